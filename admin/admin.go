@@ -0,0 +1,92 @@
+// Package admin provides operational endpoints of the server: health
+// checks and metrics.
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"pipelined.dev/phono/metrics"
+	"pipelined.dev/phono/middleware"
+)
+
+var errNotReady = errors.New("not ready")
+
+type (
+	// Health reports liveness and readiness of the server. Server is live
+	// while it's able to respond. It's ready while it accepts new requests
+	// and all checks of the backend pass.
+	Health struct {
+		ready int32
+
+		mu     sync.Mutex
+		checks []check
+	}
+
+	check struct {
+		name string
+		fn   func() error
+	}
+)
+
+// SetReady changes the readiness of the server.
+func (h *Health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+// AddCheck adds the check of backend, e.g. free disk space. Server is
+// not ready while the check returns error.
+func (h *Health) AddCheck(name string, fn func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, check{name: name, fn: fn})
+}
+
+// Ready returns true if the server accepts new requests.
+func (h *Health) Ready() bool {
+	return h.Err() == nil
+}
+
+// Err returns the reason why the server is not ready or nil if it's
+// ready.
+func (h *Health) Err() error {
+	if atomic.LoadInt32(&h.ready) != 1 {
+		return errNotReady
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range h.checks {
+		if err := c.fn(); err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// Handler returns the mux with admin endpoints:
+//
+//	/healthz - liveness check
+//	/readyz - readiness check
+//	/metrics - metrics in text or OpenMetrics format
+func Handler(h *Health) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := h.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	mux.Handle("/metrics", middleware.Compress(metricsHandler(metrics.Default)))
+	return mux
+}
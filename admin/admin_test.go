@@ -0,0 +1,58 @@
+package admin_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/admin"
+	"pipelined.dev/phono/metrics"
+)
+
+func TestHandler(t *testing.T) {
+	var health admin.Health
+	h := admin.Handler(&health)
+	get := func(path string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, get("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	health.SetReady(true)
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+	var checkErr error
+	health.AddCheck("disk", func() error { return checkErr })
+	checkErr = errors.New("insufficient storage")
+	assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	checkErr = nil
+	assert.Equal(t, http.StatusOK, get("/readyz"))
+	assert.Equal(t, http.StatusOK, get("/metrics"))
+}
+
+func TestMetrics(t *testing.T) {
+	h := admin.Handler(&admin.Health{})
+	contentType := func(accept string) string {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr.Header().Get("Content-Type")
+	}
+
+	assert.Equal(t, metrics.TextContentType, contentType(""))
+	assert.Equal(t, metrics.OpenMetricsContentType, contentType("application/openmetrics-text; version=1.0.0"))
+}
+
+func TestDebug(t *testing.T) {
+	h := admin.Debug()
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Debug returns the mux with runtime debugging endpoints:
+//
+//	/debug/pprof/ - profiles of net/http/pprof
+//	/debug/vars - variables of expvar
+//
+// Profiles expose internals of the process, so the mux must not be served
+// on public address.
+func Debug() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+
+	"pipelined.dev/phono/metrics"
+)
+
+// metricsHandler serves metrics of the registry. OpenMetrics format is
+// used if it's accepted by the client.
+func metricsHandler(r *metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		bw := bufio.NewWriter(w)
+		if strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text") {
+			w.Header().Set("Content-Type", metrics.OpenMetricsContentType)
+			r.WriteOpenMetrics(bw)
+		} else {
+			w.Header().Set("Content-Type", metrics.TextContentType)
+			r.Write(bw)
+		}
+		bw.Flush()
+	})
+}
@@ -0,0 +1,150 @@
+// Package api provides versioned routing of the http api. Version is
+// selected by the path prefix:
+//
+//	/api/v1/...
+//
+// or by the version header for paths without version:
+//
+//	/api/...
+//	Phono-API-Version: v1
+//
+// If neither is provided, the latest not deprecated version is used.
+// Deprecated versions respond with Deprecation and Sunset headers and
+// are removed after the sunset.
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pipelined.dev/phono/clock"
+)
+
+const (
+	// Prefix is the path prefix of the api.
+	Prefix = "/api/"
+	// VersionHeader is the request header to select the version of api.
+	// Response header contains the version that served the request.
+	VersionHeader = "Phono-API-Version"
+)
+
+var (
+	errVersionUnsupported = errors.New("unsupported api version")
+	errVersionMismatch    = errors.New("api version in header doesn't match the path")
+	errVersionSunset      = errors.New("api version is removed")
+)
+
+type (
+	// Version of the api.
+	Version struct {
+		// Name of the version in the path and header, e.g. v1.
+		Name    string
+		Handler http.Handler
+		// Deprecated versions are not used by default.
+		Deprecated bool
+		// Sunset is the time when deprecated version is removed. Zero
+		// means no sunset is scheduled.
+		Sunset time.Time
+		// Link to migration guide of deprecated version.
+		Link string
+	}
+
+	// Router routes requests to versions of the api. Clock can be set
+	// before the first use.
+	Router struct {
+		Clock    clock.Clock
+		versions map[string]Version
+		latest   string
+	}
+)
+
+// NewRouter returns router of provided versions. Versions must be
+// ordered from the oldest to the latest.
+func NewRouter(versions ...Version) *Router {
+	rt := Router{
+		versions: make(map[string]Version, len(versions)),
+	}
+	for _, v := range versions {
+		rt.versions[v.Name] = v
+		if !v.Deprecated {
+			rt.latest = v.Name
+		}
+	}
+	return &rt
+}
+
+// ServeHTTP passes the request to the handler of requested version. The
+// path of request is stripped of the api prefix and version.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, p := rt.route(r.URL.Path)
+	status := http.StatusNotFound
+	if header := r.Header.Get(VersionHeader); header != "" {
+		switch {
+		case name == "":
+			name, status = header, http.StatusBadRequest
+		case name != header:
+			http.Error(w, errVersionMismatch.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if name == "" {
+		name = rt.latest
+	}
+	v, ok := rt.versions[name]
+	if !ok {
+		http.Error(w, errVersionUnsupported.Error(), status)
+		return
+	}
+	if v.Deprecated {
+		if !v.Sunset.IsZero() {
+			if !clock.Or(rt.Clock).Now().Before(v.Sunset) {
+				http.Error(w, errVersionSunset.Error(), http.StatusGone)
+				return
+			}
+			w.Header().Set("Sunset", v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("Deprecation", "true")
+		if v.Link != "" {
+			w.Header().Add("Link", "<"+v.Link+`>; rel="deprecation"`)
+		}
+	}
+	w.Header().Set(VersionHeader, v.Name)
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	v.Handler.ServeHTTP(w, r2)
+}
+
+// route returns the version from the path and the path without api
+// prefix and version. Empty version is returned if path has no version.
+func (rt *Router) route(urlPath string) (string, string) {
+	rest := strings.TrimPrefix(urlPath, Prefix)
+	first := rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		first = rest[:i]
+	}
+	if _, ok := rt.versions[first]; ok || isVersion(first) {
+		return first, "/" + strings.TrimPrefix(rest[len(first):], "/")
+	}
+	return "", "/" + rest
+}
+
+// isVersion returns true if path element looks like version, e.g. v2.
+func isVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+package api_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/api"
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestRouter(t *testing.T) {
+	echo := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name+" "+r.URL.Path)
+		})
+	}
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	rt := api.NewRouter(
+		api.Version{Name: "v1", Handler: echo("v1"), Deprecated: true, Sunset: sunset, Link: "/docs/v2"},
+		api.Version{Name: "v2", Handler: echo("v2")},
+	)
+	c := clock.NewManual(sunset.Add(-time.Hour))
+	rt.Clock = c
+	request := func(path, version string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if version != "" {
+			r.Header.Set(api.VersionHeader, version)
+		}
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := request("/api/v2/uploads/id", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "v2 /uploads/id", rr.Body.String())
+	assert.Equal(t, "v2", rr.Header().Get(api.VersionHeader))
+	assert.Empty(t, rr.Header().Get("Deprecation"))
+
+	// latest not deprecated version by default
+	assert.Equal(t, "v2 /progress/id", request("/api/progress/id", "").Body.String())
+	assert.Equal(t, "v2 /", request("/api/v2", "").Body.String())
+
+	rr = request("/api/uploads/id", "v1")
+	assert.Equal(t, "v1 /uploads/id", rr.Body.String())
+	assert.Equal(t, "true", rr.Header().Get("Deprecation"))
+	assert.Equal(t, "Tue, 01 Jan 2030 00:00:00 GMT", rr.Header().Get("Sunset"))
+	assert.Equal(t, `</docs/v2>; rel="deprecation"`, rr.Header().Get("Link"))
+
+	assert.Equal(t, http.StatusBadRequest, request("/api/v1/", "v2").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/api/", "v3").Code)
+	assert.Equal(t, http.StatusNotFound, request("/api/v3/", "").Code)
+
+	c.Add(time.Hour)
+	assert.Equal(t, http.StatusGone, request("/api/v1/", "").Code)
+}
+
+// TestV1Compatibility pins the request schema of v1. If it fails, the
+// change breaks existing clients and must go to the new version.
+func TestV1Compatibility(t *testing.T) {
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	v1 := api.V1(service.Handler(service.Config{Form: form, Buffering: encode.Buffering{Size: 512}}), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	rt := api.NewRouter(api.Version{Name: "v1", Handler: v1})
+
+	for name, fields := range map[string]map[string]string{
+		"wav": {
+			"format":        ".wav",
+			"wav-bit-depth": "16",
+		},
+		"mp3 vbr": {
+			"format":            ".mp3",
+			"mp3-channel-mode":  "1",
+			"mp3-bit-rate-mode": "VBR",
+			"mp3-vbr-quality":   "2",
+			"mp3-use-quality":   "true",
+			"mp3-quality":       "5",
+		},
+		"mp3 cbr": {
+			"format":            ".mp3",
+			"mp3-channel-mode":  "2",
+			"mp3-bit-rate-mode": "CBR",
+			"mp3-bit-rate":      "320",
+		},
+	} {
+		rr := httptest.NewRecorder()
+		rt.ServeHTTP(rr, uploadRequest(t, "/api/v1/sample.wav", fields))
+		assert.Equal(t, http.StatusOK, rr.Code, name)
+		assert.Equal(t, "v1", rr.Header().Get(api.VersionHeader), name)
+		assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment", name)
+	}
+}
+
+func uploadRequest(t *testing.T, uri string, fields map[string]string) *http.Request {
+	t.Helper()
+	f, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile(userinput.FormFileKey, "sample.wav")
+	assert.NoError(t, err)
+	_, err = io.Copy(part, f)
+	assert.NoError(t, err)
+	for k, v := range fields {
+		assert.NoError(t, w.WriteField(k, v))
+	}
+	assert.NoError(t, w.Close())
+
+	r := httptest.NewRequest(http.MethodPost, uri, &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func TestOpenAPI(t *testing.T) {
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	presets, err := preset.NewStore("")
+	assert.NoError(t, err)
+	v1 := api.V1(service.Handler(service.Config{Form: form, Buffering: encode.Buffering{Size: 512}}), form.Capabilities(), nil, nil, service.NewJobs(), nil, nil, nil, presets, nil, nil)
+	rt := api.NewRouter(api.Version{Name: "v1", Handler: v1})
+
+	rr := httptest.NewRecorder()
+	rt.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api"+api.OpenAPIPath, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var doc struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+	assert.Contains(t, doc.Paths["/"], "post")
+	assert.Contains(t, doc.Paths["/jobs/{id}/pause"], "post")
+	assert.Contains(t, doc.Paths["/capabilities"], "get")
+	assert.Contains(t, doc.Paths["/presets/{name}"], "put")
+	// only routed paths are described
+	assert.NotContains(t, doc.Paths, "/files")
+	assert.Contains(t, rr.Body.String(), `".mp3"`)
+	assert.Contains(t, rr.Body.String(), `"`+userinput.ProgressIDKey+`"`)
+}
+
+func TestV1Compress(t *testing.T) {
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	v1 := api.V1(service.Handler(service.Config{Form: form, Buffering: encode.Buffering{Size: 512}}), form.Capabilities(), nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/capabilities", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	v1.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	var capabilities map[string]interface{}
+	assert.NoError(t, json.NewDecoder(gr).Decode(&capabilities))
+	assert.NotEmpty(t, capabilities)
+
+	// encoded audio is not compressed
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	v1.ServeHTTP(rr, r)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,484 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/render"
+	"pipelined.dev/phono/userinput"
+)
+
+// OpenAPIPath is the path of OpenAPI document in every version of api.
+const OpenAPIPath = "/openapi.json"
+
+// object is the node of OpenAPI document.
+type object = map[string]interface{}
+
+// errorCodes are codes of failed conversions.
+var errorCodes = []encode.ErrorCode{
+	encode.CodeCorruptHeader,
+	encode.CodeCorruptStream,
+	encode.CodeTruncatedStream,
+	encode.CodeUnsupportedSampleRate,
+	encode.CodeEncoderFailure,
+	encode.CodeStalled,
+	encode.CodeTimeout,
+	encode.CodeCanceled,
+	encode.CodeClipped,
+	encode.CodeChecksumMismatch,
+	encode.CodeUnknown,
+}
+
+// OpenAPI returns OpenAPI 3 document of routed paths of the first version.
+// Formats and their options are taken from the codec registry, so the
+// document describes sinks of external modules too.
+func OpenAPI(routed map[string]bool) []byte {
+	paths := object{}
+	add := func(route string, items object) {
+		if routed[route] {
+			for p, item := range items {
+				paths[p] = item
+			}
+		}
+	}
+	add("/", object{
+		"/": object{
+			"get": operation("Encode form", "HTML form of conversions.", nil, object{
+				"200": content("text/html", object{"type": "string"}),
+			}),
+			"post": encodeOperation(),
+		},
+	})
+	add("/capabilities", object{
+		"/capabilities": object{
+			"get": operation("Capabilities", "Inputs, output formats and ranges of options accepted by the encode form.", nil, object{
+				"200": content("application/json", ref("Capabilities")),
+			}),
+		},
+	})
+	add("/uploads/", object{
+		"/uploads/": object{
+			"post": operation("Create upload", "Creates resumable upload of "+userinput.UploadLengthHeader+" bytes. Id of the upload is used in "+userinput.UploadIDKey+" field of forms.", nil, object{
+				"201": object{"description": "Upload is created, its URL is in Location header."},
+			}),
+		},
+		"/uploads/{id}": object{
+			"parameters": []object{pathParam("id", "id of the upload")},
+			"head": operation("Upload offset", "Returns the offset of the upload in "+userinput.UploadOffsetHeader+" header.", nil, object{
+				"200": object{"description": "Current offset."},
+			}),
+			"patch": operation("Upload chunk", "Appends the chunk at "+userinput.UploadOffsetHeader+".", nil, object{
+				"204": object{"description": "Chunk is appended."},
+			}),
+		},
+	})
+	add("/progress/", object{
+		"/progress/{id}": object{
+			"parameters": []object{pathParam("id", "progress id provided in "+userinput.ProgressIDKey+" field")},
+			"get": operation("Conversion progress", "Streams progress events of the conversion as server-sent events until it's done.", nil, object{
+				"200": content("text/event-stream", ref("ProgressEvent")),
+			}),
+		},
+	})
+	add("/jobs/", object{
+		"/jobs/{id}": object{
+			"parameters": []object{pathParam("id", "id of running conversion")},
+			"get":        operation("Job state", "State of running conversion.", nil, jobResponses()),
+		},
+		"/jobs/{id}/pause": object{
+			"parameters": []object{pathParam("id", "id of running conversion")},
+			"post":       operation("Pause job", "Holds the conversion before the next buffer.", nil, jobResponses()),
+		},
+		"/jobs/{id}/resume": object{
+			"parameters": []object{pathParam("id", "id of running conversion")},
+			"post":       operation("Resume job", "Releases paused conversion.", nil, jobResponses()),
+		},
+	})
+	add("/events", object{
+		"/events": object{
+			"get": operation("Job events", "Streams events of all jobs of the caller as server-sent events, event names are types of events.", nil, object{
+				"200": content("text/event-stream", ref("JobEvent")),
+			}),
+		},
+	})
+	add("/results/", object{
+		"/results/{name}": object{
+			"parameters": []object{pathParam("name", "signed name of the result from the result link")},
+			"get": operation("Download result", "Sends the result by its link until the link expires.", nil, object{
+				"200": content("application/octet-stream", object{"type": "string", "format": "binary"}),
+				"404": object{"description": "Result is not found or expired."},
+			}),
+		},
+	})
+	add("/files/", object{
+		"/files": object{
+			"get": operation("List files", "Retained results of the owner.", nil, object{
+				"200": content("application/json", object{"type": "array", "items": ref("File")}),
+			}),
+		},
+		"/files/{id}": object{
+			"parameters": []object{pathParam("id", "id of retained result")},
+			"get": operation("Download file", "Sends retained result, range requests are supported.", nil, object{
+				"200": content("application/octet-stream", object{"type": "string", "format": "binary"}),
+				"404": object{"description": "File is not found."},
+			}),
+			"delete": operation("Delete file", "Deletes retained result.", nil, object{
+				"204": object{"description": "File is deleted."},
+				"404": object{"description": "File is not found."},
+			}),
+		},
+	})
+	add("/presets/", object{
+		"/presets": object{
+			"get": operation("List presets", "Encode presets of the caller, presets of callers without API key are kept in "+preset.Cookie+" cookie.", nil, object{
+				"200": content("application/json", object{"type": "array", "items": ref("Preset")}),
+			}),
+		},
+		"/presets/{name}": object{
+			"parameters": []object{pathParam("name", "name of the preset")},
+			"get": operation("Get preset", "Sends the preset.", nil, object{
+				"200": content("application/json", ref("Preset")),
+				"404": object{"description": "Preset is not found."},
+			}),
+			"put": operation("Save preset", "Saves encode form values as the preset, values of the single request, e.g. "+userinput.ProgressIDKey+", are omitted.", object{
+				"required": true,
+				"content": object{
+					"application/json": object{"schema": ref("FormValues")},
+				},
+			}, object{
+				"200": content("application/json", ref("Preset")),
+				"413": object{"description": "Too many presets."},
+			}),
+			"delete": operation("Delete preset", "Deletes the preset.", nil, object{
+				"204": object{"description": "Preset is deleted."},
+				"404": object{"description": "Preset is not found."},
+			}),
+		},
+	})
+	add("/waveform/", object{
+		"/waveform/": object{
+			"post": renderOperation("Render waveform", "Renders the waveform image of the input.", renderFields(false)),
+		},
+	})
+	add("/spectrogram/", object{
+		"/spectrogram/": object{
+			"post": renderOperation("Render spectrogram", "Renders the spectrogram image of the input.", renderFields(true)),
+		},
+	})
+	b, _ := json.MarshalIndent(object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "phono",
+			"version":     "v1",
+			"description": "Audio conversion api. Paths are served with " + Prefix + "v1 prefix or with " + VersionHeader + " header.",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": schemas(),
+		},
+	}, "", "  ")
+	return b
+}
+
+// openAPIHandler serves the document of routed paths. It's generated once,
+// since codecs are registered before the server is started.
+func openAPIHandler(routed map[string]bool) http.Handler {
+	var (
+		once sync.Once
+		doc  []byte
+	)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		once.Do(func() {
+			doc = OpenAPI(routed)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+}
+
+func encodeOperation() object {
+	fields := inputFields()
+	fields[userinput.FormatKey] = object{
+		"type":        "array",
+		"items":       object{"type": "string", "enum": formats()},
+		"description": "output format, multiple formats are encoded in a single pass and sent in zip archive",
+	}
+	options := object{
+		userinput.ProgressIDKey:      stringField("id to publish progress and control the job"),
+		userinput.LinkKey:            boolField("return the link to the result instead of the file"),
+		userinput.CallbackURLKey:     stringField("http or https URL that receives the result of conversion"),
+		userinput.DestinationKey:     stringField("remote location the result is uploaded to"),
+		userinput.ProcessorKey:       object{"type": "array", "items": object{"type": "string"}, "description": "processor spec name[:key=value[,key=value]]"},
+		userinput.ProcessorsKey:      stringField(`JSON list of processors, e.g. [{"name":"gain","params":{"db":-3}}]`),
+		userinput.EQKey:              stringField("equalizer bands applied before processors, e.g. highpass:80,peak:3000:-4:1.0"),
+		userinput.PeakNormalizeKey:   numberField("peak normalization target in dBFS"),
+		userinput.LoudnessKey:        numberField("loudness normalization target in LUFS"),
+		userinput.TruePeakKey:        numberField("true peak ceiling of loudness normalization in dBTP"),
+		userinput.TempoKey:           numberField("tempo factor"),
+		userinput.PitchKey:           numberField("pitch shift in semitones"),
+		userinput.ResampleQualityKey: stringField("resample quality of pitch shift"),
+		userinput.DetectClippingKey:  boolField("report clipped regions of the output"),
+		userinput.FailOnClippingKey:  boolField("fail the conversion if the output is clipped"),
+		userinput.StripTagsKey:       boolField("don't copy tags of the input"),
+		userinput.TitleKey:           stringField("title tag"),
+		userinput.ArtistKey:          stringField("artist tag"),
+		userinput.AlbumKey:           stringField("album tag"),
+		"wav-bit-depth":              object{"type": "integer", "enum": wavBitDepths(), "description": "bit depth of wav output"},
+		"mp3-bit-rate-mode":          object{"type": "string", "enum": []string{userinput.MP3.VBR, userinput.MP3.CBR, userinput.MP3.ABR}, "description": "bit rate mode of mp3 output"},
+		"mp3-vbr-quality":            object{"type": "integer", "minimum": userinput.MP3.MinVBR, "maximum": userinput.MP3.MaxVBR, "description": "VBR quality of mp3 output"},
+		"mp3-bit-rate":               object{"type": "integer", "minimum": userinput.MP3.MinBitRate, "maximum": userinput.MP3.MaxBitRate, "description": "CBR and ABR bit rate of mp3 output"},
+		"mp3-channel-mode":           object{"type": "integer", "enum": []int{0, 1, 2}, "description": "0 - mono, 1 - stereo, 2 - joint stereo"},
+		"mp3-use-quality":            boolField("use mp3-quality"),
+		"mp3-quality":                object{"type": "integer", "minimum": userinput.MP3.MinQuality, "maximum": userinput.MP3.MaxQuality, "description": "encoding quality of mp3 output"},
+	}
+	for k, v := range options {
+		fields[k] = v
+	}
+	// options of registered sinks are prefixed with their extensions
+	for _, s := range codec.Sinks() {
+		if s.Name == "wav" || s.Name == "mp3" {
+			continue
+		}
+		prefix := strings.TrimPrefix(s.Extension, ".") + "-"
+		for _, o := range s.Options {
+			field := stringField(s.Name + " " + o.Description)
+			if len(o.Values) > 0 {
+				field["enum"] = o.Values
+			}
+			if o.Default != "" {
+				field["default"] = o.Default
+			}
+			fields[prefix+o.Name] = field
+		}
+	}
+	return operation("Encode", "Converts the input into output formats. The result is sent in the response, as the link or uploaded to the destination.", form(fields), object{
+		"200": object{
+			"description": "The result file or zip archive of multiple outputs.",
+			"headers": object{
+				encode.ClippingHeader: header("number of clipped regions if clipping is detected"),
+				service.JobIDHeader:   header("id of the job with callback"),
+			},
+			"content": object{"application/octet-stream": object{"schema": object{"type": "string", "format": "binary"}}},
+		},
+		"201": content("application/json", ref("Result")),
+		"400": errorResponse("Invalid form or input."),
+		"422": errorResponse("Output is clipped."),
+		"499": errorResponse("Client closed the request."),
+		"502": errorResponse("Result is not uploaded to the destination."),
+		"504": errorResponse("Conversion stalled or timed out."),
+	})
+}
+
+func renderOperation(summary, description string, fields object) object {
+	return operation(summary, description, form(fields), object{
+		"200": object{
+			"description": "Rendered image.",
+			"content": object{
+				"image/png":     object{"schema": object{"type": "string", "format": "binary"}},
+				"image/svg+xml": object{"schema": object{"type": "string"}},
+			},
+		},
+		"400": errorResponse("Invalid form or input."),
+		"504": errorResponse("Rendering stalled or timed out."),
+	})
+}
+
+// inputFields are fields that provide the input of forms.
+func inputFields() object {
+	return object{
+		userinput.FormFileKey:  object{"type": "string", "format": "binary", "description": "input file"},
+		userinput.UploadIDKey:  stringField("id of finished resumable upload used instead of the file"),
+		userinput.SourceURLKey: stringField("URL of the input used instead of the file"),
+	}
+}
+
+func renderFields(spectrogram bool) object {
+	fields := inputFields()
+	fields[userinput.WidthKey] = object{"type": "integer", "default": userinput.DefaultWidth, "description": "image width in pixels"}
+	fields[userinput.HeightKey] = object{"type": "integer", "default": userinput.DefaultHeight, "description": "image height in pixels"}
+	if spectrogram {
+		fields[userinput.FFTSizeKey] = object{"type": "integer", "description": "FFT size"}
+		fields[userinput.WindowKey] = stringField("window function")
+		fields[userinput.ColorMapKey] = stringField("color map")
+	} else {
+		fields[userinput.ImageFormatKey] = object{"type": "string", "enum": []render.Format{render.PNG, render.SVG}, "default": render.PNG}
+	}
+	return fields
+}
+
+func jobResponses() object {
+	return object{
+		"200": content("application/json", ref("Job")),
+		"404": object{"description": "Job is not running."},
+	}
+}
+
+func schemas() object {
+	codes := make([]string, 0, len(errorCodes))
+	for _, c := range errorCodes {
+		codes = append(codes, string(c))
+	}
+	return object{
+		"ErrorCode": object{"type": "string", "enum": codes},
+		"ProgressEvent": properties(object{
+			"frames": object{"type": "integer"},
+			"read":   object{"type": "integer"},
+			"size":   object{"type": "integer"},
+			"done":   object{"type": "boolean"},
+			"error":  object{"type": "string"},
+			"code":   ref("ErrorCode"),
+		}),
+		"JobEvent": properties(object{
+			"type":   object{"type": "string", "enum": []string{service.JobCreated, service.JobProgress, service.JobCompleted, service.JobFailed}},
+			"id":     object{"type": "string"},
+			"time":   object{"type": "string", "format": "date-time"},
+			"frames": object{"type": "integer"},
+			"error":  object{"type": "string"},
+			"code":   ref("ErrorCode"),
+		}),
+		"Job": properties(object{
+			"id":     object{"type": "string"},
+			"paused": object{"type": "boolean"},
+		}),
+		"Result": properties(object{
+			"url":      object{"type": "string"},
+			"location": object{"type": "string"},
+			"expires":  object{"type": "string", "format": "date-time"},
+			"clipping": object{"type": "object"},
+		}),
+		"Capabilities": properties(object{
+			"inputs":         object{"type": "array", "items": object{"type": "string"}},
+			"max_sizes":      object{"type": "object", "additionalProperties": object{"type": "integer"}},
+			"source_url":     object{"type": "boolean"},
+			"links":          object{"type": "boolean"},
+			"max_tag_length": object{"type": "integer"},
+			"fields":         object{"type": "array", "items": ref("Field")},
+			"outputs": object{"type": "array", "items": properties(object{
+				"extension":   object{"type": "string"},
+				"description": object{"type": "string"},
+				"fields":      object{"type": "array", "items": ref("Field")},
+			})},
+		}),
+		"Field": properties(object{
+			"name":        object{"type": "string"},
+			"description": object{"type": "string"},
+			"default":     object{"type": "string"},
+			"values":      object{"type": "array", "items": object{"type": "string"}},
+			"min":         object{"type": "number"},
+			"max":         object{"type": "number"},
+		}),
+		"FormValues": object{
+			"type":                 "object",
+			"description":          "values of encode form fields",
+			"additionalProperties": object{"type": "array", "items": object{"type": "string"}},
+		},
+		"Preset": properties(object{
+			"name":   object{"type": "string"},
+			"values": ref("FormValues"),
+		}),
+		"File": properties(object{
+			"id":      object{"type": "string"},
+			"name":    object{"type": "string"},
+			"size":    object{"type": "integer"},
+			"url":     object{"type": "string"},
+			"created": object{"type": "string", "format": "date-time"},
+			"expires": object{"type": "string", "format": "date-time"},
+		}),
+	}
+}
+
+func wavBitDepths() []int {
+	result := make([]int, 0, len(userinput.WAV.BitDepths))
+	for bd := range userinput.WAV.BitDepths {
+		result = append(result, int(bd))
+	}
+	sort.Ints(result)
+	return result
+}
+
+// formats returns extensions of registered sinks.
+func formats() []string {
+	sinks := codec.Sinks()
+	result := make([]string, 0, len(sinks))
+	for _, s := range sinks {
+		result = append(result, s.Extension)
+	}
+	return result
+}
+
+func operation(summary, description string, body, responses object) object {
+	op := object{
+		"summary":     summary,
+		"description": description,
+		"responses":   responses,
+	}
+	if body != nil {
+		op["requestBody"] = body
+	}
+	return op
+}
+
+func form(fields object) object {
+	return object{
+		"required": true,
+		"content": object{
+			"multipart/form-data": object{"schema": properties(fields)},
+		},
+	}
+}
+
+func content(mediaType string, schema object) object {
+	return object{
+		"description": "Success.",
+		"content":     object{mediaType: object{"schema": schema}},
+	}
+}
+
+func errorResponse(description string) object {
+	return object{
+		"description": description,
+		"headers": object{
+			encode.ErrorCodeHeader: object{"schema": ref("ErrorCode")},
+		},
+		"content": object{"text/plain": object{"schema": object{"type": "string"}}},
+	}
+}
+
+func properties(props object) object {
+	return object{"type": "object", "properties": props}
+}
+
+func pathParam(name, description string) object {
+	return object{"name": name, "in": "path", "required": true, "description": description, "schema": object{"type": "string"}}
+}
+
+func header(description string) object {
+	return object{"description": description, "schema": object{"type": "string"}}
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func stringField(description string) object {
+	return object{"type": "string", "description": description}
+}
+
+func numberField(description string) object {
+	return object{"type": "number", "description": description}
+}
+
+func boolField(description string) object {
+	return object{"type": "boolean", "description": description}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+
+	"pipelined.dev/phono/middleware"
+)
+
+// compressed are paths of JSON responses, they are compressed if client
+// accepts it. Audio, images and downloads of retained files are already
+// compressed.
+var compressed = map[string]bool{
+	"/capabilities": true,
+	"/jobs/":        true,
+	"/files":        true,
+	"/presets":      true,
+	"/presets/":     true,
+	OpenAPIPath:     true,
+}
+
+// V1 returns the handler of the first version of api:
+//
+//	/ - encode form and conversions
+//	/capabilities - inputs and options accepted by the encode form
+//	/uploads/ - resumable uploads
+//	/progress/ - progress of conversions
+//	/jobs/ - pause and resume of conversions
+//	/events - events of all jobs of the caller
+//	/results/ - results by links
+//	/files/ - retained results
+//	/presets/ - encode presets of the caller
+//	/waveform/ - waveform images
+//	/spectrogram/ - spectrogram images
+//	/openapi.json - OpenAPI document of routed paths
+//
+// Nil handlers are not routed. JSON responses are compressed.
+func V1(encode, capabilities, uploads, progress, jobs, events, results, files, presets, waveform, spectrogram http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	routed := make(map[string]bool)
+	for p, h := range map[string]http.Handler{
+		"/":             encode,
+		"/capabilities": capabilities,
+		"/uploads/":     uploads,
+		"/progress/":    progress,
+		"/jobs/":        jobs,
+		"/events":       events,
+		"/results/":     results,
+		"/files":        files,
+		"/files/":       files,
+		"/presets":      presets,
+		"/presets/":     presets,
+		"/waveform/":    waveform,
+		"/spectrogram/": spectrogram,
+	} {
+		if h != nil {
+			if compressed[p] {
+				h = middleware.Compress(h)
+			}
+			mux.Handle(p, h)
+			routed[p] = true
+		}
+	}
+	mux.Handle(OpenAPIPath, middleware.Compress(openAPIHandler(routed)))
+	return mux
+}
@@ -0,0 +1,100 @@
+// Package bufpool reuses buffers across conversions, so bursts of requests
+// don't allocate new buffers for every conversion. Buffers are grouped in
+// size classes of powers of two, so buffers of close sizes are shared.
+package bufpool
+
+import (
+	"strconv"
+	"sync"
+
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/metrics"
+)
+
+// minClass is the smallest size class, smaller buffers are rounded up.
+const minClass = 512
+
+// Kinds of buffers in metrics.
+const (
+	kindBytes    = "bytes"
+	kindFloating = "floating"
+)
+
+var (
+	bytePools = struct {
+		sync.Mutex
+		classes map[int]*sync.Pool
+	}{classes: make(map[int]*sync.Pool)}
+
+	bufferGets = metrics.NewCounter(
+		"phono_buffer_pool_gets_total",
+		"Number of buffers taken from the pool.",
+		"kind", "class",
+	)
+	bufferAllocations = metrics.NewCounter(
+		"phono_buffer_pool_allocations_total",
+		"Number of buffers allocated because the pool was empty.",
+		"kind", "class",
+	)
+)
+
+// Class returns the size class of the buffer size.
+func Class(size int) int {
+	class := minClass
+	for class < size {
+		class <<= 1
+	}
+	return class
+}
+
+// Bytes returns the byte buffer of the size. Its capacity is the size
+// class. Content of the buffer is undefined.
+func Bytes(size int) []byte {
+	class := Class(size)
+	label := strconv.Itoa(class)
+	bufferGets.Inc(kindBytes, label)
+	if b, ok := bytesPool(class).Get().(*[]byte); ok {
+		return (*b)[:size]
+	}
+	bufferAllocations.Inc(kindBytes, label)
+	return make([]byte, size, class)
+}
+
+// PutBytes returns the buffer to the pool. Buffers that weren't returned
+// by Bytes are dropped.
+func PutBytes(b []byte) {
+	class := cap(b)
+	if class < minClass || class != Class(class) {
+		return
+	}
+	b = b[:0]
+	bytesPool(class).Put(&b)
+}
+
+// bytesPool returns the pool of the size class.
+func bytesPool(class int) *sync.Pool {
+	bytePools.Lock()
+	defer bytePools.Unlock()
+	p, ok := bytePools.classes[class]
+	if !ok {
+		p = &sync.Pool{}
+		bytePools.classes[class] = p
+	}
+	return p
+}
+
+// Floating returns the signal buffer of the length in frames. Its
+// capacity is the size class of the length. Buffers are shared with
+// pipes of the same size, the content is zeroed.
+func Floating(channels, length int) signal.Floating {
+	class := Class(length)
+	bufferGets.Inc(kindFloating, strconv.Itoa(class))
+	return signal.GetPoolAllocator(channels, length, class).Float64()
+}
+
+// PutFloating returns the signal buffer to the pool. The buffer must be
+// returned by Floating and must not be used after it's returned.
+func PutFloating(s signal.Floating) {
+	s.Free(signal.GetPoolAllocator(s.Channels(), 0, s.Cap()/s.Channels()))
+}
@@ -0,0 +1,47 @@
+package bufpool_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/bufpool"
+)
+
+func TestClass(t *testing.T) {
+	for size, class := range map[int]int{
+		0:    512,
+		512:  512,
+		513:  1024,
+		1152: 2048,
+		8192: 8192,
+	} {
+		assert.Equal(t, class, bufpool.Class(size), size)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	b := bufpool.Bytes(1000)
+	assert.Len(t, b, 1000)
+	assert.Equal(t, 1024, cap(b))
+	bufpool.PutBytes(b)
+	// buffers of other sizes are dropped
+	bufpool.PutBytes(make([]byte, 1000))
+
+	b = bufpool.Bytes(600)
+	assert.Len(t, b, 600)
+	assert.Equal(t, 1024, cap(b))
+}
+
+func TestFloating(t *testing.T) {
+	s := bufpool.Floating(2, 1152)
+	assert.Equal(t, 2, s.Channels())
+	assert.Equal(t, 1152, s.Length())
+	assert.Equal(t, 2*2048, s.Cap())
+	s.SetSample(0, 1)
+	bufpool.PutFloating(s)
+
+	s = bufpool.Floating(2, 1000)
+	assert.Equal(t, 1000, s.Length())
+	assert.Equal(t, 0.0, s.Sample(0))
+}
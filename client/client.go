@@ -0,0 +1,170 @@
+// Package client provides Go client of phono http api. It's used by
+// programs that convert files on the phono server instead of encoding
+// them locally:
+//
+//	c, err := client.New("https://phono.internal", key)
+//	form := url.Values{userinput.FormatKey: {"mp3"}, ...}
+//	err = c.Encode(ctx, "track.wav", in, form, out)
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"pipelined.dev/phono/api"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/userinput"
+)
+
+// maxErrorSize is the max size of the error message read from the
+// response.
+const maxErrorSize = 1 << 12
+
+type (
+	// Client sends requests to the api of the phono server at URL. If
+	// APIKey is not empty, it's sent as the bearer token. HTTPClient
+	// can be replaced before the first request.
+	Client struct {
+		URL        *url.URL
+		APIKey     string
+		HTTPClient *http.Client
+	}
+
+	// Error is returned if the server rejects the request. Code is the
+	// code of conversion error, it's empty if the request failed before
+	// the conversion.
+	Error struct {
+		StatusCode int
+		Code       encode.ErrorCode
+		Message    string
+	}
+)
+
+// New returns the client of the server at raw url.
+func New(rawURL, apiKey string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid server url: %s", rawURL)
+	}
+	return &Client{
+		URL:        u,
+		APIKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// Encode uploads the input and writes the result of conversion into w.
+// The format of the input is detected by the extension of name. Form
+// contains the output format and its options with keys of the encode
+// form, see userinput package. The input is streamed, so it's read only
+// once.
+func (c *Client) Encode(ctx context.Context, name string, input io.Reader, form url.Values, w io.Writer) error {
+	body, contentType := multipartBody(name, input, form)
+	defer body.Close()
+	endpoint := *c.URL
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + api.Prefix + "v1/" + path.Base(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to receive result: %w", err)
+	}
+	return nil
+}
+
+// Preset returns the preset of the name saved on the server by the owner
+// of the api key.
+func (c *Client) Preset(ctx context.Context, name string) (preset.Preset, error) {
+	endpoint := *c.URL
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + api.Prefix + "v1/presets/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return preset.Preset{}, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return preset.Preset{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preset.Preset{}, responseError(resp)
+	}
+	var p preset.Preset
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return preset.Preset{}, fmt.Errorf("failed to receive preset: %w", err)
+	}
+	return p, nil
+}
+
+// multipartBody returns the body of the encode form. Values are written
+// before the file, so the server validates them before the upload is
+// done.
+func multipartBody(name string, input io.Reader, form url.Values) (io.ReadCloser, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(func() error {
+			for key, values := range form {
+				for _, v := range values {
+					if err := mw.WriteField(key, v); err != nil {
+						return err
+					}
+				}
+			}
+			part, err := mw.CreateFormFile(userinput.FormFileKey, path.Base(name))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, input); err != nil {
+				return err
+			}
+			return mw.Close()
+		}())
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+// responseError returns the error of rejected request.
+func responseError(resp *http.Response) error {
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+	if err != nil {
+		return fmt.Errorf("failed to read error: %w", err)
+	}
+	return &Error{
+		StatusCode: resp.StatusCode,
+		Code:       encode.ErrorCode(resp.Header.Get(encode.ErrorCodeHeader)),
+		Message:    strings.TrimSpace(string(msg)),
+	}
+}
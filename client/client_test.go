@@ -0,0 +1,99 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/api"
+	"pipelined.dev/phono/client"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestEncode(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	v1 := api.V1(h, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		api.NewRouter(api.Version{Name: "v1", Handler: v1}).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	c, err := client.New(server.URL, "secret")
+	assert.NoError(t, err)
+
+	in, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer in.Close()
+	var out bytes.Buffer
+	form := url.Values{
+		userinput.FormatKey: {".wav"},
+		"wav-bit-depth":     {"16"},
+	}
+	err = c.Encode(context.Background(), "sample.wav", in, form, &out)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("RIFF")))
+	assert.Equal(t, "Bearer secret", auth)
+
+	// rejected conversions return the error
+	in, err = os.Open("../_testdata/not-media")
+	assert.NoError(t, err)
+	defer in.Close()
+	err = c.Encode(context.Background(), "not-media.wav", in, form, &out)
+	var clientErr *client.Error
+	assert.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, http.StatusBadRequest, clientErr.StatusCode)
+	assert.NotEmpty(t, clientErr.Code)
+
+	_, err = client.New("ftp://phono.internal", "")
+	assert.Error(t, err)
+}
+
+func TestPreset(t *testing.T) {
+	presets, err := preset.NewStore("")
+	assert.NoError(t, err)
+	presets.Owner = func(r *http.Request) string {
+		return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	v1 := api.V1(nil, nil, nil, nil, nil, nil, nil, nil, presets, nil, nil)
+	server := httptest.NewServer(api.NewRouter(api.Version{Name: "v1", Handler: v1}))
+	defer server.Close()
+
+	values := url.Values{userinput.FormatKey: {".mp3"}, "mp3-bit-rate-mode": {"VBR"}}
+	p, err := preset.New("mp3 v0", values)
+	assert.NoError(t, err)
+	body := strings.NewReader(`{"format":[".mp3"],"mp3-bit-rate-mode":["VBR"]}`)
+	req, err := http.NewRequest(http.MethodPut, server.URL+api.Prefix+"v1/presets/mp3%20v0", body)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	c, err := client.New(server.URL, "secret")
+	assert.NoError(t, err)
+	result, err := c.Preset(context.Background(), "mp3 v0")
+	assert.NoError(t, err)
+	assert.Equal(t, p, result)
+
+	// presets of other keys are not found
+	c, err = client.New(server.URL, "other")
+	assert.NoError(t, err)
+	_, err = c.Preset(context.Background(), "mp3 v0")
+	var clientErr *client.Error
+	assert.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, http.StatusNotFound, clientErr.StatusCode)
+}
@@ -0,0 +1,56 @@
+// Package clock provides time source that can be replaced in tests.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Clock provides current time.
+	Clock interface {
+		Now() time.Time
+	}
+
+	// System is the clock backed by time.Now.
+	System struct{}
+
+	// Manual is the clock that is moved manually. It's safe for
+	// concurrent use.
+	Manual struct {
+		mu  sync.Mutex
+		now time.Time
+	}
+)
+
+// Now returns current system time.
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// NewManual returns manual clock set to provided time.
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+// Now returns current time of the clock.
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+// Add moves the clock forward.
+func (m *Manual) Add(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.now = m.now.Add(d)
+}
+
+// Or returns c if it's not nil and system clock otherwise.
+func Or(c Clock) Clock {
+	if c == nil {
+		return System{}
+	}
+	return c
+}
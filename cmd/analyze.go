@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+)
+
+var (
+	analyze = struct {
+		json             bool
+		tempoKey         bool
+		silence          bool
+		silenceThreshold dbfsFlag
+		silenceMin       time.Duration
+		bufferSize       int
+		latency          string
+		stallTimeout     time.Duration
+	}{
+		silenceThreshold: -60,
+	}
+	analyzeCmd = &cobra.Command{
+		Use:                   "analyze [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Report loudness and peaks of audio files",
+		Long: "Report integrated loudness, loudness range and true peak of audio files according to\n" +
+			"EBU R128, sample peak and RMS of every channel. Tempo in BPM and musical key are\n" +
+			"estimated if enabled. Silent regions are listed if silence detection is enabled.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, analyze.bufferSize, analyze.latency)
+			if err != nil {
+				return err
+			}
+			if err := encode.CheckSilenceThreshold(float64(analyze.silenceThreshold), analyze.silenceMin); err != nil {
+				return err
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			reports := make([]analysisReport, 0, len(args))
+			for _, path := range args {
+				r, err := analyzeFile(ctx, path, b, analyze.stallTimeout)
+				if err != nil {
+					return err
+				}
+				reports = append(reports, r)
+			}
+			if analyze.json {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(reports)
+			}
+			for _, r := range reports {
+				if err := r.print(os.Stdout); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+)
+
+// analysisReport is the analysis of the file. Tempo and key are provided
+// if their estimation is enabled. Silences are provided if silence
+// detection is enabled.
+type analysisReport struct {
+	Path string `json:"path"`
+	encode.Analysis
+	*encode.TempoKey
+	Silences []encode.Silence `json:"silences,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(analyzeCmd)
+	analyzeCmd.Flags().BoolVar(&analyze.json, "json", false, "print report in JSON format")
+	analyzeCmd.Flags().BoolVar(&analyze.tempoKey, "tempo-key", false, "estimate tempo in BPM and musical key")
+	analyzeCmd.Flags().BoolVar(&analyze.silence, "silence", false, "list silent regions")
+	analyzeCmd.Flags().Var(&analyze.silenceThreshold, "silence-threshold", "level of silence, e.g. -60dBFS")
+	analyzeCmd.Flags().DurationVar(&analyze.silenceMin, "silence-min", 500*time.Millisecond, "list only silence that lasts at least this long")
+	bufferSizeVar(analyzeCmd.Flags(), &analyze.bufferSize)
+	analyzeCmd.Flags().StringVar(&analyze.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	analyzeCmd.Flags().DurationVar(&analyze.stallTimeout, "stall-timeout", time.Minute, "cancel analysis if it makes no progress, disabled if zero")
+	analyzeCmd.Flags().SortFlags = false
+}
+
+// analyzeFile decodes the file with the analyzer sink. Tempo and key
+// detector and silence detector are added if they are enabled.
+func analyzeFile(ctx context.Context, path string, b encode.Buffering, stallTimeout time.Duration) (analysisReport, error) {
+	in, format, closeFn, err := openAudio(path)
+	if err != nil {
+		return analysisReport{}, err
+	}
+	defer closeFn()
+	analyzer := encode.NewAnalyzer()
+	sink := analyzer.Sink(nil)
+	var tempoKey *encode.TempoKeyDetector
+	if analyze.tempoKey {
+		tempoKey = encode.NewTempoKeyDetector()
+		sink = tempoKey.Sink(sink)
+	}
+	var detector *encode.SilenceDetector
+	if analyze.silence {
+		if detector, err = encode.NewSilenceDetector(float64(analyze.silenceThreshold), analyze.silenceMin); err != nil {
+			return analysisReport{}, err
+		}
+		sink = detector.Sink(sink)
+	}
+	if err := encode.Run(ctx, b.BufferSize(format, nil), stallTimeout, format.Source(in), sink); err != nil {
+		return analysisReport{}, fmt.Errorf("failed to analyze %s: %s: %v", path, encode.Code(err), err)
+	}
+	r := analysisReport{
+		Path:     path,
+		Analysis: analyzer.Analysis(),
+	}
+	if tempoKey != nil {
+		tk := tempoKey.TempoKey()
+		r.TempoKey = &tk
+	}
+	if detector != nil {
+		r.Silences = detector.Silences()
+	}
+	return r, nil
+}
+
+// print writes the report as a table.
+func (r analysisReport) print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", r.Path)
+	fmt.Fprintf(w, "duration\t%.2fs\n", r.Duration)
+	fmt.Fprintf(w, "integrated\t%v LUFS\n", r.Integrated)
+	fmt.Fprintf(w, "loudness range\t%v LU\n", r.LoudnessRange)
+	fmt.Fprintf(w, "true peak\t%v dBTP\n", r.TruePeak)
+	fmt.Fprintf(w, "sample peak\t%v dBFS\n", r.SamplePeak)
+	if r.TempoKey != nil {
+		tempo, key := "unknown", "unknown"
+		if r.Tempo > 0 {
+			tempo = fmt.Sprintf("%.1f BPM", r.Tempo)
+		}
+		if r.Key != "" {
+			key = r.Key
+		}
+		fmt.Fprintf(w, "tempo\t%s\n", tempo)
+		fmt.Fprintf(w, "key\t%s\n", key)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CHANNEL\tTRUE PEAK\tSAMPLE PEAK\tRMS")
+	for i, c := range r.Channels {
+		fmt.Fprintf(w, "%d\t%v dBTP\t%v dBFS\t%v dBFS\n", i+1, c.TruePeak, c.SamplePeak, c.RMS)
+	}
+	fmt.Fprintln(w)
+	if len(r.Silences) > 0 {
+		fmt.Fprintln(w, "SILENCE\tSTART\tEND\tDURATION")
+		for i, s := range r.Silences {
+			fmt.Fprintf(w, "%d\t%.3fs\t%.3fs\t%.3fs\n", i+1, s.Start, s.End, s.Duration)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
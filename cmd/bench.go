@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+var (
+	bench = struct {
+		input       string
+		duration    time.Duration
+		sampleRate  int
+		channels    int
+		formats     []string
+		bufferSizes []string
+		workers     []int
+		runs        int
+		bitDepth    int
+		channelMode int
+		bitRateMode string
+		bitRate     int
+		json        bool
+	}{}
+	benchCmd = &cobra.Command{
+		Use:                   "bench [flags]",
+		DisableFlagsInUseLine: true,
+		Short:                 "Measure encoding throughput",
+		Long: "Encode the synthesized signal or the input file with every combination of formats,\n" +
+			"buffer sizes and worker counts and print the throughput of each configuration in\n" +
+			"multiples of realtime. Workers encode the signal concurrently, the total throughput\n" +
+			"is the audio encoded by all of them per second. Outputs are discarded, the median\n" +
+			"of runs is reported.",
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if bench.runs < 1 {
+				return fmt.Errorf("invalid number of runs: %d", bench.runs)
+			}
+			source, err := benchSource()
+			if err != nil {
+				return err
+			}
+			outputs, err := benchOutputs()
+			if err != nil {
+				return err
+			}
+			sizes := make([]int, 0, len(bench.bufferSizes))
+			for _, v := range bench.bufferSizes {
+				size, err := parseBufferSize(v)
+				if err != nil {
+					return err
+				}
+				sizes = append(sizes, size)
+			}
+			for _, workers := range bench.workers {
+				if workers < 1 {
+					return fmt.Errorf("invalid number of workers: %d", workers)
+				}
+			}
+
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			var results []benchResult
+			for _, out := range outputs {
+				for _, size := range sizes {
+					for _, workers := range bench.workers {
+						r, err := benchConfig(ctx, source, out, size, workers)
+						if err != nil {
+							return fmt.Errorf("%s buffer %s workers %d: %s: %w", out.name, r.BufferSize, workers, encode.Code(err), err)
+						}
+						if !bench.json {
+							fmt.Printf("%s buffer %s workers %d: %.1fx realtime\n", out.name, r.BufferSize, workers, r.Realtime)
+						}
+						results = append(results, r)
+					}
+				}
+			}
+			if bench.json {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(results)
+			}
+			fmt.Println()
+			return printBench(results)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().StringVar(&bench.input, "input", "", "audio file to encode instead of the synthesized signal")
+	benchCmd.Flags().DurationVar(&bench.duration, "duration", 30*time.Second, "duration of the synthesized signal")
+	benchCmd.Flags().IntVar(&bench.sampleRate, "samplerate", 44100, "sample rate of the synthesized signal")
+	benchCmd.Flags().IntVar(&bench.channels, "channels", 2, "number of channels of the synthesized signal")
+	benchCmd.Flags().StringSliceVar(&bench.formats, "formats", []string{"wav", "mp3"}, "output formats:\nwav\nmp3")
+	benchCmd.Flags().StringSliceVar(&bench.bufferSizes, "buffersizes", []string{"512", "1152", "4608", "16384", "auto"}, "buffer sizes, auto picks the size from the input")
+	benchCmd.Flags().IntSliceVar(&bench.workers, "workers", []int{1, 2, 4}, "numbers of concurrent workers")
+	benchCmd.Flags().IntVar(&bench.runs, "runs", 3, "number of runs of every configuration")
+	benchCmd.Flags().IntVar(&bench.bitDepth, "bitdepth", 16, "bit depth of wav output")
+	benchCmd.Flags().IntVar(&bench.channelMode, "channelmode", 2, "channel mode of mp3 output:\n0 - mono\n1 - stereo\n2 - joint stereo")
+	benchCmd.Flags().StringVar(&bench.bitRateMode, "bitratemode", "vbr", "bit rate mode of mp3 output:\ncbr - constant bit rate\nabr - average bit rate\nvbr - variable bit rate")
+	benchCmd.Flags().IntVar(&bench.bitRate, "bitrate", 4, "bit rate of mp3 output:\n[8..320] for cbr and abr\n[0..9] for vbr")
+	benchCmd.Flags().BoolVar(&bench.json, "json", false, "print results in json")
+	benchCmd.Flags().SortFlags = false
+}
+
+type (
+	// benchInput is the signal encoded by benchmark.
+	benchInput struct {
+		format     *fileformat.Format
+		sampleRate int
+		channels   int
+		duration   time.Duration
+		// open returns the source of the signal and the function that
+		// releases it.
+		open func() (pipe.SourceAllocatorFunc, func(), error)
+	}
+
+	// benchOutput is the output format of benchmark.
+	benchOutput struct {
+		name   string
+		format *fileformat.Format
+		sink   userinput.Sink
+	}
+
+	// benchResult is the throughput of the configuration.
+	benchResult struct {
+		Format       string  `json:"format"`
+		BufferSize   string  `json:"buffer_size"`
+		BufferFrames int     `json:"buffer_frames"`
+		Workers      int     `json:"workers"`
+		Realtime     float64 `json:"realtime"`
+		PerWorker    float64 `json:"per_worker"`
+	}
+
+	// discardSeeker discards written data and keeps track of the
+	// position, so it can be used by sinks that seek.
+	discardSeeker struct {
+		pos, size int64
+	}
+)
+
+// benchSource returns the synthesized signal or the input file.
+func benchSource() (benchInput, error) {
+	if bench.input == "" {
+		if bench.sampleRate <= 0 || bench.channels <= 0 || bench.duration <= 0 {
+			return benchInput{}, fmt.Errorf("invalid synthesized signal: %d Hz, %d channels, %v", bench.sampleRate, bench.channels, bench.duration)
+		}
+		return benchInput{
+			sampleRate: bench.sampleRate,
+			channels:   bench.channels,
+			duration:   bench.duration,
+			open: func() (pipe.SourceAllocatorFunc, func(), error) {
+				return encode.Synth(bench.sampleRate, bench.channels, bench.duration), func() {}, nil
+			},
+		}, nil
+	}
+	in, format, closeFn, err := openAudio(bench.input)
+	if err != nil {
+		return benchInput{}, err
+	}
+	defer closeFn()
+	info, err := encode.Probe(format, in, -1)
+	if err != nil {
+		return benchInput{}, fmt.Errorf("failed to read %s: %w", bench.input, err)
+	}
+	return benchInput{
+		format:     format,
+		sampleRate: info.SampleRate,
+		channels:   info.Channels,
+		duration:   time.Duration(info.Duration * float64(time.Second)),
+		open: func() (pipe.SourceAllocatorFunc, func(), error) {
+			in, format, closeFn, err := openAudio(bench.input)
+			if err != nil {
+				return nil, nil, err
+			}
+			return format.Source(in), closeFn, nil
+		},
+	}, nil
+}
+
+// benchOutputs returns output formats configured with flags.
+func benchOutputs() ([]benchOutput, error) {
+	outputs := make([]benchOutput, 0, len(bench.formats))
+	for _, name := range bench.formats {
+		out := benchOutput{name: strings.ToLower(name)}
+		var err error
+		switch out.name {
+		case "wav":
+			out.format = fileformat.WAV()
+			out.sink, err = userinput.WAV.Sink(bench.bitDepth)
+		case "mp3":
+			out.format = fileformat.MP3()
+			out.sink, err = userinput.MP3.Sink(bench.bitRateMode, bench.bitRate, bench.channelMode, false, 0)
+		default:
+			err = fmt.Errorf("unsupported output format: %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, out)
+	}
+	return outputs, nil
+}
+
+// benchConfig encodes the input with workers concurrently and returns the
+// median throughput of runs.
+func benchConfig(ctx context.Context, in benchInput, out benchOutput, size, workers int) (benchResult, error) {
+	label := fmt.Sprint(size)
+	if size == autoBufferSize {
+		size = encode.AutoBufferSize(in.format, out.format, in.sampleRate, in.channels, false)
+		label = fmt.Sprintf("auto (%d)", size)
+	}
+	r := benchResult{
+		Format:       out.name,
+		BufferSize:   label,
+		BufferFrames: size,
+		Workers:      workers,
+	}
+	realtimes := make([]float64, 0, bench.runs)
+	for i := 0; i < bench.runs; i++ {
+		elapsed, err := benchRun(ctx, in, out, size, workers)
+		if err != nil {
+			return r, err
+		}
+		realtimes = append(realtimes, float64(workers)*float64(in.duration)/float64(elapsed))
+	}
+	sort.Float64s(realtimes)
+	r.Realtime = realtimes[len(realtimes)/2]
+	r.PerWorker = r.Realtime / float64(workers)
+	return r, nil
+}
+
+// benchRun encodes the input with workers concurrently and returns the
+// time it took.
+func benchRun(ctx context.Context, in benchInput, out benchOutput, size, workers int) (time.Duration, error) {
+	sources := make([]pipe.SourceAllocatorFunc, workers)
+	for i := range sources {
+		source, closeFn, err := in.open()
+		if err != nil {
+			return 0, err
+		}
+		defer closeFn()
+		sources[i] = source
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, workers)
+	)
+	start := time.Now()
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source pipe.SourceAllocatorFunc) {
+			defer wg.Done()
+			errs[i] = encode.Run(ctx, size, 0, source, out.sink(&discardSeeker{}))
+		}(i, source)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+	return elapsed, nil
+}
+
+// printBench prints results as a table.
+func printBench(results []benchResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FORMAT\tBUFFER SIZE\tWORKERS\tREALTIME\tPER WORKER")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1fx\t%.1fx\n", r.Format, r.BufferSize, r.Workers, r.Realtime, r.PerWorker)
+	}
+	return w.Flush()
+}
+
+func (d *discardSeeker) Write(p []byte) (int, error) {
+	d.pos += int64(len(p))
+	if d.pos > d.size {
+		d.size = d.pos
+	}
+	return len(p), nil
+}
+
+func (d *discardSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position: %d", offset)
+	}
+	d.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
+)
+
+// envPrefix is the prefix of variables that override flags defaults,
+// e.g. PHONO_PORT for --port flag.
+const envPrefix = "PHONO_"
+
+// configFile is the path of configuration file.
+var configFile string
+
+// commandLineFlags are the flags provided in command line. Directory
+// options don't override them.
+var commandLineFlags map[string]bool
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "configuration file with flags defaults, "+envName("config")+" variable is used if empty")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		commandLineFlags = changedFlags(cmd.Flags())
+		if err := configure(cmd); err != nil {
+			return err
+		}
+		if err := applyPreset(cmd, commandLineFlags); err != nil {
+			return err
+		}
+		if err := checkStallTimeout(cmd.Flags()); err != nil {
+			return err
+		}
+		return limitCPU()
+	}
+}
+
+// configure sets flags of the command that are not provided in command
+// line. Values are taken from environment variables first and then from
+// configuration file. Configuration file has the same format as directory
+// options files, keys are flag names:
+//
+//	port: 8080
+//	max-conversions: 4
+//	processor: [gain:db=-3]
+func configure(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+	if err := setFromEnv(flags.Lookup("config")); err != nil {
+		return err
+	}
+	config := dirconfig.Options{}
+	if configFile != "" {
+		// missing directory options are fine, but missing config is not
+		if _, err := os.Stat(configFile); err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		var err error
+		if config, err = dirconfig.ReadFile(configFile); err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := checkConfig(config); err != nil {
+			return fmt.Errorf("invalid config %s: %w", configFile, err)
+		}
+	}
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "config" {
+			return
+		}
+		if err = setFromEnv(f); err != nil || f.Changed {
+			return
+		}
+		for _, v := range config[f.Name] {
+			if err = flags.Set(f.Name, v); err != nil {
+				err = fmt.Errorf("invalid config %s: %s: %w", configFile, f.Name, err)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// checkStallTimeout returns error if stall-timeout flag of the command is
+// invalid, so conversions are not started with it.
+func checkStallTimeout(flags *pflag.FlagSet) error {
+	if flags.Lookup("stall-timeout") == nil {
+		return nil
+	}
+	timeout, err := flags.GetDuration("stall-timeout")
+	if err != nil {
+		return err
+	}
+	return encode.CheckStallTimeout(timeout)
+}
+
+// setFromEnv sets the flag from environment variable if it's defined and
+// flag is not provided in command line.
+func setFromEnv(f *pflag.Flag) error {
+	if f == nil || f.Changed || envReserved[f.Name] {
+		return nil
+	}
+	v, ok := os.LookupEnv(envName(f.Name))
+	if !ok {
+		return nil
+	}
+	if err := f.Value.Set(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", envName(f.Name), err)
+	}
+	f.Changed = true
+	return nil
+}
+
+// envReserved contains flags which names clash with variables that have
+// different meaning.
+var envReserved = map[string]bool{
+	// PHONO_API_KEYS contains keys, not the file with them.
+	"api-keys": true,
+}
+
+// envName returns the name of variable that overrides the flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// checkConfig returns error if config contains keys that are not flags of
+// any command. The same file is used by all commands, so only typos are
+// reported. Plugins are loaded before the file is read, so they are not
+// allowed.
+func checkConfig(config dirconfig.Options) error {
+	var known []string
+	addFlag := func(f *pflag.Flag) {
+		if f.Name != "plugin" {
+			known = append(known, f.Name)
+		}
+	}
+	var visit func(*cobra.Command)
+	visit = func(c *cobra.Command) {
+		c.Flags().VisitAll(addFlag)
+		c.PersistentFlags().VisitAll(addFlag)
+		for _, sub := range c.Commands() {
+			visit(sub)
+		}
+	}
+	visit(rootCmd)
+	return config.Check(known...)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+)
+
+var (
+	diff = struct {
+		json       bool
+		maxOffset  time.Duration
+		threshold  dbfsFlag
+		bufferSize int
+		latency    string
+	}{
+		threshold: -90,
+	}
+	diffCmd = &cobra.Command{
+		Use:                   "diff [flags] a b",
+		DisableFlagsInUseLine: true,
+		Short:                 "Compare samples of two audio files",
+		Long: "Compare samples of two audio files and report max and RMS difference. Files are aligned\n" +
+			"with cross-correlation of their beginnings. Exit code is non-zero if the max difference\n" +
+			"exceeds the threshold.",
+		Args:          cobra.ExactArgs(2),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, diff.bufferSize, diff.latency)
+			if err != nil {
+				return err
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			d, err := diffFiles(ctx, args[0], args[1], b)
+			if err != nil {
+				return err
+			}
+			if diff.json {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				if err := e.Encode(d); err != nil {
+					return err
+				}
+			} else if err := printDifference(d); err != nil {
+				return err
+			}
+			if float64(d.MaxDifference) > float64(diff.threshold) {
+				return fmt.Errorf("max difference %v dBFS exceeds threshold %v dBFS", d.MaxDifference, float64(diff.threshold))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diff.json, "json", false, "print difference in JSON format")
+	diffCmd.Flags().DurationVar(&diff.maxOffset, "max-offset", time.Second, "max offset of aligned files, alignment is disabled if zero")
+	diffCmd.Flags().Var(&diff.threshold, "threshold", "max allowed difference, e.g. -90dBFS")
+	bufferSizeVar(diffCmd.Flags(), &diff.bufferSize)
+	diffCmd.Flags().StringVar(&diff.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	diffCmd.Flags().SortFlags = false
+}
+
+// diffFiles decodes and compares two files.
+func diffFiles(ctx context.Context, pathA, pathB string, b encode.Buffering) (encode.Difference, error) {
+	a, formatA, closeA, err := openAudio(pathA)
+	if err != nil {
+		return encode.Difference{}, err
+	}
+	defer closeA()
+	bb, formatB, closeB, err := openAudio(pathB)
+	if err != nil {
+		return encode.Difference{}, err
+	}
+	defer closeB()
+	d, err := encode.Compare(ctx, b.BufferSize(formatA, formatB), formatA, a, formatB, bb, diff.maxOffset)
+	if err != nil {
+		return encode.Difference{}, fmt.Errorf("failed to compare %s and %s: %s: %v", pathA, pathB, encode.Code(err), err)
+	}
+	return d, nil
+}
+
+// printDifference writes the difference as a table.
+func printDifference(d encode.Difference) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "offset\t%d frames (%.3fs)\n", d.Offset, float64(d.Offset)/float64(d.SampleRate))
+	fmt.Fprintf(w, "compared\t%d frames\n", d.Frames)
+	fmt.Fprintf(w, "unmatched\t%d frames\n", d.Unmatched)
+	fmt.Fprintf(w, "max difference\t%v dBFS at %.3fs\n", d.MaxDifference, d.MaxAt)
+	fmt.Fprintf(w, "rms difference\t%v dBFS\n", d.RMSDifference)
+	return w.Flush()
+}
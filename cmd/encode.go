@@ -1,22 +1,38 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"pipelined.dev/audio/fileformat"
 	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
 
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/dirconfig"
 	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/mmap"
+	"pipelined.dev/phono/processor"
+	"pipelined.dev/phono/storage"
+	"pipelined.dev/phono/tag"
 )
 
+// encodeStatsJSON is the path of the file with stats of encoded files.
+var encodeStatsJSON string
+
 var (
 	encodeCmd = &cobra.Command{
 		Use:   "encode",
@@ -29,82 +45,1148 @@ var (
 
 func init() {
 	rootCmd.AddCommand(encodeCmd)
+	encodeCmd.PersistentFlags().StringVar(&encodeStatsJSON, "stats-json", "", "write stats of encoded files and their total into JSON file")
+}
+
+// buffering returns buffering settings from command flags. Explicitly
+// provided buffer size overrides latency profile.
+func buffering(cmd *cobra.Command, bufferSize int, latency string) (encode.Buffering, error) {
+	l, err := encode.ParseLatency(latency)
+	if err != nil {
+		return encode.Buffering{}, err
+	}
+	b := encode.Buffering{Latency: l}
+	if cmd.Flags().Changed("buffersize") {
+		setBufferSize(&b, bufferSize)
+	}
+	return b, nil
+}
+
+// setBufferSize sets the size of buffering or enables automatic size.
+func setBufferSize(b *encode.Buffering, size int) {
+	if size == autoBufferSize {
+		b.Size, b.Auto = 0, true
+		return
+	}
+	b.Size, b.Auto = size, false
+}
+
+// dirBuffering returns buffering settings from command flags overridden
+// by directory options.
+func dirBuffering(cmd *cobra.Command, opts dirconfig.Options, bufferSize int, latency string) (encode.Buffering, error) {
+	latency, err := opts.String("latency", latency)
+	if err != nil {
+		return encode.Buffering{}, err
+	}
+	b, err := buffering(cmd, bufferSize, latency)
+	if err != nil {
+		return encode.Buffering{}, err
+	}
+	if opts.Has("buffersize") {
+		v, err := opts.String("buffersize", "")
+		if err != nil {
+			return encode.Buffering{}, err
+		}
+		size, err := parseBufferSize(v)
+		if err != nil {
+			return encode.Buffering{}, fmt.Errorf("option buffersize: %w", err)
+		}
+		setBufferSize(&b, size)
+	}
+	return b, nil
+}
+
+// dirPeakNormalize returns peak normalization target from command flag
+// overridden by directory options. Normalization is enabled if the target
+// is provided in either of them.
+func dirPeakNormalize(cmd *cobra.Command, opts dirconfig.Options, target float64) (bool, float64, error) {
+	if !cmd.Flags().Changed("peak-normalize") && !opts.Has("peak-normalize") {
+		return false, 0, nil
+	}
+	target, err := dirPeakTarget(opts, "peak-normalize", target)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, target, nil
+}
+
+// dirPeakTarget returns peak normalization target from directory option
+// or def if it's not set.
+func dirPeakTarget(opts dirconfig.Options, key string, def float64) (float64, error) {
+	target := def
+	if opts.Has(key) {
+		s, err := opts.String(key, "")
+		if err != nil {
+			return 0, err
+		}
+		if target, err = encode.ParseDBFS(s); err != nil {
+			return 0, fmt.Errorf("option %s: %w", key, err)
+		}
+	}
+	if err := encode.CheckPeakTarget(target); err != nil {
+		return 0, err
+	}
+	return target, nil
 }
 
-func encodeCLI(ctx context.Context, paths []string, recursive bool, outDir string, bufferSize int, sink func(io.WriteSeeker) pipe.SinkAllocatorFunc, ext string) {
+// dirLoudnessNormalize returns loudness normalization target and true
+// peak ceiling from command flags overridden by directory options.
+// Normalization is enabled if the target is provided in either of them.
+func dirLoudnessNormalize(cmd *cobra.Command, opts dirconfig.Options, target, truePeak float64) (bool, float64, float64, error) {
+	if !cmd.Flags().Changed("loudness") && !opts.Has("loudness") {
+		return false, 0, 0, nil
+	}
+	target, err := opts.Float("loudness", target)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if truePeak, err = opts.Float("true-peak", truePeak); err != nil {
+		return false, 0, 0, err
+	}
+	if err := encode.CheckLoudnessTarget(target, truePeak); err != nil {
+		return false, 0, 0, err
+	}
+	return true, target, truePeak, nil
+}
+
+// dirNormalize returns peak and loudness normalization and ReplayGain
+// settings of the directory. Only one of them can be enabled.
+func dirNormalize(cmd *cobra.Command, opts dirconfig.Options, enc *cliEncoder, peakTarget, loudnessTarget, truePeak float64, replayGain string) error {
+	replayGain, err := opts.String("replaygain", replayGain)
+	if err != nil {
+		return err
+	}
+	if enc.replayGain, err = encode.ParseReplayGainMode(replayGain); err != nil {
+		return err
+	}
+	if enc.peakNormalize, enc.peakTarget, err = dirPeakNormalize(cmd, opts, peakTarget); err != nil {
+		return err
+	}
+	if enc.loudnessNormalize, enc.loudnessTarget, enc.truePeak, err = dirLoudnessNormalize(cmd, opts, loudnessTarget, truePeak); err != nil {
+		return err
+	}
+	if enc.peakNormalize && enc.loudnessNormalize {
+		return errors.New("peak and loudness normalization cannot be used together")
+	}
+	if enc.replayGain != encode.ReplayGainOff && (enc.peakNormalize || enc.loudnessNormalize) {
+		return errors.New("replaygain and normalization cannot be used together")
+	}
+	return nil
+}
+
+// processors returns allocators of processors defined by specs. Warning
+// is printed for every experimental processor.
+func processors(specs []string) ([]pipe.ProcessorAllocatorFunc, error) {
+	return processor.Default.Allocators(specs, enableExperimental, func(p processor.Processor) {
+		log.Printf("Warning: processor %s is experimental", p.Name)
+	})
+}
+
+// dirStretch sets tempo, pitch shift and resample quality from command
+// flags overridden by directory options. Stretch is enabled if they change
+// the signal.
+func dirStretch(opts dirconfig.Options, enc *cliEncoder, tempo, pitch float64, quality string) error {
+	tempo, err := opts.Float("tempo", tempo)
+	if err != nil {
+		return err
+	}
+	if opts.Has("pitch") {
+		s, err := opts.String("pitch", "")
+		if err != nil {
+			return err
+		}
+		if pitch, err = encode.ParseSemitones(s); err != nil {
+			return fmt.Errorf("option pitch: %w", err)
+		}
+	}
+	if err := encode.CheckStretch(tempo, pitch); err != nil {
+		return err
+	}
+	if quality, err = opts.String("resample-quality", quality); err != nil {
+		return err
+	}
+	if enc.resampleQuality, err = encode.ParseResampleQuality(quality); err != nil {
+		return err
+	}
+	enc.stretch, enc.tempo, enc.pitch = tempo != 1 || pitch != 0, tempo, pitch
+	return nil
+}
+
+// dirClipping sets clipping detection from command flags overridden by
+// directory options. Failure on clipping enables the detection.
+func dirClipping(opts dirconfig.Options, enc *cliEncoder, detect, fail bool) error {
+	detect, err := opts.Bool("detect-clipping", detect)
+	if err != nil {
+		return err
+	}
+	if fail, err = opts.Bool("fail-on-clipping", fail); err != nil {
+		return err
+	}
+	enc.detectClipping, enc.failOnClipping = detect || fail, fail
+	return nil
+}
+
+// dirTagEdit returns the edit of output tags from command flags
+// overridden by directory options.
+func dirTagEdit(opts dirconfig.Options, assignments []string, template string) (tag.Edit, error) {
+	template, err := opts.String("tag-from-name", template)
+	if err != nil {
+		return tag.Edit{}, err
+	}
+	return tag.ParseEdit(opts.Strings("tag", assignments), template)
+}
+
+// tagReplayGain returns ReplayGain of the mode from tags. Album mode falls
+// back to the track gain. Nil is returned if tags have no gain.
+func tagReplayGain(t tag.Tags, mode encode.ReplayGainMode) *encode.ReplayGain {
+	if mode == encode.ReplayGainAlbum {
+		if gain, peak, ok := t.ReplayGain(true); ok {
+			return &encode.ReplayGain{Gain: gain, Peak: peak}
+		}
+	}
+	if gain, peak, ok := t.ReplayGain(false); ok {
+		return &encode.ReplayGain{Gain: gain, Peak: peak}
+	}
+	return nil
+}
+
+// readChaptersFile reads chapters in JSON format. Nil is returned if path
+// is empty.
+func readChaptersFile(path string) ([]container.Chapter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return container.ReadChapters(f)
+}
+
+// dirDither returns dither mode and noise shaping from command flags
+// overridden by directory options.
+func dirDither(opts dirconfig.Options, mode string, shaping bool) (encode.DitherMode, bool, error) {
+	mode, err := opts.String("dither", mode)
+	if err != nil {
+		return "", false, err
+	}
+	m, err := encode.ParseDitherMode(mode)
+	if err != nil {
+		return "", false, err
+	}
+	if shaping, err = opts.Bool("noise-shaping", shaping); err != nil {
+		return "", false, err
+	}
+	return m, shaping, nil
+}
+
+// dirProcessors returns allocators of EQ and processors from command
+// flags overridden by directory options. EQ is applied first.
+func dirProcessors(opts dirconfig.Options, eq string, specs []string) ([]pipe.ProcessorAllocatorFunc, error) {
+	allocators, err := processors(opts.Strings("processor", specs))
+	if err != nil {
+		return nil, err
+	}
+	if eq, err = opts.String("eq", eq); err != nil || eq == "" {
+		return allocators, err
+	}
+	bands, err := processor.ParseEQ(eq)
+	if err != nil {
+		return nil, err
+	}
+	return append([]pipe.ProcessorAllocatorFunc{processor.EQ(bands...)}, allocators...), nil
+}
+
+// cliEncoder contains the encoding settings of a directory.
+type cliEncoder struct {
+	buffering  encode.Buffering
+	sink       func(io.WriteSeeker) pipe.SinkAllocatorFunc
+	processors []pipe.ProcessorAllocatorFunc
+	// output of bitDepth is dithered according to dither mode, the noise
+	// is shaped if noiseShaping is set.
+	bitDepth     signal.BitDepth
+	dither       encode.DitherMode
+	noiseShaping bool
+	// leading and trailing silence below silenceThreshold dBFS that lasts
+	// at least silenceMin is dropped if trimSilence is set.
+	trimSilence      bool
+	silenceThreshold float64
+	silenceMin       time.Duration
+	// peak is normalized to peakTarget dBFS if peakNormalize is set.
+	peakNormalize bool
+	peakTarget    float64
+	// loudness is normalized to loudnessTarget LUFS with true peak under
+	// truePeak dBTP if loudnessNormalize is set.
+	loudnessNormalize bool
+	loudnessTarget    float64
+	truePeak          float64
+	// gain of replayGain mode is applied unless it's off. The gain is read
+	// from tags of the source or measured if they don't have it.
+	replayGain encode.ReplayGainMode
+	// output is played tempo times faster with pitch shifted by pitch
+	// semitones if stretch is set. Pitch is shifted by resampling of
+	// resampleQuality.
+	stretch         bool
+	tempo           float64
+	pitch           float64
+	resampleQuality encode.ResampleQuality
+	// sidecars enables export of container subtitles and chapters.
+	sidecars bool
+	// output is analyzed during the conversion if analyze is set.
+	analyze bool
+	// clipped regions of the output are printed if detectClipping is set,
+	// the conversion fails on the first one if failOnClipping is set.
+	detectClipping bool
+	failOnClipping bool
+	// sources are fully decoded before the conversion and corrupt ones
+	// are skipped if verify is set.
+	verify bool
+	// tags of the source are written into the output unless stripTags is
+	// set. Output tags are changed by tagEdit. Chapters of the source
+	// are replaced by chapters if they are provided.
+	stripTags bool
+	tagEdit   tag.Edit
+	chapters  []container.Chapter
+	// sources in the output format are copied without re-encoding if
+	// passthrough is set and nothing changes the signal, see copies.
+	// Local sources with unchanged tags are hardlinked instead if
+	// hardlink is set.
+	passthrough bool
+	hardlink    bool
+	// desc describes the output in the summary.
+	desc string
+	// also outputs are encoded in the same pass next to the output.
+	also []cliOutput
+}
+
+// outputFormat is the format of encoded files. Format is nil for
+// registered sinks unknown to fileformat package, ext is the extension
+// of output files.
+type outputFormat struct {
+	format *fileformat.Format
+	ext    string
+}
+
+// outputOf returns the output format of fileformat format.
+func outputOf(format *fileformat.Format) outputFormat {
+	return outputFormat{format: format, ext: format.DefaultExtension()}
+}
+
+// cliOutput is the output encoded in the same pass with the main one.
+// Output is dithered to bitDepth, desc describes it in the summary.
+type cliOutput struct {
+	outputFormat
+	sink     func(io.WriteSeeker) pipe.SinkAllocatorFunc
+	bitDepth signal.BitDepth
+	desc     string
+}
+
+// dirAlso returns outputs encoded in the same pass from command flag
+// overridden by directory options. Every spec has format[:settings]
+// form, e.g. mp3:V2, mp3:CBR320 or wav:16. Outputs must have different
+// formats and differ from the main one.
+func dirAlso(opts dirconfig.Options, specs []string, main outputFormat) ([]cliOutput, error) {
+	specs = opts.Strings("also", specs)
+	outputs := make([]cliOutput, 0, len(specs))
+	formats := map[string]struct{}{main.ext: {}}
+	for _, spec := range specs {
+		output, err := parseOutputSpec(spec)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %w", spec, err)
+		}
+		if _, ok := formats[output.ext]; ok {
+			return nil, fmt.Errorf("output %s: format is already encoded", spec)
+		}
+		formats[output.ext] = struct{}{}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// parseOutputSpec parses the output of format[:settings] spec, see
+// codec.ParseSpec. Options omitted in settings get their defaults.
+func parseOutputSpec(spec string) (cliOutput, error) {
+	s, params, err := codec.ParseSpec(spec)
+	if err != nil {
+		return cliOutput{}, err
+	}
+	enc, err := s.NewEncoder(params)
+	if err != nil {
+		return cliOutput{}, err
+	}
+	return cliOutput{
+		outputFormat: outputFormat{format: s.Format, ext: s.Extension},
+		sink:         enc.Sink,
+		bitDepth:     enc.BitDepth,
+		desc:         enc.Desc,
+	}, nil
+}
+
+// copies returns true if the input of the format is copied into the
+// output instead of re-encoding. Tags of the input are replaced, so tag
+// edits don't need re-encoding. Stripped tags and replaced chapters do,
+// because the copy keeps frames of the input that aren't mapped to tag
+// fields.
+func (enc *cliEncoder) copies(in, out *fileformat.Format) bool {
+	return enc.passthrough && in == out &&
+		len(enc.processors) == 0 &&
+		!enc.trimSilence &&
+		!enc.peakNormalize &&
+		!enc.loudnessNormalize &&
+		enc.replayGain == encode.ReplayGainOff &&
+		!enc.stretch &&
+		!enc.analyze &&
+		!enc.detectClipping &&
+		!enc.stripTags &&
+		enc.chapters == nil
+}
+
+// cliOptions are the options of encodeCLI. Output files are named after
+// the command and written into outDir, next to the inputs if it's
+// empty. Subdirectories are walked if recursive is set. Encoder creates
+// the encoder of directory options.
+type cliOptions struct {
+	command        string
+	recursive      bool
+	followSymlinks bool
+	outDir         string
+	stallTimeout   time.Duration
+	output         outputFormat
+	encoder        func(dirconfig.Options) (cliEncoder, error)
+}
+
+// fileOptions are the options of encodeFile. Source is the remote
+// location of the file and dest is the remote folder of its outputs,
+// they are empty for local files and outputs. Other fields are shared by
+// all files of encodeCLI.
+type fileOptions struct {
+	command      string
+	outDir       string
+	stallTimeout time.Duration
+	output       outputFormat
+	enc          *cliEncoder
+	source       string
+	dest         string
+	store        storage.Storage
+	progress     bool
+	mon          *monitor
+	rtp          *encode.RTPStream
+	batch        *encode.Batch
+}
+
+// encodeCLI encodes files in provided paths and prints the summary of
+// every conversion. Symlinks are followed only if followSymlinks is set
+// or they are provided in paths, directories reached by different links
+// are walked once. Encoder of every directory is created from the
+// options merged from dirconfig files of the directory and its parents.
+// Additional outputs of the encoder are written next to the output with
+// extensions of their formats. Remote files are downloaded into temp
+// folders and their outputs are uploaded next to them, remote out folder
+// is used for all outputs if it's provided. If stdin is a terminal,
+// conversions are paused and resumed with keys, see controlPause. If
+// stderr is a terminal, progress bar of every conversion is drawn.
+// Conversions are sent to the monitor and streamed as RTP if they are
+// enabled with flags. Failed conversions don't stop the walk, error is
+// returned after the summary if any of them failed.
+func encodeCLI(ctx context.Context, paths []string, opts cliOptions) error {
+	store, err := newStorage()
+	if err != nil {
+		return err
+	}
+	if isTerminal(os.Stdin) {
+		pause := encode.NewPause()
+		ctx = encode.WithPause(ctx, pause)
+		go controlPause(os.Stdin, pause)
+		log.Println("Press p and Enter to pause, Enter to resume")
+	}
+	// progress of conversions is drawn only in terminal
+	progress := isTerminal(os.Stderr)
+	mon, err := newMonitor()
+	if err != nil {
+		return err
+	}
+	rtp, err := newRTPStream()
+	if err != nil {
+		return err
+	}
+	outDir, remoteOut := opts.outDir, ""
+	if store.Remote(outDir) {
+		remoteOut, outDir = outDir, ""
+	}
 	if outDir != "" {
 		if _, err := os.Stat(outDir); os.IsNotExist(err) {
-			log.Printf("Out path doesn't exist: %v", err)
-			return
+			return fmt.Errorf("out path doesn't exist: %w", err)
 		}
 	}
 	// build a map for easy-check
 	mpaths := make(map[string]struct{})
-	if !recursive {
-		for _, p := range paths {
-			mpaths[p] = struct{}{}
+	for _, p := range paths {
+		mpaths[filepath.Clean(p)] = struct{}{}
+	}
+	// real paths of walked directories to detect symlink cycles
+	visited := make(map[string]struct{})
+
+	var (
+		// options tree of the walked path
+		tree *dirconfig.Tree
+		// encoders of directories, nil if options are invalid
+		encoders map[string]*cliEncoder
+	)
+	dirEncoder := func(dir string) *cliEncoder {
+		if enc, ok := encoders[dir]; ok {
+			return enc
+		}
+		enc, err := func() (*cliEncoder, error) {
+			dirOpts, err := tree.Options(dir)
+			if err != nil {
+				return nil, err
+			}
+			// flags of command line take precedence over the directory
+			enc, err := opts.encoder(dirOpts.Omit(commandLineFlags))
+			if err != nil {
+				return nil, fmt.Errorf("options of %s: %w", dir, err)
+			}
+			return &enc, nil
+		}()
+		if err != nil {
+			log.Printf("Error reading options: %v\n", err)
 		}
+		encoders[dir] = enc
+		return enc
 	}
 
-	command := "phono-encode"
-	walkFn := func(path string, fi os.FileInfo, err error) error {
+	var (
+		// remote location of the walked path
+		source string
+		// remote folder of outputs
+		dest string
+	)
+	batch := encode.NewBatch()
+	fileOpts := fileOptions{
+		command:      opts.command,
+		outDir:       outDir,
+		stallTimeout: opts.stallTimeout,
+		output:       opts.output,
+		store:        store,
+		progress:     progress,
+		mon:          mon,
+		rtp:          rtp,
+		batch:        batch,
+	}
+	var walkFn filepath.WalkFunc
+	walkFn = func(path string, fi os.FileInfo, err error) error {
+		// interrupted walk doesn't start new conversions
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		if err != nil {
 			log.Printf("Error during walk: %v\n", err)
 		}
-		if fi.IsDir() {
-			// process subdirs
-			if recursive {
+		_, isArg := mpaths[filepath.Clean(path)]
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if !opts.followSymlinks && !isArg {
 				return nil
 			}
-
-			// if not recursive, skip all subdirs
-			if _, ok := mpaths[path]; ok {
+			if fi, err = os.Stat(path); err != nil {
+				log.Printf("Error following symlink: %v\n", err)
 				return nil
 			}
-			return filepath.SkipDir
+			if fi.IsDir() {
+				// trailing separator makes walk resolve the link
+				return filepath.Walk(path+string(filepath.Separator), walkFn)
+			}
+		}
+		if fi.IsDir() {
+			// if not recursive, skip all subdirs
+			if !opts.recursive && !isArg {
+				return filepath.SkipDir
+			}
+			if opts.followSymlinks {
+				real, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					log.Printf("Error resolving directory: %v\n", err)
+					return filepath.SkipDir
+				}
+				if _, ok := visited[real]; ok {
+					log.Printf("Skipping %s: directory %s is already walked\n", path, real)
+					return filepath.SkipDir
+				}
+				visited[real] = struct{}{}
+			}
+			return nil
 		}
 
 		// try to parse format
 		format := fileformat.FormatByPath(path)
-		if format == nil {
+		if format == nil && !container.MatchPath(path) {
 			// file is not supported, skip
 			return nil
 		}
+		enc := dirEncoder(filepath.Dir(path))
+		if enc == nil {
+			return nil
+		}
+		o := fileOpts
+		o.enc, o.source, o.dest = enc, source, dest
+		return encodeFile(ctx, path, format, o)
+	}
+	for _, path := range paths {
+		if ctx.Err() != nil {
+			log.Printf("Interrupted, skipping %s\n", path)
+			continue
+		}
+		source, dest = "", remoteOut
+		if store.Remote(path) {
+			tmp, err := ioutil.TempDir("", "phono-")
+			if err != nil {
+				log.Printf("Error creating input folder: %v\n", err)
+				continue
+			}
+			defer os.RemoveAll(tmp)
+			local, err := download(ctx, store, path, tmp)
+			if err != nil {
+				log.Printf("Error downloading %s: %v\n", path, err)
+				batch.Fail(path, fmt.Errorf("failed to download: %w", err))
+				continue
+			}
+			if dest == "" {
+				dest = storage.Dir(path)
+			}
+			source, path = path, local
+			mpaths[filepath.Clean(path)] = struct{}{}
+		}
+		root := path
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			root = filepath.Dir(path)
+		}
+		tree = dirconfig.NewTree(root)
+		encoders = make(map[string]*cliEncoder)
+		err := filepath.Walk(path, walkFn)
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	if err := reportBatch(batch); err != nil {
+		log.Printf("Error writing stats: %v\n", err)
+	}
+	if r := batch.Report(); r.Total.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", r.Total.Failed, len(r.Files))
+	}
+	return nil
+}
+
+// encodeFile converts the file of format at path and prints the summary.
+// Files that can't be read are skipped. Failures of conversions are
+// recorded in the batch and returned.
+func encodeFile(ctx context.Context, path string, format *fileformat.Format, opts fileOptions) error {
+	enc, ext, outFormat := opts.enc, opts.output.ext, opts.output.format
+	// fail records the failure of the file in the batch and lets the walk
+	// continue with the next file
+	fail := func(err error) error {
+		log.Print(err)
+		name := path
+		if opts.source != "" {
+			name = opts.source
+		}
+		opts.batch.Fail(name, err)
+		return nil
+	}
+
+	// open file
+	in, err := openFile(path)
+	if err != nil {
+		log.Printf("Error opening file: %v\n", err)
+		return nil
+	}
+	defer in.Close() // since we only read file, it's ok to close it with defer
 
-		// open file
-		in, err := os.Open(path)
+	var (
+		text container.Text
+		// audio extracted from container is not the source file
+		extractedAudio bool
+	)
+	if format == nil {
+		if enc.sidecars {
+			if text, err = container.ExtractText(in); err != nil {
+				log.Printf("Skipping sidecars of %s: %v\n", path, err)
+			}
+		}
+		audio, extracted, err := extractAudio(in)
 		if err != nil {
-			log.Printf("Error opening file: %v\n", err)
+			log.Printf("Skipping %s: %v\n", path, err)
 			return nil
 		}
-		defer in.Close() // since we only read file, it's ok to close it with defer
+		defer os.Remove(audio.Name())
+		defer audio.Close()
+		in, format = audio, extracted
+		extractedAudio = true
+	}
 
-		// create output filename
-		var outFilename string
-		if outDir != "" {
-			outFilename = filepath.Join(outDir, outName("", command, ext))
-		} else {
-			outFilename = filepath.Join(filepath.Dir(path), outName("", command, ext))
+	// bit depth is shown in the summary and used to dither the output
+	inBitDepth, bitDepthErr := encode.SourceBitDepth(format, in)
+	bufferSize := enc.buffering.InputBufferSize(format, outFormat, in, false)
+	if enc.verify {
+		if _, err := encode.Verify(ctx, bufferSize, opts.stallTimeout, format, in); err != nil {
+			if ctx.Err() != nil {
+				return fail(fmt.Errorf("failed to verify %s: %s: %v", path, encode.Code(err), err))
+			}
+			log.Printf("Skipping %s: %s: %v\n", path, encode.Code(err), err)
+			return nil
 		}
+	}
 
-		out, err := os.Create(outFilename)
+	var tags tag.Tags
+	if !enc.stripTags || enc.replayGain != encode.ReplayGainOff {
+		if tags, err = tag.Read(format, in); err != nil {
+			log.Printf("Skipping tags of %s: %v\n", path, err)
+		}
+	}
+	var replayGain *encode.ReplayGain
+	if enc.replayGain != encode.ReplayGainOff {
+		replayGain = tagReplayGain(tags, enc.replayGain)
+		// applied gain makes values of the source invalid
+		for _, f := range []tag.Field{tag.TrackGain, tag.TrackPeak, tag.AlbumGain, tag.AlbumPeak} {
+			delete(tags, f)
+		}
+	}
+	if enc.stripTags {
+		tags = nil
+	}
+	if !enc.tagEdit.Empty() {
+		tags = enc.tagEdit.Apply(tags, path)
+	}
+	// chapters of the source are moved with stretched output
+	chapters := enc.chapters
+	if chapters == nil && !enc.stripTags {
+		if chapters = text.Chapters; len(chapters) == 0 {
+			if chapters, err = tag.ReadChapters(format, in); err != nil {
+				log.Printf("Skipping chapters of %s: %v\n", path, err)
+			}
+		}
+		if enc.stretch {
+			chapters = tag.Shift(chapters, 1/enc.tempo)
+		}
+	}
+
+	// create output filename
+	var outFilename string
+	if opts.dest != "" {
+		// outputs are uploaded from the temp folder
+		tmp, err := ioutil.TempDir("", "phono-")
 		if err != nil {
-			log.Printf("Error creating output file: %v\n", err)
+			log.Printf("Error creating output folder: %v\n", err)
+			return nil
 		}
-		// error will be handled in the end of the flow
-		defer out.Close()
+		defer os.RemoveAll(tmp)
+		outFilename = filepath.Join(tmp, outName("", opts.command, ext))
+	} else if opts.outDir != "" {
+		outFilename = filepath.Join(opts.outDir, outName("", opts.command, ext))
+	} else {
+		outFilename = filepath.Join(filepath.Dir(path), outName("", opts.command, ext))
+	}
 
-		if err = encode.Run(ctx, bufferSize, format.Source(in), sink(out)); err != nil {
-			return fmt.Errorf("failed to execute pipe: %v", err)
+	out, err := os.Create(outFilename)
+	if err != nil {
+		log.Printf("Error creating output file: %v\n", err)
+	}
+	// error will be handled in the end of the flow
+	defer out.Close()
+	alsoFiles := make([]*os.File, 0, len(enc.also))
+	// partial outputs of failed or interrupted conversion are removed
+	done := false
+	defer func() {
+		if done {
+			return
+		}
+		os.Remove(outFilename)
+		for _, f := range alsoFiles {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	for _, o := range enc.also {
+		f, err := os.Create(strings.TrimSuffix(outFilename, ext) + o.ext)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create output of %s: %v", path, err))
 		}
-		return out.Close()
+		defer f.Close()
+		alsoFiles = append(alsoFiles, f)
 	}
-	for _, path := range paths {
-		err := filepath.Walk(path, walkFn)
+
+	var (
+		stats    *encode.Stats
+		clip     *encode.ClipDetector
+		analyzer *encode.Analyzer
+		desc     = enc.desc
+	)
+	if enc.copies(format, outFormat) {
+		// the audio is copied as is, so it has no generation loss
+		desc = "copy"
+		if enc.hardlink && opts.dest == "" && !extractedAudio && enc.tagEdit.Empty() && linkOutput(path, out) {
+			desc = "link"
+			stats, err = encode.CopyStats(format, in)
+		} else {
+			stats, err = encode.Copy(format, in, out, tags)
+		}
 		if err != nil {
-			log.Print(err)
+			return fail(fmt.Errorf("failed to copy %s: %v", path, err))
+		}
+	} else {
+		processors := enc.processors
+		if enc.trimSilence {
+			trim, err := encode.TrimSilence(ctx, bufferSize, opts.stallTimeout, format, in, enc.silenceThreshold, enc.silenceMin, processors...)
+			if err != nil {
+				return fail(fmt.Errorf("failed to analyze %s: %s: %v", path, encode.Code(err), err))
+			}
+			processors = append(processors[:len(processors):len(processors)], trim)
+		}
+		if enc.replayGain != encode.ReplayGainOff {
+			if replayGain == nil {
+				g, err := encode.NewReplayGainScanner().Scan(ctx, bufferSize, opts.stallTimeout, format, in, processors...)
+				if err != nil {
+					return fail(fmt.Errorf("failed to analyze %s: %s: %v", path, encode.Code(err), err))
+				}
+				replayGain = &g
+			}
+			log.Printf("%s: replaygain %v\n", path, replayGain)
+			processors = append(processors[:len(processors):len(processors)], replayGain.Apply())
+		}
+		if enc.peakNormalize {
+			normalize, err := encode.PeakNormalize(ctx, bufferSize, opts.stallTimeout, format, in, enc.peakTarget, processors...)
+			if err != nil {
+				return fail(fmt.Errorf("failed to analyze %s: %s: %v", path, encode.Code(err), err))
+			}
+			processors = append(processors[:len(processors):len(processors)], normalize)
+		}
+		if enc.loudnessNormalize {
+			normalize, loudness, err := encode.LoudnessNormalize(ctx, bufferSize, opts.stallTimeout, format, in, enc.loudnessTarget, enc.truePeak, processors...)
+			if err != nil {
+				return fail(fmt.Errorf("failed to analyze %s: %s: %v", path, encode.Code(err), err))
+			}
+			log.Printf("%s: loudness %.1f LUFS, true peak %.1f dBTP\n", path, loudness.Integrated, loudness.TruePeak)
+			processors = append(processors[:len(processors):len(processors)], normalize...)
+		}
+		if enc.dither != encode.DitherOff && bitDepthErr != nil {
+			return fail(fmt.Errorf("failed to read %s: %v", path, bitDepthErr))
+		}
+		// every output is dithered to its own bit depth
+		dithered := func(sink pipe.SinkAllocatorFunc, bitDepth signal.BitDepth) pipe.SinkAllocatorFunc {
+			if enc.dither != encode.DitherOff && enc.dither.Enabled(inBitDepth, bitDepth) {
+				return encode.DitherSink(sink, bitDepth, enc.noiseShaping)
+			}
+			return sink
+		}
+		sinks := []pipe.SinkAllocatorFunc{dithered(tag.Sink(enc.sink(out), outFormat, out, tags, chapters...), enc.bitDepth)}
+		for i, o := range enc.also {
+			f := alsoFiles[i]
+			sinks = append(sinks, dithered(tag.Sink(o.sink(f), o.format, f, tags, chapters...), o.bitDepth))
+		}
+		sinks = append(sinks, opts.mon.sinks(path)...)
+		if opts.rtp != nil {
+			sinks = append(sinks, opts.rtp.Sink())
+		}
+		sink := encode.Tee(sinks...)
+		if enc.detectClipping || enc.failOnClipping {
+			clip = encode.NewClipDetector(encode.DefaultClipRun, enc.failOnClipping)
+			sink = clip.Sink(sink)
+		}
+		if enc.analyze {
+			analyzer = encode.NewAnalyzer()
+			sink = analyzer.Sink(sink)
+		}
+		stats = encode.NewStats()
+		sink = stats.Sink(sink)
+		if enc.stretch {
+			sink = encode.Stretch(sink, enc.tempo, enc.pitch, enc.resampleQuality)
+		}
+		var hooks encode.Hooks
+		if opts.progress {
+			hooks = append(hooks, newProgressBar(path, format, in))
+		}
+		sink = encode.HookSink(sink, hooks)
+		hooks.OnStart()
+		err = encode.Run(ctx, bufferSize, opts.stallTimeout, stats.Source(format.Source(in)), sink, processors...)
+		encode.Done(hooks, err)
+		if err != nil {
+			return fail(fmt.Errorf("failed to encode %s: %s: %v", path, encode.Code(err), err))
+		}
+	}
+	if err := out.Close(); err != nil {
+		return fail(err)
+	}
+	for _, f := range alsoFiles {
+		if err := f.Close(); err != nil {
+			return fail(err)
+		}
+	}
+	done = true
+	if !text.Empty() {
+		if err := writeSidecars(strings.TrimSuffix(outFilename, ext), text); err != nil {
+			log.Printf("Error writing sidecars of %s: %v\n", path, err)
+		}
+	}
+	summary := stats.Summary(
+		strings.TrimPrefix(format.DefaultExtension(), "."),
+		strings.TrimPrefix(ext, ".")+" "+desc,
+		fileSize(path),
+		fileSize(outFilename),
+	)
+	summary.InBitDepth = inBitDepth
+	if opts.dest != "" {
+		if err := uploadDir(ctx, opts.store, filepath.Dir(outFilename), opts.dest); err != nil {
+			return fail(fmt.Errorf("failed to upload output of %s: %v", path, err))
+		}
+	}
+	if opts.source != "" {
+		path = opts.source
+	}
+	fmt.Printf("%s: %v\n", path, summary)
+	opts.batch.Add(path, summary)
+	for i, o := range enc.also {
+		also := stats.Summary(
+			summary.Input,
+			strings.TrimPrefix(o.ext, ".")+" "+o.desc,
+			summary.InputSize,
+			fileSize(alsoFiles[i].Name()),
+		)
+		also.InBitDepth = inBitDepth
+		fmt.Printf("%s: %v\n", path, also)
+	}
+	if analyzer != nil {
+		fmt.Printf("%s: %v\n", path, analyzer.Analysis())
+	}
+	if clip != nil {
+		printClipping(path, clip.Clipping())
+	}
+	return nil
+}
+
+// controlPause pauses conversions when p line is read and resumes them
+// on the next line. Paused conversions keep their position, so the
+// machine can be yielded for a while without losing progress.
+func controlPause(r io.Reader, pause *encode.Pause) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if pause.Paused() {
+			pause.Resume()
+			log.Println("Resumed")
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(scanner.Text()), "p") {
+			pause.Pause()
+			log.Println("Paused, press Enter to resume")
+		}
+	}
+}
+
+// reportBatch prints the table of the batch if it has multiple files and
+// writes the report into the stats file if it's provided.
+func reportBatch(batch *encode.Batch) error {
+	r := batch.Report()
+	if len(r.Files) > 1 {
+		fmt.Println()
+		if err := r.WriteTable(os.Stdout); err != nil {
+			return err
+		}
+	}
+	if encodeStatsJSON == "" {
+		return nil
+	}
+	f, err := os.Create(encodeStatsJSON)
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(f)
+	e.SetIndent("", "  ")
+	if err := e.Encode(r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// download copies the remote file into the local folder and returns the
+// path of the copy. The name of the file is kept, so its format is
+// detected by extension.
+func download(ctx context.Context, store storage.Storage, location, dir string) (string, error) {
+	r, _, err := store.Get(ctx, location)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	path := filepath.Join(dir, storage.Base(location))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// uploadDir uploads files of the local folder into the remote one.
+func uploadDir(ctx context.Context, store storage.Storage, dir, dest string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		location := storage.Join(dest, fi.Name())
+		err = store.Put(ctx, location, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("uploaded %s\n", location)
+	}
+	return nil
+}
+
+// extractAudio writes the audio track of container into the temp file.
+// Offset of returned file is reset to the beginning.
+func extractAudio(in io.ReadSeeker) (*os.File, *fileformat.Format, error) {
+	file, err := ioutil.TempFile("", "phono-")
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := container.Extract(in, file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, nil, err
+	}
+	return file, format, nil
+}
+
+// linkOutput replaces the output file with the hard link to the source.
+// False is returned if the link cannot be created, e.g. if files are on
+// different devices, the output file is kept then.
+func linkOutput(source string, out *os.File) bool {
+	link := out.Name() + ".link"
+	if err := os.Link(source, link); err != nil {
+		log.Printf("Copying %s: %v\n", source, err)
+		return false
+	}
+	if err := os.Rename(link, out.Name()); err != nil {
+		os.Remove(link)
+		log.Printf("Copying %s: %v\n", source, err)
+		return false
+	}
+	return true
+}
+
+// inputFile is the local file opened for reading.
+type inputFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// openFile opens the local file of path for reading. Files of
+// mmapThreshold size or larger are mapped into memory, they are opened
+// as usual if mapping fails.
+func openFile(path string) (inputFile, error) {
+	if mmapThreshold <= 0 {
+		return os.Open(path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < int64(mmapThreshold) || !fi.Mode().IsRegular() {
+		return os.Open(path)
+	}
+	f, err := mmap.Open(path)
+	if err != nil {
+		if err != mmap.ErrUnsupported {
+			log.Printf("Reading %s without memory mapping: %v\n", path, err)
 		}
+		return os.Open(path)
 	}
+	return f, nil
+}
+
+// openAudio opens the audio file of path. Audio track of media container
+// is extracted into the temp file that is removed by close function.
+func openAudio(path string) (io.ReadSeeker, *fileformat.Format, func(), error) {
+	format := fileformat.FormatByPath(path)
+	if format == nil && !container.MatchPath(path) {
+		return nil, nil, nil, fmt.Errorf("unsupported input format: %s", path)
+	}
+	in, err := openFile(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if format != nil {
+		return in, format, func() { in.Close() }, nil
+	}
+	audio, format, err := extractAudio(in)
+	in.Close()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to extract audio of %s: %v", path, err)
+	}
+	return audio, format, func() {
+		audio.Close()
+		os.Remove(audio.Name())
+	}, nil
+}
+
+// writeSidecars writes subtitles and chapters next to the output with
+// base name. Subtitles are written into base.srt, base.lang.srt if the
+// language is known or base.N.srt if there are several tracks without
+// language. Chapters are written into base.chapters.json.
+func writeSidecars(base string, text container.Text) error {
+	names := make(map[string]struct{})
+	for i, s := range text.Subtitles {
+		name := base + ".srt"
+		if s.Language != "" {
+			name = fmt.Sprintf("%s.%s.srt", base, s.Language)
+		}
+		if _, ok := names[name]; ok || len(text.Subtitles) > 1 && s.Language == "" {
+			name = fmt.Sprintf("%s.%d.srt", base, i+1)
+		}
+		names[name] = struct{}{}
+		if err := writeFile(name, s.WriteSRT); err != nil {
+			return err
+		}
+	}
+	if len(text.Chapters) > 0 {
+		return writeFile(base+".chapters.json", func(w io.Writer) error {
+			return container.WriteChapters(w, text.Chapters)
+		})
+	}
+	return nil
+}
+
+// writeFile creates the file and writes it with write function.
+func writeFile(name string, write func(io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fileSize returns the size of file or zero if it cannot be retrieved.
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
 }
 
 // outName generates an output file name with a next template:
@@ -119,3 +1201,19 @@ func outName(prefix, command, ext string) string {
 func timestamp() string {
 	return time.Now().Format("2006-01-02T150405.999")
 }
+
+// maxClippedRegions is the max number of clipped regions printed for a
+// single file.
+const maxClippedRegions = 10
+
+// printClipping prints the clipping summary and first clipped regions.
+func printClipping(path string, c encode.Clipping) {
+	fmt.Printf("%s: %v\n", path, c)
+	for i, r := range c.Regions {
+		if i == maxClippedRegions {
+			fmt.Printf("%s: and %d more clipped regions\n", path, len(c.Regions)-i)
+			return
+		}
+		fmt.Printf("%s: clipped %.3fs-%.3fs in channel %d, %d samples\n", path, r.Start, r.End, r.Channel, r.Samples)
+	}
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
+)
+
+// codecEncodeFlags are flags of encode command of the registered sink.
+// Options of the sink are provided with flags of their names.
+type codecEncodeFlags struct {
+	outPath      string
+	recursive    bool
+	symlinks     bool
+	bufferSize   int
+	stallTimeout time.Duration
+	processors   []string
+	eq           string
+	dither       string
+	noiseShaping bool
+	stripTags    bool
+	tags         []string
+	tagFromName  string
+	latency      string
+	options      map[string]*string
+}
+
+// addCodecCommands adds encode commands of registered sinks that don't
+// have dedicated ones. It's called before the execution, so sinks of
+// external modules are already registered.
+func addCodecCommands() {
+	for _, s := range codec.Sinks() {
+		if found, _, err := encodeCmd.Find([]string{s.Name}); err == nil && found != encodeCmd {
+			continue
+		}
+		encodeCmd.AddCommand(codecEncodeCmd(s))
+	}
+}
+
+// codecEncodeCmd returns encode command of the registered sink.
+func codecEncodeCmd(s codec.Sink) *cobra.Command {
+	flags := codecEncodeFlags{options: make(map[string]*string)}
+	short := "Encode audio files to " + s.Name + " format"
+	if s.Description != "" {
+		short += ", " + s.Description
+	}
+	cmd := &cobra.Command{
+		Use:                   s.Name + " [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 short,
+		Args:                  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if remoteServer.url != "" {
+				encodeRemote(cmd, "phono-encode", args)
+				return
+			}
+			// validate flags before walking the tree
+			if _, err := codecEncoder(cmd, s, &flags, dirconfig.Options{}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+			// conversions are cancelled on interrupt, so partial outputs
+			// are removed before exit
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			if err := encodeCLI(ctx, args, cliOptions{
+				command:        "phono-encode",
+				recursive:      flags.recursive,
+				followSymlinks: flags.symlinks,
+				outDir:         flags.outPath,
+				stallTimeout:   flags.stallTimeout,
+				output:         outputFormat{format: s.Format, ext: s.Extension},
+				encoder: func(opts dirconfig.Options) (cliEncoder, error) {
+					return codecEncoder(cmd, s, &flags, opts)
+				},
+			}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&flags.outPath, "out", "", "output folder or remote folder, e.g. s3://bucket/prefix/ or sftp://host/path/, the userinput folder is used if not specified")
+	bufferSizeVar(cmd.Flags(), &flags.bufferSize)
+	cmd.Flags().StringVar(&flags.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	for _, o := range s.Options {
+		usage := o.Description
+		if len(o.Values) > 0 {
+			usage += ", one of: " + strings.Join(o.Values, ", ")
+		}
+		flags.options[o.Name] = cmd.Flags().String(o.Name, o.Default, usage)
+	}
+	cmd.Flags().StringArrayVar(&flags.processors, "processor", nil, "processor to apply, can be repeated:\nname[:key=value[,key=value]]")
+	cmd.Flags().StringVar(&flags.eq, "eq", "", "equalizer bands applied before processors, e.g.\nhighpass:80,peak:3000:-4:1.0,lowshelf:120:+2")
+	cmd.Flags().StringVar(&flags.dither, "dither", string(encode.DitherAuto), "dither mode:\nauto - if output bit depth is lower than input\non - always\noff - never")
+	cmd.Flags().BoolVar(&flags.noiseShaping, "noise-shaping", false, "shape dither noise to high frequencies")
+	cmd.Flags().BoolVar(&flags.stripTags, "strip-tags", false, "don't copy tags of the source to the output")
+	cmd.Flags().StringArrayVar(&flags.tags, "tag", nil, "set output tag field, can be repeated:\nfield=value")
+	cmd.Flags().StringVar(&flags.tagFromName, "tag-from-name", "", "parse output tag fields from file name, e.g. \"{artist} - {title}\"")
+	cmd.Flags().DurationVar(&flags.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
+	cmd.Flags().BoolVar(&flags.recursive, "recursive", false, "process paths recursive")
+	cmd.Flags().BoolVar(&flags.symlinks, "follow-symlinks", false, "follow symlinks inside paths, they are skipped if not set")
+	cmd.Flags().SortFlags = false
+	return cmd
+}
+
+// codecEncoder returns encoder of the registered sink configured with
+// flags overridden by directory options.
+func codecEncoder(cmd *cobra.Command, s codec.Sink, flags *codecEncodeFlags, opts dirconfig.Options) (cliEncoder, error) {
+	allowed := []string{"buffersize", "latency", "processor", "eq", "dither", "noise-shaping", "strip-tags", "tag", "tag-from-name"}
+	params := make(codec.Params, len(s.Options))
+	for _, o := range s.Options {
+		allowed = append(allowed, o.Name)
+	}
+	if err := opts.Check(allowed...); err != nil {
+		return cliEncoder{}, err
+	}
+	for _, o := range s.Options {
+		v, err := opts.String(o.Name, *flags.options[o.Name])
+		if err != nil {
+			return cliEncoder{}, err
+		}
+		if v != "" {
+			params[o.Name] = v
+		}
+	}
+	enc, err := s.NewEncoder(params)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	b, err := dirBuffering(cmd, opts, flags.bufferSize, flags.latency)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	processors, err := dirProcessors(opts, flags.eq, flags.processors)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	stripTags, err := opts.Bool("strip-tags", flags.stripTags)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	result := cliEncoder{
+		buffering:  b,
+		sink:       enc.Sink,
+		processors: processors,
+		bitDepth:   enc.BitDepth,
+		replayGain: encode.ReplayGainOff,
+		stripTags:  stripTags,
+		desc:       enc.Desc,
+	}
+	if result.dither, result.noiseShaping, err = dirDither(opts, flags.dither, flags.noiseShaping); err != nil {
+		return cliEncoder{}, err
+	}
+	if result.tagEdit, err = dirTagEdit(opts, flags.tags, flags.tagFromName); err != nil {
+		return cliEncoder{}, err
+	}
+	return result, nil
+}
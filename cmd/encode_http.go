@@ -3,28 +3,98 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"html/template"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme/autocert"
+	"pipelined.dev/audio/fileformat"
 
+	"pipelined.dev/phono/admin"
+	"pipelined.dev/phono/api"
+	"pipelined.dev/phono/dav"
 	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/middleware"
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/render"
+	"pipelined.dev/phono/tempdir"
 	"pipelined.dev/phono/userinput"
 )
 
 var (
 	encodeHTTP = struct {
-		port       int
-		tempDir    string
-		bufferSize int
+		port             int
+		tempDir          string
+		bufferSize       int
+		latency          string
+		stallTimeout     time.Duration
+		convTimeout      time.Duration
+		readTimeout      time.Duration
+		writeTimeout     time.Duration
+		sourceURL        bool
+		sourceURLSchemes string
+		sourceURLMaxSize int64
+		sourceURLTimeout time.Duration
+		destinations     string
+		callbackSecret   string
+		callbackTimeout  time.Duration
+		callbackRetries  int
+		uploadMaxSize    int64
+		uploadTTL        time.Duration
+		uploadMemLimit   sizeFlag
+		memoryThreshold  sizeFlag
+		memoryBudget     sizeFlag
+		tempTTL          time.Duration
+		minFreeSpace     sizeFlag
+		maxSize          sizeFlag
+		maxWAVSize       sizeFlag
+		maxMP3Size       sizeFlag
+		maxFLACSize      sizeFlag
+		adminPort        int
+		adminTimeout     time.Duration
+		debugAddr        string
+		resultLinks      bool
+		resultLinksTTL   time.Duration
+		resultLinksKey   string
+		retainResults    time.Duration
+		tlsCert          string
+		tlsKey           string
+		autocertDomains  string
+		autocertCache    string
+		apiKeys          string
+		maxConversions   int
+		queueWait        time.Duration
+		rateLimit        float64
+		rateBurst        int
+		guestCaptchaURL  string
+		guestWidget      string
+		guestTTL         time.Duration
+		guestDailyBytes  sizeFlag
+		guestConversions int
+		webdavDir        string
+		webdavFolders    string
+		templatesDir     string
+		translationsDir  string
+		presetsStore     string
 	}{}
 	encodeHTTPCmd = &cobra.Command{
 		Use:   "http",
 		Short: "Spin up the http service to encode files",
 		Run: func(cmd *cobra.Command, args []string) {
-			serve(encodeHTTP.port, encodeHTTP.tempDir, encodeHTTP.bufferSize)
+			b, err := buffering(cmd, encodeHTTP.bufferSize, encodeHTTP.latency)
+			if err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+			serve(encodeHTTP.port, encodeHTTP.tempDir, b, limits())
 		},
 	}
 )
@@ -33,37 +103,271 @@ func init() {
 	encodeCmd.AddCommand(encodeHTTPCmd)
 	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.port, "port", 8080, "port to use")
 	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.tempDir, "tempdir", "", "directory for temp files. defaults to os.TempDir if empty")
-	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.bufferSize, "buffersize", 1024, "buffer size")
+	bufferSizeVar(encodeHTTPCmd.Flags(), &encodeHTTP.bufferSize)
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.convTimeout, "conversion-timeout", 30*time.Minute, "cancel conversion if it runs longer, disabled if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.readTimeout, "read-timeout", 0, "max duration of reading the request including upload, disabled if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.writeTimeout, "write-timeout", 0, "max duration from the end of request headers to the end of response, disabled if zero")
+	encodeHTTPCmd.Flags().BoolVar(&encodeHTTP.sourceURL, "source-url", false, "allow to provide source url instead of file upload")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.sourceURLSchemes, "source-url-schemes", "https", "comma-separated list of allowed source url schemes, e.g. https,s3,sftp,ftp")
+	encodeHTTPCmd.Flags().Int64Var(&encodeHTTP.sourceURLMaxSize, "source-url-maxsize", 0, "max size of source url content in bytes, no limit if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.sourceURLTimeout, "source-url-timeout", 30*time.Second, "timeout to fetch source url content")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.destinations, "destinations", "", "comma-separated list of location prefixes where results can be uploaded, e.g. s3://bucket/results/, disabled if empty")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.callbackSecret, "callback-secret", "", "secret to sign callbacks, enables callback url of conversions if set")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.callbackTimeout, "callback-timeout", 10*time.Second, "timeout of every callback request")
+	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.callbackRetries, "callback-retries", 3, "number of retries of failed callback with exponential backoff")
+	encodeHTTPCmd.Flags().Int64Var(&encodeHTTP.uploadMaxSize, "upload-maxsize", 0, "max size of resumable upload in bytes, no limit if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.uploadTTL, "upload-ttl", time.Hour, "time to keep inactive resumable uploads")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.uploadMemLimit, "upload-memory-limit", "keep uploaded files up to this size in memory and spool larger to temp folder, e.g. 8M. Every concurrent upload can take this much memory, all uploads are spooled if zero")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.memoryThreshold, "memory-threshold", "encode inputs up to this size into memory instead of temp files, e.g. 16M. Results that exceed the budget are moved to temp folder, disabled if zero")
+	encodeHTTP.memoryBudget = 256 << 20
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.memoryBudget, "memory-budget", "max memory size of all results encoded into memory")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.tempTTL, "temp-ttl", 6*time.Hour, "remove temp files not modified longer than ttl, must exceed the longest conversion, disabled if zero")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.minFreeSpace, "min-free-space", "refuse new conversions with 507 when free disk space of temp folder is lower, e.g. 1G, disabled if zero")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.maxSize, "max-size", "max size of input file of any format, e.g. 500M or 2G, no limit if zero")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.maxWAVSize, "max-wav-size", "max size of wav input file, overrides --max-size")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.maxMP3Size, "max-mp3-size", "max size of mp3 input file, overrides --max-size")
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.maxFLACSize, "max-flac-size", "max size of flac input file, overrides --max-size")
+	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.adminPort, "admin-port", 0, "port for health and metrics endpoints, main port is used if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.adminTimeout, "admin-timeout", 10*time.Second, "read and write timeout of admin endpoints")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.debugAddr, "debug-addr", "", "address for pprof and expvar endpoints, e.g. localhost:6060, disabled if empty")
+	encodeHTTPCmd.Flags().BoolVar(&encodeHTTP.resultLinks, "result-links", false, "allow to request expiring download link instead of the result file")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.resultLinksTTL, "result-links-ttl", 15*time.Minute, "time while result download link is valid")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.resultLinksKey, "result-links-secret", "", "secret to sign result links, random if empty. Must be set to keep links valid after restart")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.retainResults, "retain-results", 0, "keep sent results for this time, so users can list, download and delete them under /files/, disabled if zero")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.tlsCert, "tls-cert", "", "TLS certificate file, enables https together with --tls-key")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.tlsKey, "tls-key", "", "TLS private key file")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.autocertDomains, "autocert-domain", "", "comma-separated list of domains to obtain certificates automatically, port must be 443")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.autocertCache, "autocert-cache", "phono-autocert", "directory to cache automatic certificates")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.apiKeys, "api-keys", "", "JSON file with api keys and their limits, keys can be also provided with "+apiKeysEnv+" variable")
+	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.maxConversions, "max-conversions", 0, "max number of concurrent conversions, no limit if zero")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.queueWait, "queue-wait", 0, "time to wait for conversion slot before 429 is returned")
+	encodeHTTPCmd.Flags().Float64Var(&encodeHTTP.rateLimit, "rate-limit", 0, "max number of conversion requests per second from single IP, no limit if zero")
+	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.rateBurst, "rate-burst", 10, "max number of conversion requests at once from single IP")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.guestCaptchaURL, "guest-captcha-url", "", "siteverify url of captcha provider, enables guest access without api key, secret is provided with "+captchaSecretEnv+" variable")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.guestWidget, "guest-captcha-widget", "", "HTML file with captcha widget of the provider, rendered on "+middleware.GuestPath+" page")
+	encodeHTTPCmd.Flags().DurationVar(&encodeHTTP.guestTTL, "guest-ttl", time.Hour, "time while guest session is valid after solved captcha")
+	encodeHTTP.guestDailyBytes = 100 << 20
+	encodeHTTPCmd.Flags().Var(&encodeHTTP.guestDailyBytes, "guest-daily-size", "max size of guest requests per day from single IP, no limit if zero")
+	encodeHTTPCmd.Flags().IntVar(&encodeHTTP.guestConversions, "guest-daily-conversions", 10, "max number of guest conversions per day from single IP, no limit if zero")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.templatesDir, "templates-dir", "", "directory with encode.html template and static folder of assets that override embedded ones")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.translationsDir, "translations-dir", "", "directory with message catalogs of web form and errors named after languages, e.g. fr.json, messages of embedded languages are overridden")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.presetsStore, "presets-store", "", "JSON file to keep presets of api key owners, presets are kept in memory if empty. Presets of clients without api key are kept in cookies")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.webdavDir, "webdav-dir", "", "directory of WebDAV share on "+webdavPrefix+"/ path, files written into folders of formats are converted into "+dav.DoneFolder+" folder, requires api keys, every key has its own folders, disabled if empty")
+	encodeHTTPCmd.Flags().StringVar(&encodeHTTP.webdavFolders, "webdav-folders", "mp3-v0,mp3-v2,mp3-cbr320,wav-16,wav-24", "comma-separated list of format folders created in WebDAV share, format is separated from settings with dash, e.g. mp3-v0")
 }
 
-func serve(port int, tempDir string, bufferSize int) {
+// limits returns max sizes of input files. Size of format overrides the
+// generic one.
+func limits() userinput.Limits {
+	l := userinput.Limits{}
+	for format, size := range map[*fileformat.Format]sizeFlag{
+		fileformat.WAV():  encodeHTTP.maxWAVSize,
+		fileformat.MP3():  encodeHTTP.maxMP3Size,
+		fileformat.FLAC(): encodeHTTP.maxFLACSize,
+	} {
+		if size == 0 {
+			size = encodeHTTP.maxSize
+		}
+		if size > 0 {
+			l[format] = int64(size)
+		}
+	}
+	return l
+}
+
+func serve(port int, tempDir string, b encode.Buffering, limits userinput.Limits) {
 	// temporary directory
-	dir, err := ioutil.TempDir(tempDir, "phono")
+	dir, err := tempdir.Create(tempDir)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("Failed to create temp folder: %v", err))
 	}
+	var results *service.Results
+	if encodeHTTP.resultLinks {
+		results = service.NewResults([]byte(encodeHTTP.resultLinksKey), encodeHTTP.resultLinksTTL)
+	}
+	recoverTempDirs(tempDir, dir, results)
+
+	store, err := newStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fetcher *userinput.Fetcher
+	if encodeHTTP.sourceURL {
+		fetcher = &userinput.Fetcher{
+			Storage: store,
+			Schemes: strings.Split(encodeHTTP.sourceURLSchemes, ","),
+			MaxSize: encodeHTTP.sourceURLMaxSize,
+			Timeout: encodeHTTP.sourceURLTimeout,
+			TempDir: dir,
+		}
+	}
+
+	uploads := userinput.NewUploads(dir, encodeHTTP.uploadMaxSize, encodeHTTP.uploadTTL)
+	progress := service.NewProgress()
+	jobs := service.NewJobs()
+	events := service.NewEvents()
+	events.Owner = middleware.Client
+
+	if encodeHTTP.translationsDir != "" {
+		if err := i18n.LoadDir(encodeHTTP.translationsDir); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// setting router rule
+	form := userinput.NewEncodeForm(limits, dir, fetcher, uploads, results != nil)
+	form.Experimental = enableExperimental
+	form.MemoryLimit = int64(encodeHTTP.uploadMemLimit)
+	templates, err := userinput.LoadTemplates(encodeHTTP.templatesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := form.SetTemplates(templates); err != nil {
+		log.Fatal(err)
+	}
+	var callbacks *service.Callbacks
+	if encodeHTTP.callbackSecret != "" {
+		callbacks = service.NewCallbacks([]byte(encodeHTTP.callbackSecret))
+		callbacks.Timeout = encodeHTTP.callbackTimeout
+		callbacks.Retries = encodeHTTP.callbackRetries
+		form.Callbacks = callbacks
+	}
+	if encodeHTTP.destinations != "" {
+		form.Destinations = &userinput.Destinations{
+			Storage:  store,
+			Prefixes: strings.Split(encodeHTTP.destinations, ","),
+		}
+	}
+	health := admin.Health{}
+	limiter := middleware.NewLimiter(encodeHTTP.maxConversions, encodeHTTP.rateLimit, encodeHTTP.rateBurst)
+	limiter.QueueWait = encodeHTTP.queueWait
+	limiter.Health = health.Err
+	var resultsHandler http.Handler
+	if results != nil {
+		resultsHandler = results
+	}
+	var (
+		files        *service.Files
+		filesHandler http.Handler
+	)
+	if encodeHTTP.retainResults > 0 {
+		files = service.NewFiles(encodeHTTP.retainResults)
+		files.Owner = middleware.Client
+		filesHandler = files
+	}
+	presets, err := preset.NewStore(encodeHTTP.presetsStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	presets.Owner = middleware.Client
+	var memory *service.MemoryResults
+	if encodeHTTP.memoryThreshold > 0 {
+		memory = service.NewMemoryResults(int64(encodeHTTP.memoryThreshold), int64(encodeHTTP.memoryBudget))
+	}
+	janitor := tempdir.NewJanitor(dir, encodeHTTP.tempTTL, int64(encodeHTTP.minFreeSpace))
+	janitor.Keep = func(path string) bool {
+		return uploads.Owns(path) ||
+			(results != nil && results.Owns(path)) ||
+			(files != nil && files.Owns(path))
+	}
+	health.AddCheck("disk", janitor.Err)
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	go janitor.Run(janitorCtx, janitorInterval)
+	go uploads.Run(janitorCtx, janitorInterval)
+	timeouts := service.Timeouts{
+		Stall:      encodeHTTP.stallTimeout,
+		Conversion: encodeHTTP.convTimeout,
+	}
+	encodeHandler := service.Handler(service.Config{
+		Form:      form,
+		Buffering: b,
+		Timeouts:  timeouts,
+		TempDir:   dir,
+		Progress:  progress,
+		Results:   results,
+		Files:     files,
+		Memory:    memory,
+		Jobs:      jobs,
+		Events:    events,
+	})
+	v1 := api.V1(
+		limiter.Handler(janitor.Handler(encodeHandler)),
+		form.Capabilities(),
+		janitor.Handler(uploads),
+		progress,
+		jobs,
+		events,
+		resultsHandler,
+		filesHandler,
+		presets,
+		limiter.Handler(janitor.Handler(render.Handler(form.Waveform(), b, timeouts))),
+		limiter.Handler(janitor.Handler(render.Handler(form.Spectrogram(), b, timeouts))),
+	)
 	mux := http.NewServeMux()
-	mux.Handle("/", encode.Handler(userinput.NewEncodeForm(userinput.Limits{}), bufferSize, dir))
+	// unversioned paths are kept for the web form and existing clients
+	mux.Handle("/", v1)
+	mux.Handle(api.Prefix, api.NewRouter(api.Version{Name: "v1", Handler: v1}))
+	mux.Handle(userinput.StaticPath, templates.Static())
+	keys, err := apiKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+	share := webdavShare(mux, b, dir, keys, limiter, janitor)
+	handler, err := authenticate(mux, keys)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if share != nil {
+		// clients that mount the share authenticate after the challenge
+		root := http.NewServeMux()
+		root.Handle("/", handler)
+		root.Handle(webdavPrefix+"/", middleware.BasicChallenge("phono", handler))
+		handler = root
+	}
 	server := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: mux,
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      handler,
+		ReadTimeout:  encodeHTTP.readTimeout,
+		WriteTimeout: encodeHTTP.writeTimeout,
 	}
+
+	adminServer := serveAdmin(mux, &health)
+	debugServer := serveDebug()
 	interrupted := onInterrupt(func() {
 		// interrupt signal received, shut down
+		health.SetReady(false)
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Printf("HTTP server Shutdown error: %v", err)
 		}
+		if debugServer != nil {
+			if err := debugServer.Shutdown(context.Background()); err != nil {
+				log.Printf("Debug HTTP server Shutdown error: %v", err)
+			}
+		}
+		if adminServer == nil {
+			return
+		}
+		if err := adminServer.Shutdown(context.Background()); err != nil {
+			log.Printf("Admin HTTP server Shutdown error: %v", err)
+		}
 	})
 
-	log.Printf("phono encode at: http://localhost%s\n", server.Addr)
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+	health.SetReady(true)
+	if err := listen(&server); err != http.ErrServerClosed {
 		log.Printf("HTTP server ListenAndServe error: %v", err)
 	}
 
 	// block until shutdown executed
 	<-interrupted
+	stopJanitor()
+	if callbacks != nil {
+		callbacks.Wait()
+	}
+	if share != nil {
+		share.Wait()
+	}
 
 	// clean up
 	err = os.RemoveAll(dir)
@@ -71,3 +375,183 @@ func serve(port int, tempDir string, bufferSize int) {
 		log.Printf("Clean up error: %v", err)
 	}
 }
+
+// webdavPrefix is the path of WebDAV share.
+const webdavPrefix = "/webdav"
+
+// webdavShare adds WebDAV share to the mux if its directory is set. The
+// share requires api keys, every key has its own folders. Requests pass
+// the same limiter and disk space checks as conversions of the form and
+// conversions of the share use temp folder of the server. Nil is returned
+// if the share is disabled.
+func webdavShare(mux *http.ServeMux, b encode.Buffering, tempDir string, keys []middleware.APIKey, limiter *middleware.Limiter, janitor *tempdir.Janitor) *dav.Share {
+	if encodeHTTP.webdavDir == "" {
+		return nil
+	}
+	if len(keys) == 0 {
+		log.Fatal("WebDAV share requires api keys, provide --api-keys or " + apiKeysEnv)
+	}
+	share, err := dav.NewShare(webdavPrefix, encodeHTTP.webdavDir, strings.Split(encodeHTTP.webdavFolders, ","))
+	if err != nil {
+		log.Fatal(fmt.Sprintf("Failed to create WebDAV share: %v", err))
+	}
+	share.Buffering = b
+	share.StallTimeout = encodeHTTP.stallTimeout
+	share.Timeout = encodeHTTP.convTimeout
+	share.MaxSize = int64(encodeHTTP.maxSize)
+	share.TempDir = tempDir
+	share.Owner = middleware.Client
+	mux.Handle(webdavPrefix+"/", limiter.Handler(janitor.Handler(share)))
+	return share
+}
+
+// janitorInterval is the interval of temp folder cleaning and free disk
+// space checks.
+const janitorInterval = time.Minute
+
+// recoverTempDirs removes temp directories left by crashed runs. If
+// results are enabled, not expired results are moved to the current temp
+// directory.
+func recoverTempDirs(root, dir string, results *service.Results) {
+	var salvage func(string) int
+	if results != nil {
+		salvage = func(orphan string) int {
+			return results.Salvage(orphan, dir)
+		}
+	}
+	summary, err := tempdir.Recover(root, salvage)
+	if err != nil {
+		log.Printf("Failed to recover temp folders: %v", err)
+	}
+	if summary.Directories > 0 {
+		log.Printf("Removed %d orphaned temp folders, salvaged %d results", summary.Directories, summary.Salvaged)
+	}
+}
+
+const (
+	// apiKeysEnv is the variable with comma-separated api keys.
+	apiKeysEnv = "PHONO_API_KEYS"
+	// captchaSecretEnv is the variable with secret of captcha provider.
+	captchaSecretEnv = "PHONO_CAPTCHA_SECRET"
+)
+
+// apiKeys returns api keys provided in the file and environment
+// variable.
+func apiKeys() ([]middleware.APIKey, error) {
+	keys := middleware.ParseAPIKeys(os.Getenv(apiKeysEnv))
+	if encodeHTTP.apiKeys == "" {
+		return keys, nil
+	}
+	f, err := os.Open(encodeHTTP.apiKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open api keys: %w", err)
+	}
+	defer f.Close()
+	fileKeys, err := middleware.ReadAPIKeys(f)
+	if err != nil {
+		return nil, err
+	}
+	return append(keys, fileKeys...), nil
+}
+
+// authenticate requires api keys if they are provided. If guest captcha
+// is enabled, anonymous clients can solve it instead of providing the
+// key.
+func authenticate(h http.Handler, keys []middleware.APIKey) (http.Handler, error) {
+	var auth *middleware.Auth
+	if len(keys) > 0 {
+		auth = middleware.NewAuth(keys)
+		h = auth.Handler(h)
+	}
+	if encodeHTTP.guestCaptchaURL == "" {
+		return h, nil
+	}
+	g := middleware.NewGuest(middleware.SiteVerifier{
+		URL:    encodeHTTP.guestCaptchaURL,
+		Secret: os.Getenv(captchaSecretEnv),
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}, encodeHTTP.guestTTL)
+	g.Auth = auth
+	g.DailyBytes = int64(encodeHTTP.guestDailyBytes)
+	g.DailyConversions = encodeHTTP.guestConversions
+	if encodeHTTP.guestWidget != "" {
+		widget, err := ioutil.ReadFile(encodeHTTP.guestWidget)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read captcha widget: %w", err)
+		}
+		g.Widget = template.HTML(widget)
+	}
+	return g.Handler(h), nil
+}
+
+// listen serves http or https depending on the flags. If autocert domains
+// are provided, certificates are obtained with TLS-ALPN challenge, so the
+// server must be reachable on 443 port.
+func listen(server *http.Server) error {
+	switch {
+	case encodeHTTP.autocertDomains != "":
+		m := autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(encodeHTTP.autocertCache),
+			HostPolicy: autocert.HostWhitelist(strings.Split(encodeHTTP.autocertDomains, ",")...),
+		}
+		server.TLSConfig = m.TLSConfig()
+		log.Printf("phono encode at: https://%s%s\n", encodeHTTP.autocertDomains, server.Addr)
+		return server.ListenAndServeTLS("", "")
+	case encodeHTTP.tlsCert != "" || encodeHTTP.tlsKey != "":
+		log.Printf("phono encode at: https://localhost%s\n", server.Addr)
+		return server.ListenAndServeTLS(encodeHTTP.tlsCert, encodeHTTP.tlsKey)
+	}
+	log.Printf("phono encode at: http://localhost%s\n", server.Addr)
+	return server.ListenAndServe()
+}
+
+// serveAdmin starts admin server on a separate port, so operational
+// endpoints are available when main listener is saturated. If admin port
+// is not set, endpoints are added to the main mux and nil is returned.
+func serveAdmin(mux *http.ServeMux, health *admin.Health) *http.Server {
+	adminMux := admin.Handler(health)
+	if encodeHTTP.adminPort == 0 {
+		for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+			mux.Handle(path, adminMux)
+		}
+		return nil
+	}
+
+	server := http.Server{
+		Addr:         fmt.Sprintf(":%d", encodeHTTP.adminPort),
+		Handler:      adminMux,
+		ReadTimeout:  encodeHTTP.adminTimeout,
+		WriteTimeout: encodeHTTP.adminTimeout,
+	}
+	go func() {
+		log.Printf("phono admin at: http://localhost%s\n", server.Addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("Admin HTTP server ListenAndServe error: %v", err)
+		}
+	}()
+	return &server
+}
+
+// serveDebug starts the server with pprof and expvar endpoints if debug
+// address is set. Server has no timeouts, because profiles are collected
+// for a while. Nil is returned if debug address is not set.
+func serveDebug() *http.Server {
+	if encodeHTTP.debugAddr == "" {
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(encodeHTTP.debugAddr); err == nil && (host == "" || net.ParseIP(host).IsUnspecified()) {
+		log.Printf("Warning: debug endpoints are exposed on all interfaces")
+	}
+	server := http.Server{
+		Addr:    encodeHTTP.debugAddr,
+		Handler: admin.Debug(),
+	}
+	go func() {
+		log.Printf("phono debug at: http://%s/debug/pprof/\n", server.Addr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Printf("Debug HTTP server ListenAndServe error: %v", err)
+		}
+	}()
+	return &server
+}
@@ -2,24 +2,56 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/signal"
 
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
 	"pipelined.dev/phono/userinput"
 )
 
 var (
 	encodeMp3 = struct {
-		outPath     string
-		recursive   bool
-		bufferSize  int
-		channelMode int
-		bitRateMode string
-		bitRate     int
-		quality     int
+		outPath      string
+		recursive    bool
+		symlinks     bool
+		bufferSize   int
+		stallTimeout time.Duration
+		processors   []string
+		eq           string
+		peakTarget   dbfsFlag
+		loudness     float64
+		truePeak     float64
+		replayGain   string
+		tempo        float64
+		pitch        semitonesFlag
+		resample     string
+		dither       string
+		noiseShaping bool
+		sidecars     bool
+		analyze      bool
+		clipping     bool
+		failClipping bool
+		verify       bool
+		transcode    bool
+		hardlink     bool
+		stripTags    bool
+		tags         []string
+		tagFromName  string
+		chapters     string
+		latency      string
+		also         []string
+		channelMode  int
+		bitRateMode  string
+		bitRate      int
+		quality      int
 	}{}
 	encodeMp3Cmd = &cobra.Command{
 		Use:                   "mp3 [flags] path...",
@@ -27,46 +59,184 @@ var (
 		Short:                 "Encode audio files to mp3 format",
 		Args:                  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			useQuality := false
-			if cmd.Flags().Changed("quality") {
-				useQuality = true
+			if remoteServer.url != "" {
+				encodeRemote(cmd, "phono-encode", args)
+				return
 			}
-			sink, err := userinput.MP3.Sink(
-				encodeMp3.bitRateMode,
-				encodeMp3.bitRate,
-				encodeMp3.channelMode,
-				useQuality,
-				encodeMp3.quality,
-			)
-			if err != nil {
+			// validate flags before walking the tree
+			if _, err := mp3Encoder(cmd, dirconfig.Options{}); err != nil {
 				log.Print(err)
 				os.Exit(1)
 			}
-			// create channel for interruption and context for cancellation
+			// conversions are cancelled on interrupt, so partial outputs
+			// are removed before exit
 			ctx, cancelFn := context.WithCancel(context.Background())
-			// interrupt signal received, shut down
-			interrupted := onInterrupt(func() { cancelFn() })
-			encodeCLI(ctx,
-				args,
-				encodeMp3.recursive,
-				encodeMp3.outPath,
-				encodeMp3.bufferSize,
-				sink,
-				fileformat.MP3().DefaultExtension(),
-			)
-			<-interrupted
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			if err := encodeCLI(ctx, args, cliOptions{
+				command:        "phono-encode",
+				recursive:      encodeMp3.recursive,
+				followSymlinks: encodeMp3.symlinks,
+				outDir:         encodeMp3.outPath,
+				stallTimeout:   encodeMp3.stallTimeout,
+				output:         outputOf(fileformat.MP3()),
+				encoder: func(opts dirconfig.Options) (cliEncoder, error) {
+					return mp3Encoder(cmd, opts)
+				},
+			}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
 		},
 	}
 )
 
 func init() {
 	encodeCmd.AddCommand(encodeMp3Cmd)
-	encodeMp3Cmd.Flags().StringVar(&encodeMp3.outPath, "out", "", "output folder, the userinput folder is used if not specified")
-	encodeMp3Cmd.Flags().IntVar(&encodeMp3.bufferSize, "buffersize", 1024, "buffer size")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.outPath, "out", "", "output folder or remote folder, e.g. s3://bucket/prefix/ or sftp://host/path/, the userinput folder is used if not specified")
+	bufferSizeVar(encodeMp3Cmd.Flags(), &encodeMp3.bufferSize)
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
 	encodeMp3Cmd.Flags().IntVar(&encodeMp3.channelMode, "channelmode", 2, "channel mode:\n0 - mono\n1 - stereo\n2 - joint stereo")
 	encodeMp3Cmd.Flags().StringVar(&encodeMp3.bitRateMode, "bitratemode", "vbr", "bit rate mode:\ncbr - constant bit rate\nabr - average bit rate\nvbr - variable bit rate")
 	encodeMp3Cmd.Flags().IntVar(&encodeMp3.bitRate, "bitrate", 4, "bit rate:\n[8..320] for cbr and abr\n[0..9] for vbr")
 	encodeMp3Cmd.Flags().IntVar(&encodeMp3.quality, "quality", 5, "quality [0..9]")
+	encodeMp3Cmd.Flags().StringSliceVar(&encodeMp3.also, "also", nil, "also encode outputs of other formats in the same pass, e.g. wav:16")
+	encodeMp3Cmd.Flags().StringArrayVar(&encodeMp3.processors, "processor", nil, "processor to apply, can be repeated:\nname[:key=value[,key=value]]")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.eq, "eq", "", "equalizer bands applied before processors, e.g.\nhighpass:80,peak:3000:-4:1.0,lowshelf:120:+2")
+	encodeMp3Cmd.Flags().Var(&encodeMp3.peakTarget, "peak-normalize", "normalize peak to dBFS target, e.g. -1dBFS, disabled if not set")
+	encodeMp3Cmd.Flags().Float64Var(&encodeMp3.loudness, "loudness", 0, "normalize integrated loudness to LUFS target, e.g. -16, disabled if not set")
+	encodeMp3Cmd.Flags().Float64Var(&encodeMp3.truePeak, "true-peak", -1, "true peak ceiling in dBTP of loudness normalization")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.replayGain, "replaygain", string(encode.ReplayGainOff), "apply ReplayGain from tags or measured if missing:\noff - disabled\ntrack - track gain\nalbum - album gain, track gain if missing")
+	encodeMp3Cmd.Flags().Float64Var(&encodeMp3.tempo, "tempo", 1, "play output tempo times faster, e.g. 1.25")
+	encodeMp3Cmd.Flags().Var(&encodeMp3.pitch, "pitch", "shift pitch by semitones, e.g. +2st")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.resample, "resample-quality", string(encode.DefaultResampleQuality), "resample quality of pitch shift:\nlinear - fastest\npolyphase - balanced\nsinc-best - best fidelity")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.dither, "dither", string(encode.DitherAuto), "dither mode:\nauto - if output bit depth is lower than input\non - always\noff - never")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.noiseShaping, "noise-shaping", false, "shape dither noise to high frequencies")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.sidecars, "sidecars", false, "write subtitles and chapters of containers next to the output")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.analyze, "analyze", false, "print loudness and peaks of the output")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.clipping, "detect-clipping", false, "print clipped regions of the output")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.failClipping, "fail-on-clipping", false, "fail conversion if the output is clipped")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.verify, "verify", false, "verify sources before conversion and skip corrupt ones")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.transcode, "allow-transcode", false, "re-encode mp3 sources even if nothing changes them, they are copied otherwise")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.hardlink, "hardlink", false, "hardlink copied mp3 sources instead of writing new files if their tags are not edited")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.stripTags, "strip-tags", false, "don't copy tags of the source to the output")
+	encodeMp3Cmd.Flags().StringArrayVar(&encodeMp3.tags, "tag", nil, "set output tag field, can be repeated:\nfield=value")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.tagFromName, "tag-from-name", "", "parse output tag fields from file name, e.g. \"{artist} - {title}\"")
+	encodeMp3Cmd.Flags().StringVar(&encodeMp3.chapters, "chapters", "", "replace chapters of the output with chapters from JSON file:\n[{\"start\": 0, \"title\": \"Intro\"}]")
+	encodeMp3Cmd.Flags().DurationVar(&encodeMp3.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
 	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.recursive, "recursive", false, "process paths recursive")
+	encodeMp3Cmd.Flags().BoolVar(&encodeMp3.symlinks, "follow-symlinks", false, "follow symlinks inside paths, they are skipped if not set")
 	encodeMp3Cmd.Flags().SortFlags = false
 }
+
+// mp3Encoder returns mp3 encoder configured with flags overridden by
+// directory options.
+func mp3Encoder(cmd *cobra.Command, opts dirconfig.Options) (cliEncoder, error) {
+	if err := opts.Check("buffersize", "latency", "channelmode", "bitratemode", "bitrate", "quality", "processor", "eq", "peak-normalize", "loudness", "true-peak", "replaygain", "tempo", "pitch", "resample-quality", "dither", "noise-shaping", "sidecars", "analyze", "detect-clipping", "fail-on-clipping", "verify", "allow-transcode", "hardlink", "strip-tags", "tag", "tag-from-name", "also"); err != nil {
+		return cliEncoder{}, err
+	}
+	bitRateMode, err := opts.String("bitratemode", encodeMp3.bitRateMode)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	bitRate, err := opts.Int("bitrate", encodeMp3.bitRate)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	channelMode, err := opts.Int("channelmode", encodeMp3.channelMode)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	quality, err := opts.Int("quality", encodeMp3.quality)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	useQuality := cmd.Flags().Changed("quality") || opts.Has("quality")
+	sink, err := userinput.MP3.Sink(bitRateMode, bitRate, channelMode, useQuality, quality)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	b, err := dirBuffering(cmd, opts, encodeMp3.bufferSize, encodeMp3.latency)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	processors, err := dirProcessors(opts, encodeMp3.eq, encodeMp3.processors)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	sidecars, err := opts.Bool("sidecars", encodeMp3.sidecars)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	analyze, err := opts.Bool("analyze", encodeMp3.analyze)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	verify, err := opts.Bool("verify", encodeMp3.verify)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	transcode, err := opts.Bool("allow-transcode", encodeMp3.transcode)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	hardlink, err := opts.Bool("hardlink", encodeMp3.hardlink)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	// mp3 sources are copied unless encoder settings are provided
+	passthrough := !transcode
+	for _, name := range []string{"channelmode", "bitratemode", "bitrate", "quality"} {
+		if cmd.Flags().Changed(name) || opts.Has(name) {
+			passthrough = false
+		}
+	}
+	stripTags, err := opts.Bool("strip-tags", encodeMp3.stripTags)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	enc := cliEncoder{
+		buffering:   b,
+		sink:        sink,
+		processors:  processors,
+		bitDepth:    signal.BitDepth16,
+		sidecars:    sidecars,
+		analyze:     analyze,
+		verify:      verify,
+		stripTags:   stripTags,
+		passthrough: passthrough,
+		hardlink:    hardlink,
+		desc:        mp3Description(bitRateMode, bitRate),
+	}
+	if enc.dither, enc.noiseShaping, err = dirDither(opts, encodeMp3.dither, encodeMp3.noiseShaping); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirStretch(opts, &enc, encodeMp3.tempo, float64(encodeMp3.pitch), encodeMp3.resample); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirClipping(opts, &enc, encodeMp3.clipping, encodeMp3.failClipping); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.tagEdit, err = dirTagEdit(opts, encodeMp3.tags, encodeMp3.tagFromName); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.chapters, err = readChaptersFile(encodeMp3.chapters); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.also, err = dirAlso(opts, encodeMp3.also, outputOf(fileformat.MP3())); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirNormalize(cmd, opts, &enc, float64(encodeMp3.peakTarget), encodeMp3.loudness, encodeMp3.truePeak, encodeMp3.replayGain); err != nil {
+		return cliEncoder{}, err
+	}
+	return enc, nil
+}
+
+// mp3Description returns short description of mp3 settings, e.g. V2 or
+// CBR 320k.
+func mp3Description(bitRateMode string, bitRate int) string {
+	if strings.EqualFold(bitRateMode, userinput.MP3.VBR) {
+		return fmt.Sprintf("V%d", bitRate)
+	}
+	return fmt.Sprintf("%s %dk", strings.ToUpper(bitRateMode), bitRate)
+}
@@ -2,21 +2,50 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/signal"
 
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
 	"pipelined.dev/phono/userinput"
 )
 
 var (
 	encodeWav = struct {
-		outPath    string
-		recursive  bool
-		bufferSize int
-		bitDepth   int
+		outPath      string
+		recursive    bool
+		symlinks     bool
+		bufferSize   int
+		stallTimeout time.Duration
+		processors   []string
+		eq           string
+		peakTarget   dbfsFlag
+		loudness     float64
+		truePeak     float64
+		replayGain   string
+		tempo        float64
+		pitch        semitonesFlag
+		resample     string
+		dither       string
+		noiseShaping bool
+		sidecars     bool
+		analyze      bool
+		clipping     bool
+		failClipping bool
+		verify       bool
+		stripTags    bool
+		tags         []string
+		tagFromName  string
+		chapters     string
+		latency      string
+		also         []string
+		bitDepth     int
 	}{}
 	encodeWavCmd = &cobra.Command{
 		Use:                   "wav [flags] path...",
@@ -24,34 +53,140 @@ var (
 		Short:                 "Encode audio files to wav format",
 		Args:                  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			// parse user userinput
-			sink, err := userinput.WAV.Sink(encodeWav.bitDepth)
-			if err != nil {
+			if remoteServer.url != "" {
+				encodeRemote(cmd, "phono-encode", args)
+				return
+			}
+			// validate flags before walking the tree
+			if _, err := wavEncoder(cmd, dirconfig.Options{}); err != nil {
 				log.Print(err)
 				os.Exit(1)
 			}
-			// create channel for interruption and context for cancellation
+			// conversions are cancelled on interrupt, so partial outputs
+			// are removed before exit
 			ctx, cancelFn := context.WithCancel(context.Background())
-			// interrupt signal received, shut down
-			interrupted := onInterrupt(func() { cancelFn() })
-			encodeCLI(ctx,
-				args,
-				encodeWav.recursive,
-				encodeWav.outPath,
-				encodeWav.bufferSize,
-				sink,
-				fileformat.WAV().DefaultExtension(),
-			)
-			<-interrupted
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			if err := encodeCLI(ctx, args, cliOptions{
+				command:        "phono-encode",
+				recursive:      encodeWav.recursive,
+				followSymlinks: encodeWav.symlinks,
+				outDir:         encodeWav.outPath,
+				stallTimeout:   encodeWav.stallTimeout,
+				output:         outputOf(fileformat.WAV()),
+				encoder: func(opts dirconfig.Options) (cliEncoder, error) {
+					return wavEncoder(cmd, opts)
+				},
+			}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
 		},
 	}
 )
 
 func init() {
 	encodeCmd.AddCommand(encodeWavCmd)
-	encodeWavCmd.Flags().StringVar(&encodeWav.outPath, "out", "", "output folder, the userinput folder is used if not specified")
-	encodeWavCmd.Flags().IntVar(&encodeWav.bufferSize, "buffersize", 1024, "buffer size")
+	encodeWavCmd.Flags().StringVar(&encodeWav.outPath, "out", "", "output folder or remote folder, e.g. s3://bucket/prefix/ or sftp://host/path/, the userinput folder is used if not specified")
+	bufferSizeVar(encodeWavCmd.Flags(), &encodeWav.bufferSize)
+	encodeWavCmd.Flags().StringVar(&encodeWav.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
 	encodeWavCmd.Flags().IntVar(&encodeWav.bitDepth, "bitdepth", 24, "bit depth")
+	encodeWavCmd.Flags().StringSliceVar(&encodeWav.also, "also", nil, "also encode outputs of other formats in the same pass, e.g. mp3:V2 or mp3:CBR320")
+	encodeWavCmd.Flags().StringArrayVar(&encodeWav.processors, "processor", nil, "processor to apply, can be repeated:\nname[:key=value[,key=value]]")
+	encodeWavCmd.Flags().StringVar(&encodeWav.eq, "eq", "", "equalizer bands applied before processors, e.g.\nhighpass:80,peak:3000:-4:1.0,lowshelf:120:+2")
+	encodeWavCmd.Flags().Var(&encodeWav.peakTarget, "peak-normalize", "normalize peak to dBFS target, e.g. -1dBFS, disabled if not set")
+	encodeWavCmd.Flags().Float64Var(&encodeWav.loudness, "loudness", 0, "normalize integrated loudness to LUFS target, e.g. -16, disabled if not set")
+	encodeWavCmd.Flags().Float64Var(&encodeWav.truePeak, "true-peak", -1, "true peak ceiling in dBTP of loudness normalization")
+	encodeWavCmd.Flags().StringVar(&encodeWav.replayGain, "replaygain", string(encode.ReplayGainOff), "apply ReplayGain from tags or measured if missing:\noff - disabled\ntrack - track gain\nalbum - album gain, track gain if missing")
+	encodeWavCmd.Flags().Float64Var(&encodeWav.tempo, "tempo", 1, "play output tempo times faster, e.g. 1.25")
+	encodeWavCmd.Flags().Var(&encodeWav.pitch, "pitch", "shift pitch by semitones, e.g. +2st")
+	encodeWavCmd.Flags().StringVar(&encodeWav.resample, "resample-quality", string(encode.DefaultResampleQuality), "resample quality of pitch shift:\nlinear - fastest\npolyphase - balanced\nsinc-best - best fidelity")
+	encodeWavCmd.Flags().StringVar(&encodeWav.dither, "dither", string(encode.DitherAuto), "dither mode:\nauto - if output bit depth is lower than input\non - always\noff - never")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.noiseShaping, "noise-shaping", false, "shape dither noise to high frequencies")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.sidecars, "sidecars", false, "write subtitles and chapters of containers next to the output")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.analyze, "analyze", false, "print loudness and peaks of the output")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.clipping, "detect-clipping", false, "print clipped regions of the output")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.failClipping, "fail-on-clipping", false, "fail conversion if the output is clipped")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.verify, "verify", false, "verify sources before conversion and skip corrupt ones")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.stripTags, "strip-tags", false, "don't copy tags of the source to the output")
+	encodeWavCmd.Flags().StringArrayVar(&encodeWav.tags, "tag", nil, "set output tag field, can be repeated:\nfield=value")
+	encodeWavCmd.Flags().StringVar(&encodeWav.tagFromName, "tag-from-name", "", "parse output tag fields from file name, e.g. \"{artist} - {title}\"")
+	encodeWavCmd.Flags().StringVar(&encodeWav.chapters, "chapters", "", "replace chapters of the output with chapters from JSON file:\n[{\"start\": 0, \"title\": \"Intro\"}]")
+	encodeWavCmd.Flags().DurationVar(&encodeWav.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
 	encodeWavCmd.Flags().BoolVar(&encodeWav.recursive, "recursive", false, "process paths recursive")
+	encodeWavCmd.Flags().BoolVar(&encodeWav.symlinks, "follow-symlinks", false, "follow symlinks inside paths, they are skipped if not set")
 	encodeWavCmd.Flags().SortFlags = false
 }
+
+// wavEncoder returns wav encoder configured with flags overridden by
+// directory options.
+func wavEncoder(cmd *cobra.Command, opts dirconfig.Options) (cliEncoder, error) {
+	if err := opts.Check("buffersize", "latency", "bitdepth", "processor", "eq", "peak-normalize", "loudness", "true-peak", "replaygain", "tempo", "pitch", "resample-quality", "dither", "noise-shaping", "sidecars", "analyze", "detect-clipping", "fail-on-clipping", "verify", "strip-tags", "tag", "tag-from-name", "also"); err != nil {
+		return cliEncoder{}, err
+	}
+	bitDepth, err := opts.Int("bitdepth", encodeWav.bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	sink, err := userinput.WAV.Sink(bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	b, err := dirBuffering(cmd, opts, encodeWav.bufferSize, encodeWav.latency)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	processors, err := dirProcessors(opts, encodeWav.eq, encodeWav.processors)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	sidecars, err := opts.Bool("sidecars", encodeWav.sidecars)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	analyze, err := opts.Bool("analyze", encodeWav.analyze)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	verify, err := opts.Bool("verify", encodeWav.verify)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	stripTags, err := opts.Bool("strip-tags", encodeWav.stripTags)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	enc := cliEncoder{
+		buffering:  b,
+		sink:       sink,
+		processors: processors,
+		bitDepth:   signal.BitDepth(bitDepth),
+		sidecars:   sidecars,
+		analyze:    analyze,
+		verify:     verify,
+		stripTags:  stripTags,
+		desc:       fmt.Sprintf("%dbit", bitDepth),
+	}
+	if enc.dither, enc.noiseShaping, err = dirDither(opts, encodeWav.dither, encodeWav.noiseShaping); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirStretch(opts, &enc, encodeWav.tempo, float64(encodeWav.pitch), encodeWav.resample); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirClipping(opts, &enc, encodeWav.clipping, encodeWav.failClipping); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.tagEdit, err = dirTagEdit(opts, encodeWav.tags, encodeWav.tagFromName); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.chapters, err = readChaptersFile(encodeWav.chapters); err != nil {
+		return cliEncoder{}, err
+	}
+	if enc.also, err = dirAlso(opts, encodeWav.also, outputOf(fileformat.WAV())); err != nil {
+		return cliEncoder{}, err
+	}
+	if err := dirNormalize(cmd, opts, &enc, float64(encodeWav.peakTarget), encodeWav.loudness, encodeWav.truePeak, encodeWav.replayGain); err != nil {
+		return cliEncoder{}, err
+	}
+	return enc, nil
+}
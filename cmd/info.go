@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+)
+
+var (
+	info = struct {
+		fast         bool
+		json         bool
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	infoCmd = &cobra.Command{
+		Use:                   "info [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Print format, duration and bitrate of audio files",
+		Long: "Print format, duration and bitrate of audio files. Files are fully decoded to find\n" +
+			"exact duration, unless fast mode is enabled. In fast mode only headers are read and\n" +
+			"duration of mp3 files without VBR header is estimated.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, info.bufferSize, info.latency)
+			if err != nil {
+				return err
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			reports := make([]infoReport, 0, len(args))
+			for _, path := range args {
+				r, err := fileInfo(ctx, path, b, info.stallTimeout)
+				if err != nil {
+					log.Printf("%s: %s: %v\n", path, encode.Code(err), err)
+					continue
+				}
+				reports = append(reports, r)
+			}
+			if info.json {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				if err := e.Encode(reports); err != nil {
+					return err
+				}
+			} else if err := printInfo(reports); err != nil {
+				return err
+			}
+			if failed := len(args) - len(reports); failed > 0 {
+				return fmt.Errorf("failed to read %d of %d files", failed, len(args))
+			}
+			return nil
+		},
+	}
+)
+
+// infoReport is the info and chapters of the file.
+type infoReport struct {
+	Path string `json:"path"`
+	encode.Info
+	Chapters []container.Chapter `json:"chapters,omitempty"`
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+	infoCmd.Flags().BoolVar(&info.fast, "fast", false, "read headers only without decoding")
+	infoCmd.Flags().BoolVar(&info.json, "json", false, "print info in JSON format")
+	bufferSizeVar(infoCmd.Flags(), &info.bufferSize)
+	infoCmd.Flags().StringVar(&info.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	infoCmd.Flags().DurationVar(&info.stallTimeout, "stall-timeout", time.Minute, "cancel decoding if it makes no progress, disabled if zero")
+	infoCmd.Flags().SortFlags = false
+}
+
+// fileInfo probes the file headers in fast mode, otherwise the file is
+// fully decoded. Chapters that can't be read are skipped.
+func fileInfo(ctx context.Context, path string, b encode.Buffering, stallTimeout time.Duration) (infoReport, error) {
+	in, format, closeFn, err := openAudio(path)
+	if err != nil {
+		return infoReport{}, err
+	}
+	defer closeFn()
+	// size of the audio, it's extracted from containers
+	size, err := in.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = in.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return infoReport{}, err
+	}
+	r := infoReport{Path: path}
+	if info.fast {
+		r.Info, err = encode.Probe(format, in, size)
+	} else {
+		r.Info, err = encode.Inspect(ctx, b.BufferSize(format, nil), stallTimeout, format, in, size)
+	}
+	if err != nil {
+		return infoReport{}, err
+	}
+	if r.Chapters, err = tag.ReadChapters(format, in); err != nil {
+		log.Printf("Skipping chapters of %s: %v\n", path, err)
+	}
+	return r, nil
+}
+
+// printInfo writes reports as a table.
+func printInfo(reports []infoReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tFORMAT\tSAMPLE RATE\tCHANNELS\tBIT DEPTH\tDURATION\tBITRATE")
+	for _, r := range reports {
+		bitDepth, approx := "-", ""
+		if r.BitDepth > 0 {
+			bitDepth = fmt.Sprintf("%dbit", r.BitDepth)
+		}
+		if r.Estimated {
+			approx = "~"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s%.2fs\t%dkbps\n", r.Path, r.Format, r.SampleRate, r.Channels, bitDepth, approx, r.Duration, r.Bitrate/1000)
+	}
+	return w.Flush()
+}
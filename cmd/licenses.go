@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/licenses"
+)
+
+var (
+	licensesJSON bool
+	licensesCmd  = &cobra.Command{
+		Use:   "licenses",
+		Short: "Report codec libraries compiled in, their versions and licenses",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report := licenses.Report()
+			if licensesJSON {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				return e.Encode(report)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tVERSION\tLICENSE\tFEATURES\tPATENTS")
+			for _, c := range report {
+				name := c.Name
+				if c.Native {
+					name += " (native)"
+				}
+				patents := c.Patents
+				if patents == "" {
+					patents = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, c.Version, c.License, c.Features, patents)
+			}
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(licensesCmd)
+	licensesCmd.Flags().BoolVar(&licensesJSON, "json", false, "print report in JSON format")
+}
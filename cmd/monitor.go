@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/encode/service"
+)
+
+var monitorFlags = struct {
+	addr   string
+	player string
+}{}
+
+func init() {
+	encodeCmd.PersistentFlags().StringVar(&monitorFlags.addr, "monitor-addr", "", "serve peaks of running conversions on ws://addr/monitor, e.g. :8090")
+	encodeCmd.PersistentFlags().StringVar(&monitorFlags.player, "monitor-player", "", "play running conversions with the command reading 16-bit PCM from stdin,\n{rate} and {channels} are replaced with signal properties, e.g.\n\"aplay -q -t raw -f S16_LE -r {rate} -c {channels}\"")
+}
+
+// monitor is attached next to outputs of encode commands, so operators can
+// watch and listen to what's being encoded.
+type monitor struct {
+	peaks  *service.Monitor
+	player []string
+}
+
+// playerProcess is the running player, closing it waits for the played
+// signal.
+type playerProcess struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// newMonitor returns the monitor of monitor flags, nil is returned if
+// monitoring is disabled. Peaks are served until the process exits.
+func newMonitor() (*monitor, error) {
+	if monitorFlags.addr == "" && monitorFlags.player == "" {
+		return nil, nil
+	}
+	var m monitor
+	if monitorFlags.player != "" {
+		if m.player = strings.Fields(monitorFlags.player); len(m.player) == 0 {
+			return nil, errors.New("monitor player command is empty")
+		}
+	}
+	if monitorFlags.addr != "" {
+		l, err := net.Listen("tcp", monitorFlags.addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serve monitor: %w", err)
+		}
+		m.peaks = service.NewMonitor()
+		mux := http.NewServeMux()
+		mux.Handle("/monitor", m.peaks)
+		go func() {
+			if err := http.Serve(l, mux); err != nil {
+				log.Printf("Monitor stopped: %v", err)
+			}
+		}()
+		log.Printf("Monitor is served on ws://%s/monitor", l.Addr())
+	}
+	return &m, nil
+}
+
+// sinks returns monitor sinks of the conversion.
+func (m *monitor) sinks(name string) []pipe.SinkAllocatorFunc {
+	if m == nil {
+		return nil
+	}
+	var sinks []pipe.SinkAllocatorFunc
+	if m.peaks != nil {
+		sinks = append(sinks, m.peaks.Sink(name))
+	}
+	if m.player != nil {
+		sinks = append(sinks, service.PlaybackSink(m.play))
+	}
+	return sinks
+}
+
+// play starts the player of the signal.
+func (m *monitor) play(props pipe.SignalProperties) (io.WriteCloser, error) {
+	args := make([]string, len(m.player))
+	for i, arg := range m.player {
+		arg = strings.ReplaceAll(arg, "{rate}", strconv.Itoa(int(props.SampleRate)))
+		args[i] = strings.ReplaceAll(arg, "{channels}", strconv.Itoa(props.Channels))
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &playerProcess{WriteCloser: stdin, cmd: cmd}, nil
+}
+
+func (p *playerProcess) Close() error {
+	if err := p.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return p.cmd.Wait()
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+import (
+	"syscall"
+)
+
+// lowerPriority sets the lowest scheduling priority of the process.
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+}
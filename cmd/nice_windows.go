@@ -0,0 +1,10 @@
+package cmd
+
+import (
+	"errors"
+)
+
+// lowerPriority is not supported on windows.
+func lowerPriority() error {
+	return errors.New("not supported on windows")
+}
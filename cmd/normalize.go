@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+var (
+	normalize = struct {
+		outPath      string
+		recursive    bool
+		symlinks     bool
+		bufferSize   int
+		stallTimeout time.Duration
+		peakTarget   dbfsFlag
+		latency      string
+		bitDepth     int
+	}{
+		peakTarget: -1,
+	}
+	normalizeCmd = &cobra.Command{
+		Use:                   "normalize [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Normalize peak level of audio files",
+		Long: "Normalize peak level of audio files in two passes: the first one finds the peak\n" +
+			"and the second one applies the gain while the file is encoded to wav format.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// validate flags before walking the tree
+			if _, err := normalizeEncoder(cmd, dirconfig.Options{}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+			// conversions are cancelled on interrupt, so partial outputs
+			// are removed before exit
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			if err := encodeCLI(ctx, args, cliOptions{
+				command:        "phono-normalize",
+				recursive:      normalize.recursive,
+				followSymlinks: normalize.symlinks,
+				outDir:         normalize.outPath,
+				stallTimeout:   normalize.stallTimeout,
+				output:         outputOf(fileformat.WAV()),
+				encoder: func(opts dirconfig.Options) (cliEncoder, error) {
+					return normalizeEncoder(cmd, opts)
+				},
+			}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(normalizeCmd)
+	normalizeCmd.Flags().Var(&normalize.peakTarget, "peak", "peak target, e.g. -1dBFS")
+	normalizeCmd.Flags().StringVar(&normalize.outPath, "out", "", "output folder, the userinput folder is used if not specified")
+	bufferSizeVar(normalizeCmd.Flags(), &normalize.bufferSize)
+	normalizeCmd.Flags().StringVar(&normalize.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	normalizeCmd.Flags().IntVar(&normalize.bitDepth, "bitdepth", 24, "bit depth")
+	normalizeCmd.Flags().DurationVar(&normalize.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
+	normalizeCmd.Flags().BoolVar(&normalize.recursive, "recursive", false, "process paths recursive")
+	normalizeCmd.Flags().BoolVar(&normalize.symlinks, "follow-symlinks", false, "follow symlinks inside paths, they are skipped if not set")
+	normalizeCmd.Flags().SortFlags = false
+}
+
+// normalizeEncoder returns wav encoder with peak normalization configured
+// with flags overridden by directory options.
+func normalizeEncoder(cmd *cobra.Command, opts dirconfig.Options) (cliEncoder, error) {
+	if err := opts.Check("buffersize", "latency", "bitdepth", "peak"); err != nil {
+		return cliEncoder{}, err
+	}
+	target, err := dirPeakTarget(opts, "peak", float64(normalize.peakTarget))
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	bitDepth, err := opts.Int("bitdepth", normalize.bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	sink, err := userinput.WAV.Sink(bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	b, err := dirBuffering(cmd, opts, normalize.bufferSize, normalize.latency)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	return cliEncoder{
+		buffering:     b,
+		sink:          sink,
+		peakNormalize: true,
+		peakTarget:    target,
+		bitDepth:      signal.BitDepth(bitDepth),
+		dither:        encode.DitherAuto,
+		desc:          fmt.Sprintf("%dbit, peak %gdBFS", bitDepth, target),
+	}, nil
+}
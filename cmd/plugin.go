@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"plugin"
+
+	"github.com/spf13/pflag"
+)
+
+const pluginUsage = "load Go plugin that registers processors or codecs, can be repeated:\n" +
+	"plugins are built with go build -buildmode=plugin against the same phono version"
+
+func init() {
+	rootCmd.PersistentFlags().StringArray("plugin", nil, pluginUsage)
+}
+
+// pluginFlags returns paths of plugins provided with command line flags or
+// environment variable. Plugins register commands of their codecs, so
+// they are loaded before cobra parses flags and configuration file can't
+// provide them.
+func pluginFlags(args []string) ([]string, error) {
+	var paths []string
+	flags := pflag.NewFlagSet("plugins", pflag.ContinueOnError)
+	flags.ParseErrorsWhitelist.UnknownFlags = true
+	flags.SetOutput(ioutil.Discard)
+	flags.Usage = func() {}
+	flags.StringArrayVar(&paths, "plugin", nil, pluginUsage)
+	// help and other errors are reported by cobra
+	flags.Parse(args)
+	if err := setFromEnv(flags.Lookup("plugin")); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// loadPlugins opens Go plugins. Plugins register their processors and
+// codecs from init functions with the registry API, exactly like
+// modules compiled in with blank imports. Every plugin is opened once.
+func loadPlugins(paths []string) error {
+	loaded := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("failed to load plugin %s: %w", path, err)
+		}
+		if _, ok := loaded[abs]; ok {
+			continue
+		}
+		if _, err := plugin.Open(abs); err != nil {
+			return fmt.Errorf("failed to load plugin %s: %w", path, err)
+		}
+		loaded[abs] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"pipelined.dev/phono/client"
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/preset"
+)
+
+var presetFlags = struct {
+	name string
+	file string
+}{}
+
+func init() {
+	encodeCmd.PersistentFlags().StringVar(&presetFlags.name, "preset", "", "apply encode preset saved in the web form, it's read from --presets-file or from --remote server")
+	encodeCmd.PersistentFlags().StringVar(&presetFlags.file, "presets-file", "", "JSON file with list of presets, e.g. saved from /presets of the server")
+}
+
+// applyPreset sets flags of the sink command from the preset values.
+// Flags provided in command line are not changed, values of env and
+// config file are overridden. Values of the preset that have no flags
+// are ignored.
+func applyPreset(cmd *cobra.Command, explicit map[string]bool) error {
+	if presetFlags.name == "" {
+		return nil
+	}
+	sink, err := codec.LookupSink(cmd.Name())
+	if err != nil {
+		return fmt.Errorf("preset is not supported by %s command", cmd.Name())
+	}
+	p, err := loadPreset(presetFlags.name)
+	if err != nil {
+		return err
+	}
+	if !hasFormat(p.Formats(), sink.Extension) {
+		return fmt.Errorf("preset %s is not %s format", p.Name, sink.Name)
+	}
+	flags := cmd.Flags()
+	for name, values := range presetFlagValues(p.Values, sink) {
+		f := flags.Lookup(name)
+		if f == nil || explicit[name] {
+			continue
+		}
+		if err := setFlag(f, values); err != nil {
+			return fmt.Errorf("invalid preset %s: %s: %w", p.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// loadPreset reads the preset from the presets file or from the remote
+// server.
+func loadPreset(name string) (preset.Preset, error) {
+	switch {
+	case presetFlags.file != "":
+		presets, err := preset.ReadFile(presetFlags.file)
+		if err != nil {
+			return preset.Preset{}, err
+		}
+		p, ok := preset.Find(presets, name)
+		if !ok {
+			return preset.Preset{}, fmt.Errorf("preset %s is not found in %s", name, presetFlags.file)
+		}
+		return p, nil
+	case remoteServer.url != "":
+		c, err := client.New(remoteServer.url, remoteServer.apiKey)
+		if err != nil {
+			return preset.Preset{}, err
+		}
+		p, err := c.Preset(context.Background(), name)
+		if err != nil {
+			return preset.Preset{}, fmt.Errorf("failed to get preset %s: %w", name, err)
+		}
+		return p, nil
+	default:
+		return preset.Preset{}, fmt.Errorf("preset %s requires --presets-file or --remote", name)
+	}
+}
+
+// presetFlagValues returns flag values of form values of the preset. It
+// reverses remoteForm, so dedicated wav and mp3 fields are mapped to
+// flags of built-in sinks.
+func presetFlagValues(form url.Values, sink codec.Sink) map[string][]string {
+	result := make(map[string][]string)
+	value := func(key string) string {
+		if v := form[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	set := func(name, v string) {
+		if v != "" {
+			result[name] = []string{v}
+		}
+	}
+	for _, key := range remoteFormKeys {
+		var values []string
+		for _, v := range form[key] {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 0 {
+			result[key] = values
+		}
+	}
+	for field, key := range remoteTagKeys {
+		if v := value(key); v != "" {
+			result["tag"] = append(result["tag"], string(field)+"="+v)
+		}
+	}
+	switch sink.Name {
+	case "wav":
+		set("bitdepth", value("wav-bit-depth"))
+	case "mp3":
+		mode := value("mp3-bit-rate-mode")
+		set("bitratemode", strings.ToLower(mode))
+		set("channelmode", value("mp3-channel-mode"))
+		if mode == codec.MP3VBR {
+			set("bitrate", value("mp3-vbr-quality"))
+		} else {
+			set("bitrate", value("mp3-bit-rate"))
+		}
+		if value("mp3-use-quality") == "true" {
+			set("quality", value("mp3-quality"))
+		}
+	default:
+		prefix := strings.TrimPrefix(sink.Extension, ".") + "-"
+		for _, o := range sink.Options {
+			set(o.Name, value(prefix+o.Name))
+		}
+	}
+	return result
+}
+
+// setFlag replaces the value of the flag. Values of slice flags are
+// replaced entirely, other flags take the last value.
+func setFlag(f *pflag.Flag, values []string) error {
+	if s, ok := f.Value.(pflag.SliceValue); ok {
+		if err := s.Replace(values); err != nil {
+			return err
+		}
+	} else if err := f.Value.Set(values[len(values)-1]); err != nil {
+		return err
+	}
+	f.Changed = true
+	return nil
+}
+
+func hasFormat(formats []string, extension string) bool {
+	for _, f := range formats {
+		if strings.TrimPrefix(f, ".") == strings.TrimPrefix(extension, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// changedFlags returns names of flags provided in command line.
+func changedFlags(flags *pflag.FlagSet) map[string]bool {
+	result := make(map[string]bool)
+	flags.Visit(func(f *pflag.Flag) {
+		result[f.Name] = true
+	})
+	return result
+}
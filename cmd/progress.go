@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+// progressInterval is the minimal interval between redraws of progress
+// bar.
+const progressInterval = 200 * time.Millisecond
+
+// progressWidth is the number of cells of progress bar.
+const progressWidth = 30
+
+// progressBar is the hook that draws the progress of conversion in the
+// terminal. Total is the estimated number of frames of the input, the
+// number of written frames is drawn if it's unknown.
+type progressBar struct {
+	w      io.Writer
+	name   string
+	total  int64
+	frames int64
+	last   time.Time
+}
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressBar returns progress bar of the input conversion drawn in
+// stderr. Length of the input is read from its header, the input is
+// rewound after that.
+func newProgressBar(path string, format *fileformat.Format, in io.ReadSeeker) *progressBar {
+	bar := progressBar{
+		w:    os.Stderr,
+		name: filepath.Base(path),
+	}
+	if size, err := in.Seek(0, io.SeekEnd); err == nil {
+		in.Seek(0, io.SeekStart)
+		if info, err := encode.Probe(format, in, size); err == nil {
+			bar.total = int64(info.Duration * float64(info.SampleRate))
+		}
+	}
+	in.Seek(0, io.SeekStart)
+	return &bar
+}
+
+// OnStart draws empty progress bar.
+func (b *progressBar) OnStart() {
+	b.draw()
+}
+
+// OnProgress redraws the progress bar, redraws are limited by progress
+// interval.
+func (b *progressBar) OnProgress(frames int64) {
+	b.frames += frames
+	if time.Since(b.last) < progressInterval {
+		return
+	}
+	b.draw()
+}
+
+// OnComplete clears the progress bar, so the summary is printed in its
+// place.
+func (b *progressBar) OnComplete() {
+	b.clear()
+}
+
+// OnError clears the progress bar.
+func (b *progressBar) OnError(error) {
+	b.clear()
+}
+
+func (b *progressBar) draw() {
+	b.last = time.Now()
+	if b.total <= 0 {
+		fmt.Fprintf(b.w, "\r\033[K%s: %d frames", b.name, b.frames)
+		return
+	}
+	done := b.frames
+	if done > b.total {
+		done = b.total
+	}
+	cells := int(done * progressWidth / b.total)
+	bar := make([]byte, progressWidth)
+	for i := range bar {
+		if i < cells {
+			bar[i] = '#'
+		} else {
+			bar[i] = '.'
+		}
+	}
+	fmt.Fprintf(b.w, "\r\033[K%s: [%s] %3d%%", b.name, bar, done*100/b.total)
+}
+
+func (b *progressBar) clear() {
+	fmt.Fprint(b.w, "\r\033[K")
+}
@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/client"
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/tag"
+	"pipelined.dev/phono/userinput"
+)
+
+var remoteServer = struct {
+	url    string
+	apiKey string
+}{}
+
+func init() {
+	encodeCmd.PersistentFlags().StringVar(&remoteServer.url, "remote", "", "encode files on phono server instead of locally, e.g. https://phono.internal")
+	encodeCmd.PersistentFlags().StringVar(&remoteServer.apiKey, "remote-api-key", "", "api key of phono server")
+}
+
+// remoteFormKeys are flags of encode commands that are sent to the
+// server as form values of the same names.
+var remoteFormKeys = []string{
+	userinput.ProcessorKey,
+	userinput.EQKey,
+	userinput.PeakNormalizeKey,
+	userinput.LoudnessKey,
+	userinput.TruePeakKey,
+	userinput.TempoKey,
+	userinput.PitchKey,
+	userinput.ResampleQualityKey,
+	userinput.DetectClippingKey,
+	userinput.FailOnClippingKey,
+	userinput.StripTagsKey,
+}
+
+// remoteLocalFlags are flags of encode commands that don't change the
+// output, so they are handled locally in remote mode.
+var remoteLocalFlags = map[string]bool{
+	"out":             true,
+	"recursive":       true,
+	"follow-symlinks": true,
+	"stall-timeout":   true,
+	"buffersize":      true,
+	"latency":         true,
+}
+
+// remoteTagKeys are form keys of tag fields accepted by the server.
+var remoteTagKeys = map[tag.Field]string{
+	tag.Title:  userinput.TitleKey,
+	tag.Artist: userinput.ArtistKey,
+	tag.Album:  userinput.AlbumKey,
+}
+
+// encodeRemote encodes files of paths on the phono server with the
+// format of encode command. Flags of the command are sent as form
+// values, flags that the server doesn't support are rejected. Options
+// of dirconfig files are not applied. Outputs are written into the out
+// folder or next to inputs.
+func encodeRemote(cmd *cobra.Command, command string, paths []string) {
+	if err := encodeRemoteFiles(cmd, command, paths); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
+func encodeRemoteFiles(cmd *cobra.Command, command string, paths []string) error {
+	sink, err := codec.LookupSink(cmd.Name())
+	if err != nil {
+		return err
+	}
+	form, err := remoteForm(cmd.Flags(), sink)
+	if err != nil {
+		return err
+	}
+	c, err := client.New(remoteServer.url, remoteServer.apiKey)
+	if err != nil {
+		return err
+	}
+	outDir, _ := cmd.Flags().GetString("out")
+	recursive, _ := cmd.Flags().GetBool("recursive")
+	if outDir != "" {
+		if _, err := os.Stat(outDir); err != nil {
+			return fmt.Errorf("out path doesn't exist: %w", err)
+		}
+	}
+
+	// conversions are cancelled on interrupt, so partial outputs are
+	// removed before exit
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	onInterrupt(cancelFn)
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil {
+				log.Printf("Error during walk: %v\n", err)
+				return nil
+			}
+			if fi.IsDir() {
+				if !recursive && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fileformat.FormatByPath(path) == nil && !container.MatchPath(path) {
+				// file is not supported, skip
+				return nil
+			}
+			dir := outDir
+			if dir == "" {
+				dir = filepath.Dir(path)
+			}
+			outFilename := filepath.Join(dir, outName("", command, sink.Extension))
+			if err := encodeRemoteFile(ctx, c, path, outFilename, form); err != nil {
+				log.Printf("Failed to encode %s: %v\n", path, err)
+				return nil
+			}
+			fmt.Printf("%s: %s\n", path, outFilename)
+			return nil
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// encodeRemoteFile uploads the file of path and writes the result into
+// the output file. Partial output is removed if conversion fails.
+func encodeRemoteFile(ctx context.Context, c *client.Client, path, outFilename string, form url.Values) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(outFilename)
+	if err != nil {
+		return err
+	}
+	err = c.Encode(ctx, filepath.Base(path), in, form, out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outFilename)
+		var clientErr *client.Error
+		if errors.As(err, &clientErr) && clientErr.Code != "" {
+			return fmt.Errorf("%s: %s", clientErr.Code, clientErr.Message)
+		}
+		return err
+	}
+	return nil
+}
+
+// remoteForm returns the encode form of flags of the sink command.
+// Provided flags must be supported by the server.
+func remoteForm(flags *pflag.FlagSet, sink codec.Sink) (url.Values, error) {
+	form := url.Values{userinput.FormatKey: {sink.Extension}}
+	handled := make(map[string]bool)
+	for k := range remoteLocalFlags {
+		handled[k] = true
+	}
+	for _, o := range sink.Options {
+		handled[o.Name] = true
+	}
+	remoteSinkForm(flags, sink, form)
+	for _, key := range remoteFormKeys {
+		handled[key] = true
+		f := flags.Lookup(key)
+		if f == nil || !f.Changed {
+			continue
+		}
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			form[key] = s.GetSlice()
+			continue
+		}
+		form.Set(key, f.Value.String())
+	}
+	if f := flags.Lookup("tag"); f != nil && f.Changed {
+		handled["tag"] = true
+		edit, err := tag.ParseEdit(f.Value.(pflag.SliceValue).GetSlice(), "")
+		if err != nil {
+			return nil, err
+		}
+		for field, v := range edit.Set {
+			key, ok := remoteTagKeys[field]
+			if !ok {
+				return nil, fmt.Errorf("tag %s is not supported in remote mode", field)
+			}
+			form.Set(key, v)
+		}
+	}
+	var err error
+	flags.Visit(func(f *pflag.Flag) {
+		if err == nil && !handled[f.Name] && !isPersistent(f) {
+			err = fmt.Errorf("flag --%s is not supported in remote mode", f.Name)
+		}
+	})
+	return form, err
+}
+
+// remoteSinkForm sets form values of sink options. Dedicated wav and mp3
+// fields are used for built-in sinks, options of other sinks are
+// prefixed with their extension.
+func remoteSinkForm(flags *pflag.FlagSet, sink codec.Sink, form url.Values) {
+	value := func(name string) string {
+		if f := flags.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	switch sink.Name {
+	case "wav":
+		form.Set("wav-bit-depth", value("bitdepth"))
+	case "mp3":
+		mode := strings.ToUpper(value("bitratemode"))
+		form.Set("mp3-bit-rate-mode", mode)
+		form.Set("mp3-channel-mode", value("channelmode"))
+		if mode == codec.MP3VBR {
+			form.Set("mp3-vbr-quality", value("bitrate"))
+		} else {
+			form.Set("mp3-bit-rate", value("bitrate"))
+		}
+		if f := flags.Lookup("quality"); f != nil && f.Changed {
+			form.Set("mp3-use-quality", "true")
+			form.Set("mp3-quality", f.Value.String())
+		}
+	default:
+		prefix := strings.TrimPrefix(sink.Extension, ".") + "-"
+		for _, o := range sink.Options {
+			if v := value(o.Name); v != "" {
+				form.Set(prefix+o.Name, v)
+			}
+		}
+	}
+}
+
+// isPersistent returns true if the flag is inherited from parent
+// commands, such flags don't change the output.
+func isPersistent(f *pflag.Flag) bool {
+	return encodeCmd.PersistentFlags().Lookup(f.Name) == f || rootCmd.PersistentFlags().Lookup(f.Name) == f
+}
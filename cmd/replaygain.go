@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+)
+
+var (
+	replayGain = struct {
+		album        bool
+		dryRun       bool
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	replayGainCmd = &cobra.Command{
+		Use:                   "replaygain [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Compute ReplayGain of audio files and write it into tags",
+		Long: "Compute ReplayGain 2.0 track gain and peak of every file and album gain and peak of\n" +
+			"all files, then write them into tags of mp3 and flac files in place. Gain brings\n" +
+			"the loudness to -18 LUFS, peak is the sample peak where 1 is full scale.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, replayGain.bufferSize, replayGain.latency)
+			if err != nil {
+				return err
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+
+			var (
+				failed  int
+				scanner = encode.NewReplayGainScanner()
+				paths   = make([]string, 0, len(args))
+				gains   = make([]encode.ReplayGain, 0, len(args))
+			)
+			for _, path := range args {
+				g, err := scanReplayGain(ctx, scanner, path, b, replayGain.stallTimeout)
+				if err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					failed++
+					fmt.Printf("%s: %v\n", path, err)
+					continue
+				}
+				paths = append(paths, path)
+				gains = append(gains, g)
+			}
+			album := scanner.Album()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PATH\tGAIN\tPEAK")
+			for i, path := range paths {
+				fmt.Fprintf(w, "%s\t%+.2f dB\t%.6f\n", path, gains[i].Gain, gains[i].Peak)
+			}
+			if replayGain.album && len(paths) > 0 {
+				fmt.Fprintf(w, "album\t%+.2f dB\t%.6f\n", album.Gain, album.Peak)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if !replayGain.dryRun {
+				for i, path := range paths {
+					set := tag.Tags{}
+					set.SetReplayGain(false, gains[i].Gain, gains[i].Peak)
+					if replayGain.album {
+						set.SetReplayGain(true, album.Gain, album.Peak)
+					}
+					if _, err := tagFile(path, tag.Edit{Set: set}); err != nil {
+						failed++
+						fmt.Printf("%s: %v\n", path, err)
+					}
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to process %d of %d files", failed, len(args))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(replayGainCmd)
+	replayGainCmd.Flags().BoolVar(&replayGain.album, "album", true, "compute album gain of all files")
+	replayGainCmd.Flags().BoolVar(&replayGain.dryRun, "dry-run", false, "print gains without writing tags")
+	bufferSizeVar(replayGainCmd.Flags(), &replayGain.bufferSize)
+	replayGainCmd.Flags().StringVar(&replayGain.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	replayGainCmd.Flags().DurationVar(&replayGain.stallTimeout, "stall-timeout", time.Minute, "cancel scan if it makes no progress, disabled if zero")
+	replayGainCmd.Flags().SortFlags = false
+}
+
+// scanReplayGain measures ReplayGain of the file with the scanner. Files
+// that can't store ReplayGain tags are rejected unless it's a dry run.
+func scanReplayGain(ctx context.Context, scanner *encode.ReplayGainScanner, path string, b encode.Buffering, stallTimeout time.Duration) (encode.ReplayGain, error) {
+	if !replayGain.dryRun && !tag.Supported(fileformat.FormatByPath(path), tag.TrackGain) {
+		return encode.ReplayGain{}, fmt.Errorf("format can't store ReplayGain tags")
+	}
+	in, format, closeFn, err := openAudio(path)
+	if err != nil {
+		return encode.ReplayGain{}, err
+	}
+	defer closeFn()
+	g, err := scanner.Scan(ctx, b.BufferSize(format, nil), stallTimeout, format, in)
+	if err != nil {
+		return encode.ReplayGain{}, fmt.Errorf("failed to scan: %s: %v", encode.Code(err), err)
+	}
+	return g, nil
+}
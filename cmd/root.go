@@ -2,13 +2,29 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
 )
 
+// enableExperimental allows to use experimental features.
+var enableExperimental bool
+
+// mmapThreshold is the size of local input files that are mapped into
+// memory, disabled if zero.
+var mmapThreshold sizeFlag = 64 << 20
+
+// maxCPU is the share of all CPUs used by pipes, not limited if zero.
+var maxCPU cpuFlag
+
+// nice lowers the scheduling priority of the process.
+var nice bool
+
 var rootCmd = &cobra.Command{
 	Use:   "phono",
 	Short: "DSP pipeline",
@@ -17,14 +33,46 @@ var rootCmd = &cobra.Command{
 	},
 }
 
-// Execute the root comand.
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&enableExperimental, "enable-experimental", false, "enable experimental processors")
+	rootCmd.PersistentFlags().Var(&mmapThreshold, "mmap-threshold", "map local input files of the size or larger into memory, disabled if zero")
+	rootCmd.PersistentFlags().Var(&maxCPU, "max-cpu", "share of all CPUs used by encoding, e.g. 50%, not limited if zero")
+	rootCmd.PersistentFlags().BoolVar(&nice, "nice", false, "run with the lowest scheduling priority, so other services are not starved")
+}
+
+// Execute the root comand. Plugins are loaded first, so their codecs get
+// encode commands.
 func Execute() {
+	paths, err := pluginFlags(os.Args[1:])
+	if err == nil {
+		err = loadPlugins(paths)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	addCodecCommands()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 }
 
+// limitCPU lowers the priority of the process and limits CPU used by
+// pipes if it's configured with flags.
+func limitCPU() error {
+	if nice {
+		if err := lowerPriority(); err != nil {
+			return fmt.Errorf("failed to lower priority: %w", err)
+		}
+	}
+	if maxCPU > 0 {
+		procs, duty := encode.LimitCPU(float64(maxCPU))
+		log.Printf("CPU limited to %v: %d threads running %.0f%% of time", &maxCPU, procs, duty*100)
+	}
+	return nil
+}
+
 func onInterrupt(onInterrupt func()) <-chan struct{} {
 	interrupt := make(chan struct{})
 	sigint := make(chan os.Signal, 1)
@@ -35,6 +83,8 @@ func onInterrupt(onInterrupt func()) <-chan struct{} {
 	go func() {
 		// block until signal received
 		<-sigint
+		// next signal terminates the process if shutdown hangs
+		signal.Stop(sigint)
 		onInterrupt()
 		close(interrupt)
 	}()
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"pipelined.dev/phono/encode"
+)
+
+var rtpFlags = struct {
+	addr        string
+	payloadType uint8
+}{}
+
+func init() {
+	encodeCmd.PersistentFlags().StringVar(&rtpFlags.addr, "rtp-addr", "", "stream running conversions as RTP with L16 payload over UDP to the address, e.g. 239.0.0.1:5004.\nConversions are slowed down to real time")
+	encodeCmd.PersistentFlags().Uint8Var(&rtpFlags.payloadType, "rtp-payload-type", 96, "RTP payload type, 10 and 11 are static types of 44.1 kHz stereo and mono")
+}
+
+// udpWriter sends every write as the datagram to the address.
+type udpWriter struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+// newRTPStream returns the stream of rtp flags, nil is returned if
+// streaming is disabled.
+func newRTPStream() (*encode.RTPStream, error) {
+	if rtpFlags.addr == "" {
+		return nil, nil
+	}
+	if rtpFlags.payloadType > 127 {
+		return nil, fmt.Errorf("RTP payload type %d is not supported", rtpFlags.payloadType)
+	}
+	addr, err := net.ResolveUDPAddr("udp", rtpFlags.addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTP address: %w", err)
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream RTP: %w", err)
+	}
+	log.Printf("RTP is streamed to %s", addr)
+	return encode.NewRTPStream(&udpWriter{conn: conn, addr: addr}, rtpFlags.payloadType), nil
+}
+
+func (w *udpWriter) Write(b []byte) (int, error) {
+	return w.conn.WriteTo(b, w.addr)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/pipeline"
+	"pipelined.dev/phono/processor"
+)
+
+var (
+	run = struct {
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	runCmd = &cobra.Command{
+		Use:                   "run [flags] pipeline.yaml...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Run pipelines defined in YAML or JSON files",
+		Long: "Run pipelines that declare the pump, ordered processors and one or more sinks, e.g.\n\n" +
+			"  pump:\n" +
+			"    path: in.flac\n" +
+			"  processors:\n" +
+			"    - name: limiter\n" +
+			"      params:\n" +
+			"        ceiling: -1\n" +
+			"  sinks:\n" +
+			"    - path: out.mp3\n" +
+			"      options:\n" +
+			"        bitratemode: cbr\n" +
+			"        bitrate: 320\n" +
+			"    - path: out.wav\n\n" +
+			"Relative paths are resolved against the directory of the pipeline file. All\n" +
+			"pipelines are validated before the first one runs.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, run.bufferSize, run.latency)
+			if err != nil {
+				return err
+			}
+			pipelines := make([]*pipeline.Pipeline, 0, len(args))
+			for _, path := range args {
+				d, err := pipeline.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				p, err := d.Build(enableExperimental, func(p processor.Processor) {
+					log.Printf("Warning: processor %s is experimental", p.Name)
+				})
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				pipelines = append(pipelines, p)
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			for i, p := range pipelines {
+				start := time.Now()
+				if err := p.Run(ctx, b, run.stallTimeout); err != nil {
+					return fmt.Errorf("%s: %s: %w", args[i], encode.Code(err), err)
+				}
+				fmt.Printf("%s: done in %v\n", args[i], time.Since(start).Round(time.Millisecond))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	bufferSizeVar(runCmd.Flags(), &run.bufferSize)
+	runCmd.Flags().StringVar(&run.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	runCmd.Flags().DurationVar(&run.stallTimeout, "stall-timeout", time.Minute, "cancel pipeline if it makes no progress, disabled if zero")
+	runCmd.Flags().SortFlags = false
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+// sizeFlag is the flag value of size in bytes with optional unit, e.g.
+// 500M or 2G.
+type sizeFlag int64
+
+func (s *sizeFlag) Set(v string) error {
+	size, err := userinput.ParseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(size)
+	return nil
+}
+
+func (s *sizeFlag) String() string {
+	return userinput.FormatSize(int64(*s))
+}
+
+func (s *sizeFlag) Type() string {
+	return "size"
+}
+
+// autoBufferSize is the value of buffer size flag that enables automatic
+// buffer size.
+const autoBufferSize = -1
+
+// bufferSizeFlag is the flag value of buffer size in frames or auto.
+type bufferSizeFlag int
+
+// bufferSizeVar registers buffersize flag that stores its value into p.
+func bufferSizeVar(flags *pflag.FlagSet, p *int) {
+	*p = 1024
+	flags.Var((*bufferSizeFlag)(p), "buffersize", "buffer size or auto to pick it from the input, overrides latency profile")
+}
+
+func (b *bufferSizeFlag) Set(v string) error {
+	size, err := parseBufferSize(v)
+	if err != nil {
+		return err
+	}
+	*b = bufferSizeFlag(size)
+	return nil
+}
+
+func (b *bufferSizeFlag) String() string {
+	if *b == autoBufferSize {
+		return "auto"
+	}
+	return strconv.Itoa(int(*b))
+}
+
+func (b *bufferSizeFlag) Type() string {
+	return "size"
+}
+
+// parseBufferSize parses positive buffer size or auto.
+func parseBufferSize(v string) (int, error) {
+	if strings.EqualFold(strings.TrimSpace(v), "auto") {
+		return autoBufferSize, nil
+	}
+	size, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid buffer size: %s", v)
+	}
+	return size, nil
+}
+
+// dbfsFlag is the flag value of level in dBFS with optional unit, e.g.
+// -1dBFS.
+type dbfsFlag float64
+
+func (d *dbfsFlag) Set(v string) error {
+	db, err := encode.ParseDBFS(v)
+	if err != nil {
+		return err
+	}
+	*d = dbfsFlag(db)
+	return nil
+}
+
+func (d *dbfsFlag) String() string {
+	return strconv.FormatFloat(float64(*d), 'g', -1, 64)
+}
+
+func (d *dbfsFlag) Type() string {
+	return "dBFS"
+}
+
+// semitonesFlag is the flag value of pitch shift in semitones with
+// optional unit, e.g. +2st.
+type semitonesFlag float64
+
+func (s *semitonesFlag) Set(v string) error {
+	st, err := encode.ParseSemitones(v)
+	if err != nil {
+		return err
+	}
+	*s = semitonesFlag(st)
+	return nil
+}
+
+func (s *semitonesFlag) String() string {
+	return strconv.FormatFloat(float64(*s), 'g', -1, 64)
+}
+
+func (s *semitonesFlag) Type() string {
+	return "st"
+}
+
+// cpuFlag is the flag value of the share of all CPUs in percents with
+// optional unit, e.g. 50%. Zero means no limit.
+type cpuFlag float64
+
+func (c *cpuFlag) Set(v string) error {
+	if strings.TrimSuffix(strings.TrimSpace(v), "%") == "0" {
+		*c = 0
+		return nil
+	}
+	share, err := encode.ParseCPULimit(v)
+	if err != nil {
+		return err
+	}
+	*c = cpuFlag(share)
+	return nil
+}
+
+func (c *cpuFlag) String() string {
+	if *c == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(*c)*100, 'g', -1, 64) + "%"
+}
+
+func (c *cpuFlag) Type() string {
+	return "percent"
+}
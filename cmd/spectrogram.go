@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/render"
+	"pipelined.dev/phono/userinput"
+)
+
+var (
+	spectrogram = struct {
+		outPath      string
+		width        int
+		height       int
+		fftSize      int
+		window       string
+		colorMap     string
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	spectrogramCmd = &cobra.Command{
+		Use:                   "spectrogram [flags] path",
+		DisableFlagsInUseLine: true,
+		Short:                 "Render spectrogram image of audio file",
+		Long: "Render spectrogram image of audio file with short-time Fourier transform. Time goes\n" +
+			"from left to right and frequency from zero at the bottom to nyquist at the top.\n" +
+			"Image is written in png format.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if spectrogram.outPath == "" {
+				return fmt.Errorf("output file is not provided")
+			}
+			format, err := render.FormatByPath(spectrogram.outPath)
+			if err != nil {
+				return err
+			}
+			if format != render.PNG {
+				return fmt.Errorf("spectrogram is rendered in png format only")
+			}
+			window, err := render.ParseWindow(spectrogram.window)
+			if err != nil {
+				return err
+			}
+			colors, err := render.ParseColorMap(spectrogram.colorMap)
+			if err != nil {
+				return err
+			}
+			s, err := render.NewSpectrogram(spectrogram.width, spectrogram.height, spectrogram.fftSize, window, colors)
+			if err != nil {
+				return err
+			}
+			b, err := buffering(cmd, spectrogram.bufferSize, spectrogram.latency)
+			if err != nil {
+				return err
+			}
+			return renderCLI(args[0], spectrogram.outPath, format, b, spectrogram.stallTimeout, s)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(spectrogramCmd)
+	spectrogramCmd.Flags().StringVarP(&spectrogram.outPath, "out", "o", "", "output png file")
+	spectrogramCmd.Flags().IntVar(&spectrogram.width, "width", userinput.DefaultWidth, "image width in pixels")
+	spectrogramCmd.Flags().IntVar(&spectrogram.height, "height", userinput.DefaultHeight, "image height in pixels")
+	spectrogramCmd.Flags().IntVar(&spectrogram.fftSize, "fft-size", render.DefaultFFTSize, "size of transform, power of two")
+	spectrogramCmd.Flags().StringVar(&spectrogram.window, "window", string(render.DefaultWindow), "window function: hann, hamming, blackman or rectangular")
+	spectrogramCmd.Flags().StringVar(&spectrogram.colorMap, "colormap", string(render.DefaultColorMap), "color map: gray, inferno or viridis")
+	bufferSizeVar(spectrogramCmd.Flags(), &spectrogram.bufferSize)
+	spectrogramCmd.Flags().StringVar(&spectrogram.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	spectrogramCmd.Flags().DurationVar(&spectrogram.stallTimeout, "stall-timeout", time.Minute, "cancel rendering if it makes no progress, disabled if zero")
+	spectrogramCmd.Flags().SortFlags = false
+}
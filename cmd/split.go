@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/cue"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+	"pipelined.dev/phono/userinput"
+)
+
+// placeholders are fields of the output name template.
+var placeholders = regexp.MustCompile(`\{[a-z_]+\}`)
+
+var (
+	split = struct {
+		outPath      string
+		format       string
+		name         string
+		bitDepth     int
+		channelMode  int
+		bitRateMode  string
+		bitRate      int
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	splitCmd = &cobra.Command{
+		Use:                   "split [flags] sheet.cue...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Split disc images into tracks with cue sheets",
+		Long: "Split flac, wav or mp3 images into tracks described by cue sheets. Every track is\n" +
+			"written into a file named with the template and tagged with fields of the sheet.\n" +
+			"Images are looked up next to the sheet, files with the same name and a different\n" +
+			"extension are used if the sheet refers to a missing one.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outFormat, sink, err := splitSink()
+			if err != nil {
+				return err
+			}
+			if _, err := tag.ParseTemplate(split.name); err != nil {
+				return err
+			}
+			b, err := buffering(cmd, split.bufferSize, split.latency)
+			if err != nil {
+				return err
+			}
+			if split.outPath != "" {
+				if _, err := os.Stat(split.outPath); err != nil {
+					return err
+				}
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			var failed int
+			for _, path := range args {
+				if err := splitSheet(ctx, path, outFormat, sink, b); err != nil {
+					if ctx.Err() != nil {
+						return err
+					}
+					failed++
+					fmt.Printf("%s: %v\n", path, err)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to split %d of %d sheets", failed, len(args))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(splitCmd)
+	splitCmd.Flags().StringVar(&split.outPath, "out", "", "output folder, the folder of the sheet is used if not specified")
+	splitCmd.Flags().StringVar(&split.format, "format", "wav", "output format:\nwav\nmp3")
+	splitCmd.Flags().StringVar(&split.name, "name", "{track} - {title}", "output file name template, e.g. \"{track} {artist} - {title}\"")
+	splitCmd.Flags().IntVar(&split.bitDepth, "bitdepth", 24, "bit depth of wav output")
+	splitCmd.Flags().IntVar(&split.channelMode, "channelmode", 2, "channel mode of mp3 output:\n0 - mono\n1 - stereo\n2 - joint stereo")
+	splitCmd.Flags().StringVar(&split.bitRateMode, "bitratemode", "vbr", "bit rate mode of mp3 output:\ncbr - constant bit rate\nabr - average bit rate\nvbr - variable bit rate")
+	splitCmd.Flags().IntVar(&split.bitRate, "bitrate", 4, "bit rate of mp3 output:\n[8..320] for cbr and abr\n[0..9] for vbr")
+	bufferSizeVar(splitCmd.Flags(), &split.bufferSize)
+	splitCmd.Flags().StringVar(&split.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	splitCmd.Flags().DurationVar(&split.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
+	splitCmd.Flags().SortFlags = false
+}
+
+// splitSink returns the output format and its sink configured with flags.
+func splitSink() (*fileformat.Format, userinput.Sink, error) {
+	switch strings.ToLower(split.format) {
+	case "wav":
+		sink, err := userinput.WAV.Sink(split.bitDepth)
+		return fileformat.WAV(), sink, err
+	case "mp3":
+		sink, err := userinput.MP3.Sink(split.bitRateMode, split.bitRate, split.channelMode, false, 0)
+		return fileformat.MP3(), sink, err
+	}
+	return nil, nil, fmt.Errorf("unsupported output format: %s", split.format)
+}
+
+// splitSheet splits images of the cue sheet into tracks. Every image is
+// decoded once, outputs of failed images are removed.
+func splitSheet(ctx context.Context, path string, outFormat *fileformat.Format, sink userinput.Sink, b encode.Buffering) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	sheet, err := cue.Parse(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("invalid cue sheet: %v", err)
+	}
+	outDir := split.outPath
+	if outDir == "" {
+		outDir = filepath.Dir(path)
+	}
+	// consecutive tracks of the same image
+	for first := 0; first < len(sheet.Tracks); {
+		last := first + 1
+		for last < len(sheet.Tracks) && sheet.Tracks[last].File == sheet.Tracks[first].File {
+			last++
+		}
+		if err := splitImage(ctx, sheet, first, last, filepath.Dir(path), outDir, outFormat, sink, b); err != nil {
+			return err
+		}
+		first = last
+	}
+	return nil
+}
+
+// splitImage writes tracks from first to last of the sheet into output
+// files. Tracks must refer to the same image.
+func splitImage(ctx context.Context, sheet *cue.Sheet, first, last int, dir, outDir string, outFormat *fileformat.Format, sink userinput.Sink, b encode.Buffering) error {
+	image, err := imagePath(dir, sheet.Tracks[first].File)
+	if err != nil {
+		return err
+	}
+	in, format, closeFn, err := openAudio(image)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	starts := make([]time.Duration, 0, last-first)
+	for _, t := range sheet.Tracks[first:last] {
+		starts = append(starts, t.Start)
+	}
+	var outputs []*os.File
+	defer func() {
+		for _, out := range outputs {
+			out.Close()
+		}
+	}()
+	splitter := encode.Split(starts, func(i int) (pipe.SinkAllocatorFunc, error) {
+		tags := sheet.TrackTags(first + i)
+		name := trackName(split.name, tags, sheet.Tracks[first+i].Number) + outFormat.DefaultExtension()
+		out, err := os.Create(filepath.Join(outDir, name))
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, out)
+		return tag.Sink(sink(out), outFormat, out, tags), nil
+	})
+	if err := encode.Run(ctx, b.InputBufferSize(format, outFormat, in, false), split.stallTimeout, format.Source(in), splitter); err != nil {
+		for _, out := range outputs {
+			out.Close()
+			os.Remove(out.Name())
+		}
+		return fmt.Errorf("failed to split %s: %s: %v", image, encode.Code(err), err)
+	}
+	for _, out := range outputs {
+		if err := out.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s\n", image, out.Name())
+	}
+	return nil
+}
+
+// imagePath returns the path of the image referred by the sheet in dir.
+// If it doesn't exist, a file with the same name and extension of
+// supported format is returned.
+func imagePath(dir, file string) (string, error) {
+	path := filepath.FromSlash(strings.Replace(file, "\\", "/", -1))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, format := range []*fileformat.Format{fileformat.FLAC(), fileformat.WAV(), fileformat.MP3()} {
+		if _, err := os.Stat(base + format.DefaultExtension()); err == nil {
+			return base + format.DefaultExtension(), nil
+		}
+	}
+	return "", fmt.Errorf("image not found: %s", path)
+}
+
+// trackName fills the template with fields of the track. Track number is
+// padded to two digits, characters that aren't allowed in file names are
+// replaced with underscores.
+func trackName(template string, t tag.Tags, number int) string {
+	name := placeholders.ReplaceAllStringFunc(template, func(p string) string {
+		f, err := tag.ParseField(p[1 : len(p)-1])
+		if err != nil {
+			return p
+		}
+		if f == tag.Track {
+			return fmt.Sprintf("%02d", number)
+		}
+		if v, ok := t[f]; ok {
+			return v
+		}
+		return "unknown"
+	})
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"pipelined.dev/phono/storage"
+)
+
+var remote = struct {
+	sftpKey        string
+	sftpKnownHosts string
+	password       string
+	passwordHosts  string
+	timeout        time.Duration
+	maxIdle        int
+}{}
+
+func init() {
+	remoteFlags(encodeCmd.PersistentFlags())
+}
+
+// remoteFlags adds flags of remote storage backends to the flag set.
+func remoteFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&remote.sftpKey, "sftp-key", "", "private key file to log in to sftp servers")
+	flags.StringVar(&remote.sftpKnownHosts, "sftp-known-hosts", "", "known hosts file to verify sftp servers, ~/.ssh/known_hosts is used if empty")
+	flags.StringVar(&remote.password, "remote-password", "", "password of sftp and ftp servers if the url doesn't have one, requires --remote-password-hosts")
+	flags.StringVar(&remote.passwordHosts, "remote-password-hosts", "", "comma-separated list of hosts the remote password is sent to")
+	flags.DurationVar(&remote.timeout, "remote-timeout", 30*time.Second, "timeout to connect to sftp and ftp servers")
+	flags.IntVar(&remote.maxIdle, "remote-max-idle", 2, "max number of idle connections kept for every sftp and ftp server and user")
+}
+
+// newStorage returns the storage of remote inputs and outputs with
+// backends of all registered schemes configured with remote flags.
+func newStorage() (storage.Storage, error) {
+	o := storage.Options{
+		Password: remote.password,
+		Timeout:  remote.timeout,
+		MaxIdle:  remote.maxIdle,
+	}
+	if remote.passwordHosts != "" {
+		o.PasswordHosts = strings.Split(remote.passwordHosts, ",")
+	}
+	if o.Password != "" && len(o.PasswordHosts) == 0 {
+		return nil, fmt.Errorf("--remote-password requires --remote-password-hosts")
+	}
+	if err := sshOptions(&o); err != nil {
+		return nil, err
+	}
+	return storage.New(o)
+}
+
+// sshOptions sets the key and known hosts of flags. Missing default known
+// hosts file fails only ssh connections.
+func sshOptions(o *storage.Options) error {
+	if remote.sftpKey != "" {
+		key, err := ioutil.ReadFile(remote.sftpKey)
+		if err != nil {
+			return fmt.Errorf("failed to read sftp key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return fmt.Errorf("invalid sftp key %s: %w", remote.sftpKey, err)
+		}
+		o.Signers = []ssh.Signer{signer}
+	}
+	knownHosts := remote.sftpKnownHosts
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		if _, err := os.Stat(knownHosts); os.IsNotExist(err) {
+			o.HostKeyCallback = func(string, net.Addr, ssh.PublicKey) error {
+				return fmt.Errorf("host key cannot be verified: %s doesn't exist", knownHosts)
+			}
+			return nil
+		}
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return fmt.Errorf("failed to read sftp known hosts: %w", err)
+	}
+	o.HostKeyCallback = callback
+	return nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/tag"
+)
+
+var (
+	tagging = struct {
+		tags     []string
+		fromName string
+	}{}
+	tagCmd = &cobra.Command{
+		Use:                   "tag [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Edit metadata tags of audio files in place",
+		Long: "Edit metadata tags of mp3, wav and flac files in place. Fields are parsed from file\n" +
+			"names with the template first and then assigned with tag flags, empty value removes\n" +
+			"the field. Fields: title, artist, album, album_artist, composer, genre, track, disc,\n" +
+			"date (year), comment, bpm, key and copyright.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			edit, err := tag.ParseEdit(tagging.tags, tagging.fromName)
+			if err != nil {
+				return err
+			}
+			if edit.Empty() {
+				return fmt.Errorf("no tags to set, use --tag or --tag-from-name")
+			}
+			var failed int
+			for _, path := range args {
+				t, err := tagFile(path, edit)
+				if err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", path, err)
+					continue
+				}
+				fmt.Printf("%s: %s\n", path, formatTags(t))
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to tag %d of %d files", failed, len(args))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+	tagCmd.Flags().StringArrayVar(&tagging.tags, "tag", nil, "set field, can be repeated:\nfield=value")
+	tagCmd.Flags().StringVar(&tagging.fromName, "tag-from-name", "", "parse fields from file name, e.g. \"{artist} - {title}\"")
+	tagCmd.Flags().SortFlags = false
+}
+
+// tagFile applies the edit to the tags of the file. The file is rewritten
+// into temp file in the same directory which then replaces the original.
+// Files with unchanged tags aren't rewritten.
+func tagFile(path string, edit tag.Edit) (tag.Tags, error) {
+	format := fileformat.FormatByPath(path)
+	if format == nil {
+		return nil, fmt.Errorf("unsupported format")
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	stat, err := in.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t, err := tag.Read(format, in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %v", err)
+	}
+	edited := edit.Apply(t, path)
+	if reflect.DeepEqual(edited, t) {
+		return t, nil
+	}
+	t = edited
+
+	out, err := ioutil.TempFile(filepath.Dir(path), ".phono-tag-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if err := tag.Write(format, in, out, t); err != nil {
+		return nil, fmt.Errorf("failed to write tags: %v", err)
+	}
+	if err := out.Chmod(stat.Mode()); err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return t, os.Rename(out.Name(), path)
+}
+
+// formatTags returns sorted fields and their values.
+func formatTags(t tag.Tags) string {
+	if len(t) == 0 {
+		return "no tags"
+	}
+	values := make([]string, 0, len(t))
+	for f, v := range t {
+		values = append(values, fmt.Sprintf("%s=%q", f, v))
+	}
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
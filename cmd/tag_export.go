@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+)
+
+var (
+	tagExport = struct {
+		format string
+	}{}
+	tagExportCmd = &cobra.Command{
+		Use:                   "export [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Export tags and technical info of audio files",
+		Long: "Walk paths recursively and print the manifest of mp3, wav and flac files with their\n" +
+			"format, sample rate, channels, bit depth, duration, bitrate and tags. The manifest\n" +
+			"can be edited and applied with tag import.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if tagExport.format != tag.ManifestCSV && tagExport.format != tag.ManifestJSON {
+				return fmt.Errorf("unsupported manifest format: %s", tagExport.format)
+			}
+			var (
+				entries []manifestEntry
+				failed  int
+			)
+			for _, root := range args {
+				err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+					if err != nil {
+						log.Printf("Error during walk: %v\n", err)
+						failed++
+						return nil
+					}
+					if fi.IsDir() {
+						return nil
+					}
+					format := fileformat.FormatByPath(path)
+					if format == nil {
+						return nil
+					}
+					e, err := exportEntry(path, format, fi.Size())
+					if err != nil {
+						log.Printf("%s: %v\n", path, err)
+						failed++
+						return nil
+					}
+					entries = append(entries, e)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			var err error
+			if tagExport.format == tag.ManifestJSON {
+				e := json.NewEncoder(os.Stdout)
+				e.SetIndent("", "  ")
+				err = e.Encode(entries)
+			} else {
+				err = writeManifestCSV(os.Stdout, entries)
+			}
+			if err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to export %d files", failed)
+			}
+			return nil
+		},
+	}
+)
+
+// manifestEntry is the exported file with its info and tags.
+type manifestEntry struct {
+	Path string `json:"path"`
+	encode.Info
+	Tags tag.Tags `json:"tags"`
+}
+
+func init() {
+	tagCmd.AddCommand(tagExportCmd)
+	tagExportCmd.Flags().StringVar(&tagExport.format, "format", tag.ManifestCSV, "manifest format:\ncsv\njson")
+	tagExportCmd.Flags().SortFlags = false
+}
+
+// exportEntry reads headers and tags of the file.
+func exportEntry(path string, format *fileformat.Format, size int64) (manifestEntry, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	defer in.Close()
+	info, err := encode.Probe(format, in, size)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	t, err := tag.Read(format, in)
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("failed to read tags: %v", err)
+	}
+	return manifestEntry{Path: path, Info: info, Tags: t}, nil
+}
+
+// writeManifestCSV writes entries with the header. Technical info goes
+// before fields.
+func writeManifestCSV(w io.Writer, entries []manifestEntry) error {
+	fields := tag.Fields()
+	header := []string{"path", "format", "sample_rate", "channels", "bit_depth", "duration", "bitrate"}
+	for _, f := range fields {
+		header = append(header, string(f))
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{
+			e.Path,
+			e.Format,
+			strconv.Itoa(e.SampleRate),
+			strconv.Itoa(e.Channels),
+			strconv.Itoa(e.BitDepth),
+			strconv.FormatFloat(e.Duration, 'f', 3, 64),
+			strconv.Itoa(e.Bitrate),
+		}
+		for _, f := range fields {
+			record = append(record, e.Tags[f])
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/tag"
+)
+
+var (
+	tagImport = struct {
+		format string
+	}{}
+	tagImportCmd = &cobra.Command{
+		Use:                   "import [flags] manifest",
+		DisableFlagsInUseLine: true,
+		Short:                 "Apply tags of the manifest to audio files",
+		Long: "Apply tags of the CSV or JSON manifest exported with tag export. Fields of the\n" +
+			"manifest replace tags of files, empty values remove fields and fields missing from\n" +
+			"the manifest are kept. Relative paths are resolved against the working directory.",
+		Args:          cobra.ExactArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format := tagImport.format
+			if format == "" {
+				format = strings.TrimPrefix(filepath.Ext(args[0]), ".")
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			entries, err := tag.ReadManifest(f, format)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("invalid manifest: %v", err)
+			}
+			var failed int
+			for i, e := range entries {
+				if e.Path == "" {
+					failed++
+					fmt.Printf("entry %d: no path\n", i+1)
+					continue
+				}
+				t, err := tagFile(e.Path, tag.Edit{Set: e.Tags})
+				if err != nil {
+					failed++
+					fmt.Printf("%s: %v\n", e.Path, err)
+					continue
+				}
+				fmt.Printf("%s: %s\n", e.Path, formatTags(t))
+			}
+			if failed > 0 {
+				return fmt.Errorf("failed to tag %d of %d files", failed, len(entries))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	tagCmd.AddCommand(tagImportCmd)
+	tagImportCmd.Flags().StringVar(&tagImport.format, "format", "", "manifest format, detected by extension if not set:\ncsv\njson")
+	tagImportCmd.Flags().SortFlags = false
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/dirconfig"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+var (
+	trimSilence = struct {
+		outPath      string
+		recursive    bool
+		symlinks     bool
+		bufferSize   int
+		stallTimeout time.Duration
+		threshold    dbfsFlag
+		minDuration  time.Duration
+		latency      string
+		bitDepth     int
+	}{
+		threshold: -60,
+	}
+	trimSilenceCmd = &cobra.Command{
+		Use:                   "trim-silence [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Trim silence at head and tail of audio files",
+		Long: "Trim silence at head and tail of audio files in two passes: the first one finds\n" +
+			"the sound and the second one drops the silence while the file is encoded to wav format.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// validate flags before walking the tree
+			if _, err := trimSilenceEncoder(cmd, dirconfig.Options{}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+			// conversions are cancelled on interrupt, so partial outputs
+			// are removed before exit
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			if err := encodeCLI(ctx, args, cliOptions{
+				command:        "phono-trim-silence",
+				recursive:      trimSilence.recursive,
+				followSymlinks: trimSilence.symlinks,
+				outDir:         trimSilence.outPath,
+				stallTimeout:   trimSilence.stallTimeout,
+				output:         outputOf(fileformat.WAV()),
+				encoder: func(opts dirconfig.Options) (cliEncoder, error) {
+					return trimSilenceEncoder(cmd, opts)
+				},
+			}); err != nil {
+				log.Print(err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(trimSilenceCmd)
+	trimSilenceCmd.Flags().Var(&trimSilence.threshold, "threshold", "level of silence, e.g. -60dBFS")
+	trimSilenceCmd.Flags().DurationVar(&trimSilence.minDuration, "min-duration", 0, "trim only silence that lasts at least this long")
+	trimSilenceCmd.Flags().StringVar(&trimSilence.outPath, "out", "", "output folder, the userinput folder is used if not specified")
+	bufferSizeVar(trimSilenceCmd.Flags(), &trimSilence.bufferSize)
+	trimSilenceCmd.Flags().StringVar(&trimSilence.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	trimSilenceCmd.Flags().IntVar(&trimSilence.bitDepth, "bitdepth", 24, "bit depth")
+	trimSilenceCmd.Flags().DurationVar(&trimSilence.stallTimeout, "stall-timeout", time.Minute, "cancel conversion if it makes no progress, disabled if zero")
+	trimSilenceCmd.Flags().BoolVar(&trimSilence.recursive, "recursive", false, "process paths recursive")
+	trimSilenceCmd.Flags().BoolVar(&trimSilence.symlinks, "follow-symlinks", false, "follow symlinks inside paths, they are skipped if not set")
+	trimSilenceCmd.Flags().SortFlags = false
+}
+
+// trimSilenceEncoder returns wav encoder with silence trimming configured
+// with flags overridden by directory options.
+func trimSilenceEncoder(cmd *cobra.Command, opts dirconfig.Options) (cliEncoder, error) {
+	if err := opts.Check("buffersize", "latency", "bitdepth", "threshold", "min-duration"); err != nil {
+		return cliEncoder{}, err
+	}
+	threshold := float64(trimSilence.threshold)
+	if opts.Has("threshold") {
+		s, err := opts.String("threshold", "")
+		if err != nil {
+			return cliEncoder{}, err
+		}
+		if threshold, err = encode.ParseDBFS(s); err != nil {
+			return cliEncoder{}, fmt.Errorf("option threshold: %w", err)
+		}
+	}
+	minDuration, err := opts.Duration("min-duration", trimSilence.minDuration)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	if err := encode.CheckSilenceThreshold(threshold, minDuration); err != nil {
+		return cliEncoder{}, err
+	}
+	bitDepth, err := opts.Int("bitdepth", trimSilence.bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	sink, err := userinput.WAV.Sink(bitDepth)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	b, err := dirBuffering(cmd, opts, trimSilence.bufferSize, trimSilence.latency)
+	if err != nil {
+		return cliEncoder{}, err
+	}
+	return cliEncoder{
+		buffering:        b,
+		sink:             sink,
+		trimSilence:      true,
+		silenceThreshold: threshold,
+		silenceMin:       minDuration,
+		bitDepth:         signal.BitDepth(bitDepth),
+		dither:           encode.DitherAuto,
+		desc:             fmt.Sprintf("%dbit, silence under %gdBFS trimmed", bitDepth, threshold),
+	}, nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+)
+
+var (
+	verify = struct {
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	verifyCmd = &cobra.Command{
+		Use:                   "verify [flags] path...",
+		DisableFlagsInUseLine: true,
+		Short:                 "Verify integrity of audio files",
+		Long: "Fully decode audio files and report decode errors, truncation and MD5 mismatches of\n" +
+			"flac files. Exit code is non-zero if any file fails verification.",
+		Args:          cobra.MinimumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := buffering(cmd, verify.bufferSize, verify.latency)
+			if err != nil {
+				return err
+			}
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			onInterrupt(cancelFn)
+			var failed int
+			for _, path := range args {
+				v, err := verifyFile(ctx, path, b, verify.stallTimeout)
+				if err != nil {
+					failed++
+					fmt.Printf("%s: %s: %v\n", path, encode.Code(err), err)
+					continue
+				}
+				fmt.Printf("%s: %v\n", path, v)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files failed verification", failed, len(args))
+			}
+			return nil
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	bufferSizeVar(verifyCmd.Flags(), &verify.bufferSize)
+	verifyCmd.Flags().StringVar(&verify.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	verifyCmd.Flags().DurationVar(&verify.stallTimeout, "stall-timeout", time.Minute, "cancel verification if it makes no progress, disabled if zero")
+	verifyCmd.Flags().SortFlags = false
+}
+
+// verifyFile fully decodes the file and checks the result.
+func verifyFile(ctx context.Context, path string, b encode.Buffering, stallTimeout time.Duration) (encode.Verification, error) {
+	in, format, closeFn, err := openAudio(path)
+	if err != nil {
+		return encode.Verification{}, err
+	}
+	defer closeFn()
+	return encode.Verify(ctx, b.BufferSize(format, nil), stallTimeout, format, in)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/render"
+	"pipelined.dev/phono/userinput"
+)
+
+var (
+	waveform = struct {
+		outPath      string
+		width        int
+		height       int
+		bufferSize   int
+		latency      string
+		stallTimeout time.Duration
+	}{}
+	waveformCmd = &cobra.Command{
+		Use:                   "waveform [flags] path",
+		DisableFlagsInUseLine: true,
+		Short:                 "Render waveform image of audio file",
+		Long: "Render waveform image of audio file with min and max peaks of every pixel column.\n" +
+			"Image format is defined by the extension of output file: png or svg.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if waveform.outPath == "" {
+				return fmt.Errorf("output file is not provided")
+			}
+			format, err := render.FormatByPath(waveform.outPath)
+			if err != nil {
+				return err
+			}
+			w, err := render.NewWaveform(waveform.width, waveform.height, render.DefaultWaveformColor)
+			if err != nil {
+				return err
+			}
+			b, err := buffering(cmd, waveform.bufferSize, waveform.latency)
+			if err != nil {
+				return err
+			}
+			return renderCLI(args[0], waveform.outPath, format, b, waveform.stallTimeout, w)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(waveformCmd)
+	waveformCmd.Flags().StringVarP(&waveform.outPath, "out", "o", "", "output image file, png or svg")
+	waveformCmd.Flags().IntVar(&waveform.width, "width", userinput.DefaultWidth, "image width in pixels")
+	waveformCmd.Flags().IntVar(&waveform.height, "height", userinput.DefaultHeight, "image height in pixels")
+	bufferSizeVar(waveformCmd.Flags(), &waveform.bufferSize)
+	waveformCmd.Flags().StringVar(&waveform.latency, "latency", string(encode.Throughput), "latency profile:\nlow - small buffers\nthroughput - large buffers")
+	waveformCmd.Flags().DurationVar(&waveform.stallTimeout, "stall-timeout", time.Minute, "cancel rendering if it makes no progress, disabled if zero")
+	waveformCmd.Flags().SortFlags = false
+}
+
+// renderCLI decodes the input file with the renderer sink and writes the
+// image into the output file. Audio track of media containers is
+// extracted.
+func renderCLI(path, outPath string, format render.Format, b encode.Buffering, stallTimeout time.Duration, r render.Renderer) error {
+	in, inFormat, closeFn, err := openAudio(path)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	onInterrupt(cancelFn)
+	bufferSize := b.BufferSize(inFormat, nil)
+	if err := encode.Run(ctx, bufferSize, stallTimeout, inFormat.Source(in), r.Sink()); err != nil {
+		return fmt.Errorf("failed to decode %s: %s: %v", path, encode.Code(err), err)
+	}
+	return writeFile(outPath, func(w io.Writer) error {
+		return r.Render(w, format)
+	})
+}
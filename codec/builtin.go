@@ -0,0 +1,245 @@
+package codec
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/audio/mp3"
+	"pipelined.dev/audio/wav"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
+)
+
+// Bit rate modes of mp3 sink.
+const (
+	MP3VBR = "VBR"
+	MP3CBR = "CBR"
+	MP3ABR = "ABR"
+)
+
+// Limits of mp3 sink settings. Bit rate limits apply to CBR and ABR, VBR
+// limits apply to VBR quality.
+const (
+	MP3MinBitRate = 8
+	MP3MaxBitRate = 320
+	MP3MinQuality = 0
+	MP3MaxQuality = 9
+	MP3MinVBR     = 0
+	MP3MaxVBR     = 9
+)
+
+// WAVBitDepths are bit depths supported by wav sink.
+var WAVBitDepths = []signal.BitDepth{
+	signal.BitDepth8,
+	signal.BitDepth16,
+	signal.BitDepth24,
+	signal.BitDepth32,
+}
+
+// wav, mp3 and flac sinks are built in, sinks of other formats are
+// registered by external modules.
+func init() {
+	bitDepths := make([]string, 0, len(WAVBitDepths))
+	for _, bd := range WAVBitDepths {
+		bitDepths = append(bitDepths, strconv.Itoa(int(bd)))
+	}
+	flacBitDepths := make([]string, 0, len(FLACBitDepths))
+	for _, bd := range FLACBitDepths {
+		flacBitDepths = append(flacBitDepths, strconv.Itoa(int(bd)))
+	}
+	compressions := make([]string, 0, FLACMaxCompression-FLACMinCompression+1)
+	for c := FLACMinCompression; c <= FLACMaxCompression; c++ {
+		compressions = append(compressions, strconv.Itoa(c))
+	}
+	RegisterSink(Sink{
+		Name:        "wav",
+		Extension:   fileformat.WAV().DefaultExtension(),
+		Format:      fileformat.WAV(),
+		Description: "uncompressed PCM",
+		Options: []Option{
+			{Name: "bitdepth", Description: "bit depth", Default: "24", Values: bitDepths},
+		},
+		New: wavEncoder,
+	})
+	RegisterSink(Sink{
+		Name:        "mp3",
+		Extension:   fileformat.MP3().DefaultExtension(),
+		Format:      fileformat.MP3(),
+		Description: "MPEG-1 Audio Layer III",
+		Options: []Option{
+			{Name: "bitratemode", Description: "bit rate mode", Default: "vbr", Values: []string{"vbr", "cbr", "abr"}},
+			{Name: "bitrate", Description: fmt.Sprintf("VBR quality [%d-%d] or bit rate [%d-%d]", MP3MinVBR, MP3MaxVBR, MP3MinBitRate, MP3MaxBitRate), Default: "4"},
+			{Name: "channelmode", Description: "channel mode: 0 - mono, 1 - stereo, 2 - joint stereo", Default: "2", Values: []string{"0", "1", "2"}},
+			{Name: "quality", Description: fmt.Sprintf("encoding quality [%d-%d], default if not set", MP3MinQuality, MP3MaxQuality)},
+		},
+		New: mp3Encoder,
+	})
+	RegisterSink(Sink{
+		Name:        "flac",
+		Extension:   fileformat.FLAC().DefaultExtension(),
+		Format:      fileformat.FLAC(),
+		Description: "Free Lossless Audio Codec",
+		Options: []Option{
+			{Name: "compression", Description: "compression level", Default: "5", Values: compressions},
+			{Name: "bitdepth", Description: "bit depth", Default: "24", Values: flacBitDepths},
+		},
+		New: flacEncoder,
+	})
+}
+
+// WAV returns wav sink of the bit depth.
+func WAV(bitDepth int) (func(io.WriteSeeker) pipe.SinkAllocatorFunc, error) {
+	bd := signal.BitDepth(bitDepth)
+	supported := false
+	for _, v := range WAVBitDepths {
+		supported = supported || v == bd
+	}
+	if !supported {
+		return nil, fmt.Errorf("Bit depth %v is not supported", bitDepth)
+	}
+	return func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
+		return wav.Sink(ws, bd)
+	}, nil
+}
+
+// MP3 returns mp3 sink of provided settings. Since mp3 sink doesn't need
+// to seek, the output can be streamed. Default encoding quality is used
+// if useQuality is false.
+func MP3(bitRateMode string, bitRate, channelMode int, useQuality bool, quality int) (func(io.Writer) pipe.SinkAllocatorFunc, error) {
+	cm := mp3.ChannelMode(channelMode)
+	switch cm {
+	case mp3.Mono, mp3.Stereo, mp3.JointStereo:
+	default:
+		return nil, fmt.Errorf("Channel mode %v is not supported", cm)
+	}
+
+	var brm mp3.BitRateMode
+	switch strings.ToUpper(bitRateMode) {
+	case MP3VBR:
+		if bitRate < MP3MinVBR || bitRate > MP3MaxVBR {
+			return nil, fmt.Errorf("VBR quality %v is not supported", bitRate)
+		}
+		brm = mp3.VBR(bitRate)
+	case MP3CBR:
+		if err := mp3BitRate(bitRate); err != nil {
+			return nil, err
+		}
+		brm = mp3.CBR(bitRate)
+	case MP3ABR:
+		if err := mp3BitRate(bitRate); err != nil {
+			return nil, err
+		}
+		brm = mp3.ABR(bitRate)
+	default:
+		return nil, fmt.Errorf("Bit rate mode %v is not supported", bitRateMode)
+	}
+
+	if useQuality {
+		if quality < MP3MinQuality || quality > MP3MaxQuality {
+			return nil, fmt.Errorf("MP3 quality %v is not supported", quality)
+		}
+	}
+
+	return func(w io.Writer) pipe.SinkAllocatorFunc {
+		eq := mp3.DefaultEncodingQuality
+		if useQuality {
+			eq = mp3.EncodingQuality(quality)
+		}
+		return mp3.Sink(w, brm, cm, eq)
+	}, nil
+}
+
+// mp3BitRate checks if provided bit rate is supported.
+func mp3BitRate(v int) error {
+	if v > MP3MaxBitRate || v < MP3MinBitRate {
+		return fmt.Errorf("Bit rate %v is not supported. Provide value between %d and %d", v, MP3MinBitRate, MP3MaxBitRate)
+	}
+	return nil
+}
+
+// wavEncoder returns wav encoder of sink params.
+func wavEncoder(params Params) (Encoder, error) {
+	bitDepth, err := paramInt(params, "bitdepth")
+	if err != nil {
+		return Encoder{}, err
+	}
+	sink, err := WAV(bitDepth)
+	if err != nil {
+		return Encoder{}, err
+	}
+	return Encoder{
+		Sink:     sink,
+		BitDepth: signal.BitDepth(bitDepth),
+		Desc:     fmt.Sprintf("%dbit", bitDepth),
+	}, nil
+}
+
+// flacEncoder returns flac encoder of sink params.
+func flacEncoder(params Params) (Encoder, error) {
+	compression, err := paramInt(params, "compression")
+	if err != nil {
+		return Encoder{}, err
+	}
+	bitDepth, err := paramInt(params, "bitdepth")
+	if err != nil {
+		return Encoder{}, err
+	}
+	sink, err := FLAC(compression, bitDepth)
+	if err != nil {
+		return Encoder{}, err
+	}
+	return Encoder{
+		Sink:     sink,
+		BitDepth: signal.BitDepth(bitDepth),
+		Desc:     fmt.Sprintf("%dbit level %d", bitDepth, compression),
+	}, nil
+}
+
+// mp3Encoder returns mp3 encoder of sink params. Default encoding quality
+// is used if quality isn't set.
+func mp3Encoder(params Params) (Encoder, error) {
+	bitRate, err := paramInt(params, "bitrate")
+	if err != nil {
+		return Encoder{}, err
+	}
+	channelMode, err := paramInt(params, "channelmode")
+	if err != nil {
+		return Encoder{}, err
+	}
+	var quality int
+	_, useQuality := params["quality"]
+	if useQuality {
+		if quality, err = paramInt(params, "quality"); err != nil {
+			return Encoder{}, err
+		}
+	}
+	bitRateMode := params["bitratemode"]
+	stream, err := MP3(bitRateMode, bitRate, channelMode, useQuality, quality)
+	if err != nil {
+		return Encoder{}, err
+	}
+	desc := fmt.Sprintf("%s %dk", strings.ToUpper(bitRateMode), bitRate)
+	if strings.EqualFold(bitRateMode, MP3VBR) {
+		desc = fmt.Sprintf("V%d", bitRate)
+	}
+	return Encoder{
+		Sink: func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
+			return stream(ws)
+		},
+		Stream:   stream,
+		BitDepth: signal.BitDepth16,
+		Desc:     desc,
+	}, nil
+}
+
+// paramInt returns the value of integer sink param.
+func paramInt(params Params, key string) (int, error) {
+	v, err := strconv.Atoi(params[key])
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be integer: %q", key, params[key])
+	}
+	return v, nil
+}
@@ -0,0 +1,267 @@
+// Package codec provides the registry of pumps and sinks. Pumps of
+// built-in formats, wav and mp3 sinks are registered by the package.
+// External Go modules register their formats from init functions, so
+// they are compiled in with a blank import in the main package:
+//
+//	import _ "example.com/phono-aac"
+//
+//	func init() {
+//		codec.RegisterSink(codec.Sink{Name: "aac", Extension: ".aac", New: newAAC})
+//	}
+//
+// Registered sinks are available as encode commands with flags of their
+// options, in the web form and in pipeline definitions.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
+)
+
+// Default registry is used by package functions. Pumps of formats known
+// to fileformat package are registered in it.
+var Default = NewRegistry()
+
+func init() {
+	for _, format := range []*fileformat.Format{fileformat.WAV(), fileformat.MP3(), fileformat.FLAC()} {
+		RegisterPump(Pump{
+			Name:       strings.TrimPrefix(format.DefaultExtension(), "."),
+			Extensions: format.Extensions(),
+			Format:     format,
+			Source:     format.Source,
+		})
+	}
+}
+
+var (
+	// ErrSinkNotFound is returned if sink is not registered.
+	ErrSinkNotFound = errors.New("sink not found")
+	// ErrPumpNotFound is returned if pump is not registered.
+	ErrPumpNotFound = errors.New("pump not found")
+)
+
+type (
+	// Params are named options of the sink.
+	Params map[string]string
+
+	// Option describes the option of the sink. Values are allowed values,
+	// any value is allowed if it's empty. Default is used if the option
+	// is not provided.
+	Option struct {
+		Name        string
+		Description string
+		Default     string
+		Values      []string
+	}
+
+	// Sink describes the registered output format. Name is used in
+	// commands and forms, Extension is the extension of output files
+	// with leading dot. Format is nil if the format is not known to
+	// fileformat package, tags aren't written into such outputs. New
+	// returns the encoder configured with options, it's called with
+	// defaults of missing options and only allowed values.
+	Sink struct {
+		Name        string
+		Extension   string
+		Format      *fileformat.Format
+		Description string
+		Options     []Option
+		New         func(Params) (Encoder, error)
+	}
+
+	// Encoder is the configured sink. Stream is nil if the sink needs to
+	// seek. BitDepth is the bit depth of sink samples, the signal is
+	// dithered if it's lower than the bit depth of the input. Desc
+	// describes the settings, e.g. V2.
+	Encoder struct {
+		Sink     func(io.WriteSeeker) pipe.SinkAllocatorFunc
+		Stream   func(io.Writer) pipe.SinkAllocatorFunc
+		BitDepth signal.BitDepth
+		Desc     string
+	}
+
+	// Pump describes the registered input format. Source decodes files
+	// with one of Extensions. Format is nil if the format is not known to
+	// fileformat package, such inputs aren't probed and dithered.
+	Pump struct {
+		Name       string
+		Extensions []string
+		Format     *fileformat.Format
+		Source     func(io.ReadSeeker) pipe.SourceAllocatorFunc
+	}
+
+	// Registry holds sinks by their names and pumps by extensions.
+	Registry struct {
+		mu    sync.Mutex
+		sinks map[string]Sink
+		pumps map[string]Pump
+	}
+)
+
+// NewRegistry returns empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		sinks: make(map[string]Sink),
+		pumps: make(map[string]Pump),
+	}
+}
+
+// RegisterSink adds sink to the default registry.
+func RegisterSink(s Sink) {
+	Default.RegisterSink(s)
+}
+
+// RegisterPump adds pump to the default registry.
+func RegisterPump(p Pump) {
+	Default.RegisterPump(p)
+}
+
+// RegisterSink adds sink to the registry. Name and extension must be
+// unique, options must have unique names.
+func (r *Registry) RegisterSink(s Sink) {
+	if s.Name == "" || strings.ToLower(s.Name) != s.Name || s.New == nil {
+		panic(fmt.Sprintf("codec: invalid sink %q", s.Name))
+	}
+	if !strings.HasPrefix(s.Extension, ".") {
+		panic(fmt.Sprintf("codec: extension of sink %s must start with dot", s.Name))
+	}
+	names := make(map[string]struct{}, len(s.Options))
+	for _, o := range s.Options {
+		if _, ok := names[o.Name]; ok || o.Name == "" {
+			panic(fmt.Sprintf("codec: invalid option %q of sink %s", o.Name, s.Name))
+		}
+		names[o.Name] = struct{}{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, registered := range r.sinks {
+		if registered.Name == s.Name || strings.EqualFold(registered.Extension, s.Extension) {
+			panic(fmt.Sprintf("codec: sink %s already registered", s.Name))
+		}
+	}
+	r.sinks[s.Name] = s
+}
+
+// RegisterPump adds pump to the registry. Extensions must be unique.
+func (r *Registry) RegisterPump(p Pump) {
+	if p.Name == "" || p.Source == nil || len(p.Extensions) == 0 {
+		panic(fmt.Sprintf("codec: invalid pump %q", p.Name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ext := range p.Extensions {
+		if _, ok := r.pumps[strings.ToLower(ext)]; ok {
+			panic(fmt.Sprintf("codec: pump of %s already registered", ext))
+		}
+	}
+	for _, ext := range p.Extensions {
+		r.pumps[strings.ToLower(ext)] = p
+	}
+}
+
+// Sink returns sink by its name or extension with leading dot.
+func (r *Registry) Sink(name string) (Sink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	if s, ok := r.sinks[name]; ok {
+		return s, nil
+	}
+	for _, s := range r.sinks {
+		if strings.EqualFold(s.Extension, name) {
+			return s, nil
+		}
+	}
+	return Sink{}, fmt.Errorf("%w: %s", ErrSinkNotFound, name)
+}
+
+// LookupSink returns sink from the default registry by its name or
+// extension.
+func LookupSink(name string) (Sink, error) {
+	return Default.Sink(name)
+}
+
+// LookupPump returns pump from the default registry for the path.
+func LookupPump(path string) (Pump, error) {
+	return Default.Pump(path)
+}
+
+// Sinks returns sinks of the default registry sorted by name.
+func Sinks() []Sink {
+	return Default.Sinks()
+}
+
+// Sinks returns sinks sorted by name.
+func (r *Registry) Sinks() []Sink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]Sink, 0, len(r.sinks))
+	for _, s := range r.sinks {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Pump returns pump of the path extension.
+func (r *Registry) Pump(path string) (Pump, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.pumps[strings.ToLower(filepath.Ext(path))]; ok {
+		return p, nil
+	}
+	return Pump{}, fmt.Errorf("%w: %s", ErrPumpNotFound, path)
+}
+
+// NewEncoder returns the encoder of the sink configured with params.
+// Missing options are set to their defaults, unknown options and values
+// that are not allowed are rejected.
+func (s Sink) NewEncoder(params Params) (Encoder, error) {
+	values := make(Params, len(s.Options))
+	for _, o := range s.Options {
+		if o.Default != "" {
+			values[o.Name] = o.Default
+		}
+	}
+	for name, v := range params {
+		o, ok := s.option(name)
+		if !ok {
+			return Encoder{}, fmt.Errorf("sink %s: unknown option %s", s.Name, name)
+		}
+		if len(o.Values) > 0 && !contains(o.Values, v) {
+			return Encoder{}, fmt.Errorf("sink %s: option %s must be one of %s", s.Name, name, strings.Join(o.Values, ", "))
+		}
+		values[name] = v
+	}
+	return s.New(values)
+}
+
+// option returns the option by its name.
+func (s Sink) option(name string) (Option, bool) {
+	for _, o := range s.Options {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package codec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/codec"
+)
+
+func TestRegistry(t *testing.T) {
+	newEncoder := func(p codec.Params) (codec.Encoder, error) {
+		if p["level"] == "" {
+			return codec.Encoder{}, errors.New("level is missing")
+		}
+		return codec.Encoder{Desc: "level " + p["level"]}, nil
+	}
+	r := codec.NewRegistry()
+	r.RegisterSink(codec.Sink{
+		Name:      "test",
+		Extension: ".tst",
+		Options: []codec.Option{
+			{Name: "level", Default: "1", Values: []string{"1", "2"}},
+			{Name: "comment"},
+		},
+		New: newEncoder,
+	})
+	assert.Panics(t, func() { r.RegisterSink(codec.Sink{Name: "test", Extension: ".other", New: newEncoder}) })
+	assert.Panics(t, func() { r.RegisterSink(codec.Sink{Name: "other", Extension: ".TST", New: newEncoder}) })
+	assert.Panics(t, func() { r.RegisterSink(codec.Sink{Name: "other", Extension: "other", New: newEncoder}) })
+	assert.Panics(t, func() { r.RegisterSink(codec.Sink{Name: "other", Extension: ".other"}) })
+	assert.Panics(t, func() {
+		r.RegisterSink(codec.Sink{Name: "other", Extension: ".other", New: newEncoder, Options: []codec.Option{{Name: "a"}, {Name: "a"}}})
+	})
+
+	s, err := r.Sink("test")
+	assert.NoError(t, err)
+	_, err = r.Sink(".TST")
+	assert.NoError(t, err)
+	_, err = r.Sink("other")
+	assert.True(t, errors.Is(err, codec.ErrSinkNotFound))
+	assert.Equal(t, 1, len(r.Sinks()))
+
+	enc, err := s.NewEncoder(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "level 1", enc.Desc)
+	enc, err = s.NewEncoder(codec.Params{"level": "2", "comment": "any"})
+	assert.NoError(t, err)
+	assert.Equal(t, "level 2", enc.Desc)
+	_, err = s.NewEncoder(codec.Params{"level": "3"})
+	assert.Error(t, err)
+	_, err = s.NewEncoder(codec.Params{"unknown": "1"})
+	assert.Error(t, err)
+}
+
+func TestPumps(t *testing.T) {
+	p, err := codec.LookupPump("in.FLAC")
+	assert.NoError(t, err)
+	assert.Equal(t, fileformat.FLAC(), p.Format)
+	_, err = codec.LookupPump("in.ogg")
+	assert.True(t, errors.Is(err, codec.ErrPumpNotFound))
+	assert.Panics(t, func() {
+		codec.RegisterPump(codec.Pump{Name: "wave", Extensions: []string{".wav"}, Source: fileformat.WAV().Source})
+	})
+}
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec   string
+		sink   string
+		params codec.Params
+		desc   string
+	}{
+		{spec: "mp3", sink: "mp3", params: codec.Params{}, desc: "V4"},
+		{spec: "MP3:v0", sink: "mp3", params: codec.Params{"bitratemode": "vbr", "bitrate": "0"}, desc: "V0"},
+		{spec: "mp3:CBR320k", sink: "mp3", params: codec.Params{"bitratemode": "cbr", "bitrate": "320"}, desc: "CBR 320k"},
+		{spec: "wav:16bit", sink: "wav", params: codec.Params{"bitdepth": "16"}, desc: "16bit"},
+		{spec: ".wav", sink: "wav", params: codec.Params{}, desc: "24bit"},
+	}
+	for _, test := range tests {
+		s, params, err := codec.ParseSpec(test.spec)
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.sink, s.Name, test.spec)
+		assert.Equal(t, test.params, params, test.spec)
+		enc, err := s.NewEncoder(params)
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.desc, enc.Desc, test.spec)
+	}
+	for _, spec := range []string{"ogg", "mp3:X1", "mp3:Vx", "wav:high"} {
+		_, _, err := codec.ParseSpec(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,565 @@
+package codec
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"math/bits"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Limits of flac compression level. Levels select the block size,
+// stereo decorrelation and the search of rice partitions. Higher levels
+// give smaller files and use more CPU. Samples are predicted with fixed
+// polynomials only, so files are larger than of reference encoder with
+// the same level.
+const (
+	FLACMinCompression = 0
+	FLACMaxCompression = 8
+)
+
+// FLACBitDepths are bit depths supported by flac sink.
+var FLACBitDepths = []signal.BitDepth{
+	signal.BitDepth16,
+	signal.BitDepth24,
+}
+
+// flacLevels are the settings of compression levels. Max order is the
+// max order of fixed predictor, max partition is the max order of rice
+// partitions.
+var flacLevels = [FLACMaxCompression + 1]struct {
+	blockSize    int
+	stereo       bool
+	maxOrder     int
+	maxPartition int
+}{
+	{blockSize: 1152, maxOrder: 2, maxPartition: 2},
+	{blockSize: 1152, stereo: true, maxOrder: 2, maxPartition: 2},
+	{blockSize: 1152, stereo: true, maxOrder: 4, maxPartition: 3},
+	{blockSize: 4096, maxOrder: 4, maxPartition: 3},
+	{blockSize: 4096, stereo: true, maxOrder: 4, maxPartition: 3},
+	{blockSize: 4096, stereo: true, maxOrder: 4, maxPartition: 4},
+	{blockSize: 4096, stereo: true, maxOrder: 4, maxPartition: 5},
+	{blockSize: 4096, stereo: true, maxOrder: 4, maxPartition: 6},
+	{blockSize: 4096, stereo: true, maxOrder: 4, maxPartition: 8},
+}
+
+// Channel assignments of flac frames.
+const (
+	flacLeftSide  = 8
+	flacRightSide = 9
+	flacMidSide   = 10
+)
+
+// flacStreamInfoSize is the size of STREAMINFO block without header.
+const flacStreamInfoSize = 34
+
+// flacWriter writes samples of every channel into frames of the block
+// size. STREAMINFO block is updated with totals when it's flushed.
+// Subframes are encoded for every channel, stereo is also encoded as mid
+// and side channels, the smallest pair is written.
+type flacWriter struct {
+	ws           io.WriteSeeker
+	sampleRate   int
+	channels     int
+	bitDepth     int
+	blockSize    int
+	stereo       bool
+	maxOrder     int
+	maxPartition int
+	pending      [][]int64
+	frame        uint64
+	samples      uint64
+	minFrame     int
+	maxFrame     int
+	md5          hash.Hash
+	sampleBytes  []byte
+	residual     []int64
+	mid          []int64
+	side         []int64
+	bw           flacBitWriter
+	subframes    []flacBitWriter
+}
+
+// flacBitWriter writes big-endian bits into the buffer.
+type flacBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+// FLAC returns flac sink of compression level and bit depth.
+func FLAC(compression, bitDepth int) (func(io.WriteSeeker) pipe.SinkAllocatorFunc, error) {
+	if compression < FLACMinCompression || compression > FLACMaxCompression {
+		return nil, fmt.Errorf("Compression level %v is not supported. Provide value between %d and %d", compression, FLACMinCompression, FLACMaxCompression)
+	}
+	bd := signal.BitDepth(bitDepth)
+	supported := false
+	for _, v := range FLACBitDepths {
+		supported = supported || v == bd
+	}
+	if !supported {
+		return nil, fmt.Errorf("Bit depth %v is not supported", bitDepth)
+	}
+	return func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
+		return flacSink(ws, compression, bd)
+	}, nil
+}
+
+// flacSink writes flac data to WriteSeeker.
+func flacSink(ws io.WriteSeeker, compression int, bitDepth signal.BitDepth) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		if props.Channels < 1 || props.Channels > 8 {
+			return pipe.Sink{}, fmt.Errorf("flac doesn't support %d channels", props.Channels)
+		}
+		if props.SampleRate == 0 || props.SampleRate >= 1<<20 {
+			return pipe.Sink{}, fmt.Errorf("flac doesn't support sample rate %v", props.SampleRate)
+		}
+		level := flacLevels[compression]
+		e := flacWriter{
+			ws:           ws,
+			sampleRate:   int(props.SampleRate),
+			channels:     props.Channels,
+			bitDepth:     int(bitDepth),
+			blockSize:    level.blockSize,
+			stereo:       level.stereo && props.Channels == 2,
+			maxOrder:     level.maxOrder,
+			maxPartition: level.maxPartition,
+			pending:      make([][]int64, props.Channels),
+			subframes:    make([]flacBitWriter, props.Channels+2),
+			md5:          md5.New(),
+			sampleBytes:  make([]byte, 0, bufferSize*props.Channels*int(bitDepth)/8),
+		}
+		if err := e.writeHeader(); err != nil {
+			return pipe.Sink{}, err
+		}
+		ints := signal.Allocator{
+			Channels: props.Channels,
+			Capacity: bufferSize,
+			Length:   bufferSize,
+		}.Int64(bitDepth)
+		return pipe.Sink{
+			SinkFunc: func(floats signal.Floating) error {
+				n := signal.FloatingAsSigned(floats, ints)
+				return e.write(ints.Slice(0, n))
+			},
+			FlushFunc: func(context.Context) error {
+				return e.flush()
+			},
+		}, nil
+	}
+}
+
+// writeHeader writes flac marker and STREAMINFO block without totals.
+func (e *flacWriter) writeHeader() error {
+	header := append([]byte("fLaC"), 0x80, 0, 0, flacStreamInfoSize)
+	_, err := e.ws.Write(append(header, e.streamInfo()...))
+	return err
+}
+
+// streamInfo returns STREAMINFO block with current totals. Block size is
+// fixed, only the last block can be shorter.
+func (e *flacWriter) streamInfo() []byte {
+	var w flacBitWriter
+	w.write(uint64(e.blockSize), 16)
+	w.write(uint64(e.blockSize), 16)
+	w.write(uint64(e.minFrame), 24)
+	w.write(uint64(e.maxFrame), 24)
+	w.write(uint64(e.sampleRate), 20)
+	w.write(uint64(e.channels-1), 3)
+	w.write(uint64(e.bitDepth-1), 5)
+	w.write(e.samples, 36)
+	if e.samples > 0 {
+		w.buf = e.md5.Sum(w.buf)
+	} else {
+		w.buf = append(w.buf, make([]byte, md5.Size)...)
+	}
+	return w.buf
+}
+
+// write adds interleaved samples to pending blocks and encodes complete
+// ones.
+func (e *flacWriter) write(ints signal.Signed) error {
+	bytesPerSample := e.bitDepth / 8
+	e.sampleBytes = e.sampleBytes[:0]
+	for i := 0; i < ints.Len(); i++ {
+		v := ints.Sample(i)
+		e.pending[i%e.channels] = append(e.pending[i%e.channels], v)
+		for b := 0; b < bytesPerSample; b++ {
+			e.sampleBytes = append(e.sampleBytes, byte(v>>(8*b)))
+		}
+	}
+	e.md5.Write(e.sampleBytes)
+	for len(e.pending[0]) >= e.blockSize {
+		if err := e.writeFrame(e.blockSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush encodes the last block and updates STREAMINFO block.
+func (e *flacWriter) flush() error {
+	if n := len(e.pending[0]); n > 0 {
+		if err := e.writeFrame(n); err != nil {
+			return err
+		}
+	}
+	end, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.ws.Seek(8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := e.ws.Write(e.streamInfo()); err != nil {
+		return err
+	}
+	_, err = e.ws.Seek(end, io.SeekStart)
+	return err
+}
+
+// writeFrame encodes the block of n samples from the start of pending
+// ones.
+func (e *flacWriter) writeFrame(n int) error {
+	assignment := e.channels - 1
+	if e.stereo {
+		assignment = e.encodeStereo(n)
+	} else {
+		for c := 0; c < e.channels; c++ {
+			e.encodeSubframe(&e.subframes[c], e.pending[c][:n], e.bitDepth)
+		}
+	}
+
+	w := &e.bw
+	w.reset()
+	// sync code, fixed block size, block size and sample rate are
+	// provided after the header and in STREAMINFO
+	w.write(0xfff8, 16)
+	w.write(7, 4)
+	w.write(0, 4)
+	w.write(uint64(assignment), 4)
+	if e.bitDepth == 16 {
+		w.write(4, 3)
+	} else {
+		w.write(6, 3)
+	}
+	w.write(0, 1)
+	w.writeUTF8(e.frame)
+	w.write(uint64(n-1), 16)
+	w.write(uint64(flacCRC8(w.buf)), 8)
+	subframes := e.channels
+	for i := 0; i < subframes; i++ {
+		w.append(&e.subframes[i])
+	}
+	w.align()
+	w.write(uint64(flacCRC16(w.buf)), 16)
+	if _, err := e.ws.Write(w.buf); err != nil {
+		return err
+	}
+
+	if size := len(w.buf); e.minFrame == 0 || size < e.minFrame {
+		e.minFrame = size
+	}
+	if size := len(w.buf); size > e.maxFrame {
+		e.maxFrame = size
+	}
+	e.frame++
+	e.samples += uint64(n)
+	for c := range e.pending {
+		e.pending[c] = e.pending[c][:copy(e.pending[c], e.pending[c][n:])]
+	}
+	return nil
+}
+
+// encodeStereo encodes two channels with the smallest assignment of
+// independent, left/side, right/side and mid/side. Subframes are
+// written into the first two writers.
+func (e *flacWriter) encodeStereo(n int) int {
+	left, right := e.pending[0][:n], e.pending[1][:n]
+	e.mid, e.side = e.mid[:0], e.side[:0]
+	for i := range left {
+		e.mid = append(e.mid, (left[i]+right[i])>>1)
+		e.side = append(e.side, left[i]-right[i])
+	}
+	e.encodeSubframe(&e.subframes[0], left, e.bitDepth)
+	e.encodeSubframe(&e.subframes[1], right, e.bitDepth)
+	e.encodeSubframe(&e.subframes[2], e.mid, e.bitDepth)
+	e.encodeSubframe(&e.subframes[3], e.side, e.bitDepth+1)
+	l, r, m, s := e.subframes[0].len(), e.subframes[1].len(), e.subframes[2].len(), e.subframes[3].len()
+	switch minInt(l+r, l+s, r+s, m+s) {
+	case l + r:
+		return 1
+	case l + s:
+		e.subframes[1], e.subframes[3] = e.subframes[3], e.subframes[1]
+		return flacLeftSide
+	case r + s:
+		e.subframes[0], e.subframes[3] = e.subframes[3], e.subframes[0]
+		return flacRightSide
+	default:
+		e.subframes[0], e.subframes[2] = e.subframes[2], e.subframes[0]
+		e.subframes[1], e.subframes[3] = e.subframes[3], e.subframes[1]
+		return flacMidSide
+	}
+}
+
+// encodeSubframe writes the smallest subframe of constant, verbatim and
+// fixed predictors up to max order.
+func (e *flacWriter) encodeSubframe(w *flacBitWriter, samples []int64, bitDepth int) {
+	w.reset()
+	constant := true
+	for _, v := range samples[1:] {
+		if v != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		w.write(0, 8)
+		w.writeSigned(samples[0], uint(bitDepth))
+		return
+	}
+
+	verbatim := len(samples) * bitDepth
+	bestOrder, bestBits := -1, verbatim
+	for order := 0; order <= e.maxOrder && order < len(samples); order++ {
+		e.residual = flacResidual(e.residual[:0], samples, order)
+		_, size := flacPartitions(e.residual, len(samples), order, e.maxPartition)
+		if size += order * bitDepth; size < bestBits {
+			bestOrder, bestBits = order, size
+		}
+	}
+	if bestOrder < 0 {
+		w.write(1<<1, 8)
+		for _, v := range samples {
+			w.writeSigned(v, uint(bitDepth))
+		}
+		return
+	}
+	w.write(uint64(0x08|bestOrder)<<1, 8)
+	for _, v := range samples[:bestOrder] {
+		w.writeSigned(v, uint(bitDepth))
+	}
+	e.residual = flacResidual(e.residual[:0], samples, bestOrder)
+	partitionOrder, _ := flacPartitions(e.residual, len(samples), bestOrder, e.maxPartition)
+	flacWriteResidual(w, e.residual, len(samples), bestOrder, partitionOrder)
+}
+
+// flacResidual appends residual of fixed predictor of the order.
+func flacResidual(dst, samples []int64, order int) []int64 {
+	for i := order; i < len(samples); i++ {
+		var p int64
+		switch order {
+		case 1:
+			p = samples[i-1]
+		case 2:
+			p = 2*samples[i-1] - samples[i-2]
+		case 3:
+			p = 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		case 4:
+			p = 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+		dst = append(dst, samples[i]-p)
+	}
+	return dst
+}
+
+// flacPartitions returns the partition order with the smallest size of
+// residual in bits. Every partition must have more samples than the
+// order of predictor.
+func flacPartitions(residual []int64, blockSize, order, maxPartition int) (int, int) {
+	best, bestBits := 0, -1
+	for p := 0; p <= maxPartition; p++ {
+		if blockSize%(1<<p) != 0 || blockSize>>p <= order {
+			break
+		}
+		size := 6
+		for _, part := range flacSplit(residual, blockSize, order, p) {
+			_, n := flacRiceParam(part)
+			size += n
+		}
+		if bestBits < 0 || size < bestBits {
+			best, bestBits = p, size
+		}
+	}
+	return best, bestBits
+}
+
+// flacSplit splits residual into 2^partitionOrder partitions. The first
+// one has order samples less, they are warm-up samples.
+func flacSplit(residual []int64, blockSize, order, partitionOrder int) [][]int64 {
+	parts := make([][]int64, 0, 1<<partitionOrder)
+	size := blockSize >> partitionOrder
+	start := 0
+	for i := 0; i < 1<<partitionOrder; i++ {
+		end := start + size
+		if i == 0 {
+			end -= order
+		}
+		parts = append(parts, residual[start:end])
+		start = end
+	}
+	return parts
+}
+
+// flacRiceParam returns rice parameter of the partition and its size in
+// bits including the parameter.
+func flacRiceParam(part []int64) (int, int) {
+	var sum uint64
+	for _, v := range part {
+		sum += flacZigzag(v)
+	}
+	k := 0
+	if n := uint64(len(part)); sum > n {
+		k = bits.Len64(sum/n) - 1
+	}
+	best, bestBits := 0, -1
+	for _, param := range []int{k - 1, k, k + 1} {
+		if param < 0 || param > 30 {
+			continue
+		}
+		size := 5 + len(part)*(param+1) + int(flacRiceQuotients(part, param))
+		if bestBits < 0 || size < bestBits {
+			best, bestBits = param, size
+		}
+	}
+	return best, bestBits
+}
+
+func flacRiceQuotients(part []int64, param int) uint64 {
+	var q uint64
+	for _, v := range part {
+		q += flacZigzag(v) >> uint(param)
+	}
+	return q
+}
+
+// flacWriteResidual writes partitioned rice residual. Parameters are
+// 5-bit wide to allow large residual of 24-bit side channel.
+func flacWriteResidual(w *flacBitWriter, residual []int64, blockSize, order, partitionOrder int) {
+	w.write(1, 2)
+	w.write(uint64(partitionOrder), 4)
+	for _, part := range flacSplit(residual, blockSize, order, partitionOrder) {
+		param, _ := flacRiceParam(part)
+		w.write(uint64(param), 5)
+		for _, v := range part {
+			u := flacZigzag(v)
+			w.writeUnary(u >> uint(param))
+			w.write(u&(1<<uint(param)-1), uint(param))
+		}
+	}
+}
+
+func flacZigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func minInt(v int, values ...int) int {
+	for _, x := range values {
+		if x < v {
+			v = x
+		}
+	}
+	return v
+}
+
+func (w *flacBitWriter) reset() {
+	w.buf, w.acc, w.nbits = w.buf[:0], 0, 0
+}
+
+// len returns the number of written bits.
+func (w *flacBitWriter) len() int {
+	return len(w.buf)*8 + int(w.nbits)
+}
+
+// write writes n low bits of v, n must not exceed 56.
+func (w *flacBitWriter) write(v uint64, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.nbits -= 8
+		w.buf = append(w.buf, byte(w.acc>>w.nbits))
+	}
+}
+
+func (w *flacBitWriter) writeSigned(v int64, n uint) {
+	w.write(uint64(v), n)
+}
+
+// writeUnary writes q zeros followed by one.
+func (w *flacBitWriter) writeUnary(q uint64) {
+	for ; q >= 32; q -= 32 {
+		w.write(0, 32)
+	}
+	w.write(1, uint(q)+1)
+}
+
+// writeUTF8 writes v coded like UTF-8 with up to 36 bits.
+func (w *flacBitWriter) writeUTF8(v uint64) {
+	if v < 0x80 {
+		w.write(v, 8)
+		return
+	}
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	w.write(uint64(0xff00>>n)&0xff|v>>(6*(n-1)), 8)
+	for i := n - 2; i >= 0; i-- {
+		w.write(0x80|v>>(6*i)&0x3f, 8)
+	}
+}
+
+// append writes bits of another writer.
+func (w *flacBitWriter) append(src *flacBitWriter) {
+	for _, b := range src.buf {
+		w.write(uint64(b), 8)
+	}
+	if src.nbits > 0 {
+		w.write(src.acc, src.nbits)
+	}
+}
+
+// align pads the last byte with zeros.
+func (w *flacBitWriter) align() {
+	if w.nbits > 0 {
+		w.write(0, 8-w.nbits)
+	}
+}
+
+// flacCRC8 returns CRC-8 of frame header with polynomial 0x07.
+func flacCRC8(b []byte) byte {
+	var crc byte
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// flacCRC16 returns CRC-16 of frame with polynomial 0x8005.
+func flacCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,148 @@
+package codec_test
+
+import (
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/codec"
+)
+
+func TestFLAC(t *testing.T) {
+	_, err := codec.FLAC(9, 16)
+	assert.Error(t, err)
+	_, err = codec.FLAC(5, 8)
+	assert.Error(t, err)
+
+	// samples returns noisy sine, channels are correlated, so they are
+	// decorrelated by stereo modes
+	samples := func(channels, frames int, bitDepth signal.BitDepth) signal.Signed {
+		ints := signal.Allocator{Channels: channels, Length: frames, Capacity: frames}.Int64(bitDepth)
+		r := rand.New(rand.NewSource(1))
+		amplitude := float64(bitDepth.MaxSignedValue()) / 2
+		for i := 0; i < frames; i++ {
+			v := amplitude * math.Sin(2*math.Pi*440*float64(i)/44100)
+			for c := 0; c < channels; c++ {
+				ints.SetSample(i*channels+c, int64(v/float64(c+1)+r.NormFloat64()*64))
+			}
+		}
+		return ints
+	}
+	source := func(ints signal.Signed) pipe.SourceAllocatorFunc {
+		return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+			pos := 0
+			return pipe.Source{
+				SignalProperties: pipe.SignalProperties{Channels: ints.Channels(), SampleRate: 44100},
+				SourceFunc: func(out signal.Floating) (int, error) {
+					if pos == ints.Length() {
+						return 0, io.EOF
+					}
+					end := pos + out.Length()
+					if end > ints.Length() {
+						end = ints.Length()
+					}
+					n := signal.SignedAsFloating(ints.Slice(pos, end), out)
+					pos = end
+					return n, nil
+				},
+			}, nil
+		}
+	}
+	encode := func(path string, ints signal.Signed, compression int) error {
+		sink, err := codec.FLAC(compression, int(ints.BitDepth()))
+		if err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return pipe.Run(context.Background(), 1000, pipe.Line{Source: source(ints), Sink: sink(f)})
+	}
+	decode := func(path string, bitDepth signal.BitDepth) (signal.Signed, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		pump, err := codec.LookupPump(path)
+		if err != nil {
+			return nil, err
+		}
+		var decoded signal.Signed
+		sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+			decoded = signal.Allocator{Channels: props.Channels, Capacity: bufferSize}.Int64(bitDepth)
+			ints := signal.Allocator{Channels: props.Channels, Length: bufferSize, Capacity: bufferSize}.Int64(bitDepth)
+			return pipe.Sink{
+				SinkFunc: func(floats signal.Floating) error {
+					n := signal.FloatingAsSigned(floats, ints)
+					decoded.Append(ints.Slice(0, n))
+					return nil
+				},
+			}, nil
+		}
+		err = pipe.Run(context.Background(), 1000, pipe.Line{Source: pump.Source(f), Sink: sink})
+		return decoded, err
+	}
+	checksum := func(ints signal.Signed) []byte {
+		h := md5.New()
+		for i := 0; i < ints.Len(); i++ {
+			v := ints.Sample(i)
+			for b := 0; b < int(ints.BitDepth())/8; b++ {
+				h.Write([]byte{byte(v >> (8 * b))})
+			}
+		}
+		return h.Sum(nil)
+	}
+
+	dir := t.TempDir()
+	for _, c := range []struct {
+		channels int
+		frames   int
+		bitDepth signal.BitDepth
+	}{
+		{channels: 1, frames: 5000, bitDepth: signal.BitDepth16},
+		{channels: 2, frames: 10000, bitDepth: signal.BitDepth16},
+		{channels: 2, frames: 10000, bitDepth: signal.BitDepth24},
+		{channels: 6, frames: 3000, bitDepth: signal.BitDepth24},
+		{channels: 2, frames: 10, bitDepth: signal.BitDepth16},
+	} {
+		ints := samples(c.channels, c.frames, c.bitDepth)
+		var sizes []int64
+		for _, compression := range []int{0, 5, 8} {
+			path := filepath.Join(dir, "out.flac")
+			assert.NoError(t, encode(path, ints, compression))
+			decoded, err := decode(path, c.bitDepth)
+			if !assert.NoError(t, err) {
+				continue
+			}
+			assert.Equal(t, ints.Length(), decoded.Length())
+			for i := 0; i < ints.Len() && i < decoded.Len(); i++ {
+				if ints.Sample(i) != decoded.Sample(i) {
+					t.Fatalf("%d channels %d bit level %d: sample %d: expected %d got %d", c.channels, c.bitDepth, compression, i, ints.Sample(i), decoded.Sample(i))
+				}
+			}
+			data, err := ioutil.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, checksum(ints), data[26:42])
+			sizes = append(sizes, int64(len(data)))
+		}
+		assert.LessOrEqual(t, sizes[2], sizes[0])
+		// short blocks are stored verbatim
+		if c.frames > 1000 {
+			assert.Less(t, sizes[0], int64(ints.Len()*int(c.bitDepth)/8))
+		}
+	}
+}
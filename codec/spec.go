@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSpec parses format[:settings] spec of the output and returns its
+// sink with params. Settings of mp3 are VBR quality, e.g. V2, or bit rate
+// mode with bit rate, e.g. CBR320. Settings of wav are bit depth, e.g.
+// 16bit. Other sinks accept no settings. Options that are not set by the
+// spec get their defaults in NewEncoder of the sink.
+func ParseSpec(spec string) (Sink, Params, error) {
+	name, settings := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, settings = spec[:i], spec[i+1:]
+	}
+	s, err := LookupSink(strings.ToLower(name))
+	if err != nil {
+		return Sink{}, nil, fmt.Errorf("unsupported output format: %s", name)
+	}
+	if settings == "" {
+		return s, Params{}, nil
+	}
+	switch s.Name {
+	case "mp3":
+		v := strings.ToUpper(settings)
+		bitRateMode := MP3VBR
+		switch {
+		case strings.HasPrefix(v, "V"):
+			v = v[1:]
+		case strings.HasPrefix(v, MP3CBR), strings.HasPrefix(v, MP3ABR):
+			bitRateMode, v = v[:3], v[3:]
+		default:
+			return Sink{}, nil, fmt.Errorf("unsupported mp3 settings: %s", settings)
+		}
+		bitRate, err := strconv.Atoi(strings.TrimSuffix(v, "K"))
+		if err != nil {
+			return Sink{}, nil, fmt.Errorf("unsupported mp3 settings: %s", settings)
+		}
+		return s, Params{
+			"bitratemode": strings.ToLower(bitRateMode),
+			"bitrate":     strconv.Itoa(bitRate),
+		}, nil
+	case "wav":
+		bitDepth, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(settings), "bit"))
+		if err != nil {
+			return Sink{}, nil, fmt.Errorf("unsupported wav settings: %s", settings)
+		}
+		return s, Params{"bitdepth": strconv.Itoa(bitDepth)}, nil
+	}
+	return Sink{}, nil, fmt.Errorf("unsupported %s settings: %s", s.Name, settings)
+}
@@ -0,0 +1,178 @@
+// Package container extracts audio from media containers. MP4/QuickTime
+// and Matroska/WebM files are demuxed and the first audio track is
+// copied as an elementary stream, so it can be decoded by the formats of
+// the pipeline. Only tracks with MP3 and FLAC audio can be extracted,
+// other codecs are reported as not supported.
+package container
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"pipelined.dev/audio/fileformat"
+)
+
+// Extensions of supported container files.
+var Extensions = []string{".mp4", ".m4a", ".m4v", ".mov", ".mkv", ".mka", ".webm"}
+
+var (
+	// ErrNoAudio is returned when container has no audio tracks.
+	ErrNoAudio = errors.New("container has no audio track")
+	// ErrCodec is returned when audio track cannot be extracted.
+	ErrCodec = errors.New("unsupported audio codec")
+
+	errFormat = errors.New("unknown container format")
+)
+
+// Track kinds.
+const (
+	Other Kind = iota
+	Audio
+	Video
+	Subtitle
+)
+
+type (
+	// Kind of the track.
+	Kind int
+
+	// Track describes the track of container. Codec is the identifier
+	// used by the container, e.g. A_OPUS or mp4a.40. Language is empty
+	// if it's not specified.
+	Track struct {
+		Kind     Kind
+		Codec    string
+		Language string
+	}
+
+	// track is a demuxed track. Format is not nil if the track can be
+	// extracted. Header is written before the track data.
+	track struct {
+		Track
+		format *fileformat.Format
+		header []byte
+	}
+
+	demuxer interface {
+		tracks() []*track
+		extract(w io.Writer, t *track) error
+		text() (Text, error)
+	}
+)
+
+// MatchPath returns true if path has extension of supported container.
+func MatchPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns true if header is the beginning of supported container.
+// At least 12 bytes are needed to detect the format.
+func Match(header []byte) bool {
+	if bytes.HasPrefix(header, ebmlMagic) {
+		return true
+	}
+	if len(header) < 8 {
+		return false
+	}
+	// old quicktime files don't start with file type box
+	switch string(header[4:8]) {
+	case "ftyp", "moov", "mdat", "wide":
+		return true
+	}
+	return false
+}
+
+// Probe returns the tracks of container.
+func Probe(rs io.ReadSeeker) ([]Track, error) {
+	d, err := demux(rs)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(d.tracks()))
+	for _, t := range d.tracks() {
+		tracks = append(tracks, t.Track)
+	}
+	return tracks, nil
+}
+
+// Extract writes the first audio track of container into w and returns
+// its format. ErrNoAudio is returned for video-only files and ErrCodec
+// if audio tracks use codecs that are not supported.
+func Extract(rs io.ReadSeeker, w io.Writer) (*fileformat.Format, error) {
+	d, err := demux(rs)
+	if err != nil {
+		return nil, err
+	}
+	var audio *track
+	for _, t := range d.tracks() {
+		if t.Kind != Audio {
+			continue
+		}
+		if t.format != nil {
+			audio = t
+			break
+		}
+		if audio == nil {
+			audio = t
+		}
+	}
+	if audio == nil {
+		return nil, ErrNoAudio
+	}
+	if audio.format == nil {
+		return nil, fmt.Errorf("%w: %s", ErrCodec, audio.Codec)
+	}
+	if _, err := w.Write(audio.header); err != nil {
+		return nil, err
+	}
+	if err := d.extract(w, audio); err != nil {
+		return nil, err
+	}
+	return audio.format, nil
+}
+
+// demux detects the format of container and reads its tracks.
+func demux(rs io.ReadSeeker) (demuxer, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(rs, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header = header[:n]
+	switch {
+	case bytes.HasPrefix(header, ebmlMagic):
+		return demuxMatroska(rs)
+	case Match(header):
+		return demuxMP4(rs)
+	}
+	return nil, errFormat
+}
+
+// String returns the name of the kind.
+func (k Kind) String() string {
+	switch k {
+	case Audio:
+		return "audio"
+	case Video:
+		return "video"
+	case Subtitle:
+		return "subtitle"
+	}
+	return "other"
+}
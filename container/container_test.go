@@ -0,0 +1,292 @@
+package container_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/container"
+)
+
+func TestMatch(t *testing.T) {
+	assert.True(t, container.MatchPath("movie.MP4"))
+	assert.True(t, container.MatchPath("dir/clip.webm"))
+	assert.False(t, container.MatchPath("song.mp3"))
+
+	assert.True(t, container.Match(mp4File(nil)))
+	assert.True(t, container.Match(matroskaFile()))
+	assert.False(t, container.Match([]byte("RIFF\x00\x00\x00\x00WAVE")))
+}
+
+func TestMatroska(t *testing.T) {
+	frames := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	file := matroskaFile(
+		trackEntry(1, 1, "V_VP9"),
+		trackEntry(2, 2, "A_MPEG/L3"),
+		cluster(
+			simpleBlock(1, 0, []byte("video")),
+			simpleBlock(2, 0, frames[0]),
+			// xiph laced frames
+			simpleBlock(2, 0x02, append([]byte{1, byte(len(frames[1]))}, append(frames[1], frames[2]...)...)),
+		),
+	)
+
+	tracks, err := container.Probe(bytes.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, []container.Track{
+		{Kind: container.Video, Codec: "V_VP9"},
+		{Kind: container.Audio, Codec: "A_MPEG/L3"},
+	}, tracks)
+
+	var out bytes.Buffer
+	format, err := container.Extract(bytes.NewReader(file), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, fileformat.MP3(), format)
+	assert.Equal(t, "firstsecondthird", out.String())
+
+	// video only
+	file = matroskaFile(trackEntry(1, 1, "V_VP9"), cluster(simpleBlock(1, 0, []byte("video"))))
+	_, err = container.Extract(bytes.NewReader(file), &out)
+	assert.True(t, errors.Is(err, container.ErrNoAudio))
+
+	// webm audio
+	file = matroskaFile(trackEntry(1, 2, "A_OPUS"), cluster(simpleBlock(1, 0, []byte("opus"))))
+	_, err = container.Extract(bytes.NewReader(file), &out)
+	assert.True(t, errors.Is(err, container.ErrCodec))
+}
+
+func TestMP4(t *testing.T) {
+	frames := []byte("firstsecond")
+	file := mp4File(frames,
+		trak("vide", mp4Box("avc1", make([]byte, 78)), nil),
+		trak("soun", mp4Box("mp4a", audioEntry(0x6B)), []uint32{5, 6}),
+	)
+
+	tracks, err := container.Probe(bytes.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, []container.Track{
+		{Kind: container.Video, Codec: "avc1"},
+		{Kind: container.Audio, Codec: "mp4a.6b"},
+	}, tracks)
+
+	var out bytes.Buffer
+	format, err := container.Extract(bytes.NewReader(file), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, fileformat.MP3(), format)
+	assert.Equal(t, string(frames), out.String())
+
+	// aac audio
+	file = mp4File(frames, trak("soun", mp4Box("mp4a", audioEntry(0x40)), []uint32{5, 6}))
+	_, err = container.Extract(bytes.NewReader(file), &out)
+	assert.True(t, errors.Is(err, container.ErrCodec))
+
+	// video only
+	file = mp4File(nil, trak("vide", mp4Box("avc1", make([]byte, 78)), nil))
+	_, err = container.Extract(bytes.NewReader(file), &out)
+	assert.True(t, errors.Is(err, container.ErrNoAudio))
+
+	// sample count of fixed size samples exceeds the file
+	file = mp4File(frames, trak("soun", mp4Box("mp4a", audioEntry(0x6B)), []uint32{5, 6}))
+	stsz := bytes.Index(file, []byte("stsz"))
+	binary.BigEndian.PutUint32(file[stsz+8:], 1)
+	binary.BigEndian.PutUint32(file[stsz+12:], 0xFFFFFFFF)
+	_, err = container.Extract(bytes.NewReader(file), &out)
+	assert.Error(t, err)
+	_, err = container.Probe(bytes.NewReader(file))
+	assert.Error(t, err)
+}
+
+func TestText(t *testing.T) {
+	subtitle := func(time uint16, duration byte, text string) []byte {
+		block := append([]byte{0x82, byte(time >> 8), byte(time), 0}, text...)
+		return ebml(0xA0, append(ebml(0xA1, block), ebml(0x9B, []byte{duration})...))
+	}
+	chapter := func(start uint32, title string) []byte {
+		return ebml(0xB6, append(
+			ebml(0x91, []byte{byte(start >> 24), byte(start >> 16), byte(start >> 8), byte(start)}),
+			ebml(0x80, ebml(0x85, []byte(title)))...,
+		))
+	}
+	file := matroskaFile(
+		ebml(0x1549A966, ebml(0x4489, []byte{0x46, 0x1C, 0x40, 0})), // 10000.0 ms
+		append(
+			trackEntry(1, 2, "A_MPEG/L3"),
+			ebml(0xAE, bytes.Join([][]byte{
+				ebml(0xD7, []byte{2}),
+				ebml(0x83, []byte{0x11}),
+				ebml(0x86, []byte("S_TEXT/UTF8")),
+				ebml(0x22B59C, []byte("eng")),
+			}, nil))...,
+		),
+		ebml(0x1043A770, ebml(0x45B9, append(chapter(0, "Intro"), chapter(4000000000, "Talk")...))),
+		cluster(
+			simpleBlock(1, 0, []byte("audio")),
+			subtitle(1000, 200, "Hello"),
+			subtitle(2500, 0, "World"),
+		),
+	)
+
+	text, err := container.ExtractText(bytes.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, container.Text{
+		Subtitles: []container.Subtitles{
+			{
+				Language: "eng",
+				Cues: []container.Cue{
+					{Start: time.Second, End: 1200 * time.Millisecond, Text: "Hello"},
+					{Start: 2500 * time.Millisecond, End: 10 * time.Second, Text: "World"},
+				},
+			},
+		},
+		Chapters: []container.Chapter{
+			{Start: 0, End: 4 * time.Second, Title: "Intro"},
+			{Start: 4 * time.Second, End: 10 * time.Second, Title: "Talk"},
+		},
+	}, text)
+
+	var srt bytes.Buffer
+	assert.Nil(t, text.Subtitles[0].WriteSRT(&srt))
+	assert.Equal(t, "1\n00:00:01,000 --> 00:00:01,200\nHello\n\n2\n00:00:02,500 --> 00:00:10,000\nWorld\n\n", srt.String())
+
+	var chapters bytes.Buffer
+	assert.Nil(t, container.WriteChapters(&chapters, text.Chapters))
+	assert.JSONEq(t, `[{"start": 0, "end": 4, "title": "Intro"}, {"start": 4, "end": 10, "title": "Talk"}]`, chapters.String())
+	read, err := container.ReadChapters(&chapters)
+	assert.Nil(t, err)
+	assert.Equal(t, text.Chapters, read)
+	read, err = container.ReadChapters(strings.NewReader(`[{"start": 1.5, "title": "One"}, {"start": 3, "title": "Two"}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, []container.Chapter{
+		{Start: 1500 * time.Millisecond, End: 3 * time.Second, Title: "One"},
+		{Start: 3 * time.Second, Title: "Two"},
+	}, read)
+	_, err = container.ReadChapters(strings.NewReader(`[{"start": 3}, {"start": 1}]`))
+	assert.NotNil(t, err)
+
+	// nero chapters
+	chpl := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2}
+	for _, c := range []struct {
+		start uint64
+		title string
+	}{{0, "One"}, {20000000, "Two"}} {
+		start := make([]byte, 8)
+		binary.BigEndian.PutUint64(start, c.start)
+		chpl = append(append(append(chpl, start...), byte(len(c.title))), c.title...)
+	}
+	file = mp4File([]byte("frames"),
+		trak("soun", mp4Box("mp4a", audioEntry(0x6B)), []uint32{6}),
+		mp4Box("udta", mp4Box("chpl", chpl)),
+	)
+	text, err = container.ExtractText(bytes.NewReader(file))
+	assert.Nil(t, err)
+	assert.Empty(t, text.Subtitles)
+	assert.Equal(t, []container.Chapter{
+		{Start: 0, End: 2 * time.Second, Title: "One"},
+		{Start: 2 * time.Second, Title: "Two"},
+	}, text.Chapters)
+}
+
+func ebml(id uint32, data []byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if v := byte(id >> uint(shift)); v != 0 || len(b) > 0 {
+			b = append(b, v)
+		}
+	}
+	// 8 byte size
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(data)))
+	size[0] = 0x01
+	return append(append(b, size...), data...)
+}
+
+func matroskaFile(elements ...[]byte) []byte {
+	header := ebml(0x1A45DFA3, ebml(0x4282, []byte("matroska")))
+	return append(header, ebml(0x18538067, bytes.Join(elements, nil))...)
+}
+
+func trackEntry(number, kind byte, codec string) []byte {
+	return ebml(0x1654AE6B, ebml(0xAE, bytes.Join([][]byte{
+		ebml(0xD7, []byte{number}),
+		ebml(0x83, []byte{kind}),
+		ebml(0x86, []byte(codec)),
+	}, nil)))
+}
+
+func cluster(blocks ...[]byte) []byte {
+	return ebml(0x1F43B675, append(ebml(0xE7, []byte{0}), bytes.Join(blocks, nil)...))
+}
+
+func simpleBlock(track, lacing byte, data []byte) []byte {
+	return ebml(0xA3, append([]byte{0x80 | track, 0, 0, lacing}, data...))
+}
+
+func mp4Box(kind string, data ...[]byte) []byte {
+	payload := bytes.Join(data, nil)
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], kind)
+	return append(b, payload...)
+}
+
+// mp4File returns the file with media data followed by movie box.
+// Sample tables of tracks must point at offset 0 of media data.
+func mp4File(mdat []byte, traks ...[]byte) []byte {
+	file := append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00")), mp4Box("mdat", mdat)...)
+	file = append(file, mp4Box("moov", traks...)...)
+	// fix chunk offset to point at media data
+	offset := uint32(len(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00"))) + 8)
+	i := bytes.Index(file, []byte("stco"))
+	for i >= 0 {
+		binary.BigEndian.PutUint32(file[i+12:], offset)
+		next := bytes.Index(file[i+4:], []byte("stco"))
+		if next < 0 {
+			break
+		}
+		i += 4 + next
+	}
+	return file
+}
+
+// trak returns the track with samples in a single chunk.
+func trak(handler string, entry []byte, sizes []uint32) []byte {
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, entry...)
+	stsz := make([]byte, 12+4*len(sizes))
+	binary.BigEndian.PutUint32(stsz[8:], uint32(len(sizes)))
+	for i, s := range sizes {
+		binary.BigEndian.PutUint32(stsz[12+4*i:], s)
+	}
+	stsc := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, byte(len(sizes)), 0, 0, 0, 1}
+	stco := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+	stbl := mp4Box("stbl",
+		mp4Box("stsd", stsd),
+		mp4Box("stsz", stsz),
+		mp4Box("stsc", stsc),
+		mp4Box("stco", stco),
+	)
+	return mp4Box("trak", mp4Box("mdia",
+		mp4Box("hdlr", hdlr),
+		mp4Box("minf", stbl),
+	))
+}
+
+// audioEntry returns mp4a sample entry with provided object type.
+func audioEntry(object byte) []byte {
+	entry := make([]byte, 28)
+	esds := []byte{
+		0, 0, 0, 0,
+		// es descriptor
+		0x03, 0x0F, 0, 1, 0,
+		// decoder config descriptor
+		0x04, 0x0A, object, 0x15, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	return append(entry, mp4Box("esds", esds)...)
+}
@@ -0,0 +1,532 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+)
+
+// maxElementSize limits the size of elements which are read into memory.
+const maxElementSize = 16 << 20
+
+// Matroska element ids.
+const (
+	idSegment          = 0x18538067
+	idInfo             = 0x1549A966
+	idTimecodeScale    = 0x2AD7B1
+	idDuration         = 0x4489
+	idTracks           = 0x1654AE6B
+	idTrackEntry       = 0xAE
+	idTrackNumber      = 0xD7
+	idTrackType        = 0x83
+	idCodecID          = 0x86
+	idCodecPrivate     = 0x63A2
+	idLanguage         = 0x22B59C
+	idChapters         = 0x1043A770
+	idEditionEntry     = 0x45B9
+	idChapterAtom      = 0xB6
+	idChapterTimeStart = 0x91
+	idChapterTimeEnd   = 0x92
+	idChapterDisplay   = 0x80
+	idChapString       = 0x85
+	idCluster          = 0x1F43B675
+	idTimecode         = 0xE7
+	idBlockGroup       = 0xA0
+	idBlock            = 0xA1
+	idBlockDuration    = 0x9B
+	idSimpleBlock      = 0xA3
+)
+
+// Matroska track types.
+const (
+	trackTypeVideo    = 1
+	trackTypeAudio    = 2
+	trackTypeSubtitle = 0x11
+)
+
+// defaultTimecodeScale is the length of timecode unit in nanoseconds.
+const defaultTimecodeScale = 1000000
+
+var (
+	ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+	errMatroska = errors.New("invalid matroska file")
+)
+
+type (
+	// matroska reads elements sequentially. Master elements that contain
+	// tracks and blocks are entered, others are skipped. This allows to
+	// read files with unknown element sizes produced by live encoders.
+	matroska struct {
+		r      *bufio.Reader
+		rs     io.ReadSeeker
+		list   []*track
+		number map[*track]uint64
+		// first block which was read while looking for tracks
+		pending *matroskaBlock
+		// last block, its duration follows it in the block group
+		last *matroskaBlock
+
+		scale       int64
+		duration    time.Duration
+		clusterTime int64
+		chapters    []Chapter
+	}
+
+	// matroskaBlock is the block which header is read. Size is the
+	// number of remaining bytes, time is in timecode units.
+	matroskaBlock struct {
+		track    uint64
+		size     int64
+		time     int64
+		duration int64
+		flags    byte
+	}
+)
+
+// demuxMatroska reads elements up to the first block.
+func demuxMatroska(rs io.ReadSeeker) (*matroska, error) {
+	d := matroska{
+		r:      bufio.NewReader(rs),
+		rs:     rs,
+		number: make(map[*track]uint64),
+		scale:  defaultTimecodeScale,
+	}
+	block, err := d.next()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	d.pending = block
+	return &d, nil
+}
+
+func (d *matroska) tracks() []*track {
+	return d.list
+}
+
+func (d *matroska) extract(w io.Writer, t *track) error {
+	number := d.number[t]
+	return d.blocks(func(block *matroskaBlock) error {
+		if block.track != number {
+			return d.skip(block.size)
+		}
+		return copyFrames(w, d.r, block)
+	})
+}
+
+func (d *matroska) text() (Text, error) {
+	type trackCues struct {
+		*Subtitles
+		codec string
+	}
+	subtitles := make(map[uint64]*trackCues)
+	var order []*Subtitles
+	for _, t := range d.list {
+		if t.Kind == Subtitle && textCodec(t.Codec) {
+			s := &Subtitles{Language: t.Language}
+			subtitles[d.number[t]] = &trackCues{Subtitles: s, codec: t.Codec}
+			order = append(order, s)
+		}
+	}
+	// cue of the previous block, duration is known after the next one
+	var (
+		prevCue   *Cue
+		prevBlock *matroskaBlock
+	)
+	err := d.blocks(func(block *matroskaBlock) error {
+		if prevCue != nil && prevBlock.duration > 0 {
+			prevCue.End = d.time(prevBlock.time + prevBlock.duration)
+		}
+		prevCue = nil
+		s, ok := subtitles[block.track]
+		if !ok {
+			return d.skip(block.size)
+		}
+		if block.size > maxElementSize {
+			return fmt.Errorf("%w: invalid block size", errMatroska)
+		}
+		var buf bytes.Buffer
+		if err := copyFrames(&buf, d.r, block); err != nil {
+			return err
+		}
+		s.Cues = append(s.Cues, Cue{
+			Start: d.time(block.time),
+			Text:  cueText(s.codec, buf.String()),
+		})
+		prevCue, prevBlock = &s.Cues[len(s.Cues)-1], block
+		return nil
+	})
+	if err != nil {
+		return Text{}, err
+	}
+	if prevCue != nil && prevBlock.duration > 0 {
+		prevCue.End = d.time(prevBlock.time + prevBlock.duration)
+	}
+
+	text := Text{Chapters: d.chapters}
+	for _, s := range order {
+		if len(s.Cues) > 0 {
+			s.fillEnds(d.duration)
+			text.Subtitles = append(text.Subtitles, *s)
+		}
+	}
+	fillChapterEnds(text.Chapters, d.duration)
+	return text, nil
+}
+
+// blocks calls fn for every block starting from the pending one. Fn
+// must read or skip the rest of block.
+func (d *matroska) blocks(fn func(*matroskaBlock) error) error {
+	block := d.pending
+	d.pending = nil
+	for block != nil {
+		if err := fn(block); err != nil {
+			return err
+		}
+		var err error
+		if block, err = d.next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// next reads elements until the block is found. Tracks, chapters and
+// timing are collected on the way. Block header is consumed, the rest
+// of block must be read or skipped by the caller.
+func (d *matroska) next() (*matroskaBlock, error) {
+	var current *track
+	for {
+		id, size, err := d.readElement()
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idSegment, idInfo, idTracks, idCluster, idBlockGroup:
+			// enter master element
+		case idTrackEntry:
+			current = &track{}
+			d.list = append(d.list, current)
+		case idTrackNumber, idTrackType, idCodecID, idCodecPrivate, idLanguage:
+			if current == nil {
+				return nil, fmt.Errorf("%w: track field outside of track entry", errMatroska)
+			}
+			data, err := d.read(size)
+			if err != nil {
+				return nil, err
+			}
+			d.setTrackField(current, id, data)
+		case idTimecodeScale, idTimecode, idBlockDuration, idDuration:
+			data, err := d.read(size)
+			if err != nil {
+				return nil, err
+			}
+			d.setTiming(id, data)
+		case idChapters:
+			data, err := d.read(size)
+			if err != nil {
+				return nil, err
+			}
+			if d.chapters, err = parseChapters(data); err != nil {
+				return nil, err
+			}
+		case idSimpleBlock, idBlock:
+			if size < 0 {
+				return nil, fmt.Errorf("%w: invalid block size", errMatroska)
+			}
+			number, n, err := readVint(d.r)
+			if err != nil {
+				return nil, err
+			}
+			// timecode and flags
+			var header [3]byte
+			if _, err := io.ReadFull(d.r, header[:]); err != nil {
+				return nil, fmt.Errorf("%w: %v", errMatroska, err)
+			}
+			d.last = &matroskaBlock{
+				track: number,
+				size:  size - int64(n) - 3,
+				time:  d.clusterTime + int64(int16(binary.BigEndian.Uint16(header[:2]))),
+				flags: header[2],
+			}
+			return d.last, nil
+		default:
+			if size < 0 {
+				return nil, fmt.Errorf("%w: unknown size of element %x", errMatroska, id)
+			}
+			if err := d.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// read returns the data of element.
+func (d *matroska) read(size int64) ([]byte, error) {
+	if size < 0 || size > maxElementSize {
+		return nil, fmt.Errorf("%w: invalid element size", errMatroska)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", errMatroska, err)
+	}
+	return data, nil
+}
+
+// setTrackField sets the value of track entry element.
+func (d *matroska) setTrackField(t *track, id uint64, data []byte) {
+	switch id {
+	case idTrackNumber:
+		d.number[t] = readUint(data)
+	case idTrackType:
+		switch readUint(data) {
+		case trackTypeAudio:
+			t.Kind = Audio
+		case trackTypeVideo:
+			t.Kind = Video
+		case trackTypeSubtitle:
+			t.Kind = Subtitle
+		}
+	case idCodecID:
+		t.Codec = string(data)
+		switch t.Codec {
+		case "A_MPEG/L3":
+			t.format = fileformat.MP3()
+		case "A_FLAC":
+			t.format = fileformat.FLAC()
+		}
+	case idCodecPrivate:
+		// flac stream header and metadata blocks
+		t.header = data
+	case idLanguage:
+		t.Language = string(bytes.TrimRight(data, "\x00"))
+	}
+}
+
+// setTiming sets the value of timing element.
+func (d *matroska) setTiming(id uint64, data []byte) {
+	switch id {
+	case idTimecodeScale:
+		if scale := int64(readUint(data)); scale > 0 {
+			d.scale = scale
+		}
+	case idTimecode:
+		d.clusterTime = int64(readUint(data))
+	case idBlockDuration:
+		if d.last != nil {
+			d.last.duration = int64(readUint(data))
+		}
+	case idDuration:
+		// duration is stored as float in timecode units
+		var v float64
+		switch len(data) {
+		case 4:
+			v = float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+		case 8:
+			v = math.Float64frombits(binary.BigEndian.Uint64(data))
+		}
+		d.duration = time.Duration(v * float64(d.scale))
+	}
+}
+
+// time converts timecode units into duration.
+func (d *matroska) time(t int64) time.Duration {
+	return time.Duration(t * d.scale)
+}
+
+// parseChapters returns the chapters of the first edition. Times of
+// chapters are in nanoseconds.
+func parseChapters(data []byte) ([]Chapter, error) {
+	r := bytes.NewReader(data)
+	d := matroska{r: bufio.NewReader(r), rs: r}
+	var (
+		chapters []Chapter
+		editions int
+	)
+	for {
+		id, size, err := d.readElement()
+		if err == io.EOF {
+			return chapters, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case idEditionEntry:
+			if editions++; editions > 1 {
+				return chapters, nil
+			}
+		case idChapterAtom:
+			chapters = append(chapters, Chapter{})
+		case idChapterDisplay:
+			// enter master element
+		case idChapterTimeStart, idChapterTimeEnd, idChapString:
+			if len(chapters) == 0 {
+				return nil, fmt.Errorf("%w: chapter field outside of chapter", errMatroska)
+			}
+			value, err := d.read(size)
+			if err != nil {
+				return nil, err
+			}
+			c := &chapters[len(chapters)-1]
+			switch id {
+			case idChapterTimeStart:
+				c.Start = time.Duration(readUint(value))
+			case idChapterTimeEnd:
+				c.End = time.Duration(readUint(value))
+			case idChapString:
+				// first display is used
+				if c.Title == "" {
+					c.Title = string(value)
+				}
+			}
+		default:
+			if size < 0 {
+				return nil, fmt.Errorf("%w: unknown size of element %x", errMatroska, id)
+			}
+			if err := d.skip(size); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readElement reads the id and size of element. Negative size means
+// the size is unknown.
+func (d *matroska) readElement() (uint64, int64, error) {
+	first, err := d.r.Peek(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	length := vintLength(first[0])
+	if length == 0 || length > 4 {
+		return 0, 0, fmt.Errorf("%w: invalid element id", errMatroska)
+	}
+	raw := make([]byte, length)
+	if _, err := io.ReadFull(d.r, raw); err != nil {
+		return 0, 0, fmt.Errorf("%w: %v", errMatroska, err)
+	}
+	id := readUint(raw)
+	size, n, err := readVint(d.r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size == 1<<(7*uint(n))-1 {
+		return id, -1, nil
+	}
+	return id, int64(size), nil
+}
+
+// skip discards n bytes. Large elements are skipped with seek.
+func (d *matroska) skip(n int64) error {
+	if n <= int64(d.r.Buffered()) {
+		_, err := d.r.Discard(int(n))
+		return err
+	}
+	if _, err := d.rs.Seek(n-int64(d.r.Buffered()), io.SeekCurrent); err != nil {
+		return err
+	}
+	d.r.Reset(d.rs)
+	return nil
+}
+
+// copyFrames writes the frames of the block without lacing header.
+func copyFrames(w io.Writer, r *bufio.Reader, block *matroskaBlock) error {
+	size := block.size
+	lacing := block.flags & 0x06
+	if lacing != 0 {
+		count, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: %v", errMatroska, err)
+		}
+		size--
+		frames := int(count) + 1
+		switch lacing {
+		case 0x02:
+			// xiph lacing: sizes of all but last frame as sums of bytes
+			for i := 0; i < frames-1; i++ {
+				for {
+					b, err := r.ReadByte()
+					if err != nil {
+						return fmt.Errorf("%w: %v", errMatroska, err)
+					}
+					size--
+					if b != 0xFF {
+						break
+					}
+				}
+			}
+		case 0x06:
+			// ebml lacing: first size and signed differences
+			for i := 0; i < frames-1; i++ {
+				_, n, err := readVint(r)
+				if err != nil {
+					return err
+				}
+				size -= int64(n)
+			}
+		}
+	}
+	if size < 0 {
+		return fmt.Errorf("%w: invalid block size", errMatroska)
+	}
+	if _, err := io.CopyN(w, r, size); err != nil {
+		return fmt.Errorf("%w: %v", errMatroska, err)
+	}
+	return nil
+}
+
+// readVint reads variable size integer without length marker. Returns
+// the value and the number of bytes read.
+func readVint(r *bufio.Reader) (uint64, int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, fmt.Errorf("%w: %v", errMatroska, err)
+	}
+	length := vintLength(first)
+	if length == 0 {
+		return 0, 0, fmt.Errorf("%w: invalid variable size integer", errMatroska)
+	}
+	v := uint64(first) & (0xFF >> uint(length))
+	for i := 1; i < length; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, fmt.Errorf("%w: %v", errMatroska, err)
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, length, nil
+}
+
+// vintLength returns the length of variable size integer by its first
+// byte. Zero is returned for invalid value.
+func vintLength(first byte) int {
+	for i := 0; i < 8; i++ {
+		if first&(0x80>>uint(i)) != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// readUint reads big-endian unsigned integer.
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
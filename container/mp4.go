@@ -0,0 +1,656 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+)
+
+// maxMoovSize limits the size of movie box which is read into memory.
+const maxMoovSize = 64 << 20
+
+// maxSamples limits the number of samples of the track. Sample size box
+// with sizes of all samples cannot describe more within the movie box.
+const maxSamples = maxMoovSize / 4
+
+// Object type indications of MPEG audio in esds box.
+const (
+	objectMPEG2Audio = 0x69
+	objectMPEG1Audio = 0x6B
+)
+
+var errMP4 = errors.New("invalid mp4 file")
+
+type (
+	mp4 struct {
+		rs       io.ReadSeeker
+		traks    map[*track]*mp4Track
+		list     []*track
+		duration time.Duration
+		// nero chapters
+		chapters []Chapter
+	}
+
+	// mp4Track contains the data of track needed to read its samples.
+	// Chapters are ids of tracks with chapter titles.
+	mp4Track struct {
+		id       uint32
+		chapters []uint32
+		samples  []mp4Sample
+	}
+
+	mp4Sample struct {
+		offset   int64
+		size     int64
+		start    time.Duration
+		duration time.Duration
+	}
+
+	// box is a parsed mp4 box with its payload.
+	box struct {
+		kind    string
+		payload []byte
+	}
+)
+
+// demuxMP4 reads the movie box and sample tables of the tracks. Media
+// data is not read until the track is extracted.
+func demuxMP4(rs io.ReadSeeker) (*mp4, error) {
+	// file size limits the sample tables
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	fileSize, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var moov []byte
+	for moov == nil {
+		kind, size, err := readBoxHeader(rs)
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: no movie box", errMP4)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if kind != "moov" {
+			if size < 0 {
+				return nil, fmt.Errorf("%w: no movie box", errMP4)
+			}
+			if _, err := rs.Seek(size, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if size < 0 || size > maxMoovSize {
+			return nil, fmt.Errorf("%w: invalid movie box size", errMP4)
+		}
+		moov = make([]byte, size)
+		if _, err := io.ReadFull(rs, moov); err != nil {
+			return nil, err
+		}
+	}
+
+	d := mp4{
+		rs:    rs,
+		traks: make(map[*track]*mp4Track),
+	}
+	boxes, err := parseBoxes(moov)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range boxes {
+		switch b.kind {
+		case "mvhd":
+			if timescale, duration, ok := parseMediaHeader(b.payload, 12); ok {
+				d.duration = scaleTime(duration, timescale)
+			}
+		case "udta":
+			if chpl, err := findBox(b.payload, "chpl"); err == nil {
+				d.chapters = parseNeroChapters(chpl)
+			}
+		case "trak":
+			t, trak, err := parseTrak(b.payload, fileSize)
+			if err != nil {
+				return nil, err
+			}
+			d.list = append(d.list, t)
+			d.traks[t] = trak
+		}
+	}
+	return &d, nil
+}
+
+func (d *mp4) tracks() []*track {
+	return d.list
+}
+
+func (d *mp4) extract(w io.Writer, t *track) error {
+	for _, s := range d.traks[t].samples {
+		if _, err := d.rs.Seek(s.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, d.rs, s.size); err != nil {
+			return fmt.Errorf("%w: %v", errMP4, err)
+		}
+	}
+	return nil
+}
+
+func (d *mp4) text() (Text, error) {
+	chapterTracks := make(map[uint32]bool)
+	for _, t := range d.list {
+		for _, id := range d.traks[t].chapters {
+			chapterTracks[id] = true
+		}
+	}
+	var text Text
+	for _, t := range d.list {
+		if t.Kind != Subtitle || !textCodec(t.Codec) {
+			continue
+		}
+		trak := d.traks[t]
+		cues, err := d.readCues(trak.samples)
+		if err != nil {
+			return Text{}, err
+		}
+		if !chapterTracks[trak.id] {
+			if len(cues) > 0 {
+				s := Subtitles{Language: t.Language, Cues: cues}
+				s.fillEnds(d.duration)
+				text.Subtitles = append(text.Subtitles, s)
+			}
+			continue
+		}
+		if text.Chapters != nil {
+			continue
+		}
+		for _, c := range cues {
+			text.Chapters = append(text.Chapters, Chapter{Start: c.Start, End: c.End, Title: c.Text})
+		}
+	}
+	if text.Chapters == nil {
+		text.Chapters = d.chapters
+	}
+	fillChapterEnds(text.Chapters, d.duration)
+	return text, nil
+}
+
+// readCues reads the samples of timed text track. Empty samples are
+// gaps between cues.
+func (d *mp4) readCues(samples []mp4Sample) ([]Cue, error) {
+	var cues []Cue
+	for _, s := range samples {
+		if s.size < 2 {
+			continue
+		}
+		if s.size > maxElementSize {
+			return nil, fmt.Errorf("%w: invalid text sample size", errMP4)
+		}
+		if _, err := d.rs.Seek(s.offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		data := make([]byte, s.size)
+		if _, err := io.ReadFull(d.rs, data); err != nil {
+			return nil, fmt.Errorf("%w: %v", errMP4, err)
+		}
+		// text length followed by text and style boxes
+		n := int(binary.BigEndian.Uint16(data))
+		if n == 0 || n > len(data)-2 {
+			continue
+		}
+		cues = append(cues, Cue{
+			Start: s.start,
+			End:   s.start + s.duration,
+			Text:  cueText("", string(data[2:2+n])),
+		})
+	}
+	return cues, nil
+}
+
+// parseTrak returns the track and the data to read its samples.
+func parseTrak(data []byte, fileSize int64) (*track, *mp4Track, error) {
+	trak := mp4Track{}
+	if tkhd, err := findBox(data, "tkhd"); err == nil && len(tkhd) > 0 {
+		// creation and modification times precede the id
+		offset := 12
+		if tkhd[0] == 1 {
+			offset = 20
+		}
+		if len(tkhd) >= offset+4 {
+			trak.id = binary.BigEndian.Uint32(tkhd[offset:])
+		}
+	}
+	if chap, err := findBox(data, "tref", "chap"); err == nil {
+		for i := 0; i+4 <= len(chap); i += 4 {
+			trak.chapters = append(trak.chapters, binary.BigEndian.Uint32(chap[i:]))
+		}
+	}
+	mdia, err := findBox(data, "mdia")
+	if err != nil {
+		return nil, nil, err
+	}
+	hdlr, err := findBox(mdia, "hdlr")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(hdlr) < 12 {
+		return nil, nil, fmt.Errorf("%w: short handler box", errMP4)
+	}
+	t := track{}
+	switch string(hdlr[8:12]) {
+	case "soun":
+		t.Kind = Audio
+	case "vide":
+		t.Kind = Video
+	case "text", "sbtl", "subt":
+		t.Kind = Subtitle
+	}
+	stbl, err := findBox(mdia, "minf", "stbl")
+	if err != nil {
+		return nil, nil, err
+	}
+	stsd, err := findBox(stbl, "stsd")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := parseSampleDescription(&t, stsd); err != nil {
+		return nil, nil, err
+	}
+	if t.format == nil && !textCodec(t.Codec) {
+		// samples are not needed
+		return &t, &trak, nil
+	}
+	var timescale uint32
+	if mdhd, err := findBox(mdia, "mdhd"); err == nil {
+		var ok bool
+		if timescale, _, ok = parseMediaHeader(mdhd, 12); ok {
+			t.Language = parseLanguage(mdhd)
+		}
+	}
+	if trak.samples, err = parseSampleTable(stbl, timescale, fileSize); err != nil {
+		return nil, nil, err
+	}
+	return &t, &trak, nil
+}
+
+// parseMediaHeader returns timescale and duration of movie or media
+// header. Fields of version 0 start at offset, version 1 has 64-bit
+// times.
+func parseMediaHeader(header []byte, offset int) (uint32, uint64, bool) {
+	if len(header) < offset+8 {
+		return 0, 0, false
+	}
+	if header[0] == 1 {
+		offset += 8
+		if len(header) < offset+12 {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(header[offset:]), binary.BigEndian.Uint64(header[offset+4:]), true
+	}
+	return binary.BigEndian.Uint32(header[offset:]), uint64(binary.BigEndian.Uint32(header[offset+4:])), true
+}
+
+// parseLanguage returns ISO-639-2 code of media header language.
+func parseLanguage(mdhd []byte) string {
+	offset := 20
+	if mdhd[0] == 1 {
+		offset = 32
+	}
+	if len(mdhd) < offset+2 {
+		return ""
+	}
+	code := binary.BigEndian.Uint16(mdhd[offset:])
+	if code == 0 || code == 0x7FFF {
+		return ""
+	}
+	return string([]byte{
+		byte(code>>10&0x1F) + 0x60,
+		byte(code>>5&0x1F) + 0x60,
+		byte(code&0x1F) + 0x60,
+	})
+}
+
+// parseNeroChapters returns chapters of chpl box. Start times are in
+// 100ns units.
+func parseNeroChapters(chpl []byte) []Chapter {
+	if len(chpl) < 5 {
+		return nil
+	}
+	d := chpl[4:]
+	if chpl[0] == 1 {
+		d = skip(d, 4)
+	}
+	if len(d) < 1 {
+		return nil
+	}
+	count := int(d[0])
+	d = d[1:]
+	var chapters []Chapter
+	for i := 0; i < count && len(d) >= 9; i++ {
+		start := binary.BigEndian.Uint64(d)
+		n := int(d[8])
+		d = d[9:]
+		if n > len(d) {
+			break
+		}
+		chapters = append(chapters, Chapter{
+			Start: time.Duration(start) * 100,
+			Title: string(d[:n]),
+		})
+		d = d[n:]
+	}
+	return chapters
+}
+
+// scaleTime converts the time in timescale units into duration.
+func scaleTime(t uint64, timescale uint32) time.Duration {
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(t) / float64(timescale) * float64(time.Second))
+}
+
+// parseSampleDescription sets codec and format of the track from the
+// first sample entry.
+func parseSampleDescription(t *track, stsd []byte) error {
+	// version and flags, entry count
+	if len(stsd) < 16 {
+		return fmt.Errorf("%w: short sample description", errMP4)
+	}
+	entries, err := parseBoxes(stsd[8:])
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("%w: no sample entries", errMP4)
+	}
+	entry := entries[0]
+	t.Codec = entry.kind
+	switch entry.kind {
+	case ".mp3":
+		t.format = fileformat.MP3()
+	case "mp4a":
+		if t.Kind != Audio {
+			return nil
+		}
+		object, err := audioObjectType(entry.payload)
+		if err != nil {
+			return err
+		}
+		t.Codec = fmt.Sprintf("mp4a.%x", object)
+		if object == objectMPEG1Audio || object == objectMPEG2Audio {
+			t.format = fileformat.MP3()
+		}
+	}
+	return nil
+}
+
+// audioObjectType returns object type indication of mp4a sample entry.
+func audioObjectType(entry []byte) (byte, error) {
+	// reserved, data reference index, version
+	if len(entry) < 28 {
+		return 0, fmt.Errorf("%w: short audio sample entry", errMP4)
+	}
+	offset := 28
+	// quicktime sound description versions have extra fields
+	switch binary.BigEndian.Uint16(entry[8:10]) {
+	case 1:
+		offset += 16
+	case 2:
+		offset += 36
+	}
+	if len(entry) < offset {
+		return 0, fmt.Errorf("%w: short audio sample entry", errMP4)
+	}
+	esds, err := findBox(entry[offset:], "esds")
+	if err != nil {
+		// wave box wraps esds in quicktime files
+		wave, waveErr := findBox(entry[offset:], "wave")
+		if waveErr != nil {
+			return 0, err
+		}
+		if esds, err = findBox(wave, "esds"); err != nil {
+			return 0, err
+		}
+	}
+	// version and flags
+	if len(esds) < 4 {
+		return 0, fmt.Errorf("%w: short esds box", errMP4)
+	}
+	d := esds[4:]
+	tag, d := readDescriptor(d)
+	if tag != 0x03 || len(d) < 3 {
+		return 0, fmt.Errorf("%w: invalid es descriptor", errMP4)
+	}
+	flags := d[2]
+	d = d[3:]
+	if flags&0x80 != 0 {
+		d = skip(d, 2)
+	}
+	if flags&0x40 != 0 && len(d) > 0 {
+		d = skip(d, 1+int(d[0]))
+	}
+	if flags&0x20 != 0 {
+		d = skip(d, 2)
+	}
+	tag, d = readDescriptor(d)
+	if tag != 0x04 || len(d) < 1 {
+		return 0, fmt.Errorf("%w: invalid decoder config descriptor", errMP4)
+	}
+	return d[0], nil
+}
+
+// parseSampleTable returns the positions and times of samples in the
+// file. Times are not set if timescale is zero. Samples must fit into
+// the file of fileSize bytes.
+func parseSampleTable(stbl []byte, timescale uint32, fileSize int64) ([]mp4Sample, error) {
+	stsz, err := findBox(stbl, "stsz")
+	if err != nil {
+		return nil, err
+	}
+	if len(stsz) < 12 {
+		return nil, fmt.Errorf("%w: short sample size box", errMP4)
+	}
+	size := binary.BigEndian.Uint32(stsz[4:8])
+	count := int(binary.BigEndian.Uint32(stsz[8:12]))
+	if size == 0 && len(stsz) < 12+4*count {
+		return nil, fmt.Errorf("%w: short sample size box", errMP4)
+	}
+	if count > maxSamples || int64(size)*int64(count) > fileSize {
+		return nil, fmt.Errorf("%w: invalid sample count", errMP4)
+	}
+	sampleSize := func(i int) int64 {
+		if size != 0 {
+			return int64(size)
+		}
+		return int64(binary.BigEndian.Uint32(stsz[12+4*i:]))
+	}
+
+	var chunks []int64
+	if stco, err := findBox(stbl, "stco"); err == nil {
+		if len(stco) < 8 {
+			return nil, fmt.Errorf("%w: short chunk offset box", errMP4)
+		}
+		n := int(binary.BigEndian.Uint32(stco[4:8]))
+		if len(stco) < 8+4*n {
+			return nil, fmt.Errorf("%w: short chunk offset box", errMP4)
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(stco[8+4*i:])))
+		}
+	} else if co64, err := findBox(stbl, "co64"); err == nil {
+		if len(co64) < 8 {
+			return nil, fmt.Errorf("%w: short chunk offset box", errMP4)
+		}
+		n := int(binary.BigEndian.Uint32(co64[4:8]))
+		if len(co64) < 8+8*n {
+			return nil, fmt.Errorf("%w: short chunk offset box", errMP4)
+		}
+		for i := 0; i < n; i++ {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(co64[8+8*i:])))
+		}
+	} else {
+		return nil, fmt.Errorf("%w: no chunk offsets", errMP4)
+	}
+
+	stsc, err := findBox(stbl, "stsc")
+	if err != nil {
+		return nil, err
+	}
+	if len(stsc) < 8 {
+		return nil, fmt.Errorf("%w: short sample to chunk box", errMP4)
+	}
+	runs := int(binary.BigEndian.Uint32(stsc[4:8]))
+	if len(stsc) < 8+12*runs {
+		return nil, fmt.Errorf("%w: short sample to chunk box", errMP4)
+	}
+
+	samples := make([]mp4Sample, 0, count)
+	for r := 0; r < runs && len(samples) < count; r++ {
+		entry := stsc[8+12*r:]
+		first := int(binary.BigEndian.Uint32(entry[0:4])) - 1
+		perChunk := int(binary.BigEndian.Uint32(entry[4:8]))
+		last := len(chunks)
+		if r+1 < runs {
+			last = int(binary.BigEndian.Uint32(stsc[8+12*(r+1):])) - 1
+		}
+		if first < 0 || last > len(chunks) {
+			return nil, fmt.Errorf("%w: invalid sample to chunk box", errMP4)
+		}
+		for c := first; c < last && len(samples) < count; c++ {
+			offset := chunks[c]
+			for i := 0; i < perChunk && len(samples) < count; i++ {
+				s := sampleSize(len(samples))
+				samples = append(samples, mp4Sample{offset: offset, size: s})
+				offset += s
+			}
+		}
+	}
+	if stts, err := findBox(stbl, "stts"); err == nil && len(stts) >= 8 {
+		var (
+			t uint64
+			i int
+		)
+		runs := int(binary.BigEndian.Uint32(stts[4:8]))
+		for r := 0; r < runs && 8+8*(r+1) <= len(stts); r++ {
+			count := binary.BigEndian.Uint32(stts[8+8*r:])
+			delta := binary.BigEndian.Uint32(stts[12+8*r:])
+			for j := uint32(0); j < count && i < len(samples); j++ {
+				samples[i].start = scaleTime(t, timescale)
+				samples[i].duration = scaleTime(uint64(delta), timescale)
+				t += uint64(delta)
+				i++
+			}
+		}
+	}
+	return samples, nil
+}
+
+// readBoxHeader reads the type and payload size of the box. Negative
+// size means the box extends to the end of file.
+func readBoxHeader(r io.Reader) (string, int64, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return "", 0, fmt.Errorf("%w: truncated box", errMP4)
+		}
+		return "", 0, err
+	}
+	kind := string(header[4:])
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	switch size {
+	case 0:
+		return kind, -1, nil
+	case 1:
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return "", 0, fmt.Errorf("%w: truncated box", errMP4)
+		}
+		size = int64(binary.BigEndian.Uint64(header[:])) - 16
+	default:
+		size -= 8
+	}
+	if size < 0 {
+		return "", 0, fmt.Errorf("%w: invalid box size", errMP4)
+	}
+	return kind, size, nil
+}
+
+// parseBoxes splits data into boxes.
+func parseBoxes(data []byte) ([]box, error) {
+	var boxes []box
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		kind, size, err := readBoxHeader(r)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 || size > int64(r.Len()) {
+			size = int64(r.Len())
+		}
+		start := len(data) - r.Len()
+		boxes = append(boxes, box{kind: kind, payload: data[start : start+int(size)]})
+		r.Seek(size, io.SeekCurrent)
+	}
+	return boxes, nil
+}
+
+// findBox returns the payload of the box found by the path of types.
+func findBox(data []byte, path ...string) ([]byte, error) {
+	for _, kind := range path {
+		boxes, err := parseBoxes(data)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, b := range boxes {
+			if b.kind == kind {
+				data, found = b.payload, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: no %s box", errMP4, kind)
+		}
+	}
+	return data, nil
+}
+
+// readDescriptor returns the tag of mpeg-4 descriptor and its payload.
+func readDescriptor(d []byte) (byte, []byte) {
+	if len(d) < 2 {
+		return 0, nil
+	}
+	tag := d[0]
+	size := 0
+	i := 1
+	for ; i < len(d) && i < 5; i++ {
+		size = size<<7 | int(d[i]&0x7F)
+		if d[i]&0x80 == 0 {
+			i++
+			break
+		}
+	}
+	d = d[i:]
+	if size < len(d) {
+		d = d[:size]
+	}
+	return tag, d
+}
+
+func skip(d []byte, n int) []byte {
+	if n > len(d) {
+		return nil
+	}
+	return d[n:]
+}
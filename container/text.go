@@ -0,0 +1,198 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultCueDuration is the duration of the last cue if container
+// doesn't provide it.
+const defaultCueDuration = 3 * time.Second
+
+// assTags are style override tags of ASS/SSA subtitles.
+var assTags = regexp.MustCompile(`\{[^}]*\}`)
+
+type (
+	// Text contains text tracks of container: subtitles with transcripts
+	// and chapters.
+	Text struct {
+		Subtitles []Subtitles
+		Chapters  []Chapter
+	}
+
+	// Subtitles is the text track of container.
+	Subtitles struct {
+		Language string
+		Cues     []Cue
+	}
+
+	// Cue is the text displayed between start and end.
+	Cue struct {
+		Start time.Duration
+		End   time.Duration
+		Text  string
+	}
+
+	// Chapter marks the part of media.
+	Chapter struct {
+		Start time.Duration
+		End   time.Duration
+		Title string
+	}
+
+	// jsonChapter is the chapter with times in seconds.
+	jsonChapter struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Title string  `json:"title"`
+	}
+)
+
+// ExtractText returns subtitles and chapters of container. Only text
+// subtitles are returned, bitmap subtitles are ignored.
+func ExtractText(rs io.ReadSeeker) (Text, error) {
+	d, err := demux(rs)
+	if err != nil {
+		return Text{}, err
+	}
+	return d.text()
+}
+
+// Empty returns true if there are no subtitles and chapters.
+func (t Text) Empty() bool {
+	return len(t.Subtitles) == 0 && len(t.Chapters) == 0
+}
+
+// WriteSRT writes subtitles in SubRip format.
+func (s Subtitles) WriteSRT(w io.Writer) error {
+	for i, c := range s.Cues {
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, srtTime(c.Start), srtTime(c.End), c.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteChapters writes chapters in JSON format. Times are in seconds:
+//
+//	[{"start": 0, "end": 61.5, "title": "Intro"}]
+func WriteChapters(w io.Writer, chapters []Chapter) error {
+	if chapters == nil {
+		chapters = []Chapter{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(chapters)
+}
+
+// ReadChapters reads chapters in JSON format written by WriteChapters.
+// Chapters must be sorted by start, missing ends are set to the start of
+// next chapter.
+func ReadChapters(r io.Reader) ([]Chapter, error) {
+	var chapters []Chapter
+	if err := json.NewDecoder(r).Decode(&chapters); err != nil {
+		return nil, fmt.Errorf("invalid chapters: %v", err)
+	}
+	for i, c := range chapters {
+		if c.Start < 0 || i > 0 && c.Start < chapters[i-1].Start {
+			return nil, fmt.Errorf("invalid chapters: chapter %d starts at %v", i+1, c.Start)
+		}
+	}
+	fillChapterEnds(chapters, 0)
+	return chapters, nil
+}
+
+// MarshalJSON encodes the chapter with times in seconds.
+func (c Chapter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonChapter{
+		Start: c.Start.Seconds(),
+		End:   c.End.Seconds(),
+		Title: c.Title,
+	})
+}
+
+// UnmarshalJSON decodes the chapter with times in seconds.
+func (c *Chapter) UnmarshalJSON(b []byte) error {
+	var jc jsonChapter
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return err
+	}
+	*c = Chapter{
+		Start: time.Duration(math.Round(jc.Start * float64(time.Second))),
+		End:   time.Duration(math.Round(jc.End * float64(time.Second))),
+		Title: jc.Title,
+	}
+	return nil
+}
+
+// fillEnds sets missing ends of cues to the start of next cue. The last
+// cue ends with media or lasts default duration.
+func (s *Subtitles) fillEnds(duration time.Duration) {
+	for i := range s.Cues {
+		c := &s.Cues[i]
+		if c.End > c.Start {
+			continue
+		}
+		switch {
+		case i+1 < len(s.Cues):
+			c.End = s.Cues[i+1].Start
+		case duration > c.Start:
+			c.End = duration
+		default:
+			c.End = c.Start + defaultCueDuration
+		}
+	}
+}
+
+// fillChapterEnds sets missing ends of chapters to the start of next
+// chapter. The last chapter ends with media.
+func fillChapterEnds(chapters []Chapter, duration time.Duration) {
+	for i := range chapters {
+		c := &chapters[i]
+		if c.End > c.Start {
+			continue
+		}
+		switch {
+		case i+1 < len(chapters):
+			c.End = chapters[i+1].Start
+		case duration > c.Start:
+			c.End = duration
+		}
+	}
+}
+
+// textCodec returns true if subtitles codec is text-based.
+func textCodec(codec string) bool {
+	switch codec {
+	case "S_TEXT/UTF8", "S_TEXT/ASS", "S_TEXT/SSA", "S_TEXT/WEBVTT", "S_ASS", "S_SSA", "tx3g", "text":
+		return true
+	}
+	return false
+}
+
+// cueText converts the subtitle block into plain text.
+func cueText(codec, text string) string {
+	switch codec {
+	case "S_TEXT/ASS", "S_TEXT/SSA", "S_ASS", "S_SSA":
+		// ReadOrder, Layer, Style, Name, MarginL, MarginR, MarginV,
+		// Effect, Text
+		if fields := strings.SplitN(text, ",", 9); len(fields) == 9 {
+			text = fields[8]
+		}
+		text = assTags.ReplaceAllString(text, "")
+		text = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(text)
+	}
+	return strings.TrimSpace(strings.Replace(text, "\r\n", "\n", -1))
+}
+
+// srtTime formats the time as HH:MM:SS,mmm.
+func srtTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,248 @@
+// Package cue parses cue sheets that describe tracks of disc images.
+package cue
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pipelined.dev/phono/tag"
+)
+
+// framesPerSecond is the number of CD frames in a second of cue time.
+const framesPerSecond = 75
+
+type (
+	// Sheet is the parsed cue sheet. Tags are fields of the whole disc:
+	// album, album artist, genre, date, composer and comment.
+	Sheet struct {
+		Tags   tag.Tags
+		Tracks []Track
+	}
+
+	// Track is the audio track of the sheet. File is the image path as
+	// it's written in the sheet. Start is the position of INDEX 01, End
+	// is the start of next track in the same file or zero if the track
+	// lasts until the end of the file. Tags are title, artist and
+	// composer of the track.
+	Track struct {
+		Number int
+		File   string
+		Start  time.Duration
+		End    time.Duration
+		Tags   tag.Tags
+	}
+)
+
+// Parse parses the cue sheet. Latin-1 sheets are converted to UTF-8.
+// Tracks without INDEX 01 start where INDEX 00 is, tracks with neither of
+// them are invalid.
+func Parse(r io.Reader) (*Sheet, error) {
+	s := Sheet{Tags: tag.Tags{}}
+	var (
+		file    string
+		track   *Track
+		indexed bool
+		line    int
+	)
+	// ends the current track
+	endTrack := func() error {
+		if track == nil {
+			return nil
+		}
+		if !indexed {
+			return fmt.Errorf("track %d has no index", track.Number)
+		}
+		s.Tracks = append(s.Tracks, *track)
+		track = nil
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if line == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if !utf8.ValidString(text) {
+			text = latin1(text)
+		}
+		args := fields(text)
+		if len(args) == 0 {
+			continue
+		}
+		// disc fields before the first track, track fields after
+		set := func(disc, trk tag.Field, v string) {
+			switch {
+			case track == nil:
+				setTag(s.Tags, disc, v)
+			case trk != "":
+				setTag(track.Tags, trk, v)
+			}
+		}
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "FILE" && len(args) >= 2:
+			if err := endTrack(); err != nil {
+				return nil, err
+			}
+			file = args[1]
+		case cmd == "TRACK" && len(args) >= 3:
+			if err := endTrack(); err != nil {
+				return nil, err
+			}
+			if file == "" {
+				return nil, fmt.Errorf("line %d: track before file", line)
+			}
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 || n > 99 {
+				return nil, fmt.Errorf("line %d: invalid track number %q", line, args[1])
+			}
+			indexed = false
+			// data tracks are skipped
+			if strings.ToUpper(args[2]) == "AUDIO" {
+				track = &Track{Number: n, File: file, Tags: tag.Tags{}}
+			} else {
+				indexed = true
+			}
+		case cmd == "INDEX" && len(args) >= 3 && track != nil:
+			start, err := ParseTime(args[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			switch args[1] {
+			case "01", "1":
+				track.Start = start
+				indexed = true
+			case "00", "0":
+				if !indexed {
+					track.Start = start
+					indexed = true
+				}
+			}
+		case cmd == "TITLE" && len(args) >= 2:
+			set(tag.Album, tag.Title, args[1])
+		case cmd == "PERFORMER" && len(args) >= 2:
+			set(tag.AlbumArtist, tag.Artist, args[1])
+		case cmd == "SONGWRITER" && len(args) >= 2:
+			set(tag.Composer, tag.Composer, args[1])
+		case cmd == "REM" && len(args) >= 3:
+			switch strings.ToUpper(args[1]) {
+			case "GENRE":
+				set(tag.Genre, "", args[2])
+			case "DATE":
+				set(tag.Date, "", args[2])
+			case "COMMENT":
+				set(tag.Comment, "", args[2])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := endTrack(); err != nil {
+		return nil, err
+	}
+	if len(s.Tracks) == 0 {
+		return nil, fmt.Errorf("no audio tracks")
+	}
+	for i := range s.Tracks {
+		if i+1 < len(s.Tracks) && s.Tracks[i+1].File == s.Tracks[i].File {
+			if s.Tracks[i+1].Start < s.Tracks[i].Start {
+				return nil, fmt.Errorf("track %d starts before track %d", s.Tracks[i+1].Number, s.Tracks[i].Number)
+			}
+			s.Tracks[i].End = s.Tracks[i+1].Start
+		}
+	}
+	return &s, nil
+}
+
+// TrackTags returns tags of the track merged with tags of the disc. Track
+// artist defaults to the album artist, track number includes the number
+// of tracks in the sheet.
+func (s *Sheet) TrackTags(i int) tag.Tags {
+	t := make(tag.Tags, len(s.Tags)+len(s.Tracks[i].Tags)+2)
+	for f, v := range s.Tags {
+		t[f] = v
+	}
+	if v, ok := s.Tags[tag.AlbumArtist]; ok {
+		t[tag.Artist] = v
+	}
+	for f, v := range s.Tracks[i].Tags {
+		t[f] = v
+	}
+	t[tag.Track] = fmt.Sprintf("%d/%d", s.Tracks[i].Number, len(s.Tracks))
+	return t
+}
+
+// ParseTime parses MM:SS:FF time of the sheet, where FF are CD frames.
+// Minutes can exceed 59.
+func ParseTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid time %q: expected MM:SS:FF", s)
+	}
+	var values [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time %q: expected MM:SS:FF", s)
+		}
+		values[i] = v
+	}
+	if values[1] > 59 || values[2] >= framesPerSecond {
+		return 0, fmt.Errorf("invalid time %q: seconds or frames out of range", s)
+	}
+	frames := (values[0]*60+values[1])*framesPerSecond + values[2]
+	return time.Duration(frames) * time.Second / framesPerSecond, nil
+}
+
+// fields splits the line into words. Quoted strings are single words
+// without quotes.
+func fields(line string) []string {
+	var (
+		result []string
+		word   strings.Builder
+		quoted bool
+		inWord bool
+	)
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inWord = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inWord {
+				result = append(result, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		result = append(result, word.String())
+	}
+	return result
+}
+
+// setTag sets the trimmed value if it's not empty.
+func setTag(t tag.Tags, f tag.Field, v string) {
+	if v = strings.TrimSpace(v); v != "" {
+		t[f] = v
+	}
+}
+
+// latin1 converts Latin-1 string to UTF-8.
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
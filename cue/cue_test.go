@@ -0,0 +1,84 @@
+package cue_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/cue"
+	"pipelined.dev/phono/tag"
+)
+
+const sheet = "\ufeffREM GENRE Rock\n" +
+	"REM DATE 1996\n" +
+	"PERFORMER \"Tool\"\n" +
+	"TITLE \"Ænima\"\n" +
+	"FILE \"Tool - Ænima.wav\" WAVE\n" +
+	"  TRACK 01 AUDIO\n" +
+	"    TITLE \"Stinkfist\"\n" +
+	"    INDEX 01 00:00:00\n" +
+	"  TRACK 02 AUDIO\n" +
+	"    TITLE \"Eulogy\"\n" +
+	"    PERFORMER \"Maynard\"\n" +
+	"    INDEX 00 05:10:50\n" +
+	"    INDEX 01 05:11:00\n" +
+	"  TRACK 03 MODE1/2352\n" +
+	"    INDEX 01 13:39:00\n" +
+	"FILE \"bonus.flac\" WAVE\n" +
+	"  TRACK 04 AUDIO\n" +
+	"    TITLE \"H.\"\n" +
+	"    INDEX 00 00:01:00\n"
+
+func TestParse(t *testing.T) {
+	s, err := cue.Parse(strings.NewReader(sheet))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{
+		tag.Genre:       "Rock",
+		tag.Date:        "1996",
+		tag.AlbumArtist: "Tool",
+		tag.Album:       "Ænima",
+	}, s.Tags)
+	assert.Equal(t, []cue.Track{
+		{Number: 1, File: "Tool - Ænima.wav", End: 311 * time.Second, Tags: tag.Tags{tag.Title: "Stinkfist"}},
+		{Number: 2, File: "Tool - Ænima.wav", Start: 311 * time.Second, Tags: tag.Tags{tag.Title: "Eulogy", tag.Artist: "Maynard"}},
+		{Number: 4, File: "bonus.flac", Start: time.Second, Tags: tag.Tags{tag.Title: "H."}},
+	}, s.Tracks)
+	assert.Equal(t, tag.Tags{
+		tag.Genre:       "Rock",
+		tag.Date:        "1996",
+		tag.AlbumArtist: "Tool",
+		tag.Album:       "Ænima",
+		tag.Artist:      "Tool",
+		tag.Title:       "Stinkfist",
+		tag.Track:       "1/3",
+	}, s.TrackTags(0))
+	assert.Equal(t, "Maynard", s.TrackTags(1)[tag.Artist])
+
+	// latin-1
+	s, err = cue.Parse(strings.NewReader("FILE a.wav WAVE\nTRACK 01 AUDIO\nTITLE \"\xc6nima\"\nINDEX 01 00:00:00\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Ænima", s.Tracks[0].Tags[tag.Title])
+
+	for _, invalid := range []string{
+		"",
+		"TRACK 01 AUDIO\nINDEX 01 00:00:00\n",
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\n",
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\nINDEX 01 00:60:00\n",
+		"FILE a.wav WAVE\nTRACK 01 AUDIO\nINDEX 01 01:00:00\nTRACK 02 AUDIO\nINDEX 01 00:30:00\n",
+	} {
+		_, err = cue.Parse(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	d, err := cue.ParseTime("75:00:74")
+	assert.NoError(t, err)
+	assert.Equal(t, 75*time.Minute+74*time.Second/75, d)
+	for _, invalid := range []string{"1:2", "00:00:75", "a:00:00", "-1:00:00"} {
+		_, err = cue.ParseTime(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,255 @@
+// Package dav provides WebDAV share that converts dropped files. It lets
+// applications that can mount network drives, e.g. legacy DAWs, use phono
+// without the web form or the API.
+package dav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/encode"
+)
+
+// DoneFolder is the folder of the share with converted files.
+const DoneFolder = "done"
+
+type (
+	// Share is the WebDAV share where every folder is the format of the
+	// output, e.g. mp3-v0 or wav-16. Folder names are specs of outputs
+	// with settings separated by dash instead of colon, see
+	// codec.ParseSpec. When the file is written into the folder, it's
+	// converted and the result is written into done folder with the same
+	// name and extension of the output format. Converted inputs are
+	// removed, inputs that failed are kept. Folders of formats can be also
+	// created by clients. Buffering, StallTimeout, Timeout and TempDir
+	// configure conversions, conversions that run longer than non-zero
+	// Timeout are cancelled. Uploaded files larger than non-zero MaxSize
+	// are rejected. Owner of the request is returned by Owner function,
+	// e.g. the name of API key. If Owner is not nil, every owner has its
+	// own folders and requests without owner are rejected. Fields can be
+	// set before the first use.
+	Share struct {
+		Buffering    encode.Buffering
+		StallTimeout time.Duration
+		Timeout      time.Duration
+		MaxSize      int64
+		TempDir      string
+		Owner        func(*http.Request) string
+
+		prefix  string
+		dir     string
+		folders []string
+		slots   chan struct{}
+		wg      sync.WaitGroup
+
+		mu sync.Mutex
+		// handlers serve directories of owners
+		handlers map[string]*webdav.Handler
+	}
+
+	// statusWriter records the status of the response.
+	statusWriter struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+// NewShare returns the share of the directory served under the prefix
+// path. Directory of the share is created if it doesn't exist. Folders of
+// output formats and done folder are created when the share is used for
+// the first time, in the subdirectory of the owner if Owner is set.
+// Number of simultaneous conversions is limited by the number of CPUs.
+func NewShare(prefix, dir string, folders []string) (*Share, error) {
+	for _, folder := range folders {
+		sink, params, err := parseFolder(folder)
+		if err != nil {
+			return nil, fmt.Errorf("folder %s: %w", folder, err)
+		}
+		if _, err := sink.NewEncoder(params); err != nil {
+			return nil, fmt.Errorf("folder %s: %w", folder, err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Share{
+		prefix:   prefix,
+		dir:      dir,
+		folders:  append(folders[:len(folders):len(folders)], DoneFolder),
+		slots:    make(chan struct{}, runtime.NumCPU()),
+		handlers: make(map[string]*webdav.Handler),
+	}, nil
+}
+
+// ServeHTTP serves WebDAV requests. Conversion starts when the file is
+// uploaded or moved into the folder of the format.
+func (s *Share) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	dir := s.dir
+	if s.Owner != nil {
+		owner := s.Owner(r)
+		if owner == "" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		dir = filepath.Join(s.dir, ownerDir(owner))
+	}
+	if s.MaxSize > 0 && r.Body != nil {
+		if r.ContentLength > s.MaxSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.MaxSize)
+	}
+	h, err := s.handler(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	h.ServeHTTP(sw, r)
+	if sw.status != http.StatusCreated && sw.status != http.StatusNoContent {
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.convert(dir, r.URL.Path)
+	case "MOVE", "COPY":
+		if u, err := url.Parse(r.Header.Get("Destination")); err == nil {
+			s.convert(dir, u.Path)
+		}
+	}
+}
+
+// handler returns the handler of the directory. Folders of the share are
+// created when the handler is used for the first time.
+func (s *Share) handler(dir string) (*webdav.Handler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.handlers[dir]; ok {
+		return h, nil
+	}
+	for _, folder := range s.folders {
+		if err := os.MkdirAll(filepath.Join(dir, folder), 0755); err != nil {
+			return nil, err
+		}
+	}
+	h := &webdav.Handler{
+		Prefix:     s.prefix,
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	s.handlers[dir] = h
+	return h, nil
+}
+
+// ownerDir returns the name of owner subdirectory. Owners are hashed, so
+// their names and keys are not exposed in the file system.
+func ownerDir(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Wait waits until started conversions are done.
+func (s *Share) Wait() {
+	s.wg.Wait()
+}
+
+// convert starts conversion of the file with the url path in the
+// directory if it's the supported input in the folder of the format.
+func (s *Share) convert(dir, urlPath string) {
+	name := strings.TrimPrefix(path.Clean(strings.TrimPrefix(urlPath, s.prefix)), "/")
+	folder, file := path.Split(name)
+	folder = strings.TrimSuffix(folder, "/")
+	if folder == "" || strings.Contains(folder, "/") || folder == DoneFolder || strings.HasPrefix(file, ".") {
+		return
+	}
+	sink, params, err := parseFolder(folder)
+	if err != nil {
+		// regular folder
+		return
+	}
+	if _, err := codec.LookupPump(path.Ext(file)); err != nil {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.slots <- struct{}{}
+		defer func() { <-s.slots }()
+		input := filepath.Join(dir, folder, file)
+		output := filepath.Join(dir, DoneFolder, strings.TrimSuffix(file, path.Ext(file))+sink.Extension)
+		if err := s.encode(input, output, sink, params); err != nil {
+			log.Printf("Failed to convert %s: %v", name, err)
+			return
+		}
+		if err := os.Remove(input); err != nil {
+			log.Printf("Failed to remove converted %s: %v", name, err)
+		}
+	}()
+}
+
+// encode converts the input file into the output. Result is written into
+// the hidden temp file that replaces the output when conversion is done,
+// so clients don't see partial results.
+func (s *Share) encode(input, output string, sink codec.Sink, params codec.Params) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := ioutil.TempFile(filepath.Dir(output), "."+filepath.Base(output)+"-*")
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if s.Timeout > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, s.Timeout)
+		defer cancelFn()
+	}
+	err = encode.Encode(ctx, in, out, encode.Options{
+		Input:        filepath.Ext(input),
+		Output:       sink.Name,
+		Params:       params,
+		Buffering:    s.Buffering,
+		StallTimeout: s.StallTimeout,
+		Dither:       encode.DitherAuto,
+		TempDir:      s.TempDir,
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), output)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// parseFolder returns the sink and params of the folder name.
+func parseFolder(folder string) (codec.Sink, codec.Params, error) {
+	return codec.ParseSpec(strings.Replace(folder, "-", ":", 1))
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
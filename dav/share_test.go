@@ -0,0 +1,132 @@
+package dav_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/dav"
+	"pipelined.dev/phono/encode"
+)
+
+func TestShare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-dav")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = dav.NewShare("/webdav", dir, []string{"mp3-x0"})
+	assert.Error(t, err)
+	_, err = dav.NewShare("/webdav", dir, []string{"wav-20"})
+	assert.Error(t, err)
+
+	share, err := dav.NewShare("/webdav", dir, []string{"wav-16"})
+	assert.NoError(t, err)
+	share.Buffering = encode.Buffering{Size: 512}
+	server := httptest.NewServer(share)
+	defer server.Close()
+
+	sample, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	put := func(name string, body []byte) {
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/webdav/"+name, bytes.NewReader(body))
+		assert.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	put("wav-16/sample.wav", sample)
+	put("wav-16/notes.txt", []byte("notes"))
+	put("wav-16/broken.wav", []byte("not media"))
+	share.Wait()
+
+	result, err := ioutil.ReadFile(filepath.Join(dir, dav.DoneFolder, "sample.wav"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(result, []byte("RIFF")))
+	assert.NoFileExists(t, filepath.Join(dir, "wav-16", "sample.wav"))
+
+	// unsupported and failed inputs are kept
+	assert.FileExists(t, filepath.Join(dir, "wav-16", "notes.txt"))
+	assert.FileExists(t, filepath.Join(dir, "wav-16", "broken.wav"))
+	files, err := ioutil.ReadDir(filepath.Join(dir, dav.DoneFolder))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(files))
+}
+
+func TestShareOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-dav")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	share, err := dav.NewShare("/webdav", dir, []string{"wav-16"})
+	assert.NoError(t, err)
+	sample, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	share.Buffering = encode.Buffering{Size: 512}
+	share.MaxSize = int64(len(sample))
+	share.Owner = func(r *http.Request) string {
+		return r.Header.Get("X-Owner")
+	}
+	server := httptest.NewServer(share)
+	defer server.Close()
+
+	do := func(method, owner, name string, body []byte) int {
+		req, err := http.NewRequest(method, server.URL+"/webdav/"+name, bytes.NewReader(body))
+		assert.NoError(t, err)
+		if owner != "" {
+			req.Header.Set("X-Owner", owner)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "", "done/", nil))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, do(http.MethodPut, "alice", "wav-16/large.wav", make([]byte, share.MaxSize+1)))
+	assert.Equal(t, http.StatusCreated, do(http.MethodPut, "alice", "wav-16/sample.wav", sample))
+	share.Wait()
+
+	// owners don't see folders of each other
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "alice", "done/sample.wav", nil))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "bob", "done/sample.wav", nil))
+	owners, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(owners))
+	for _, owner := range owners {
+		assert.NotContains(t, []string{"alice", "bob"}, owner.Name())
+	}
+}
+
+func TestShareTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-dav")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	share, err := dav.NewShare("/webdav", dir, []string{"wav-16"})
+	assert.NoError(t, err)
+	share.Buffering = encode.Buffering{Size: 512}
+	share.Timeout = time.Nanosecond
+	server := httptest.NewServer(share)
+	defer server.Close()
+
+	sample, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/webdav/wav-16/sample.wav", bytes.NewReader(sample))
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	share.Wait()
+
+	// cancelled input is kept
+	assert.FileExists(t, filepath.Join(dir, "wav-16", "sample.wav"))
+	assert.NoFileExists(t, filepath.Join(dir, dav.DoneFolder, "sample.wav"))
+}
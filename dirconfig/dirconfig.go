@@ -0,0 +1,273 @@
+// Package dirconfig reads per-directory option files. Files are merged
+// hierarchically, so options of the directory apply to its whole subtree
+// unless they are overridden by the files in subdirectories.
+//
+// Files are YAML documents with flat mapping of keys to scalars or lists
+// of scalars:
+//
+//	# audiobooks
+//	bitratemode: cbr
+//	bitrate: 64
+//	processor:
+//	  - gain:db=3
+package dirconfig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of options file.
+const FileName = ".phono.yaml"
+
+type (
+	// Options maps option names to their values. Scalar options have
+	// exactly one value.
+	Options map[string][]string
+
+	// Tree resolves options of directories in the tree. Options of the
+	// root directory are inherited by all subdirectories. Files outside
+	// of the root are ignored. Resolved options are cached, so Tree is
+	// meant to be used for a single walk. It's not safe for concurrent
+	// use.
+	Tree struct {
+		root  string
+		cache map[string]Options
+	}
+)
+
+// NewTree returns the tree with provided root directory.
+func NewTree(root string) *Tree {
+	return &Tree{
+		root:  filepath.Clean(root),
+		cache: make(map[string]Options),
+	}
+}
+
+// Options returns merged options of directory. Directory must be inside
+// the root of the tree.
+func (t *Tree) Options(dir string) (Options, error) {
+	dir = filepath.Clean(dir)
+	if opts, ok := t.cache[dir]; ok {
+		return opts, nil
+	}
+	var parent Options
+	if dir != t.root {
+		if !inside(t.root, dir) {
+			return nil, fmt.Errorf("directory %s is outside of %s", dir, t.root)
+		}
+		up := filepath.Dir(dir)
+		var err error
+		if parent, err = t.Options(up); err != nil {
+			return nil, err
+		}
+	}
+	opts, err := ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		return nil, err
+	}
+	opts = parent.Merge(opts)
+	t.cache[dir] = opts
+	return opts, nil
+}
+
+// ReadFile reads options file. Missing file results in empty options.
+func ReadFile(path string) (Options, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Options{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	opts, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return opts, nil
+}
+
+// Read parses options. Options must be a mapping of keys to scalars or
+// lists of scalars, empty value is an empty list.
+func Read(r io.Reader) (Options, error) {
+	var doc yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Options{}, nil
+		}
+		return nil, err
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: expected key: value", root.Line)
+	}
+	opts := make(Options, len(root.Content)/2)
+	for i := 0; i < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: expected key: value", key.Line)
+		}
+		if _, ok := opts[key.Value]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %s", key.Line, key.Value)
+		}
+		switch {
+		case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+			opts[key.Value] = []string{}
+		case value.Kind == yaml.ScalarNode:
+			opts[key.Value] = []string{value.Value}
+		case value.Kind == yaml.SequenceNode:
+			values := make([]string, 0, len(value.Content))
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("line %d: option %s must be list of values", item.Line, key.Value)
+				}
+				values = append(values, item.Value)
+			}
+			opts[key.Value] = values
+		default:
+			return nil, fmt.Errorf("line %d: option %s must be value or list", value.Line, key.Value)
+		}
+	}
+	return opts, nil
+}
+
+// Merge returns new options where options of child override the options
+// of o.
+func (o Options) Merge(child Options) Options {
+	merged := make(Options, len(o)+len(child))
+	for k, v := range o {
+		merged[k] = v
+	}
+	for k, v := range child {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Omit returns new options without provided keys.
+func (o Options) Omit(keys map[string]bool) Options {
+	result := make(Options, len(o))
+	for k, v := range o {
+		if !keys[k] {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Has returns true if option is set.
+func (o Options) Has(key string) bool {
+	_, ok := o[key]
+	return ok
+}
+
+// Check returns error if options contain keys that are not allowed.
+func (o Options) Check(allowed ...string) error {
+	known := make(map[string]struct{}, len(allowed))
+	for _, k := range allowed {
+		known[k] = struct{}{}
+	}
+	var unknown []string
+	for k := range o {
+		if _, ok := known[k]; !ok {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown options: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// String returns the value of scalar option or def if it's not set.
+func (o Options) String(key, def string) (string, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	if len(v) != 1 {
+		return "", fmt.Errorf("option %s must have single value", key)
+	}
+	return v[0], nil
+}
+
+// Int returns the value of integer option or def if it's not set.
+func (o Options) Int(key string, def int) (int, error) {
+	s, err := o.String(key, strconv.Itoa(def))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be integer: %w", key, err)
+	}
+	return v, nil
+}
+
+// Float returns the value of float option or def if it's not set.
+func (o Options) Float(key string, def float64) (float64, error) {
+	s, err := o.String(key, strconv.FormatFloat(def, 'g', -1, 64))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be number: %w", key, err)
+	}
+	return v, nil
+}
+
+// Bool returns the value of boolean option or def if it's not set.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	s, err := o.String(key, strconv.FormatBool(def))
+	if err != nil {
+		return false, err
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("option %s must be boolean: %w", key, err)
+	}
+	return v, nil
+}
+
+// Duration returns the value of duration option or def if it's not set.
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	s, err := o.String(key, def.String())
+	if err != nil {
+		return 0, err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("option %s must be duration: %w", key, err)
+	}
+	return v, nil
+}
+
+// Strings returns the values of option or def if it's not set. Scalar
+// options are returned as a single value list.
+func (o Options) Strings(key string, def []string) []string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return def
+}
+
+// inside returns true if dir is inside of root. Both paths must be clean.
+func inside(root, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
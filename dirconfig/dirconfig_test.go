@@ -0,0 +1,91 @@
+package dirconfig_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/dirconfig"
+)
+
+func TestRead(t *testing.T) {
+	opts, err := dirconfig.Read(strings.NewReader(`
+# audiobooks
+bitratemode: cbr # comment
+bitrate: "64"
+processor:
+  - gain:db=3
+  - 'gain:db=-1'
+tags: [a, b]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, dirconfig.Options{
+		"bitratemode": {"cbr"},
+		"bitrate":     {"64"},
+		"processor":   {"gain:db=3", "gain:db=-1"},
+		"tags":        {"a", "b"},
+	}, opts)
+
+	bitRate, err := opts.Int("bitrate", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, bitRate)
+	_, err = opts.String("processor", "")
+	assert.Error(t, err)
+	assert.Error(t, opts.Check("bitratemode", "bitrate", "processor"))
+	assert.NoError(t, opts.Check("bitratemode", "bitrate", "processor", "tags"))
+
+	for _, invalid := range []string{
+		"key",
+		"- item",
+		"key: 1\nkey: 2",
+		"key: {a: b}",
+		"key: [[a]]",
+	} {
+		_, err := dirconfig.Read(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestTree(t *testing.T) {
+	root, err := ioutil.TempDir("", "dirconfig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	music := filepath.Join(root, "music")
+	books := filepath.Join(root, "books", "novel")
+	assert.NoError(t, os.MkdirAll(music, 0755))
+	assert.NoError(t, os.MkdirAll(books, 0755))
+	write := func(dir, content string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, dirconfig.FileName), []byte(content), 0644))
+	}
+	write(root, "bitratemode: vbr\nbitrate: 2\n")
+	write(filepath.Join(root, "books"), "bitratemode: cbr\nbitrate: 64\nchannelmode: 0\n")
+	write(books, "bitrate: 96\n")
+
+	tree := dirconfig.NewTree(root)
+	opts, err := tree.Options(music)
+	assert.NoError(t, err)
+	assert.Equal(t, dirconfig.Options{"bitratemode": {"vbr"}, "bitrate": {"2"}}, opts)
+
+	opts, err = tree.Options(books)
+	assert.NoError(t, err)
+	assert.Equal(t, dirconfig.Options{"bitratemode": {"cbr"}, "bitrate": {"96"}, "channelmode": {"0"}}, opts)
+
+	_, err = dirconfig.NewTree(music).Options(books)
+	assert.Error(t, err)
+	// sibling with the same prefix is outside of the root
+	musicVideo := filepath.Join(root, "music-video")
+	assert.NoError(t, os.Mkdir(musicVideo, 0755))
+	_, err = dirconfig.NewTree(music).Options(musicVideo)
+	assert.Error(t, err)
+}
+
+func TestOmit(t *testing.T) {
+	opts := dirconfig.Options{"bitrate": {"64"}, "bitratemode": {"cbr"}}
+	assert.Equal(t, dirconfig.Options{"bitratemode": {"cbr"}}, opts.Omit(map[string]bool{"bitrate": true}))
+	assert.Len(t, opts, 2)
+}
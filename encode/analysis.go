@@ -0,0 +1,148 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+type (
+	// Level is the level in dB. Silence is negative infinity and it's
+	// encoded as null in JSON.
+	Level float64
+
+	// Analysis contains the loudness and levels of the signal. Duration
+	// is in seconds. Integrated loudness is in LUFS, loudness range is
+	// in LU, true peak is in dBTP and other levels are in dBFS. Peaks of
+	// the whole signal are the max peaks of all channels.
+	Analysis struct {
+		Duration      float64           `json:"duration"`
+		SampleRate    signal.Frequency  `json:"sample_rate"`
+		Integrated    Level             `json:"integrated"`
+		LoudnessRange Level             `json:"loudness_range"`
+		TruePeak      Level             `json:"true_peak"`
+		SamplePeak    Level             `json:"sample_peak"`
+		Channels      []ChannelAnalysis `json:"channels"`
+	}
+
+	// ChannelAnalysis contains the levels of single channel.
+	ChannelAnalysis struct {
+		TruePeak   Level `json:"true_peak"`
+		SamplePeak Level `json:"sample_peak"`
+		RMS        Level `json:"rms"`
+	}
+
+	// Analyzer measures the signal that is written into its sink.
+	// Analysis is available after the run is done.
+	Analyzer struct {
+		analysis Analysis
+	}
+)
+
+// NewAnalyzer returns new analyzer.
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{}
+}
+
+// Sink wraps the sink allocator to measure the signal before it's
+// written, so the analysis can be done during the conversion. If fn is
+// nil, the signal is measured only.
+func (a *Analyzer) Sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			sink pipe.Sink
+			err  error
+		)
+		if fn != nil {
+			if sink, err = fn(mctx, bufferSize, props); err != nil {
+				return sink, err
+			}
+		}
+		m := newLoudnessMeter(props)
+		var frames int64
+		peaks := make([]float64, props.Channels)
+		squares := make([]float64, props.Channels)
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			m.write(in)
+			channels := in.Channels()
+			for i := 0; i < in.Len(); i++ {
+				v := in.Sample(i)
+				peaks[i%channels] = math.Max(peaks[i%channels], math.Abs(v))
+				squares[i%channels] += v * v
+			}
+			frames += int64(in.Length())
+			if sinkFn != nil {
+				return sinkFn(in)
+			}
+			return nil
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			loudness := m.loudness()
+			a.analysis = Analysis{
+				Duration:      float64(frames) / float64(props.SampleRate),
+				SampleRate:    props.SampleRate,
+				Integrated:    Level(loudness.Integrated),
+				LoudnessRange: Level(loudness.Range),
+				TruePeak:      Level(loudness.TruePeak),
+				Channels:      make([]ChannelAnalysis, props.Channels),
+			}
+			var samplePeak float64
+			for c := range a.analysis.Channels {
+				rms := 0.0
+				if frames > 0 {
+					rms = math.Sqrt(squares[c] / float64(frames))
+				}
+				a.analysis.Channels[c] = ChannelAnalysis{
+					TruePeak:   decibels(m.peak.peaks[c]),
+					SamplePeak: decibels(peaks[c]),
+					RMS:        decibels(rms),
+				}
+				samplePeak = math.Max(samplePeak, peaks[c])
+			}
+			a.analysis.SamplePeak = decibels(samplePeak)
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
+
+// Analysis returns the analysis of the signal.
+func (a *Analyzer) Analysis() Analysis {
+	return a.analysis
+}
+
+// String returns short description of the analysis.
+func (a Analysis) String() string {
+	return fmt.Sprintf("%v LUFS, LRA %v LU, true peak %v dBTP, sample peak %v dBFS", a.Integrated, a.LoudnessRange, a.TruePeak, a.SamplePeak)
+}
+
+// String returns the level with one decimal.
+func (l Level) String() string {
+	if math.IsInf(float64(l), -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(float64(l), 'f', 1, 64)
+}
+
+// MarshalJSON encodes the level with two decimals. Silence is encoded
+// as null.
+func (l Level) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(l), 0) || math.IsNaN(float64(l)) {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.FormatFloat(float64(l), 'f', 2, 64)), nil
+}
+
+// decibels converts the amplitude into the level.
+func decibels(amplitude float64) Level {
+	return Level(20 * math.Log10(amplitude))
+}
@@ -0,0 +1,70 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestAnalyzer(t *testing.T) {
+	format := fileformat.WAV()
+	analyze := func(wav []byte, fn pipe.SinkAllocatorFunc) encode.Analysis {
+		analyzer := encode.NewAnalyzer()
+		assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(bytes.NewReader(wav)), analyzer.Sink(fn)))
+		return analyzer.Analysis()
+	}
+
+	// reference signal of EBU Tech 3341 is steady, so its range is zero
+	a := analyze(sineWAV(48000, 997, -23, 10), nil)
+	assert.InDelta(t, 10, a.Duration, 0.001)
+	assert.InDelta(t, -23, float64(a.Integrated), 0.1)
+	assert.InDelta(t, 0, float64(a.LoudnessRange), 0.1)
+	assert.InDelta(t, -23, float64(a.TruePeak), 0.1)
+	assert.InDelta(t, -23, float64(a.SamplePeak), 0.1)
+	assert.Len(t, a.Channels, 2)
+	for _, c := range a.Channels {
+		assert.InDelta(t, -23, float64(c.SamplePeak), 0.1)
+		// rms of sine is 3 dB below its peak
+		assert.InDelta(t, -26, float64(c.RMS), 0.1)
+	}
+
+	// 10s at -20 dBFS followed by 10s at -30 dBFS
+	frames := make([]float64, 20*48000)
+	for i := range frames {
+		db := -20.0
+		if i >= len(frames)/2 {
+			db = -30
+		}
+		frames[i] = math.Pow(10, db/20) * math.Sin(2*math.Pi*997*float64(i)/48000)
+	}
+	a = analyze(stereoWAV(48000, frames), nil)
+	assert.InDelta(t, 10, float64(a.LoudnessRange), 0.5)
+
+	// signal is passed to the wrapped sink
+	var written int
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				written += in.Length()
+				return nil
+			},
+		}, nil
+	}
+	a = analyze(sineWAV(48000, 0, -20, 1), sink)
+	assert.Equal(t, 48000, written)
+	assert.True(t, math.IsInf(float64(a.Integrated), -1))
+	assert.Equal(t, "-inf", a.SamplePeak.String())
+	b, err := json.Marshal(a)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"integrated":null`)
+}
@@ -0,0 +1,137 @@
+package encode
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type (
+	// Batch collects results of conversions of multiple files. It's safe
+	// for concurrent use.
+	Batch struct {
+		mu    sync.Mutex
+		start time.Time
+		files []BatchFile
+	}
+
+	// BatchFile is the result of the file conversion. Durations are in
+	// seconds, Error is empty if the conversion succeeded.
+	BatchFile struct {
+		Path       string  `json:"path"`
+		Input      string  `json:"input,omitempty"`
+		Output     string  `json:"output,omitempty"`
+		InputSize  int64   `json:"input_size"`
+		OutputSize int64   `json:"output_size"`
+		Duration   float64 `json:"duration"`
+		Elapsed    float64 `json:"elapsed"`
+		Realtime   float64 `json:"realtime"`
+		Error      string  `json:"error,omitempty"`
+	}
+
+	// BatchTotal sums up results of succeeded conversions. Elapsed is the
+	// wall time of the batch, so Realtime accounts for time between
+	// conversions.
+	BatchTotal struct {
+		Files      int     `json:"files"`
+		Failed     int     `json:"failed"`
+		InputSize  int64   `json:"input_size"`
+		OutputSize int64   `json:"output_size"`
+		Duration   float64 `json:"duration"`
+		Elapsed    float64 `json:"elapsed"`
+		Realtime   float64 `json:"realtime"`
+	}
+
+	// BatchReport contains results of all files in the order they were
+	// added and their total.
+	BatchReport struct {
+		Files []BatchFile `json:"files"`
+		Total BatchTotal  `json:"total"`
+	}
+)
+
+// NewBatch returns new batch. Elapsed time is counted from this call.
+func NewBatch() *Batch {
+	return &Batch{start: time.Now()}
+}
+
+// Add adds the summary of succeeded conversion of the file.
+func (b *Batch) Add(path string, s Summary) {
+	b.add(BatchFile{
+		Path:       path,
+		Input:      s.Input,
+		Output:     s.Output,
+		InputSize:  s.InputSize,
+		OutputSize: s.OutputSize,
+		Duration:   s.Duration().Seconds(),
+		Elapsed:    s.Elapsed.Seconds(),
+		Realtime:   s.Realtime(),
+	})
+}
+
+// Fail adds failed conversion of the file.
+func (b *Batch) Fail(path string, err error) {
+	b.add(BatchFile{Path: path, Error: err.Error()})
+}
+
+func (b *Batch) add(f BatchFile) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files = append(b.files, f)
+}
+
+// Len returns the number of added files.
+func (b *Batch) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.files)
+}
+
+// Report returns results of added files and their total.
+func (b *Batch) Report() BatchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := BatchReport{
+		Files: append([]BatchFile(nil), b.files...),
+		Total: BatchTotal{Elapsed: time.Since(b.start).Seconds()},
+	}
+	for _, f := range b.files {
+		if f.Error != "" {
+			r.Total.Failed++
+			continue
+		}
+		r.Total.Files++
+		r.Total.InputSize += f.InputSize
+		r.Total.OutputSize += f.OutputSize
+		r.Total.Duration += f.Duration
+	}
+	if r.Total.Elapsed > 0 {
+		r.Total.Realtime = r.Total.Duration / r.Total.Elapsed
+	}
+	return r
+}
+
+// WriteTable writes the report as a table with the total in the last
+// row.
+func (r BatchReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tINPUT\tOUTPUT\tDURATION\tELAPSED\tREALTIME")
+	for _, f := range r.Files {
+		if f.Error != "" {
+			fmt.Fprintf(tw, "%s\tfailed: %s\t\t\t\t\n", f.Path, f.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s %s\t%s %s\t%.2fs\t%.2fs\t%.1fx\n",
+			f.Path, f.Input, formatSize(f.InputSize), f.Output, formatSize(f.OutputSize), f.Duration, f.Elapsed, f.Realtime)
+	}
+	t := r.Total
+	total := fmt.Sprintf("total: %d files", t.Files)
+	if t.Failed > 0 {
+		total += fmt.Sprintf(", %d failed", t.Failed)
+	}
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%.2fs\t%.2fs\t%.1fx\n",
+		total, formatSize(t.InputSize), formatSize(t.OutputSize), t.Duration, t.Elapsed, t.Realtime)
+	return tw.Flush()
+}
@@ -0,0 +1,49 @@
+package encode_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestBatch(t *testing.T) {
+	b := encode.NewBatch()
+	props := pipe.SignalProperties{SampleRate: 44100, Channels: 2}
+	b.Add("a.wav", encode.Summary{
+		Input:      "wav",
+		Output:     "mp3 V2",
+		InputSize:  10000000,
+		OutputSize: 2000000,
+		In:         props,
+		Out:        props,
+		Frames:     44100 * 60,
+		Elapsed:    2 * time.Second,
+	})
+	b.Fail("b.wav", errors.New("corrupt"))
+	assert.Equal(t, 2, b.Len())
+
+	r := b.Report()
+	assert.Equal(t, 2, len(r.Files))
+	assert.Equal(t, 30.0, r.Files[0].Realtime)
+	assert.Equal(t, "corrupt", r.Files[1].Error)
+	assert.Equal(t, 1, r.Total.Files)
+	assert.Equal(t, 1, r.Total.Failed)
+	assert.Equal(t, int64(10000000), r.Total.InputSize)
+	assert.Equal(t, 60.0, r.Total.Duration)
+	assert.True(t, r.Total.Realtime > 0)
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteTable(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.Contains(t, lines[1], "mp3 V2 2MB")
+	assert.Contains(t, lines[2], "failed: corrupt")
+	assert.True(t, strings.HasPrefix(lines[3], "total: 1 files, 1 failed"))
+}
@@ -0,0 +1,124 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestBufferSize(t *testing.T) {
+	testBufferSize := func(b encode.Buffering, in, out *fileformat.Format, expected int) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+			assert.Equal(t, expected, b.BufferSize(in, out))
+		}
+	}
+	t.Run("explicit size",
+		testBufferSize(encode.Buffering{Size: 100, Latency: encode.Throughput},
+			fileformat.WAV(), fileformat.MP3(), 100),
+	)
+	t.Run("low latency wav to mp3",
+		testBufferSize(encode.Buffering{Latency: encode.LowLatency},
+			fileformat.WAV(), fileformat.MP3(), 1152),
+	)
+	t.Run("throughput flac to wav",
+		testBufferSize(encode.Buffering{Latency: encode.Throughput},
+			fileformat.FLAC(), fileformat.WAV(), 16384),
+	)
+	t.Run("no profile",
+		testBufferSize(encode.Buffering{},
+			fileformat.WAV(), fileformat.WAV(), 1024),
+	)
+}
+
+func TestAutoBufferSize(t *testing.T) {
+	testAutoBufferSize := func(in, out *fileformat.Format, sampleRate, channels int, streaming bool, expected int) func(*testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+			assert.Equal(t, expected, encode.AutoBufferSize(in, out, sampleRate, channels, streaming))
+		}
+	}
+	t.Run("wav file",
+		testAutoBufferSize(fileformat.WAV(), fileformat.WAV(), 44100, 2, false, 4410),
+	)
+	t.Run("wav to mp3 file",
+		testAutoBufferSize(fileformat.WAV(), fileformat.MP3(), 44100, 2, false, 4608),
+	)
+	t.Run("wav to mp3 stream",
+		testAutoBufferSize(fileformat.WAV(), fileformat.MP3(), 44100, 2, true, 1152),
+	)
+	t.Run("multichannel limit",
+		testAutoBufferSize(fileformat.WAV(), fileformat.WAV(), 96000, 8, false, 8192),
+	)
+	t.Run("minimal size",
+		testAutoBufferSize(fileformat.WAV(), fileformat.WAV(), 8000, 1, true, 512),
+	)
+}
+
+func TestInputBufferSize(t *testing.T) {
+	f, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	b := encode.Buffering{Auto: true, Latency: encode.Throughput}
+	assert.Equal(t, 4608, b.InputBufferSize(fileformat.WAV(), fileformat.MP3(), f, false))
+	pos, err := f.Seek(0, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pos)
+
+	b.Size = 100
+	assert.Equal(t, 100, b.InputBufferSize(fileformat.WAV(), fileformat.MP3(), f, false))
+	b = encode.Buffering{Latency: encode.Throughput}
+	assert.Equal(t, b.BufferSize(fileformat.WAV(), fileformat.MP3()), b.InputBufferSize(fileformat.WAV(), fileformat.MP3(), f, false))
+}
+
+func TestParseLatency(t *testing.T) {
+	l, err := encode.ParseLatency("low")
+	assert.NoError(t, err)
+	assert.Equal(t, encode.LowLatency, l)
+	_, err = encode.ParseLatency("fast")
+	assert.Error(t, err)
+}
+
+// BenchmarkBufferSize measures wav to wav conversion of the sample file
+// with buffer sizes of latency profiles and automatic ones.
+func BenchmarkBufferSize(b *testing.B) {
+	wav, err := ioutil.ReadFile("../_testdata/sample.wav")
+	if err != nil {
+		b.Fatal(err)
+	}
+	out, err := ioutil.TempFile("", "phono-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	sink, err := userinput.WAV.Sink(16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, size := range []int{512, 1152, 4410, 8192, 16384, 65536} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(len(wav)))
+			for i := 0; i < b.N; i++ {
+				if _, err := out.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				input := bytes.NewReader(wav)
+				if err := encode.Run(context.Background(), size, 0, fileformat.WAV().Source(input), sink(out)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
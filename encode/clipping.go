@@ -0,0 +1,161 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// ClippingHeader is the response header with the number of clipped
+// regions of the output.
+const ClippingHeader = "Phono-Clipped-Regions"
+
+// DefaultClipRun is the number of consecutive full scale samples that is
+// considered as clipping.
+const DefaultClipRun = 3
+
+// clipLevel is the level of full scale samples. It's about one step of 16
+// bit samples below one, so asymmetric negative range of integer formats
+// and rounding of decoders are tolerated.
+const clipLevel = 0.9999
+
+type (
+	// ClippedRegion is the run of consecutive full scale samples in the
+	// channel. Start and end are in seconds, channels are counted from
+	// one.
+	ClippedRegion struct {
+		Channel int     `json:"channel"`
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Samples int     `json:"samples"`
+	}
+
+	// Clipping contains clipped regions of the signal and the total
+	// number of clipped samples.
+	Clipping struct {
+		Regions []ClippedRegion `json:"regions"`
+		Samples int             `json:"samples"`
+	}
+
+	// ClipDetector counts consecutive full scale samples of the signal
+	// written into its sink. Runs of at least minRun samples are
+	// reported as clipped regions. If fail is set, the run fails at the
+	// first clipped region. Clipping is available after the run is done.
+	ClipDetector struct {
+		minRun   int
+		fail     bool
+		clipping Clipping
+	}
+)
+
+// NewClipDetector returns the detector of runs of at least minRun full
+// scale samples.
+func NewClipDetector(minRun int, fail bool) *ClipDetector {
+	if minRun < 1 {
+		minRun = 1
+	}
+	return &ClipDetector{
+		minRun: minRun,
+		fail:   fail,
+	}
+}
+
+// Sink wraps the sink allocator to detect the clipping before the signal
+// is written. If fn is nil, the signal is analyzed only.
+func (d *ClipDetector) Sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			sink pipe.Sink
+			err  error
+		)
+		if fn != nil {
+			if sink, err = fn(mctx, bufferSize, props); err != nil {
+				return sink, err
+			}
+		}
+		d.clipping = Clipping{}
+		sampleRate := float64(props.SampleRate)
+		// first frame and length of current run per channel
+		starts := make([]int, props.Channels)
+		runs := make([]int, props.Channels)
+		// failed is set when the run is failed on clipping
+		var (
+			frames int
+			failed bool
+		)
+		end := func(c, frame int) error {
+			run := runs[c]
+			runs[c] = 0
+			if run < d.minRun {
+				return nil
+			}
+			region := ClippedRegion{
+				Channel: c + 1,
+				Start:   float64(starts[c]) / sampleRate,
+				End:     float64(frame) / sampleRate,
+				Samples: run,
+			}
+			d.clipping.Regions = append(d.clipping.Regions, region)
+			d.clipping.Samples += run
+			if d.fail {
+				failed = true
+				return &CodecError{
+					Code: CodeClipped,
+					Err:  fmt.Errorf("output is clipped at %.3fs in channel %d", region.Start, region.Channel),
+				}
+			}
+			return nil
+		}
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			channels := in.Channels()
+			for i := 0; i < in.Len(); i++ {
+				c, frame := i%channels, frames+i/channels
+				if math.Abs(in.Sample(i)) >= clipLevel {
+					if runs[c] == 0 {
+						starts[c] = frame
+					}
+					runs[c]++
+					continue
+				}
+				if err := end(c, frame); err != nil {
+					return err
+				}
+			}
+			frames += in.Length()
+			if sinkFn != nil {
+				return sinkFn(in)
+			}
+			return nil
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			for c := 0; c < len(runs) && !failed; c++ {
+				if err := end(c, frames); err != nil {
+					return err
+				}
+			}
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
+
+// Clipping returns clipped regions of the signal.
+func (d *ClipDetector) Clipping() Clipping {
+	return d.clipping
+}
+
+// String returns short description of the clipping.
+func (c Clipping) String() string {
+	if len(c.Regions) == 0 {
+		return "no clipping"
+	}
+	return fmt.Sprintf("%d clipped regions, %d samples", len(c.Regions), c.Samples)
+}
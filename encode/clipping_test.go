@@ -0,0 +1,60 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestClipDetector(t *testing.T) {
+	// 3 full scale samples at 0.1s, 2 at 0.3s and 5 at the end
+	const sampleRate = 1000
+	frames := make([]float64, 1000)
+	for i := range frames {
+		switch {
+		case i >= 100 && i < 103, i >= 995:
+			frames[i] = 1
+		case i >= 300 && i < 302:
+			frames[i] = -1
+		default:
+			frames[i] = 0.5
+		}
+	}
+	format := fileformat.WAV()
+	detect := func(minRun int, fail bool) (encode.Clipping, error) {
+		d := encode.NewClipDetector(minRun, fail)
+		input := bytes.NewReader(stereoWAV(sampleRate, frames))
+		err := encode.Run(context.Background(), 64, 0, format.Source(input), d.Sink(nil))
+		return d.Clipping(), err
+	}
+
+	c, err := detect(encode.DefaultClipRun, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 16, c.Samples)
+	assert.Len(t, c.Regions, 4)
+	for i, r := range c.Regions {
+		// both channels are clipped
+		assert.Equal(t, i%2+1, r.Channel)
+	}
+	assert.InDelta(t, 0.1, c.Regions[0].Start, 0.0001)
+	assert.InDelta(t, 0.103, c.Regions[0].End, 0.0001)
+	assert.Equal(t, 3, c.Regions[0].Samples)
+	assert.InDelta(t, 0.995, c.Regions[2].Start, 0.0001)
+	assert.InDelta(t, 1, c.Regions[2].End, 0.0001)
+	assert.Equal(t, 5, c.Regions[2].Samples)
+
+	c, err = detect(2, false)
+	assert.NoError(t, err)
+	assert.Len(t, c.Regions, 6)
+
+	// conversion fails at the first region
+	c, err = detect(encode.DefaultClipRun, true)
+	assert.Error(t, err)
+	assert.Equal(t, encode.CodeClipped, encode.Code(err))
+	assert.Len(t, c.Regions, 1)
+}
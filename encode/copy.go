@@ -0,0 +1,52 @@
+package encode
+
+import (
+	"io"
+	"math"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/tag"
+)
+
+// Copy copies the input of the format into the output without decoding,
+// so the audio has no generation loss. Tags of the input are replaced by
+// provided ones, see tag.Write. Returned stats describe the signal read
+// from headers of the input, see CopyStats.
+func Copy(format *fileformat.Format, input io.ReadSeeker, output io.WriteSeeker, t tag.Tags) (*Stats, error) {
+	s, err := CopyStats(format, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := tag.Write(format, input, output, t); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CopyStats returns stats of the input that is copied as is. The signal
+// is read from headers of the input, the number of frames of mp3 input
+// without Xing or VBRI header is estimated.
+func CopyStats(format *fileformat.Format, input io.ReadSeeker) (*Stats, error) {
+	s := NewStats()
+	size, err := input.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	info, err := Probe(format, input, size)
+	if err != nil {
+		return nil, err
+	}
+	props := pipe.SignalProperties{
+		SampleRate: signal.Frequency(info.SampleRate),
+		Channels:   info.Channels,
+	}
+	s.in, s.out = props, props
+	s.frames = int64(math.Round(info.Duration * float64(info.SampleRate)))
+	return s, nil
+}
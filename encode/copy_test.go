@@ -0,0 +1,39 @@
+package encode_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+)
+
+func TestCopy(t *testing.T) {
+	in, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer in.Close()
+	out, err := ioutil.TempFile("", "phono-copy")
+	assert.NoError(t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	stats, err := encode.Copy(fileformat.WAV(), in, out, tag.Tags{tag.Title: "Copy"})
+	assert.NoError(t, err)
+	s := stats.Summary("wav", "wav copy", 0, 0)
+	assert.Equal(t, 44100, int(s.In.SampleRate))
+	assert.Equal(t, 2, s.Out.Channels)
+
+	_, err = out.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	info, err := encode.Probe(fileformat.WAV(), out, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(info.Duration*44100+0.5), s.Frames)
+	tags, err := tag.Read(fileformat.WAV(), out)
+	assert.NoError(t, err)
+	assert.Equal(t, "Copy", tags[tag.Title])
+}
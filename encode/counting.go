@@ -0,0 +1,15 @@
+package encode
+
+import "io"
+
+// CountingWriter accounts the number of bytes written into Writer.
+type CountingWriter struct {
+	io.Writer
+	Written int64
+}
+
+func (w *CountingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.Written += int64(n)
+	return n, err
+}
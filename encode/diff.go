@@ -0,0 +1,262 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/fft"
+)
+
+// alignDuration is the duration of signals beginnings in seconds that
+// are cross-correlated to find the offset.
+const alignDuration = 5
+
+type (
+	// Difference is the result of comparison of two signals. Offset is
+	// the number of frames the second signal is delayed, negative if it's
+	// ahead. Frames is the number of compared frames after alignment and
+	// Unmatched is the number of frames present only in one signal. Max
+	// difference is the largest difference of samples and MaxAt is its
+	// position in the first signal in seconds.
+	Difference struct {
+		SampleRate    int     `json:"sample_rate"`
+		Offset        int     `json:"offset"`
+		Frames        int     `json:"frames"`
+		Unmatched     int     `json:"unmatched"`
+		MaxDifference Level   `json:"max_difference"`
+		MaxAt         float64 `json:"max_at"`
+		RMSDifference Level   `json:"rms_difference"`
+	}
+
+	// frameReader pulls frames from the decoder.
+	frameReader struct {
+		pipe.Source
+		c   classifier
+		buf signal.Floating
+		pos int
+		n   int
+	}
+)
+
+// Compare decodes both inputs and compares their samples. If maxOffset is
+// not zero, the inputs are aligned with cross-correlation of their
+// beginnings, the offset is limited to maxOffset. Inputs must have the
+// same sample rate and number of channels.
+func Compare(ctx context.Context, bufferSize int, formatA *fileformat.Format, a io.ReadSeeker, formatB *fileformat.Format, b io.ReadSeeker, maxOffset time.Duration) (Difference, error) {
+	var offset int
+	if maxOffset > 0 {
+		var err error
+		if offset, err = align(ctx, bufferSize, formatA, a, formatB, b, maxOffset); err != nil {
+			return Difference{}, err
+		}
+	}
+	ra, rb, err := openReaders(bufferSize, formatA, a, formatB, b)
+	if err != nil {
+		return Difference{}, err
+	}
+	defer ra.close()
+	defer rb.close()
+	d := Difference{
+		SampleRate:    int(ra.SampleRate),
+		Offset:        offset,
+		MaxDifference: Level(math.Inf(-1)),
+		RMSDifference: Level(math.Inf(-1)),
+	}
+	fa, fb := make([]float64, ra.Channels), make([]float64, rb.Channels)
+	// skip leading frames of delayed signal
+	delayed, skip := rb, offset
+	if offset < 0 {
+		delayed, skip = ra, -offset
+	}
+	for i := 0; i < skip; i++ {
+		if ok, err := delayed.read(ctx, fb); err != nil || !ok {
+			return d, err
+		}
+	}
+	var maxDiff, sum float64
+	for {
+		okA, err := ra.read(ctx, fa)
+		if err != nil {
+			return Difference{}, err
+		}
+		okB, err := rb.read(ctx, fb)
+		if err != nil {
+			return Difference{}, err
+		}
+		if !okA || !okB {
+			// count frames left in the longer signal
+			rest := ra
+			if okB {
+				rest = rb
+			}
+			for ok := okA || okB; ok; {
+				d.Unmatched++
+				if ok, err = rest.read(ctx, fa); err != nil {
+					return Difference{}, err
+				}
+			}
+			break
+		}
+		for c := range fa {
+			diff := math.Abs(fa[c] - fb[c])
+			if diff > maxDiff {
+				maxDiff = diff
+				d.MaxAt = float64(d.Frames) / float64(ra.SampleRate)
+			}
+			sum += diff * diff
+		}
+		d.Frames++
+	}
+	if offset < 0 {
+		d.MaxAt -= float64(offset) / float64(ra.SampleRate)
+	}
+	if d.Frames > 0 {
+		d.MaxDifference = Level(decibels(maxDiff))
+		d.RMSDifference = Level(decibels(math.Sqrt(sum / float64(d.Frames*len(fa)))))
+	}
+	return d, nil
+}
+
+// String returns short description of the difference.
+func (d Difference) String() string {
+	return fmt.Sprintf("offset %d frames, max difference %v dBFS at %.3fs, rms difference %v dBFS, %d unmatched frames",
+		d.Offset, d.MaxDifference, d.MaxAt, d.RMSDifference, d.Unmatched)
+}
+
+// align finds the offset of the second input with cross-correlation of
+// mono beginnings of the inputs. Inputs are seeked to the start.
+func align(ctx context.Context, bufferSize int, formatA *fileformat.Format, a io.ReadSeeker, formatB *fileformat.Format, b io.ReadSeeker, maxOffset time.Duration) (int, error) {
+	ra, rb, err := openReaders(bufferSize, formatA, a, formatB, b)
+	if err != nil {
+		return 0, err
+	}
+	defer ra.close()
+	defer rb.close()
+	maxLag := int(maxOffset.Seconds() * float64(ra.SampleRate))
+	frames := maxLag + alignDuration*int(ra.SampleRate)
+	size := 1
+	for size < 2*frames {
+		size <<= 1
+	}
+	xa, xb := make([]complex128, size), make([]complex128, size)
+	for _, x := range []struct {
+		r   *frameReader
+		out []complex128
+	}{{ra, xa}, {rb, xb}} {
+		frame := make([]float64, x.r.Channels)
+		for i := 0; i < frames; i++ {
+			ok, err := x.r.read(ctx, frame)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				break
+			}
+			var sum float64
+			for _, v := range frame {
+				sum += v
+			}
+			x.out[i] = complex(sum, 0)
+		}
+	}
+	fft.Transform(xa)
+	fft.Transform(xb)
+	// inverse transform of the cross spectrum is done with conjugates
+	for i := range xa {
+		xa[i] = cmplx.Conj(cmplx.Conj(xa[i]) * xb[i])
+	}
+	fft.Transform(xa)
+	offset, best := 0, 0.0
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		i := lag
+		if i < 0 {
+			i += size
+		}
+		if v := real(xa[i]); v > best {
+			offset, best = lag, v
+		}
+	}
+	return offset, nil
+}
+
+// openReaders seeks inputs to the start and allocates their decoders.
+// Signal properties of inputs must match.
+func openReaders(bufferSize int, formatA *fileformat.Format, a io.ReadSeeker, formatB *fileformat.Format, b io.ReadSeeker) (*frameReader, *frameReader, error) {
+	ra, err := newFrameReader(bufferSize, formatA, a)
+	if err != nil {
+		return nil, nil, err
+	}
+	rb, err := newFrameReader(bufferSize, formatB, b)
+	if err != nil {
+		ra.close()
+		return nil, nil, err
+	}
+	if ra.SampleRate != rb.SampleRate || ra.Channels != rb.Channels {
+		ra.close()
+		rb.close()
+		return nil, nil, fmt.Errorf("signals differ: %v %v and %v %v",
+			formatSampleRate(ra.SampleRate), formatChannels(ra.Channels),
+			formatSampleRate(rb.SampleRate), formatChannels(rb.Channels))
+	}
+	return ra, rb, nil
+}
+
+// newFrameReader seeks the input to the start and allocates its decoder.
+func newFrameReader(bufferSize int, format *fileformat.Format, input io.ReadSeeker) (*frameReader, error) {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := frameReader{}
+	source, err := r.c.source(format.Source(input))(mutable.Mutable(), bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	r.Source = source
+	r.buf = signal.Allocator{
+		Channels: source.Channels,
+		Length:   bufferSize,
+		Capacity: bufferSize,
+	}.Float64()
+	return &r, nil
+}
+
+// read reads the next frame. False is returned if the input is over.
+func (r *frameReader) read(ctx context.Context, frame []float64) (bool, error) {
+	if r.pos == r.n {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		n, err := r.SourceFunc(r.buf)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		r.pos, r.n = 0, n
+		if n == 0 {
+			return false, nil
+		}
+	}
+	for c := range frame {
+		frame[c] = r.buf.Sample(r.pos*len(frame) + c)
+	}
+	r.pos++
+	return true, nil
+}
+
+// close flushes the decoder.
+func (r *frameReader) close() {
+	if r.FlushFunc != nil {
+		r.FlushFunc(context.Background())
+	}
+}
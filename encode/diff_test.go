@@ -0,0 +1,64 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestCompare(t *testing.T) {
+	const sampleRate = 8000
+	rnd := rand.New(rand.NewSource(1))
+	signal := make([]float64, 3*sampleRate)
+	for i := range signal {
+		signal[i] = 0.5 * (rnd.Float64() - 0.5)
+	}
+	format := fileformat.WAV()
+	compare := func(a, b []float64, maxOffset time.Duration) encode.Difference {
+		d, err := encode.Compare(context.Background(), 256, format, bytes.NewReader(stereoWAV(sampleRate, a)), format, bytes.NewReader(stereoWAV(sampleRate, b)), maxOffset)
+		assert.NoError(t, err)
+		return d
+	}
+
+	d := compare(signal, signal, time.Second)
+	assert.Equal(t, 0, d.Offset)
+	assert.Equal(t, len(signal), d.Frames)
+	assert.Equal(t, 0, d.Unmatched)
+	assert.True(t, math.IsInf(float64(d.MaxDifference), -1))
+	assert.True(t, math.IsInf(float64(d.RMSDifference), -1))
+
+	// delayed copy with a single changed sample
+	delayed := append(make([]float64, 123), signal...)
+	delayed[1123] += 0.1
+	d = compare(signal, delayed, time.Second)
+	assert.Equal(t, 123, d.Offset)
+	assert.Equal(t, len(signal), d.Frames)
+	assert.InDelta(t, -20, float64(d.MaxDifference), 0.5)
+	assert.InDelta(t, 1000.0/sampleRate, d.MaxAt, 0.001)
+	assert.Less(t, float64(d.RMSDifference), -60.0)
+
+	// second signal is ahead and shorter
+	d = compare(delayed, signal[:2*sampleRate], time.Second)
+	assert.Equal(t, -123, d.Offset)
+	assert.Equal(t, 2*sampleRate, d.Frames)
+	assert.Equal(t, sampleRate, d.Unmatched)
+	assert.InDelta(t, 1123.0/sampleRate, d.MaxAt, 0.001)
+
+	// without alignment the signals don't match
+	d = compare(signal, delayed, 0)
+	assert.Equal(t, 0, d.Offset)
+	assert.Equal(t, 123, d.Unmatched)
+	assert.Greater(t, float64(d.MaxDifference), -10.0)
+
+	// signals with different properties cannot be compared
+	_, err := encode.Compare(context.Background(), 256, format, bytes.NewReader(stereoWAV(sampleRate, signal)), format, bytes.NewReader(stereoWAV(2*sampleRate, signal)), 0)
+	assert.Error(t, err)
+}
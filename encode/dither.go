@@ -0,0 +1,145 @@
+package encode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/bufpool"
+	"pipelined.dev/phono/processor"
+)
+
+// DitherMode defines when the output is dithered.
+type DitherMode string
+
+// Dither modes. Auto mode dithers the output if its bit depth is lower
+// than the bit depth of the input.
+const (
+	DitherAuto DitherMode = "auto"
+	DitherOn   DitherMode = "on"
+	DitherOff  DitherMode = "off"
+)
+
+// ParseDitherMode returns dither mode by its name.
+func ParseDitherMode(s string) (DitherMode, error) {
+	switch m := DitherMode(s); m {
+	case DitherAuto, DitherOn, DitherOff:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid dither mode %q: must be %s, %s or %s", s, DitherAuto, DitherOn, DitherOff)
+}
+
+// Enabled returns true if the output of bit depth is dithered when the
+// input has source bit depth. Zero bit depth means it's unknown.
+func (m DitherMode) Enabled(source, output signal.BitDepth) bool {
+	if output == 0 {
+		return false
+	}
+	switch m {
+	case DitherOn:
+		return true
+	case DitherAuto:
+		return output < source
+	}
+	return false
+}
+
+// SourceBitDepth returns the bit depth of the input samples. Mp3 is
+// decoded to 16 bits and zero is returned for unknown formats. The input
+// is rewound after the header is read.
+func SourceBitDepth(format *fileformat.Format, input io.ReadSeeker) (signal.BitDepth, error) {
+	var (
+		bitDepth signal.BitDepth
+		err      error
+	)
+	switch format {
+	case fileformat.MP3():
+		return signal.BitDepth16, nil
+	case fileformat.WAV():
+		bitDepth, err = wavBitDepth(input)
+	case fileformat.FLAC():
+		bitDepth, err = flacBitDepth(input)
+	default:
+		return 0, nil
+	}
+	if _, serr := input.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	return bitDepth, err
+}
+
+// wavBitDepth reads bits per sample from the format chunk.
+func wavBitDepth(r io.Reader) (signal.BitDepth, error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[:4], []byte("RIFF")) || !bytes.Equal(header[8:], []byte("WAVE")) {
+		return 0, fmt.Errorf("invalid wav header")
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		if !bytes.Equal(chunk[:4], []byte("fmt ")) {
+			// chunks are padded to even size
+			if _, err := io.CopyN(ioutil.Discard, r, size+size%2); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		var format [16]byte
+		if _, err := io.ReadFull(r, format[:]); err != nil {
+			return 0, err
+		}
+		return signal.BitDepth(binary.LittleEndian.Uint16(format[14:])), nil
+	}
+}
+
+// flacBitDepth reads bits per sample from the stream info block.
+func flacBitDepth(r io.Reader) (signal.BitDepth, error) {
+	h, err := readFLACHeader(r)
+	return h.bitDepth, err
+}
+
+// DitherSink wraps the sink allocator to dither the signal to bit depth
+// before it's written.
+func DitherSink(fn pipe.SinkAllocatorFunc, bitDepth signal.BitDepth, shaping bool) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		sink, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return sink, err
+		}
+		dither, err := processor.Dither(bitDepth, shaping)(mctx, bufferSize, props)
+		if err != nil {
+			return pipe.Sink{}, err
+		}
+		out := bufpool.Floating(props.Channels, bufferSize)
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			buf := out.Slice(0, in.Length())
+			if _, err := dither.ProcessFunc(in, buf); err != nil {
+				return err
+			}
+			return sinkFn(buf)
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			bufpool.PutFloating(out)
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
@@ -0,0 +1,43 @@
+package encode_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestDitherMode(t *testing.T) {
+	_, err := encode.ParseDitherMode("always")
+	assert.Error(t, err)
+	mode, err := encode.ParseDitherMode("auto")
+	assert.NoError(t, err)
+	assert.Equal(t, encode.DitherAuto, mode)
+
+	assert.True(t, encode.DitherAuto.Enabled(signal.BitDepth24, signal.BitDepth16))
+	assert.False(t, encode.DitherAuto.Enabled(signal.BitDepth16, signal.BitDepth16))
+	assert.False(t, encode.DitherAuto.Enabled(0, signal.BitDepth16))
+	assert.True(t, encode.DitherOn.Enabled(signal.BitDepth16, signal.BitDepth24))
+	assert.False(t, encode.DitherOn.Enabled(signal.BitDepth16, 0))
+	assert.False(t, encode.DitherOff.Enabled(signal.BitDepth24, signal.BitDepth16))
+
+	input := bytes.NewReader(sineWAV(44100, 440, -6, 1))
+	bitDepth, err := encode.SourceBitDepth(fileformat.WAV(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, signal.BitDepth16, bitDepth)
+	// input is rewound
+	pos, _ := input.Seek(0, io.SeekCurrent)
+	assert.Equal(t, int64(0), pos)
+
+	bitDepth, err = encode.SourceBitDepth(fileformat.MP3(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, signal.BitDepth16, bitDepth)
+
+	_, err = encode.SourceBitDepth(fileformat.WAV(), bytes.NewReader([]byte("not a wav file")))
+	assert.Error(t, err)
+}
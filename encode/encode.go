@@ -0,0 +1,154 @@
+// Package encode converts audio between registered formats. It doesn't
+// depend on net/http, so it can be embedded into Go programs, the HTTP
+// service is provided by encode/service package.
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/codec"
+)
+
+// Options configure the conversion of Encode. Input and Output are names
+// or extensions of the registered pump and sink, e.g. flac or .mp3.
+// Params configure the sink, missing options are set to their defaults.
+// Passes analyse the input before it's encoded, see Analyze. Processors
+// are applied before processors of passes. Zero Buffering uses defaults
+// of input and output formats. If StallTimeout is not zero, stalled
+// conversion is cancelled. Dither mode is applied if the bit depth of
+// the input is known, the output isn't dithered if it's empty. Hook
+// receives events of the conversion, it can be nil. Inputs that can't
+// seek and outputs that need to seek are spooled into temp files in
+// TempDir, the default directory for temporary files is used if it's
+// empty.
+type Options struct {
+	Input        string
+	Output       string
+	Params       codec.Params
+	Processors   []pipe.ProcessorAllocatorFunc
+	Passes       []Pass
+	Buffering    Buffering
+	StallTimeout time.Duration
+	Dither       DitherMode
+	Hook         Hook
+	TempDir      string
+}
+
+// Encode converts src into dst with options. It's the entry point for Go
+// programs that embed phono: formats come from the codec registry, so
+// sinks and pumps of compiled in modules are available. If dst is
+// io.WriteSeeker, the result is written directly. Otherwise streamed
+// sinks encode into dst and other sinks encode into the temp file that is
+// copied into dst when conversion is done. Passes of options are used
+// once, so options with passes can't be reused.
+func Encode(ctx context.Context, src io.Reader, dst io.Writer, opts Options) error {
+	pump, err := codec.LookupPump(extension(opts.Input))
+	if err != nil {
+		return err
+	}
+	sink, err := codec.LookupSink(opts.Output)
+	if err != nil {
+		return err
+	}
+	encoder, err := sink.NewEncoder(opts.Params)
+	if err != nil {
+		return err
+	}
+
+	input, ok := src.(io.ReadSeeker)
+	if !ok {
+		f, err := spool(opts.TempDir, src)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		defer cleanUp(f)
+		input = f
+	}
+
+	var (
+		out       pipe.SinkAllocatorFunc
+		result    *os.File
+		streaming bool
+	)
+	if ws, ok := dst.(io.WriteSeeker); ok {
+		out = encoder.Sink(ws)
+	} else if encoder.Stream != nil {
+		out, streaming = encoder.Stream(dst), true
+	} else {
+		if result, err = ioutil.TempFile(opts.TempDir, ""); err != nil {
+			return err
+		}
+		defer cleanUp(result)
+		out = encoder.Sink(result)
+	}
+	if pump.Format != nil {
+		// invalid header is reported by the conversion
+		if source, err := SourceBitDepth(pump.Format, input); err == nil && opts.Dither.Enabled(source, encoder.BitDepth) {
+			out = DitherSink(out, encoder.BitDepth, false)
+		}
+	}
+
+	var hooks Hooks
+	if opts.Hook != nil {
+		hooks = append(hooks, opts.Hook)
+	}
+	bufferSize := opts.Buffering.InputBufferSize(pump.Format, sink.Format, input, streaming)
+	open := func() (pipe.SourceAllocatorFunc, error) {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return pump.Source(input), nil
+	}
+	hooks.OnStart()
+	err = TwoPass(ctx, bufferSize, opts.StallTimeout, open, HookSink(out, hooks), opts.Passes, opts.Processors...)
+	if err == nil && result != nil {
+		if _, err = result.Seek(0, io.SeekStart); err == nil {
+			_, err = io.Copy(dst, result)
+		}
+	}
+	Done(hooks, err)
+	return err
+}
+
+// extension returns the extension of the pump name. Names are extensions
+// without leading dot.
+func extension(name string) string {
+	return "." + strings.TrimPrefix(name, ".")
+}
+
+// spool copies the input into the temp file, so it can be decoded more
+// than once.
+func spool(dir string, r io.Reader) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		cleanUp(f)
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanUp(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// cleanUp removes temporary file and handles all errors on the way.
+func cleanUp(f *os.File) {
+	if err := f.Close(); err != nil {
+		log.Printf("Failed to close temp file")
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		log.Printf("Failed to delete temp file")
+	}
+}
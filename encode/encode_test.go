@@ -0,0 +1,63 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/encode"
+)
+
+func TestEncode(t *testing.T) {
+	sample, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		opts   encode.Options
+		header string
+		err    error
+	}{
+		{
+			name:   "wav",
+			opts:   encode.Options{Input: "wav", Output: "wav", Params: codec.Params{"bitdepth": "16"}, Dither: encode.DitherAuto},
+			header: "RIFF",
+		},
+		{
+			name: "mp3 extension",
+			opts: encode.Options{Input: ".wav", Output: ".mp3"},
+		},
+		{
+			name: "unknown sink",
+			opts: encode.Options{Input: "wav", Output: "aac"},
+			err:  codec.ErrSinkNotFound,
+		},
+		{
+			name: "unknown pump",
+			opts: encode.Options{Input: "aac", Output: "wav"},
+			err:  codec.ErrPumpNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var hook recordHook
+			test.opts.Hook = &hook
+			// readers and buffers don't seek
+			var out bytes.Buffer
+			err := encode.Encode(context.Background(), bytes.NewBufferString(string(sample)), &out, test.opts)
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(out.Bytes(), []byte(test.header)))
+			assert.Equal(t, []string{"start", "complete"}, hook.events)
+			assert.NotZero(t, hook.frames)
+		})
+	}
+}
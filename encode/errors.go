@@ -0,0 +1,175 @@
+package encode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// ErrorCodeHeader is the response header with the code of conversion
+// error.
+const ErrorCodeHeader = "Phono-Error-Code"
+
+// maxSampleRate is the highest sample rate accepted from the input.
+const maxSampleRate = 768000
+
+// ErrorCode is a stable identifier of conversion failure. Codes are
+// returned in http responses, progress events, CLI messages and used as
+// metrics labels, so they must not be changed.
+type ErrorCode string
+
+// Error codes of conversion failures.
+const (
+	// CodeCorruptHeader means the input cannot be decoded at all.
+	CodeCorruptHeader ErrorCode = "corrupt_header"
+	// CodeCorruptStream means decoding failed after it was started.
+	CodeCorruptStream ErrorCode = "corrupt_stream"
+	// CodeTruncatedStream means the input ended unexpectedly.
+	CodeTruncatedStream ErrorCode = "truncated_stream"
+	// CodeUnsupportedSampleRate means the input sample rate cannot be
+	// processed.
+	CodeUnsupportedSampleRate ErrorCode = "unsupported_sample_rate"
+	// CodeEncoderFailure means the output cannot be encoded or written.
+	CodeEncoderFailure ErrorCode = "encoder_failure"
+	// CodeStalled means the conversion made no progress.
+	CodeStalled ErrorCode = "stalled"
+	// CodeTimeout means the conversion took longer than allowed.
+	CodeTimeout ErrorCode = "timeout"
+	// CodeCanceled means the conversion was cancelled by the client.
+	CodeCanceled ErrorCode = "canceled"
+	// CodeClipped means the output is clipped and the conversion was
+	// required to fail on clipping.
+	CodeClipped ErrorCode = "clipped"
+	// CodeChecksumMismatch means decoded samples don't match the
+	// checksum stored in the input.
+	CodeChecksumMismatch ErrorCode = "checksum_mismatch"
+	// CodeUnknown is used for all other errors.
+	CodeUnknown ErrorCode = "unknown"
+)
+
+// CodecError is returned when the input cannot be decoded or the output
+// cannot be encoded.
+type CodecError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// classifier assigns codes to errors of pipe components. Pipe joins
+// errors of components, so the code of the first one is kept to be
+// attached to the result of the run.
+type classifier struct {
+	once sync.Once
+	code ErrorCode
+}
+
+func (e *CodecError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns underlying codec error.
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the code of conversion error. Empty code is returned for
+// nil error.
+func Code(err error) ErrorCode {
+	var codecErr *CodecError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrStalled):
+		return CodeStalled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.As(err, &codecErr):
+		return codecErr.Code
+	}
+	return CodeUnknown
+}
+
+// wrap returns error with the code and remembers it.
+func (c *classifier) wrap(code ErrorCode, err error) error {
+	c.once.Do(func() { c.code = code })
+	return &CodecError{Code: code, Err: err}
+}
+
+// sinkError assigns encoder failure code to the sink error, unless it
+// already has a code.
+func (c *classifier) sinkError(err error) error {
+	var codecErr *CodecError
+	if errors.As(err, &codecErr) {
+		c.once.Do(func() { c.code = codecErr.Code })
+		return err
+	}
+	return c.wrap(CodeEncoderFailure, err)
+}
+
+// result attaches the code of the first component error to the result of
+// the run.
+func (c *classifier) result(err error) error {
+	var codecErr *CodecError
+	if err == nil || c.code == "" || errors.As(err, &codecErr) {
+		return err
+	}
+	return &CodecError{Code: c.code, Err: err}
+}
+
+// source wraps the source allocator to assign codes to decoding errors.
+func (c *classifier) source(fn pipe.SourceAllocatorFunc) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		s, err := fn(mctx, bufferSize)
+		if err != nil {
+			return s, c.wrap(CodeCorruptHeader, err)
+		}
+		if rate := s.SampleRate; rate <= 0 || rate > maxSampleRate {
+			return s, c.wrap(CodeUnsupportedSampleRate, fmt.Errorf("unsupported sample rate: %v", rate))
+		}
+		sourceFn := s.SourceFunc
+		s.SourceFunc = func(out signal.Floating) (int, error) {
+			n, err := sourceFn(out)
+			switch {
+			case err == nil, err == io.EOF:
+				return n, err
+			case errors.Is(err, io.ErrUnexpectedEOF):
+				return n, c.wrap(CodeTruncatedStream, err)
+			}
+			return n, c.wrap(CodeCorruptStream, err)
+		}
+		return s, nil
+	}
+}
+
+// sink wraps the sink allocator to assign codes to encoding errors.
+func (c *classifier) sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		s, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return s, c.wrap(CodeEncoderFailure, err)
+		}
+		sinkFn := s.SinkFunc
+		s.SinkFunc = func(in signal.Floating) error {
+			if err := sinkFn(in); err != nil {
+				return c.sinkError(err)
+			}
+			return nil
+		}
+		if flushFn := s.FlushFunc; flushFn != nil {
+			s.FlushFunc = func(ctx context.Context) error {
+				if err := flushFn(ctx); err != nil {
+					return c.sinkError(err)
+				}
+				return nil
+			}
+		}
+		return s, nil
+	}
+}
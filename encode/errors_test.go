@@ -0,0 +1,50 @@
+package encode_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+// failingSource returns a source with provided properties that fails
+// with err on the first read.
+func failingSource(sampleRate signal.Frequency, err error) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{
+				SampleRate: sampleRate,
+				Channels:   1,
+			},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				return 0, err
+			},
+		}, nil
+	}
+}
+
+func TestCode(t *testing.T) {
+	testCode := func(source pipe.SourceAllocatorFunc, expected encode.ErrorCode) func(*testing.T) {
+		return func(t *testing.T) {
+			err := encode.Run(context.Background(), 16, 0, source, discardSink)
+			assert.Equal(t, expected, encode.Code(err))
+		}
+	}
+	t.Run("ok", testCode(failingSource(44100, io.EOF), ""))
+	t.Run("truncated", testCode(failingSource(44100, io.ErrUnexpectedEOF), encode.CodeTruncatedStream))
+	t.Run("corrupt stream", testCode(failingSource(44100, errors.New("bad frame")), encode.CodeCorruptStream))
+	t.Run("sample rate", testCode(failingSource(0, io.EOF), encode.CodeUnsupportedSampleRate))
+	t.Run("corrupt header", testCode(
+		func(mutable.Context, int) (pipe.Source, error) {
+			return pipe.Source{}, errors.New("bad header")
+		},
+		encode.CodeCorruptHeader,
+	))
+}
@@ -0,0 +1,83 @@
+package encode
+
+import (
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+type (
+	// Hook receives events of a single conversion. OnStart is called
+	// before the conversion is started, OnProgress after every buffer
+	// written to the sink with the number of its frames. OnComplete or
+	// OnError is called once the conversion is done. OnProgress is called
+	// from the pipe goroutine, so it must not block.
+	Hook interface {
+		OnStart()
+		OnProgress(frames int64)
+		OnComplete()
+		OnError(err error)
+	}
+
+	// Hooks is the hook that passes events to all hooks in order.
+	Hooks []Hook
+)
+
+// OnStart calls OnStart of all hooks.
+func (hs Hooks) OnStart() {
+	for _, h := range hs {
+		h.OnStart()
+	}
+}
+
+// OnProgress calls OnProgress of all hooks.
+func (hs Hooks) OnProgress(frames int64) {
+	for _, h := range hs {
+		h.OnProgress(frames)
+	}
+}
+
+// OnComplete calls OnComplete of all hooks.
+func (hs Hooks) OnComplete() {
+	for _, h := range hs {
+		h.OnComplete()
+	}
+}
+
+// OnError calls OnError of all hooks.
+func (hs Hooks) OnError(err error) {
+	for _, h := range hs {
+		h.OnError(err)
+	}
+}
+
+// Done reports the result of the conversion to the hook. OnComplete is
+// called if err is nil, OnError otherwise.
+func Done(h Hook, err error) {
+	if err != nil {
+		h.OnError(err)
+		return
+	}
+	h.OnComplete()
+}
+
+// HookSink wraps the sink allocator to report written frames to the hook.
+// Only one wrapper is needed per conversion, since all hooks receive the
+// same events.
+func HookSink(fn pipe.SinkAllocatorFunc, h Hook) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		s, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return s, err
+		}
+		sinkFn := s.SinkFunc
+		s.SinkFunc = func(in signal.Floating) error {
+			if err := sinkFn(in); err != nil {
+				return err
+			}
+			h.OnProgress(int64(in.Length()))
+			return nil
+		}
+		return s, nil
+	}
+}
@@ -0,0 +1,43 @@
+package encode_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+)
+
+type recordHook struct {
+	events []string
+	frames int64
+	err    error
+}
+
+func (h *recordHook) OnStart()           { h.events = append(h.events, "start") }
+func (h *recordHook) OnProgress(n int64) { h.frames += n }
+func (h *recordHook) OnComplete()        { h.events = append(h.events, "complete") }
+func (h *recordHook) OnError(err error) {
+	h.events = append(h.events, "error")
+	h.err = err
+}
+
+func TestHooks(t *testing.T) {
+	a, b := &recordHook{}, &recordHook{}
+	hooks := encode.Hooks{a, b}
+	hooks.OnStart()
+	hooks.OnProgress(10)
+	hooks.OnProgress(5)
+	encode.Done(hooks, nil)
+	for _, h := range []*recordHook{a, b} {
+		assert.Equal(t, []string{"start", "complete"}, h.events)
+		assert.Equal(t, int64(15), h.frames)
+	}
+
+	failed := &recordHook{}
+	err := errors.New("test")
+	encode.Done(failed, err)
+	assert.Equal(t, []string{"error"}, failed.events)
+	assert.Equal(t, err, failed.err)
+}
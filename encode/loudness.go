@@ -0,0 +1,387 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+// Loudness gating and limiting constants of EBU R128 and ITU-R BS.1770.
+const (
+	// MinLoudness is the lowest loudness target, it's also the absolute
+	// gate of loudness measurement.
+	MinLoudness = -70.0
+	// relativeGate is the gate below the loudness of blocks above the
+	// absolute gate.
+	relativeGate = -10.0
+	// loudnessBlock is the duration of gating block, blocks overlap by
+	// 75%, so a new block starts every quarter of it.
+	loudnessBlock = 400 * time.Millisecond
+	// limiterRelease is the release time of true peak limiter.
+	limiterRelease = 50 * time.Millisecond
+	// shortTermQuarters is the number of quarter blocks in the 3s window
+	// of short-term loudness.
+	shortTermQuarters = 30
+	// rangeGate is the relative gate of loudness range measurement.
+	rangeGate = -20.0
+)
+
+// Loudness contains the measurement of the signal. Integrated loudness is
+// in LUFS and is negative infinity for silent signal. Range is the
+// loudness range in LU of EBU Tech 3342. True peak is in dBTP.
+type Loudness struct {
+	Integrated float64
+	Range      float64
+	TruePeak   float64
+}
+
+type (
+	// loudnessMeter measures integrated loudness and true peak.
+	loudnessMeter struct {
+		weights []float64
+		filters []kWeighting
+		// energy of current quarter block per channel
+		quarter   []float64
+		samples   int
+		blockSize int
+		// energies of last quarters of all channels
+		quarters []float64
+		blocks   []float64
+		// energies of short-term windows that start every quarter
+		shortTerm []float64
+		peak      truePeakMeter
+	}
+
+	// kWeighting is the pre-filter of loudness measurement: high shelf
+	// followed by high pass. Each stage is a biquad in direct form I.
+	kWeighting struct {
+		b, a   [2][3]float64
+		x1, x2 [2]float64
+		y1, y2 [2]float64
+	}
+
+	// truePeakMeter measures the peaks of channels oversampled with
+	// polyphase interpolation filter.
+	truePeakMeter struct {
+		factor  int
+		taps    []float64
+		history [][]float64
+		peaks   []float64
+	}
+)
+
+// CheckLoudnessTarget returns error if loudness target is out of range
+// or true peak ceiling is above full scale.
+func CheckLoudnessTarget(lufs, truePeak float64) error {
+	if lufs < MinLoudness || lufs > 0 || math.IsNaN(lufs) {
+		return fmt.Errorf("invalid loudness target %v LUFS: must be between %v and 0", lufs, MinLoudness)
+	}
+	if truePeak > 0 || math.IsNaN(truePeak) {
+		return fmt.Errorf("invalid true peak %v dBTP: must not be above 0", truePeak)
+	}
+	return nil
+}
+
+// LoudnessNormalize returns the processors that bring integrated loudness
+// of the signal to target LUFS and keep its true peak under truePeak
+// dBTP. The input is decoded with provided processors in the first pass
+// to measure the loudness and then rewound. If gain pushes the peaks over
+// the ceiling, the limiter is added after the gain. Silent input is not
+// changed.
+func LoudnessNormalize(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, targetLUFS, truePeak float64, processors ...pipe.ProcessorAllocatorFunc) ([]pipe.ProcessorAllocatorFunc, Loudness, error) {
+	if err := CheckLoudnessTarget(targetLUFS, truePeak); err != nil {
+		return nil, Loudness{}, err
+	}
+	var loudness Loudness
+	normalize, err := AnalyzeInput(ctx, bufferSize, stallTimeout, format, input, []Pass{LoudnessNormalizePass(targetLUFS, truePeak, &loudness)}, processors...)
+	if err != nil {
+		return nil, Loudness{}, err
+	}
+	return normalize, loudness, nil
+}
+
+// LoudnessNormalizePass returns the pass that measures the loudness of
+// the signal into provided one and brings it to target LUFS. If the gain
+// pushes measured true peak over truePeak dBTP, sample peaks are limited
+// to it with processor.Limiter, so inter-sample peaks of the output can
+// still exceed the ceiling slightly. Targets must be checked with
+// CheckLoudnessTarget.
+func LoudnessNormalizePass(targetLUFS, truePeak float64, loudness *Loudness) Pass {
+	return Pass{
+		Sink: loudnessSink(loudness),
+		Processors: func() ([]pipe.ProcessorAllocatorFunc, error) {
+			if math.IsInf(loudness.Integrated, -1) {
+				return nil, nil
+			}
+			gain := targetLUFS - loudness.Integrated
+			normalize := []pipe.ProcessorAllocatorFunc{processor.Gain(gain)}
+			if loudness.TruePeak+gain > truePeak {
+				normalize = append(normalize, processor.Limiter(truePeak, limiterRelease))
+			}
+			return normalize, nil
+		},
+	}
+}
+
+// loudnessSink measures the loudness of the signal.
+func loudnessSink(loudness *Loudness) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		m := newLoudnessMeter(props)
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				m.write(in)
+				return nil
+			},
+			FlushFunc: func(context.Context) error {
+				*loudness = m.loudness()
+				return nil
+			},
+		}, nil
+	}
+}
+
+func newLoudnessMeter(props pipe.SignalProperties) *loudnessMeter {
+	sampleRate := float64(props.SampleRate)
+	m := loudnessMeter{
+		weights:   channelWeights(props.Channels),
+		filters:   make([]kWeighting, props.Channels),
+		quarter:   make([]float64, props.Channels),
+		blockSize: int(sampleRate * loudnessBlock.Seconds() / 4),
+		peak:      newTruePeakMeter(sampleRate, props.Channels),
+	}
+	for i := range m.filters {
+		m.filters[i] = newKWeighting(sampleRate)
+	}
+	return &m
+}
+
+// channelWeights returns the weights of channels. Surround channels of
+// 5.1 layout are weighted by 1.41 and LFE is excluded.
+func channelWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if channels == 6 {
+		weights[3] = 0
+		weights[4] = 1.41
+		weights[5] = 1.41
+	}
+	return weights
+}
+
+func (m *loudnessMeter) write(in signal.Floating) {
+	channels := in.Channels()
+	for i := 0; i < in.Len(); i += channels {
+		for c := 0; c < channels; c++ {
+			v := in.Sample(i + c)
+			m.peak.write(c, v)
+			v = m.filters[c].process(v)
+			m.quarter[c] += v * v
+		}
+		m.samples++
+		if m.samples == m.blockSize {
+			m.endQuarter()
+		}
+	}
+}
+
+// endQuarter completes the quarter of the block. Every quarter completes
+// the block that consists of last four quarters.
+func (m *loudnessMeter) endQuarter() {
+	var energy float64
+	for c := range m.quarter {
+		energy += m.weights[c] * m.quarter[c]
+		m.quarter[c] = 0
+	}
+	m.samples = 0
+	m.quarters = append(m.quarters, energy/float64(m.blockSize))
+	if len(m.quarters) > shortTermQuarters {
+		m.quarters = append(m.quarters[:0], m.quarters[1:]...)
+	}
+	n := len(m.quarters)
+	if n < 4 {
+		return
+	}
+	m.blocks = append(m.blocks, (m.quarters[n-4]+m.quarters[n-3]+m.quarters[n-2]+m.quarters[n-1])/4)
+	if n == shortTermQuarters {
+		var sum float64
+		for _, q := range m.quarters {
+			sum += q
+		}
+		m.shortTerm = append(m.shortTerm, sum/shortTermQuarters)
+	}
+}
+
+// loudness returns the gated loudness of all blocks.
+func (m *loudnessMeter) loudness() Loudness {
+	return Loudness{
+		Integrated: gatedLoudness(m.blocks),
+		Range:      loudnessRange(m.shortTerm),
+		TruePeak:   20 * math.Log10(m.peak.peak()),
+	}
+}
+
+// gatedLoudness applies absolute and relative gates to the blocks and
+// returns the loudness of remaining ones.
+func gatedLoudness(blocks []float64) float64 {
+	mean := func(threshold float64) float64 {
+		var (
+			sum float64
+			n   int
+		)
+		for _, b := range blocks {
+			if energyLoudness(b) > threshold {
+				sum += b
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	absolute := mean(MinLoudness)
+	if absolute == 0 {
+		return math.Inf(-1)
+	}
+	return energyLoudness(mean(math.Max(MinLoudness, energyLoudness(absolute)+relativeGate)))
+}
+
+// loudnessRange returns the difference between 95th and 10th percentiles
+// of short-term loudness distribution. Windows are gated with the
+// absolute gate and the relative gate of loudness range.
+func loudnessRange(shortTerm []float64) float64 {
+	var (
+		sum   float64
+		gated []float64
+	)
+	for _, e := range shortTerm {
+		if energyLoudness(e) > MinLoudness {
+			sum += e
+			gated = append(gated, e)
+		}
+	}
+	if len(gated) == 0 {
+		return 0
+	}
+	threshold := energyLoudness(sum/float64(len(gated))) + rangeGate
+	var levels []float64
+	for _, e := range gated {
+		if l := energyLoudness(e); l > threshold {
+			levels = append(levels, l)
+		}
+	}
+	sort.Float64s(levels)
+	percentile := func(p float64) float64 {
+		return levels[int(math.Round(p*float64(len(levels)-1)))]
+	}
+	return percentile(0.95) - percentile(0.1)
+}
+
+// energyLoudness converts mean square of K-weighted signal into LUFS.
+func energyLoudness(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+// newKWeighting returns K-weighting filter for the sample rate.
+// Coefficients are derived from the filters defined for 48 kHz.
+func newKWeighting(sampleRate float64) kWeighting {
+	var f kWeighting
+	// high shelf
+	k := math.Tan(math.Pi * 1681.974450955533 / sampleRate)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	f.b[0] = [3]float64{(vh + vb*k/q + k*k) / a0, 2 * (k*k - vh) / a0, (vh - vb*k/q + k*k) / a0}
+	f.a[0] = [3]float64{1, 2 * (k*k - 1) / a0, (1 - k/q + k*k) / a0}
+	// high pass
+	k = math.Tan(math.Pi * 38.13547087602444 / sampleRate)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	f.b[1] = [3]float64{1, -2, 1}
+	f.a[1] = [3]float64{1, 2 * (k*k - 1) / a0, (1 - k/q + k*k) / a0}
+	return f
+}
+
+func (f *kWeighting) process(x float64) float64 {
+	for s := range f.b {
+		y := f.b[s][0]*x + f.b[s][1]*f.x1[s] + f.b[s][2]*f.x2[s] - f.a[s][1]*f.y1[s] - f.a[s][2]*f.y2[s]
+		f.x2[s], f.x1[s] = f.x1[s], x
+		f.y2[s], f.y1[s] = f.y1[s], y
+		x = y
+	}
+	return x
+}
+
+// truePeakTaps is the number of filter taps per phase.
+const truePeakTaps = 12
+
+// newTruePeakMeter returns the meter that oversamples the signal to at
+// least 192 kHz.
+func newTruePeakMeter(sampleRate float64, channels int) truePeakMeter {
+	factor := 1
+	for sampleRate*float64(factor) < 192000 && factor < 4 {
+		factor *= 2
+	}
+	m := truePeakMeter{
+		factor:  factor,
+		taps:    make([]float64, truePeakTaps*factor),
+		history: make([][]float64, channels),
+		peaks:   make([]float64, channels),
+	}
+	for i := range m.history {
+		m.history[i] = make([]float64, truePeakTaps)
+	}
+	// windowed sinc with cutoff at the original nyquist
+	center := float64(len(m.taps)-1) / 2
+	for i := range m.taps {
+		x := (float64(i) - center) / float64(factor)
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i+1)/float64(len(m.taps)+1))
+		m.taps[i] = sinc * window
+	}
+	return m
+}
+
+// write adds the sample of channel and updates the peak with
+// interpolated values.
+func (m *truePeakMeter) write(channel int, v float64) {
+	h := m.history[channel]
+	copy(h[1:], h[:len(h)-1])
+	h[0] = v
+	m.peaks[channel] = math.Max(m.peaks[channel], math.Abs(v))
+	if m.factor == 1 {
+		return
+	}
+	for p := 0; p < m.factor; p++ {
+		var y float64
+		for k, x := range h {
+			y += m.taps[p+k*m.factor] * x
+		}
+		m.peaks[channel] = math.Max(m.peaks[channel], math.Abs(y))
+	}
+}
+
+// peak returns the max peak of all channels.
+func (m *truePeakMeter) peak() float64 {
+	var peak float64
+	for _, p := range m.peaks {
+		peak = math.Max(peak, p)
+	}
+	return peak
+}
@@ -0,0 +1,106 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestLoudnessNormalize(t *testing.T) {
+	assert.Error(t, encode.CheckLoudnessTarget(-71, -1))
+	assert.Error(t, encode.CheckLoudnessTarget(-16, 0.5))
+	assert.NoError(t, encode.CheckLoudnessTarget(-16, -1.5))
+
+	// reference signal of EBU Tech 3341: 1 kHz sine at -23 dBFS in both
+	// channels is -23 LUFS
+	input := bytes.NewReader(sineWAV(48000, 997, -23, 10))
+	format := fileformat.WAV()
+	normalize, loudness, err := encode.LoudnessNormalize(context.Background(), 512, 0, format, input, -16, -1.5)
+	assert.NoError(t, err)
+	assert.InDelta(t, -23, loudness.Integrated, 0.1)
+	assert.InDelta(t, -23, loudness.TruePeak, 0.1)
+	assert.Len(t, normalize, 1)
+
+	var peak float64
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				for i := 0; i < in.Len(); i++ {
+					peak = math.Max(peak, math.Abs(in.Sample(i)))
+				}
+				return nil
+			},
+		}, nil
+	}
+	// input is rewound after analysis
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(input), sink, normalize...))
+	assert.InDelta(t, -16, 20*math.Log10(peak), 0.1)
+
+	// peaks over the ceiling are limited
+	_, err = input.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	normalize, _, err = encode.LoudnessNormalize(context.Background(), 512, 0, format, input, -1, -1.5)
+	assert.NoError(t, err)
+	assert.Len(t, normalize, 2)
+	peak = 0
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(input), sink, normalize...))
+	assert.True(t, 20*math.Log10(peak) <= -1.5+1e-6)
+
+	// silence is not changed
+	normalize, loudness, err = encode.LoudnessNormalize(context.Background(), 512, 0, format, bytes.NewReader(sineWAV(48000, 0, -20, 1)), -16, -1)
+	assert.NoError(t, err)
+	assert.Empty(t, normalize)
+	assert.True(t, math.IsInf(loudness.Integrated, -1))
+}
+
+// sineWAV returns 16-bit stereo wav file with sine of frequency at db
+// level. Zero frequency gives silence.
+func sineWAV(sampleRate, frequency int, db float64, seconds int) []byte {
+	frames := make([]float64, sampleRate*seconds)
+	amplitude := math.Pow(10, db/20)
+	for i := range frames {
+		frames[i] = amplitude * math.Sin(2*math.Pi*float64(frequency)*float64(i)/float64(sampleRate))
+	}
+	return stereoWAV(sampleRate, frames)
+}
+
+// stereoWAV returns 16-bit stereo wav file with the same frames in both
+// channels.
+func stereoWAV(sampleRate int, frames []float64) []byte {
+	data := make([]byte, len(frames)*4)
+	for i, f := range frames {
+		v := int16(f * math.MaxInt16)
+		binary.LittleEndian.PutUint16(data[4*i:], uint16(v))
+		binary.LittleEndian.PutUint16(data[4*i+2:], uint16(v))
+	}
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+len(data)))
+	b.WriteString("WAVEfmt ")
+	for _, v := range []interface{}{
+		uint32(16),             // chunk size
+		uint16(1),              // pcm
+		uint16(2),              // channels
+		uint32(sampleRate),     // sample rate
+		uint32(sampleRate * 4), // byte rate
+		uint16(4),              // block align
+		uint16(16),             // bit depth
+	} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	return b.Bytes()
+}
@@ -0,0 +1,88 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+// ParseDBFS parses the level in dBFS with optional unit, e.g. -1dBFS.
+func ParseDBFS(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	if len(v) > 4 && strings.EqualFold(v[len(v)-4:], "dbfs") {
+		v = strings.TrimSpace(v[:len(v)-4])
+	}
+	db, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid level %q: %w", s, err)
+	}
+	return db, nil
+}
+
+// CheckPeakTarget returns error if peak normalization target is above
+// full scale.
+func CheckPeakTarget(db float64) error {
+	if db > 0 || math.IsNaN(db) {
+		return fmt.Errorf("invalid peak target %v dBFS: must not be above 0", db)
+	}
+	return nil
+}
+
+// PeakNormalize returns the processor that scales the signal, so its peak
+// is at target dBFS. The input is decoded with provided processors in the
+// first pass to find the peak and then rewound. Unlike loudness
+// normalization, only the sample peak is measured, so it's cheap. Silent
+// input is not scaled.
+func PeakNormalize(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, targetDB float64, processors ...pipe.ProcessorAllocatorFunc) (pipe.ProcessorAllocatorFunc, error) {
+	if err := CheckPeakTarget(targetDB); err != nil {
+		return nil, err
+	}
+	normalize, err := AnalyzeInput(ctx, bufferSize, stallTimeout, format, input, []Pass{PeakNormalizePass(targetDB)}, processors...)
+	if err != nil {
+		return nil, err
+	}
+	return normalize[0], nil
+}
+
+// PeakNormalizePass returns the pass that finds the peak of the signal
+// and scales it to target dBFS. Target must be checked with
+// CheckPeakTarget.
+func PeakNormalizePass(targetDB float64) Pass {
+	var peak float64
+	return Pass{
+		Sink: peakSink(&peak),
+		Processors: func() ([]pipe.ProcessorAllocatorFunc, error) {
+			if peak == 0 {
+				return []pipe.ProcessorAllocatorFunc{processor.Gain(0)}, nil
+			}
+			return []pipe.ProcessorAllocatorFunc{processor.Gain(targetDB - 20*math.Log10(peak))}, nil
+		},
+	}
+}
+
+// peakSink measures the sample peak of the signal.
+func peakSink(peak *float64) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				for i := 0; i < in.Len(); i++ {
+					if v := math.Abs(in.Sample(i)); v > *peak {
+						*peak = v
+					}
+				}
+				return nil
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,61 @@
+package encode_test
+
+import (
+	"context"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestParseDBFS(t *testing.T) {
+	for s, expected := range map[string]float64{
+		"-1":        -1,
+		"-1dBFS":    -1,
+		"-0.5 dbfs": -0.5,
+		"0DBFS":     0,
+	} {
+		db, err := encode.ParseDBFS(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, db, s)
+	}
+	_, err := encode.ParseDBFS("dBFS")
+	assert.Error(t, err)
+	_, err = encode.ParseDBFS("-1dB")
+	assert.Error(t, err)
+}
+
+func TestPeakNormalize(t *testing.T) {
+	assert.Error(t, encode.CheckPeakTarget(0.1))
+	assert.NoError(t, encode.CheckPeakTarget(0))
+
+	f, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer f.Close()
+
+	format := fileformat.WAV()
+	normalize, err := encode.PeakNormalize(context.Background(), 512, 0, format, f, -3)
+	assert.NoError(t, err)
+
+	var peak float64
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				for i := 0; i < in.Len(); i++ {
+					peak = math.Max(peak, math.Abs(in.Sample(i)))
+				}
+				return nil
+			},
+		}, nil
+	}
+	// input is rewound after analysis
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(f), sink, normalize))
+	assert.InDelta(t, -3, 20*math.Log10(peak), 0.001)
+}
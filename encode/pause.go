@@ -0,0 +1,119 @@
+package encode
+
+import (
+	"context"
+	"sync"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+type (
+	// Pause holds pipes between buffers while it's paused. Pipes are
+	// attached to the pause with the context, see WithPause. Paused pipes
+	// keep their state, so they continue from the same position when
+	// the pause is resumed.
+	Pause struct {
+		mu      sync.Mutex
+		resumed chan struct{}
+	}
+
+	pauseKey struct{}
+)
+
+// NewPause returns resumed pause.
+func NewPause() *Pause {
+	resumed := make(chan struct{})
+	close(resumed)
+	return &Pause{resumed: resumed}
+}
+
+// Pause holds pipes before the next buffer. It does nothing if pipes are
+// already paused.
+func (p *Pause) Pause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.resumed:
+		p.resumed = make(chan struct{})
+	default:
+	}
+}
+
+// Resume releases held pipes. It does nothing if pipes are not paused.
+func (p *Pause) Resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.resumed:
+	default:
+		close(p.resumed)
+	}
+}
+
+// Paused returns true if pipes are held.
+func (p *Pause) Paused() bool {
+	select {
+	case <-p.done():
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *Pause) done() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resumed
+}
+
+// wait blocks until the pause is resumed or the context is done.
+func (p *Pause) wait(ctx context.Context) error {
+	select {
+	case <-p.done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sink wraps the sink allocator to hold the sink before every buffer
+// while the pipe is paused. Held sink blocks all stages of the pipe.
+func (p *Pause) sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		sink, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return sink, err
+		}
+		ctx := context.Background()
+		startFn, sinkFn := sink.StartFunc, sink.SinkFunc
+		sink.StartFunc = func(c context.Context) error {
+			ctx = c
+			if startFn == nil {
+				return nil
+			}
+			return startFn(c)
+		}
+		sink.SinkFunc = func(in signal.Floating) error {
+			if err := p.wait(ctx); err != nil {
+				return err
+			}
+			return sinkFn(in)
+		}
+		return sink, nil
+	}
+}
+
+// WithPause returns the context that attaches pipes run with it to the
+// pause. Time spent in pause isn't counted by stall timeout.
+func WithPause(ctx context.Context, p *Pause) context.Context {
+	return context.WithValue(ctx, pauseKey{}, p)
+}
+
+// pauseFrom returns the pause attached to the context, nil if there is
+// none.
+func pauseFrom(ctx context.Context) *Pause {
+	p, _ := ctx.Value(pauseKey{}).(*Pause)
+	return p
+}
@@ -0,0 +1,71 @@
+package encode_test
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestPause(t *testing.T) {
+	buffers := func(n int) pipe.SourceAllocatorFunc {
+		return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+			calls := 0
+			return pipe.Source{
+				SignalProperties: pipe.SignalProperties{
+					SampleRate: 44100,
+					Channels:   1,
+				},
+				SourceFunc: func(out signal.Floating) (int, error) {
+					if calls++; calls > n {
+						return 0, io.EOF
+					}
+					return out.Len(), nil
+				},
+			}, nil
+		}
+	}
+	var sunk int32
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				atomic.AddInt32(&sunk, 1)
+				return nil
+			},
+		}, nil
+	}
+
+	pause := encode.NewPause()
+	pause.Pause()
+	pause.Pause()
+	assert.True(t, pause.Paused())
+	errc := make(chan error, 1)
+	go func() {
+		// paused time isn't counted by stall timeout
+		errc <- encode.Run(encode.WithPause(context.Background(), pause), 16, 20*time.Millisecond, buffers(10), sink)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sunk))
+	pause.Resume()
+	pause.Resume()
+	assert.False(t, pause.Paused())
+	assert.NoError(t, <-errc)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&sunk))
+
+	// paused pipe is cancelled
+	pause.Pause()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	go func() {
+		errc <- encode.Run(encode.WithPause(ctx, pause), 16, 0, buffers(10), sink)
+	}()
+	cancelFn()
+	assert.Equal(t, encode.CodeCanceled, encode.Code(<-errc))
+}
@@ -3,19 +3,194 @@ package encode
 import (
 	"context"
 	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
 
 	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
 )
 
-// Run encoding using Pump as the source and Sinks as destination.
-func Run(ctx context.Context, bufferSize int, pump pipe.SourceAllocatorFunc, sink pipe.SinkAllocatorFunc) error {
-	// run conversion
-	err := pipe.Run(ctx, bufferSize, pipe.Line{
-		Source: pump,
-		Sink:   sink,
-	})
+// MinStallTimeout is the shortest stall timeout. Progress is checked a
+// few times per timeout, shorter ones would cancel healthy conversions.
+const MinStallTimeout = time.Millisecond
+
+// CheckStallTimeout returns error if stall timeout is negative or shorter
+// than MinStallTimeout. Zero timeout disables the check.
+func CheckStallTimeout(timeout time.Duration) error {
+	if timeout < 0 || (timeout > 0 && timeout < MinStallTimeout) {
+		return fmt.Errorf("invalid stall timeout %v: must be zero or at least %v", timeout, MinStallTimeout)
+	}
+	return nil
+}
+
+// Run encoding using Pump as the source, Sink as destination and optional
+// processors in between. If
+// stallTimeout is not zero, the pipe is cancelled when it makes no
+// progress longer than timeout and StallError is returned. If context has
+// deadline, the pipe is cancelled when it's exceeded, even if some stage
+// doesn't return. Cancelled pipe returns the error of the context, even
+// if all stages are flushed. Decoding and encoding failures are returned as
+// CodecError. On multicore machines every stage runs in its own
+// goroutine, so decoding of the next buffer overlaps processing and
+// encoding of the previous ones. Pipes are paused if CPU is limited, see
+// LimitCPU, and if the pause attached to the context is paused, see
+// WithPause.
+func Run(ctx context.Context, bufferSize int, stallTimeout time.Duration, pump pipe.SourceAllocatorFunc, sink pipe.SinkAllocatorFunc, processors ...pipe.ProcessorAllocatorFunc) error {
+	var c classifier
+	pump, sink = c.source(pump), c.sink(sink)
+	pause := pauseFrom(ctx)
+	if pause != nil {
+		sink = pause.sink(sink)
+	}
+	_, hasDeadline := ctx.Deadline()
+	if stallTimeout == 0 && !hasDeadline {
+		return c.result(run(ctx, bufferSize, pump, sink, processors))
+	}
+
+	ctx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+	p := newProgress()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- run(ctx, bufferSize, p.source(pump), p.sink(sink), processors)
+	}()
+
+	var stallCheck <-chan time.Time
+	if stallTimeout > 0 {
+		// timeouts shorter than 4ns would give zero interval
+		interval := stallTimeout / 4
+		if interval == 0 {
+			interval = stallTimeout
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		stallCheck = ticker.C
+	}
+	for {
+		select {
+		case err := <-errc:
+			return c.result(err)
+		case <-ctx.Done():
+			// blocked stage might never return, so don't wait for it
+			log.Printf("Pipe cancelled: %v", ctx.Err())
+			return fmt.Errorf("failed to execute pipe: %w", ctx.Err())
+		case <-stallCheck:
+			if pause != nil && pause.Paused() {
+				// paused pipe isn't stalled
+				p.touch()
+				continue
+			}
+			if err := p.stalled(stallTimeout); err != nil {
+				// blocked stage might never return, so don't wait for it
+				cancelFn()
+				log.Printf("Pipe cancelled: %v", err)
+				return err
+			}
+		}
+	}
+}
+
+func run(ctx context.Context, bufferSize int, pump pipe.SourceAllocatorFunc, sink pipe.SinkAllocatorFunc, processors []pipe.ProcessorAllocatorFunc) error {
+	line := pipe.Line{
+		Source:     pump,
+		Processors: processors,
+		Sink:       sink,
+	}
+	if dutyCycle < 1 {
+		line.Sink = throttle(sink, dutyCycle)
+	}
+	// cancelled pipe isn't started, the source would report the end of
+	// the input otherwise
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to execute pipe: %w", err)
+	}
+	var err error
+	if runtime.GOMAXPROCS(0) > 1 {
+		err = runConcurrent(ctx, bufferSize, line)
+	} else {
+		err = pipe.Run(ctx, bufferSize, line)
+	}
+	// pipe stops the source on cancellation as if the input ended, so
+	// the truncated output must not be reported as complete
+	if err == nil {
+		err = ctx.Err()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to execute pipe: %w", err)
 	}
 	return nil
 }
+
+// runConcurrent runs every stage of the line in its own goroutine. Stages
+// are connected with channels of a single buffer, so a stage can't get
+// ahead of the next one by more than two buffers. The pipe returns the
+// first error without waiting for other stages, so it's waited until all
+// of them are flushed.
+func runConcurrent(ctx context.Context, bufferSize int, line pipe.Line) error {
+	var s stages
+	source := line.Source
+	line.Source = func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		c, err := source(mctx, bufferSize)
+		if err == nil {
+			c.StartFunc, c.FlushFunc = s.hooks(c.StartFunc, c.FlushFunc)
+		}
+		return c, err
+	}
+	processors := make([]pipe.ProcessorAllocatorFunc, len(line.Processors))
+	for i := range line.Processors {
+		processor := line.Processors[i]
+		processors[i] = func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+			c, err := processor(mctx, bufferSize, props)
+			if err == nil {
+				c.StartFunc, c.FlushFunc = s.hooks(c.StartFunc, c.FlushFunc)
+			}
+			return c, err
+		}
+	}
+	line.Processors = processors
+	sink := line.Sink
+	line.Sink = func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		c, err := sink(mctx, bufferSize, props)
+		if err == nil {
+			c.StartFunc, c.FlushFunc = s.hooks(c.StartFunc, c.FlushFunc)
+		}
+		return c, err
+	}
+
+	p, err := pipe.New(bufferSize, line)
+	if err != nil {
+		return err
+	}
+	err = pipe.Wait(p.Start(ctx))
+	s.Wait()
+	return err
+}
+
+// stages tracks stages of the pipe that are started and not flushed yet.
+type stages struct {
+	sync.WaitGroup
+}
+
+// hooks wraps start and flush hooks of the stage. The stage is done when
+// it's flushed or fails to start.
+func (s *stages) hooks(start pipe.StartFunc, flush pipe.FlushFunc) (pipe.StartFunc, pipe.FlushFunc) {
+	s.Add(1)
+	return func(ctx context.Context) error {
+			if start == nil {
+				return nil
+			}
+			err := start(ctx)
+			if err != nil {
+				s.Done()
+			}
+			return err
+		}, func(ctx context.Context) error {
+			defer s.Done()
+			if flush == nil {
+				return nil
+			}
+			return flush(ctx)
+		}
+}
@@ -0,0 +1,179 @@
+package encode_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+// blockingSource returns a source that produces one buffer and blocks
+// until unblock is closed.
+func blockingSource(unblock <-chan struct{}) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		calls := 0
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{
+				SampleRate: 44100,
+				Channels:   1,
+			},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				calls++
+				switch calls {
+				case 1:
+					return out.Len(), nil
+				case 2:
+					<-unblock
+				}
+				return 0, io.EOF
+			},
+		}, nil
+	}
+}
+
+func discardSink(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+	return pipe.Sink{
+		SinkFunc: func(in signal.Floating) error {
+			return nil
+		},
+	}, nil
+}
+
+func TestRunStalled(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	err := encode.Run(context.Background(), 16, 20*time.Millisecond, blockingSource(unblock), discardSink)
+	assert.True(t, errors.Is(err, encode.ErrStalled))
+
+	var stallErr *encode.StallError
+	assert.True(t, errors.As(err, &stallErr))
+	assert.Equal(t, "source", stallErr.Stage)
+	assert.Equal(t, int64(16), stallErr.Sourced)
+	assert.Equal(t, int64(16), stallErr.Sunk)
+}
+
+func TestRunStalledShortTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	err := encode.Run(context.Background(), 16, 3*time.Nanosecond, blockingSource(unblock), discardSink)
+	assert.True(t, errors.Is(err, encode.ErrStalled))
+}
+
+func TestCheckStallTimeout(t *testing.T) {
+	assert.NoError(t, encode.CheckStallTimeout(0))
+	assert.NoError(t, encode.CheckStallTimeout(time.Minute))
+	assert.Error(t, encode.CheckStallTimeout(-time.Second))
+	assert.Error(t, encode.CheckStallTimeout(3*time.Nanosecond))
+}
+
+func TestRunNotStalled(t *testing.T) {
+	unblock := make(chan struct{})
+	close(unblock)
+
+	err := encode.Run(context.Background(), 16, time.Second, blockingSource(unblock), discardSink)
+	assert.NoError(t, err)
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	endless := func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{
+				SampleRate: 44100,
+				Channels:   1,
+			},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				return out.Len(), nil
+			},
+		}, nil
+	}
+	var sunk int32
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				if atomic.AddInt32(&sunk, 1) == 10 {
+					cancelFn()
+				}
+				return nil
+			},
+		}, nil
+	}
+
+	err := encode.Run(ctx, 16, 0, endless, sink)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, encode.CodeCanceled, encode.Code(err))
+
+	// cancelled pipe isn't started
+	sunk = 0
+	err = encode.Run(ctx, 16, 0, endless, sink)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sunk))
+}
+
+func TestRunDeadline(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelFn()
+	err := encode.Run(ctx, 16, 0, blockingSource(unblock), discardSink)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, encode.CodeTimeout, encode.Code(err))
+}
+
+func TestRunConcurrent(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+
+	var calls int32
+	source := func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{
+				SampleRate: 44100,
+				Channels:   1,
+			},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				if atomic.AddInt32(&calls, 1) > 4 {
+					return 0, io.EOF
+				}
+				return out.Length(), nil
+			},
+		}, nil
+	}
+	// the first buffer is sunk after the source reads the next one, so
+	// stages would block each other if they ran sequentially
+	overlapped := false
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				if overlapped {
+					return nil
+				}
+				deadline := time.Now().Add(time.Second)
+				for atomic.LoadInt32(&calls) < 2 {
+					if time.Now().After(deadline) {
+						return errors.New("source didn't read ahead")
+					}
+					time.Sleep(time.Millisecond)
+				}
+				overlapped = true
+				return nil
+			},
+		}, nil
+	}
+	err := encode.Run(context.Background(), 16, 0, source, sink)
+	assert.NoError(t, err)
+	assert.True(t, overlapped)
+}
@@ -0,0 +1,359 @@
+package encode
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// mp3ScanSize is the max number of bytes scanned for the first mp3 frame
+// after the ID3 tag.
+const mp3ScanSize = 64 << 10
+
+var (
+	// ErrProbeUnsupported is returned if the format cannot be probed
+	// without decoding.
+	ErrProbeUnsupported = errors.New("format cannot be probed")
+
+	mp3Bitrates = [2][16]int{
+		// MPEG 1
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		// MPEG 2 and 2.5
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	}
+	mp3SampleRates = [3]int{44100, 48000, 32000}
+)
+
+type (
+	// Info describes the audio stream of the file. Format is the file
+	// extension without the dot, duration is in seconds and bitrate is in
+	// bits per second. Estimated is true if the duration is calculated
+	// from the bitrate of the first frame.
+	Info struct {
+		Format     string  `json:"format"`
+		SampleRate int     `json:"sample_rate"`
+		Channels   int     `json:"channels"`
+		BitDepth   int     `json:"bit_depth,omitempty"`
+		Duration   float64 `json:"duration"`
+		Bitrate    int     `json:"bitrate"`
+		Estimated  bool    `json:"estimated,omitempty"`
+	}
+
+	// header is the stream information read from the input header. Zero
+	// frames means the length is unknown. Checksum is MD5 signature of
+	// flac samples, nil if it's not provided.
+	header struct {
+		sampleRate int
+		channels   int
+		bitDepth   signal.BitDepth
+		frames     int64
+		checksum   []byte
+	}
+)
+
+// Probe reads the information from headers of the input without decoding
+// of the audio. Length of mp3 input is read from Xing or VBRI header of
+// the first frame, otherwise it's estimated with the bitrate of the first
+// frame. Size is the size of the input in bytes. ErrProbeUnsupported is
+// returned for formats other than wav, flac and mp3.
+func Probe(format *fileformat.Format, input io.ReadSeeker, size int64) (Info, error) {
+	var (
+		info Info
+		err  error
+	)
+	switch format {
+	case fileformat.WAV(), fileformat.FLAC():
+		var h header
+		if format == fileformat.WAV() {
+			h, err = readWAVHeader(input)
+		} else {
+			h, err = readFLACHeader(input)
+		}
+		if err != nil {
+			return Info{}, err
+		}
+		info = Info{
+			SampleRate: h.sampleRate,
+			Channels:   h.channels,
+			BitDepth:   int(h.bitDepth),
+			Duration:   float64(h.frames) / float64(h.sampleRate),
+		}
+		if format == fileformat.WAV() {
+			info.Bitrate = h.sampleRate * h.channels * int(h.bitDepth)
+		} else if info.Duration > 0 {
+			info.Bitrate = int(float64(size*8) / info.Duration)
+		}
+	case fileformat.MP3():
+		if info, err = probeMP3(input, size); err != nil {
+			return Info{}, err
+		}
+	default:
+		return Info{}, ErrProbeUnsupported
+	}
+	info.Format = strings.TrimPrefix(format.DefaultExtension(), ".")
+	return info, nil
+}
+
+// Inspect fully decodes the input to find its exact duration. Size is the
+// size of the input in bytes.
+func Inspect(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, size int64) (Info, error) {
+	var bitDepth signal.BitDepth
+	if format != fileformat.MP3() {
+		// invalid header is reported by the decoding
+		bitDepth, _ = SourceBitDepth(format, input)
+	}
+	var (
+		props  pipe.SignalProperties
+		frames int
+	)
+	sink := func(mctx mutable.Context, bufferSize int, p pipe.SignalProperties) (pipe.Sink, error) {
+		props = p
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				frames += in.Length()
+				return nil
+			},
+		}, nil
+	}
+	if err := Run(ctx, bufferSize, stallTimeout, format.Source(input), sink); err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Format:     strings.TrimPrefix(format.DefaultExtension(), "."),
+		SampleRate: int(props.SampleRate),
+		Channels:   props.Channels,
+		BitDepth:   int(bitDepth),
+		Duration:   float64(frames) / float64(props.SampleRate),
+	}
+	if info.Duration > 0 {
+		info.Bitrate = int(float64(size*8) / info.Duration)
+	}
+	return info, nil
+}
+
+// readWAVHeader reads the format chunk and the number of frames from the
+// size of data chunk. Length is unknown if the size is not set by
+// streaming writers.
+func readWAVHeader(r io.Reader) (header, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return header{}, err
+	}
+	if !bytes.Equal(riff[:4], []byte("RIFF")) || !bytes.Equal(riff[8:], []byte("WAVE")) {
+		return header{}, fmt.Errorf("invalid wav header")
+	}
+	var (
+		h          header
+		blockAlign int64
+	)
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return header{}, err
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch {
+		case bytes.Equal(chunk[:4], []byte("fmt ")):
+			var format [16]byte
+			if _, err := io.ReadFull(r, format[:]); err != nil {
+				return header{}, err
+			}
+			h.channels = int(binary.LittleEndian.Uint16(format[2:]))
+			h.sampleRate = int(binary.LittleEndian.Uint32(format[4:]))
+			blockAlign = int64(binary.LittleEndian.Uint16(format[12:]))
+			h.bitDepth = signal.BitDepth(binary.LittleEndian.Uint16(format[14:]))
+			size -= int64(len(format))
+		case bytes.Equal(chunk[:4], []byte("data")):
+			if blockAlign == 0 {
+				return header{}, fmt.Errorf("wav data chunk before format chunk")
+			}
+			if size != math.MaxUint32 {
+				h.frames = size / blockAlign
+			}
+			return h, nil
+		}
+		// chunks are padded to even size
+		if _, err := io.CopyN(ioutil.Discard, r, size+size%2); err != nil {
+			return header{}, err
+		}
+	}
+}
+
+// readFLACHeader reads the stream info block. Zero signature means it
+// wasn't computed by encoder.
+func readFLACHeader(r io.Reader) (header, error) {
+	// marker, block header and stream info
+	var b [42]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return header{}, err
+	}
+	if !bytes.Equal(b[:4], []byte("fLaC")) || b[4]&0x7f != 0 {
+		return header{}, fmt.Errorf("invalid flac header")
+	}
+	info := b[8:]
+	h := header{
+		sampleRate: int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4,
+		channels:   int(info[12]>>1&0x07) + 1,
+		bitDepth:   signal.BitDepth((info[12]&1)<<4|info[13]>>4) + 1,
+		frames:     int64(info[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(info[14:])),
+	}
+	if checksum := info[18:34]; !bytes.Equal(checksum, make([]byte, len(checksum))) {
+		h.checksum = checksum
+	}
+	return h, nil
+}
+
+// probeMP3 finds the first frame after ID3 tag and reads the number of
+// frames from its Xing or VBRI header.
+func probeMP3(r io.ReadSeeker, size int64) (Info, error) {
+	var id3 [10]byte
+	if _, err := io.ReadFull(r, id3[:]); err != nil {
+		return Info{}, err
+	}
+	var offset int64
+	if bytes.Equal(id3[:3], []byte("ID3")) {
+		// tag size is syncsafe integer without header and footer
+		offset = int64(id3[6])<<21 | int64(id3[7])<<14 | int64(id3[8])<<7 | int64(id3[9]) + 10
+		if id3[5]&0x10 != 0 {
+			offset += 10
+		}
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return Info{}, err
+	}
+	buf := make([]byte, mp3ScanSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return Info{}, err
+	}
+	buf = buf[:n]
+	var (
+		frame mp3Frame
+		pos   = -1
+	)
+	for i := 0; i+4 <= len(buf); i++ {
+		if f, ok := parseMP3Frame(buf[i:]); ok {
+			// next frame must follow if it's in the buffer
+			if next := i + f.length; next+4 <= len(buf) {
+				if _, ok := parseMP3Frame(buf[next:]); !ok {
+					continue
+				}
+			}
+			frame, pos = f, i
+			break
+		}
+	}
+	if pos < 0 {
+		return Info{}, fmt.Errorf("mp3 frame not found")
+	}
+	audioBytes := size - offset - int64(pos)
+	// ID3v1 tag is at the end of the file
+	if size >= 128 {
+		var tag [3]byte
+		if _, err := r.Seek(size-128, io.SeekStart); err != nil {
+			return Info{}, err
+		}
+		if _, err := io.ReadFull(r, tag[:]); err == nil && bytes.Equal(tag[:], []byte("TAG")) {
+			audioBytes -= 128
+		}
+	}
+	info := Info{
+		SampleRate: frame.sampleRate,
+		Channels:   frame.channels,
+	}
+	if frames := frame.vbrFrames(buf[pos:]); frames > 0 {
+		info.Duration = float64(frames*frame.samples) / float64(frame.sampleRate)
+		info.Bitrate = int(float64(audioBytes*8) / info.Duration)
+		return info, nil
+	}
+	info.Bitrate = frame.bitrate
+	info.Duration = float64(audioBytes*8) / float64(frame.bitrate)
+	info.Estimated = true
+	return info, nil
+}
+
+// mp3Frame is the header of layer III frame. Length is in bytes,
+// bitrate is in bits per second.
+type mp3Frame struct {
+	mpeg1      bool
+	sampleRate int
+	channels   int
+	bitrate    int
+	samples    int
+	length     int
+}
+
+// parseMP3Frame parses the frame header at the start of b.
+func parseMP3Frame(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := b[1] >> 3 & 0x03
+	layer := b[1] >> 1 & 0x03
+	bitrateIndex := b[2] >> 4
+	rateIndex := b[2] >> 2 & 0x03
+	// reserved version, layers other than III, free and bad bitrates and
+	// reserved sample rate are rejected
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+	f := mp3Frame{
+		mpeg1:      version == 3,
+		sampleRate: mp3SampleRates[rateIndex],
+		channels:   2,
+		samples:    1152,
+	}
+	table := 0
+	switch version {
+	case 2:
+		f.sampleRate /= 2
+		table, f.samples = 1, 576
+	case 0:
+		f.sampleRate /= 4
+		table, f.samples = 1, 576
+	}
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+	f.bitrate = mp3Bitrates[table][bitrateIndex] * 1000
+	f.length = f.samples/8*f.bitrate/f.sampleRate + int(b[2]>>1&0x01)
+	return f, true
+}
+
+// vbrFrames returns the number of frames from Xing or VBRI header of the
+// frame at the start of b. Zero is returned if there is no header.
+func (f mp3Frame) vbrFrames(b []byte) int {
+	// Xing header follows side information
+	side := 32
+	switch {
+	case f.mpeg1 && f.channels == 1:
+		side = 17
+	case !f.mpeg1 && f.channels == 2:
+		side = 17
+	case !f.mpeg1:
+		side = 9
+	}
+	if x := 4 + side; len(b) >= x+12 {
+		tag := string(b[x : x+4])
+		if (tag == "Xing" || tag == "Info") && b[x+7]&0x01 != 0 {
+			return int(binary.BigEndian.Uint32(b[x+8:]))
+		}
+	}
+	// VBRI header is at fixed offset
+	if v := 4 + 32; len(b) >= v+18 && string(b[v:v+4]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(b[v+14:]))
+	}
+	return 0
+}
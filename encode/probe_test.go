@@ -0,0 +1,77 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestProbe(t *testing.T) {
+	probe := func(format *fileformat.Format, data []byte) (encode.Info, error) {
+		return encode.Probe(format, bytes.NewReader(data), int64(len(data)))
+	}
+	// probed info of lossless formats must match the decoded one
+	for _, test := range []struct {
+		format *fileformat.Format
+		path   string
+	}{
+		{fileformat.WAV(), "../_testdata/sample.wav"},
+		{fileformat.FLAC(), "../_testdata/sample.flac"},
+	} {
+		data, err := ioutil.ReadFile(test.path)
+		assert.NoError(t, err)
+		info, err := probe(test.format, data)
+		assert.NoError(t, err)
+		decoded, err := encode.Inspect(context.Background(), 512, 0, test.format, bytes.NewReader(data), int64(len(data)))
+		assert.NoError(t, err)
+		assert.InDelta(t, decoded.Duration, info.Duration, 0.001)
+		info.Duration, decoded.Duration = 0, 0
+		if test.format == fileformat.WAV() {
+			// wav bitrate is calculated from the format
+			info.Bitrate, decoded.Bitrate = 0, 0
+		}
+		assert.InDelta(t, decoded.Bitrate, info.Bitrate, 1)
+		info.Bitrate, decoded.Bitrate = 0, 0
+		assert.Equal(t, decoded, info)
+	}
+
+	// 100 frames of mpeg 1 layer III, 128 kbps, 44.1 kHz stereo
+	frames := make([]byte, 100*417)
+	for i := 0; i < len(frames); i += 417 {
+		copy(frames[i:], []byte{0xff, 0xfb, 0x90, 0x00})
+	}
+	id3 := []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 100}
+	id3 = append(id3, make([]byte, 100)...)
+
+	info, err := probe(fileformat.MP3(), append(id3, frames...))
+	assert.NoError(t, err)
+	assert.True(t, info.Estimated)
+	assert.Equal(t, encode.Info{
+		Format:     "mp3",
+		SampleRate: 44100,
+		Channels:   2,
+		Duration:   float64(len(frames)*8) / 128000,
+		Bitrate:    128000,
+		Estimated:  true,
+	}, info)
+
+	// first frame has Xing header with the number of frames
+	xing := append([]byte{}, frames...)
+	copy(xing[36:], "Xing")
+	binary.BigEndian.PutUint32(xing[40:], 1)
+	binary.BigEndian.PutUint32(xing[44:], 99)
+	info, err = probe(fileformat.MP3(), xing)
+	assert.NoError(t, err)
+	assert.False(t, info.Estimated)
+	assert.InDelta(t, 99*1152/44100.0, info.Duration, 0.0001)
+
+	_, err = probe(fileformat.MP3(), make([]byte, 1000))
+	assert.Error(t, err)
+}
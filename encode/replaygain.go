@@ -0,0 +1,133 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+// ReplayGainReference is the reference loudness of ReplayGain 2.0 in
+// LUFS.
+const ReplayGainReference = -18.0
+
+// ReplayGainMode defines which gain is applied during the conversion.
+type ReplayGainMode string
+
+// ReplayGain modes. Album mode falls back to the track gain if the album
+// gain isn't known.
+const (
+	ReplayGainOff   ReplayGainMode = "off"
+	ReplayGainTrack ReplayGainMode = "track"
+	ReplayGainAlbum ReplayGainMode = "album"
+)
+
+type (
+	// ReplayGain is the gain in dB that brings the signal to the reference
+	// loudness and the sample peak of the signal as amplitude, where 1 is
+	// full scale. Gain of silent signal is zero.
+	ReplayGain struct {
+		Gain float64 `json:"gain"`
+		Peak float64 `json:"peak"`
+	}
+
+	// ReplayGainScanner measures ReplayGain of tracks and the album that
+	// consists of all scanned tracks. Loudness of the album is measured
+	// over gating blocks of all tracks.
+	ReplayGainScanner struct {
+		blocks []float64
+		peak   float64
+	}
+)
+
+// ParseReplayGainMode returns ReplayGain mode by its name.
+func ParseReplayGainMode(s string) (ReplayGainMode, error) {
+	switch m := ReplayGainMode(s); m {
+	case ReplayGainOff, ReplayGainTrack, ReplayGainAlbum:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid replaygain mode %q: must be %s, %s or %s", s, ReplayGainOff, ReplayGainTrack, ReplayGainAlbum)
+}
+
+// NewReplayGainScanner returns new scanner.
+func NewReplayGainScanner() *ReplayGainScanner {
+	return &ReplayGainScanner{}
+}
+
+// Scan decodes the track with provided processors, returns its ReplayGain
+// and adds it to the album. The input is rewound after the scan.
+func (s *ReplayGainScanner) Scan(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, processors ...pipe.ProcessorAllocatorFunc) (ReplayGain, error) {
+	var g ReplayGain
+	if _, err := AnalyzeInput(ctx, bufferSize, stallTimeout, format, input, []Pass{s.Pass(&g)}, processors...); err != nil {
+		return ReplayGain{}, err
+	}
+	return g, nil
+}
+
+// Pass returns the pass that measures ReplayGain of the track into
+// provided one and adds it to the album. The pass doesn't change the
+// signal, the gain is applied with Apply.
+func (s *ReplayGainScanner) Pass(g *ReplayGain) Pass {
+	var (
+		m    *loudnessMeter
+		peak float64
+	)
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		m = newLoudnessMeter(props)
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				m.write(in)
+				for i := 0; i < in.Len(); i++ {
+					peak = math.Max(peak, math.Abs(in.Sample(i)))
+				}
+				return nil
+			},
+		}, nil
+	}
+	return Pass{
+		Sink: sink,
+		Processors: func() ([]pipe.ProcessorAllocatorFunc, error) {
+			s.blocks = append(s.blocks, m.blocks...)
+			s.peak = math.Max(s.peak, peak)
+			*g = replayGain(m.blocks, peak)
+			return nil, nil
+		},
+	}
+}
+
+// Album returns ReplayGain of all scanned tracks.
+func (s *ReplayGainScanner) Album() ReplayGain {
+	return replayGain(s.blocks, s.peak)
+}
+
+// Apply returns the processor that applies the gain. The gain is reduced
+// if it pushes the peak over full scale.
+func (g ReplayGain) Apply() pipe.ProcessorAllocatorFunc {
+	gain := g.Gain
+	if g.Peak > 0 {
+		gain = math.Min(gain, -20*math.Log10(g.Peak))
+	}
+	return processor.Gain(gain)
+}
+
+// String returns the gain and peak.
+func (g ReplayGain) String() string {
+	return fmt.Sprintf("%+.2f dB, peak %.6f", g.Gain, g.Peak)
+}
+
+// replayGain returns the gain of gating blocks to the reference loudness.
+func replayGain(blocks []float64, peak float64) ReplayGain {
+	loudness := gatedLoudness(blocks)
+	if math.IsInf(loudness, -1) {
+		return ReplayGain{Peak: peak}
+	}
+	return ReplayGain{Gain: ReplayGainReference - loudness, Peak: peak}
+}
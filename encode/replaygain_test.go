@@ -0,0 +1,66 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestReplayGain(t *testing.T) {
+	_, err := encode.ParseReplayGainMode("loud")
+	assert.Error(t, err)
+	m, err := encode.ParseReplayGainMode("album")
+	assert.NoError(t, err)
+	assert.Equal(t, encode.ReplayGainAlbum, m)
+
+	format := fileformat.WAV()
+	scanner := encode.NewReplayGainScanner()
+	// -23 LUFS reference signal needs +5 dB
+	quiet := bytes.NewReader(sineWAV(48000, 997, -23, 10))
+	g, err := scanner.Scan(context.Background(), 512, 0, format, quiet)
+	assert.NoError(t, err)
+	assert.InDelta(t, 5, g.Gain, 0.1)
+	assert.InDelta(t, math.Pow(10, -23.0/20), g.Peak, 0.001)
+	loud := bytes.NewReader(sineWAV(48000, 997, -13, 10))
+	g, err = scanner.Scan(context.Background(), 512, 0, format, loud)
+	assert.NoError(t, err)
+	assert.InDelta(t, -5, g.Gain, 0.1)
+	// album is dominated by the loud track
+	album := scanner.Album()
+	assert.True(t, album.Gain < -2 && album.Gain > -5, "album gain %v", album.Gain)
+	assert.InDelta(t, math.Pow(10, -13.0/20), album.Peak, 0.001)
+
+	var peak float64
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				for i := 0; i < in.Len(); i++ {
+					peak = math.Max(peak, math.Abs(in.Sample(i)))
+				}
+				return nil
+			},
+		}, nil
+	}
+	// input is rewound after scan
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(quiet), sink, encode.ReplayGain{Gain: 5, Peak: g.Peak}.Apply()))
+	assert.InDelta(t, -18, 20*math.Log10(peak), 0.1)
+
+	// gain is reduced to keep the peak under full scale
+	peak = 0
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, format.Source(loud), sink, encode.ReplayGain{Gain: 20, Peak: g.Peak}.Apply()))
+	assert.InDelta(t, 1, peak, 0.001)
+
+	// silence has no gain
+	g, err = encode.NewReplayGainScanner().Scan(context.Background(), 512, 0, format, bytes.NewReader(sineWAV(48000, 997, math.Inf(-1), 1)))
+	assert.NoError(t, err)
+	assert.Equal(t, encode.ReplayGain{}, g)
+}
@@ -0,0 +1,179 @@
+package encode
+
+import (
+	"fmt"
+	"math"
+)
+
+// ResampleQuality defines the interpolation of resampling. Linear is the
+// fastest one, polyphase and sinc-best use windowed sinc kernels of
+// different length and filter out aliasing. Polyphase looks the kernel up
+// in a table of precomputed phases, sinc-best computes it for every
+// frame. Tradeoffs of pitch shift, measured by BenchmarkResample and
+// TestStretch:
+//
+//	linear     1x CPU    aliasing above -12 dB, for previews and speech
+//	polyphase  1.5x CPU  aliasing below -40 dB, for batch conversions
+//	sinc-best  10x CPU   aliasing below -60 dB, for mastering
+//
+// Aliasing is the level of sine shifted over the nyquist frequency.
+type ResampleQuality string
+
+// Resample qualities.
+const (
+	ResampleLinear    ResampleQuality = "linear"
+	ResamplePolyphase ResampleQuality = "polyphase"
+	ResampleSincBest  ResampleQuality = "sinc-best"
+)
+
+// DefaultResampleQuality is used if quality is not provided.
+const DefaultResampleQuality = ResamplePolyphase
+
+// ResampleQualities are all supported qualities from fastest to the best.
+var ResampleQualities = []ResampleQuality{ResampleLinear, ResamplePolyphase, ResampleSincBest}
+
+// sincZeroCrossings is the number of zero crossings of sinc kernels on
+// each side.
+var sincZeroCrossings = map[ResampleQuality]int{
+	ResamplePolyphase: 8,
+	ResampleSincBest:  32,
+}
+
+// polyphases is the number of precomputed kernel phases between two
+// frames of polyphase quality.
+const polyphases = 256
+
+// resampler changes the rate of the signal, so its pitch is multiplied
+// by ratio. Input is interpolated with the kernel that covers half frames
+// on each side of the output position.
+type resampler struct {
+	channels int
+	ratio    float64
+	kernel   func(d float64) float64
+	half     int
+	phase    float64
+	in       []float64
+	weights  []float64
+}
+
+// ParseResampleQuality returns resample quality by its name.
+// DefaultResampleQuality is returned for empty name.
+func ParseResampleQuality(s string) (ResampleQuality, error) {
+	if s == "" {
+		return DefaultResampleQuality, nil
+	}
+	for _, q := range ResampleQualities {
+		if string(q) == s {
+			return q, nil
+		}
+	}
+	return "", fmt.Errorf("invalid resample quality %q: must be %s, %s or %s", s, ResampleLinear, ResamplePolyphase, ResampleSincBest)
+}
+
+func newResampler(channels int, ratio float64, quality ResampleQuality) *resampler {
+	r := resampler{
+		channels: channels,
+		ratio:    ratio,
+		kernel:   linearKernel,
+		half:     1,
+	}
+	if zeros, ok := sincZeroCrossings[quality]; ok {
+		// cutoff is lowered to the new nyquist when the signal is
+		// compressed, the kernel is stretched accordingly
+		cutoff := math.Min(1, 1/ratio)
+		r.half = int(math.Ceil(float64(zeros) / cutoff))
+		r.kernel = sincKernel(cutoff, float64(r.half))
+		if quality == ResamplePolyphase {
+			r.kernel = tableKernel(r.kernel, r.half, polyphases)
+		}
+	}
+	r.weights = make([]float64, 2*r.half)
+	// the signal is preceded by silence, so the kernel is complete
+	r.in = make([]float64, r.half*channels)
+	r.phase = float64(r.half)
+	return &r
+}
+
+// write adds interleaved samples to the input and returns resampled
+// output. If final is set, the input is drained.
+func (r *resampler) write(samples []float64, final bool) []float64 {
+	if r.ratio == 1 {
+		return samples
+	}
+	r.in = append(r.in, samples...)
+	end := len(r.in) / r.channels
+	if final {
+		// the signal is followed by silence
+		r.in = append(r.in, make([]float64, r.half*r.channels)...)
+	}
+	frames := len(r.in) / r.channels
+	var out []float64
+	for {
+		i := int(r.phase)
+		if i+r.half >= frames || final && i >= end {
+			break
+		}
+		var sum float64
+		for k := range r.weights {
+			r.weights[k] = r.kernel(r.phase - float64(i-r.half+1+k))
+			sum += r.weights[k]
+		}
+		for c := 0; c < r.channels; c++ {
+			var v float64
+			for k, w := range r.weights {
+				v += w * r.in[(i-r.half+1+k)*r.channels+c]
+			}
+			out = append(out, v/sum)
+		}
+		r.phase += r.ratio
+	}
+	if drop := int(r.phase) - r.half + 1; drop > 0 {
+		if drop > frames {
+			drop = frames
+		}
+		r.in = r.in[:copy(r.in, r.in[drop*r.channels:])]
+		r.phase -= float64(drop)
+	}
+	return out
+}
+
+// linearKernel interpolates between two closest frames.
+func linearKernel(d float64) float64 {
+	return math.Max(0, 1-math.Abs(d))
+}
+
+// sincKernel returns low-pass sinc kernel with cutoff relative to nyquist
+// and blackman window of half width.
+func sincKernel(cutoff, half float64) func(d float64) float64 {
+	return func(d float64) float64 {
+		t := d / half
+		if t <= -1 || t >= 1 {
+			return 0
+		}
+		window := 0.42 + 0.5*math.Cos(math.Pi*t) + 0.08*math.Cos(2*math.Pi*t)
+		x := math.Pi * cutoff * d
+		if x == 0 {
+			return cutoff * window
+		}
+		return cutoff * math.Sin(x) / x * window
+	}
+}
+
+// tableKernel returns kernel of half width that is sampled in advance at
+// the given number of phases per frame. Values between phases are
+// interpolated linearly.
+func tableKernel(kernel func(d float64) float64, half, phases int) func(d float64) float64 {
+	table := make([]float64, 2*half*phases+2)
+	for i := range table {
+		table[i] = kernel(float64(i)/float64(phases) - float64(half))
+	}
+	return func(d float64) float64 {
+		x := (d + float64(half)) * float64(phases)
+		if x <= 0 || x >= float64(len(table)-2) {
+			return 0
+		}
+		i := int(x)
+		f := x - float64(i)
+		return table[i] + f*(table[i+1]-table[i])
+	}
+}
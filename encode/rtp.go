@@ -0,0 +1,117 @@
+package encode
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+const (
+	// rtpHeaderSize is the size of RTP header without extensions.
+	rtpHeaderSize = 12
+	// rtpMaxPayload is the max size of RTP payload, packets fit into
+	// ethernet frames.
+	rtpMaxPayload = 1200
+)
+
+// RTPStream sends signals of conversions as RTP packets with L16
+// payload, interleaved 16-bit big-endian PCM, see RFC 3551. Every Write
+// to the writer is a single packet, e.g. UDP datagram. Conversions
+// continue the same stream, so receivers don't need to resync between
+// files. Static payload types are 10 for 44.1 kHz stereo and 11 for
+// 44.1 kHz mono, other signals need dynamic type that is agreed with the
+// receiver out of band, e.g. 96.
+type RTPStream struct {
+	w           io.Writer
+	payloadType uint8
+	ssrc        uint32
+
+	mu        sync.Mutex
+	seq       uint16
+	timestamp uint32
+}
+
+// NewRTPStream returns the stream of payload type that is sent into w.
+// Synchronization source, first sequence number and timestamp are
+// random.
+func NewRTPStream(w io.Writer, payloadType uint8) *RTPStream {
+	return &RTPStream{
+		w:           w,
+		payloadType: payloadType & 0x7f,
+		ssrc:        rand.Uint32(),
+		seq:         uint16(rand.Uint32()),
+		timestamp:   rand.Uint32(),
+	}
+}
+
+// Sink returns the sink allocator that sends the signal into the stream.
+// Packets are sent in real time of the signal, so hardware decoders
+// don't overflow, it slows the conversion down to the speed of playback.
+// First packet of every conversion has the marker bit. Write errors are
+// logged, but don't fail the conversion.
+func (s *RTPStream) Sink() pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			start  time.Time
+			frames int
+			marker bool
+			failed bool
+		)
+		framesPerPacket := rtpMaxPayload / (2 * props.Channels)
+		return pipe.Sink{
+			StartFunc: func(context.Context) error {
+				start, frames, marker, failed = time.Now(), 0, true, false
+				return nil
+			},
+			SinkFunc: func(in signal.Floating) error {
+				for pos := 0; pos < in.Length(); pos += framesPerPacket {
+					n := framesPerPacket
+					if pos+n > in.Length() {
+						n = in.Length() - pos
+					}
+					packet := s.packet(in.Slice(pos, pos+n), marker)
+					marker = false
+					time.Sleep(time.Until(start.Add(props.SampleRate.Duration(frames))))
+					frames += n
+					if _, err := s.w.Write(packet); err != nil && !failed {
+						failed = true
+						log.Printf("Failed to send RTP packet: %v", err)
+					}
+				}
+				return nil
+			},
+		}, nil
+	}
+}
+
+// packet returns the next packet of the stream with samples of the
+// signal.
+func (s *RTPStream) packet(in signal.Floating, marker bool) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := make([]byte, rtpHeaderSize+2*in.Len())
+	b[0] = 2 << 6 // version
+	b[1] = s.payloadType
+	if marker {
+		b[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(b[2:], s.seq)
+	binary.BigEndian.PutUint32(b[4:], s.timestamp)
+	binary.BigEndian.PutUint32(b[8:], s.ssrc)
+	for i := 0; i < in.Len(); i++ {
+		v := math.Max(-1, math.Min(1, in.Sample(i)))
+		binary.BigEndian.PutUint16(b[rtpHeaderSize+2*i:], uint16(int16(math.Round(v*math.MaxInt16))))
+	}
+	s.seq++
+	s.timestamp += uint32(in.Length())
+	return b
+}
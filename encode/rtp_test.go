@@ -0,0 +1,49 @@
+package encode_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+)
+
+// packetWriter records written packets.
+type packetWriter [][]byte
+
+func (w *packetWriter) Write(b []byte) (int, error) {
+	*w = append(*w, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestRTPStream(t *testing.T) {
+	var packets packetWriter
+	stream := encode.NewRTPStream(&packets, 96)
+	samples := []float64{0.5, -0.5, 1, -2, 0.25, 0}
+	assert.NoError(t, encode.Run(context.Background(), 4, 0, rampSource(samples), stream.Sink()))
+	assert.NoError(t, encode.Run(context.Background(), 4, 0, rampSource(samples[:2]), stream.Sink()))
+	assert.Equal(t, 3, len(packets))
+
+	seq := binary.BigEndian.Uint16(packets[0][2:])
+	timestamp := binary.BigEndian.Uint32(packets[0][4:])
+	ssrc := binary.BigEndian.Uint32(packets[0][8:])
+	frames := []uint32{0, 4, 6}
+	for i, p := range packets {
+		assert.Equal(t, byte(0x80), p[0])
+		assert.Equal(t, byte(96), p[1]&0x7f)
+		assert.Equal(t, seq+uint16(i), binary.BigEndian.Uint16(p[2:]))
+		assert.Equal(t, timestamp+frames[i], binary.BigEndian.Uint32(p[4:]))
+		assert.Equal(t, ssrc, binary.BigEndian.Uint32(p[8:]))
+	}
+	// conversions start with the marker
+	assert.Equal(t, []bool{true, false, true}, []bool{packets[0][1]&0x80 != 0, packets[1][1]&0x80 != 0, packets[2][1]&0x80 != 0})
+
+	pcm := make([]int16, 4)
+	for i := range pcm {
+		pcm[i] = int16(binary.BigEndian.Uint16(packets[0][12+2*i:]))
+	}
+	assert.Equal(t, []int16{16384, -16384, 32767, -32767}, pcm)
+	assert.Equal(t, 12+2*2, len(packets[1]))
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/publicnet"
+)
+
+// JobIDHeader is the header with the id of conversion that has a
+// callback or is published to events. The same id is sent in the
+// callback and events.
+const JobIDHeader = "Phono-Job-Id"
+
+// SignatureHeader is the header of callback requests with hex-encoded
+// HMAC-SHA256 of the body, e.g. "sha256=6b86b2...".
+const SignatureHeader = "Phono-Signature"
+
+// Callback statuses.
+const (
+	CallbackDone   = "done"
+	CallbackFailed = "failed"
+)
+
+// defaultCallbackBackoff is the delay before the first retry of failed
+// callback.
+const defaultCallbackBackoff = time.Second
+
+// maxCallbackError is the max length of error message in callbacks.
+const maxCallbackError = 1024
+
+type (
+	// Callbacks send results of conversions to URLs provided by clients.
+	// Requests are signed with the secret, so receivers can verify them.
+	// Failed requests are retried up to Retries times, delay starts with
+	// Backoff and doubles every retry, default backoff is used if it's
+	// zero. Every attempt is limited by Timeout, zero means no limit.
+	// Only http and https URLs are allowed, redirects are checked with
+	// the same rule. Requests are sent with publicnet.Client if Client is
+	// nil, so callbacks can't reach internal addresses.
+	Callbacks struct {
+		Client  *http.Client
+		Timeout time.Duration
+		Retries int
+		Backoff time.Duration
+		IDs     idgen.Generator
+		secret  []byte
+		wg      sync.WaitGroup
+	}
+
+	// Callback is the URL provided by the client to receive the result
+	// of the conversion.
+	Callback struct {
+		URL       string
+		callbacks *Callbacks
+	}
+
+	// CallbackEvent is the payload of callback. Location is the URL of
+	// the result if it's stored, e.g. result link or destination. Error
+	// and Code describe failed conversion.
+	CallbackEvent struct {
+		ID       string           `json:"id"`
+		Status   string           `json:"status"`
+		Location string           `json:"location,omitempty"`
+		Error    string           `json:"error,omitempty"`
+		Code     encode.ErrorCode `json:"code,omitempty"`
+		Stats    *CallbackStats   `json:"stats,omitempty"`
+		Time     time.Time        `json:"time"`
+	}
+
+	// CallbackStats are stats of the conversion. Duration is the length
+	// of the output and Elapsed is the time of conversion in seconds.
+	// Clipped is the number of clipped regions if clipping is detected.
+	CallbackStats struct {
+		InputSize  int64   `json:"input_size"`
+		SampleRate int     `json:"sample_rate,omitempty"`
+		Channels   int     `json:"channels,omitempty"`
+		Frames     int64   `json:"frames"`
+		Duration   float64 `json:"duration"`
+		Elapsed    float64 `json:"elapsed"`
+		Clipped    *int    `json:"clipped,omitempty"`
+	}
+
+	// callbackWriter records the response of the conversion with callback.
+	// It's the hook of the conversion, so failed conversion is reported
+	// with its own error rather than the response.
+	callbackWriter struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+		err    error
+	}
+)
+
+// NewCallbacks returns callbacks signed with the secret.
+func NewCallbacks(secret []byte) *Callbacks {
+	return &Callbacks{secret: secret}
+}
+
+// Callback returns the callback of the URL. Error is returned if the URL
+// is not absolute http or https one.
+func (c *Callbacks) Callback(rawURL string) (*Callback, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback url: %w", err)
+	}
+	if err := checkCallbackURL(u); err != nil {
+		return nil, err
+	}
+	return &Callback{URL: u.String(), callbacks: c}, nil
+}
+
+// checkCallbackURL returns error if the URL is not absolute http or https
+// one.
+func checkCallbackURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url must be absolute http or https url: %s", u.Redacted())
+	}
+	return nil
+}
+
+// NewCallbackStats returns callback stats of the conversion summary.
+func NewCallbackStats(s encode.Summary) *CallbackStats {
+	return &CallbackStats{
+		InputSize:  s.InputSize,
+		SampleRate: int(s.Out.SampleRate),
+		Channels:   s.Out.Channels,
+		Frames:     s.Frames,
+		Duration:   s.Duration().Seconds(),
+		Elapsed:    s.Elapsed.Seconds(),
+	}
+}
+
+// Wait waits until all callbacks are delivered or given up.
+func (c *Callbacks) Wait() {
+	c.wg.Wait()
+}
+
+// Sign returns the signature of the body.
+func (c *Callbacks) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send delivers the event in background.
+func (c *Callback) Send(e CallbackEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode callback %s: %v", e.ID, err)
+		return
+	}
+	cb := c.callbacks
+	cb.wg.Add(1)
+	go func() {
+		defer cb.wg.Done()
+		backoff := cb.Backoff
+		if backoff == 0 {
+			backoff = defaultCallbackBackoff
+		}
+		for attempt := 0; ; attempt++ {
+			err := c.post(body)
+			if err == nil {
+				return
+			}
+			if attempt == cb.Retries {
+				log.Printf("Failed to send callback %s: %v", e.ID, err)
+				return
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}()
+}
+
+// post sends the signed body. Any 2xx status is success.
+func (c *Callback) post(body []byte) error {
+	ctx := context.Background()
+	if c.callbacks.Timeout > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, c.callbacks.Timeout)
+		defer cancelFn()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, c.callbacks.Sign(body))
+	client := publicnet.Client
+	if c.callbacks.Client != nil {
+		client = c.callbacks.Client
+	}
+	// redirects are checked with the same rules as the callback url
+	cl := *client
+	cl.CheckRedirect = publicnet.CheckRedirect(checkCallbackURL)
+	resp, err := cl.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback response: %s", resp.Status)
+	}
+	return nil
+}
+
+// event returns the event of finished conversion described by the
+// recorded response.
+func (w *callbackWriter) event(id string, formData FormData) CallbackEvent {
+	e := CallbackEvent{
+		ID:     id,
+		Status: CallbackDone,
+		Time:   time.Now().UTC(),
+	}
+	header := w.Header()
+	if w.status >= http.StatusBadRequest {
+		e.Status = CallbackFailed
+		if w.err != nil {
+			e.Code = encode.Code(w.err)
+			e.Error = truncate(w.err.Error(), maxCallbackError)
+			return e
+		}
+		e.Code = encode.ErrorCode(header.Get(encode.ErrorCodeHeader))
+		e.Error = strings.TrimSpace(w.body.String())
+		return e
+	}
+	e.Location = header.Get("Location")
+	if e.Location == "" {
+		e.Location = header.Get("Content-Location")
+	}
+	if formData.stats != nil {
+		e.Stats = NewCallbackStats(formData.stats.Summary("", "", formData.Input.Size, 0))
+		if v := header.Get(encode.ClippingHeader); v != "" {
+			if clipped, err := strconv.Atoi(v); err == nil {
+				e.Stats.Clipped = &clipped
+			}
+		}
+	}
+	return e
+}
+
+// OnStart implements Hook.
+func (w *callbackWriter) OnStart() {}
+
+// OnProgress implements Hook.
+func (w *callbackWriter) OnProgress(int64) {}
+
+// OnComplete implements Hook.
+func (w *callbackWriter) OnComplete() {}
+
+// OnError records the error of the conversion.
+func (w *callbackWriter) OnError(err error) {
+	w.err = err
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func (w *callbackWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write keeps the beginning of error responses.
+func (w *callbackWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxCallbackError {
+		rest := maxCallbackError - w.body.Len()
+		if len(p) < rest {
+			rest = len(p)
+		}
+		w.body.Write(p[:rest])
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// aborted returns the event of the conversion that was aborted after
+// the response was started.
+func aborted(id string) CallbackEvent {
+	return CallbackEvent{
+		ID:     id,
+		Status: CallbackFailed,
+		Error:  "conversion failed after the result was partially sent",
+		Time:   time.Now().UTC(),
+	}
+}
@@ -0,0 +1,108 @@
+package service_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestCallbacks(t *testing.T) {
+	events := make(chan service.CallbackEvent, 1)
+	failures := 0
+	callbacks := service.NewCallbacks([]byte("secret"))
+	callbacks.IDs = &idgen.Sequence{Prefix: "job-"}
+	callbacks.Retries = 1
+	callbacks.Backoff = time.Millisecond
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "ftp://example.com/callback", http.StatusTemporaryRedirect)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, callbacks.Sign(body), r.Header.Get(service.SignatureHeader))
+		if r.URL.Path == "/flaky" && failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e service.CallbackEvent
+		assert.NoError(t, json.Unmarshal(body, &e))
+		events <- e
+	}))
+	defer receiver.Close()
+	callbacks.Client = receiver.Client()
+
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	form.Callbacks = callbacks
+	h := service.Handler(service.Config{Form: form, Buffering: encode.Buffering{Size: 512}})
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":                    ".wav",
+		"wav-bit-depth":             "16",
+		userinput.DetectClippingKey: "true",
+		userinput.CallbackURLKey:    receiver.URL + "/flaky",
+	}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "job-1", rr.Header().Get(service.JobIDHeader))
+	e := <-events
+	assert.Equal(t, "job-1", e.ID)
+	assert.Equal(t, service.CallbackDone, e.Status)
+	assert.Equal(t, 1, failures)
+	if assert.NotNil(t, e.Stats) {
+		assert.Equal(t, 44100, e.Stats.SampleRate)
+		assert.Equal(t, 2, e.Stats.Channels)
+		assert.True(t, e.Stats.Frames > 0)
+		assert.True(t, e.Stats.Duration > 0)
+		assert.Equal(t, 0, *e.Stats.Clipped)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, notMediaUploadRequest("test/.wav", map[string]string{
+		"format":                 ".wav",
+		"wav-bit-depth":          "16",
+		userinput.ProgressIDKey:  "progress",
+		userinput.CallbackURLKey: receiver.URL,
+	}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	e = <-events
+	assert.Equal(t, "progress", e.ID)
+	assert.Equal(t, service.CallbackFailed, e.Status)
+	assert.Equal(t, encode.CodeCorruptHeader, e.Code)
+	assert.NotEmpty(t, e.Error)
+	assert.Nil(t, e.Stats)
+
+	// only absolute http urls are allowed
+	for _, u := range []string{"ftp://host/callback", "/callback"} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+			"format":                 ".wav",
+			"wav-bit-depth":          "16",
+			userinput.CallbackURLKey: u,
+		}))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, u)
+	}
+	callbacks.Wait()
+
+	// redirects to other schemes and internal addresses are not followed
+	callback, err := callbacks.Callback(receiver.URL + "/redirect")
+	assert.NoError(t, err)
+	callback.Send(service.CallbackEvent{ID: "redirect"})
+	callbacks.Wait()
+	callbacks.Client = nil
+	callback, err = callbacks.Callback(receiver.URL)
+	assert.NoError(t, err)
+	callback.Send(service.CallbackEvent{ID: "internal"})
+	callbacks.Wait()
+	assert.Empty(t, events)
+}
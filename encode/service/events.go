@@ -0,0 +1,199 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/idgen"
+)
+
+// Types of job events.
+const (
+	JobCreated   = "created"
+	JobProgress  = "progress"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+type (
+	// Events publishes events of all conversions of the http handler.
+	// Events are served as server-sent events stream on /events path, so
+	// integrations can follow jobs without polling. Subscribers receive
+	// events of jobs that have the same owner as they do. Owner of the
+	// request is returned by Owner function, e.g. the name of API key,
+	// jobs of anonymous requests are visible to anonymous subscribers. If
+	// Owner is nil, events of all jobs are sent to all subscribers.
+	// Conversions without progress or callback id get ids from IDs, the
+	// id is sent in JobIDHeader. Owner and IDs can be set before the
+	// first use.
+	Events struct {
+		Owner func(*http.Request) string
+		IDs   idgen.Generator
+
+		mu   sync.Mutex
+		subs map[chan JobEvent]string
+	}
+
+	// JobEvent is the event of the job. Frames is the number of frames
+	// written to the sink. Error and Code are set if the job failed.
+	JobEvent struct {
+		Type   string           `json:"type"`
+		ID     string           `json:"id"`
+		Time   time.Time        `json:"time"`
+		Frames int64            `json:"frames"`
+		Error  string           `json:"error,omitempty"`
+		Code   encode.ErrorCode `json:"code,omitempty"`
+	}
+
+	// eventHook publishes events of a single job.
+	eventHook struct {
+		events *Events
+		id     string
+		owner  string
+
+		mu     sync.Mutex
+		frames int64
+		last   time.Time
+	}
+)
+
+// NewEvents returns new events publisher.
+func NewEvents() *Events {
+	return &Events{
+		subs: make(map[chan JobEvent]string),
+	}
+}
+
+// ServeHTTP streams events of jobs visible to the owner of the request
+// until the client disconnects. Event type is sent as the name of event.
+func (e *Events) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	events := e.subscribe(e.owner(r))
+	defer e.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			b, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// owner returns the owner of the request.
+func (e *Events) owner(r *http.Request) string {
+	if e.Owner == nil {
+		return ""
+	}
+	return e.Owner(r)
+}
+
+func (e *Events) subscribe(owner string) chan JobEvent {
+	events := make(chan JobEvent, 64)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.subs[events] = owner
+	return events
+}
+
+func (e *Events) unsubscribe(events chan JobEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subs, events)
+}
+
+// publish sends the event of the job to subscribers of its owner. Slow
+// subscribers miss progress events, but receive others unless their
+// queue is full.
+func (e *Events) publish(owner string, event JobEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for events, o := range e.subs {
+		if o != owner {
+			continue
+		}
+		if event.Type == JobProgress && len(events) > cap(events)/2 {
+			// keep the space for events that change the state
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// hook returns the hook that publishes events of the job with id owned
+// by the owner of the request.
+func (e *Events) hook(id string, r *http.Request) encode.Hook {
+	return &eventHook{events: e, id: id, owner: e.owner(r)}
+}
+
+// OnStart publishes the created job.
+func (h *eventHook) OnStart() {
+	h.publish(JobCreated, nil)
+}
+
+// OnProgress publishes written frames, events are limited by progress
+// interval.
+func (h *eventHook) OnProgress(frames int64) {
+	h.mu.Lock()
+	h.frames += frames
+	if time.Since(h.last) < progressInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.last = time.Now()
+	h.mu.Unlock()
+	h.publish(JobProgress, nil)
+}
+
+// OnComplete publishes the completed job.
+func (h *eventHook) OnComplete() {
+	h.publish(JobCompleted, nil)
+}
+
+// OnError publishes the failed job.
+func (h *eventHook) OnError(err error) {
+	h.publish(JobFailed, err)
+}
+
+func (h *eventHook) publish(eventType string, err error) {
+	h.mu.Lock()
+	event := JobEvent{
+		Type:   eventType,
+		ID:     h.id,
+		Time:   time.Now().UTC(),
+		Frames: h.frames,
+	}
+	h.mu.Unlock()
+	if err != nil {
+		event.Error = err.Error()
+		event.Code = encode.Code(err)
+	}
+	h.events.publish(h.owner, event)
+}
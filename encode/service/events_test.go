@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestEvents(t *testing.T) {
+	events := service.NewEvents()
+	events.Owner = func(r *http.Request) string {
+		return r.Header.Get("Owner")
+	}
+	events.IDs = &idgen.Sequence{Prefix: "job-"}
+	server := httptest.NewServer(events)
+	// streams are closed before the server
+	t.Cleanup(server.Close)
+
+	subscribe := func(owner string) *bufio.Scanner {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Owner", owner)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewScanner(resp.Body)
+	}
+	// next reads events until the job is done
+	next := func(scanner *bufio.Scanner) []service.JobEvent {
+		var result []service.JobEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var e service.JobEvent
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e))
+			result = append(result, e)
+			if e.Type == service.JobCompleted || e.Type == service.JobFailed {
+				break
+			}
+		}
+		return result
+	}
+	a, b := subscribe("a"), subscribe("b")
+
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}, Events: events})
+	convert := func(owner string) string {
+		rr := httptest.NewRecorder()
+		req := wavUploadRequest(map[string]string{
+			"format":        ".wav",
+			"wav-bit-depth": "16",
+		})
+		req.Header.Set("Owner", owner)
+		h.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr.Header().Get(service.JobIDHeader)
+	}
+	assert.Equal(t, "job-1", convert("a"))
+	assert.Equal(t, "job-2", convert("b"))
+
+	received := next(a)
+	assert.Equal(t, service.JobCreated, received[0].Type)
+	last := received[len(received)-1]
+	assert.Equal(t, service.JobCompleted, last.Type)
+	assert.True(t, last.Frames > 0)
+	for _, e := range received {
+		assert.Equal(t, "job-1", e.ID)
+	}
+
+	// jobs of other owners are not visible
+	for _, e := range next(b) {
+		assert.Equal(t, "job-2", e.ID)
+	}
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/idgen"
+)
+
+// OwnerCookie is the name of cookie that identifies the owner of retained
+// files if owner is not provided by the request.
+const OwnerCookie = "phono-owner"
+
+var errFileNotFound = i18n.Errorf("error.file_not_found")
+
+// ownerID is the format of owner id stored in the cookie.
+var ownerID = regexp.MustCompile(`^[0-9a-zA-Z-]{1,64}$`)
+
+type (
+	// Files keeps encoded files for TTL, so users can download them again
+	// instead of repeating the conversion. Files are available only to
+	// their owners:
+	//	GET    /files      list of owner's files
+	//	GET    /files/id   download the file
+	//	DELETE /files/id   delete the file
+	// Owner of the request is returned by Owner function, e.g. the name of
+	// API key. If Owner is nil or returns empty string, random owner id is
+	// stored in the cookie. Clock and IDs can be set before the first use,
+	// system clock and random ids are used by default.
+	Files struct {
+		Clock clock.Clock
+		IDs   idgen.Generator
+		Owner func(*http.Request) string
+		ttl   time.Duration
+
+		mu    sync.Mutex
+		files map[string]*retainedFile
+	}
+
+	retainedFile struct {
+		fileInfo
+		path  string
+		owner string
+	}
+
+	// fileInfo describes the retained file in the list.
+	fileInfo struct {
+		ID      string    `json:"id"`
+		Name    string    `json:"name"`
+		Size    int64     `json:"size"`
+		URL     string    `json:"url"`
+		Created time.Time `json:"created"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// NewFiles creates the store that keeps files for ttl.
+func NewFiles(ttl time.Duration) *Files {
+	return &Files{
+		ttl:   ttl,
+		files: make(map[string]*retainedFile),
+	}
+}
+
+// ServeHTTP lists, sends and deletes the files of request owner. Files of
+// other owners are reported as not found.
+func (s *Files) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.expire()
+	owner := s.requestOwner(r)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/files"), "/")
+	if id == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(s.list(owner)); err != nil {
+			log.Printf("Failed to send files list: %v", err)
+		}
+		return
+	}
+
+	f, ok := s.get(id)
+	if !ok || owner == "" || f.owner != owner {
+		http.Error(w, i18n.Localize(i18n.Request(r), errFileNotFound), http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		file, err := os.Open(f.path)
+		if err != nil {
+			http.Error(w, i18n.Localize(i18n.Request(r), errFileNotFound), http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get file stats: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Disposition", "attachment; filename="+f.Name)
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(f.Name)))
+		http.ServeContent(w, r, "", stat.ModTime(), file)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.files, id)
+		s.mu.Unlock()
+		if err := os.Remove(f.path); err != nil {
+			log.Printf("Failed to delete retained file: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// keep takes the ownership of the file at path. The owner is identified
+// by the request, if it has no owner, the cookie with new owner id is
+// set in the response. It must be called before the response is written.
+func (s *Files) keep(w http.ResponseWriter, r *http.Request, filePath, name string, size int64) (fileInfo, error) {
+	s.expire()
+	owner, err := s.responseOwner(w, r)
+	if err != nil {
+		return fileInfo{}, err
+	}
+	id, err := idgen.Or(s.IDs).New()
+	if err != nil {
+		return fileInfo{}, err
+	}
+	now := s.now()
+	info := fileInfo{
+		ID:      id,
+		Name:    name,
+		Size:    size,
+		URL:     "/files/" + id,
+		Created: now,
+		Expires: now.Add(s.ttl),
+	}
+	s.mu.Lock()
+	s.files[id] = &retainedFile{
+		fileInfo: info,
+		path:     filePath,
+		owner:    owner,
+	}
+	s.mu.Unlock()
+	return info, nil
+}
+
+// list returns the files of the owner, recent first.
+func (s *Files) list(owner string) []fileInfo {
+	infos := []fileInfo{}
+	if owner == "" {
+		return infos
+	}
+	s.mu.Lock()
+	for _, f := range s.files {
+		if f.owner == owner {
+			infos = append(infos, f.fileInfo)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Created.Equal(infos[j].Created) {
+			return infos[i].ID > infos[j].ID
+		}
+		return infos[i].Created.After(infos[j].Created)
+	})
+	return infos
+}
+
+func (s *Files) get(id string) (*retainedFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	return f, ok
+}
+
+// Owns returns true if path is the retained file.
+func (s *Files) Owns(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range s.files {
+		if f.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes files which are kept longer than TTL.
+func (s *Files) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, f := range s.files {
+		if now.After(f.Expires) {
+			if err := os.Remove(f.path); err != nil {
+				log.Printf("Failed to delete retained file: %v", err)
+			}
+			delete(s.files, id)
+		}
+	}
+}
+
+// requestOwner returns the owner of request or empty string if it's
+// unknown.
+func (s *Files) requestOwner(r *http.Request) string {
+	if s.Owner != nil {
+		if owner := s.Owner(r); owner != "" {
+			return "owner:" + owner
+		}
+	}
+	if c, err := r.Cookie(OwnerCookie); err == nil && ownerID.MatchString(c.Value) {
+		return "cookie:" + c.Value
+	}
+	return ""
+}
+
+// responseOwner returns the owner of request. If it's unknown, new owner
+// id is generated and set in the cookie. Cookie lives as long as the
+// files, so it's refreshed with every new file.
+func (s *Files) responseOwner(w http.ResponseWriter, r *http.Request) (string, error) {
+	owner := s.requestOwner(r)
+	if strings.HasPrefix(owner, "owner:") {
+		return owner, nil
+	}
+	id := strings.TrimPrefix(owner, "cookie:")
+	if owner == "" {
+		var err error
+		if id, err = (idgen.Random{}).New(); err != nil {
+			return "", err
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     OwnerCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(s.ttl / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return "cookie:" + id, nil
+}
+
+func (s *Files) now() time.Time {
+	return clock.Or(s.Clock).Now()
+}
@@ -0,0 +1,102 @@
+package service_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := clock.NewManual(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	files := service.NewFiles(time.Hour)
+	files.Clock = c
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}, TempDir: dir, Files: files})
+	encodeFile := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := wavUploadRequest(map[string]string{
+			"format":        ".wav",
+			"wav-bit-depth": "16",
+		})
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		return rr
+	}
+	request := func(method, url string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, url, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		files.ServeHTTP(rr, r)
+		return rr
+	}
+	type fileInfo struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	}
+	list := func(cookie *http.Cookie) []fileInfo {
+		rr := request(http.MethodGet, "/files", cookie)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var infos []fileInfo
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&infos))
+		return infos
+	}
+
+	// first result sets the owner cookie
+	rr := encodeFile(nil)
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	owner := cookies[0]
+	assert.Equal(t, service.OwnerCookie, owner.Name)
+	first := rr.Header().Get("Content-Location")
+	assert.NotEmpty(t, first)
+	c.Add(time.Minute)
+	second := encodeFile(owner).Header().Get("Content-Location")
+
+	infos := list(owner)
+	assert.Len(t, infos, 2)
+	assert.Equal(t, second, infos[0].URL)
+	assert.Equal(t, first, infos[1].URL)
+	assert.Equal(t, "result_1.wav", infos[0].Name)
+
+	rr = request(http.MethodGet, first, owner)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotZero(t, rr.Body.Len())
+
+	// other owners don't see the files
+	stranger := &http.Cookie{Name: service.OwnerCookie, Value: "stranger"}
+	assert.Empty(t, list(stranger))
+	assert.Empty(t, list(nil))
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, first, stranger).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, first, nil).Code)
+
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, first, owner).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, first, owner).Code)
+	assert.Len(t, list(owner), 1)
+
+	// expired
+	c.Add(time.Hour + time.Second)
+	assert.Empty(t, list(owner))
+	remaining, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, remaining)
+}
@@ -0,0 +1,683 @@
+// Package service serves conversions of encode package over HTTP: the
+// encode handler, progress and job events, callbacks, results and
+// retained files.
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/tag"
+)
+
+type (
+	// Form provides user-input for http encoding. Bytes returns the
+	// form in the language.
+	Form interface {
+		Bytes(lang string) []byte
+		Parse(*http.Request) (FormData, error)
+	}
+
+	// FormData contains parsed form data. Outputs of Also are encoded in
+	// the same pass and all results are sent in a zip archive. Warnings
+	// are sent in Warning header. Normalization, stretch and clipping
+	// fields configure the passes of encode package. The result is
+	// uploaded to Destination or sent to Callback if they are not nil.
+	FormData struct {
+		Input
+		Output
+		Also              []Output
+		Processors        []pipe.ProcessorAllocatorFunc
+		Warnings          []string
+		ProgressID        string
+		Link              bool
+		Destination       *Destination
+		Callback          *Callback
+		PeakNormalize     bool
+		PeakTarget        float64
+		LoudnessNormalize bool
+		LoudnessTarget    float64
+		TruePeak          float64
+		Stretch           bool
+		Tempo             float64
+		Pitch             float64
+		ResampleQuality   encode.ResampleQuality
+		DetectClipping    bool
+		FailOnClipping    bool
+		StripTags         bool
+		Tags              tag.Tags
+		stats             *encode.Stats
+		// jobID is the id of progress or callback job.
+		jobID string
+		// hooks receive events of the conversion.
+		hooks encode.Hooks
+	}
+
+	// Input is user-provided input for encoding. Size is the number of
+	// bytes received from the user.
+	Input struct {
+		*fileformat.Format
+		multipart.File
+		Size int64
+	}
+
+	// Output is user-provided output for encoding. Stream is not nil if
+	// the sink doesn't need to seek, so result can be streamed without
+	// temp file. BitDepth is the bit depth of sink samples, the output is
+	// dithered if it's lower than the bit depth of the input. Format is
+	// nil for registered sinks unknown to fileformat package, Extension
+	// is used for their results.
+	Output struct {
+		*fileformat.Format
+		Extension string
+		Sink      func(io.WriteSeeker) pipe.SinkAllocatorFunc
+		Stream    func(io.Writer) pipe.SinkAllocatorFunc
+		BitDepth  signal.BitDepth
+	}
+
+	// Destination is the remote location of the result. Put uploads the
+	// content of the result.
+	Destination struct {
+		Location string
+		Put      func(context.Context, io.Reader) error
+	}
+
+	// Timeouts of conversion. Stall is the max time without progress,
+	// Conversion is the max duration of the whole conversion. Zero values
+	// disable timeouts.
+	Timeouts struct {
+		Stall      time.Duration
+		Conversion time.Duration
+	}
+
+	// Config of the encode handler. Form and Buffering are required, temp
+	// files are created in TempDir. If Progress is not nil, conversion
+	// progress is published with id provided in the form. If Results is
+	// not nil, user can request the link to the result instead of the
+	// file. If Files is not nil, results are not streamed and sent files
+	// are kept in the store, so the user can download them again. If
+	// Memory is not nil, results of small inputs are kept in memory
+	// instead of temp files. If Jobs is not nil, conversions with progress
+	// or callback id can be paused and resumed. If Events is not nil,
+	// events of all conversions are published.
+	Config struct {
+		Form      Form
+		Buffering encode.Buffering
+		Timeouts  Timeouts
+		TempDir   string
+		Progress  *Progress
+		Results   *Results
+		Files     *Files
+		Memory    *MemoryResults
+		Jobs      *Jobs
+		Events    *Events
+	}
+
+	handler struct {
+		form      Form
+		buffering encode.Buffering
+		timeouts  Timeouts
+		tempDir   string
+		progress  *Progress
+		results   *Results
+		files     *Files
+		memory    *MemoryResults
+		jobs      *Jobs
+		events    *Events
+	}
+
+	// streamWriter tracks if any data was sent to the client.
+	streamWriter struct {
+		http.ResponseWriter
+		written bool
+	}
+)
+
+// Handler form files to the format provided by form.
+// Process request steps:
+//	1. Retrieve userinput format from URL
+//	2. Use http.MaxBytesReader to avoid memory abuse
+//	3. Parse output configuration
+//	4. Create temp file
+//	5. Run conversion
+//	6. Send result file
+//
+// If output sink doesn't need to seek, steps 4-6 are replaced with
+// streaming of the result directly to the client.
+//
+// Range requests are served only for results sent from the temp file.
+// Streamed results, e.g. MP3, are sent whole with Accept-Ranges: none.
+// Every request runs the conversion again, so clients that need to seek
+// should request the link to the result or use retained files.
+//
+// If conversion makes no progress longer than stall timeout or runs
+// longer than conversion timeout, it's cancelled and 504 status is
+// returned.
+func Handler(c Config) http.Handler {
+	return &handler{
+		form:      c.Form,
+		buffering: c.Buffering,
+		timeouts:  c.Timeouts,
+		tempDir:   c.TempDir,
+		progress:  c.Progress,
+		results:   c.Results,
+		files:     c.Files,
+		memory:    c.Memory,
+		jobs:      c.Jobs,
+		events:    c.Events,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		lang := i18n.Request(r)
+		w.Header().Set("Content-Language", lang)
+		_, err := w.Write(h.form.Bytes(lang))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		formData, err := h.form.Parse(r)
+		if err != nil {
+			http.Error(w, i18n.Localize(i18n.Request(r), err), http.StatusBadRequest)
+			return
+		}
+		defer formData.Close()
+		formData.jobID = formData.ProgressID
+		for _, warning := range formData.Warnings {
+			w.Header().Add("Warning", fmt.Sprintf("299 phono %q", warning))
+		}
+		if formData.Callback != nil {
+			id := formData.ProgressID
+			if id == "" {
+				if id, err = idgen.Or(formData.Callback.callbacks.IDs).New(); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			w.Header().Set(JobIDHeader, id)
+			formData.jobID = id
+			formData.stats = encode.NewStats()
+			cw := &callbackWriter{ResponseWriter: w}
+			w = cw
+			formData.hooks = append(formData.hooks, cw)
+			defer func() {
+				// aborted stream is reported before the panic goes on
+				if v := recover(); v != nil {
+					formData.Callback.Send(aborted(id))
+					panic(v)
+				}
+				formData.Callback.Send(cw.event(id, formData))
+			}()
+		}
+		if h.events != nil {
+			id := formData.jobID
+			if id == "" {
+				if id, err = idgen.Or(h.events.IDs).New(); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set(JobIDHeader, id)
+			}
+			formData.hooks = append(formData.hooks, h.events.hook(id, r))
+		}
+
+		if len(formData.Also) > 0 {
+			h.encodeZip(w, r, formData)
+			return
+		}
+		if formData.Destination != nil {
+			h.encodeDestination(w, r, formData)
+			return
+		}
+		if formData.Link && h.results != nil {
+			h.encodeLink(w, r, formData)
+			return
+		}
+		if formData.Output.Stream != nil && h.files == nil {
+			h.stream(w, r, formData)
+			return
+		}
+		h.encodeTempFile(w, r, formData)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// encodeTempFile encodes the input into the temp file and sends it when
+// conversion is done. Results of small inputs are kept in memory if
+// memory results are enabled and files aren't retained.
+func (h *handler) encodeTempFile(w http.ResponseWriter, r *http.Request, formData FormData) {
+	// retained files must be on the disk
+	memory := h.memory
+	if h.files != nil {
+		memory = nil
+	}
+	result, err := memory.file(h.tempDir, formData.Input.Size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// retained file is removed by the store
+	retained := false
+	defer func() {
+		if retained {
+			result.(*tempResult).File.Close()
+			return
+		}
+		if err := result.Close(); err != nil {
+			log.Printf("Failed to remove result: %v", err)
+		}
+	}()
+
+	// encode file using temp file
+	clip := clipDetector(formData)
+	if err = h.encode(r, formData, clip, outputSink(formData, formData.Output, result, formData.Output.Sink(result)), false); err != nil {
+		conversionError(w, r, err)
+		return
+	}
+	setClippingHeader(w, clip)
+	size, err := result.Seek(0, io.SeekEnd)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get result size: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if h.files != nil {
+		name := outFileName("result", 1, formData.Output.DefaultExtension())
+		info, err := h.files.keep(w, r, result.(*tempResult).Name(), name, size)
+		if err != nil {
+			log.Printf("Failed to retain result: %v", err)
+		} else {
+			retained = true
+			w.Header().Set("Content-Location", info.URL)
+		}
+	}
+	// send file to a client, range requests are handled, so players can
+	// seek and interrupted downloads can be resumed
+	setOutputHeaders(w, formData.Output)
+	http.ServeContent(w, r, "", time.Now(), result)
+}
+
+// encodeLink encodes the input into the temp file and moves it to the
+// results store. Link to the result is sent to the client.
+func (h *handler) encodeLink(w http.ResponseWriter, r *http.Request, formData FormData) {
+	tempFile, err := ioutil.TempFile(h.tempDir, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	clip := clipDetector(formData)
+	if err = h.encode(r, formData, clip, outputSink(formData, formData.Output, tempFile, formData.Output.Sink(tempFile)), false); err != nil {
+		cleanUp(tempFile)
+		conversionError(w, r, err)
+		return
+	}
+	link, err := h.results.store(tempFile, outFileName("result", 1, formData.Output.DefaultExtension()))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to store result: %v", err), http.StatusInternalServerError)
+		return
+	}
+	response := struct {
+		resultLink
+		Clipping *encode.Clipping `json:"clipping,omitempty"`
+	}{resultLink: link}
+	if clip != nil {
+		clipping := clip.Clipping()
+		response.Clipping = &clipping
+	}
+	setClippingHeader(w, clip)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", link.URL)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send result link: %v", err)
+	}
+}
+
+// encodeDestination encodes the input and uploads it to the destination.
+// Outputs that don't need to seek are uploaded while they are encoded,
+// others are encoded into the result file first. Location of the result is
+// sent to the client.
+func (h *handler) encodeDestination(w http.ResponseWriter, r *http.Request, formData FormData) {
+	clip := clipDetector(formData)
+	var err error
+	if formData.Output.Stream != nil {
+		err = formData.Destination.Stream(r.Context(), func(out io.Writer) error {
+			return h.encode(r, formData, clip, outputSink(formData, formData.Output, out, formData.Output.Stream(out)), true)
+		})
+	} else {
+		var result resultFile
+		if result, err = h.memory.file(h.tempDir, formData.Input.Size); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer result.Close()
+		err = h.encodeUpload(r, formData, clip, result)
+	}
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		http.Error(w, fmt.Sprintf("Failed to upload result: %v", uploadErr.Err), http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		conversionError(w, r, err)
+		return
+	}
+	response := struct {
+		Location string           `json:"location"`
+		Clipping *encode.Clipping `json:"clipping,omitempty"`
+	}{Location: formData.Destination.Location}
+	if clip != nil {
+		clipping := clip.Clipping()
+		response.Clipping = &clipping
+	}
+	setClippingHeader(w, clip)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", formData.Destination.Location)
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to send result location: %v", err)
+	}
+}
+
+// encodeUpload encodes the input into the result file and uploads it to
+// the destination.
+func (h *handler) encodeUpload(r *http.Request, formData FormData, clip *encode.ClipDetector, result resultFile) error {
+	if err := h.encode(r, formData, clip, outputSink(formData, formData.Output, result, formData.Output.Sink(result)), false); err != nil {
+		return err
+	}
+	if _, err := result.Seek(0, io.SeekStart); err != nil {
+		return &UploadError{Err: err}
+	}
+	if err := formData.Destination.Put(r.Context(), result); err != nil {
+		return &UploadError{Err: err}
+	}
+	return nil
+}
+
+// encodeZip encodes the input into all outputs of the form in a single
+// pass. Results are written into temp files and sent in a zip archive
+// when conversion is done.
+func (h *handler) encodeZip(w http.ResponseWriter, r *http.Request, formData FormData) {
+	outputs := append([]Output{formData.Output}, formData.Also...)
+	files := make([]*os.File, 0, len(outputs))
+	defer func() {
+		for _, f := range files {
+			cleanUp(f)
+		}
+	}()
+	sinks := make([]pipe.SinkAllocatorFunc, 0, len(outputs))
+	for _, output := range outputs {
+		f, err := ioutil.TempFile(h.tempDir, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		files = append(files, f)
+		sinks = append(sinks, outputSink(formData, output, f, output.Sink(f)))
+	}
+	clip := clipDetector(formData)
+	if err := h.encode(r, formData, clip, encode.Tee(sinks...), false); err != nil {
+		conversionError(w, r, err)
+		return
+	}
+	setClippingHeader(w, clip)
+	w.Header().Set("Content-Disposition", "attachment; filename="+outFileName("result", 1, ".zip"))
+	w.Header().Set("Content-Type", "application/zip")
+	zw := zip.NewWriter(w)
+	for i, f := range files {
+		if err := zipFile(zw, outFileName("result", 1, outputs[i].DefaultExtension()), f); err != nil {
+			// the archive is partially sent, so the connection is aborted
+			log.Printf("Failed to send results: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Failed to send results: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// zipFile writes the content of file into the archive entry of name.
+func zipFile(zw *zip.Writer, name string, f *os.File) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, f)
+	return err
+}
+
+// stream encodes the input directly into response. Since the length of
+// result is not known, chunked transfer encoding is used. If conversion
+// fails after the first byte is sent, the connection is aborted, so the
+// client doesn't treat truncated result as complete. Clipping is reported
+// in the trailer, since it's known only when the result is sent.
+func (h *handler) stream(w http.ResponseWriter, r *http.Request, formData FormData) {
+	setOutputHeaders(w, formData.Output)
+	w.Header().Set("Accept-Ranges", "none")
+	clip := clipDetector(formData)
+	if clip != nil {
+		w.Header().Set("Trailer", encode.ClippingHeader)
+	}
+	sw := streamWriter{ResponseWriter: w}
+	err := h.encode(r, formData, clip, outputSink(formData, formData.Output, &sw, formData.Output.Stream(&sw)), true)
+	if err == nil {
+		setClippingHeader(w, clip)
+		return
+	}
+	if !sw.written {
+		w.Header().Del("Content-Disposition")
+		conversionError(w, r, err)
+		return
+	}
+	log.Printf("Failed to stream result: %s: %v", encode.Code(err), err)
+	panic(http.ErrAbortHandler)
+}
+
+// encode runs the conversion from form input to provided sink. If clip
+// is not nil, it detects clipping of the output. Streaming is true if the
+// sink sends the output while it's encoded.
+func (h *handler) encode(r *http.Request, formData FormData, clip *encode.ClipDetector, sink pipe.SinkAllocatorFunc, streaming bool) error {
+	bufferSize := h.buffering.InputBufferSize(formData.Input.Format, formData.Output.Format, formData.File, streaming)
+	var input io.ReadSeeker = formData.File
+	if clip != nil {
+		sink = clip.Sink(sink)
+	}
+	if formData.stats != nil {
+		sink = formData.stats.Sink(sink)
+	}
+	if formData.Stretch {
+		sink = encode.Stretch(sink, formData.Tempo, formData.Pitch, formData.ResampleQuality)
+	}
+	ctx := r.Context()
+	hooks := encode.Hooks{&conversionMetrics{formData: formData, traceID: traceID(r)}}
+	if h.progress != nil && formData.ProgressID != "" {
+		job := h.progress.job(formData.ProgressID, formData.Input.Size)
+		input = job.reader(input)
+		hooks = append(hooks, job)
+	}
+	if h.jobs != nil && formData.jobID != "" {
+		pause := encode.NewPause()
+		ctx = encode.WithPause(ctx, pause)
+		hooks = append(hooks, h.jobs.hook(formData.jobID, pause))
+	}
+	hooks = append(hooks, formData.hooks...)
+	sink = encode.HookSink(sink, hooks)
+	if h.timeouts.Conversion > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, h.timeouts.Conversion)
+		defer cancelFn()
+	}
+	hooks.OnStart()
+	var passes []encode.Pass
+	if formData.PeakNormalize {
+		passes = append(passes, encode.PeakNormalizePass(formData.PeakTarget))
+	}
+	if formData.LoudnessNormalize {
+		passes = append(passes, encode.LoudnessNormalizePass(formData.LoudnessTarget, formData.TruePeak, &encode.Loudness{}))
+	}
+	processors := formData.Processors
+	var err error
+	if len(passes) > 0 {
+		var analyzed []pipe.ProcessorAllocatorFunc
+		if analyzed, err = encode.AnalyzeInput(ctx, bufferSize, h.timeouts.Stall, formData.Input.Format, formData.File, passes, processors...); err == nil {
+			processors = append(processors[:len(processors):len(processors)], analyzed...)
+		}
+	}
+	if err == nil {
+		source := formData.Input.Source(input)
+		if formData.stats != nil {
+			source = formData.stats.Source(source)
+		}
+		err = encode.Run(ctx, bufferSize, h.timeouts.Stall, source, sink, processors...)
+	}
+	encode.Done(hooks, err)
+	return err
+}
+
+// statusClientClosedRequest is sent if the client closed the connection
+// before the conversion was done. Nobody receives it, but it's logged by
+// middleware and proxies.
+const statusClientClosedRequest = 499
+
+// conversionError sends conversion error with its code. The message is
+// translated into the language of the request, the code is not.
+func conversionError(w http.ResponseWriter, r *http.Request, err error) {
+	code := encode.Code(err)
+	w.Header().Set(encode.ErrorCodeHeader, string(code))
+	http.Error(w, fmt.Sprintf("%s: %s", code, i18n.Localize(i18n.Request(r), err)), errorStatus(err))
+}
+
+// errorStatus returns http status for conversion error.
+func errorStatus(err error) int {
+	if errors.Is(err, encode.ErrStalled) || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if encode.Code(err) == encode.CodeClipped {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, context.Canceled) {
+		return statusClientClosedRequest
+	}
+	return http.StatusBadRequest
+}
+
+// clipDetector returns the clipping detector if it's requested in the
+// form.
+func clipDetector(formData FormData) *encode.ClipDetector {
+	if !formData.DetectClipping && !formData.FailOnClipping {
+		return nil
+	}
+	return encode.NewClipDetector(encode.DefaultClipRun, formData.FailOnClipping)
+}
+
+// outputSink wraps the sink of output to write tags into w. The signal
+// is dithered if the bit depth of output is lower than the input one.
+func outputSink(formData FormData, output Output, w io.Writer, sink pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	sink = tagged(formData, output, w, sink)
+	// invalid header is reported by the conversion
+	if source, err := encode.SourceBitDepth(formData.Input.Format, formData.File); err == nil && encode.DitherAuto.Enabled(source, output.BitDepth) {
+		sink = encode.DitherSink(sink, output.BitDepth, false)
+	}
+	return sink
+}
+
+// tagged wraps the sink to write tags of the input replaced by tags of
+// the form into w. Input tags aren't written if they are stripped or
+// can't be read.
+func tagged(formData FormData, output Output, w io.Writer, sink pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	var t tag.Tags
+	if !formData.StripTags {
+		var err error
+		if t, err = tag.Read(formData.Input.Format, formData.File); err != nil {
+			log.Printf("Failed to read tags: %v", err)
+		}
+	}
+	t = tag.Edit{Set: formData.Tags}.Apply(t, "")
+	return tag.Sink(sink, output.Format, w, t)
+}
+
+// setClippingHeader sets the number of clipped regions if clipping is
+// detected.
+func setClippingHeader(w http.ResponseWriter, clip *encode.ClipDetector) {
+	if clip != nil {
+		w.Header().Set(encode.ClippingHeader, strconv.Itoa(len(clip.Clipping().Regions)))
+	}
+}
+
+// DefaultExtension returns the extension of the output result.
+func (o Output) DefaultExtension() string {
+	if o.Extension != "" {
+		return o.Extension
+	}
+	return o.Format.DefaultExtension()
+}
+
+func setOutputHeaders(w http.ResponseWriter, output Output) {
+	w.Header().Set("Content-Disposition", "attachment; filename="+outFileName("result", 1, output.DefaultExtension()))
+	w.Header().Set("Content-Type", mime.TypeByExtension(output.DefaultExtension()))
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.written = true
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// observeConversion records metrics of finished conversion. If trace id is
+// not empty, it's attached to the duration as exemplar.
+func observeConversion(formData FormData, d time.Duration, err error, traceID string) {
+	result := resultOK
+	if err != nil {
+		result = string(encode.Code(err))
+	}
+	input, output := formData.Input.DefaultExtension(), formData.Output.DefaultExtension()
+	conversionsTotal.Inc(input, output, result)
+	var exemplar map[string]string
+	if traceID != "" {
+		exemplar = map[string]string{"trace_id": traceID}
+	}
+	conversionDuration.ObserveWithExemplar(d.Seconds(), exemplar, output)
+}
+
+// outFileName return output file name. It replaces userinput format extension with output.
+func outFileName(prefix string, idx int, ext string) string {
+	return fmt.Sprintf("%v_%d%v", prefix, idx, ext)
+}
+
+// cleanUp removes temporary file and handles all errors on the way.
+func cleanUp(f *os.File) {
+	err := f.Close()
+	if err != nil {
+		log.Printf("Failed to close temp file")
+	}
+	err = os.Remove(f.Name())
+	if err != nil {
+		log.Printf("Failed to delete temp file")
+	}
+}
@@ -0,0 +1,370 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/storage"
+	"pipelined.dev/phono/tag"
+	"pipelined.dev/phono/userinput"
+)
+
+func parseURL(raw string) (result *url.URL) {
+	result, _ = url.Parse(raw)
+	return
+}
+
+// Creates a new file upload http request with optional extra params. Any error causes panic.
+func fileUploadRequest(uri string, params map[string]string, filePath string) *http.Request {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile(userinput.FormFileKey, filepath.Base(filePath))
+	if err != nil {
+		panic(err)
+	}
+	_, err = io.Copy(part, file)
+
+	for key, val := range params {
+		_ = writer.WriteField(key, val)
+	}
+	err = writer.Close()
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", uri, body)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func wavUploadRequest(params map[string]string) *http.Request {
+	return fileUploadRequest("test/.wav", params, "../../_testdata/sample.wav")
+}
+
+func notMediaUploadRequest(uri string, params map[string]string) *http.Request {
+	return fileUploadRequest(uri, params, "../../_testdata/not-media")
+}
+
+func TestHandler(t *testing.T) {
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	buffering := encode.Buffering{Size: 512}
+	testHandler := func(l service.Form, r *http.Request, expectedStatus int) func(t *testing.T) {
+		return func(t *testing.T) {
+			t.Helper()
+			h := service.Handler(service.Config{Form: l, Buffering: buffering})
+			assert.NotNil(t, h)
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+			assert.Equal(t, expectedStatus, rr.Code)
+		}
+	}
+	t.Run("not allowed method",
+		testHandler(f,
+			&http.Request{
+				Method: http.MethodPut,
+				URL:    parseURL("test/.wav"),
+			},
+			http.StatusMethodNotAllowed),
+	)
+	t.Run("not allowed userinput format",
+		testHandler(f,
+			&http.Request{
+				Method: http.MethodPost,
+				URL:    parseURL("test/.test"),
+			},
+			http.StatusBadRequest),
+	)
+	t.Run("wav empty body",
+		testHandler(f,
+			&http.Request{
+				Method: http.MethodPost,
+				URL:    parseURL("test/.wav"),
+			},
+			http.StatusBadRequest),
+	)
+	t.Run("wav missing bit depth",
+		testHandler(f,
+			wavUploadRequest(nil),
+			http.StatusBadRequest),
+	)
+	t.Run("wav ok",
+		testHandler(f,
+			wavUploadRequest(map[string]string{
+				"format":        ".wav",
+				"wav-bit-depth": "16",
+			}),
+			http.StatusOK),
+	)
+}
+
+func TestHandlerLanguage(t *testing.T) {
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	h := service.Handler(service.Config{Form: f, Buffering: encode.Buffering{Size: 512}})
+	request := func(method, path, lang string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Accept-Language", lang)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	rr := request(http.MethodGet, "/", "de-DE,de;q=0.9")
+	assert.Equal(t, "de", rr.Header().Get("Content-Language"))
+	assert.Contains(t, rr.Body.String(), "Dateien auswählen")
+	rr = request(http.MethodGet, "/", "ja")
+	assert.Equal(t, "en", rr.Header().Get("Content-Language"))
+	assert.Contains(t, rr.Body.String(), "select files")
+
+	rr = request(http.MethodPost, "/.test", "ru")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "неподдерживаемый входной формат\n", rr.Body.String())
+	rr = request(http.MethodPost, "/.test", "")
+	assert.Equal(t, "unsupported input format\n", rr.Body.String())
+}
+
+func TestHandlerStream(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":            ".mp3",
+		"mp3-channel-mode":  "1",
+		"mp3-bit-rate-mode": "CBR",
+		"mp3-bit-rate":      "320",
+	}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "attachment; filename=result_1.mp3", rr.Header().Get("Content-Disposition"))
+	// streamed result has no length
+	assert.Empty(t, rr.Header().Get("Content-Length"))
+	assert.Equal(t, "none", rr.Header().Get("Accept-Ranges"))
+}
+
+func TestHandlerRange(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	r := wavUploadRequest(map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+	})
+	r.Header.Set("Range", "bytes=0-99")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+	assert.Equal(t, 100, rr.Body.Len())
+}
+
+func TestHandlerZip(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	sample, err := ioutil.ReadFile("../../_testdata/sample.wav")
+	assert.NoError(t, err)
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+	part.Write(sample)
+	for _, field := range [][2]string{
+		{userinput.FormatKey, ".wav"},
+		{userinput.FormatKey, ".mp3"},
+		{"wav-bit-depth", "16"},
+		{"mp3-channel-mode", "1"},
+		{"mp3-bit-rate-mode", "CBR"},
+		{"mp3-bit-rate", "320"},
+	} {
+		writer.WriteField(field[0], field[1])
+	}
+	writer.Close()
+	r := httptest.NewRequest(http.MethodPost, "/test/.wav", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=result_1.zip", rr.Header().Get("Content-Disposition"))
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	assert.NoError(t, err)
+	names := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"result_1.wav", "result_1.mp3"}, names)
+	wav, err := zr.File[0].Open()
+	assert.NoError(t, err)
+	header := make([]byte, 4)
+	_, err = io.ReadFull(wav, header)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("RIFF"), header)
+}
+
+func TestHandlerCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handler-canceled")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, dir, nil, nil, false), Buffering: encode.Buffering{Size: 512}, TempDir: dir})
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{"format": ".wav", "wav-bit-depth": "16"}).WithContext(ctx))
+	assert.Equal(t, 499, rr.Code)
+	assert.Equal(t, string(encode.CodeCanceled), rr.Header().Get(encode.ErrorCodeHeader))
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestHandlerTags(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	sampleTags := tag.Tags{tag.Artist: "freewavesamples.com", tag.Date: "2017"}
+	convert := func(params map[string]string) tag.Tags {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, wavUploadRequest(params))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		format := fileformat.WAV()
+		if params["format"] == ".mp3" {
+			format = fileformat.MP3()
+		}
+		tags, err := tag.Read(format, bytes.NewReader(rr.Body.Bytes()))
+		assert.NoError(t, err)
+		return tags
+	}
+
+	tags := convert(map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+	})
+	assert.Equal(t, sampleTags, tags)
+	tags = convert(map[string]string{
+		"format":               ".wav",
+		"wav-bit-depth":        "16",
+		userinput.StripTagsKey: "true",
+	})
+	assert.Empty(t, tags)
+	tags = convert(map[string]string{
+		"format":            ".wav",
+		"wav-bit-depth":     "16",
+		userinput.TitleKey:  "Stinkfist",
+		userinput.ArtistKey: "Tool",
+	})
+	assert.Equal(t, tag.Tags{tag.Title: "Stinkfist", tag.Artist: "Tool", tag.Date: "2017"}, tags)
+	// streamed mp3 starts with ID3 tag
+	tags = convert(map[string]string{
+		"format":            ".mp3",
+		"mp3-channel-mode":  "1",
+		"mp3-bit-rate-mode": "CBR",
+		"mp3-bit-rate":      "320",
+	})
+	assert.Equal(t, sampleTags, tags)
+}
+
+// memoryBackend keeps uploaded files in memory.
+type memoryBackend map[string][]byte
+
+func (m memoryBackend) Get(context.Context, *url.URL) (io.ReadCloser, int64, error) {
+	return nil, 0, errors.New("write-only")
+}
+
+func (m memoryBackend) Put(_ context.Context, u *url.URL, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	m[u.String()] = b
+	return err
+}
+
+func TestHandlerDestination(t *testing.T) {
+	uploaded := memoryBackend{}
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	form.Destinations = &userinput.Destinations{
+		Storage:  storage.Storage{"s3": uploaded},
+		Prefixes: []string{"s3://bucket/results/"},
+	}
+	h := service.Handler(service.Config{Form: form, Buffering: encode.Buffering{Size: 512}})
+	encode := func(destination string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+			"format":                 ".wav",
+			"wav-bit-depth":          "16",
+			userinput.DestinationKey: destination,
+		}))
+		return rr
+	}
+
+	rr := encode("s3://bucket/results/out.wav")
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "s3://bucket/results/out.wav", rr.Header().Get("Location"))
+	assert.JSONEq(t, `{"location":"s3://bucket/results/out.wav"}`, rr.Body.String())
+	assert.Equal(t, []byte("RIFF"), uploaded["s3://bucket/results/out.wav"][:4])
+
+	// mp3 is uploaded while it's encoded
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":                 ".mp3",
+		"mp3-channel-mode":       "1",
+		"mp3-bit-rate-mode":      "CBR",
+		"mp3-bit-rate":           "320",
+		userinput.DestinationKey: "s3://bucket/results/out.mp3",
+	}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NotEmpty(t, uploaded["s3://bucket/results/out.mp3"])
+	delete(uploaded, "s3://bucket/results/out.mp3")
+
+	for _, destination := range []string{
+		"s3://bucket/other/out.wav",
+		"s3://bucket/results/",
+		"s3://bucket/results/../other/out.wav",
+		"s3://bucket/results/%2e%2e/other/out.wav",
+		"s3://bucket/results/out%0D%0A.wav",
+		"s3://bucket/other/out.wav#/results/",
+		"gs://bucket/results/out.wav",
+		"/tmp/out.wav",
+	} {
+		rr = encode(destination)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, destination)
+	}
+	assert.Len(t, uploaded, 1)
+}
+
+func TestHandlerErrorCode(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, notMediaUploadRequest("test/.wav", map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+	}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, string(encode.CodeCorruptHeader), rr.Header().Get(encode.ErrorCodeHeader))
+}
+
+func TestHandlerClipping(t *testing.T) {
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":                    ".wav",
+		"wav-bit-depth":             "16",
+		userinput.DetectClippingKey: "true",
+	}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get(encode.ClippingHeader))
+}
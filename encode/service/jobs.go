@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"pipelined.dev/phono/encode"
+)
+
+type (
+	// Jobs controls running conversions of the http handler by their
+	// ids. Jobs are served on the following paths:
+	//
+	//	GET /jobs/{id} - state of the job
+	//	POST /jobs/{id}/pause - pause the job
+	//	POST /jobs/{id}/resume - resume the job
+	Jobs struct {
+		mu     sync.Mutex
+		pauses map[string]*encode.Pause
+	}
+
+	// JobState is the state of running conversion.
+	JobState struct {
+		ID     string `json:"id"`
+		Paused bool   `json:"paused"`
+	}
+
+	// jobHook registers running conversion in jobs.
+	jobHook struct {
+		jobs  *Jobs
+		id    string
+		pause *encode.Pause
+	}
+)
+
+// NewJobs returns empty jobs.
+func NewJobs() *Jobs {
+	return &Jobs{
+		pauses: make(map[string]*encode.Pause),
+	}
+}
+
+// hook returns the hook that registers the job with the pause while its
+// conversion is running.
+func (j *Jobs) hook(id string, p *encode.Pause) encode.Hook {
+	return &jobHook{jobs: j, id: id, pause: p}
+}
+
+// OnStart registers the job.
+func (h *jobHook) OnStart() {
+	h.jobs.mu.Lock()
+	h.jobs.pauses[h.id] = h.pause
+	h.jobs.mu.Unlock()
+}
+
+// OnProgress implements Hook.
+func (h *jobHook) OnProgress(int64) {}
+
+// OnComplete removes the job.
+func (h *jobHook) OnComplete() {
+	h.remove()
+}
+
+// OnError removes the job.
+func (h *jobHook) OnError(error) {
+	h.remove()
+}
+
+func (h *jobHook) remove() {
+	h.jobs.mu.Lock()
+	if h.jobs.pauses[h.id] == h.pause {
+		delete(h.jobs.pauses, h.id)
+	}
+	h.jobs.mu.Unlock()
+}
+
+// Get returns the pause of running job.
+func (j *Jobs) Get(id string) (*encode.Pause, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p, ok := j.pauses[id]
+	return p, ok
+}
+
+// ServeHTTP serves the state of running jobs and pauses or resumes them.
+func (j *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	id, action := parts[0], ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+	if id == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case (action == "pause" || action == "resume") && r.Method == http.MethodPost:
+	case action == "" || action == "pause" || action == "resume":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	p, ok := j.Get(id)
+	if !ok {
+		http.Error(w, "job is not running", http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "pause":
+		p.Pause()
+	case "resume":
+		p.Resume()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(JobState{ID: id, Paused: p.Paused()})
+}
@@ -0,0 +1,29 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode/service"
+)
+
+func TestJobs(t *testing.T) {
+	jobs := service.NewJobs()
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/jobs/unknown", http.StatusNotFound},
+		{http.MethodPost, "/jobs/unknown/pause", http.StatusNotFound},
+		{http.MethodGet, "/jobs/unknown/pause", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/jobs/unknown/stop", http.StatusNotFound},
+		{http.MethodGet, "/jobs/", http.StatusNotFound},
+	} {
+		rr := httptest.NewRecorder()
+		jobs.ServeHTTP(rr, httptest.NewRequest(test.method, test.path, nil))
+		assert.Equal(t, test.status, rr.Code, test.path)
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// minMemoryBuffer is the initial capacity of result buffers.
+const minMemoryBuffer = 64 << 10
+
+var errNegativeOffset = errors.New("negative offset")
+
+type (
+	// MemoryResults keeps results of small conversions in memory, so they
+	// don't touch the disk. Results of inputs up to Threshold bytes are
+	// encoded into memory while buffers of all results take up to Budget
+	// bytes. Result that doesn't fit the budget is moved into the temp
+	// file. Buffers are reused by following conversions.
+	MemoryResults struct {
+		Threshold int64
+		Budget    int64
+
+		mu   sync.Mutex
+		used int64
+		pool sync.Pool
+	}
+
+	// resultFile is the seekable output of conversion.
+	resultFile interface {
+		io.ReadWriteSeeker
+		io.Closer
+	}
+
+	// memoryFile keeps the result in the buffer until it exceeds the
+	// budget, then the content is moved into the temp file.
+	memoryFile struct {
+		results *MemoryResults
+		tempDir string
+		buf     []byte
+		pos     int64
+		file    *os.File
+	}
+)
+
+// NewMemoryResults returns in-memory results of inputs up to threshold
+// bytes limited with the budget.
+func NewMemoryResults(threshold, budget int64) *MemoryResults {
+	return &MemoryResults{
+		Threshold: threshold,
+		Budget:    budget,
+	}
+}
+
+// Used returns the number of bytes taken by buffers of results.
+func (m *MemoryResults) Used() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.used
+}
+
+// file returns the in-memory result file if the input size is known and
+// doesn't exceed the threshold. Temp file is returned otherwise.
+func (m *MemoryResults) file(tempDir string, inputSize int64) (resultFile, error) {
+	if m == nil || inputSize <= 0 || inputSize > m.Threshold {
+		return newTempResult(tempDir)
+	}
+	f := &memoryFile{results: m, tempDir: tempDir}
+	if b, ok := m.pool.Get().(*[]byte); ok {
+		if m.reserve(int64(cap(*b))) {
+			f.buf = (*b)[:0]
+		}
+	}
+	return f, nil
+}
+
+// reserve takes n bytes of the budget if they are available.
+func (m *MemoryResults) reserve(n int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.used+n > m.Budget {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+// release returns the size of the buffer to the budget and the buffer to
+// the pool.
+func (m *MemoryResults) release(b []byte) {
+	m.mu.Lock()
+	m.used -= int64(cap(b))
+	m.mu.Unlock()
+	m.put(b)
+}
+
+// put returns the buffer to the pool. Pooled buffers aren't counted in the
+// budget, since they can be collected any time.
+func (m *MemoryResults) put(b []byte) {
+	if cap(b) == 0 {
+		return
+	}
+	b = b[:0]
+	m.pool.Put(&b)
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	if f.file != nil {
+		return f.file.Write(p)
+	}
+	end := f.pos + int64(len(p))
+	if end > int64(cap(f.buf)) {
+		if err := f.grow(end); err != nil {
+			return 0, err
+		}
+		if f.file != nil {
+			return f.file.Write(p)
+		}
+	}
+	if end > int64(len(f.buf)) {
+		n := len(f.buf)
+		f.buf = f.buf[:end]
+		// pooled buffers keep content of previous results
+		for i := n; i < int(f.pos); i++ {
+			f.buf[i] = 0
+		}
+	}
+	copy(f.buf[f.pos:], p)
+	f.pos = end
+	return len(p), nil
+}
+
+// grow extends the buffer to fit size bytes. If the budget is exceeded,
+// the content is moved into the temp file.
+func (f *memoryFile) grow(size int64) error {
+	newCap := 2 * int64(cap(f.buf))
+	if newCap < minMemoryBuffer {
+		newCap = minMemoryBuffer
+	}
+	if newCap < size {
+		newCap = size
+	}
+	if !f.results.reserve(newCap - int64(cap(f.buf))) {
+		return f.spill()
+	}
+	buf := make([]byte, len(f.buf), newCap)
+	copy(buf, f.buf)
+	f.results.put(f.buf)
+	f.buf = buf
+	return nil
+}
+
+// spill moves the content into the temp file and releases the buffer.
+func (f *memoryFile) spill() error {
+	file, err := ioutil.TempFile(f.tempDir, "")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(f.buf); err == nil {
+		_, err = file.Seek(f.pos, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	f.results.release(f.buf)
+	f.buf = nil
+	f.file = file
+	return nil
+}
+
+func (f *memoryFile) Read(p []byte) (int, error) {
+	if f.file != nil {
+		return f.file.Read(p)
+	}
+	if f.pos >= int64(len(f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset of the next read or write. Offset beyond the end
+// extends the result with zeros on the next write.
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	if f.file != nil {
+		return f.file.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.buf))
+	}
+	if offset < 0 {
+		return 0, errNegativeOffset
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close releases the buffer or removes the temp file.
+func (f *memoryFile) Close() error {
+	if f.file != nil {
+		return (&tempResult{File: f.file}).Close()
+	}
+	f.results.release(f.buf)
+	f.buf = nil
+	return nil
+}
+
+// tempResult is the result file on the disk.
+type tempResult struct {
+	*os.File
+}
+
+func newTempResult(tempDir string) (resultFile, error) {
+	f, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		return nil, err
+	}
+	return &tempResult{File: f}, nil
+}
+
+// Close closes and removes the temp file.
+func (f *tempResult) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestMemoryResults(t *testing.T) {
+	convert := func(memory *service.MemoryResults, header http.Header, status int) *httptest.ResponseRecorder {
+		h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}, Memory: memory})
+		r := wavUploadRequest(map[string]string{
+			"format":        ".wav",
+			"wav-bit-depth": "16",
+		})
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		assert.Equal(t, status, rr.Code)
+		return rr
+	}
+	expected := convert(nil, nil, http.StatusOK).Body.Bytes()
+
+	tests := []struct {
+		name   string
+		memory *service.MemoryResults
+	}{
+		{name: "memory", memory: service.NewMemoryResults(1<<30, 1<<30)},
+		{name: "budget exceeded", memory: service.NewMemoryResults(1<<30, 128<<10)},
+		{name: "threshold exceeded", memory: service.NewMemoryResults(1, 1<<30)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// buffers are reused by the second conversion
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, expected, convert(test.memory, nil, http.StatusOK).Body.Bytes())
+				assert.Equal(t, int64(0), test.memory.Used())
+			}
+		})
+	}
+
+	rr := convert(service.NewMemoryResults(1<<30, 1<<30), http.Header{"Range": {"bytes=0-3"}}, http.StatusPartialContent)
+	assert.Equal(t, "RIFF", rr.Body.String())
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"pipelined.dev/phono/metrics"
+)
+
+// resultOK is the result label of successful conversion. Error code is
+// used as the label of failed conversion.
+const resultOK = "ok"
+
+var (
+	conversionsTotal = metrics.NewCounter(
+		"phono_conversions_total",
+		"Number of finished conversions.",
+		"input", "output", "result",
+	)
+	conversionsInFlight = metrics.NewGauge(
+		"phono_conversions_in_flight",
+		"Number of running conversions.",
+	)
+	conversionDuration = metrics.NewHistogram(
+		"phono_conversion_duration_seconds",
+		"Duration of conversions in seconds.",
+		metrics.DefaultBuckets,
+		"output",
+	)
+)
+
+// conversionMetrics is the hook that counts running conversions and
+// observes finished ones.
+type conversionMetrics struct {
+	formData FormData
+	traceID  string
+	start    time.Time
+}
+
+// OnStart counts running conversion.
+func (m *conversionMetrics) OnStart() {
+	conversionsInFlight.Inc()
+	m.start = time.Now()
+}
+
+// OnProgress implements Hook.
+func (m *conversionMetrics) OnProgress(int64) {}
+
+// OnComplete observes successful conversion.
+func (m *conversionMetrics) OnComplete() {
+	m.done(nil)
+}
+
+// OnError observes failed conversion.
+func (m *conversionMetrics) OnError(err error) {
+	m.done(err)
+}
+
+func (m *conversionMetrics) done(err error) {
+	conversionsInFlight.Dec()
+	observeConversion(m.formData, time.Since(m.start), err, m.traceID)
+}
+
+// traceID returns the id of sampled trace from W3C traceparent header. It
+// links conversion duration to the trace with exemplar. Empty string is
+// returned if request isn't traced.
+func traceID(r *http.Request) string {
+	// version-traceid-parentid-flags
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[3]) != 2 {
+		return ""
+	}
+	if !isHex(parts[1]) || strings.Trim(parts[1], "0") == "" {
+		return ""
+	}
+	// sampled flag
+	if !isHex(parts[3]) || parts[3][1]&1 == 0 {
+		return ""
+	}
+	return parts[1]
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// playbackQueue is the number of buffers queued for the player.
+const playbackQueue = 16
+
+type (
+	// Monitor streams peaks of running conversions to websocket
+	// listeners, so operators can watch what's being encoded. Monitor
+	// never slows the conversion down: blocks are dropped for listeners
+	// that are behind.
+	Monitor struct {
+		mu   sync.Mutex
+		subs map[chan MonitorBlock]struct{}
+	}
+
+	// MonitorBlock contains peaks of the buffer written by conversion of
+	// Name. Min and Max are the lowest and highest samples of every
+	// channel.
+	MonitorBlock struct {
+		Name       string    `json:"name"`
+		SampleRate int       `json:"sample_rate"`
+		Frames     int       `json:"frames"`
+		Min        []float64 `json:"min"`
+		Max        []float64 `json:"max"`
+	}
+)
+
+// NewMonitor returns monitor without listeners.
+func NewMonitor() *Monitor {
+	return &Monitor{
+		subs: make(map[chan MonitorBlock]struct{}),
+	}
+}
+
+// Sink returns the sink allocator that publishes peaks of every buffer of
+// conversion with provided name. It's meant to be attached next to the
+// output with Tee.
+func (m *Monitor) Sink(name string) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				b := MonitorBlock{
+					Name:       name,
+					SampleRate: int(props.SampleRate),
+					Frames:     in.Length(),
+					Min:        make([]float64, props.Channels),
+					Max:        make([]float64, props.Channels),
+				}
+				for i := 0; i < in.Len(); i++ {
+					c, v := i%props.Channels, in.Sample(i)
+					b.Min[c] = math.Min(b.Min[c], v)
+					b.Max[c] = math.Max(b.Max[c], v)
+				}
+				m.publish(b)
+				return nil
+			},
+		}, nil
+	}
+}
+
+// ServeHTTP streams blocks of all conversions to the websocket as JSON
+// messages until the listener is gone.
+func (m *Monitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Handler(m.serve).ServeHTTP(w, r)
+}
+
+func (m *Monitor) serve(ws *websocket.Conn) {
+	blocks := m.subscribe()
+	defer m.unsubscribe(blocks)
+	// listeners don't send anything, read fails when they are gone
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case b := <-blocks:
+			if err := websocket.JSON.Send(ws, b); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (m *Monitor) subscribe() chan MonitorBlock {
+	blocks := make(chan MonitorBlock, 64)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[blocks] = struct{}{}
+	return blocks
+}
+
+func (m *Monitor) unsubscribe(blocks chan MonitorBlock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.subs, blocks)
+}
+
+// publish sends the block to listeners that keep up.
+func (m *Monitor) publish(b MonitorBlock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for blocks := range m.subs {
+		select {
+		case blocks <- b:
+		default:
+		}
+	}
+}
+
+// PlaybackSink returns the sink allocator that sends the signal to the
+// player as interleaved 16-bit little-endian PCM. Open is called with
+// properties of the signal to start the player, it's closed when the
+// conversion is done. Buffers are written by separate goroutine and
+// dropped if the player is behind, player errors stop the playback, but
+// don't fail the conversion.
+func PlaybackSink(open func(pipe.SignalProperties) (io.WriteCloser, error)) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			queue chan []byte
+			done  chan struct{}
+		)
+		return pipe.Sink{
+			StartFunc: func(context.Context) error {
+				player, err := open(props)
+				if err != nil {
+					log.Printf("Failed to start playback: %v", err)
+					return nil
+				}
+				queue, done = make(chan []byte, playbackQueue), make(chan struct{})
+				go func() {
+					defer close(done)
+					defer player.Close()
+					for b := range queue {
+						if _, err := player.Write(b); err != nil {
+							log.Printf("Playback stopped: %v", err)
+							break
+						}
+					}
+					// release the sink if playback is stopped
+					for range queue {
+					}
+				}()
+				return nil
+			},
+			SinkFunc: func(in signal.Floating) error {
+				if queue == nil {
+					return nil
+				}
+				b := make([]byte, 2*in.Len())
+				for i := 0; i < in.Len(); i++ {
+					v := math.Max(-1, math.Min(1, in.Sample(i)))
+					binary.LittleEndian.PutUint16(b[2*i:], uint16(int16(math.Round(v*math.MaxInt16))))
+				}
+				select {
+				case queue <- b:
+				default:
+				}
+				return nil
+			},
+			FlushFunc: func(context.Context) error {
+				if queue != nil {
+					close(queue)
+					<-done
+				}
+				return nil
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+)
+
+type playerBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *playerBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestMonitor(t *testing.T) {
+	monitor := service.NewMonitor()
+	server := httptest.NewServer(monitor)
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/monitor", "", server.URL)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	// listener is subscribed after the handshake, so conversions are
+	// repeated until it receives the block
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	go func() {
+		samples := []float64{0.5, -0.25, 1, -1}
+		for ctx.Err() == nil {
+			encode.Run(ctx, 2, 0, rampSource(samples), monitor.Sink("test"))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	var b service.MonitorBlock
+	assert.NoError(t, websocket.JSON.Receive(ws, &b))
+	assert.Equal(t, "test", b.Name)
+	assert.Equal(t, 100, b.SampleRate)
+	assert.Equal(t, 2, b.Frames)
+	assert.Equal(t, []float64{-0.25}, b.Min)
+	assert.Equal(t, []float64{0.5}, b.Max)
+}
+
+func TestPlaybackSink(t *testing.T) {
+	var (
+		player playerBuffer
+		props  pipe.SignalProperties
+	)
+	open := func(p pipe.SignalProperties) (io.WriteCloser, error) {
+		props = p
+		return &player, nil
+	}
+	samples := []float64{0.5, -0.5, 1, -2}
+	err := encode.Run(context.Background(), 4, 0, rampSource(samples), service.PlaybackSink(open))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, int(props.SampleRate))
+	assert.True(t, player.closed)
+
+	pcm := make([]int16, len(samples))
+	assert.NoError(t, binary.Read(&player, binary.LittleEndian, pcm))
+	assert.Equal(t, []int16{16384, -16384, 32767, -32767}, pcm)
+}
+
+// rampSource returns mono source of samples at 100 Hz.
+func rampSource(samples []float64) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		var pos int
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{Channels: 1, SampleRate: 100},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				if pos == len(samples) {
+					return 0, io.EOF
+				}
+				n := signal.WriteFloat64(samples[pos:], out)
+				pos += n
+				return n, nil
+			},
+		}, nil
+	}
+}
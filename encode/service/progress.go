@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pipelined.dev/phono/encode"
+)
+
+const (
+	// progressInterval is the minimal interval between progress events.
+	progressInterval = 200 * time.Millisecond
+	// doneRetention is how long the last event of the conversion is kept
+	// for subscribers that connect after it's done.
+	doneRetention = time.Minute
+)
+
+type (
+	// Progress publishes conversion progress events to subscribers. Events
+	// are served as server-sent events stream on /progress/{id} path.
+	// Subscriber can connect before the conversion is started, so client
+	// can generate the id, subscribe and then submit the form. The last
+	// event is kept for a minute, so subscribers that connect after the
+	// conversion is done receive it as well.
+	Progress struct {
+		mu   sync.Mutex
+		subs map[string]map[chan ProgressEvent]struct{}
+		done map[string]doneEvent
+	}
+
+	// doneEvent is the last event of finished conversion.
+	doneEvent struct {
+		ProgressEvent
+		at time.Time
+	}
+
+	// ProgressEvent contains the state of conversion. Frames is the number
+	// of frames written to the sink. Read is the number of bytes read from
+	// the input of Size bytes. Code is set if conversion failed.
+	ProgressEvent struct {
+		Frames int64            `json:"frames"`
+		Read   int64            `json:"read"`
+		Size   int64            `json:"size"`
+		Done   bool             `json:"done"`
+		Error  string           `json:"error,omitempty"`
+		Code   encode.ErrorCode `json:"code,omitempty"`
+	}
+
+	// progressJob tracks progress of a single conversion.
+	progressJob struct {
+		progress *Progress
+		id       string
+		size     int64
+
+		mu     sync.Mutex
+		frames int64
+		read   int64
+		last   time.Time
+	}
+
+	// progressReader tracks read position of the input.
+	progressReader struct {
+		io.ReadSeeker
+		job *progressJob
+	}
+)
+
+// NewProgress returns new progress publisher.
+func NewProgress() *Progress {
+	return &Progress{
+		subs: make(map[string]map[chan ProgressEvent]struct{}),
+		done: make(map[string]doneEvent),
+	}
+}
+
+// ServeHTTP streams progress events of the conversion until it's done.
+func (p *Progress) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	id := path.Base(r.URL.Path)
+	events := p.subscribe(id)
+	defer p.unsubscribe(id, events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			b, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+			if e.Done {
+				return
+			}
+		}
+	}
+}
+
+func (p *Progress) subscribe(id string) chan ProgressEvent {
+	events := make(chan ProgressEvent, 16)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.subs[id]; !ok {
+		p.subs[id] = make(map[chan ProgressEvent]struct{})
+	}
+	p.subs[id][events] = struct{}{}
+	p.expire()
+	if e, ok := p.done[id]; ok {
+		events <- e.ProgressEvent
+	}
+	return events
+}
+
+func (p *Progress) unsubscribe(id string, events chan ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subs[id], events)
+	if len(p.subs[id]) == 0 {
+		delete(p.subs, id)
+	}
+}
+
+// publish sends event to all subscribers of the conversion. Slow
+// subscribers miss intermediate events, but always receive the last one.
+func (p *Progress) publish(id string, e ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e.Done {
+		p.expire()
+		p.done[id] = doneEvent{ProgressEvent: e, at: time.Now()}
+	}
+	for events := range p.subs[id] {
+		if e.Done {
+			// drop pending event to free the space for the last one
+			select {
+			case <-events:
+			default:
+			}
+		}
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+// expire removes last events older than retention period. Must be
+// called with the lock held.
+func (p *Progress) expire() {
+	for id, e := range p.done {
+		if time.Since(e.at) > doneRetention {
+			delete(p.done, id)
+		}
+	}
+}
+
+// job starts tracking of the conversion with provided id.
+func (p *Progress) job(id string, size int64) *progressJob {
+	return &progressJob{
+		progress: p,
+		id:       id,
+		size:     size,
+	}
+}
+
+// reader wraps the input to track read bytes.
+func (j *progressJob) reader(rs io.ReadSeeker) io.ReadSeeker {
+	return &progressReader{
+		ReadSeeker: rs,
+		job:        j,
+	}
+}
+
+// OnStart implements Hook. Progress is published with the first
+// written frames.
+func (j *progressJob) OnStart() {}
+
+// OnProgress publishes written frames, events are limited by progress
+// interval.
+func (j *progressJob) OnProgress(frames int64) {
+	j.mu.Lock()
+	j.frames += frames
+	if time.Since(j.last) < progressInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.last = time.Now()
+	e := j.event()
+	j.mu.Unlock()
+	j.progress.publish(j.id, e)
+}
+
+// OnComplete publishes the final event of the conversion.
+func (j *progressJob) OnComplete() {
+	j.done(nil)
+}
+
+// OnError publishes the final event of failed conversion.
+func (j *progressJob) OnError(err error) {
+	j.done(err)
+}
+
+func (j *progressJob) done(err error) {
+	j.mu.Lock()
+	e := j.event()
+	j.mu.Unlock()
+	e.Done = true
+	if err != nil {
+		e.Error = err.Error()
+		e.Code = encode.Code(err)
+	}
+	j.progress.publish(j.id, e)
+}
+
+// event must be called under the lock.
+func (j *progressJob) event() ProgressEvent {
+	return ProgressEvent{
+		Frames: j.frames,
+		Read:   atomic.LoadInt64(&j.read),
+		Size:   j.size,
+	}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	atomic.AddInt64(&r.job.read, int64(n))
+	return n, err
+}
+
+func (r *progressReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		atomic.StoreInt64(&r.job.read, pos)
+	}
+	return pos, err
+}
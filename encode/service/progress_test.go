@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestProgress(t *testing.T) {
+	progress := service.NewProgress()
+	server := httptest.NewServer(progress)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/progress/test-id")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false), Buffering: encode.Buffering{Size: 512}, Progress: progress})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":                ".wav",
+		"wav-bit-depth":         "16",
+		userinput.ProgressIDKey: "test-id",
+	}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// read events until the last one
+	var last service.ProgressEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last))
+		if last.Done {
+			break
+		}
+	}
+	assert.True(t, last.Done)
+	assert.Empty(t, last.Error)
+	assert.True(t, last.Read > 0 && last.Read <= last.Size)
+	assert.True(t, last.Frames > 0)
+
+	// late subscriber receives the last event
+	resp, err = http.Get(server.URL + "/progress/test-id")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var late service.ProgressEvent
+	scanner = bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &late))
+		}
+	}
+	assert.Equal(t, last, late)
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/idgen"
+)
+
+// metadataExt is the extension of result metadata file. It's stored next
+// to the result, so results can be salvaged after restart.
+const metadataExt = ".json"
+
+var (
+	errResultNotFound  = i18n.Errorf("error.result_not_found")
+	errResultExpired   = i18n.Errorf("error.result_expired")
+	errResultSignature = i18n.Errorf("error.result_signature")
+)
+
+type (
+	// Results stores encoded files and serves them by signed links. Link
+	// has the following format:
+	//	/results/id?expires=unix&signature=hmac
+	// Signature is HMAC-SHA256 of id and expiration time, so links cannot
+	// be forged or prolonged. Files are removed after TTL. Clock and IDs
+	// can be set before the first use, system clock and random ids are
+	// used by default.
+	Results struct {
+		Clock  clock.Clock
+		IDs    idgen.Generator
+		secret []byte
+		ttl    time.Duration
+
+		mu      sync.Mutex
+		results map[string]*result
+	}
+
+	result struct {
+		path    string
+		name    string
+		expires time.Time
+	}
+
+	// resultMetadata is stored in the metadata file of the result.
+	resultMetadata struct {
+		ID      string    `json:"id"`
+		Name    string    `json:"name"`
+		Expires time.Time `json:"expires"`
+	}
+
+	// resultLink is sent to the client instead of the encoded file.
+	resultLink struct {
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+// NewResults creates results store. If secret is empty, random one is
+// generated, so links are valid until restart. Secret must be provided
+// to keep links of salvaged results valid.
+func NewResults(secret []byte, ttl time.Duration) *Results {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(fmt.Sprintf("failed to generate results secret: %v", err))
+		}
+	}
+	return &Results{
+		secret:  secret,
+		ttl:     ttl,
+		results: make(map[string]*result),
+	}
+}
+
+// ServeHTTP sends the result file if link is valid.
+func (s *Results) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	s.expire()
+
+	id := path.Base(r.URL.Path)
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(r.URL.Query().Get("signature")), []byte(s.sign(id, expires))) {
+		http.Error(w, i18n.Localize(i18n.Request(r), errResultSignature), http.StatusForbidden)
+		return
+	}
+	if s.now().After(time.Unix(expires, 0)) {
+		http.Error(w, i18n.Localize(i18n.Request(r), errResultExpired), http.StatusGone)
+		return
+	}
+	res, ok := s.get(id)
+	if !ok {
+		http.Error(w, i18n.Localize(i18n.Request(r), errResultNotFound), http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(res.path)
+	if err != nil {
+		http.Error(w, i18n.Localize(i18n.Request(r), errResultNotFound), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get file stats: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", "attachment; filename="+res.name)
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(res.name)))
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
+
+// store takes the ownership of encoded file and returns the link to it.
+// File is closed, but not removed until it's expired.
+func (s *Results) store(f *os.File, name string) (resultLink, error) {
+	s.expire()
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return resultLink{}, err
+	}
+	id, err := idgen.Or(s.IDs).New()
+	if err != nil {
+		os.Remove(f.Name())
+		return resultLink{}, err
+	}
+	expires := s.now().Add(s.ttl)
+	if err := writeResultMetadata(f.Name(), resultMetadata{ID: id, Name: name, Expires: expires}); err != nil {
+		os.Remove(f.Name())
+		return resultLink{}, err
+	}
+	s.mu.Lock()
+	s.results[id] = &result{
+		path:    f.Name(),
+		name:    name,
+		expires: expires,
+	}
+	s.mu.Unlock()
+	return resultLink{
+		URL:     fmt.Sprintf("/results/%s?expires=%d&signature=%s", id, expires.Unix(), s.sign(id, expires.Unix())),
+		Expires: expires,
+	}, nil
+}
+
+func (s *Results) get(id string) (*result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.results[id]
+	return res, ok
+}
+
+// Owns returns true if path is the result or its metadata file.
+func (s *Results) Owns(path string) bool {
+	path = strings.TrimSuffix(path, metadataExt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, res := range s.results {
+		if res.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes results which links are expired.
+func (s *Results) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, res := range s.results {
+		if now.After(res.expires) {
+			removeResult(res.path)
+			delete(s.results, id)
+		}
+	}
+}
+
+// Salvage moves not expired results with metadata from src to dst
+// directory and returns the number of salvaged results.
+func (s *Results) Salvage(src, dst string) int {
+	paths, err := filepath.Glob(filepath.Join(src, "*"+metadataExt))
+	if err != nil {
+		return 0
+	}
+	salvaged := 0
+	for _, metadataPath := range paths {
+		b, err := ioutil.ReadFile(metadataPath)
+		if err != nil {
+			continue
+		}
+		var m resultMetadata
+		if err := json.Unmarshal(b, &m); err != nil || s.now().After(m.Expires) {
+			continue
+		}
+		resultPath := filepath.Join(dst, filepath.Base(strings.TrimSuffix(metadataPath, metadataExt)))
+		if err := os.Rename(strings.TrimSuffix(metadataPath, metadataExt), resultPath); err != nil {
+			continue
+		}
+		if err := os.Rename(metadataPath, resultPath+metadataExt); err != nil {
+			os.Remove(resultPath)
+			continue
+		}
+		s.mu.Lock()
+		s.results[m.ID] = &result{
+			path:    resultPath,
+			name:    m.Name,
+			expires: m.Expires,
+		}
+		s.mu.Unlock()
+		salvaged++
+	}
+	return salvaged
+}
+
+func writeResultMetadata(resultPath string, m resultMetadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(resultPath+metadataExt, b, 0600)
+}
+
+// removeResult removes result and its metadata files.
+func removeResult(resultPath string) {
+	for _, p := range []string{resultPath, resultPath + metadataExt} {
+		if err := os.Remove(p); err != nil {
+			log.Printf("Failed to delete result file: %v", err)
+		}
+	}
+}
+
+// sign returns hex-encoded signature of the link.
+func (s *Results) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Results) now() time.Time {
+	return clock.Or(s.Clock).Now()
+}
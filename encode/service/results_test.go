@@ -0,0 +1,113 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestResults(t *testing.T) {
+	results := service.NewResults([]byte("secret"), time.Minute)
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, true), Buffering: encode.Buffering{Size: 512}, Results: results})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+		"link":          "true",
+	}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var link struct {
+		URL     string    `json:"url"`
+		Expires time.Time `json:"expires"`
+	}
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&link))
+	assert.Equal(t, link.URL, rr.Header().Get("Location"))
+	assert.True(t, link.Expires.After(time.Now()))
+
+	rr = httptest.NewRecorder()
+	results.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link.URL, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "attachment; filename=result_1.wav", rr.Header().Get("Content-Disposition"))
+	assert.NotZero(t, rr.Body.Len())
+
+	// forged link
+	rr = httptest.NewRecorder()
+	forged := strings.Replace(link.URL, "expires=", "expires=1", 1)
+	results.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, forged, nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+}
+
+func TestResultsSalvage(t *testing.T) {
+	src, err := ioutil.TempDir("", "results-src")
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "results-dst")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	// result of the previous run
+	secret := []byte("secret")
+	results := service.NewResults(secret, time.Minute)
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, true), Buffering: encode.Buffering{Size: 512}, TempDir: src, Results: results})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+		"link":          "true",
+	}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	link := rr.Header().Get("Location")
+
+	restarted := service.NewResults(secret, time.Minute)
+	assert.Equal(t, 1, restarted.Salvage(src, dst))
+	// result and its metadata
+	paths, err := filepath.Glob(filepath.Join(dst, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(paths))
+	for _, p := range paths {
+		assert.True(t, restarted.Owns(p), p)
+	}
+	assert.False(t, restarted.Owns(filepath.Join(dst, "other")))
+	rr = httptest.NewRecorder()
+	restarted.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotZero(t, rr.Body.Len())
+}
+
+func TestResultsExpired(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewManual(now)
+	results := service.NewResults([]byte("secret"), time.Minute)
+	results.Clock = c
+	results.IDs = &idgen.Sequence{Prefix: "result-"}
+	h := service.Handler(service.Config{Form: userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, true), Buffering: encode.Buffering{Size: 512}, Results: results})
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, wavUploadRequest(map[string]string{
+		"format":        ".wav",
+		"wav-bit-depth": "16",
+		"link":          "true",
+	}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	link := rr.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(link, fmt.Sprintf("/results/result-1?expires=%d&", now.Add(time.Minute).Unix())))
+
+	c.Add(2 * time.Minute)
+	rr = httptest.NewRecorder()
+	results.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link, nil))
+	assert.Equal(t, http.StatusGone, rr.Code)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// errUploadEnded is returned when the upload stops reading before the
+// end of the result.
+var errUploadEnded = errors.New("upload ended before the end of result")
+
+// UploadError is returned when the result cannot be uploaded to the
+// destination.
+type UploadError struct {
+	Err error
+}
+
+func (e *UploadError) Error() string {
+	return "failed to upload result: " + e.Err.Error()
+}
+
+// Unwrap returns underlying upload error.
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// Stream runs the conversion that writes the result into w and uploads
+// it at the same time, so the upload doesn't wait until the conversion
+// is done. Conversion error is passed to the upload, so it's aborted.
+// If the upload fails first, writes of the conversion fail and
+// UploadError is returned. Backends that write in place, e.g. sftp and
+// ftp, keep the partial result of failed conversion.
+func (d *Destination) Stream(ctx context.Context, run func(w io.Writer) error) error {
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+	go func() {
+		err := d.Put(ctx, pr)
+		// the result is sent before writes start to fail
+		uploaded <- err
+		if err == nil {
+			err = errUploadEnded
+		}
+		pr.CloseWithError(err)
+	}()
+	err := run(pw)
+	select {
+	case uerr := <-uploaded:
+		if uerr == nil {
+			uerr = errUploadEnded
+		}
+		return &UploadError{Err: uerr}
+	default:
+	}
+	pw.CloseWithError(err)
+	uerr := <-uploaded
+	if err != nil {
+		return err
+	}
+	if uerr != nil {
+		return &UploadError{Err: uerr}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode/service"
+)
+
+func TestDestinationStream(t *testing.T) {
+	var uploaded []byte
+	d := service.Destination{
+		Location: "s3://bucket/out.mp3",
+		Put: func(ctx context.Context, r io.Reader) error {
+			var err error
+			uploaded, err = ioutil.ReadAll(r)
+			return err
+		},
+	}
+	// upload is done when the conversion is done
+	err := d.Stream(context.Background(), func(w io.Writer) error {
+		for _, s := range []string{"first ", "second"} {
+			if _, err := io.WriteString(w, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "first second", string(uploaded))
+
+	// upload is aborted by conversion error
+	errConversion := errors.New("conversion failed")
+	err = d.Stream(context.Background(), func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errConversion
+	})
+	assert.Equal(t, errConversion, err)
+
+	// conversion is stopped by upload error
+	errUpload := errors.New("upload failed")
+	d.Put = func(ctx context.Context, r io.Reader) error {
+		return errUpload
+	}
+	err = d.Stream(context.Background(), func(w io.Writer) error {
+		for {
+			if _, err := io.WriteString(w, "data"); err != nil {
+				return err
+			}
+		}
+	})
+	var uploadErr *service.UploadError
+	assert.True(t, errors.As(err, &uploadErr))
+	assert.Equal(t, errUpload, uploadErr.Err)
+
+	// upload that doesn't read the whole result fails
+	d.Put = func(ctx context.Context, r io.Reader) error {
+		return nil
+	}
+	err = d.Stream(context.Background(), func(w io.Writer) error {
+		_, err := io.WriteString(w, "data")
+		return err
+	})
+	assert.True(t, errors.As(err, &uploadErr))
+}
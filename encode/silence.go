@@ -0,0 +1,112 @@
+package encode
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+type (
+	// Silence is the silent region of the signal. Start, end and duration
+	// are in seconds.
+	Silence struct {
+		Start    float64 `json:"start"`
+		End      float64 `json:"end"`
+		Duration float64 `json:"duration"`
+	}
+
+	// SilenceDetector finds the regions of the signal written into its
+	// sink where all samples are below the threshold. Only regions that
+	// last at least min duration are reported. Silences are available
+	// after the run is done.
+	SilenceDetector struct {
+		threshold   float64
+		minDuration time.Duration
+		silences    []Silence
+	}
+)
+
+// NewSilenceDetector returns the detector of silence below threshold
+// dBFS that lasts at least minDuration.
+func NewSilenceDetector(thresholdDB float64, minDuration time.Duration) (*SilenceDetector, error) {
+	if err := CheckSilenceThreshold(thresholdDB, minDuration); err != nil {
+		return nil, err
+	}
+	return &SilenceDetector{
+		threshold:   math.Pow(10, thresholdDB/20),
+		minDuration: minDuration,
+	}, nil
+}
+
+// Sink wraps the sink allocator to detect the silence before the signal
+// is written. If fn is nil, the signal is analyzed only.
+func (d *SilenceDetector) Sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			sink pipe.Sink
+			err  error
+		)
+		if fn != nil {
+			if sink, err = fn(mctx, bufferSize, props); err != nil {
+				return sink, err
+			}
+		}
+		d.silences = nil
+		sampleRate := float64(props.SampleRate)
+		minFrames := int(d.minDuration.Seconds() * sampleRate)
+		// start is the first frame of current silence, negative if the
+		// current frame is not silent
+		frames, start := 0, -1
+		end := func(frame int) {
+			if start >= 0 && frame > start && frame-start >= minFrames {
+				d.silences = append(d.silences, Silence{
+					Start:    float64(start) / sampleRate,
+					End:      float64(frame) / sampleRate,
+					Duration: float64(frame-start) / sampleRate,
+				})
+			}
+			start = -1
+		}
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			channels := in.Channels()
+			for i := 0; i < in.Length(); i++ {
+				silent := true
+				for c := 0; c < channels; c++ {
+					if math.Abs(in.Sample(i*channels+c)) > d.threshold {
+						silent = false
+						break
+					}
+				}
+				switch {
+				case !silent:
+					end(frames + i)
+				case start < 0:
+					start = frames + i
+				}
+			}
+			frames += in.Length()
+			if sinkFn != nil {
+				return sinkFn(in)
+			}
+			return nil
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			end(frames)
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
+
+// Silences returns silent regions of the signal in order.
+func (d *SilenceDetector) Silences() []Silence {
+	return d.silences
+}
@@ -0,0 +1,61 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestSilenceDetector(t *testing.T) {
+	_, err := encode.NewSilenceDetector(1, 0)
+	assert.Error(t, err)
+
+	// 0.2s of silence, 0.5s of sound with 0.1s and 0.3s dropouts and 0.2s
+	// of silence
+	const sampleRate = 1000
+	frames := make([]float64, 1600)
+	for i := 200; i < 1400; i++ {
+		if i < 400 || i >= 500 && i < 900 || i >= 1200 {
+			frames[i] = 0.5
+		}
+	}
+	format := fileformat.WAV()
+	detect := func(db float64, minDuration time.Duration) []encode.Silence {
+		d, err := encode.NewSilenceDetector(db, minDuration)
+		assert.NoError(t, err)
+		input := bytes.NewReader(stereoWAV(sampleRate, frames))
+		assert.NoError(t, encode.Run(context.Background(), 64, 0, format.Source(input), d.Sink(nil)))
+		return d.Silences()
+	}
+
+	assert.Equal(t, []encode.Silence{
+		{Start: 0, End: 0.2, Duration: 0.2},
+		{Start: 0.4, End: 0.5, Duration: 0.1},
+		{Start: 0.9, End: 1.2, Duration: 0.3},
+		{Start: 1.4, End: 1.6, Duration: 0.2},
+	}, roundSilences(detect(-60, 0)))
+	assert.Equal(t, []encode.Silence{
+		{Start: 0.9, End: 1.2, Duration: 0.3},
+	}, roundSilences(detect(-60, 250*time.Millisecond)))
+	// sound is below threshold
+	assert.Equal(t, []encode.Silence{
+		{Start: 0, End: 1.6, Duration: 1.6},
+	}, roundSilences(detect(-3, 0)))
+}
+
+// roundSilences rounds silences to milliseconds.
+func roundSilences(silences []encode.Silence) []encode.Silence {
+	round := func(v float64) float64 {
+		return float64(int(v*1000+0.5)) / 1000
+	}
+	for i, s := range silences {
+		silences[i] = encode.Silence{Start: round(s.Start), End: round(s.End), Duration: round(s.Duration)}
+	}
+	return silences
+}
@@ -0,0 +1,97 @@
+package encode
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Split returns the sink allocator that writes segments of the signal
+// into separate sinks in a single pass. Segment i starts at starts[i] and
+// lasts until the next start, the last one lasts until the end of the
+// signal. The signal before the first start is dropped. Sink of every
+// segment is allocated with fn when the segment begins and flushed when
+// it ends. Starts must be sorted.
+func Split(starts []time.Duration, fn func(i int) (pipe.SinkAllocatorFunc, error)) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		bounds := make([]int, len(starts))
+		for i, s := range starts {
+			bounds[i] = int(math.Round(s.Seconds() * float64(props.SampleRate)))
+		}
+		var (
+			ctx = context.Background()
+			// index of the current segment and its sink
+			current = -1
+			sink    pipe.Sink
+			// position of the next frame
+			pos int
+		)
+		// flush flushes the sink of the current segment.
+		flush := func() error {
+			if current < 0 || sink.FlushFunc == nil {
+				return nil
+			}
+			return sink.FlushFunc(ctx)
+		}
+		// next flushes the current segment and starts the next one.
+		next := func() error {
+			if err := flush(); err != nil {
+				return err
+			}
+			current++
+			allocator, err := fn(current)
+			if err != nil {
+				return err
+			}
+			if sink, err = allocator(mctx, bufferSize, props); err != nil {
+				return err
+			}
+			if sink.StartFunc != nil {
+				return sink.StartFunc(ctx)
+			}
+			return nil
+		}
+		return pipe.Sink{
+			StartFunc: func(c context.Context) error {
+				ctx = c
+				return nil
+			},
+			SinkFunc: func(in signal.Floating) error {
+				frames := in.Length()
+				for from := 0; from < frames; {
+					for current+1 < len(bounds) && pos+from >= bounds[current+1] {
+						if err := next(); err != nil {
+							return err
+						}
+					}
+					to := frames
+					if current+1 < len(bounds) && bounds[current+1]-pos < to {
+						to = bounds[current+1] - pos
+					}
+					if current >= 0 {
+						if err := sink.SinkFunc(in.Slice(from, to)); err != nil {
+							return err
+						}
+					}
+					from = to
+				}
+				pos += frames
+				return nil
+			},
+			FlushFunc: func(c context.Context) error {
+				ctx = c
+				// segments that start after the end of the signal are empty
+				for current+1 < len(bounds) {
+					if err := next(); err != nil {
+						return err
+					}
+				}
+				return flush()
+			},
+		}, nil
+	}
+}
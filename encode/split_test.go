@@ -0,0 +1,85 @@
+package encode_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestSplit(t *testing.T) {
+	// 1 second of ramp at 100 Hz
+	samples := make([]float64, 100)
+	for i := range samples {
+		samples[i] = float64(i) / 100
+	}
+	split := func(starts ...time.Duration) ([][]float64, []bool, error) {
+		var (
+			segments [][]float64
+			flushed  []bool
+		)
+		sink := encode.Split(starts, func(i int) (pipe.SinkAllocatorFunc, error) {
+			if i == 2 && len(starts) == 4 {
+				return nil, errors.New("failed")
+			}
+			segments = append(segments, nil)
+			flushed = append(flushed, false)
+			return func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+				return pipe.Sink{
+					SinkFunc: func(in signal.Floating) error {
+						for i := 0; i < in.Len(); i++ {
+							segments[len(segments)-1] = append(segments[len(segments)-1], in.Sample(i))
+						}
+						return nil
+					},
+					FlushFunc: func(context.Context) error {
+						flushed[len(flushed)-1] = true
+						return nil
+					},
+				}, nil
+			}, nil
+		})
+		err := encode.Run(context.Background(), 16, 0, rampSource(samples), sink)
+		return segments, flushed, err
+	}
+
+	_, _, err := split(100*time.Millisecond, 250*time.Millisecond, 250*time.Millisecond, 2*time.Second)
+	assert.Error(t, err)
+
+	segments, flushed, err := split(100*time.Millisecond, 250*time.Millisecond, 250*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{samples[10:25], nil, samples[25:]}, segments)
+	assert.Equal(t, []bool{true, true, true}, flushed)
+
+	// segments after the end are empty
+	segments, flushed, err = split(0, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{samples, nil}, segments)
+	assert.Equal(t, []bool{true, true}, flushed)
+}
+
+// rampSource returns mono source of samples at 100 Hz.
+func rampSource(samples []float64) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		var pos int
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{Channels: 1, SampleRate: 100},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				if pos == len(samples) {
+					return 0, io.EOF
+				}
+				n := signal.WriteFloat64(samples[pos:], out)
+				pos += n
+				return n, nil
+			},
+		}, nil
+	}
+}
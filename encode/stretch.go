@@ -0,0 +1,313 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/bufpool"
+)
+
+// Limits of tempo and pitch changes.
+const (
+	MinTempo     = 0.25
+	MaxTempo     = 4.0
+	MaxSemitones = 12.0
+)
+
+const (
+	// stretchWindow is the duration of overlapped segments.
+	stretchWindow = 30 * time.Millisecond
+	// stretchSearchStep is the step of coarse segment search.
+	stretchSearchStep = 4
+)
+
+type (
+	// stretcher changes tempo of the signal with waveform similarity
+	// overlap-add: segments of the input are picked near their nominal
+	// positions where they match the continuation of previous segment
+	// the best and are cross-faded with the hann window.
+	stretcher struct {
+		channels  int
+		window    int
+		hop       int
+		tolerance int
+		// analysisHop is the nominal distance between input segments.
+		analysisHop float64
+		weights     []float64
+		// input frames starting at absolute frame inStart
+		in      []float64
+		inStart int
+		frames  int
+		// segments is the number of added segments and prev is the
+		// absolute frame of the last one.
+		segments int
+		prev     int
+		acc      []float64
+	}
+)
+
+// ParseSemitones parses the pitch shift in semitones with optional unit,
+// e.g. +2st.
+func ParseSemitones(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	if len(v) > 2 && strings.EqualFold(v[len(v)-2:], "st") {
+		v = strings.TrimSpace(v[:len(v)-2])
+	}
+	st, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pitch %q: %w", s, err)
+	}
+	return st, nil
+}
+
+// CheckStretch returns error if tempo or pitch shift is out of range.
+func CheckStretch(tempo, semitones float64) error {
+	if !(tempo >= MinTempo && tempo <= MaxTempo) {
+		return fmt.Errorf("invalid tempo %v: must be between %v and %v", tempo, MinTempo, MaxTempo)
+	}
+	if !(math.Abs(semitones) <= MaxSemitones) {
+		return fmt.Errorf("invalid pitch %v st: must be between -%v and %v", semitones, MaxSemitones, MaxSemitones)
+	}
+	return nil
+}
+
+// Stretch wraps the sink allocator to play the signal tempo times faster
+// and shift its pitch by semitones. Pitch is shifted by resampling with
+// provided quality. It's a sink wrapper rather than a processor, because
+// the output can be longer than the input and the tail of the signal is
+// written when the pipe is flushed.
+func Stretch(fn pipe.SinkAllocatorFunc, tempo, semitones float64, quality ResampleQuality) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		sink, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return sink, err
+		}
+		ratio := math.Pow(2, semitones/12)
+		// tempo of the stretcher is compensated for the change of rate
+		s := newStretcher(props.SampleRate, props.Channels, tempo/ratio)
+		r := newResampler(props.Channels, ratio, quality)
+		samples := make([]float64, bufferSize*props.Channels)
+		out := bufpool.Floating(props.Channels, bufferSize)
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		// expected number of output frames and number of written ones
+		var expected, written int
+		var pending []float64
+		send := func(final bool) error {
+			size := bufferSize * props.Channels
+			if final {
+				n := expected - written
+				if len(pending) > n*props.Channels {
+					pending = pending[:n*props.Channels]
+				}
+			}
+			for len(pending) >= size || final && len(pending) > 0 {
+				n := len(pending)
+				if n > size {
+					n = size
+				}
+				buf := out.Slice(0, n/props.Channels)
+				signal.WriteFloat64(pending[:n], buf)
+				if err := sinkFn(buf); err != nil {
+					return err
+				}
+				written += n / props.Channels
+				pending = pending[n:]
+			}
+			return nil
+		}
+		sink.SinkFunc = func(in signal.Floating) error {
+			n := signal.ReadFloat64(in, samples)
+			pending = append(pending, r.write(s.write(samples[:n*props.Channels], false), false)...)
+			return send(false)
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			pending = append(pending, r.write(s.write(nil, true), true)...)
+			expected = int(math.Round(float64(s.frames) / tempo))
+			err := send(true)
+			bufpool.PutFloating(out)
+			if err != nil {
+				return err
+			}
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
+
+func newStretcher(sampleRate signal.Frequency, channels int, tempo float64) *stretcher {
+	window := int(stretchWindow.Seconds()*float64(sampleRate)) / 2 * 2
+	s := stretcher{
+		channels:    channels,
+		window:      window,
+		hop:         window / 2,
+		tolerance:   window / 4,
+		analysisHop: float64(window/2) * tempo,
+		weights:     make([]float64, window),
+		acc:         make([]float64, window*channels),
+		prev:        -1,
+	}
+	// periodic hann window sums to one with half overlap
+	for i := range s.weights {
+		s.weights[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(window))
+	}
+	return &s
+}
+
+// write adds interleaved samples to the input and returns the finished
+// output. If final is set, the rest of input continues the last segment.
+func (s *stretcher) write(samples []float64, final bool) []float64 {
+	s.in = append(s.in, samples...)
+	s.frames += len(samples) / s.channels
+	var out []float64
+	for {
+		pos := int(math.Round(float64(s.segments) * s.analysisHop))
+		need := pos + s.tolerance + s.window
+		if s.prev >= 0 && s.prev+s.hop+s.window > need {
+			need = s.prev + s.hop + s.window
+		}
+		limit := pos + s.tolerance
+		if available := s.inStart + len(s.in)/s.channels; need > available {
+			if !final {
+				break
+			}
+			// segments at the end are picked from the rest of input,
+			// until it's long enough to complete the output
+			limit = available - s.window
+			if limit < s.inStart || s.prev >= 0 && float64((s.segments+1)*s.hop+available-s.prev-s.window) >= s.length() {
+				break
+			}
+		}
+		best := pos
+		if best > limit {
+			best = limit
+		}
+		if s.prev >= 0 {
+			best = s.search(pos, limit)
+		}
+		s.add(best)
+		out = append(out, s.acc[:s.hop*s.channels]...)
+		copy(s.acc, s.acc[s.hop*s.channels:])
+		for i := (s.window - s.hop) * s.channels; i < len(s.acc); i++ {
+			s.acc[i] = 0
+		}
+		s.prev = best
+		s.segments++
+		// drop the input that is not needed anymore, the rest of input
+		// is kept for the final segments
+		if final {
+			continue
+		}
+		keep := int(math.Round(float64(s.segments)*s.analysisHop)) - s.tolerance
+		if s.prev+s.hop < keep {
+			keep = s.prev + s.hop
+		}
+		if drop := keep - s.inStart; drop > 0 {
+			s.in = s.in[:copy(s.in, s.in[drop*s.channels:])]
+			s.inStart = keep
+		}
+	}
+	if final {
+		out = append(out, s.tail()...)
+	}
+	return out
+}
+
+// tail returns the rest of input cross-faded with the last segment.
+func (s *stretcher) tail() []float64 {
+	if s.prev < 0 {
+		return s.in
+	}
+	start := (s.prev + s.hop - s.inStart) * s.channels
+	for n := 0; n < s.hop; n++ {
+		for c := 0; c < s.channels; c++ {
+			if i := start + n*s.channels + c; i < len(s.in) {
+				s.acc[n*s.channels+c] += s.weights[n] * s.in[i]
+			}
+		}
+	}
+	out := s.acc[:s.hop*s.channels]
+	if rest := start + s.hop*s.channels; rest < len(s.in) {
+		out = append(out, s.in[rest:]...)
+	}
+	return out
+}
+
+// length returns the expected number of output frames.
+func (s *stretcher) length() float64 {
+	return float64(s.frames) * float64(s.hop) / s.analysisHop
+}
+
+// search returns the absolute frame of the segment near pos, but not
+// after limit, that is the most similar to the natural continuation of
+// previous segment.
+func (s *stretcher) search(pos, limit int) int {
+	from, to := pos-s.tolerance, pos+s.tolerance
+	if to > limit {
+		to = limit
+	}
+	if from < s.inStart {
+		from = s.inStart
+	}
+	if from > to {
+		from = to
+	}
+	best, bestCorr := to, math.Inf(-1)
+	check := func(c int) {
+		if corr := s.correlation(c, s.prev+s.hop); corr > bestCorr {
+			best, bestCorr = c, corr
+		}
+	}
+	for c := from; c <= to; c += stretchSearchStep {
+		check(c)
+	}
+	// refine around the best coarse candidate
+	coarse := best
+	for c := coarse - stretchSearchStep + 1; c < coarse+stretchSearchStep; c++ {
+		if c >= from && c <= to && c != coarse {
+			check(c)
+		}
+	}
+	return best
+}
+
+// correlation returns cross-correlation of the overlapped halves of two
+// segments at absolute frames a and b.
+func (s *stretcher) correlation(a, b int) float64 {
+	a, b = (a-s.inStart)*s.channels, (b-s.inStart)*s.channels
+	var sum float64
+	for i := 0; i < s.hop*s.channels; i += 2 * s.channels {
+		var x, y float64
+		for c := 0; c < s.channels; c++ {
+			x += s.in[a+i+c]
+			y += s.in[b+i+c]
+		}
+		sum += x * y
+	}
+	return sum
+}
+
+// add overlaps the windowed segment at absolute frame pos with the
+// output. The first segment isn't faded in.
+func (s *stretcher) add(pos int) {
+	offset := (pos - s.inStart) * s.channels
+	for n := 0; n < s.window; n++ {
+		w := s.weights[n]
+		if s.segments == 0 && n < s.hop {
+			w = 1
+		}
+		for c := 0; c < s.channels; c++ {
+			s.acc[n*s.channels+c] += w * s.in[offset+n*s.channels+c]
+		}
+	}
+}
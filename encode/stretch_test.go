@@ -0,0 +1,118 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestStretch(t *testing.T) {
+	assert.Error(t, encode.CheckStretch(0.1, 0))
+	assert.Error(t, encode.CheckStretch(1, 13))
+	assert.NoError(t, encode.CheckStretch(1.25, -2))
+
+	st, err := encode.ParseSemitones("+2st")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, st)
+	_, err = encode.ParseSemitones("2 semitones")
+	assert.Error(t, err)
+
+	// stretch returns the first channel of -6 dBFS sine after the stretch
+	stretch := func(frequency int, tempo, semitones float64, quality encode.ResampleQuality) []float64 {
+		var samples []float64
+		sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+			return pipe.Sink{
+				SinkFunc: func(in signal.Floating) error {
+					for i := 0; i < in.Len(); i += in.Channels() {
+						samples = append(samples, in.Sample(i))
+					}
+					return nil
+				},
+			}, nil
+		}
+		input := bytes.NewReader(sineWAV(44100, frequency, -6, 2))
+		assert.NoError(t, encode.Run(context.Background(), 512, 0, fileformat.WAV().Source(input), encode.Stretch(sink, tempo, semitones, quality)))
+		return samples
+	}
+	// frequency returns the number of positive zero crossings per second
+	frequency := func(samples []float64) float64 {
+		var crossings int
+		for i := 1; i < len(samples); i++ {
+			if samples[i-1] < 0 && samples[i] >= 0 {
+				crossings++
+			}
+		}
+		return float64(crossings) * 44100 / float64(len(samples))
+	}
+	// level returns the rms level in db
+	level := func(samples []float64) float64 {
+		var energy float64
+		for _, v := range samples {
+			energy += v * v
+		}
+		return 10 * math.Log10(energy/float64(len(samples)))
+	}
+
+	for _, quality := range encode.ResampleQualities {
+		for _, c := range []struct {
+			tempo     float64
+			semitones float64
+			frames    int
+			frequency float64
+		}{
+			{tempo: 1.25, frames: 70560, frequency: 440},
+			{tempo: 0.5, frames: 176400, frequency: 440},
+			{tempo: 1, semitones: 12, frames: 88200, frequency: 880},
+			{tempo: 1.5, semitones: -2, frames: 58800, frequency: 440 * math.Pow(2, -2.0/12)},
+		} {
+			samples := stretch(440, c.tempo, c.semitones, quality)
+			assert.Equal(t, c.frames, len(samples), quality)
+			assert.InDelta(t, c.frequency, frequency(samples), c.frequency/50, quality)
+			// level of sine is kept
+			assert.InDelta(t, -9, level(samples), 0.5, quality)
+			// the end of output is not faded out
+			assert.InDelta(t, -9, level(samples[len(samples)-1024:]), 2, quality)
+		}
+	}
+
+	// shifted over the nyquist frequency, sine is aliased by linear
+	// interpolation and filtered out by sinc
+	assert.Greater(t, level(stretch(15000, 1, 12, encode.ResampleLinear)), -12.0)
+	assert.Less(t, level(stretch(15000, 1, 12, encode.ResamplePolyphase)), -40.0)
+	assert.Less(t, level(stretch(15000, 1, 12, encode.ResampleSincBest)), -60.0)
+
+	_, err = encode.ParseResampleQuality("cubic")
+	assert.Error(t, err)
+	quality, err := encode.ParseResampleQuality("")
+	assert.NoError(t, err)
+	assert.Equal(t, encode.DefaultResampleQuality, quality)
+}
+
+// BenchmarkResample measures pitch shift of 10 seconds of sine with every
+// resample quality.
+func BenchmarkResample(b *testing.B) {
+	wav := sineWAV(44100, 440, -6, 10)
+	discard := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{SinkFunc: func(signal.Floating) error { return nil }}, nil
+	}
+	for _, quality := range encode.ResampleQualities {
+		b.Run(string(quality), func(b *testing.B) {
+			b.SetBytes(int64(len(wav)))
+			for i := 0; i < b.N; i++ {
+				input := bytes.NewReader(wav)
+				if err := encode.Run(context.Background(), 512, 0, fileformat.WAV().Source(input), encode.Stretch(discard, 1, 3, quality)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package encode
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+type (
+	// Summary describes finished conversion. Input and Output are short
+	// descriptions of formats, e.g. "flac" and "mp3 V2". InBitDepth is
+	// the bit depth of input samples, it's zero if unknown.
+	Summary struct {
+		Input      string
+		Output     string
+		InputSize  int64
+		OutputSize int64
+		In         pipe.SignalProperties
+		InBitDepth signal.BitDepth
+		Out        pipe.SignalProperties
+		Frames     int64
+		Elapsed    time.Duration
+	}
+
+	// Stats collects signal properties and the number of frames of the
+	// conversion. Source and Sink wrappers must be used in the same run.
+	Stats struct {
+		in, out pipe.SignalProperties
+		frames  int64
+		start   time.Time
+	}
+)
+
+// NewStats returns new conversion stats. Elapsed time is counted from
+// this call.
+func NewStats() *Stats {
+	return &Stats{start: time.Now()}
+}
+
+// Source wraps the source allocator to collect input properties.
+func (s *Stats) Source(fn pipe.SourceAllocatorFunc) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		src, err := fn(mctx, bufferSize)
+		s.in = src.SignalProperties
+		return src, err
+	}
+}
+
+// Sink wraps the sink allocator to collect output properties and the
+// number of written frames.
+func (s *Stats) Sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		s.out = props
+		sink, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return sink, err
+		}
+		sinkFn := sink.SinkFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			atomic.AddInt64(&s.frames, int64(in.Length()))
+			return sinkFn(in)
+		}
+		return sink, nil
+	}
+}
+
+// Summary returns the summary of finished conversion.
+func (s *Stats) Summary(input, output string, inputSize, outputSize int64) Summary {
+	return Summary{
+		Input:      input,
+		Output:     output,
+		InputSize:  inputSize,
+		OutputSize: outputSize,
+		In:         s.in,
+		Out:        s.out,
+		Frames:     atomic.LoadInt64(&s.frames),
+		Elapsed:    time.Since(s.start),
+	}
+}
+
+// Duration returns the duration of converted audio.
+func (s Summary) Duration() time.Duration {
+	if s.Out.SampleRate == 0 {
+		return 0
+	}
+	return s.Out.SampleRate.Duration(int(s.Frames))
+}
+
+// Realtime returns how many times the conversion was faster than
+// realtime.
+func (s Summary) Realtime() float64 {
+	if s.Elapsed == 0 {
+		return 0
+	}
+	return float64(s.Duration()) / float64(s.Elapsed)
+}
+
+// String returns one-line summary, e.g.:
+//
+//	in: flac 96k/24 stereo 41MB → out: mp3 V2 44.1k stereo 7.2MB, 12.4x realtime
+func (s Summary) String() string {
+	inRate := formatSampleRate(s.In.SampleRate)
+	if s.InBitDepth != 0 {
+		inRate += "/" + strconv.Itoa(int(s.InBitDepth))
+	}
+	return fmt.Sprintf("in: %s %s %s %s → out: %s %s %s %s, %.1fx realtime",
+		s.Input, inRate, formatChannels(s.In.Channels), formatSize(s.InputSize),
+		s.Output, formatSampleRate(s.Out.SampleRate), formatChannels(s.Out.Channels), formatSize(s.OutputSize),
+		s.Realtime(),
+	)
+}
+
+func formatSampleRate(rate signal.Frequency) string {
+	return strconv.FormatFloat(float64(rate)/1000, 'f', -1, 64) + "k"
+}
+
+func formatChannels(channels int) string {
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	}
+	return fmt.Sprintf("%dch", channels)
+}
+
+// formatSize formats size in bytes with decimal units.
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	v := strconv.FormatFloat(float64(size)/float64(div), 'f', 1, 64)
+	return fmt.Sprintf("%s%cB", trimZero(v), "kMGTPE"[exp])
+}
+
+func trimZero(v string) string {
+	if len(v) > 2 && v[len(v)-2:] == ".0" {
+		return v[:len(v)-2]
+	}
+	return v
+}
@@ -0,0 +1,40 @@
+package encode_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestSummary(t *testing.T) {
+	s := encode.Summary{
+		Input:      "flac",
+		Output:     "mp3 V2",
+		InputSize:  41000000,
+		OutputSize: 7200000,
+		In:         pipe.SignalProperties{SampleRate: 96000, Channels: 2},
+		InBitDepth: 24,
+		Out:        pipe.SignalProperties{SampleRate: 44100, Channels: 2},
+		Frames:     44100 * 124,
+		Elapsed:    10 * time.Second,
+	}
+	assert.Equal(t, 124*time.Second, s.Duration())
+	assert.Equal(t, "in: flac 96k/24 stereo 41MB → out: mp3 V2 44.1k stereo 7.2MB, 12.4x realtime", s.String())
+}
+
+func TestStats(t *testing.T) {
+	unblock := make(chan struct{})
+	close(unblock)
+	stats := encode.NewStats()
+	err := encode.Run(context.Background(), 16, 0, stats.Source(blockingSource(unblock)), stats.Sink(discardSink))
+	assert.NoError(t, err)
+	s := stats.Summary("wav", "wav 16bit", 0, 0)
+	assert.Equal(t, int64(16), s.Frames)
+	assert.Equal(t, 44100, int(s.In.SampleRate))
+	assert.Equal(t, 1, s.Out.Channels)
+}
@@ -0,0 +1,64 @@
+package encode
+
+import (
+	"io"
+	"math"
+	"math/rand"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Range of the synthesized sweep, the upper bound is limited by the
+// Nyquist frequency.
+const (
+	synthLowFrequency  = 20.0
+	synthHighFrequency = 20000.0
+)
+
+// Synth returns the source of the synthesized signal of duration. It's an
+// exponential sine sweep from 20Hz to 20kHz mixed with quiet noise, so
+// encoders process content of the whole spectrum. Channels get the same
+// sweep and different noise. The signal is the same for every source.
+func Synth(sampleRate, channels int, duration time.Duration) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		var (
+			frames = int(math.Round(duration.Seconds() * float64(sampleRate)))
+			low    = synthLowFrequency
+			high   = math.Min(synthHighFrequency, 0.45*float64(sampleRate))
+			random = rand.New(rand.NewSource(1))
+			phase  float64
+			pos    int
+		)
+		return pipe.Source{
+			SignalProperties: pipe.SignalProperties{
+				SampleRate: signal.Frequency(sampleRate),
+				Channels:   channels,
+			},
+			SourceFunc: func(out signal.Floating) (int, error) {
+				if pos >= frames {
+					return 0, io.EOF
+				}
+				n := out.Length()
+				if frames-pos < n {
+					n = frames - pos
+				}
+				for i := 0; i < n; i++ {
+					f := low * math.Pow(high/low, float64(pos+i)/float64(frames))
+					phase += 2 * math.Pi * f / float64(sampleRate)
+					if phase > 2*math.Pi {
+						phase -= 2 * math.Pi
+					}
+					sine := 0.5 * math.Sin(phase)
+					for c := 0; c < channels; c++ {
+						out.SetSample(i*channels+c, sine+0.05*(2*random.Float64()-1))
+					}
+				}
+				pos += n
+				return n, nil
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,41 @@
+package encode_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestSynth(t *testing.T) {
+	var (
+		frames int
+		peak   float64
+		props  pipe.SignalProperties
+	)
+	sink := func(mctx mutable.Context, bufferSize int, p pipe.SignalProperties) (pipe.Sink, error) {
+		props = p
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				frames += in.Length()
+				for i := 0; i < in.Len(); i++ {
+					peak = math.Max(peak, math.Abs(in.Sample(i)))
+				}
+				return nil
+			},
+		}, nil
+	}
+	err := encode.Run(context.Background(), 1000, 0, encode.Synth(8000, 2, 1500*time.Millisecond), sink)
+	assert.NoError(t, err)
+	assert.Equal(t, signal.Frequency(8000), props.SampleRate)
+	assert.Equal(t, 2, props.Channels)
+	assert.Equal(t, 12000, frames)
+	assert.True(t, peak > 0.4 && peak <= 0.55, "peak: %v", peak)
+}
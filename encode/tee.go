@@ -0,0 +1,67 @@
+package encode
+
+import (
+	"context"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Tee returns the sink allocator that writes the signal into all sinks in
+// a single pass, so the input is decoded and processed once for multiple
+// outputs. Sinks get the same buffer, so they must not change it. All
+// started sinks are flushed even if some of them fail, the first error is
+// returned.
+func Tee(sinks ...pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		allocated := make([]pipe.Sink, 0, len(sinks))
+		for _, fn := range sinks {
+			sink, err := fn(mctx, bufferSize, props)
+			if err != nil {
+				return pipe.Sink{}, err
+			}
+			allocated = append(allocated, sink)
+		}
+		// number of started sinks
+		var started int
+		flush := func(ctx context.Context) error {
+			var err error
+			for _, sink := range allocated[:started] {
+				if sink.FlushFunc == nil {
+					continue
+				}
+				if flushErr := sink.FlushFunc(ctx); err == nil {
+					err = flushErr
+				}
+			}
+			return err
+		}
+		return pipe.Sink{
+			StartFunc: func(ctx context.Context) error {
+				for _, sink := range allocated {
+					if sink.StartFunc != nil {
+						if err := sink.StartFunc(ctx); err != nil {
+							flush(ctx)
+							return err
+						}
+					}
+					started++
+				}
+				return nil
+			},
+			SinkFunc: func(in signal.Floating) error {
+				for _, sink := range allocated {
+					if err := sink.SinkFunc(in); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			FlushFunc: flush,
+		}, nil
+	}
+}
@@ -0,0 +1,73 @@
+package encode_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestTee(t *testing.T) {
+	samples := make([]float64, 100)
+	for i := range samples {
+		samples[i] = float64(i) / 100
+	}
+	type result struct {
+		samples []float64
+		flushed bool
+	}
+	tee := func(failStart, failSink int) ([]result, error) {
+		results := make([]result, 3)
+		sinks := make([]pipe.SinkAllocatorFunc, len(results))
+		for i := range sinks {
+			i := i
+			sinks[i] = func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+				return pipe.Sink{
+					StartFunc: func(context.Context) error {
+						if i == failStart {
+							return errors.New("start failed")
+						}
+						return nil
+					},
+					SinkFunc: func(in signal.Floating) error {
+						if i == failSink {
+							return errors.New("sink failed")
+						}
+						for j := 0; j < in.Len(); j++ {
+							results[i].samples = append(results[i].samples, in.Sample(j))
+						}
+						return nil
+					},
+					FlushFunc: func(context.Context) error {
+						results[i].flushed = true
+						return nil
+					},
+				}, nil
+			}
+		}
+		err := encode.Run(context.Background(), 16, 0, rampSource(samples), encode.Tee(sinks...))
+		return results, err
+	}
+
+	results, err := tee(-1, -1)
+	assert.NoError(t, err)
+	for _, r := range results {
+		assert.Equal(t, samples, r.samples)
+		assert.True(t, r.flushed)
+	}
+
+	// started sinks are flushed
+	results, err = tee(1, -1)
+	assert.Error(t, err)
+	assert.True(t, results[0].flushed)
+	assert.False(t, results[2].flushed)
+
+	_, err = tee(-1, 2)
+	assert.Error(t, err)
+}
@@ -0,0 +1,302 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/fft"
+)
+
+const (
+	// range of detected tempo in BPM, the search is weighted towards
+	// tempoCenter
+	minTempo    = 60
+	maxTempo    = 200
+	tempoCenter = 120
+	// framesPerSecond is the lowest number of analysis frames per second,
+	// frame size is the power of two that fits it
+	framesPerSecond = 24
+	// range of frequencies in Hz used for key detection
+	minKeyFrequency = 65
+	maxKeyFrequency = 2100
+	// onsetCompression is the factor of log compression of magnitudes
+	onsetCompression = 1000
+)
+
+var (
+	pitchClasses = [12]string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+	// key profiles of Krumhansl-Kessler starting from the tonic
+	majorProfile = [12]float64{6.35, 2.23, 3.48, 2.33, 4.38, 4.09, 2.52, 5.19, 2.39, 3.66, 2.29, 2.88}
+	minorProfile = [12]float64{6.33, 2.68, 3.52, 5.38, 2.60, 3.53, 2.54, 4.75, 3.98, 2.69, 3.34, 3.17}
+)
+
+type (
+	// TempoKey is the estimated tempo in BPM and musical key of the
+	// signal, e.g. "A minor". Zero values mean the estimation failed.
+	TempoKey struct {
+		Tempo float64 `json:"bpm,omitempty"`
+		Key   string  `json:"key,omitempty"`
+	}
+
+	// TempoKeyDetector estimates tempo and key of the signal written into
+	// its sink. Tempo is found with autocorrelation of spectral flux and
+	// key is matched with pitch class profiles. Estimation is available
+	// after the run is done.
+	TempoKeyDetector struct {
+		result TempoKey
+	}
+)
+
+// NewTempoKeyDetector returns the detector of tempo and key.
+func NewTempoKeyDetector() *TempoKeyDetector {
+	return &TempoKeyDetector{}
+}
+
+// Sink wraps the sink allocator to estimate tempo and key before the
+// signal is written. If fn is nil, the signal is analyzed only.
+func (d *TempoKeyDetector) Sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		var (
+			sink pipe.Sink
+			err  error
+		)
+		if fn != nil {
+			if sink, err = fn(mctx, bufferSize, props); err != nil {
+				return sink, err
+			}
+		}
+		d.result = TempoKey{}
+		sampleRate := float64(props.SampleRate)
+		size := 1
+		for float64(size) < sampleRate/framesPerSecond {
+			size <<= 1
+		}
+		a := newTempoKeyAnalysis(size, sampleRate)
+		sinkFn, flushFn := sink.SinkFunc, sink.FlushFunc
+		sink.SinkFunc = func(in signal.Floating) error {
+			a.write(in)
+			if sinkFn != nil {
+				return sinkFn(in)
+			}
+			return nil
+		}
+		sink.FlushFunc = func(ctx context.Context) error {
+			d.result = TempoKey{
+				Tempo: a.tempo(),
+				Key:   a.key(),
+			}
+			if flushFn != nil {
+				return flushFn(ctx)
+			}
+			return nil
+		}
+		return sink, nil
+	}
+}
+
+// TempoKey returns estimated tempo and key of the signal.
+func (d *TempoKeyDetector) TempoKey() TempoKey {
+	return d.result
+}
+
+// String returns short description of tempo and key.
+func (t TempoKey) String() string {
+	tempo, key := "unknown", "unknown"
+	if t.Tempo > 0 {
+		tempo = fmt.Sprintf("%.1f", t.Tempo)
+	}
+	if t.Key != "" {
+		key = t.Key
+	}
+	return fmt.Sprintf("tempo %s BPM, key %s", tempo, key)
+}
+
+// tempoKeyAnalysis accumulates spectral flux and chroma of overlapped
+// frames of mono signal.
+type tempoKeyAnalysis struct {
+	sampleRate float64
+	size, hop  int
+	window     []float64
+	// pitch class of every bin, negative if it's out of key range
+	classes []int
+	in      []float64
+	buf     []complex128
+	prev    []float64
+	onsets  []float64
+	chroma  [12]float64
+}
+
+func newTempoKeyAnalysis(size int, sampleRate float64) *tempoKeyAnalysis {
+	a := tempoKeyAnalysis{
+		sampleRate: sampleRate,
+		size:       size,
+		hop:        size / 4,
+		window:     make([]float64, size),
+		classes:    make([]int, size/2),
+		buf:        make([]complex128, size),
+	}
+	for i := range a.window {
+		a.window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	for k := range a.classes {
+		a.classes[k] = -1
+		f := float64(k) * sampleRate / float64(size)
+		if f < minKeyFrequency || f > maxKeyFrequency {
+			continue
+		}
+		midi := int(math.Round(12*math.Log2(f/440))) + 69
+		a.classes[k] = midi % 12
+	}
+	return &a
+}
+
+// write mixes the input to mono and analyzes all complete frames.
+func (a *tempoKeyAnalysis) write(in signal.Floating) {
+	channels := in.Channels()
+	for i := 0; i < in.Length(); i++ {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			sum += in.Sample(i*channels + c)
+		}
+		a.in = append(a.in, sum/float64(channels))
+	}
+	offset := 0
+	for ; len(a.in)-offset >= a.size; offset += a.hop {
+		a.frame(a.in[offset : offset+a.size])
+	}
+	a.in = append(a.in[:0], a.in[offset:]...)
+}
+
+// frame adds spectral flux and chroma of a single frame.
+func (a *tempoKeyAnalysis) frame(samples []float64) {
+	for i, v := range samples {
+		a.buf[i] = complex(v*a.window[i], 0)
+	}
+	fft.Transform(a.buf)
+	first := a.prev == nil
+	if first {
+		a.prev = make([]float64, len(a.classes))
+	}
+	var flux float64
+	for k, class := range a.classes {
+		re, im := real(a.buf[k]), imag(a.buf[k])
+		power := re*re + im*im
+		if class >= 0 {
+			a.chroma[class] += power
+		}
+		level := math.Log1p(onsetCompression * math.Sqrt(power))
+		if diff := level - a.prev[k]; diff > 0 {
+			flux += diff
+		}
+		a.prev[k] = level
+	}
+	if !first {
+		a.onsets = append(a.onsets, flux)
+	}
+}
+
+// tempo returns the tempo with the highest weighted autocorrelation of
+// onsets. The period is refined with multiples of the best lag. Zero is
+// returned if the signal is too short or has no onsets.
+func (a *tempoKeyAnalysis) tempo() float64 {
+	rate := a.sampleRate / float64(a.hop)
+	minLag := int(math.Floor(rate * 60 / maxTempo))
+	maxLag := int(math.Ceil(rate * 60 / minTempo))
+	if minLag < 1 || len(a.onsets) < 2*maxLag {
+		return 0
+	}
+	var mean float64
+	for _, v := range a.onsets {
+		mean += v
+	}
+	mean /= float64(len(a.onsets))
+	onsets := make([]float64, len(a.onsets))
+	for i, v := range a.onsets {
+		onsets[i] = v - mean
+	}
+	autocorrelation := func(lag int) float64 {
+		var sum float64
+		for i := lag; i < len(onsets); i++ {
+			sum += onsets[i] * onsets[i-lag]
+		}
+		return sum / float64(len(onsets)-lag)
+	}
+	best, bestScore := 0, 0.0
+	for lag := minLag; lag <= maxLag; lag++ {
+		octaves := math.Log2(rate * 60 / float64(lag) / tempoCenter)
+		if score := autocorrelation(lag) * math.Exp(-0.5*octaves*octaves); score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+	// average period of peaks near multiples of the best lag
+	var period float64
+	var n int
+	for m := 1; m <= 4 && (m*best+m)*2 <= len(onsets); m++ {
+		peak, peakValue := m*best, math.Inf(-1)
+		for lag := m*best - m; lag <= m*best+m; lag++ {
+			if v := autocorrelation(lag); v > peakValue {
+				peak, peakValue = lag, v
+			}
+		}
+		before, after := autocorrelation(peak-1), autocorrelation(peak+1)
+		offset := 0.0
+		if denom := before - 2*peakValue + after; denom < 0 {
+			offset = 0.5 * (before - after) / denom
+		}
+		period += (float64(peak) + offset) / float64(m)
+		n++
+	}
+	period /= float64(n)
+	return math.Round(rate*60/period*10) / 10
+}
+
+// key returns the key which profile correlates best with the chroma.
+// Empty string is returned if the signal has no energy in key range.
+func (a *tempoKeyAnalysis) key() string {
+	var total float64
+	for _, v := range a.chroma {
+		total += v
+	}
+	if total == 0 {
+		return ""
+	}
+	best, bestScore := "", math.Inf(-1)
+	for tonic := range pitchClasses {
+		if score := correlate(a.chroma, majorProfile, tonic); score > bestScore {
+			best, bestScore = pitchClasses[tonic]+" major", score
+		}
+		if score := correlate(a.chroma, minorProfile, tonic); score > bestScore {
+			best, bestScore = pitchClasses[tonic]+" minor", score
+		}
+	}
+	return best
+}
+
+// correlate returns Pearson correlation of chroma and the profile rotated
+// to the tonic.
+func correlate(chroma, profile [12]float64, tonic int) float64 {
+	var chromaMean, profileMean float64
+	for i := range chroma {
+		chromaMean += chroma[i] / 12
+		profileMean += profile[i] / 12
+	}
+	var sum, chromaSquares, profileSquares float64
+	for i := range chroma {
+		c, p := chroma[(i+tonic)%12]-chromaMean, profile[i]-profileMean
+		sum += c * p
+		chromaSquares += c * c
+		profileSquares += p * p
+	}
+	if chromaSquares == 0 {
+		return 0
+	}
+	return sum / math.Sqrt(chromaSquares*profileSquares)
+}
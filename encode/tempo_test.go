@@ -0,0 +1,55 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestTempoKeyDetector(t *testing.T) {
+	const sampleRate = 44100
+	format := fileformat.WAV()
+	detect := func(frames []float64) encode.TempoKey {
+		d := encode.NewTempoKeyDetector()
+		input := bytes.NewReader(stereoWAV(sampleRate, frames))
+		assert.NoError(t, encode.Run(context.Background(), 1024, 0, format.Source(input), d.Sink(nil)))
+		return d.TempoKey()
+	}
+	// clicks returns 20s of decaying 2 kHz bursts at tempo.
+	clicks := func(tempo float64) []float64 {
+		frames := make([]float64, 20*sampleRate)
+		beat := 60 / tempo * sampleRate
+		for i := range frames {
+			pos := math.Mod(float64(i), beat) / sampleRate
+			frames[i] = 0.5 * math.Exp(-pos*200) * math.Sin(2*math.Pi*2000*float64(i)/sampleRate)
+		}
+		return frames
+	}
+	// chord returns 10s of sines with provided frequencies.
+	chord := func(frequencies ...float64) []float64 {
+		frames := make([]float64, 10*sampleRate)
+		for i := range frames {
+			for _, f := range frequencies {
+				frames[i] += 0.2 * math.Sin(2*math.Pi*f*float64(i)/sampleRate)
+			}
+		}
+		return frames
+	}
+
+	for _, tempo := range []float64{90, 120, 128, 174} {
+		assert.InDelta(t, tempo, detect(clicks(tempo)).Tempo, 1, "tempo %v", tempo)
+	}
+	assert.Equal(t, "A minor", detect(chord(220, 261.63, 329.63)).Key)
+	assert.Equal(t, "C major", detect(chord(261.63, 329.63, 392)).Key)
+
+	// short and silent signals cannot be estimated
+	tk := detect(make([]float64, sampleRate))
+	assert.Equal(t, encode.TempoKey{}, tk)
+	assert.Equal(t, "tempo unknown BPM, key unknown", tk.String())
+}
@@ -0,0 +1,96 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// minThrottlePause is the shortest pause of throttled pipes. Busy time is
+// accumulated until its pause is long enough, so timers don't add much
+// overhead with small buffers.
+const minThrottlePause = 10 * time.Millisecond
+
+// dutyCycle is the share of time pipes are running, they are not paused
+// if it's 1. It's set by LimitCPU.
+var dutyCycle = 1.0
+
+// ParseCPULimit parses the share of all CPUs in percents with optional
+// unit, e.g. 50%.
+func ParseCPULimit(s string) (float64, error) {
+	v := strings.TrimSuffix(strings.TrimSpace(s), "%")
+	percent, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid cpu limit %q: must be in (0%%..100%%]", s)
+	}
+	return percent / 100, nil
+}
+
+// LimitCPU limits CPU time used by pipes to the share of all CPUs. The
+// number of threads running Go code is capped to the number of CPUs the
+// share amounts to, rounded up. The rest is enforced by pausing pipes
+// after every buffer, so they run only the duty cycle of time. It must be
+// called before any pipe is run. Returns the number of threads and the
+// duty cycle.
+func LimitCPU(share float64) (int, float64) {
+	cpus := share * float64(runtime.NumCPU())
+	procs := int(math.Ceil(cpus))
+	if procs < 1 {
+		procs = 1
+	}
+	runtime.GOMAXPROCS(procs)
+	dutyCycle = math.Min(cpus/float64(procs), 1)
+	return procs, dutyCycle
+}
+
+// throttle pauses the sink after every buffer, so the pipe runs only the
+// duty cycle of time. The time since the end of previous pause is the
+// time the pipe was busy, it's followed by the pause of proportional
+// length once it's at least minThrottlePause. The pause blocks the sink, so all stages are slowed down.
+func throttle(fn pipe.SinkAllocatorFunc, duty float64) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		sink, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return sink, err
+		}
+		var (
+			ctx     context.Context
+			resumed time.Time
+		)
+		startFn, sinkFn := sink.StartFunc, sink.SinkFunc
+		sink.StartFunc = func(c context.Context) error {
+			ctx, resumed = c, time.Now()
+			if startFn == nil {
+				return nil
+			}
+			return startFn(c)
+		}
+		sink.SinkFunc = func(in signal.Floating) error {
+			if err := sinkFn(in); err != nil {
+				return err
+			}
+			pause := time.Duration(float64(time.Since(resumed)) * (1 - duty) / duty)
+			if pause < minThrottlePause {
+				return nil
+			}
+			t := time.NewTimer(pause)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			resumed = time.Now()
+			return nil
+		}
+		return sink, nil
+	}
+}
@@ -0,0 +1,67 @@
+package encode_test
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestParseCPULimit(t *testing.T) {
+	tests := []struct {
+		s     string
+		share float64
+		fails bool
+	}{
+		{s: "50%", share: 0.5},
+		{s: " 12.5 % ", share: 0.125},
+		{s: "100", share: 1},
+		{s: "0%", fails: true},
+		{s: "150%", fails: true},
+		{s: "half", fails: true},
+	}
+	for _, test := range tests {
+		share, err := encode.ParseCPULimit(test.s)
+		if test.fails {
+			assert.Error(t, err, test.s)
+			continue
+		}
+		assert.NoError(t, err, test.s)
+		assert.Equal(t, test.share, share, test.s)
+	}
+}
+
+func TestLimitCPU(t *testing.T) {
+	maxProcs := runtime.GOMAXPROCS(0)
+	defer func() {
+		encode.LimitCPU(1)
+		runtime.GOMAXPROCS(maxProcs)
+	}()
+	procs, duty := encode.LimitCPU(0.5 / float64(runtime.NumCPU()))
+	assert.Equal(t, 1, procs)
+	assert.InDelta(t, 0.5, duty, 1e-9)
+
+	// every buffer keeps the sink busy for 10ms
+	var buffers int
+	sink := func(mctx mutable.Context, bufferSize int, p pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				buffers++
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			},
+		}, nil
+	}
+	start := time.Now()
+	err := encode.Run(context.Background(), 800, 0, encode.Synth(8000, 1, time.Second), sink)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, buffers)
+	assert.True(t, time.Since(start) >= 190*time.Millisecond, "elapsed: %v", time.Since(start))
+}
@@ -0,0 +1,108 @@
+package encode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+// silence is the position of sound in the signal. Frames before first
+// and after last are silent.
+type silence struct {
+	sampleRate signal.Frequency
+	frames     int
+	first      int
+	last       int
+}
+
+// CheckSilenceThreshold returns error if silence threshold is above full
+// scale or minimal duration is negative.
+func CheckSilenceThreshold(db float64, minDuration time.Duration) error {
+	if db > 0 || math.IsNaN(db) {
+		return fmt.Errorf("invalid silence threshold %v dBFS: must not be above 0", db)
+	}
+	if minDuration < 0 {
+		return fmt.Errorf("invalid silence duration %v: must not be negative", minDuration)
+	}
+	return nil
+}
+
+// TrimSilence returns the processor that drops leading and trailing
+// frames with all samples below threshold dBFS. Silence is dropped only
+// if it lasts at least minDuration. The input is decoded with provided
+// processors in the first pass to find the silence and then rewound.
+// Input without sound is not trimmed.
+func TrimSilence(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, thresholdDB float64, minDuration time.Duration, processors ...pipe.ProcessorAllocatorFunc) (pipe.ProcessorAllocatorFunc, error) {
+	if err := CheckSilenceThreshold(thresholdDB, minDuration); err != nil {
+		return nil, err
+	}
+	trim, err := AnalyzeInput(ctx, bufferSize, stallTimeout, format, input, []Pass{TrimSilencePass(thresholdDB, minDuration)}, processors...)
+	if err != nil {
+		return nil, err
+	}
+	return trim[0], nil
+}
+
+// TrimSilencePass returns the pass that finds leading and trailing
+// silence of the signal and drops it. Threshold must be checked with
+// CheckSilenceThreshold.
+func TrimSilencePass(thresholdDB float64, minDuration time.Duration) Pass {
+	s := silence{first: -1}
+	return Pass{
+		Sink: silenceSink(&s, math.Pow(10, thresholdDB/20)),
+		Processors: func() ([]pipe.ProcessorAllocatorFunc, error) {
+			return []pipe.ProcessorAllocatorFunc{s.trim(minDuration)}, nil
+		},
+	}
+}
+
+// trim returns the processor that drops silence that lasts at least
+// minDuration. Input without sound is not trimmed.
+func (s *silence) trim(minDuration time.Duration) pipe.ProcessorAllocatorFunc {
+	if s.first < 0 {
+		return processor.Trim(0, s.frames)
+	}
+	minFrames := int(minDuration.Seconds() * float64(s.sampleRate))
+	start, end := 0, s.frames
+	if s.first >= minFrames {
+		start = s.first
+	}
+	if s.frames-s.last-1 >= minFrames {
+		end = s.last + 1
+	}
+	return processor.Trim(start, end-start)
+}
+
+// silenceSink finds the first and the last frames with samples above
+// threshold.
+func silenceSink(s *silence, threshold float64) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		s.sampleRate = props.SampleRate
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				channels := in.Channels()
+				for i := 0; i < in.Len(); i++ {
+					if math.Abs(in.Sample(i)) <= threshold {
+						continue
+					}
+					frame := s.frames + i/channels
+					if s.first < 0 {
+						s.first = frame
+					}
+					s.last = frame
+				}
+				s.frames += in.Length()
+				return nil
+			},
+		}, nil
+	}
+}
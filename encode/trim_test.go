@@ -0,0 +1,63 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestTrimSilence(t *testing.T) {
+	assert.Error(t, encode.CheckSilenceThreshold(1, 0))
+	assert.Error(t, encode.CheckSilenceThreshold(-60, -time.Second))
+
+	// 1s of silence, 0.5s of sound with a pause and 0.2s of noise floor
+	const sampleRate = 1000
+	frames := make([]float64, 1700)
+	for i := 1000; i < 1500; i++ {
+		if i < 1200 || i >= 1300 {
+			frames[i] = 0.5
+		}
+	}
+	for i := 1500; i < 1700; i++ {
+		frames[i] = 0.0001
+	}
+	format := fileformat.WAV()
+	trim := func(db float64, minDuration time.Duration) []float64 {
+		input := bytes.NewReader(stereoWAV(sampleRate, frames))
+		processor, err := encode.TrimSilence(context.Background(), 64, 0, format, input, db, minDuration)
+		assert.NoError(t, err)
+		var out []float64
+		sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+			return pipe.Sink{
+				SinkFunc: func(in signal.Floating) error {
+					for i := 0; i < in.Len(); i += in.Channels() {
+						out = append(out, in.Sample(i))
+					}
+					return nil
+				},
+			}, nil
+		}
+		assert.NoError(t, encode.Run(context.Background(), 64, 0, format.Source(input), sink, processor))
+		return out
+	}
+
+	out := trim(-60, 0)
+	assert.Equal(t, 500, len(out))
+	assert.InDelta(t, 0.5, out[0], 0.001)
+	assert.InDelta(t, 0.5, out[len(out)-1], 0.001)
+	// noise floor is above threshold
+	assert.Equal(t, 700, len(trim(-90, 0)))
+	// tail is shorter than min duration
+	assert.Equal(t, 700, len(trim(-60, 500*time.Millisecond)))
+	// nothing is shorter than min duration
+	assert.Equal(t, 1700, len(trim(-60, 2*time.Second)))
+}
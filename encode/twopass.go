@@ -0,0 +1,92 @@
+package encode
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+)
+
+type (
+	// Pass is the analysis pass over the input. Sink measures the signal
+	// decoded with processors of preceding passes. Processors is called
+	// when the pass is done and returns processors based on the
+	// measurement, they are applied in following passes and in the
+	// processing pass. Sink is used once, so the pass can't be reused.
+	Pass struct {
+		Sink       pipe.SinkAllocatorFunc
+		Processors func() ([]pipe.ProcessorAllocatorFunc, error)
+	}
+
+	// Opener returns the source of the input from its start. It's called
+	// before every pass, so the input is rewound or opened again.
+	Opener func() (pipe.SourceAllocatorFunc, error)
+)
+
+// Rewind returns the opener that seeks to the start of the input before
+// every pass.
+func Rewind(format *fileformat.Format, input io.ReadSeeker) Opener {
+	return func() (pipe.SourceAllocatorFunc, error) {
+		if _, err := input.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return format.Source(input), nil
+	}
+}
+
+// Analyze runs analysis passes in order and returns their processors.
+// Every pass decodes the input with provided processors followed by
+// processors of preceding passes, so passes build on each other, e.g.
+// silence is trimmed before the loudness is measured.
+func Analyze(ctx context.Context, bufferSize int, stallTimeout time.Duration, open Opener, passes []Pass, processors ...pipe.ProcessorAllocatorFunc) ([]pipe.ProcessorAllocatorFunc, error) {
+	var analyzed []pipe.ProcessorAllocatorFunc
+	for _, pass := range passes {
+		source, err := open()
+		if err != nil {
+			return nil, err
+		}
+		applied := append(processors[:len(processors):len(processors)], analyzed...)
+		if err := Run(ctx, bufferSize, stallTimeout, source, pass.Sink, applied...); err != nil {
+			return nil, err
+		}
+		if pass.Processors == nil {
+			continue
+		}
+		result, err := pass.Processors()
+		if err != nil {
+			return nil, err
+		}
+		analyzed = append(analyzed, result...)
+	}
+	return analyzed, nil
+}
+
+// TwoPass runs analysis passes and then the processing pass into the
+// sink. The processing pass applies provided processors followed by
+// processors of all analysis passes.
+func TwoPass(ctx context.Context, bufferSize int, stallTimeout time.Duration, open Opener, sink pipe.SinkAllocatorFunc, passes []Pass, processors ...pipe.ProcessorAllocatorFunc) error {
+	analyzed, err := Analyze(ctx, bufferSize, stallTimeout, open, passes, processors...)
+	if err != nil {
+		return err
+	}
+	source, err := open()
+	if err != nil {
+		return err
+	}
+	return Run(ctx, bufferSize, stallTimeout, source, sink, append(processors[:len(processors):len(processors)], analyzed...)...)
+}
+
+// AnalyzeInput runs passes over the input and rewinds it, so it's ready
+// for the next run.
+func AnalyzeInput(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker, passes []Pass, processors ...pipe.ProcessorAllocatorFunc) ([]pipe.ProcessorAllocatorFunc, error) {
+	analyzed, err := Analyze(ctx, bufferSize, stallTimeout, Rewind(format, input), passes, processors...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return analyzed, nil
+}
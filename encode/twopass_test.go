@@ -0,0 +1,52 @@
+package encode_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestTwoPass(t *testing.T) {
+	samples := []float64{0, 0, 0.25, -0.5, 0.25, 0, 0}
+	open := func() (pipe.SourceAllocatorFunc, error) {
+		return rampSource(samples), nil
+	}
+	var result []float64
+	sink := func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				for i := 0; i < in.Len(); i++ {
+					result = append(result, in.Sample(i))
+				}
+				return nil
+			},
+		}, nil
+	}
+	// silence is trimmed before the peak is found
+	passes := []encode.Pass{
+		encode.TrimSilencePass(-60, 0),
+		encode.PeakNormalizePass(0),
+	}
+	err := encode.TwoPass(context.Background(), 2, 0, open, sink, passes)
+	assert.NoError(t, err)
+	assert.Len(t, result, 3)
+	peak := 0.0
+	for _, v := range result {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	assert.InDelta(t, 1, peak, 1e-9)
+
+	// errors of the source are returned
+	failed := func() (pipe.SourceAllocatorFunc, error) {
+		return nil, assert.AnError
+	}
+	err = encode.TwoPass(context.Background(), 2, 0, failed, sink, []encode.Pass{encode.PeakNormalizePass(0)})
+	assert.Equal(t, assert.AnError, err)
+}
@@ -0,0 +1,128 @@
+package encode
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Verification is the result of successful verification. Duration is in
+// seconds. Checksum is true if decoded samples are matched with the
+// checksum of the input.
+type Verification struct {
+	Duration float64 `json:"duration"`
+	Frames   int     `json:"frames"`
+	Checksum bool    `json:"checksum"`
+}
+
+// Verify fully decodes the input and checks the result. Decoded length of
+// wav and flac inputs is compared with their headers and flac samples are
+// matched with MD5 signature of the stream. Returned error has the code
+// of the problem. Input is seeked to the start when verification is done.
+func Verify(ctx context.Context, bufferSize int, stallTimeout time.Duration, format *fileformat.Format, input io.ReadSeeker) (Verification, error) {
+	h, err := expect(format, input)
+	if err != nil {
+		return Verification{}, &CodecError{Code: CodeCorruptHeader, Err: err}
+	}
+	var (
+		v      Verification
+		digest hash.Hash
+	)
+	if h.checksum != nil {
+		digest = md5.New()
+	}
+	sink := func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				v.Frames += in.Length()
+				if digest != nil {
+					writeSamples(digest, in, h.bitDepth)
+				}
+				return nil
+			},
+			FlushFunc: func(context.Context) error {
+				v.Duration = float64(v.Frames) / float64(props.SampleRate)
+				return nil
+			},
+		}, nil
+	}
+	if err := Run(ctx, bufferSize, stallTimeout, format.Source(input), sink); err != nil {
+		return Verification{}, err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return Verification{}, err
+	}
+	if h.frames > 0 && int64(v.Frames) < h.frames {
+		return Verification{}, &CodecError{
+			Code: CodeTruncatedStream,
+			Err:  fmt.Errorf("decoded %d of %d frames", v.Frames, h.frames),
+		}
+	}
+	if digest != nil {
+		if sum := digest.Sum(nil); !bytes.Equal(sum, h.checksum) {
+			return Verification{}, &CodecError{
+				Code: CodeChecksumMismatch,
+				Err:  fmt.Errorf("md5 of decoded samples %x doesn't match %x", sum, h.checksum),
+			}
+		}
+		v.Checksum = true
+	}
+	return v, nil
+}
+
+// String returns short description of the verification.
+func (v Verification) String() string {
+	if v.Checksum {
+		return fmt.Sprintf("ok %.2fs, md5 matched", v.Duration)
+	}
+	return fmt.Sprintf("ok %.2fs", v.Duration)
+}
+
+// expect reads the header of wav and flac inputs. Input is seeked to the
+// start.
+func expect(format *fileformat.Format, input io.ReadSeeker) (header, error) {
+	var (
+		h   header
+		err error
+	)
+	switch format {
+	case fileformat.WAV():
+		h, err = readWAVHeader(input)
+	case fileformat.FLAC():
+		h, err = readFLACHeader(input)
+	}
+	if _, serr := input.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	return h, err
+}
+
+// writeSamples writes samples converted to integers of bit depth in
+// little-endian byte order, as they are hashed by flac encoders.
+func writeSamples(w io.Writer, in signal.Floating, bitDepth signal.BitDepth) {
+	msv := float64(bitDepth.MaxSignedValue())
+	size := (int(bitDepth) + 7) / 8
+	buf := make([]byte, 0, in.Len()*size)
+	for i := 0; i < in.Len(); i++ {
+		var sample int64
+		if v := in.Sample(i); v > 0 {
+			sample = int64(math.Round(v * msv))
+		} else {
+			sample = int64(math.Round(v * (msv + 1)))
+		}
+		for b := 0; b < size; b++ {
+			buf = append(buf, byte(sample>>uint(8*b)))
+		}
+	}
+	w.Write(buf)
+}
@@ -0,0 +1,58 @@
+package encode_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+)
+
+func TestVerify(t *testing.T) {
+	wav, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	flac, err := ioutil.ReadFile("../_testdata/sample.flac")
+	assert.NoError(t, err)
+	verify := func(format *fileformat.Format, data []byte) (encode.Verification, error) {
+		input := bytes.NewReader(data)
+		v, err := encode.Verify(context.Background(), 512, 0, format, input)
+		if err == nil {
+			// input is rewound for the conversion
+			assert.Equal(t, int64(len(data)), int64(input.Len()))
+		}
+		return v, err
+	}
+	// modified returns the copy of data with byte at i inverted.
+	modified := func(data []byte, i int) []byte {
+		result := append([]byte{}, data...)
+		result[i] = ^result[i]
+		return result
+	}
+
+	v, err := verify(fileformat.WAV(), wav)
+	assert.NoError(t, err)
+	assert.InDelta(t, 7.5, v.Duration, 0.01)
+	assert.False(t, v.Checksum)
+	assert.Equal(t, "ok 7.50s", v.String())
+
+	_, err = verify(fileformat.WAV(), wav[:len(wav)/2])
+	assert.Equal(t, encode.CodeTruncatedStream, encode.Code(err))
+
+	_, err = verify(fileformat.WAV(), wav[:8])
+	assert.Equal(t, encode.CodeCorruptHeader, encode.Code(err))
+
+	v, err = verify(fileformat.FLAC(), flac)
+	assert.NoError(t, err)
+	assert.True(t, v.Checksum)
+
+	// md5 signature starts at 26th byte of the stream
+	_, err = verify(fileformat.FLAC(), modified(flac, 30))
+	assert.Equal(t, encode.CodeChecksumMismatch, encode.Code(err))
+
+	_, err = verify(fileformat.FLAC(), modified(flac, len(flac)/2))
+	assert.Equal(t, encode.CodeCorruptStream, encode.Code(err))
+}
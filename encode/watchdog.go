@@ -0,0 +1,121 @@
+package encode
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// ErrStalled is returned when the pipe makes no progress longer than
+// stall timeout.
+var ErrStalled = errors.New("conversion stalled")
+
+type (
+	// StallError contains diagnostics of the stalled pipe.
+	StallError struct {
+		// Stage that doesn't make progress: source or sink.
+		Stage string
+		// Frames read by source and written by sink.
+		Sourced, Sunk int64
+		// Idle is the time since last progress.
+		Idle time.Duration
+	}
+
+	// progress tracks the number of processed frames and time of the
+	// last progress in every stage. All fields are accessed atomically.
+	progress struct {
+		sourced    int64
+		sunk       int64
+		lastSource int64
+		lastSink   int64
+	}
+)
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("%v: %s idle for %v, frames sourced: %d sunk: %d", ErrStalled, e.Stage, e.Idle, e.Sourced, e.Sunk)
+}
+
+// Is allows to check the error with errors.Is(err, ErrStalled).
+func (e *StallError) Is(err error) bool {
+	return err == ErrStalled
+}
+
+func newProgress() *progress {
+	now := time.Now().UnixNano()
+	return &progress{
+		lastSource: now,
+		lastSink:   now,
+	}
+}
+
+// source wraps the source allocator to track read frames.
+func (p *progress) source(fn pipe.SourceAllocatorFunc) pipe.SourceAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int) (pipe.Source, error) {
+		s, err := fn(mctx, bufferSize)
+		if err != nil {
+			return s, err
+		}
+		sourceFn := s.SourceFunc
+		s.SourceFunc = func(out signal.Floating) (int, error) {
+			n, err := sourceFn(out)
+			if n > 0 {
+				atomic.AddInt64(&p.sourced, int64(n))
+				atomic.StoreInt64(&p.lastSource, time.Now().UnixNano())
+			}
+			return n, err
+		}
+		return s, nil
+	}
+}
+
+// sink wraps the sink allocator to track written frames.
+func (p *progress) sink(fn pipe.SinkAllocatorFunc) pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		s, err := fn(mctx, bufferSize, props)
+		if err != nil {
+			return s, err
+		}
+		sinkFn := s.SinkFunc
+		s.SinkFunc = func(in signal.Floating) error {
+			err := sinkFn(in)
+			atomic.AddInt64(&p.sunk, int64(in.Length()))
+			atomic.StoreInt64(&p.lastSink, time.Now().UnixNano())
+			return err
+		}
+		return s, nil
+	}
+}
+
+// touch records the progress of all stages without frames.
+func (p *progress) touch() {
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&p.lastSource, now)
+	atomic.StoreInt64(&p.lastSink, now)
+}
+
+// stalled returns error if no stage made progress longer than timeout.
+func (p *progress) stalled(timeout time.Duration) *StallError {
+	lastSource := atomic.LoadInt64(&p.lastSource)
+	lastSink := atomic.LoadInt64(&p.lastSink)
+	// stages are executed sequentially, the one that didn't pass the
+	// last buffer further is stuck
+	stage, last := "source", lastSink
+	if lastSource > lastSink {
+		stage, last = "sink", lastSource
+	}
+	idle := time.Since(time.Unix(0, last))
+	if idle <= timeout {
+		return nil
+	}
+	return &StallError{
+		Stage:   stage,
+		Sourced: atomic.LoadInt64(&p.sourced),
+		Sunk:    atomic.LoadInt64(&p.sunk),
+		Idle:    idle,
+	}
+}
@@ -0,0 +1,35 @@
+// Package fft provides fast Fourier transform of signal frames.
+package fft
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Transform transforms x in place with iterative radix-2 algorithm.
+// Length of x must be a power of two.
+func Transform(x []complex128) {
+	n := len(x)
+	// bit-reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
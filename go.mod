@@ -1,13 +1,18 @@
 module pipelined.dev/phono
 
-go 1.12
+go 1.16
 
 require (
 	github.com/hajimehoshi/go-mp3 v0.3.2 // indirect
+	github.com/lib/pq v1.10.4
 	github.com/mewkiz/pkg v0.0.0-20210604082325-6217eed0deab // indirect
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0
-	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.14.6
 	pipelined.dev/audio/fileformat v0.3.0
 	pipelined.dev/audio/flac v0.4.1 // indirect
 	pipelined.dev/audio/mp3 v0.6.1
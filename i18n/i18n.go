@@ -0,0 +1,237 @@
+// Package i18n translates messages of the web form and errors returned
+// to users. Messages are looked up by keys in catalogs of languages. The
+// catalog of the default language is complete, its messages are used if
+// the translation is missing. English, German and Russian catalogs are
+// embedded, deployments add languages or override messages with Register
+// or LoadDir.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the language of messages if the language of the user is
+// not supported.
+const Default = "en"
+
+// embedded are catalogs of built-in languages.
+//
+//go:embed catalogs
+var embedded embed.FS
+
+var registry = struct {
+	mu       sync.Mutex
+	catalogs map[string]Catalog
+}{catalogs: make(map[string]Catalog)}
+
+func init() {
+	if err := loadFS(embedded, "catalogs"); err != nil {
+		panic(err)
+	}
+}
+
+type (
+	// Catalog contains messages of the language by their keys. Messages
+	// can contain fmt verbs that are replaced with arguments.
+	Catalog map[string]string
+
+	// Error is the error with translated message. Error returns the
+	// message in the default language.
+	Error struct {
+		Key  string
+		Args []interface{}
+	}
+
+	// language is the language of Accept-Language header with its
+	// weight.
+	language struct {
+		tag    string
+		weight float64
+	}
+)
+
+// Register adds messages of the catalog to the language. Messages of
+// registered catalogs are replaced. Language is the primary tag or the
+// tag with region in lower case, e.g. de or pt-br.
+func Register(lang string, c Catalog) {
+	lang = strings.ToLower(lang)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	catalog, ok := registry.catalogs[lang]
+	if !ok {
+		catalog = make(Catalog, len(c))
+		registry.catalogs[lang] = catalog
+	}
+	for k, v := range c {
+		catalog[k] = v
+	}
+}
+
+// ReadCatalog reads the catalog in JSON format, keys are mapped to
+// messages.
+func ReadCatalog(r io.Reader) (Catalog, error) {
+	var c Catalog
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+	return c, nil
+}
+
+// LoadDir registers catalogs of the directory. Catalogs are JSON files
+// named after their languages, e.g. fr.json.
+func LoadDir(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("invalid translations directory: %w", err)
+	}
+	return loadFS(os.DirFS(dir), ".")
+}
+
+func loadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		f, err := fsys.Open(path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		c, err := ReadCatalog(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		Register(strings.TrimSuffix(e.Name(), ".json"), c)
+	}
+	return nil
+}
+
+// Languages returns registered languages in alphabetical order.
+func Languages() []string {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	langs := make([]string, 0, len(registry.catalogs))
+	for lang := range registry.catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Messages returns messages of the language with keys of the prefix,
+// the prefix is trimmed from returned keys. Missing translations are
+// taken from the default language.
+func Messages(lang, prefix string) Catalog {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	result := make(Catalog)
+	for _, l := range []string{Default, lang} {
+		for k, v := range registry.catalogs[l] {
+			if strings.HasPrefix(k, prefix) {
+				result[strings.TrimPrefix(k, prefix)] = v
+			}
+		}
+	}
+	return result
+}
+
+// T returns the message of the key in the language formatted with
+// arguments. The message of the default language is used if the
+// translation is missing, the key is returned if there is no message.
+func T(lang, key string, args ...interface{}) string {
+	registry.mu.Lock()
+	msg, ok := registry.catalogs[lang][key]
+	if !ok {
+		msg, ok = registry.catalogs[Default][key]
+	}
+	registry.mu.Unlock()
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Negotiate returns the registered language preferred in the value of
+// Accept-Language header. Regions fall back to their languages, e.g.
+// de-AT is served in de. Default language is returned if none of
+// preferred languages is registered.
+func Negotiate(acceptLanguage string) string {
+	var langs []language
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		l := language{tag: strings.ToLower(strings.TrimSpace(fields[0])), weight: 1}
+		for _, f := range fields[1:] {
+			if q := strings.TrimSpace(f); strings.HasPrefix(q, "q=") {
+				if w, err := strconv.ParseFloat(q[2:], 64); err == nil {
+					l.weight = w
+				}
+			}
+		}
+		if l.tag != "" && l.weight > 0 {
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].weight > langs[j].weight
+	})
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, l := range langs {
+		if _, ok := registry.catalogs[l.tag]; ok {
+			return l.tag
+		}
+		if i := strings.Index(l.tag, "-"); i > 0 {
+			if _, ok := registry.catalogs[l.tag[:i]]; ok {
+				return l.tag[:i]
+			}
+		}
+	}
+	return Default
+}
+
+// Request returns the language of the request negotiated with its
+// Accept-Language header.
+func Request(r *http.Request) string {
+	return Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// Errorf returns the error with the message of the key formatted with
+// arguments.
+func Errorf(key string, args ...interface{}) error {
+	return &Error{Key: key, Args: args}
+}
+
+func (e *Error) Error() string {
+	return T(Default, e.Key, e.Args...)
+}
+
+// Localize returns the message of the error in the language. Messages of
+// wrapped errors are translated in place, other errors are returned as
+// is.
+func Localize(lang string, err error) string {
+	msg := err.Error()
+	var e *Error
+	if lang == Default || !errors.As(err, &e) {
+		return msg
+	}
+	return strings.Replace(msg, e.Error(), T(lang, e.Key, e.Args...), 1)
+}
@@ -0,0 +1,70 @@
+package i18n_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/i18n"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                          i18n.Default,
+		"*":                         i18n.Default,
+		"ja":                        i18n.Default,
+		"de":                        "de",
+		"de-AT":                     "de",
+		"RU-ru":                     "ru",
+		"ja, ru;q=0.5, de;q=0.8":    "de",
+		"de;q=0, ru;q=0.1":          "ru",
+		"en-US,en;q=0.9,ru;q=0.8":   "en",
+		"ja-JP, ja;q=0.9, de;q=0.7": "de",
+	}
+	for header, expected := range tests {
+		assert.Equal(t, expected, i18n.Negotiate(header), header)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	for _, lang := range []string{"de", "en", "ru"} {
+		assert.Contains(t, i18n.Languages(), lang)
+	}
+	assert.Equal(t, "Dateien auswählen", i18n.T("de", "form.select_files"))
+	assert.Equal(t, "select files", i18n.T("ja", "form.select_files"))
+	assert.Equal(t, "missing.key", i18n.T("de", "missing.key"))
+	assert.Equal(t, "Format .wav ist doppelt ausgewählt", i18n.T("de", "error.output_twice", ".wav"))
+	assert.Equal(t, "загружается", i18n.Messages("ru", "script.")["uploading"])
+
+	err := fmt.Errorf("invalid form: %w", i18n.Errorf("error.tag_too_long", "title", 256))
+	assert.Equal(t, "invalid form: title exceeds 256 bytes", err.Error())
+	assert.Equal(t, err.Error(), i18n.Localize(i18n.Default, err))
+	assert.Equal(t, "invalid form: title превышает 256 байт", i18n.Localize("ru", err))
+	assert.Equal(t, "plain", i18n.Localize("ru", fmt.Errorf("plain")))
+}
+
+func TestLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-i18n")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fr.json"), []byte(`{"form.encode": "convertir"}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a catalog"), 0644))
+
+	assert.NoError(t, i18n.LoadDir(dir))
+	assert.Equal(t, "fr", i18n.Negotiate("fr-CH, de;q=0.7"))
+	assert.Equal(t, "convertir", i18n.T("fr", "form.encode"))
+	// missing translations fall back to default language
+	assert.Equal(t, "select files", i18n.T("fr", "form.select_files"))
+
+	i18n.Register("de", i18n.Catalog{"form.encode": "umwandeln"})
+	assert.Equal(t, "umwandeln", i18n.T("de", "form.encode"))
+	assert.Equal(t, "Dateien auswählen", i18n.T("de", "form.select_files"))
+
+	assert.Error(t, i18n.LoadDir(filepath.Join(dir, "missing")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "it.json"), []byte(`{"form.encode": `), 0644))
+	assert.Error(t, i18n.LoadDir(dir))
+}
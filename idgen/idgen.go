@@ -0,0 +1,51 @@
+// Package idgen provides generators of unique ids. Generators can be
+// replaced to get deterministic ids in tests or to integrate with
+// external id schemes.
+package idgen
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+)
+
+type (
+	// Generator generates unique ids. Ids must be safe to use in URL
+	// paths and file names.
+	Generator interface {
+		New() (string, error)
+	}
+
+	// Random generates hex-encoded random ids of 16 bytes.
+	Random struct{}
+
+	// Sequence generates ids with prefix and sequential number. It's safe
+	// for concurrent use.
+	Sequence struct {
+		Prefix string
+		n      int64
+	}
+)
+
+// New returns new random id.
+func (Random) New() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// New returns next id of the sequence.
+func (s *Sequence) New() (string, error) {
+	return fmt.Sprintf("%s%d", s.Prefix, atomic.AddInt64(&s.n, 1)), nil
+}
+
+// Or returns g if it's not nil and random generator otherwise.
+func Or(g Generator) Generator {
+	if g == nil {
+		return Random{}
+	}
+	return g
+}
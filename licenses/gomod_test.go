@@ -0,0 +1,61 @@
+package licenses
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// nonCodecs are modules of go.mod that don't decode or encode audio.
+var nonCodecs = map[string]bool{
+	"github.com/lib/pq":           true,
+	"github.com/spf13/cobra":      true,
+	"github.com/spf13/pflag":      true,
+	"github.com/stretchr/testify": true,
+	"golang.org/x/crypto":         true,
+	"golang.org/x/net":            true,
+	"gopkg.in/yaml.v3":            true,
+	"modernc.org/sqlite":          true,
+	"pipelined.dev/pipe":          true,
+	"pipelined.dev/signal":        true,
+}
+
+// TestGoMod fails if go.mod requires a module that is neither described
+// codec nor known to be something else, so the report is not stale.
+func TestGoMod(t *testing.T) {
+	f, err := os.Open("../go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	required := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "require (":
+			required = true
+			continue
+		case line == ")":
+			required = false
+			continue
+		case strings.HasPrefix(line, "require "):
+			line = strings.TrimPrefix(line, "require ")
+		case !required:
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		module := fields[0]
+		if _, ok := codecs[module]; !ok && !nonCodecs[module] {
+			t.Errorf("module %s is not described in codecs", module)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,10 @@
+package licenses
+
+// #cgo LDFLAGS: -lmp3lame
+// #include <lame/lame.h>
+import "C"
+
+// lameVersion returns the version of linked LAME library.
+func lameVersion() string {
+	return C.GoString(C.get_lame_version())
+}
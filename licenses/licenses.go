@@ -0,0 +1,133 @@
+// Package licenses reports codec libraries compiled into the binary, so
+// operators who distribute it can check their obligations.
+package licenses
+
+import (
+	"runtime/debug"
+)
+
+// Component is a codec library compiled into the binary.
+type Component struct {
+	// Name of the library.
+	Name string `json:"name"`
+	// Features provided by the library.
+	Features string `json:"features"`
+	// Module is the path of Go module. Native libraries are reported
+	// with the module that links them.
+	Module string `json:"module"`
+	// Version of the module or native library. Empty if unknown.
+	Version string `json:"version"`
+	// License is SPDX identifier of the license.
+	License string `json:"license"`
+	// Native is true for C libraries linked with cgo.
+	Native bool `json:"native"`
+	// Patents describes patent-encumbered features. Empty if there are
+	// none known.
+	Patents string `json:"patents,omitempty"`
+}
+
+// mp3Patents describes the status of mp3 patents.
+const mp3Patents = "MPEG-1/2 Audio Layer III, patents expired in 2017"
+
+// lame is the native mp3 encoder, it's linked by this package.
+var lame = Component{
+	Name:     "LAME",
+	Features: "mp3 encoding",
+	Module:   "github.com/viert/lame",
+	License:  "LGPL-2.0-or-later",
+	Native:   true,
+	Patents:  mp3Patents,
+}
+
+// codecs describes Go modules of codec libraries. Report lists the ones
+// found in the build info, so it follows the modules of the binary. New
+// codec modules must be described here, it's checked against go.mod.
+var codecs = map[string]Component{
+	"github.com/viert/lame": {
+		Name:     "viert/lame",
+		Features: "Go bindings of LAME",
+		License:  "MIT",
+	},
+	"github.com/hajimehoshi/go-mp3": {
+		Name:     "go-mp3",
+		Features: "mp3 decoding",
+		License:  "Apache-2.0",
+		Patents:  mp3Patents,
+	},
+	"github.com/mewkiz/flac": {
+		Name:     "flac",
+		Features: "flac decoding",
+		License:  "Unlicense",
+	},
+	"github.com/mewkiz/pkg": {
+		Name:     "mewkiz/pkg",
+		Features: "utilities of flac decoder",
+		License:  "Unlicense",
+	},
+	"github.com/icza/bitio": {
+		Name:     "bitio",
+		Features: "bit reader of flac decoder",
+		License:  "Apache-2.0",
+	},
+	"github.com/go-audio/wav": {
+		Name:     "wav",
+		Features: "wav decoding and encoding",
+		License:  "Apache-2.0",
+	},
+	"github.com/go-audio/riff": {
+		Name:     "riff",
+		Features: "riff container of wav",
+		License:  "Apache-2.0",
+	},
+	"github.com/go-audio/audio": {
+		Name:     "go-audio",
+		Features: "sample buffers of wav",
+		License:  "Apache-2.0",
+	},
+	"pipelined.dev/audio/mp3": {
+		Name:     "pipelined mp3",
+		Features: "mp3 pipe source and sink",
+		License:  "MIT",
+		Patents:  mp3Patents,
+	},
+	"pipelined.dev/audio/flac": {
+		Name:     "pipelined flac",
+		Features: "flac pipe source",
+		License:  "MIT",
+	},
+	"pipelined.dev/audio/wav": {
+		Name:     "pipelined wav",
+		Features: "wav pipe source and sink",
+		License:  "MIT",
+	},
+	"pipelined.dev/audio/fileformat": {
+		Name:     "fileformat",
+		Features: "format detection",
+		License:  "MIT",
+	},
+}
+
+// Report returns codec libraries compiled into the binary. Go modules are
+// taken from the build info, in its order, after the native libraries.
+func Report() []Component {
+	native := lame
+	native.Version = lameVersion()
+	report := []Component{native}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return report
+	}
+	for _, m := range info.Deps {
+		path := m.Path
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		c, ok := codecs[path]
+		if !ok {
+			continue
+		}
+		c.Module, c.Version = path, m.Version
+		report = append(report, c)
+	}
+	return report
+}
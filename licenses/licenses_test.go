@@ -0,0 +1,24 @@
+package licenses_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/licenses"
+)
+
+func TestReport(t *testing.T) {
+	report := licenses.Report()
+	assert.NotEmpty(t, report)
+	var lame licenses.Component
+	for _, c := range report {
+		assert.NotEmpty(t, c.License, c.Name)
+		if c.Native {
+			lame = c
+		}
+	}
+	assert.Equal(t, "LAME", lame.Name)
+	assert.NotEmpty(t, lame.Version)
+	assert.NotEmpty(t, lame.Patents)
+}
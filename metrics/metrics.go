@@ -0,0 +1,393 @@
+// Package metrics provides minimal instrumentation primitives written in
+// Prometheus text format or OpenMetrics format with exemplars. Metrics are
+// served by admin package, so instrumented packages don't depend on
+// net/http.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default registry is used by metrics constructors.
+var Default = NewRegistry()
+
+// labelSeparator joins label values into the key of series.
+const labelSeparator = "\xff"
+
+// Content types of supported exposition formats.
+const (
+	TextContentType        = "text/plain; version=0.0.4"
+	OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+type (
+	// Registry holds metrics and writes them in text format.
+	Registry struct {
+		mu      sync.Mutex
+		metrics []metric
+	}
+
+	metric interface {
+		write(w io.Writer, openMetrics bool)
+	}
+
+	// desc is a common description of metric.
+	desc struct {
+		name   string
+		help   string
+		kind   string
+		labels []string
+	}
+
+	// Counter is a monotonically increasing value.
+	Counter struct {
+		desc
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// Gauge is a value that can go up and down.
+	Gauge struct {
+		desc
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// Func is the counter or gauge without labels which value is read
+	// when metrics are written.
+	Func struct {
+		desc
+		fn func() float64
+	}
+
+	// Histogram counts observations in configurable buckets.
+	Histogram struct {
+		desc
+		buckets []float64
+		mu      sync.Mutex
+		series  map[string]*histogramSeries
+	}
+
+	histogramSeries struct {
+		counts []uint64
+		count  uint64
+		sum    float64
+		// exemplars of buckets, the last one is +Inf bucket
+		exemplars []*exemplar
+	}
+
+	// exemplar references the observation, e.g. by trace id.
+	exemplar struct {
+		labels map[string]string
+		value  float64
+		time   time.Time
+	}
+)
+
+// DefaultBuckets are histogram buckets suitable for request durations in
+// seconds.
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// NewRegistry creates empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter creates counter and registers it in default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		values: make(map[string]float64),
+	}
+	Default.register(c)
+	return c
+}
+
+// NewGauge creates gauge and registers it in default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{
+		desc:   desc{name: name, help: help, kind: "gauge", labels: labels},
+		values: make(map[string]float64),
+	}
+	Default.register(g)
+	return g
+}
+
+// NewCounterFunc creates counter which value is returned by fn and
+// registers it in default registry. Value must not decrease.
+func NewCounterFunc(name, help string, fn func() float64) *Func {
+	f := &Func{
+		desc: desc{name: name, help: help, kind: "counter"},
+		fn:   fn,
+	}
+	Default.register(f)
+	return f
+}
+
+// NewGaugeFunc creates gauge which value is returned by fn and registers
+// it in default registry.
+func NewGaugeFunc(name, help string, fn func() float64) *Func {
+	f := &Func{
+		desc: desc{name: name, help: help, kind: "gauge"},
+		fn:   fn,
+	}
+	Default.register(f)
+	return f
+}
+
+// NewHistogram creates histogram with provided upper bounds of buckets
+// and registers it in default registry. Buckets must be sorted.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	Default.register(h)
+	return h
+}
+
+// register adds metric to the registry.
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes all metrics in text format.
+func (r *Registry) Write(w io.Writer) {
+	r.write(w, false)
+}
+
+// WriteOpenMetrics writes all metrics in OpenMetrics format. Unlike text
+// format, it contains exemplars.
+func (r *Registry) WriteOpenMetrics(w io.Writer) {
+	r.write(w, true)
+	fmt.Fprint(w, "# EOF\n")
+}
+
+func (r *Registry) write(w io.Writer, openMetrics bool) {
+	r.mu.Lock()
+	metrics := append(r.metrics[:0:0], r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w, openMetrics)
+	}
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds non-negative value to the counter.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w, openMetrics)
+	writeValues(w, c.desc, c.values)
+}
+
+// Inc increments the gauge by one.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Add adds value to the gauge.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	g.values[key] += v
+	g.mu.Unlock()
+}
+
+// Set sets the value of the gauge.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	g.values[key] = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer, openMetrics bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w, openMetrics)
+	writeValues(w, g.desc, g.values)
+}
+
+func (f *Func) write(w io.Writer, openMetrics bool) {
+	f.header(w, openMetrics)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+// Observe adds observation to the histogram.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.ObserveWithExemplar(v, nil, labelValues...)
+}
+
+// ObserveWithExemplar adds observation to the histogram and sets it as
+// exemplar of the bucket. Exemplar labels usually contain trace_id, so
+// the slow observation can be linked to its trace. Exemplar isn't set if
+// labels are empty.
+func (h *Histogram) ObserveWithExemplar(v float64, exemplarLabels map[string]string, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{
+			counts:    make([]uint64, len(h.buckets)),
+			exemplars: make([]*exemplar, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	bucket := len(h.buckets)
+	for i := len(h.buckets) - 1; i >= 0; i-- {
+		if v <= h.buckets[i] {
+			s.counts[i]++
+			bucket = i
+		}
+	}
+	s.count++
+	s.sum += v
+	if len(exemplarLabels) > 0 {
+		s.exemplars[bucket] = &exemplar{
+			labels: exemplarLabels,
+			value:  v,
+			time:   time.Now(),
+		}
+	}
+}
+
+func (h *Histogram) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w, openMetrics)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		values := splitKey(key, len(h.labels))
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d", h.name, formatLabels(h.labels, values, "le", formatFloat(bound)), s.counts[i])
+			writeExemplar(w, s.exemplars[i], openMetrics)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d", h.name, formatLabels(h.labels, values, "le", "+Inf"), s.count)
+		writeExemplar(w, s.exemplars[len(h.buckets)], openMetrics)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// key validates label values and joins them.
+func (d desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", d.name, len(d.labels), len(labelValues)))
+	}
+	return strings.Join(labelValues, labelSeparator)
+}
+
+// header writes metric description. OpenMetrics counters are described
+// without _total suffix.
+func (d desc) header(w io.Writer, openMetrics bool) {
+	name := d.name
+	if openMetrics && d.kind == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, d.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, d.kind)
+}
+
+// writeExemplar ends the sample line. Exemplar is written only in
+// OpenMetrics format.
+func writeExemplar(w io.Writer, e *exemplar, openMetrics bool) {
+	if !openMetrics || e == nil {
+		fmt.Fprint(w, "\n")
+		return
+	}
+	names := make([]string, 0, len(e.labels))
+	for name := range e.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, e.labels[name])
+	}
+	ts := float64(e.time.UnixNano()) / float64(time.Second)
+	fmt.Fprintf(w, " # %s %s %s\n", formatLabels(names, values), formatFloat(e.value), strconv.FormatFloat(ts, 'f', 3, 64))
+}
+
+func writeValues(w io.Writer, d desc, values map[string]float64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", d.name, formatLabels(d.labels, splitKey(key, len(d.labels))), formatFloat(values[key]))
+	}
+}
+
+func sortedKeys(m map[string]*histogramSeries) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string, labels int) []string {
+	if labels == 0 {
+		return nil
+	}
+	return strings.Split(key, labelSeparator)
+}
+
+// formatLabels formats label pairs. Extra pairs can be provided as
+// name, value sequence.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", name, strconv.Quote(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extra[i], strconv.Quote(extra[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,80 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	c := metrics.NewCounter("test_requests_total", "Test requests.", "code")
+	c.Inc("200")
+	c.Add(2, "500")
+	g := metrics.NewGauge("test_in_flight", "Test in flight.")
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	h := metrics.NewHistogram("test_duration_seconds", "Test duration.", []float64{1, 10})
+	h.Observe(0.5)
+	h.Observe(5)
+	metrics.NewGaugeFunc("test_queue_size", "Test queue size.", func() float64 { return 3 })
+
+	var b strings.Builder
+	metrics.Default.Write(&b)
+	body := b.String()
+
+	for _, expected := range []string{
+		"# TYPE test_requests_total counter\n",
+		`test_requests_total{code="200"} 1` + "\n",
+		`test_requests_total{code="500"} 2` + "\n",
+		"test_in_flight 1\n",
+		"# TYPE test_duration_seconds histogram\n",
+		`test_duration_seconds_bucket{le="1"} 1` + "\n",
+		`test_duration_seconds_bucket{le="10"} 2` + "\n",
+		`test_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+		"test_duration_seconds_sum 5.5\n",
+		"test_duration_seconds_count 2\n",
+		"# TYPE test_queue_size gauge\n",
+		"test_queue_size 3\n",
+		"# TYPE phono_gc_cycles_total counter\n",
+	} {
+		assert.True(t, strings.Contains(body, expected), "missing %q in:\n%s", expected, body)
+	}
+}
+
+func TestLabelsMismatch(t *testing.T) {
+	c := metrics.NewCounter("test_mismatch_total", "Test mismatch.", "code")
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "200") })
+}
+
+func TestOpenMetrics(t *testing.T) {
+	c := metrics.NewCounter("test_exemplar_requests_total", "Test requests.")
+	c.Inc()
+	h := metrics.NewHistogram("test_exemplar_duration_seconds", "Test duration.", []float64{1, 10})
+	h.ObserveWithExemplar(5, map[string]string{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"})
+	h.Observe(0.5)
+
+	var b strings.Builder
+	metrics.Default.WriteOpenMetrics(&b)
+	body := b.String()
+
+	assert.True(t, strings.HasSuffix(body, "# EOF\n"))
+	for _, expected := range []string{
+		"# TYPE test_exemplar_requests counter\n",
+		"test_exemplar_requests_total 1\n",
+		`test_exemplar_duration_seconds_bucket{le="1"} 1` + "\n",
+		`test_exemplar_duration_seconds_bucket{le="10"} 2 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 5 `,
+		`test_exemplar_duration_seconds_bucket{le="+Inf"} 2` + "\n",
+	} {
+		assert.True(t, strings.Contains(body, expected), "missing %q in:\n%s", expected, body)
+	}
+
+	// exemplars are not exposed in text format
+	b.Reset()
+	metrics.Default.Write(&b)
+	assert.False(t, strings.Contains(b.String(), "trace_id"))
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// memStatsTTL is the time while memory statistics are reused, so metrics
+// of a single scrape stop the world once.
+const memStatsTTL = time.Second
+
+var memStats = struct {
+	sync.Mutex
+	stats runtime.MemStats
+	read  time.Time
+}{}
+
+func init() {
+	NewCounterFunc("phono_gc_pause_seconds_total", "Total duration of garbage collection pauses.", func() float64 {
+		return float64(readMemStats().PauseTotalNs) / float64(time.Second)
+	})
+	NewCounterFunc("phono_gc_cycles_total", "Number of completed garbage collection cycles.", func() float64 {
+		return float64(readMemStats().NumGC)
+	})
+	NewCounterFunc("phono_heap_allocations_total", "Number of heap objects allocated.", func() float64 {
+		return float64(readMemStats().Mallocs)
+	})
+	NewGaugeFunc("phono_heap_alloc_bytes", "Size of allocated heap objects.", func() float64 {
+		return float64(readMemStats().HeapAlloc)
+	})
+}
+
+// readMemStats returns memory statistics read less than TTL ago or reads
+// them again.
+func readMemStats() runtime.MemStats {
+	memStats.Lock()
+	defer memStats.Unlock()
+	if time.Since(memStats.read) > memStatsTTL {
+		runtime.ReadMemStats(&memStats.stats)
+		memStats.read = time.Now()
+	}
+	return memStats.stats
+}
@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKeyHeader is the header with API key. Key can be also provided as
+// bearer token in Authorization header or as the password of basic
+// authentication, so WebDAV clients can use it.
+const APIKeyHeader = "X-API-Key"
+
+var (
+	errAPIKeyMissing = errors.New("api key is required")
+	errAPIKeyInvalid = errors.New("invalid api key")
+	errQuotaExceeded = errors.New("daily quota exceeded")
+	errRateLimited   = errors.New("rate limit exceeded")
+)
+
+type (
+	// APIKey is the key of API client with its limits. Zero limits mean no
+	// limit. Rate is the number of requests per second, Burst is the max
+	// number of requests at once.
+	APIKey struct {
+		Key        string  `json:"key"`
+		Name       string  `json:"name"`
+		DailyBytes int64   `json:"daily_bytes"`
+		Rate       float64 `json:"rate"`
+		Burst      int     `json:"burst"`
+	}
+
+	// Auth authenticates requests with API keys. Key is required for
+	// requests that modify the state, unless they are allowed by guest
+	// middleware, and optional for GET and HEAD requests, but if it's
+	// provided, it must be valid. Bytes of request bodies are counted
+	// against daily quota of the key.
+	Auth struct {
+		mu   sync.Mutex
+		keys map[string]*client
+	}
+
+	client struct {
+		APIKey
+		day    string
+		used   int64
+		bucket *tokenBucket
+	}
+
+	// clientKey is the context key of authenticated client.
+	clientKey struct{}
+
+	// quotaReader counts request body bytes against the quota.
+	quotaReader struct {
+		io.ReadCloser
+		auth   *Auth
+		client *client
+	}
+)
+
+// ReadAPIKeys reads API keys in JSON format.
+func ReadAPIKeys(r io.Reader) ([]APIKey, error) {
+	var keys []APIKey
+	if err := json.NewDecoder(r).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("failed to read api keys: %w", err)
+	}
+	return keys, nil
+}
+
+// ParseAPIKeys parses comma-separated list of API keys without limits.
+// It's used to provide keys via environment variable.
+func ParseAPIKeys(s string) []APIKey {
+	var keys []APIKey
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, APIKey{Key: key})
+		}
+	}
+	return keys
+}
+
+// NewAuth creates authentication middleware with provided keys.
+func NewAuth(keys []APIKey) *Auth {
+	a := Auth{
+		keys: make(map[string]*client, len(keys)),
+	}
+	for _, key := range keys {
+		c := client{APIKey: key}
+		if key.Rate > 0 {
+			c.bucket = newTokenBucket(key.Rate, key.Burst)
+		}
+		a.keys[key.Key] = &c
+	}
+	return &a
+}
+
+// Handler authenticates requests before passing them to h.
+func (a *Auth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := requestAPIKey(r)
+		if key == "" {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || isGuest(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, errAPIKeyMissing.Error(), http.StatusUnauthorized)
+			return
+		}
+		c, ok := a.keys[key]
+		if !ok {
+			http.Error(w, errAPIKeyInvalid.Error(), http.StatusUnauthorized)
+			return
+		}
+		if wait, err := a.allow(c, r.ContentLength); err != nil {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if c.DailyBytes > 0 && r.Body != nil {
+			r.Body = &quotaReader{ReadCloser: r.Body, auth: a, client: c}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientKey{}, c)))
+	})
+}
+
+// allow checks the limits of the client. If request is not allowed, the
+// time to wait is returned.
+func (a *Auth) allow(c *client, contentLength int64) (time.Duration, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now().UTC()
+	if c.DailyBytes > 0 {
+		c.reset(now)
+		if c.used >= c.DailyBytes || (contentLength > 0 && c.used+contentLength > c.DailyBytes) {
+			return untilTomorrow(now), errQuotaExceeded
+		}
+	}
+	if c.bucket != nil {
+		if wait, ok := c.bucket.take(now); !ok {
+			return wait, errRateLimited
+		}
+	}
+	return 0, nil
+}
+
+// valid returns true if key is known. Nil auth has no keys.
+func (a *Auth) valid(key string) bool {
+	if a == nil {
+		return false
+	}
+	_, ok := a.keys[key]
+	return ok
+}
+
+// reset starts new quota period if the day has changed.
+func (c *client) reset(now time.Time) {
+	if day := now.Format("2006-01-02"); day != c.day {
+		c.day = day
+		c.used = 0
+	}
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.auth.mu.Lock()
+	defer r.auth.mu.Unlock()
+	r.client.reset(time.Now().UTC())
+	r.client.used += int64(n)
+	if r.client.used > r.client.DailyBytes {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// Client returns the name of authenticated API key or the key itself if
+// it has no name. Empty string is returned for anonymous requests.
+func Client(r *http.Request) string {
+	c, ok := r.Context().Value(clientKey{}).(*client)
+	if !ok {
+		return ""
+	}
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Key
+}
+
+// BasicChallenge asks clients of h to authenticate with basic scheme
+// when they are not authorized. Clients that mount the share, e.g.
+// WebDAV, send credentials only after the challenge. User name is
+// ignored, API key is the password.
+func BasicChallenge(realm string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(&challengeWriter{ResponseWriter: w, realm: realm}, r)
+	})
+}
+
+// challengeWriter sets the challenge header of unauthorized responses.
+type challengeWriter struct {
+	http.ResponseWriter
+	realm string
+}
+
+func (w *challengeWriter) WriteHeader(code int) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", w.realm))
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// requestAPIKey returns the key from API key or Authorization header.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	const bearer = "Bearer "
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, bearer) {
+		return strings.TrimPrefix(auth, bearer)
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+func untilTomorrow(now time.Time) time.Duration {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
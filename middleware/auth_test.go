@@ -0,0 +1,81 @@
+package middleware_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/middleware"
+)
+
+func TestAuth(t *testing.T) {
+	keys, err := middleware.ReadAPIKeys(strings.NewReader(`[
+		{"key": "limited", "name": "team", "daily_bytes": 10},
+		{"key": "rated", "rate": 0.001, "burst": 1}
+	]`))
+	assert.NoError(t, err)
+	keys = append(keys, middleware.ParseAPIKeys("env-key, ")...)
+	assert.Equal(t, 3, len(keys))
+
+	h := middleware.NewAuth(keys).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, middleware.Client(r))
+	}))
+	request := func(method, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(middleware.APIKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "wrong", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "", "").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "env-key", "body").Code)
+
+	// client is the name of key or the key itself
+	assert.Equal(t, "", request(http.MethodGet, "", "").Body.String())
+	assert.Equal(t, "env-key", request(http.MethodPost, "env-key", "").Body.String())
+	assert.Equal(t, "team", request(http.MethodGet, "limited", "").Body.String())
+
+	// quota
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "limited", "12345").Code)
+	rr := request(http.MethodPost, "limited", "123456")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Retry-After"))
+
+	// rate
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "rated", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request(http.MethodPost, "rated", "").Code)
+
+	// bearer token
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Authorization", "Bearer env-key")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// basic authentication is challenged
+	challenged := middleware.BasicChallenge("phono", h)
+	rr = httptest.NewRecorder()
+	challenged.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, `Basic realm="phono"`, rr.Header().Get("WWW-Authenticate"))
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.SetBasicAuth("daw", "env-key")
+	rr = httptest.NewRecorder()
+	challenged.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("WWW-Authenticate"))
+}
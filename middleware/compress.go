@@ -0,0 +1,138 @@
+// Package middleware provides http handlers wrappers shared by the server
+// endpoints.
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Supported content encodings.
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+type (
+	// compressWriter compresses the response body.
+	compressWriter struct {
+		http.ResponseWriter
+		encoding string
+		w        io.WriteCloser
+	}
+)
+
+// Compress compresses responses with gzip or deflate encoding, whichever
+// is preferred by the client in Accept-Encoding header. It's intended for
+// metadata endpoints, audio responses are already compressed.
+func Compress(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := compressWriter{
+			ResponseWriter: w,
+			encoding:       encoding,
+		}
+		defer cw.Close()
+		h.ServeHTTP(&cw, r)
+	})
+}
+
+// acceptedEncoding returns supported encoding with the highest quality
+// value. Gzip is preferred if qualities are equal.
+func acceptedEncoding(header string) string {
+	var (
+		best    string
+		bestQ   float64
+		encoded = map[string]bool{encodingGzip: true, encodingDeflate: true}
+	)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if !encoded[name] {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+		if q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == encodingGzip) {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// WriteHeader sets encoding headers before the status is sent. Bodyless
+// responses are not compressed.
+func (cw *compressWriter) WriteHeader(status int) {
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.encoding = ""
+	}
+	cw.init()
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.init()
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.w.Write(p)
+}
+
+// Flush sends compressed data buffered so far to the client.
+func (cw *compressWriter) Flush() {
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close flushes compressed data.
+func (cw *compressWriter) Close() error {
+	if cw.w == nil {
+		return nil
+	}
+	return cw.w.Close()
+}
+
+// init creates compressor once, before the first write.
+func (cw *compressWriter) init() {
+	if cw.w != nil || cw.encoding == "" {
+		return
+	}
+	header := cw.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", cw.encoding)
+	switch cw.encoding {
+	case encodingGzip:
+		cw.w = gzip.NewWriter(cw.ResponseWriter)
+	case encodingDeflate:
+		// deflate content coding is zlib format, not raw deflate
+		cw.w = zlib.NewWriter(cw.ResponseWriter)
+	}
+	// compressor is created, headers must not be changed anymore
+	cw.encoding = ""
+}
@@ -0,0 +1,56 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/middleware"
+)
+
+func TestCompress(t *testing.T) {
+	const body = `{"formats":[".wav",".mp3",".flac"]}`
+	h := middleware.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	testCompress := func(acceptEncoding, expectedEncoding string) func(*testing.T) {
+		return func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+			assert.Equal(t, expectedEncoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+
+			var reader io.Reader
+			switch expectedEncoding {
+			case "gzip":
+				gr, err := gzip.NewReader(rr.Body)
+				assert.NoError(t, err)
+				reader = gr
+			case "deflate":
+				zr, err := zlib.NewReader(rr.Body)
+				assert.NoError(t, err)
+				reader = zr
+			default:
+				reader = rr.Body
+			}
+			b, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, body, string(b))
+		}
+	}
+	t.Run("gzip", testCompress("gzip, deflate", "gzip"))
+	t.Run("deflate", testCompress("deflate", "deflate"))
+	t.Run("deflate preferred", testCompress("gzip;q=0.5, deflate", "deflate"))
+	t.Run("not acceptable", testCompress("gzip;q=0", ""))
+	t.Run("identity", testCompress("", ""))
+	t.Run("unsupported", testCompress("br", ""))
+}
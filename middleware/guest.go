@@ -0,0 +1,331 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/idgen"
+)
+
+const (
+	// GuestCookie is the name of cookie with guest session id.
+	GuestCookie = "phono-guest"
+	// GuestPath is the path of the page where anonymous users solve the
+	// CAPTCHA to start the guest session.
+	GuestPath = "/guest"
+)
+
+var (
+	errGuestRequired    = errors.New("api key or guest session is required, solve the captcha at " + GuestPath)
+	errCaptchaMissing   = errors.New("captcha response is missing")
+	errCaptchaFailed    = errors.New("captcha verification failed")
+	errGuestQuotaBytes  = errors.New("daily guest quota exceeded")
+	errGuestConversions = errors.New("daily guest conversions exceeded")
+)
+
+// captchaFields are the form fields with CAPTCHA response of common
+// providers: reCAPTCHA, hCaptcha and Turnstile.
+var captchaFields = []string{"g-recaptcha-response", "h-captcha-response", "cf-turnstile-response", "captcha-response"}
+
+var guestTemplate = template.Must(template.New("guest").Parse(guestHTML))
+
+type (
+	// Verifier verifies the CAPTCHA response of the client.
+	Verifier interface {
+		Verify(ctx context.Context, response, remoteIP string) error
+	}
+
+	// VerifierFunc allows to use ordinary functions as verifiers.
+	VerifierFunc func(ctx context.Context, response, remoteIP string) error
+
+	// SiteVerifier verifies responses with siteverify API, supported by
+	// reCAPTCHA, hCaptcha and Turnstile. Default client is used if Client
+	// is nil.
+	SiteVerifier struct {
+		URL    string
+		Secret string
+		Client *http.Client
+	}
+
+	// Guest allows anonymous clients to modify the state after they solve
+	// the CAPTCHA. Solved CAPTCHA starts the guest session that lasts for
+	// TTL. Guest requests are limited per client IP with daily quotas of
+	// request bytes and conversions, zero quotas mean no limit. Requests
+	// with API key of Auth are passed as is, other keys are ignored.
+	// Widget is the HTML of CAPTCHA widget rendered on the guest page.
+	// Auth and Clock can be set before the first use.
+	Guest struct {
+		Auth             *Auth
+		Clock            clock.Clock
+		Widget           template.HTML
+		DailyBytes       int64
+		DailyConversions int
+		verifier         Verifier
+		ttl              time.Duration
+
+		mu       sync.Mutex
+		sessions map[string]time.Time
+		quotas   map[string]*guestQuota
+	}
+
+	guestQuota struct {
+		day         string
+		used        int64
+		conversions int
+	}
+
+	// guestKey is the context key of guest requests.
+	guestKey struct{}
+
+	// guestReader counts request body bytes against the guest quota.
+	guestReader struct {
+		io.ReadCloser
+		guest *Guest
+		quota *guestQuota
+	}
+)
+
+// Verify calls f(ctx, response, remoteIP).
+func (f VerifierFunc) Verify(ctx context.Context, response, remoteIP string) error {
+	return f(ctx, response, remoteIP)
+}
+
+// Verify sends the response to siteverify URL.
+func (v SiteVerifier) Verify(ctx context.Context, response, remoteIP string) error {
+	form := url.Values{
+		"secret":   {v.Secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	}
+	r, err := http.NewRequest(http.MethodPost, v.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to verify captcha: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to read captcha verification: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", errCaptchaFailed, strings.Join(result.Errors, ", "))
+	}
+	return nil
+}
+
+// NewGuest creates guest middleware. Sessions started with verified
+// CAPTCHA last for ttl.
+func NewGuest(verifier Verifier, ttl time.Duration) *Guest {
+	return &Guest{
+		verifier: verifier,
+		ttl:      ttl,
+		sessions: make(map[string]time.Time),
+		quotas:   make(map[string]*guestQuota),
+	}
+}
+
+// Handler serves the guest page and checks guest sessions of anonymous
+// requests before passing them to h. GET and HEAD requests are not
+// limited.
+func (g *Guest) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == GuestPath {
+			g.serveGuest(w, r)
+			return
+		}
+		if g.Auth.valid(requestAPIKey(r)) || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !g.hasSession(r) {
+			http.Error(w, errGuestRequired.Error(), http.StatusUnauthorized)
+			return
+		}
+		quota, wait, err := g.allow(clientIP(r), r.Method == http.MethodPost, r.ContentLength)
+		if err != nil {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if g.DailyBytes > 0 && r.Body != nil {
+			r.Body = &guestReader{ReadCloser: r.Body, guest: g, quota: quota}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), guestKey{}, true)))
+	})
+}
+
+// serveGuest renders the page with CAPTCHA widget and starts the session
+// when the form is submitted with verified response.
+func (g *Guest) serveGuest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := guestTemplate.Execute(w, g.Widget); err != nil {
+			log.Printf("Failed to render guest page: %v", err)
+		}
+	case http.MethodPost:
+		response := ""
+		for _, field := range captchaFields {
+			if response = r.PostFormValue(field); response != "" {
+				break
+			}
+		}
+		if response == "" {
+			http.Error(w, errCaptchaMissing.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := g.verifier.Verify(r.Context(), response, clientIP(r)); err != nil {
+			log.Printf("Guest verification failed: %v", err)
+			http.Error(w, errCaptchaFailed.Error(), http.StatusForbidden)
+			return
+		}
+		id, err := g.startSession()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to start guest session: %v", err), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     GuestCookie,
+			Value:    id,
+			Path:     "/",
+			MaxAge:   int(g.ttl / time.Second),
+			Secure:   r.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// startSession returns the id of new session and removes expired ones.
+func (g *Guest) startSession() (string, error) {
+	id, err := (idgen.Random{}).New()
+	if err != nil {
+		return "", err
+	}
+	now := g.now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id, expires := range g.sessions {
+		if now.After(expires) {
+			delete(g.sessions, id)
+		}
+	}
+	g.sessions[id] = now.Add(g.ttl)
+	return id, nil
+}
+
+// hasSession returns true if request has not expired guest session.
+func (g *Guest) hasSession(r *http.Request) bool {
+	c, err := r.Cookie(GuestCookie)
+	if err != nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expires, ok := g.sessions[c.Value]
+	return ok && !g.now().After(expires)
+}
+
+// allow checks the quotas of client IP. If request is not allowed, the
+// time to wait is returned.
+func (g *Guest) allow(ip string, conversion bool, contentLength int64) (*guestQuota, time.Duration, error) {
+	now := g.now().UTC()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	q := g.quota(ip, now)
+	if g.DailyBytes > 0 && (q.used >= g.DailyBytes || (contentLength > 0 && q.used+contentLength > g.DailyBytes)) {
+		return nil, untilTomorrow(now), errGuestQuotaBytes
+	}
+	if conversion && g.DailyConversions > 0 {
+		if q.conversions >= g.DailyConversions {
+			return nil, untilTomorrow(now), errGuestConversions
+		}
+		q.conversions++
+	}
+	return q, 0, nil
+}
+
+// quota returns the quota of IP for the current day. Quotas of previous
+// days are removed. Must be called under lock.
+func (g *Guest) quota(ip string, now time.Time) *guestQuota {
+	day := now.Format("2006-01-02")
+	q, ok := g.quotas[ip]
+	if ok && q.day == day {
+		return q
+	}
+	for ip, q := range g.quotas {
+		if q.day != day {
+			delete(g.quotas, ip)
+		}
+	}
+	q = &guestQuota{day: day}
+	g.quotas[ip] = q
+	return q
+}
+
+func (g *Guest) now() time.Time {
+	return clock.Or(g.Clock).Now()
+}
+
+func (r *guestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.guest.mu.Lock()
+	defer r.guest.mu.Unlock()
+	r.quota.used += int64(n)
+	if r.quota.used > r.guest.DailyBytes {
+		return n, errGuestQuotaBytes
+	}
+	return n, err
+}
+
+// isGuest returns true if request is allowed by guest middleware.
+func isGuest(r *http.Request) bool {
+	guest, _ := r.Context().Value(guestKey{}).(bool)
+	return guest
+}
+
+const guestHTML = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>phono guest access</title>
+	<style>
+		body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+	</style>
+</head>
+<body>
+	<h1>Guest access</h1>
+	<p>Confirm that you are not a robot to convert files without an API key.</p>
+	<form method="post" action="/guest">
+		{{.}}
+		<p><input type="submit" value="Continue"></p>
+	</form>
+</body>
+</html>
+`
@@ -0,0 +1,118 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/middleware"
+)
+
+func TestGuest(t *testing.T) {
+	c := clock.NewManual(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	verifier := middleware.VerifierFunc(func(ctx context.Context, response, remoteIP string) error {
+		if response != "solved" {
+			return errors.New("wrong answer")
+		}
+		return nil
+	})
+	g := middleware.NewGuest(verifier, time.Hour)
+	g.Clock = c
+	g.DailyBytes = 10
+	g.DailyConversions = 2
+	auth := middleware.NewAuth(middleware.ParseAPIKeys("key"))
+	g.Auth = auth
+	h := g.Handler(auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})))
+	serve := func(r *http.Request, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r.RemoteAddr = "10.0.0.1:1000"
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+	solve := func(answer string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, middleware.GuestPath, strings.NewReader(url.Values{"h-captcha-response": {answer}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(r, nil)
+	}
+	convert := func(cookie *http.Cookie, body string) int {
+		return serve(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), cookie).Code
+	}
+
+	page := serve(httptest.NewRequest(http.MethodGet, middleware.GuestPath, nil), nil)
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), `action="/guest"`)
+
+	assert.Equal(t, http.StatusUnauthorized, convert(nil, ""))
+	assert.Equal(t, http.StatusForbidden, solve("wrong").Code)
+	rr := solve("solved")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	session := cookies[0]
+	assert.Equal(t, middleware.GuestCookie, session.Name)
+
+	// quotas
+	assert.Equal(t, http.StatusOK, convert(session, "12345"))
+	assert.Equal(t, http.StatusTooManyRequests, convert(session, "1234567"))
+	assert.Equal(t, http.StatusOK, convert(session, ""))
+	assert.Equal(t, http.StatusTooManyRequests, convert(session, ""))
+	// api key is not limited
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("1234567890"))
+	r.Header.Set(middleware.APIKeyHeader, "key")
+	assert.Equal(t, http.StatusOK, serve(r, nil).Code)
+	// unknown api key needs the session
+	r = httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set(middleware.APIKeyHeader, "made-up")
+	assert.Equal(t, http.StatusUnauthorized, serve(r, nil).Code)
+
+	// next day quotas are reset, but session expires
+	c.Add(12 * time.Hour)
+	assert.Equal(t, http.StatusUnauthorized, convert(session, ""))
+	assert.Equal(t, http.StatusOK, convert(solve("solved").Result().Cookies()[0], ""))
+}
+
+func TestGuestWithoutKeys(t *testing.T) {
+	g := middleware.NewGuest(middleware.VerifierFunc(func(ctx context.Context, response, remoteIP string) error {
+		return nil
+	}), time.Hour)
+	h := g.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	r.Header.Set(middleware.APIKeyHeader, "made-up")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestSiteVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") == "secret" && r.PostFormValue("response") == "solved" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	v := middleware.SiteVerifier{URL: server.URL, Secret: "secret"}
+	assert.NoError(t, v.Verify(context.Background(), "solved", "10.0.0.1"))
+	err := v.Verify(context.Background(), "wrong", "10.0.0.1")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid-input-response")
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pipelined.dev/phono/clock"
+)
+
+// tokenBucket allows rate events per second with bursts up to burst
+// events. It's not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns full bucket. Burst is at least one event.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// take takes a token from the bucket. If bucket is empty, the time until
+// the next token is returned.
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// idleBucketTTL is the time after which the bucket of inactive client is
+// removed. Bucket is full again by that time, if rate is at least one
+// request per minute.
+const idleBucketTTL = 10 * time.Minute
+
+// unhealthyRetry is the time to retry requests while backend is
+// unhealthy.
+const unhealthyRetry = 30 * time.Second
+
+var (
+	errTooManyConversions = errors.New("too many concurrent conversions")
+)
+
+type (
+	// Limiter limits the rate of requests per client IP and the number
+	// of concurrent requests that modify the state, e.g. conversions.
+	// Rate limited requests get 429 status with Retry-After header. If no
+	// slot is available during QueueWait or Health returns error, the
+	// backend is unavailable and request gets 503 status with queue
+	// stats. Zero limits mean no limit. Clock and Health can be set
+	// before the first use.
+	Limiter struct {
+		Clock     clock.Clock
+		QueueWait time.Duration
+		Health    func() error
+		slots     chan struct{}
+		waiting   int32
+		rate      float64
+		burst     int
+
+		mu      sync.Mutex
+		buckets map[string]*tokenBucket
+		cleaned time.Time
+	}
+)
+
+// NewLimiter returns new limiter. Rate is the number of requests per
+// second from single IP, burst is the max number of requests at once.
+func NewLimiter(concurrent int, rate float64, burst int) *Limiter {
+	l := Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+	if concurrent > 0 {
+		l.slots = make(chan struct{}, concurrent)
+	}
+	return &l
+}
+
+// Handler limits requests before passing them to h. Concurrency limit is
+// not applied to GET and HEAD requests.
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.Health != nil {
+			if err := l.Health(); err != nil {
+				unavailable(w, r, err, unhealthyRetry, l.Stats())
+				return
+			}
+		}
+		if wait, ok := l.allow(clientIP(r)); !ok {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, errRateLimited.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if l.slots == nil || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !l.acquire(r) {
+			unavailable(w, r, errTooManyConversions, time.Second, l.Stats())
+			return
+		}
+		defer l.release()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// allow takes the token from the bucket of the client.
+func (l *Limiter) allow(ip string) (time.Duration, bool) {
+	if l.rate <= 0 {
+		return 0, true
+	}
+	now := clock.Or(l.Clock).Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cleanUp(now)
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[ip] = b
+	}
+	return b.take(now)
+}
+
+// cleanUp removes buckets of inactive clients. Must be called under lock.
+func (l *Limiter) cleanUp(now time.Time) {
+	if now.Sub(l.cleaned) < idleBucketTTL {
+		return
+	}
+	l.cleaned = now
+	for ip, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTTL {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// acquire waits for a free slot up to queue wait time.
+func (l *Limiter) acquire(r *http.Request) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.QueueWait <= 0 {
+		return false
+	}
+	atomic.AddInt32(&l.waiting, 1)
+	defer atomic.AddInt32(&l.waiting, -1)
+	t := time.NewTimer(l.QueueWait)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+	return false
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// Stats returns the current load of conversion slots.
+func (l *Limiter) Stats() QueueStats {
+	return QueueStats{
+		Active:   len(l.slots),
+		Capacity: cap(l.slots),
+		Waiting:  int(atomic.LoadInt32(&l.waiting)),
+	}
+}
+
+// clientIP returns the IP of the client from remote address.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// retryAfter formats Retry-After header value in whole seconds.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(retrySeconds(wait))
+}
+
+// retrySeconds returns the wait time in whole seconds, at least one.
+func retrySeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
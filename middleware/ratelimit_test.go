@@ -0,0 +1,105 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/middleware"
+)
+
+func TestLimiterRate(t *testing.T) {
+	c := clock.NewManual(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := middleware.NewLimiter(0, 1, 2)
+	l.Clock = c
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1000").Code)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1001").Code)
+	rr := request("10.0.0.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	// other clients are not affected
+	assert.Equal(t, http.StatusOK, request("10.0.0.2:1000").Code)
+
+	c.Add(time.Second)
+	assert.Equal(t, http.StatusOK, request("10.0.0.1:1003").Code)
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := middleware.NewLimiter(1, 0, 0)
+	started, unblock := make(chan struct{}), make(chan struct{})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{
+		"error": "too many concurrent conversions",
+		"retry_after": 1,
+		"queue": {"active": 1, "capacity": 1, "waiting": 0}
+	}`, rr.Body.String())
+
+	// queued request gets the slot when it's released
+	l.QueueWait = time.Second
+	queued := httptest.NewRecorder()
+	go func() {
+		<-started
+		<-done
+	}()
+	go close(unblock)
+	h.ServeHTTP(queued, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusOK, queued.Code)
+}
+
+func TestLimiterHealth(t *testing.T) {
+	l := middleware.NewLimiter(2, 0, 0)
+	var healthErr error
+	l.Health = func() error { return healthErr }
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		return rr
+	}
+	assert.Equal(t, http.StatusOK, request("").Code)
+
+	healthErr = errors.New("insufficient storage")
+	rr := request("application/json")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{
+		"error": "insufficient storage",
+		"retry_after": 30,
+		"queue": {"active": 0, "capacity": 2, "waiting": 0}
+	}`, rr.Body.String())
+
+	// browsers get the page
+	rr = request("text/html,application/xhtml+xml")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+	assert.Contains(t, rr.Body.String(), "Please try again later")
+	assert.Contains(t, rr.Body.String(), "insufficient storage")
+}
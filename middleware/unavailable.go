@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var unavailableTemplate = template.Must(template.New("unavailable").Parse(unavailableHTML))
+
+type (
+	// QueueStats describes the load of conversion queue.
+	QueueStats struct {
+		Active   int `json:"active"`
+		Capacity int `json:"capacity"`
+		Waiting  int `json:"waiting"`
+	}
+
+	// unavailableResponse is the body of 503 response.
+	unavailableResponse struct {
+		Error      string     `json:"error"`
+		RetryAfter int        `json:"retry_after"`
+		Queue      QueueStats `json:"queue"`
+	}
+)
+
+// unavailable responds with 503 status. Browsers get the page that asks
+// to try later, other clients get the reason and queue stats in JSON.
+func unavailable(w http.ResponseWriter, r *http.Request, err error, wait time.Duration, stats QueueStats) {
+	resp := unavailableResponse{
+		Error:      err.Error(),
+		RetryAfter: retrySeconds(wait),
+		Queue:      stats,
+	}
+	w.Header().Set("Retry-After", retryAfter(wait))
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if err := unavailableTemplate.Execute(w, resp); err != nil {
+			log.Printf("Failed to render unavailable page: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Failed to send unavailable response: %v", err)
+	}
+}
+
+const unavailableHTML = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<meta http-equiv="refresh" content="{{.RetryAfter}}">
+	<title>phono is busy</title>
+	<style>
+		body { font-family: sans-serif; max-width: 40em; margin: 2em auto; padding: 0 1em; }
+	</style>
+</head>
+<body>
+	<h1>Please try again later</h1>
+	<p>The converter can't take new files right now: {{.Error}}.</p>
+	{{if .Queue.Capacity}}<p>{{.Queue.Active}} of {{.Queue.Capacity}} conversions are running, {{.Queue.Waiting}} waiting.</p>{{end}}
+	<p>This page reloads in {{.RetryAfter}} seconds.</p>
+</body>
+</html>
+`
@@ -0,0 +1,97 @@
+// Package mmap reads local files mapped into memory. Reads and seeks of
+// mapped files are copies and don't need system calls, so decoders that
+// seek a lot are faster on large files. The file must not be truncated
+// while it's mapped.
+package mmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrUnsupported is returned by Open if files can't be mapped on the
+// platform.
+var ErrUnsupported = errors.New("memory mapping is not supported")
+
+// File is the read-only memory mapping of a file.
+type File struct {
+	data   []byte
+	off    int64
+	closed bool
+}
+
+// Read reads up to len(p) bytes from the current offset.
+func (f *File) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+// ReadAt reads len(p) bytes from the offset, io.EOF is returned if the
+// file ends before that. It doesn't change the current offset.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset: %d", off)
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the next read. Offsets past the end are
+// allowed, reads return io.EOF there.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	default:
+		return 0, fmt.Errorf("invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset: %d", offset)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Size returns the size of the file.
+func (f *File) Size() int64 {
+	return int64(len(f.data))
+}
+
+// Close unmaps the file. Slices of the mapping must not be used after
+// that.
+func (f *File) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	data := f.data
+	f.data = nil
+	if len(data) == 0 {
+		return nil
+	}
+	return unmap(data)
+}
@@ -0,0 +1,71 @@
+package mmap_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/mmap"
+)
+
+func TestFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("memory mapping is not supported")
+	}
+	tmp, err := ioutil.TempFile("", "phono-mmap")
+	assert.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString("0123456789")
+	assert.NoError(t, err)
+	assert.NoError(t, tmp.Close())
+
+	f, err := mmap.Open(tmp.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), f.Size())
+
+	b := make([]byte, 4)
+	n, err := f.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(b[:n]))
+
+	pos, err := f.Seek(-3, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), pos)
+	n, err = f.Read(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "789", string(b[:n]))
+	_, err = f.Read(b)
+	assert.Equal(t, io.EOF, err)
+
+	n, err = f.ReadAt(b, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "89", string(b[:n]))
+	n, err = f.ReadAt(b, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "2345", string(b[:n]))
+
+	assert.NoError(t, f.Close())
+	_, err = f.Read(b)
+	assert.Equal(t, os.ErrClosed, err)
+	assert.Equal(t, os.ErrClosed, f.Close())
+}
+
+func TestEmptyFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("memory mapping is not supported")
+	}
+	tmp, err := ioutil.TempFile("", "phono-mmap")
+	assert.NoError(t, err)
+	defer os.Remove(tmp.Name())
+	assert.NoError(t, tmp.Close())
+
+	f, err := mmap.Open(tmp.Name())
+	assert.NoError(t, err)
+	_, err = f.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.NoError(t, f.Close())
+}
@@ -0,0 +1,41 @@
+//go:build !windows
+// +build !windows
+
+package mmap
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Open maps the file of path into memory.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid after the file is closed
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		// empty files can't be mapped
+		return &File{}, nil
+	}
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("file is too large to map: %d bytes", size)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", path, err)
+	}
+	return &File{data: data}, nil
+}
+
+func unmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,11 @@
+package mmap
+
+// Open returns ErrUnsupported, files are read with system calls on
+// windows.
+func Open(path string) (*File, error) {
+	return nil, ErrUnsupported
+}
+
+func unmap(data []byte) error {
+	return nil
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"pipelined.dev/phono/cmd"
+	// formats and processors of external modules are compiled in with
+	// blank imports, they register themselves with codec and processor
+	// packages.
 )
 
 func main() {
@@ -0,0 +1,274 @@
+// Package pipeline builds pipes from declarative definitions. Definition
+// declares the pump, ordered processors and one or more sinks. Files are
+// YAML, JSON definitions are accepted as well:
+//
+//	pump:
+//	  path: in.flac
+//	processors:
+//	  - name: gain
+//	    params:
+//	      db: -3
+//	  - name: limiter
+//	    params:
+//	      ceiling: -1
+//	sinks:
+//	  - path: out.mp3
+//	    options:
+//	      bitratemode: cbr
+//	      bitrate: 320
+//	  - path: out.wav
+//	    options:
+//	      bitdepth: 16
+//
+// Processors are looked up in the processor registry, pumps and sinks in
+// the codec registry. Format of the pump and sinks is detected by path
+// extension unless it's provided.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/processor"
+)
+
+// ErrNoSinks is returned if definition has no sinks.
+var ErrNoSinks = errors.New("pipeline has no sinks")
+
+type (
+	// Definition declares the pipeline.
+	Definition struct {
+		Pump       Pump        `yaml:"pump"`
+		Processors []Processor `yaml:"processors"`
+		Sinks      []Sink      `yaml:"sinks"`
+	}
+
+	// Pump is the input file of the pipeline.
+	Pump struct {
+		Path   string `yaml:"path"`
+		Format string `yaml:"format"`
+	}
+
+	// Processor is the registered processor with its parameters.
+	Processor struct {
+		Name   string           `yaml:"name"`
+		Params processor.Params `yaml:"params"`
+	}
+
+	// Sink is the output file of the pipeline. Format is the name of
+	// registered sink, options are options of the sink, e.g. bitdepth of
+	// wav sink or bitratemode, bitrate, channelmode and quality of mp3
+	// sink.
+	Sink struct {
+		Path    string            `yaml:"path"`
+		Format  string            `yaml:"format"`
+		Options map[string]string `yaml:"options"`
+	}
+
+	// Pipeline is the validated definition that is ready to run.
+	Pipeline struct {
+		input      string
+		pump       codec.Pump
+		processors []pipe.ProcessorAllocatorFunc
+		sinks      []sink
+	}
+
+	// sink is the validated output of the pipeline.
+	sink struct {
+		path     string
+		format   *fileformat.Format
+		fn       func(io.WriteSeeker) pipe.SinkAllocatorFunc
+		bitDepth signal.BitDepth
+	}
+)
+
+// Read parses the definition. Unknown fields are rejected, so typos
+// don't silently change the pipeline.
+func Read(r io.Reader) (Definition, error) {
+	var d Definition
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&d); err != nil {
+		if err == io.EOF {
+			return Definition{}, errors.New("empty pipeline definition")
+		}
+		return Definition{}, err
+	}
+	return d, nil
+}
+
+// ReadFile reads the definition file. Relative paths of the pump and
+// sinks are resolved against the directory of the file.
+func ReadFile(path string) (Definition, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Definition{}, err
+	}
+	defer f.Close()
+	d, err := Read(f)
+	if err != nil {
+		return Definition{}, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	d.Pump.Path = resolve(dir, d.Pump.Path)
+	for i := range d.Sinks {
+		d.Sinks[i].Path = resolve(dir, d.Sinks[i].Path)
+	}
+	return d, nil
+}
+
+// resolve returns the path relative to dir unless it's absolute or empty.
+func resolve(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Build validates the definition and assembles the pipeline. Experimental
+// processors are allowed if experimental is true, warning is called for
+// every one of them.
+func (d Definition) Build(experimental bool, warning func(processor.Processor)) (*Pipeline, error) {
+	if d.Pump.Path == "" {
+		return nil, errors.New("pump path is not provided")
+	}
+	pump, err := pumpOf(d.Pump.Path, d.Pump.Format)
+	if err != nil {
+		return nil, fmt.Errorf("pump: %w", err)
+	}
+	processors := make([]pipe.ProcessorAllocatorFunc, 0, len(d.Processors))
+	for _, p := range d.Processors {
+		registered, err := processor.Default.Lookup(p.Name, experimental)
+		if err != nil {
+			return nil, err
+		}
+		if registered.Experimental && warning != nil {
+			warning(registered)
+		}
+		params := p.Params
+		if params == nil {
+			params = processor.Params{}
+		}
+		fn, err := registered.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", registered.Name, err)
+		}
+		processors = append(processors, fn)
+	}
+	if len(d.Sinks) == 0 {
+		return nil, ErrNoSinks
+	}
+	sinks := make([]sink, 0, len(d.Sinks))
+	paths := map[string]struct{}{filepath.Clean(d.Pump.Path): {}}
+	for i, s := range d.Sinks {
+		if s.Path == "" {
+			return nil, fmt.Errorf("sink %d: path is not provided", i+1)
+		}
+		if _, ok := paths[filepath.Clean(s.Path)]; ok {
+			return nil, fmt.Errorf("sink %s: path is already used", s.Path)
+		}
+		paths[filepath.Clean(s.Path)] = struct{}{}
+		built, err := s.build()
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", s.Path, err)
+		}
+		sinks = append(sinks, built)
+	}
+	return &Pipeline{
+		input:      d.Pump.Path,
+		pump:       pump,
+		processors: processors,
+		sinks:      sinks,
+	}, nil
+}
+
+// build validates options of the sink.
+func (s Sink) build() (sink, error) {
+	name := s.Format
+	if name == "" {
+		name = filepath.Ext(s.Path)
+	}
+	registered, err := codec.LookupSink(name)
+	if err != nil {
+		return sink{}, fmt.Errorf("unsupported output format: %s", name)
+	}
+	enc, err := registered.NewEncoder(codec.Params(s.Options))
+	if err != nil {
+		return sink{}, err
+	}
+	return sink{path: s.Path, format: registered.Format, fn: enc.Sink, bitDepth: enc.BitDepth}, nil
+}
+
+// pumpOf returns the pump of format name, e.g. flac, or the pump of path
+// extension if name is empty.
+func pumpOf(path, name string) (codec.Pump, error) {
+	if name != "" {
+		path = "." + name
+	}
+	pump, err := codec.LookupPump(path)
+	if err != nil {
+		return codec.Pump{}, fmt.Errorf("unsupported format: %s", path)
+	}
+	return pump, nil
+}
+
+// Run runs the pipeline. Input is decoded once and written into all
+// sinks, the output of each sink is dithered if its bit depth is lower
+// than the input one. See encode.Run for buffer size and stall timeout.
+// Outputs of failed run are removed.
+func (p *Pipeline) Run(ctx context.Context, b encode.Buffering, stallTimeout time.Duration) (err error) {
+	in, err := os.Open(p.input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var source signal.BitDepth
+	if p.pump.Format != nil {
+		if source, err = encode.SourceBitDepth(p.pump.Format, in); err != nil {
+			return fmt.Errorf("failed to read %s: %w", p.input, err)
+		}
+	}
+	files := make([]*os.File, 0, len(p.sinks))
+	defer func() {
+		for _, f := range files {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			for _, f := range files {
+				os.Remove(f.Name())
+			}
+		}
+	}()
+	sinks := make([]pipe.SinkAllocatorFunc, 0, len(p.sinks))
+	for _, s := range p.sinks {
+		f, err := os.Create(s.path)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		fn := s.fn(f)
+		if encode.DitherAuto.Enabled(source, s.bitDepth) {
+			fn = encode.DitherSink(fn, s.bitDepth, false)
+		}
+		sinks = append(sinks, fn)
+	}
+	bufferSize := b.BufferSize(nil, p.sinks[0].format)
+	if p.pump.Format != nil {
+		bufferSize = b.InputBufferSize(p.pump.Format, p.sinks[0].format, in, false)
+	}
+	return encode.Run(ctx, bufferSize, stallTimeout, p.pump.Source(in), encode.Tee(sinks...), p.processors...)
+}
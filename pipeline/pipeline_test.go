@@ -0,0 +1,118 @@
+package pipeline_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/pipeline"
+	"pipelined.dev/phono/processor"
+)
+
+func TestRead(t *testing.T) {
+	d, err := pipeline.Read(strings.NewReader(`
+pump:
+  path: in.flac
+processors:
+  - name: gain
+    params:
+      db: -3
+sinks:
+  - path: out.mp3
+    options:
+      bitratemode: cbr
+      bitrate: 320
+  - path: out
+    format: wav
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "in.flac", d.Pump.Path)
+	assert.Equal(t, "-3", d.Processors[0].Params["db"])
+	assert.Equal(t, "320", d.Sinks[0].Options["bitrate"])
+	assert.Equal(t, "wav", d.Sinks[1].Format)
+
+	// json is accepted as well
+	d, err = pipeline.Read(strings.NewReader(`{"pump": {"path": "in.wav"}, "sinks": [{"path": "out.wav", "options": {"bitdepth": 16}}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "16", d.Sinks[0].Options["bitdepth"])
+
+	_, err = pipeline.Read(strings.NewReader("pump:\n  file: in.wav\n"))
+	assert.Error(t, err)
+	_, err = pipeline.Read(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestBuild(t *testing.T) {
+	build := func(d pipeline.Definition) error {
+		_, err := d.Build(true, nil)
+		return err
+	}
+	pump := pipeline.Pump{Path: "in.wav"}
+	wav := pipeline.Sink{Path: "out.wav"}
+	assert.NoError(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{wav}}))
+	assert.NoError(t, build(pipeline.Definition{
+		Pump:       pump,
+		Processors: []pipeline.Processor{{Name: "limiter", Params: map[string]string{"ceiling": "-1"}}},
+		Sinks:      []pipeline.Sink{wav, {Path: "out.mp3", Options: map[string]string{"bitratemode": "vbr", "bitrate": "2"}}},
+	}))
+
+	assert.Equal(t, pipeline.ErrNoSinks, build(pipeline.Definition{Pump: pump}))
+	assert.Error(t, build(pipeline.Definition{Sinks: []pipeline.Sink{wav}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pipeline.Pump{Path: "in.ogg"}, Sinks: []pipeline.Sink{wav}}))
+	assert.NoError(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{{Path: "out.flac"}}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{{Path: "out.ogg"}}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{wav, wav}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{{Path: "in.wav"}}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{{Path: "out.wav", Options: map[string]string{"bitrate": "320"}}}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Sinks: []pipeline.Sink{{Path: "out.wav", Options: map[string]string{"bitdepth": "12"}}}}))
+	assert.Error(t, build(pipeline.Definition{Pump: pump, Processors: []pipeline.Processor{{Name: "unknown"}}, Sinks: []pipeline.Sink{wav}}))
+
+	// gain is experimental
+	var warnings int
+	d := pipeline.Definition{Pump: pump, Processors: []pipeline.Processor{{Name: "gain", Params: map[string]string{"db": "-3"}}}, Sinks: []pipeline.Sink{wav}}
+	_, err := d.Build(false, nil)
+	assert.Error(t, err)
+	_, err = d.Build(true, func(processor.Processor) { warnings++ })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, warnings)
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	input, err := filepath.Abs("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	definition := filepath.Join(dir, "pipeline.yaml")
+	assert.NoError(t, ioutil.WriteFile(definition, []byte(`
+pump:
+  path: `+input+`
+processors:
+  - name: limiter
+    params:
+      ceiling: -1
+sinks:
+  - path: out16.wav
+    options:
+      bitdepth: 16
+  - path: out24.wav
+`), 0644))
+
+	d, err := pipeline.ReadFile(definition)
+	assert.NoError(t, err)
+	p, err := d.Build(true, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Run(context.Background(), encode.Buffering{Size: 512}, 0))
+
+	out16, err := os.Stat(filepath.Join(dir, "out16.wav"))
+	assert.NoError(t, err)
+	out24, err := os.Stat(filepath.Join(dir, "out24.wav"))
+	assert.NoError(t, err)
+	assert.True(t, out24.Size() > out16.Size())
+}
@@ -0,0 +1,89 @@
+// Package preset provides named sets of encode options. Presets are
+// values of the encode form, e.g. output format with its options and
+// processing, so they are applied by the web form and by the command
+// line the same way.
+package preset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/userinput"
+)
+
+// name is the format of preset names.
+var name = regexp.MustCompile(`^[0-9a-zA-Z _.-]{1,64}$`)
+
+var (
+	errName   = i18n.Errorf("error.preset_name")
+	errValues = i18n.Errorf("error.preset_values")
+)
+
+// requestKeys are form keys of the single request, e.g. the input, they
+// are not saved in presets.
+var requestKeys = []string{
+	userinput.FormFileKey,
+	userinput.ProgressIDKey,
+	userinput.SourceURLKey,
+	userinput.UploadIDKey,
+	userinput.CallbackURLKey,
+	userinput.DestinationKey,
+}
+
+// Preset is the named set of encode form values.
+type Preset struct {
+	Name   string     `json:"name"`
+	Values url.Values `json:"values"`
+}
+
+// New returns the preset of form values. Values of the single request,
+// e.g. progress id or source url, are omitted.
+func New(presetName string, values url.Values) (Preset, error) {
+	if !name.MatchString(presetName) {
+		return Preset{}, fmt.Errorf("%w: %q", errName, presetName)
+	}
+	p := Preset{Name: presetName, Values: url.Values{}}
+	for k, v := range values {
+		p.Values[k] = v
+	}
+	for _, k := range requestKeys {
+		delete(p.Values, k)
+	}
+	if len(p.Values) == 0 {
+		return Preset{}, errValues
+	}
+	return p, nil
+}
+
+// Formats returns output formats of the preset.
+func (p Preset) Formats() []string {
+	return p.Values[userinput.FormatKey]
+}
+
+// ReadFile reads presets from the file with JSON list, e.g. the list
+// returned by the server.
+func ReadFile(path string) ([]Preset, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets: %w", err)
+	}
+	var presets []Preset
+	if err := json.Unmarshal(b, &presets); err != nil {
+		return nil, fmt.Errorf("failed to read presets %s: %w", path, err)
+	}
+	return presets, nil
+}
+
+// Find returns the preset of the name.
+func Find(presets []Preset, presetName string) (Preset, bool) {
+	for _, p := range presets {
+		if p.Name == presetName {
+			return p, true
+		}
+	}
+	return Preset{}, false
+}
@@ -0,0 +1,287 @@
+package preset
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"pipelined.dev/phono/i18n"
+)
+
+const (
+	// Cookie is the name of cookie with presets of requests without
+	// owner.
+	Cookie = "phono-presets"
+	// maxCookieSize is the max size of the cookie value, browsers keep
+	// cookies up to 4 kB.
+	maxCookieSize = 4000
+	// maxPresets is the max number of presets of the owner.
+	maxPresets = 100
+	// maxBodySize is the max size of preset values in the request.
+	maxBodySize = 1 << 16
+)
+
+var (
+	errNotFound   = i18n.Errorf("error.preset_not_found")
+	errTooMany    = i18n.Errorf("error.presets_too_many", maxPresets)
+	errCookieFull = i18n.Errorf("error.presets_cookie_full")
+)
+
+// Store keeps presets of owners:
+//
+//	GET    /presets        list of owner's presets
+//	GET    /presets/name   the preset
+//	PUT    /presets/name   save the preset, the body is JSON object of form values
+//	DELETE /presets/name   delete the preset
+//
+// Owner of the request is returned by Owner function, e.g. the name of
+// API key. If Owner is nil or returns empty string, presets are stored
+// in the cookie of the client. Presets of owners are written into the
+// file of the store, if it's provided.
+type Store struct {
+	Owner func(*http.Request) string
+	path  string
+
+	mu      sync.Mutex
+	presets map[string][]Preset
+}
+
+// NewStore returns the store of presets that are kept in the file at
+// path. Presets are kept only in memory if path is empty.
+func NewStore(path string) (*Store, error) {
+	s := Store{
+		path:    path,
+		presets: make(map[string][]Preset),
+	}
+	if path == "" {
+		return &s, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read presets store: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.presets); err != nil {
+		return nil, fmt.Errorf("failed to read presets store %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// ServeHTTP lists, sends, saves and deletes presets of the request owner.
+// Presets of the owner are locked from load to store, so concurrent
+// requests don't lose changes of each other.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lang := i18n.Request(r)
+	presetName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/presets"), "/")
+	var owner string
+	if s.Owner != nil {
+		owner = s.Owner(r)
+	}
+	// saved preset is read before the lock, so slow clients don't block
+	// the store
+	var saved Preset
+	if presetName != "" && r.Method == http.MethodPut {
+		var values url.Values
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&values); err != nil {
+			http.Error(w, i18n.Localize(lang, i18n.Errorf("error.preset_body", err)), http.StatusBadRequest)
+			return
+		}
+		var err error
+		if saved, err = New(presetName, values); err != nil {
+			http.Error(w, i18n.Localize(lang, err), http.StatusBadRequest)
+			return
+		}
+	}
+	if owner != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	presets := s.load(owner, r)
+	if presetName == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		sendJSON(w, presets)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		p, ok := Find(presets, presetName)
+		if !ok {
+			http.Error(w, i18n.Localize(lang, errNotFound), http.StatusNotFound)
+			return
+		}
+		sendJSON(w, p)
+	case http.MethodPut:
+		presets, err := save(presets, saved)
+		if err != nil {
+			http.Error(w, i18n.Localize(lang, err), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := s.store(w, r, owner, presets); err != nil {
+			storeError(w, lang, err)
+			return
+		}
+		sendJSON(w, saved)
+	case http.MethodDelete:
+		if _, ok := Find(presets, presetName); !ok {
+			http.Error(w, i18n.Localize(lang, errNotFound), http.StatusNotFound)
+			return
+		}
+		if err := s.store(w, r, owner, remove(presets, presetName)); err != nil {
+			storeError(w, lang, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// load returns presets of the owner or presets of the cookie if there is
+// no owner. Invalid cookie is ignored. Presets of the owner must be
+// loaded under the lock.
+func (s *Store) load(owner string, r *http.Request) []Preset {
+	if owner != "" {
+		return append([]Preset(nil), s.presets[owner]...)
+	}
+	c, err := r.Cookie(Cookie)
+	if err != nil {
+		return []Preset{}
+	}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return []Preset{}
+	}
+	var presets []Preset
+	if err := json.Unmarshal(b, &presets); err != nil {
+		return []Preset{}
+	}
+	return presets
+}
+
+// store replaces presets of the owner or sets the cookie with presets if
+// there is no owner. It must be called before the response is written.
+// Presets of the owner must be stored under the lock.
+func (s *Store) store(w http.ResponseWriter, r *http.Request, owner string, presets []Preset) error {
+	if owner != "" {
+		prev, ok := s.presets[owner]
+		s.presets[owner] = presets
+		if len(presets) == 0 {
+			delete(s.presets, owner)
+		}
+		// presets in memory must match the file
+		if err := s.write(); err != nil {
+			delete(s.presets, owner)
+			if ok {
+				s.presets[owner] = prev
+			}
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(presets)
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	if len(value) > maxCookieSize {
+		return errCookieFull
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     Cookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// write saves presets of all owners into the file of the store. The file
+// is replaced, so it's never partially written. Must be called under
+// the lock.
+func (s *Store) write() error {
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.presets, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".presets")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		log.Printf("Failed to write presets store: %v", err)
+	}
+	return err
+}
+
+// save returns presets with added or replaced preset sorted by names.
+func save(presets []Preset, p Preset) ([]Preset, error) {
+	presets = remove(presets, p.Name)
+	if len(presets) >= maxPresets {
+		return nil, errTooMany
+	}
+	presets = append(presets, p)
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+// remove returns presets without the preset of the name.
+func remove(presets []Preset, presetName string) []Preset {
+	result := make([]Preset, 0, len(presets))
+	for _, p := range presets {
+		if p.Name != presetName {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// storeError sends the error of stored presets. Full cookie is reported
+// with 413 status.
+func storeError(w http.ResponseWriter, lang string, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errCookieFull) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	http.Error(w, i18n.Localize(lang, err), status)
+}
+
+func sendJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to send presets: %v", err)
+	}
+}
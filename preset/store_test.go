@@ -0,0 +1,171 @@
+package preset_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/preset"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "presets.json")
+
+	owner := func(r *http.Request) string {
+		return r.Header.Get("X-Owner")
+	}
+	store, err := preset.NewStore(path)
+	assert.NoError(t, err)
+	store.Owner = owner
+	request := func(s *preset.Store, method, target, owner, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("X-Owner", owner)
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, r)
+		return rr
+	}
+	list := func(s *preset.Store, owner string) []preset.Preset {
+		rr := request(s, http.MethodGet, "/presets", owner, "")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var presets []preset.Preset
+		assert.NoError(t, json.NewDecoder(rr.Body).Decode(&presets))
+		return presets
+	}
+
+	// values of the single request are omitted
+	rr := request(store, http.MethodPut, "/presets/mp3%20v0", "alice", `{"format":[".mp3"],"mp3-bit-rate-mode":["VBR"],"progress-id":["1"]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = request(store, http.MethodPut, "/presets/cd", "alice", `{"format":[".wav"],"wav-bit-depth":["16"]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	expected := []preset.Preset{
+		{Name: "cd", Values: url.Values{userinput.FormatKey: {".wav"}, "wav-bit-depth": {"16"}}},
+		{Name: "mp3 v0", Values: url.Values{userinput.FormatKey: {".mp3"}, "mp3-bit-rate-mode": {"VBR"}}},
+	}
+	assert.Equal(t, expected, list(store, "alice"))
+	assert.Empty(t, list(store, "bob"))
+
+	rr = request(store, http.MethodGet, "/presets/cd", "alice", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var p preset.Preset
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&p))
+	assert.Equal(t, expected[0], p)
+	assert.Equal(t, []string{".wav"}, p.Formats())
+	assert.Equal(t, http.StatusNotFound, request(store, http.MethodGet, "/presets/cd", "bob", "").Code)
+
+	// presets are kept after restart
+	restored, err := preset.NewStore(path)
+	assert.NoError(t, err)
+	restored.Owner = owner
+	assert.Equal(t, expected, list(restored, "alice"))
+
+	rr = request(store, http.MethodDelete, "/presets/cd", "alice", "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, expected[1:], list(store, "alice"))
+	assert.Equal(t, http.StatusNotFound, request(store, http.MethodDelete, "/presets/cd", "alice", "").Code)
+
+	// invalid presets
+	assert.Equal(t, http.StatusBadRequest, request(store, http.MethodPut, "/presets/a%2Fb", "alice", `{"format":[".wav"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(store, http.MethodPut, "/presets/empty", "alice", `{"progress-id":["1"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(store, http.MethodPut, "/presets/broken", "alice", `[`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(store, http.MethodPost, "/presets", "alice", "").Code)
+
+	// invalid values are reported in the language of the client
+	r := httptest.NewRequest(http.MethodPut, "/presets/broken", strings.NewReader(`[`))
+	r.Header.Set("X-Owner", "alice")
+	r.Header.Set("Accept-Language", "de")
+	rr = httptest.NewRecorder()
+	store.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "ungültige Werte der Voreinstellung"), rr.Body.String())
+
+	// presets are kept if the store can't be written
+	assert.NoError(t, os.RemoveAll(dir))
+	assert.Equal(t, http.StatusInternalServerError, request(store, http.MethodPut, "/presets/cd", "alice", `{"format":[".wav"]}`).Code)
+	assert.Equal(t, http.StatusInternalServerError, request(store, http.MethodDelete, "/presets/mp3%20v0", "alice", "").Code)
+	assert.Equal(t, http.StatusInternalServerError, request(store, http.MethodPut, "/presets/cd", "bob", `{"format":[".wav"]}`).Code)
+	assert.Equal(t, expected[1:], list(store, "alice"))
+	assert.Empty(t, list(store, "bob"))
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	store, err := preset.NewStore("")
+	assert.NoError(t, err)
+	store.Owner = func(r *http.Request) string {
+		return "alice"
+	}
+
+	// concurrent saves of the owner don't lose each other
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/presets/p%d", i), strings.NewReader(`{"format":[".wav"]}`))
+			store.ServeHTTP(httptest.NewRecorder(), r)
+		}(i)
+	}
+	wg.Wait()
+
+	rr := httptest.NewRecorder()
+	store.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/presets", nil))
+	var presets []preset.Preset
+	assert.NoError(t, json.NewDecoder(rr.Body).Decode(&presets))
+	assert.Equal(t, 50, len(presets))
+}
+
+func TestStoreCookie(t *testing.T) {
+	store, err := preset.NewStore("")
+	assert.NoError(t, err)
+	request := func(method, target string, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		store.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// presets without owner are kept in the cookie
+	rr := request(http.MethodPut, "/presets/cd", nil, `{"format":[".wav"],"wav-bit-depth":["16"]}`)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	cookies := rr.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, preset.Cookie, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+
+	rr = request(http.MethodGet, "/presets/cd", cookie, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = request(http.MethodGet, "/presets/cd", nil, "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = request(http.MethodDelete, "/presets/cd", cookie, "")
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	cookie = rr.Result().Cookies()[0]
+	rr = request(http.MethodGet, "/presets", cookie, "")
+	assert.Equal(t, "[]\n", rr.Body.String())
+
+	// invalid cookie is ignored
+	rr = request(http.MethodGet, "/presets", &http.Cookie{Name: preset.Cookie, Value: "invalid"}, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "[]\n", rr.Body.String())
+
+	// cookie size is limited
+	rr = request(http.MethodPut, "/presets/big", nil, `{"processor":["`+strings.Repeat("a", 4000)+`"]}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
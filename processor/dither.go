@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+func init() {
+	Register(Processor{
+		Name:         "dither",
+		Description:  "quantize to bitdepth with triangular dither, noise is shaped if shaping is true",
+		Experimental: true,
+		New:          newDither,
+	})
+}
+
+func newDither(params Params) (pipe.ProcessorAllocatorFunc, error) {
+	bitDepth, err := strconv.Atoi(params["bitdepth"])
+	if err != nil || bitDepth < 8 || bitDepth > 32 {
+		return nil, fmt.Errorf("invalid bitdepth value: %q", params["bitdepth"])
+	}
+	var shaping bool
+	if v, ok := params["shaping"]; ok {
+		if shaping, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid shaping value: %q", v)
+		}
+	}
+	return Dither(signal.BitDepth(bitDepth), shaping), nil
+}
+
+// Dither quantizes the signal to bit depth with triangular probability
+// density dither of one least significant bit. If shaping is true, the
+// quantization error is fed back, so the noise is moved to high
+// frequencies. Output samples are offset by half of the step, so the
+// truncation of sinks gives the quantized values.
+func Dither(bitDepth signal.BitDepth, shaping bool) pipe.ProcessorAllocatorFunc {
+	scale := float64(bitDepth.MaxSignedValue()) + 1
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+		// fixed seed keeps the output reproducible
+		random := rand.New(rand.NewSource(1))
+		feedback := make([]float64, props.Channels)
+		return pipe.Processor{
+			SignalProperties: props,
+			ProcessFunc: func(in, out signal.Floating) (int, error) {
+				channels := in.Channels()
+				for i := 0; i < in.Len(); i++ {
+					c := i % channels
+					v := in.Sample(i) - feedback[c]
+					step := math.Round((v + (random.Float64()-random.Float64())/scale) * scale)
+					step = math.Max(-scale, math.Min(scale-1, step))
+					if shaping {
+						feedback[c] = step/scale - v
+					}
+					out.SetSample(i, quantized(step, scale))
+				}
+				return in.Length(), nil
+			},
+		}, nil
+	}
+}
+
+// quantized returns the sample of step that is converted back to the
+// same step by sinks. Positive samples are scaled by the max value and
+// negative ones by the scale.
+func quantized(step, scale float64) float64 {
+	switch {
+	case step > 0:
+		return (step + 0.5) / (scale - 1)
+	case step < 0:
+		return (step - 0.5) / scale
+	default:
+		return 0
+	}
+}
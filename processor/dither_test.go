@@ -0,0 +1,65 @@
+package processor_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+func TestDither(t *testing.T) {
+	const frames = 10000
+	// dither returns the samples of sine quantized by the sink
+	dither := func(shaping bool) []int64 {
+		p, err := processor.Dither(signal.BitDepth16, shaping)(mutable.Mutable(), frames, pipe.SignalProperties{
+			SampleRate: 44100,
+			Channels:   1,
+		})
+		assert.NoError(t, err)
+		in := signal.Allocator{Channels: 1, Length: frames, Capacity: frames}.Float64()
+		out := signal.Allocator{Channels: 1, Length: frames, Capacity: frames}.Float64()
+		for i := 0; i < frames; i++ {
+			in.SetSample(i, 0.5*math.Sin(2*math.Pi*440*float64(i)/44100))
+		}
+		_, err = p.ProcessFunc(in, out)
+		assert.NoError(t, err)
+		ints := signal.Allocator{Channels: 1, Length: frames, Capacity: frames}.Int16(signal.BitDepth16)
+		signal.FloatingAsSigned(out, ints)
+		result := make([]int64, frames)
+		for i := range result {
+			result[i] = ints.Sample(i)
+			// samples are in the middle of quantization steps, so
+			// truncation is exact
+			scaled := out.Sample(i) * 32768
+			if scaled > 0 {
+				scaled = out.Sample(i) * 32767
+			}
+			if scaled != 0 {
+				assert.InDelta(t, 0.5, math.Abs(scaled-float64(result[i])), 1e-6)
+			}
+		}
+		return result
+	}
+
+	for _, shaping := range []bool{false, true} {
+		samples := dither(shaping)
+		// error is within a few steps and has no offset
+		var sum float64
+		for i, v := range samples {
+			e := float64(v) - 0.5*math.Sin(2*math.Pi*440*float64(i)/44100)*32768
+			assert.True(t, math.Abs(e) < 4, "error %v", e)
+			sum += e
+		}
+		assert.InDelta(t, 0, sum/frames, 0.1)
+	}
+
+	_, err := processor.Default.Allocators([]string{"dither:bitdepth=12"}, true, nil)
+	assert.NoError(t, err)
+	_, err = processor.Default.Allocators([]string{"dither:bitdepth=64"}, true, nil)
+	assert.Error(t, err)
+}
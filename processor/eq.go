@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Filter types of EQ bands.
+const (
+	HighPass  FilterType = "highpass"
+	LowPass   FilterType = "lowpass"
+	Peak      FilterType = "peak"
+	LowShelf  FilterType = "lowshelf"
+	HighShelf FilterType = "highshelf"
+)
+
+// defaultQ is the quality factor of band if it's not provided. It gives
+// Butterworth response for pass filters.
+const defaultQ = math.Sqrt2 / 2
+
+type (
+	// FilterType is the type of EQ band.
+	FilterType string
+
+	// Band is a single filter of EQ. Gain in db is used by peak and
+	// shelf filters only.
+	Band struct {
+		Type      FilterType
+		Frequency float64
+		Gain      float64
+		Q         float64
+	}
+
+	// biquad is the second order filter in direct form I with state per
+	// channel.
+	biquad struct {
+		b0, b1, b2, a1, a2 float64
+		x1, x2, y1, y2     []float64
+	}
+)
+
+// ParseEQ parses EQ spec into bands. Spec is a comma-separated list of
+// bands with the following format:
+//
+//	highpass|lowpass:frequency[:q]
+//	peak|lowshelf|highshelf:frequency:gain[:q]
+func ParseEQ(spec string) ([]Band, error) {
+	var bands []Band
+	for _, s := range strings.Split(spec, ",") {
+		band, err := parseBand(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		bands = append(bands, band)
+	}
+	return bands, nil
+}
+
+func parseBand(s string) (Band, error) {
+	parts := strings.Split(s, ":")
+	band := Band{Type: FilterType(parts[0]), Q: defaultQ}
+	args := parts[1:]
+	switch band.Type {
+	case HighPass, LowPass:
+		if len(args) < 1 || len(args) > 2 {
+			return Band{}, fmt.Errorf("invalid eq band %q: must be %s:frequency[:q]", s, band.Type)
+		}
+	case Peak, LowShelf, HighShelf:
+		if len(args) < 2 || len(args) > 3 {
+			return Band{}, fmt.Errorf("invalid eq band %q: must be %s:frequency:gain[:q]", s, band.Type)
+		}
+	default:
+		return Band{}, fmt.Errorf("invalid eq band %q: unknown filter type %q", s, parts[0])
+	}
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return Band{}, fmt.Errorf("invalid eq band %q: %q is not a number", s, arg)
+		}
+		values[i] = v
+	}
+	band.Frequency, values = values[0], values[1:]
+	if band.Type != HighPass && band.Type != LowPass {
+		band.Gain, values = values[0], values[1:]
+	}
+	if len(values) > 0 {
+		band.Q = values[0]
+	}
+	if band.Frequency <= 0 || band.Q <= 0 {
+		return Band{}, fmt.Errorf("invalid eq band %q: frequency and q must be positive", s)
+	}
+	return band, nil
+}
+
+// EQ applies the bands to the signal in provided order. Allocation fails
+// if band frequency is not below the nyquist frequency of the signal.
+func EQ(bands ...Band) pipe.ProcessorAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+		nyquist := float64(props.SampleRate) / 2
+		filters := make([]biquad, len(bands))
+		for i, band := range bands {
+			if band.Frequency >= nyquist {
+				return pipe.Processor{}, fmt.Errorf("eq band %s frequency %g must be below %g", band.Type, band.Frequency, nyquist)
+			}
+			filters[i] = newBiquad(band, float64(props.SampleRate), props.Channels)
+		}
+		return pipe.Processor{
+			SignalProperties: props,
+			ProcessFunc: func(in, out signal.Floating) (int, error) {
+				channels := in.Channels()
+				for i := 0; i < in.Len(); i++ {
+					v := in.Sample(i)
+					for f := range filters {
+						v = filters[f].process(i%channels, v)
+					}
+					out.SetSample(i, v)
+				}
+				return in.Length(), nil
+			},
+		}, nil
+	}
+}
+
+// newBiquad returns the filter with coefficients of audio EQ cookbook.
+func newBiquad(band Band, sampleRate float64, channels int) biquad {
+	w := 2 * math.Pi * band.Frequency / sampleRate
+	cos, alpha := math.Cos(w), math.Sin(w)/(2*band.Q)
+	a := math.Pow(10, band.Gain/40)
+	var b0, b1, b2, a0, a1, a2 float64
+	switch band.Type {
+	case HighPass:
+		b0, b1, b2 = (1+cos)/2, -(1 + cos), (1+cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case LowPass:
+		b0, b1, b2 = (1-cos)/2, 1-cos, (1-cos)/2
+		a0, a1, a2 = 1+alpha, -2*cos, 1-alpha
+	case Peak:
+		b0, b1, b2 = 1+alpha*a, -2*cos, 1-alpha*a
+		a0, a1, a2 = 1+alpha/a, -2*cos, 1-alpha/a
+	case LowShelf:
+		s := 2 * math.Sqrt(a) * alpha
+		b0, b1, b2 = a*((a+1)-(a-1)*cos+s), 2*a*((a-1)-(a+1)*cos), a*((a+1)-(a-1)*cos-s)
+		a0, a1, a2 = (a+1)+(a-1)*cos+s, -2*((a-1)+(a+1)*cos), (a+1)+(a-1)*cos-s
+	case HighShelf:
+		s := 2 * math.Sqrt(a) * alpha
+		b0, b1, b2 = a*((a+1)+(a-1)*cos+s), -2*a*((a-1)+(a+1)*cos), a*((a+1)+(a-1)*cos-s)
+		a0, a1, a2 = (a+1)-(a-1)*cos+s, 2*((a-1)-(a+1)*cos), (a+1)-(a-1)*cos-s
+	}
+	return biquad{
+		b0: b0 / a0,
+		b1: b1 / a0,
+		b2: b2 / a0,
+		a1: a1 / a0,
+		a2: a2 / a0,
+		x1: make([]float64, channels),
+		x2: make([]float64, channels),
+		y1: make([]float64, channels),
+		y2: make([]float64, channels),
+	}
+}
+
+func (f *biquad) process(c int, x float64) float64 {
+	y := f.b0*x + f.b1*f.x1[c] + f.b2*f.x2[c] - f.a1*f.y1[c] - f.a2*f.y2[c]
+	f.x2[c], f.x1[c] = f.x1[c], x
+	f.y2[c], f.y1[c] = f.y1[c], y
+	return y
+}
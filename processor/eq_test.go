@@ -0,0 +1,87 @@
+package processor_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/processor"
+)
+
+func TestParseEQ(t *testing.T) {
+	bands, err := processor.ParseEQ("highpass:80, peak:3000:-4:1.0,lowshelf:120:+2")
+	assert.NoError(t, err)
+	assert.Equal(t, []processor.Band{
+		{Type: processor.HighPass, Frequency: 80, Q: math.Sqrt2 / 2},
+		{Type: processor.Peak, Frequency: 3000, Gain: -4, Q: 1},
+		{Type: processor.LowShelf, Frequency: 120, Gain: 2, Q: math.Sqrt2 / 2},
+	}, bands)
+
+	for _, spec := range []string{
+		"",
+		"notch:100",
+		"highpass",
+		"highpass:80:1:2",
+		"peak:3000",
+		"peak:3000:x",
+		"lowpass:-100",
+		"lowpass:100:0",
+		"lowpass:NaN",
+		"lowpass:Inf",
+		"peak:3000:NaN",
+		"peak:3000:-Inf",
+		"peak:3000:1:+Inf",
+	} {
+		_, err := processor.ParseEQ(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestEQ(t *testing.T) {
+	// amplitude of sine after eq is applied
+	amplitude := func(bands []processor.Band, frequency float64) float64 {
+		const sampleRate, frames = 44100, 44100
+		p, err := processor.EQ(bands...)(mutable.Mutable(), frames, pipe.SignalProperties{
+			SampleRate: sampleRate,
+			Channels:   1,
+		})
+		assert.NoError(t, err)
+		in := signal.Allocator{Channels: 1, Length: frames, Capacity: frames}.Float64()
+		out := signal.Allocator{Channels: 1, Length: frames, Capacity: frames}.Float64()
+		for i := 0; i < frames; i++ {
+			in.SetSample(i, math.Sin(2*math.Pi*frequency*float64(i)/sampleRate))
+		}
+		n, err := p.ProcessFunc(in, out)
+		assert.NoError(t, err)
+		assert.Equal(t, frames, n)
+		var peak float64
+		// skip the transient response
+		for i := frames / 2; i < frames; i++ {
+			peak = math.Max(peak, math.Abs(out.Sample(i)))
+		}
+		return 20 * math.Log10(peak)
+	}
+
+	highpass := []processor.Band{{Type: processor.HighPass, Frequency: 1000, Q: math.Sqrt2 / 2}}
+	assert.InDelta(t, -3, amplitude(highpass, 1000), 0.1)
+	assert.InDelta(t, 0, amplitude(highpass, 10000), 0.1)
+	assert.Less(t, amplitude(highpass, 100), -30.0)
+
+	peak := []processor.Band{{Type: processor.Peak, Frequency: 3000, Gain: -4, Q: 1}}
+	assert.InDelta(t, -4, amplitude(peak, 3000), 0.1)
+	assert.InDelta(t, 0, amplitude(peak, 100), 0.1)
+
+	shelf := []processor.Band{{Type: processor.LowShelf, Frequency: 120, Gain: 2, Q: math.Sqrt2 / 2}}
+	assert.InDelta(t, 2, amplitude(shelf, 20), 0.1)
+	assert.InDelta(t, 0, amplitude(shelf, 5000), 0.1)
+
+	_, err := processor.EQ(processor.Band{Type: processor.LowPass, Frequency: 30000, Q: 1})(mutable.Mutable(), 512, pipe.SignalProperties{
+		SampleRate: 44100,
+		Channels:   2,
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+func init() {
+	Register(Processor{
+		Name:         "gain",
+		Description:  "change volume by db decibels",
+		Experimental: true,
+		New:          newGain,
+	})
+}
+
+func newGain(params Params) (pipe.ProcessorAllocatorFunc, error) {
+	db, err := strconv.ParseFloat(params["db"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid db value: %q", params["db"])
+	}
+	return Gain(db), nil
+}
+
+// Gain multiplies the signal by the factor of db decibels.
+func Gain(db float64) pipe.ProcessorAllocatorFunc {
+	factor := math.Pow(10, db/20)
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+		return pipe.Processor{
+			SignalProperties: props,
+			ProcessFunc: func(in, out signal.Floating) (int, error) {
+				for i := 0; i < in.Len(); i++ {
+					out.SetSample(i, in.Sample(i)*factor)
+				}
+				return in.Length(), nil
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// defaultRelease is the release time of limiter if it's not provided.
+const defaultRelease = 50 * time.Millisecond
+
+func init() {
+	Register(Processor{
+		Name:         "limiter",
+		Description:  "keep sample peaks under ceiling db, gain recovers in release duration",
+		Experimental: true,
+		New:          newLimiter,
+	})
+}
+
+func newLimiter(params Params) (pipe.ProcessorAllocatorFunc, error) {
+	ceiling, err := strconv.ParseFloat(params["ceiling"], 64)
+	if err != nil || ceiling > 0 {
+		return nil, fmt.Errorf("invalid ceiling value: %q", params["ceiling"])
+	}
+	release := defaultRelease
+	if v, ok := params["release"]; ok {
+		if release, err = time.ParseDuration(v); err != nil || release <= 0 {
+			return nil, fmt.Errorf("invalid release value: %q", v)
+		}
+	}
+	return Limiter(ceiling, release), nil
+}
+
+// Limiter keeps sample peaks of the signal under ceiling db. The gain
+// drops instantly when a frame exceeds the ceiling and then recovers
+// exponentially with release time constant. All channels get the same
+// gain, so the stereo image is kept.
+//
+// It is a sample peak limiter: the signal is not oversampled, so peaks
+// between samples are not detected and the true peak of the output can
+// exceed the ceiling, usually by less than 1 dB. Catching them would
+// need the look-ahead of interpolation filter, which delays the output.
+func Limiter(ceilingDB float64, release time.Duration) pipe.ProcessorAllocatorFunc {
+	ceiling := math.Pow(10, ceilingDB/20)
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+		recovery := math.Exp(-1 / (release.Seconds() * float64(props.SampleRate)))
+		gain := 1.0
+		return pipe.Processor{
+			SignalProperties: props,
+			ProcessFunc: func(in, out signal.Floating) (int, error) {
+				channels := in.Channels()
+				for i := 0; i < in.Len(); i += channels {
+					var peak float64
+					for c := 0; c < channels; c++ {
+						peak = math.Max(peak, math.Abs(in.Sample(i+c)))
+					}
+					gain = 1 - (1-gain)*recovery
+					if peak*gain > ceiling {
+						gain = ceiling / peak
+					}
+					for c := 0; c < channels; c++ {
+						out.SetSample(i+c, in.Sample(i+c)*gain)
+					}
+				}
+				return in.Length(), nil
+			},
+		}, nil
+	}
+}
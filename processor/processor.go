@@ -0,0 +1,160 @@
+// Package processor provides the registry of DSP processors that can be
+// selected in CLI and API. Processors can be registered as experimental,
+// such processors are available only if experimental features are
+// enabled. Experimental processors can be referred with experimental
+// namespace prefix, so pipelines keep working after they are promoted.
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"pipelined.dev/pipe"
+)
+
+// ExperimentalPrefix is the namespace of experimental processors.
+const ExperimentalPrefix = "experimental."
+
+// Default registry is used by package functions.
+var Default = NewRegistry()
+
+var (
+	// ErrNotFound is returned if processor is not registered.
+	ErrNotFound = errors.New("processor not found")
+	// ErrExperimental is returned if experimental processor is
+	// requested, but experimental features are disabled.
+	ErrExperimental = errors.New("processor is experimental, enable experimental features to use it")
+)
+
+type (
+	// Params are named parameters of the processor.
+	Params map[string]string
+
+	// Processor describes registered processor. New returns allocator
+	// configured with provided parameters.
+	Processor struct {
+		Name         string
+		Description  string
+		Experimental bool
+		New          func(Params) (pipe.ProcessorAllocatorFunc, error)
+	}
+
+	// Registry holds processors by their names.
+	Registry struct {
+		mu         sync.Mutex
+		processors map[string]Processor
+	}
+)
+
+// NewRegistry returns empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		processors: make(map[string]Processor),
+	}
+}
+
+// Register adds processor to the default registry.
+func Register(p Processor) {
+	Default.Register(p)
+}
+
+// Register adds processor to the registry. Name must be unique and must
+// not contain experimental prefix.
+func (r *Registry) Register(p Processor) {
+	if strings.HasPrefix(p.Name, ExperimentalPrefix) {
+		panic(fmt.Sprintf("processor %s must be registered without %s prefix", p.Name, ExperimentalPrefix))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.processors[p.Name]; ok {
+		panic(fmt.Sprintf("processor %s already registered", p.Name))
+	}
+	r.processors[p.Name] = p
+}
+
+// Lookup returns processor by its name. Name can have experimental
+// prefix, it's ignored if processor was promoted. Experimental processor
+// is returned only if experimental is true.
+func (r *Registry) Lookup(name string, experimental bool) (Processor, error) {
+	r.mu.Lock()
+	p, ok := r.processors[strings.TrimPrefix(name, ExperimentalPrefix)]
+	r.mu.Unlock()
+	if !ok {
+		return Processor{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if p.Experimental && !experimental {
+		return Processor{}, fmt.Errorf("%w: %s", ErrExperimental, name)
+	}
+	return p, nil
+}
+
+// List returns processors sorted by name. Experimental processors are
+// listed only if experimental is true.
+func (r *Registry) List(experimental bool) []Processor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]Processor, 0, len(r.processors))
+	for _, p := range r.processors {
+		if p.Experimental && !experimental {
+			continue
+		}
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Allocators returns allocators of processors defined by specs. Spec has
+// the following format:
+//
+//	name[:key=value[,key=value]]
+//
+// Warning is called for every experimental processor in use.
+func (r *Registry) Allocators(specs []string, experimental bool, warning func(Processor)) ([]pipe.ProcessorAllocatorFunc, error) {
+	allocators := make([]pipe.ProcessorAllocatorFunc, 0, len(specs))
+	for _, spec := range specs {
+		name, params, err := ParseSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		p, err := r.Lookup(name, experimental)
+		if err != nil {
+			return nil, err
+		}
+		if p.Experimental && warning != nil {
+			warning(p)
+		}
+		fn, err := p.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", p.Name, err)
+		}
+		allocators = append(allocators, fn)
+	}
+	return allocators, nil
+}
+
+// ParseSpec parses processor spec into name and parameters.
+func ParseSpec(spec string) (string, Params, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	name := strings.TrimSpace(parts[0])
+	if name == "" {
+		return "", nil, fmt.Errorf("invalid processor spec: %q", spec)
+	}
+	params := Params{}
+	if len(parts) == 1 || parts[1] == "" {
+		return name, params, nil
+	}
+	for _, param := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return "", nil, fmt.Errorf("invalid processor parameter: %q", param)
+		}
+		params[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return name, params, nil
+}
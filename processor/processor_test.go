@@ -0,0 +1,59 @@
+package processor_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/processor"
+)
+
+func TestParseSpec(t *testing.T) {
+	name, params, err := processor.ParseSpec("gain:db=-3, mode = fast")
+	assert.NoError(t, err)
+	assert.Equal(t, "gain", name)
+	assert.Equal(t, processor.Params{"db": "-3", "mode": "fast"}, params)
+
+	name, params, err = processor.ParseSpec("gain")
+	assert.NoError(t, err)
+	assert.Equal(t, "gain", name)
+	assert.Empty(t, params)
+
+	_, _, err = processor.ParseSpec(":db=1")
+	assert.Error(t, err)
+	_, _, err = processor.ParseSpec("gain:db")
+	assert.Error(t, err)
+}
+
+func TestRegistry(t *testing.T) {
+	r := processor.NewRegistry()
+	newFn := func(processor.Params) (pipe.ProcessorAllocatorFunc, error) {
+		return processor.Gain(0), nil
+	}
+	r.Register(processor.Processor{Name: "stable", New: newFn})
+	r.Register(processor.Processor{Name: "new", Experimental: true, New: newFn})
+	assert.Panics(t, func() { r.Register(processor.Processor{Name: "stable"}) })
+	assert.Panics(t, func() { r.Register(processor.Processor{Name: "experimental.other"}) })
+
+	_, err := r.Lookup("new", false)
+	assert.True(t, errors.Is(err, processor.ErrExperimental))
+	_, err = r.Lookup("missing", true)
+	assert.True(t, errors.Is(err, processor.ErrNotFound))
+	// promoted processor is available with experimental prefix
+	p, err := r.Lookup("experimental.stable", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "stable", p.Name)
+
+	assert.Equal(t, 1, len(r.List(false)))
+	assert.Equal(t, 2, len(r.List(true)))
+
+	var warned []string
+	allocators, err := r.Allocators([]string{"stable", "experimental.new"}, true, func(p processor.Processor) {
+		warned = append(warned, p.Name)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(allocators))
+	assert.Equal(t, []string{"new"}, warned)
+}
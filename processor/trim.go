@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// Trim drops the first start frames of the signal, passes the next length
+// frames and drops the rest.
+func Trim(start, length int) pipe.ProcessorAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Processor, error) {
+		// position of the next frame
+		var pos int
+		return pipe.Processor{
+			SignalProperties: props,
+			ProcessFunc: func(in, out signal.Floating) (int, error) {
+				channels := in.Channels()
+				frames := in.Length()
+				// frames of the buffer to keep
+				from, to := start-pos, start+length-pos
+				pos += frames
+				if from < 0 {
+					from = 0
+				}
+				if to > frames {
+					to = frames
+				}
+				if from >= to {
+					return 0, nil
+				}
+				for i := from * channels; i < to*channels; i++ {
+					out.SetSample(i-from*channels, in.Sample(i))
+				}
+				return to - from, nil
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,115 @@
+// Package publicnet provides HTTP client that connects only to public
+// addresses. It's used for URLs provided by users, e.g. source urls and
+// callbacks, so they can't reach services of the internal network.
+package publicnet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// maxRedirects is the max number of redirects followed by the client.
+const maxRedirects = 10
+
+// ErrNotPublic is returned if the address of connection is not public.
+var ErrNotPublic = errors.New("address is not public")
+
+// nonPublic are networks of loopback, private, link-local, multicast and
+// reserved addresses.
+var nonPublic = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// Client is the HTTP client that connects only to public addresses.
+// Addresses are checked after name resolution, so host names that
+// resolve to internal addresses are rejected as well. Proxy of
+// environment is not used, because it would connect on behalf of the
+// client.
+var Client = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   Control,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	},
+}
+
+// Control rejects connections to addresses that are not public. It has
+// the signature of net.Dialer Control.
+func Control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !IsPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, host)
+	}
+	return nil
+}
+
+// IsPublic returns true if ip is not loopback, private, link-local,
+// multicast or reserved address.
+func IsPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckRedirect returns the redirect policy of http.Client that follows
+// up to 10 redirects to URLs accepted by check.
+func CheckRedirect(check func(*url.URL) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if err := check(req.URL); err != nil {
+			return fmt.Errorf("redirect rejected: %w", err)
+		}
+		return nil
+	}
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
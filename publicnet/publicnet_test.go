@@ -0,0 +1,57 @@
+package publicnet_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/publicnet"
+)
+
+func TestIsPublic(t *testing.T) {
+	for addr, public := range map[string]bool{
+		"8.8.8.8":             true,
+		"2001:4860::8888":     true,
+		"127.0.0.1":           false,
+		"10.1.2.3":            false,
+		"172.20.0.1":          false,
+		"192.168.1.1":         false,
+		"169.254.169.254":     false,
+		"100.64.0.1":          false,
+		"0.0.0.0":             false,
+		"::1":                 false,
+		"fe80::1":             false,
+		"fd00::1":             false,
+		"::ffff:127.0.0.1":    false,
+		"::ffff:192.168.0.10": false,
+	} {
+		assert.Equal(t, public, publicnet.IsPublic(net.ParseIP(addr)), addr)
+	}
+	assert.NoError(t, publicnet.Control("tcp", "8.8.8.8:443", nil))
+	assert.True(t, errors.Is(publicnet.Control("tcp", "[::1]:80", nil), publicnet.ErrNotPublic))
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := publicnet.Client.Get(server.URL)
+	assert.True(t, errors.Is(err, publicnet.ErrNotPublic))
+
+	checkRedirect := publicnet.CheckRedirect(func(u *url.URL) error {
+		if u.Scheme != "https" {
+			return errors.New("scheme is not allowed")
+		}
+		return nil
+	})
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/b", nil)
+	assert.NoError(t, checkRedirect(req, []*http.Request{req}))
+	assert.Error(t, checkRedirect(req, make([]*http.Request, 10)))
+	req = httptest.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+	assert.Error(t, checkRedirect(req, []*http.Request{req}))
+}
@@ -0,0 +1,90 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/i18n"
+)
+
+type (
+	// Form provides user-input for http rendering.
+	Form interface {
+		Parse(*http.Request) (FormData, error)
+	}
+
+	// FormData contains parsed form data. Renderer accumulates the
+	// decoded input and renders the image in Image format.
+	FormData struct {
+		service.Input
+		Renderer
+		Image Format
+	}
+
+	handler struct {
+		form      Form
+		buffering encode.Buffering
+		timeouts  service.Timeouts
+	}
+)
+
+// Handler renders the image of input provided with the form. Only POST
+// requests are allowed. Image is rendered in memory and sent when the
+// whole input is decoded. Timeouts are applied the same way as for
+// conversions.
+func Handler(f Form, b encode.Buffering, t service.Timeouts) http.Handler {
+	return &handler{
+		form:      f,
+		buffering: b,
+		timeouts:  t,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	formData, err := h.form.Parse(r)
+	if err != nil {
+		http.Error(w, i18n.Localize(i18n.Request(r), err), http.StatusBadRequest)
+		return
+	}
+	defer formData.Close()
+
+	ctx := r.Context()
+	if h.timeouts.Conversion > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, h.timeouts.Conversion)
+		defer cancelFn()
+	}
+	bufferSize := h.buffering.BufferSize(formData.Input.Format, nil)
+	if err := encode.Run(ctx, bufferSize, h.timeouts.Stall, formData.Input.Source(formData.File), formData.Renderer.Sink()); err != nil {
+		renderError(w, r, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := formData.Render(&buf, formData.Image); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", formData.Image.ContentType())
+	w.Write(buf.Bytes())
+}
+
+// renderError sends decoding error with its code. Timed out rendering is
+// reported with 504 status.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	code := encode.Code(err)
+	status := http.StatusBadRequest
+	if errors.Is(err, encode.ErrStalled) || errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	}
+	w.Header().Set(encode.ErrorCodeHeader, string(code))
+	http.Error(w, fmt.Sprintf("%s: %s", code, i18n.Localize(i18n.Request(r), err)), status)
+}
@@ -0,0 +1,113 @@
+package render_test
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/render"
+	"pipelined.dev/phono/userinput"
+)
+
+func renderRequest(t *testing.T, method string, params map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	file, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer file.Close()
+	part, err := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+	assert.NoError(t, err)
+	_, err = io.Copy(part, file)
+	assert.NoError(t, err)
+	for key, val := range params {
+		_ = writer.WriteField(key, val)
+	}
+	assert.NoError(t, writer.Close())
+	req := httptest.NewRequest(method, "/render/.wav", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestHandler(t *testing.T) {
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	h := render.Handler(form.Waveform(), encode.Buffering{Size: 512}, service.Timeouts{})
+
+	t.Run("png", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.WidthKey:  "300",
+			userinput.HeightKey: "60",
+		}))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+		img, err := png.Decode(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, 300, img.Bounds().Dx())
+		assert.Equal(t, 60, img.Bounds().Dy())
+	})
+	t.Run("svg", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.ImageFormatKey: "svg",
+		}))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "image/svg+xml", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `width="1200" height="200"`)
+	})
+	t.Run("invalid size", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.WidthKey: "0",
+		}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("invalid format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.ImageFormatKey: "gif",
+		}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("method not allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/waveform/", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	spectrogram := render.Handler(form.Spectrogram(), encode.Buffering{Size: 512}, service.Timeouts{})
+	t.Run("spectrogram", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		spectrogram.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.FFTSizeKey:  "512",
+			userinput.WindowKey:   "blackman",
+			userinput.ColorMapKey: "gray",
+		}))
+		assert.Equal(t, http.StatusOK, w.Code)
+		img, err := png.Decode(w.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, userinput.DefaultWidth, img.Bounds().Dx())
+	})
+	t.Run("spectrogram svg", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		spectrogram.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.ImageFormatKey: "svg",
+		}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("invalid fft size", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		spectrogram.ServeHTTP(w, renderRequest(t, http.MethodPost, map[string]string{
+			userinput.FFTSizeKey: "1000",
+		}))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
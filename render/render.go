@@ -0,0 +1,77 @@
+// Package render draws images of the audio signal. Renderers accumulate
+// the signal with their sinks while the input is decoded and draw the
+// image when the pipe is done.
+package render
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"pipelined.dev/pipe"
+)
+
+// Image formats.
+const (
+	PNG Format = "png"
+	SVG Format = "svg"
+)
+
+// Limits of image size in pixels.
+const (
+	MaxWidth  = 8192
+	MaxHeight = 4096
+)
+
+type (
+	// Format of rendered image.
+	Format string
+
+	// Renderer accumulates the signal written into its sink and renders
+	// the image of it.
+	Renderer interface {
+		Sink() pipe.SinkAllocatorFunc
+		Render(w io.Writer, format Format) error
+	}
+)
+
+// ParseFormat returns image format by its name. PNG is returned for empty
+// name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "":
+		return PNG, nil
+	case PNG, SVG:
+		return f, nil
+	}
+	return "", fmt.Errorf("invalid image format %q: must be %s or %s", s, PNG, SVG)
+}
+
+// FormatByPath returns image format by the extension of path.
+func FormatByPath(path string) (Format, error) {
+	ext := filepath.Ext(path)
+	if ext == "" {
+		return "", fmt.Errorf("image format of %s is unknown: must have %s or %s extension", path, PNG, SVG)
+	}
+	return ParseFormat(ext[1:])
+}
+
+// ContentType returns mime type of the format.
+func (f Format) ContentType() string {
+	if f == SVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// CheckSize returns error if image size is out of limits.
+func CheckSize(width, height int) error {
+	if width < 1 || width > MaxWidth {
+		return fmt.Errorf("invalid width %d: must be between 1 and %d", width, MaxWidth)
+	}
+	if height < 1 || height > MaxHeight {
+		return fmt.Errorf("invalid height %d: must be between 1 and %d", height, MaxHeight)
+	}
+	return nil
+}
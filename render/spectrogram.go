@@ -0,0 +1,336 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/fft"
+)
+
+// Windows of spectrogram transforms.
+const (
+	Hann        Window = "hann"
+	Hamming     Window = "hamming"
+	Blackman    Window = "blackman"
+	Rectangular Window = "rectangular"
+)
+
+// Color maps of spectrogram from quiet to loud.
+const (
+	Gray    ColorMap = "gray"
+	Inferno ColorMap = "inferno"
+	Viridis ColorMap = "viridis"
+)
+
+// Limits and defaults of spectrogram options. FFT size must be a power
+// of two.
+const (
+	MinFFTSize      = 64
+	MaxFFTSize      = 32768
+	DefaultFFTSize  = 2048
+	DefaultWindow   = Hann
+	DefaultColorMap = Inferno
+)
+
+// spectrogramFloor is the level in dBFS that is drawn with the first
+// color of the map, full scale is drawn with the last one.
+const spectrogramFloor = -120.0
+
+type (
+	// Window is the window function applied before the transform.
+	Window string
+
+	// ColorMap maps the level of frequencies to colors.
+	ColorMap string
+
+	// Spectrogram renders the short-time Fourier transform of the
+	// signal. Time goes from left to right and frequency from zero at
+	// the bottom to nyquist at the top. Channels are mixed. Transforms
+	// are half overlapped and averaged per pixel column.
+	Spectrogram struct {
+		width   int
+		height  int
+		fftSize int
+		window  []float64
+		// scale normalizes the power, so full scale sine is at 0 dBFS.
+		scale  float64
+		colors []color.NRGBA
+		// mono samples waiting for the transform
+		in  []float64
+		buf []complex128
+		// block is the number of transforms per block and count is the
+		// number of transforms in the current one. Completed blocks
+		// keep sums of row powers, they are merged when there are too
+		// many of them.
+		block  int
+		count  int
+		cur    []float64
+		rows   []float64
+		counts []int
+	}
+)
+
+// windows are the functions of window position between 0 and 1.
+var windows = map[Window]func(x float64) float64{
+	Hann: func(x float64) float64 {
+		return 0.5 - 0.5*math.Cos(2*math.Pi*x)
+	},
+	Hamming: func(x float64) float64 {
+		return 0.54 - 0.46*math.Cos(2*math.Pi*x)
+	},
+	Blackman: func(x float64) float64 {
+		return 0.42 - 0.5*math.Cos(2*math.Pi*x) + 0.08*math.Cos(4*math.Pi*x)
+	},
+	Rectangular: func(x float64) float64 {
+		return 1
+	},
+}
+
+// colorMaps are the gradient stops of color maps.
+var colorMaps = map[ColorMap][]color.NRGBA{
+	Gray: {
+		{0x00, 0x00, 0x00, 0xff},
+		{0xff, 0xff, 0xff, 0xff},
+	},
+	Inferno: {
+		{0x00, 0x00, 0x04, 0xff},
+		{0x42, 0x0a, 0x68, 0xff},
+		{0x93, 0x26, 0x67, 0xff},
+		{0xdd, 0x51, 0x3a, 0xff},
+		{0xfc, 0xa5, 0x0a, 0xff},
+		{0xfc, 0xff, 0xa4, 0xff},
+	},
+	Viridis: {
+		{0x44, 0x01, 0x54, 0xff},
+		{0x41, 0x44, 0x87, 0xff},
+		{0x2a, 0x78, 0x8e, 0xff},
+		{0x22, 0xa8, 0x84, 0xff},
+		{0x7a, 0xd1, 0x51, 0xff},
+		{0xfd, 0xe7, 0x25, 0xff},
+	},
+}
+
+// ParseWindow returns window by its name. DefaultWindow is returned for
+// empty name.
+func ParseWindow(s string) (Window, error) {
+	if s == "" {
+		return DefaultWindow, nil
+	}
+	if _, ok := windows[Window(s)]; !ok {
+		return "", fmt.Errorf("invalid window %q: must be %s, %s, %s or %s", s, Hann, Hamming, Blackman, Rectangular)
+	}
+	return Window(s), nil
+}
+
+// ParseColorMap returns color map by its name. DefaultColorMap is
+// returned for empty name.
+func ParseColorMap(s string) (ColorMap, error) {
+	if s == "" {
+		return DefaultColorMap, nil
+	}
+	if _, ok := colorMaps[ColorMap(s)]; !ok {
+		return "", fmt.Errorf("invalid color map %q: must be %s, %s or %s", s, Gray, Inferno, Viridis)
+	}
+	return ColorMap(s), nil
+}
+
+// CheckFFTSize returns error if FFT size is out of limits or it's not a
+// power of two.
+func CheckFFTSize(size int) error {
+	if size < MinFFTSize || size > MaxFFTSize || size&(size-1) != 0 {
+		return fmt.Errorf("invalid fft size %d: must be a power of two between %d and %d", size, MinFFTSize, MaxFFTSize)
+	}
+	return nil
+}
+
+// NewSpectrogram returns the spectrogram renderer of provided image size.
+func NewSpectrogram(width, height, fftSize int, window Window, colors ColorMap) (*Spectrogram, error) {
+	if err := CheckSize(width, height); err != nil {
+		return nil, err
+	}
+	if err := CheckFFTSize(fftSize); err != nil {
+		return nil, err
+	}
+	fn, ok := windows[window]
+	if !ok {
+		return nil, fmt.Errorf("invalid window %q", window)
+	}
+	stops, ok := colorMaps[colors]
+	if !ok {
+		return nil, fmt.Errorf("invalid color map %q", colors)
+	}
+	s := Spectrogram{
+		width:   width,
+		height:  height,
+		fftSize: fftSize,
+		window:  make([]float64, fftSize),
+		colors:  stops,
+		buf:     make([]complex128, fftSize),
+		block:   1,
+		cur:     make([]float64, height),
+	}
+	var sum float64
+	for i := range s.window {
+		s.window[i] = fn(float64(i) / float64(fftSize))
+		sum += s.window[i]
+	}
+	// amplitude of sine is twice the magnitude of its bin divided by sum
+	s.scale = 4 / (sum * sum)
+	return &s, nil
+}
+
+// Sink returns the sink that transforms the signal.
+func (s *Spectrogram) Sink() pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				channels := in.Channels()
+				for i := 0; i < in.Length(); i++ {
+					var v float64
+					for c := 0; c < channels; c++ {
+						v += in.Sample(i*channels + c)
+					}
+					s.in = append(s.in, v/float64(channels))
+				}
+				hop, offset := s.fftSize/2, 0
+				for ; len(s.in)-offset >= s.fftSize; offset += hop {
+					s.transform(s.in[offset : offset+s.fftSize])
+				}
+				s.in = s.in[:copy(s.in, s.in[offset:])]
+				return nil
+			},
+		}, nil
+	}
+}
+
+// transform adds the power of samples per image row to the current
+// block. Row has the max power of its bins, so narrow tones are visible
+// on small images.
+func (s *Spectrogram) transform(samples []float64) {
+	for i, v := range samples {
+		s.buf[i] = complex(v*s.window[i], 0)
+	}
+	fft.Transform(s.buf)
+	bins := s.fftSize / 2
+	for r := range s.cur {
+		from, to := r*bins/s.height, (r+1)*bins/s.height
+		if to <= from {
+			to = from + 1
+		}
+		var max float64
+		for _, b := range s.buf[from:to] {
+			if p := real(b)*real(b) + imag(b)*imag(b); p > max {
+				max = p
+			}
+		}
+		s.cur[r] += max * s.scale
+	}
+	s.count++
+	if s.count == s.block {
+		s.addBlock()
+	}
+}
+
+// addBlock completes the current block. If there are too many blocks,
+// adjacent ones are merged.
+func (s *Spectrogram) addBlock() {
+	s.rows = append(s.rows, s.cur...)
+	s.counts = append(s.counts, s.count)
+	for r := range s.cur {
+		s.cur[r] = 0
+	}
+	s.count = 0
+	if len(s.counts) < 2*s.width {
+		return
+	}
+	h := s.height
+	for i := 0; i < len(s.counts)/2; i++ {
+		for r := 0; r < h; r++ {
+			s.rows[i*h+r] = s.rows[2*i*h+r] + s.rows[(2*i+1)*h+r]
+		}
+		s.counts[i] = s.counts[2*i] + s.counts[2*i+1]
+	}
+	s.counts = s.counts[:len(s.counts)/2]
+	s.rows = s.rows[:len(s.counts)*h]
+	s.block *= 2
+}
+
+// Levels returns levels in dBFS of every pixel with the lowest frequency
+// first in every column. If the signal is shorter than the fft size, it's
+// padded with silence. Levels of empty signal are -Inf.
+func (s *Spectrogram) Levels() [][]float64 {
+	if len(s.counts) == 0 && s.count == 0 && len(s.in) > 0 {
+		s.transform(append(s.in, make([]float64, s.fftSize-len(s.in))...))
+	}
+	if s.count > 0 {
+		s.addBlock()
+	}
+	levels := make([][]float64, s.width)
+	n := len(s.counts)
+	for x := range levels {
+		levels[x] = make([]float64, s.height)
+		if n == 0 {
+			for r := range levels[x] {
+				levels[x][r] = math.Inf(-1)
+			}
+			continue
+		}
+		from, to := x*n/s.width, (x+1)*n/s.width
+		if to <= from {
+			to = from + 1
+		}
+		var count int
+		for i := from; i < to; i++ {
+			count += s.counts[i]
+			for r := range levels[x] {
+				levels[x][r] += s.rows[i*s.height+r]
+			}
+		}
+		for r := range levels[x] {
+			levels[x][r] = 10 * math.Log10(levels[x][r]/float64(count))
+		}
+	}
+	return levels
+}
+
+// Render writes the image of the spectrogram. Only PNG format is
+// supported.
+func (s *Spectrogram) Render(out io.Writer, format Format) error {
+	if format != PNG {
+		return fmt.Errorf("spectrogram cannot be rendered in %s format", format)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, s.width, s.height))
+	for x, column := range s.Levels() {
+		for r, db := range column {
+			img.SetNRGBA(x, s.height-1-r, s.color(db))
+		}
+	}
+	return png.Encode(out, img)
+}
+
+// color returns the color of level in dBFS.
+func (s *Spectrogram) color(db float64) color.NRGBA {
+	t := (db - spectrogramFloor) / -spectrogramFloor
+	if !(t > 0) {
+		return s.colors[0]
+	}
+	if t >= 1 {
+		return s.colors[len(s.colors)-1]
+	}
+	pos := t * float64(len(s.colors)-1)
+	i := int(pos)
+	f := pos - float64(i)
+	a, b := s.colors[i], s.colors[i+1]
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f))
+	}
+	return color.NRGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
@@ -0,0 +1,102 @@
+package render_test
+
+import (
+	"bytes"
+	"image/png"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/render"
+)
+
+func TestSpectrogramOptions(t *testing.T) {
+	for _, size := range []int{32, 1000, 65536} {
+		assert.Error(t, render.CheckFFTSize(size))
+	}
+	assert.NoError(t, render.CheckFFTSize(render.DefaultFFTSize))
+
+	window, err := render.ParseWindow("")
+	assert.NoError(t, err)
+	assert.Equal(t, render.DefaultWindow, window)
+	_, err = render.ParseWindow("kaiser")
+	assert.Error(t, err)
+
+	colors, err := render.ParseColorMap("viridis")
+	assert.NoError(t, err)
+	assert.Equal(t, render.Viridis, colors)
+	_, err = render.ParseColorMap("jet")
+	assert.Error(t, err)
+
+	_, err = render.NewSpectrogram(100, 100, 1000, render.Hann, render.Gray)
+	assert.Error(t, err)
+}
+
+func TestSpectrogram(t *testing.T) {
+	const (
+		fftSize = 1024
+		// sine is at the center of the bin
+		bin = 128
+	)
+	for _, window := range []render.Window{render.Hann, render.Hamming, render.Blackman, render.Rectangular} {
+		t.Run(string(window), func(t *testing.T) {
+			// height matches the number of bins, so every row is a bin
+			s, err := render.NewSpectrogram(8, fftSize/2, fftSize, window, render.Inferno)
+			assert.NoError(t, err)
+			samples := make([]float64, 20*fftSize)
+			for i := range samples {
+				samples[i] = math.Sin(2 * math.Pi * bin * float64(i) / fftSize)
+			}
+			writeStereo(t, s.Sink(), 1000, samples)
+			levels := s.Levels()
+			assert.Equal(t, 8, len(levels))
+			for _, column := range levels {
+				assert.Equal(t, fftSize/2, len(column))
+				// channels are mixed, so the level of 0.75 amplitude
+				assert.InDelta(t, 20*math.Log10(0.75), column[bin], 0.1)
+				if window != render.Rectangular {
+					assert.Less(t, column[bin/2], -60.0)
+				}
+			}
+		})
+	}
+	t.Run("short signal", func(t *testing.T) {
+		s, err := render.NewSpectrogram(4, 16, fftSize, render.Hann, render.Gray)
+		assert.NoError(t, err)
+		writeStereo(t, s.Sink(), 100, []float64{0.5, -0.5, 0.5})
+		for _, column := range s.Levels() {
+			assert.False(t, math.IsInf(column[0], -1))
+		}
+	})
+	t.Run("empty signal", func(t *testing.T) {
+		s, err := render.NewSpectrogram(4, 16, fftSize, render.Hann, render.Gray)
+		assert.NoError(t, err)
+		for _, column := range s.Levels() {
+			assert.True(t, math.IsInf(column[0], -1))
+		}
+	})
+}
+
+func TestSpectrogramRender(t *testing.T) {
+	s, err := render.NewSpectrogram(30, 20, 256, render.Hann, render.Gray)
+	assert.NoError(t, err)
+	samples := make([]float64, 4096)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * float64(i) / 16)
+	}
+	writeStereo(t, s.Sink(), 512, samples)
+
+	var b bytes.Buffer
+	assert.Error(t, s.Render(&b, render.SVG))
+	assert.NoError(t, s.Render(&b, render.PNG))
+	img, err := png.Decode(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, img.Bounds().Dx())
+	assert.Equal(t, 20, img.Bounds().Dy())
+	// sine at 1/16 of sample rate is in the third row from the bottom,
+	// it's brighter than the top
+	tone, _, _, _ := img.At(15, 17).RGBA()
+	top, _, _, _ := img.At(15, 0).RGBA()
+	assert.Greater(t, tone, top)
+}
@@ -0,0 +1,171 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+)
+
+// DefaultWaveformColor is the color of waveform if it's not provided.
+var DefaultWaveformColor = color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff}
+
+// columnBlocks is the min number of blocks per pixel column. Since the
+// length of the signal is not known while it's written, peaks are kept
+// in blocks that are merged when there are too many of them.
+const columnBlocks = 4
+
+// Waveform renders min and max peaks of the signal per pixel column.
+// Channels are mixed, so the peaks of all channels are drawn. The
+// background is transparent.
+type Waveform struct {
+	width  int
+	height int
+	color  color.RGBA
+	// block is the number of frames per block and frames is the number
+	// of frames in the current one.
+	block  int
+	frames int
+	min    []float64
+	max    []float64
+	curMin float64
+	curMax float64
+}
+
+// NewWaveform returns the waveform renderer of provided image size.
+func NewWaveform(width, height int, c color.RGBA) (*Waveform, error) {
+	if err := CheckSize(width, height); err != nil {
+		return nil, err
+	}
+	return &Waveform{
+		width:  width,
+		height: height,
+		color:  c,
+		block:  1,
+		curMin: math.Inf(1),
+		curMax: math.Inf(-1),
+	}, nil
+}
+
+// Sink returns the sink that accumulates the peaks of the signal.
+func (w *Waveform) Sink() pipe.SinkAllocatorFunc {
+	return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{
+			SinkFunc: func(in signal.Floating) error {
+				channels := in.Channels()
+				for i := 0; i < in.Len(); i++ {
+					v := in.Sample(i)
+					w.curMin = math.Min(w.curMin, v)
+					w.curMax = math.Max(w.curMax, v)
+					if (i+1)%channels == 0 {
+						w.frames++
+						if w.frames == w.block {
+							w.addBlock()
+						}
+					}
+				}
+				return nil
+			},
+		}, nil
+	}
+}
+
+// addBlock completes the current block. If there are too many blocks,
+// adjacent ones are merged.
+func (w *Waveform) addBlock() {
+	w.min = append(w.min, w.curMin)
+	w.max = append(w.max, w.curMax)
+	w.frames, w.curMin, w.curMax = 0, math.Inf(1), math.Inf(-1)
+	if len(w.min) < 2*columnBlocks*w.width {
+		return
+	}
+	for i := 0; i < len(w.min)/2; i++ {
+		w.min[i] = math.Min(w.min[2*i], w.min[2*i+1])
+		w.max[i] = math.Max(w.max[2*i], w.max[2*i+1])
+	}
+	w.min, w.max = w.min[:len(w.min)/2], w.max[:len(w.max)/2]
+	w.block *= 2
+}
+
+// Columns returns min and max peaks of every pixel column. If the signal
+// is shorter than the width, blocks are stretched over several columns.
+// Peaks of empty signal are zero.
+func (w *Waveform) Columns() (min, max []float64) {
+	if w.frames > 0 {
+		w.addBlock()
+	}
+	min, max = make([]float64, w.width), make([]float64, w.width)
+	n := len(w.min)
+	if n == 0 {
+		return min, max
+	}
+	for x := 0; x < w.width; x++ {
+		from, to := x*n/w.width, (x+1)*n/w.width
+		if to <= from {
+			to = from + 1
+		}
+		min[x], max[x] = math.Inf(1), math.Inf(-1)
+		for i := from; i < to; i++ {
+			min[x] = math.Min(min[x], w.min[i])
+			max[x] = math.Max(max[x], w.max[i])
+		}
+	}
+	return min, max
+}
+
+// Render writes the image of accumulated peaks in provided format.
+func (w *Waveform) Render(out io.Writer, format Format) error {
+	min, max := w.Columns()
+	switch format {
+	case PNG:
+		img := image.NewNRGBA(image.Rect(0, 0, w.width, w.height))
+		for x := range min {
+			top, bottom := w.span(min[x], max[x])
+			for y := int(math.Floor(top)); y < int(math.Ceil(bottom)); y++ {
+				img.SetNRGBA(x, y, color.NRGBA(w.color))
+			}
+		}
+		return png.Encode(out, img)
+	case SVG:
+		bw := bufio.NewWriter(out)
+		fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, w.width, w.height, w.width, w.height)
+		fmt.Fprintf(bw, `<path fill="#%02x%02x%02x" d="`, w.color.R, w.color.G, w.color.B)
+		// upper edge from left to right and lower edge back
+		for x := range max {
+			top, _ := w.span(min[x], max[x])
+			cmd := "L"
+			if x == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(bw, "%s%d %.2f %d %.2f", cmd, x, top, x+1, top)
+		}
+		for x := len(min) - 1; x >= 0; x-- {
+			_, bottom := w.span(min[x], max[x])
+			fmt.Fprintf(bw, "L%d %.2f %d %.2f", x+1, bottom, x, bottom)
+		}
+		fmt.Fprint(bw, `Z"/></svg>`)
+		return bw.Flush()
+	}
+	return fmt.Errorf("waveform cannot be rendered in %s format", format)
+}
+
+// span returns vertical bounds of the column with peaks. Columns are at
+// least one pixel high, so silence is drawn as a line in the middle.
+func (w *Waveform) span(min, max float64) (top, bottom float64) {
+	h := float64(w.height)
+	top = (1 - math.Max(-1, math.Min(1, max))) / 2 * h
+	bottom = (1 - math.Max(-1, math.Min(1, min))) / 2 * h
+	if bottom-top < 1 {
+		middle := (top + bottom) / 2
+		top = math.Max(0, math.Min(h-1, middle-0.5))
+		bottom = top + 1
+	}
+	return top, bottom
+}
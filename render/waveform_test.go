@@ -0,0 +1,138 @@
+package render_test
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/render"
+)
+
+// writeStereo writes the mono samples into both channels of the sink in
+// buffers of provided size.
+func writeStereo(t *testing.T, fn pipe.SinkAllocatorFunc, bufferSize int, samples []float64) {
+	sink, err := fn(mutable.Mutable(), bufferSize, pipe.SignalProperties{SampleRate: 44100, Channels: 2})
+	assert.NoError(t, err)
+	for len(samples) > 0 {
+		n := bufferSize
+		if n > len(samples) {
+			n = len(samples)
+		}
+		buf := signal.Allocator{Channels: 2, Length: n, Capacity: n}.Float64()
+		for i, v := range samples[:n] {
+			buf.SetSample(2*i, v)
+			buf.SetSample(2*i+1, v/2)
+		}
+		assert.NoError(t, sink.SinkFunc(buf))
+		samples = samples[n:]
+	}
+}
+
+func TestWaveform(t *testing.T) {
+	_, err := render.NewWaveform(0, 10, render.DefaultWaveformColor)
+	assert.Error(t, err)
+	_, err = render.NewWaveform(10, render.MaxHeight+1, render.DefaultWaveformColor)
+	assert.Error(t, err)
+
+	t.Run("long signal", func(t *testing.T) {
+		// first half is loud, second half is quiet
+		samples := make([]float64, 100003)
+		for i := range samples {
+			v := 0.8
+			if i >= len(samples)/2 {
+				v = 0.1
+			}
+			if i%2 == 1 {
+				v = -v
+			}
+			samples[i] = v
+		}
+		w, err := render.NewWaveform(10, 100, render.DefaultWaveformColor)
+		assert.NoError(t, err)
+		writeStereo(t, w.Sink(), 1000, samples)
+		min, max := w.Columns()
+		assert.Equal(t, 10, len(min))
+		for x := 0; x < 4; x++ {
+			assert.Equal(t, 0.8, max[x])
+			assert.Equal(t, -0.8, min[x])
+		}
+		for x := 6; x < 10; x++ {
+			assert.Equal(t, 0.1, max[x])
+			assert.Equal(t, -0.1, min[x])
+		}
+	})
+	t.Run("short signal", func(t *testing.T) {
+		w, err := render.NewWaveform(6, 100, render.DefaultWaveformColor)
+		assert.NoError(t, err)
+		writeStereo(t, w.Sink(), 2, []float64{0.1, 0.2, 0.3})
+		_, max := w.Columns()
+		assert.Equal(t, []float64{0.1, 0.1, 0.2, 0.2, 0.3, 0.3}, max)
+	})
+	t.Run("empty signal", func(t *testing.T) {
+		w, err := render.NewWaveform(4, 100, render.DefaultWaveformColor)
+		assert.NoError(t, err)
+		min, max := w.Columns()
+		assert.Equal(t, []float64{0, 0, 0, 0}, min)
+		assert.Equal(t, []float64{0, 0, 0, 0}, max)
+	})
+}
+
+func TestWaveformRender(t *testing.T) {
+	w, err := render.NewWaveform(20, 50, render.DefaultWaveformColor)
+	assert.NoError(t, err)
+	// full scale first half and silent second half
+	samples := make([]float64, 80)
+	for i := 0; i < 40; i++ {
+		samples[i] = float64(1 - 2*(i%2))
+	}
+	writeStereo(t, w.Sink(), 16, samples)
+
+	var b bytes.Buffer
+	assert.NoError(t, w.Render(&b, render.PNG))
+	img, err := png.Decode(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, img.Bounds().Dx())
+	assert.Equal(t, 50, img.Bounds().Dy())
+	// full scale column is filled from top to bottom
+	_, _, _, top := img.At(0, 0).RGBA()
+	_, _, _, bottom := img.At(0, 49).RGBA()
+	assert.NotZero(t, top)
+	assert.NotZero(t, bottom)
+	// silence is a line in the middle
+	_, _, _, edge := img.At(19, 0).RGBA()
+	_, _, _, middle := img.At(19, 25).RGBA()
+	assert.Zero(t, edge)
+	assert.NotZero(t, middle)
+
+	b.Reset()
+	assert.NoError(t, w.Render(&b, render.SVG))
+	svg := b.String()
+	assert.True(t, strings.HasPrefix(svg, "<svg"))
+	assert.True(t, strings.HasSuffix(svg, "</svg>"))
+	assert.Contains(t, svg, `width="20" height="50"`)
+}
+
+func TestFormat(t *testing.T) {
+	for path, expected := range map[string]render.Format{
+		"wave.png": render.PNG,
+		"wave.SVG": render.SVG,
+	} {
+		format, err := render.FormatByPath(path)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, format)
+	}
+	for _, path := range []string{"wave", "wave.jpg"} {
+		_, err := render.FormatByPath(path)
+		assert.Error(t, err)
+	}
+	format, err := render.ParseFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, render.PNG, format)
+	assert.Equal(t, "image/svg+xml", render.SVG.ContentType())
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// metadataEndpoint is the address of EC2 instance metadata service.
+	metadataEndpoint = "http://169.254.169.254"
+	// containerEndpoint is the address of ECS container credentials.
+	containerEndpoint = "http://169.254.170.2"
+	// credentialsRefresh is the time before expiration when temporary
+	// credentials are renewed.
+	credentialsRefresh = 5 * time.Minute
+)
+
+// Credentials are AWS access keys. Temporary credentials have session
+// token and expire.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// EnvCredentials returns credentials provider that reads
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// variables. If they aren't set, temporary credentials of the IAM role
+// are requested from ECS container endpoint if
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI is set, with
+// AWS_CONTAINER_AUTHORIZATION_TOKEN if it's provided, or from EC2 instance
+// metadata otherwise. Temporary credentials are cached until they are
+// about to expire. Default client is used if it's nil.
+func EnvCredentials(client *http.Client) func(context.Context) (Credentials, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	var (
+		mu     sync.Mutex
+		cached Credentials
+	)
+	return func(ctx context.Context) (Credentials, error) {
+		if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+			return Credentials{
+				AccessKeyID:     id,
+				SecretAccessKey: secret,
+				SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			}, nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if cached.AccessKeyID != "" && time.Until(cached.Expires) > credentialsRefresh {
+			return cached, nil
+		}
+		var (
+			c   Credentials
+			err error
+		)
+		if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+			var header http.Header
+			if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+				header = http.Header{"Authorization": {token}}
+			}
+			c, err = roleCredentials(ctx, client, containerEndpoint+uri, header)
+		} else {
+			c, err = instanceCredentials(ctx, client)
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		cached = c
+		return c, nil
+	}
+}
+
+// instanceCredentials requests credentials of the instance role with
+// IMDSv2 session token.
+func instanceCredentials(ctx context.Context, client *http.Client) (Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, metadataEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "300")
+	token, err := metadata(client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("no credentials in environment and instance metadata is not available: %v", err)
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	rolesURL := metadataEndpoint + "/latest/meta-data/iam/security-credentials/"
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, rolesURL, nil); err != nil {
+		return Credentials{}, err
+	}
+	req.Header = header
+	roles, err := metadata(client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to get instance role: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return Credentials{}, fmt.Errorf("instance has no role")
+	}
+	return roleCredentials(ctx, client, rolesURL+role, header)
+}
+
+// roleCredentials requests temporary credentials in JSON format.
+func roleCredentials(ctx context.Context, client *http.Client, url string, header http.Header) (Credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	body, err := metadata(client, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to get role credentials: %v", err)
+	}
+	var result struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return Credentials{}, fmt.Errorf("invalid role credentials: %v", err)
+	}
+	return Credentials{
+		AccessKeyID:     result.AccessKeyID,
+		SecretAccessKey: result.SecretAccessKey,
+		SessionToken:    result.Token,
+		Expires:         result.Expiration,
+	}, nil
+}
+
+// metadata returns the body of successful metadata response.
+func metadata(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata request failed: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
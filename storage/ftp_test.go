@@ -0,0 +1,150 @@
+package storage_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/storage"
+)
+
+// fakeFTP is the FTP server that keeps files in memory.
+type fakeFTP struct {
+	net.Listener
+	sync.Mutex
+	files  map[string]string
+	logins int
+}
+
+func newFakeFTP(t *testing.T) *fakeFTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeFTP{Listener: l, files: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeFTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	var data net.Listener
+	reply("220 ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg := strings.TrimSpace(line), ""
+		if i := strings.IndexByte(command, ' '); i > 0 {
+			command, arg = command[:i], command[i+1:]
+		}
+		f.Lock()
+		content, exists := f.files[arg]
+		f.Unlock()
+		switch command {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			if arg != "secret" {
+				reply("530 login incorrect")
+				continue
+			}
+			f.Lock()
+			f.logins++
+			f.Unlock()
+			reply("230 logged in")
+		case "TYPE", "NOOP":
+			reply("200 ok")
+		case "SIZE":
+			if !exists {
+				reply("550 not found")
+				continue
+			}
+			reply("213 %d", len(content))
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "RETR":
+			if !exists {
+				data.Close()
+				reply("550 not found")
+				continue
+			}
+			reply("150 sending")
+			d, _ := data.Accept()
+			d.Write([]byte(content))
+			d.Close()
+			data.Close()
+			reply("226 done")
+		case "STOR":
+			reply("150 receiving")
+			d, _ := data.Accept()
+			b, _ := ioutil.ReadAll(d)
+			d.Close()
+			data.Close()
+			f.Lock()
+			f.files[arg] = string(b)
+			f.Unlock()
+			reply("226 done")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestFTP(t *testing.T) {
+	server := newFakeFTP(t)
+	defer server.Close()
+	s := storage.Storage{"ftp": &storage.FTP{Password: "secret", PasswordHosts: []string{"127.0.0.1"}}}
+	ctx := context.Background()
+	root := "ftp://user@" + server.Addr().String() + "/"
+
+	assert.NoError(t, s.Put(ctx, root+"dir/a.txt", strings.NewReader("content")))
+	assert.Equal(t, "content", server.files["dir/a.txt"])
+	r, size, err := s.Get(ctx, root+"dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+	content, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "content", string(content))
+	// connection is reused
+	assert.Equal(t, 1, server.logins)
+
+	_, _, err = s.Get(ctx, root+"missing.txt")
+	assert.Error(t, err)
+	_, _, err = s.Get(ctx, "ftp://user:wrong@"+server.Addr().String()+"/dir/a.txt")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "wrong")
+
+	// commands can't be injected with line breaks
+	err = s.Put(ctx, root+"b.txt%0D%0ADELE%20dir/a.txt", strings.NewReader("content"))
+	assert.Error(t, err)
+	assert.Len(t, server.files, 1)
+
+	// password is not sent to other hosts
+	s = storage.Storage{"ftp": &storage.FTP{Password: "secret", PasswordHosts: []string{"example.com"}}}
+	_, _, err = s.Get(ctx, root+"dir/a.txt")
+	assert.Error(t, err)
+}
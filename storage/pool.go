@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"io"
+	"net/url"
+	"sync"
+)
+
+// defaultMaxIdle is the default number of idle connections kept for
+// every server and user.
+const defaultMaxIdle = 2
+
+// pool keeps idle connections of remote backends by server and user, so
+// consecutive transfers don't log in again.
+type pool struct {
+	mu   sync.Mutex
+	idle map[string][]io.Closer
+}
+
+// poolKey returns the key of connections of the URL. Password is a part
+// of the key, so connections aren't reused with different credentials.
+func poolKey(u *url.URL) string {
+	return u.User.String() + "@" + u.Host
+}
+
+// get returns the idle connection or nil if there is none.
+func (p *pool) get(key string) io.Closer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.idle[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	c := conns[len(conns)-1]
+	p.idle[key] = conns[:len(conns)-1]
+	return c
+}
+
+// put returns the connection into the pool. It's closed if there are
+// maxIdle connections already, default limit is used if it's zero.
+func (p *pool) put(key string, c io.Closer, maxIdle int) {
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdle
+	}
+	p.mu.Lock()
+	if len(p.idle[key]) < maxIdle {
+		if p.idle == nil {
+			p.idle = make(map[string][]io.Closer)
+		}
+		p.idle[key] = append(p.idle[key], c)
+		c = nil
+	}
+	p.mu.Unlock()
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	// Options are common settings of remote backends. Backends use the
+	// options they support and ignore others. Password is used if the
+	// URL doesn't have one and its host is one of PasswordHosts, so it's
+	// not sent to servers of arbitrary URLs. Timeout limits connection to
+	// servers. MaxIdle is the number of idle connections kept for every
+	// server and user. Signers and HostKeyCallback are used by SSH-based
+	// backends.
+	Options struct {
+		Password        string
+		PasswordHosts   []string
+		Timeout         time.Duration
+		MaxIdle         int
+		Signers         []ssh.Signer
+		HostKeyCallback ssh.HostKeyCallback
+	}
+
+	// Factory creates the backend with options.
+	Factory func(Options) (Backend, error)
+
+	// Registry holds backend factories by their schemes.
+	Registry struct {
+		mu        sync.Mutex
+		factories map[string]Factory
+	}
+)
+
+// Default registry is used by package functions. S3, SFTP and FTP
+// backends are registered in it.
+var Default = NewRegistry()
+
+// NewRegistry returns empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		factories: make(map[string]Factory),
+	}
+}
+
+func init() {
+	Register("s3", func(Options) (Backend, error) {
+		return NewS3FromEnv(), nil
+	})
+	Register("sftp", func(o Options) (Backend, error) {
+		return &SFTP{
+			Password:        o.Password,
+			PasswordHosts:   o.PasswordHosts,
+			Signers:         o.Signers,
+			HostKeyCallback: o.HostKeyCallback,
+			Timeout:         o.Timeout,
+			MaxIdle:         o.MaxIdle,
+		}, nil
+	})
+	Register("ftp", func(o Options) (Backend, error) {
+		return &FTP{
+			Password:      o.Password,
+			PasswordHosts: o.PasswordHosts,
+			Timeout:       o.Timeout,
+			MaxIdle:       o.MaxIdle,
+		}, nil
+	})
+}
+
+// hostPassword returns the password if the host of the URL is one of
+// hosts and empty string otherwise.
+func hostPassword(u *url.URL, password string, hosts []string) string {
+	for _, host := range hosts {
+		if strings.EqualFold(host, u.Hostname()) {
+			return password
+		}
+	}
+	return ""
+}
+
+// Register makes the backend available for the URL scheme in the default
+// registry. It's meant to be called from init functions, so backends can
+// be compiled in without changes of phono.
+func Register(scheme string, f Factory) {
+	Default.Register(scheme, f)
+}
+
+// Schemes returns sorted schemes of the default registry.
+func Schemes() []string {
+	return Default.Schemes()
+}
+
+// New returns the storage with backends of the default registry.
+func New(o Options) (Storage, error) {
+	return Default.New(o)
+}
+
+// Register makes the backend available for the URL scheme. Register
+// panics if the scheme is already registered, it's invalid or the
+// factory is nil.
+func (r *Registry) Register(scheme string, f Factory) {
+	if f == nil {
+		panic("storage: factory of " + scheme + " is nil")
+	}
+	if scheme == "" || Scheme(scheme+"://") != strings.ToLower(scheme) || scheme == "file" {
+		panic("storage: invalid scheme " + scheme)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scheme = strings.ToLower(scheme)
+	if _, ok := r.factories[scheme]; ok {
+		panic("storage: scheme " + scheme + " is already registered")
+	}
+	r.factories[scheme] = f
+}
+
+// Schemes returns sorted schemes of registered backends.
+func (r *Registry) Schemes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	schemes := make([]string, 0, len(r.factories))
+	for scheme := range r.factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// New returns the storage with backends of all registered schemes.
+func (r *Registry) New(o Options) (Storage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := make(Storage, len(r.factories))
+	for scheme, f := range r.factories {
+		b, err := f(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s backend: %w", scheme, err)
+		}
+		s[scheme] = b
+	}
+	return s, nil
+}
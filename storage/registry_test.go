@@ -0,0 +1,62 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/storage"
+)
+
+// optionsBackend returns the timeout of options as the content of files.
+type optionsBackend struct {
+	storage.Options
+}
+
+func (b optionsBackend) Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	content := b.Timeout.String()
+	return ioutil.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
+func (b optionsBackend) Put(ctx context.Context, u *url.URL, r io.Reader) error {
+	return errors.New("read-only")
+}
+
+func TestRegister(t *testing.T) {
+	assert.Equal(t, []string{"ftp", "s3", "sftp"}, storage.Schemes())
+
+	r := storage.NewRegistry()
+	r.Register("Options", func(o storage.Options) (storage.Backend, error) {
+		return optionsBackend{o}, nil
+	})
+	r.Register("sftp", func(o storage.Options) (storage.Backend, error) {
+		return &storage.SFTP{Timeout: o.Timeout}, nil
+	})
+	assert.Equal(t, []string{"options", "sftp"}, r.Schemes())
+	for _, scheme := range []string{"options", "sftp", "", "file", "a b"} {
+		assert.Panics(t, func() {
+			r.Register(scheme, func(storage.Options) (storage.Backend, error) { return nil, nil })
+		}, scheme)
+	}
+	assert.Panics(t, func() { r.Register("nil", nil) })
+
+	s, err := r.New(storage.Options{Timeout: time.Second})
+	assert.NoError(t, err)
+	assert.True(t, s.Remote("options://host/file"))
+	assert.False(t, s.Remote("s3://bucket/file"))
+	assert.IsType(t, &storage.SFTP{}, s["sftp"])
+	rc, size, err := s.Get(context.Background(), "OPTIONS://host/file")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(rc)
+	assert.NoError(t, err)
+	assert.Equal(t, "1s", string(b))
+	assert.Equal(t, int64(2), size)
+	assert.Error(t, s.Put(context.Background(), "options://host/file", strings.NewReader("")))
+}
@@ -0,0 +1,358 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pipelined.dev/phono/bufpool"
+)
+
+const (
+	// DefaultPartSize is the size of parts of multipart uploads.
+	DefaultPartSize = 16 << 20
+	// minPartSize is the min size of all parts except the last one.
+	minPartSize = 5 << 20
+	// unsignedPayload is the hash of request body that isn't signed.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+type (
+	// S3 is the backend of s3://bucket/key URLs of S3-compatible object
+	// storage. Endpoint is the URL of the service, buckets are addressed
+	// in the path if it's set, AWS virtual-hosted endpoint of the region
+	// is used otherwise. Files larger than PartSize are uploaded in parts,
+	// DefaultPartSize is used if it's zero. Credentials are requested for
+	// every request, so they can be rotated.
+	S3 struct {
+		Client      *http.Client
+		Endpoint    string
+		Region      string
+		PartSize    int64
+		Credentials func(context.Context) (Credentials, error)
+		now         func() time.Time
+	}
+
+	// s3Error is the error response of S3.
+	s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+)
+
+// NewS3FromEnv returns S3 backend configured with AWS_REGION or
+// AWS_DEFAULT_REGION and AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+// variables. Credentials are read from environment variables, container
+// credentials endpoint or instance metadata, see EnvCredentials.
+func NewS3FromEnv() *S3 {
+	region := firstEnv("AWS_REGION", "AWS_DEFAULT_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		Endpoint:    firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		Region:      region,
+		Credentials: EnvCredentials(nil),
+	}
+}
+
+// Get streams the object.
+func (s *S3) Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	resp, err := s.do(ctx, http.MethodGet, u, nil, nil, -1)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Put uploads the object. Content larger than the part size is uploaded
+// in parts, so its size doesn't have to be known and memory usage is
+// limited by the part size. Failed multipart upload is aborted.
+func (s *S3) Put(ctx context.Context, u *url.URL, r io.Reader) error {
+	partSize := s.PartSize
+	if partSize == 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	part := bufpool.Bytes(int(partSize))
+	defer bufpool.PutBytes(part)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(ctx, http.MethodPut, u, nil, part[:n], int64(n))
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	uploadID, err := s.createMultipart(ctx, u)
+	if err != nil {
+		return err
+	}
+	if err := s.uploadParts(ctx, u, uploadID, part, r); err != nil {
+		// error of abort is less relevant
+		if resp, err := s.do(context.Background(), http.MethodDelete, u, url.Values{"uploadId": {uploadID}}, nil, -1); err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// createMultipart starts multipart upload and returns its id.
+func (s *S3) createMultipart(ctx context.Context, u *url.URL) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, u, url.Values{"uploads": {""}}, nil, -1)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("s3: invalid multipart upload response: %v", err)
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("s3: multipart upload id is missing")
+	}
+	return result.UploadID, nil
+}
+
+// uploadParts uploads the first part from the buffer and the rest from
+// the reader and completes the upload.
+func (s *S3) uploadParts(ctx context.Context, u *url.URL, uploadID string, part []byte, r io.Reader) error {
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	n := len(part)
+	for number := 1; n > 0; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+		resp, err := s.do(ctx, http.MethodPut, u, query, part[:n], int64(n))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		var rerr error
+		n, rerr = io.ReadFull(r, part)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, u, url.Values{"uploadId": {uploadID}}, body, int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// completion can fail after 200 status is sent
+	result, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var e s3Error
+	if xml.Unmarshal(result, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: failed to complete upload: %s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+// do sends signed request for the object of URL. Body of error responses
+// is parsed into the error.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, query url.Values, body []byte, size int64) (*http.Response, error) {
+	target, err := s.objectURL(u)
+	if err != nil {
+		return nil, err
+	}
+	target.RawQuery = strings.Replace(query.Encode(), "=&", "&", -1)
+	target.RawQuery = strings.TrimSuffix(target.RawQuery, "=")
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 {
+		req.ContentLength = size
+	}
+	if s.Credentials != nil {
+		c, err := s.Credentials(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("s3: failed to get credentials: %v", err)
+		}
+		now := time.Now
+		if s.now != nil {
+			now = s.now
+		}
+		signV4(req, c, s.Region, "s3", now())
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %v", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var e s3Error
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e) != nil || e.Code == "" {
+		return nil, fmt.Errorf("s3: %s %s: %s", method, u, resp.Status)
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s: %s", method, u, e.Code, e.Message)
+}
+
+// objectURL returns HTTP URL of the object.
+func (s *S3) objectURL(u *url.URL) (*url.URL, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid s3 location %q: expected s3://bucket/key", u)
+	}
+	var result *url.URL
+	if s.Endpoint == "" {
+		result = &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s.Region),
+			Path:   "/" + key,
+		}
+	} else {
+		endpoint, err := url.Parse(s.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+		}
+		endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + bucket + "/" + key
+		result = endpoint
+	}
+	// the path is sent as it's signed
+	result.RawPath = awsEscape(result.Path, false)
+	return result, nil
+}
+
+// signV4 signs the request with AWS Signature Version 4. Payload isn't
+// signed, so bodies can be streamed.
+func signV4(req *http.Request, c Credentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "host" || strings.HasPrefix(name, "x-amz-") || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsEscape(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns query sorted by keys and values with AWS
+// escaping.
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsEscape escapes all bytes except unreserved characters. Slashes are
+// kept unless escapeSlash is set.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// firstEnv returns the first non-empty environment variable.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/storage"
+)
+
+// fakeS3 is the path-style S3 server that keeps objects in memory.
+type fakeS3 struct {
+	sync.Mutex
+	objects  map[string][]byte
+	parts    map[string][][]byte
+	requests []string
+	// completeErr is returned in the body of 200 completion response.
+	completeErr bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/") {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+		return
+	}
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == http.MethodGet:
+		content, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Write(content)
+	case r.Method == http.MethodPost && query.Get("uploadId") == "":
+		f.parts[r.URL.Path] = nil
+		fmt.Fprint(w, "<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>")
+	case r.Method == http.MethodPut && query.Get("uploadId") != "":
+		f.parts[r.URL.Path] = append(f.parts[r.URL.Path], body)
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, query.Get("partNumber")))
+	case r.Method == http.MethodPost:
+		if f.completeErr {
+			fmt.Fprint(w, "<Error><Code>InternalError</Code><Message>failed</Message></Error>")
+			return
+		}
+		f.objects[r.URL.Path] = bytes.Join(f.parts[r.URL.Path], nil)
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case r.Method == http.MethodDelete:
+		delete(f.parts, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3(t *testing.T) {
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		parts:   make(map[string][][]byte),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s3 := &storage.S3{
+		Endpoint: server.URL,
+		Region:   "us-east-1",
+		PartSize: 5 << 20,
+		Credentials: func(context.Context) (storage.Credentials, error) {
+			return storage.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+		},
+	}
+	s := storage.Storage{"s3": s3}
+	ctx := context.Background()
+
+	// single request
+	assert.NoError(t, s.Put(ctx, "s3://bucket/dir/a%20b.txt", strings.NewReader("content")))
+	assert.Equal(t, []byte("content"), fake.objects["/bucket/dir/a b.txt"])
+	r, _, err := s.Get(ctx, "s3://bucket/dir/a%20b.txt")
+	assert.NoError(t, err)
+	content, _ := ioutil.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "content", string(content))
+
+	// multipart upload
+	large := bytes.Repeat([]byte("0123456789"), 1<<20+1)
+	fake.requests = nil
+	assert.NoError(t, s.Put(ctx, "s3://bucket/large", bytes.NewReader(large)))
+	assert.Equal(t, large, fake.objects["/bucket/large"])
+	assert.Equal(t, []string{
+		"POST /bucket/large?uploads",
+		"PUT /bucket/large?partNumber=1&uploadId=upload",
+		"PUT /bucket/large?partNumber=2&uploadId=upload",
+		"PUT /bucket/large?partNumber=3&uploadId=upload",
+		"POST /bucket/large?uploadId=upload",
+	}, fake.requests)
+
+	// failed completion is aborted
+	fake.completeErr = true
+	fake.requests = nil
+	err = s.Put(ctx, "s3://bucket/failed", bytes.NewReader(large))
+	assert.EqualError(t, err, "s3: failed to complete upload: InternalError: failed")
+	assert.Equal(t, "DELETE /bucket/failed?uploadId=upload", fake.requests[len(fake.requests)-1])
+	_, ok := fake.objects["/bucket/failed"]
+	assert.False(t, ok)
+
+	// errors
+	_, _, err = s.Get(ctx, "s3://bucket/missing")
+	assert.EqualError(t, err, "s3: GET s3://bucket/missing: NoSuchKey: The specified key does not exist.")
+	_, _, err = s.Get(ctx, "s3://bucket")
+	assert.Error(t, err)
+	s3.Credentials = func(context.Context) (storage.Credentials, error) {
+		return storage.Credentials{AccessKeyID: "other", SecretAccessKey: "secret"}, nil
+	}
+	_, _, err = s.Get(ctx, "s3://bucket/dir/a%20b.txt")
+	assert.EqualError(t, err, "s3: GET s3://bucket/dir/a%20b.txt: AccessDenied: Access Denied")
+}
+
+func TestEnvCredentials(t *testing.T) {
+	var tokens int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tokens++
+		fmt.Fprint(w, `{"AccessKeyId":"role","SecretAccessKey":"secret","Token":"session","Expiration":"2100-01-01T00:00:00Z"}`)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	client := &http.Client{Transport: rewriteTransport{host: u.Host}}
+
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":                      "",
+		"AWS_SECRET_ACCESS_KEY":                  "",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v2/credentials",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN":      "token",
+	} {
+		t.Setenv(k, v)
+	}
+	credentials := storage.EnvCredentials(client)
+	for i := 0; i < 2; i++ {
+		c, err := credentials(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "role", c.AccessKeyID)
+		assert.Equal(t, "session", c.SessionToken)
+	}
+	// cached until expiration
+	assert.Equal(t, 1, tokens)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "key")
+	c, err := credentials(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Credentials{AccessKeyID: "id", SecretAccessKey: "key"}, c)
+}
+
+// rewriteTransport sends all requests to the host.
+type rewriteTransport struct {
+	host string
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Host = t.host
+	return http.DefaultTransport.RoundTrip(r)
+}
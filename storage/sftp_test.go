@@ -0,0 +1,216 @@
+package storage_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+
+	"pipelined.dev/phono/storage"
+)
+
+// fakeSFTP is the SSH server with SFTP subsystem that keeps files in
+// memory.
+type fakeSFTP struct {
+	net.Listener
+	sync.Mutex
+	files    map[string][]byte
+	sessions int
+}
+
+func newFakeSFTP(t *testing.T) (*fakeSFTP, ssh.PublicKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() != "user" || string(password) != "secret" {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeSFTP{Listener: l, files: make(map[string][]byte)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn, config)
+		}
+	}()
+	return f, signer.PublicKey()
+}
+
+func (f *fakeSFTP) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for ch := range chans {
+		channel, requests, err := ch.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "subsystem", nil)
+			}
+		}()
+		f.Lock()
+		f.sessions++
+		f.Unlock()
+		go f.sftp(channel)
+	}
+}
+
+// sftp handles requests of the session.
+func (f *fakeSFTP) sftp(ch ssh.Channel) {
+	defer ch.Close()
+	send := func(typ byte, fields ...interface{}) {
+		var payload []byte
+		for _, field := range fields {
+			switch v := field.(type) {
+			case uint32:
+				payload = append(payload, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(payload[len(payload)-4:], v)
+			case uint64:
+				payload = append(payload, 0, 0, 0, 0, 0, 0, 0, 0)
+				binary.BigEndian.PutUint64(payload[len(payload)-8:], v)
+			case string:
+				payload = append(payload, 0, 0, 0, 0)
+				binary.BigEndian.PutUint32(payload[len(payload)-4:], uint32(len(v)))
+				payload = append(payload, v...)
+			}
+		}
+		header := make([]byte, 5)
+		binary.BigEndian.PutUint32(header, uint32(len(payload)+1))
+		header[4] = typ
+		ch.Write(append(header, payload...))
+	}
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(ch, header); err != nil {
+			return
+		}
+		p := make([]byte, binary.BigEndian.Uint32(header)-1)
+		io.ReadFull(ch, p)
+		u32 := func() uint32 {
+			v := binary.BigEndian.Uint32(p)
+			p = p[4:]
+			return v
+		}
+		str := func() string {
+			n := u32()
+			s := string(p[:n])
+			p = p[n:]
+			return s
+		}
+		if header[4] == 1 {
+			send(2, uint32(3))
+			continue
+		}
+		id := u32()
+		f.Lock()
+		switch header[4] {
+		case 3: // open
+			path, flags := str(), u32()
+			if _, ok := f.files[path]; !ok && flags&0x08 == 0 {
+				send(101, id, uint32(2), "no such file", "")
+				break
+			}
+			if flags&0x10 != 0 {
+				f.files[path] = nil
+			}
+			send(102, id, path)
+		case 5: // read
+			content := f.files[str()]
+			offset := binary.BigEndian.Uint64(p)
+			p = p[8:]
+			length := u32()
+			if offset >= uint64(len(content)) {
+				send(101, id, uint32(1), "eof", "")
+				break
+			}
+			end := offset + uint64(length)
+			if end > uint64(len(content)) {
+				end = uint64(len(content))
+			}
+			send(103, id, string(content[offset:end]))
+		case 6: // write
+			path := str()
+			p = p[8:]
+			f.files[path] = append(f.files[path], str()...)
+			send(101, id, uint32(0), "", "")
+		case 8: // fstat
+			send(105, id, uint32(1), uint64(len(f.files[str()])))
+		case 4: // close
+			send(101, id, uint32(0), "", "")
+		}
+		f.Unlock()
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	server, hostKey := newFakeSFTP(t)
+	defer server.Close()
+	s := storage.Storage{"sftp": &storage.SFTP{
+		Password:        "secret",
+		PasswordHosts:   []string{"127.0.0.1"},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}}
+	ctx := context.Background()
+	root := "sftp://user@" + server.Addr().String()
+
+	large := strings.Repeat("0123456789", 10000)
+	assert.NoError(t, s.Put(ctx, root+"/dir/a.txt", strings.NewReader(large)))
+	assert.Equal(t, large, string(server.files["/dir/a.txt"]))
+	r, size, err := s.Get(ctx, root+"/dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(large)), size)
+	content, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, large, string(content))
+
+	// file in home folder
+	assert.NoError(t, s.Put(ctx, root+"/~/b.txt", strings.NewReader("b")))
+	assert.Equal(t, "b", string(server.files["b.txt"]))
+	// connection is reused after failed request
+	_, _, err = s.Get(ctx, root+"/missing.txt")
+	assert.EqualError(t, err, "sftp: "+root+"/missing.txt: no such file")
+	r, _, err = s.Get(ctx, root+"/~/b.txt")
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, 1, server.sessions)
+
+	_, _, err = s.Get(ctx, "sftp://user:wrong@"+server.Addr().String()+"/dir/a.txt")
+	assert.Error(t, err)
+	_, _, err = storage.Storage{"sftp": &storage.SFTP{
+		Password:        "secret",
+		PasswordHosts:   []string{"127.0.0.1"},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}}.Get(ctx, "sftp://other@"+server.Addr().String()+"/dir/a.txt")
+	assert.Error(t, err)
+	// password is not sent to other hosts
+	_, _, err = storage.Storage{"sftp": &storage.SFTP{
+		Password:        "secret",
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}}.Get(ctx, root+"/dir/a.txt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+// Package storage reads and writes files of local and remote backends
+// addressed by URLs, e.g. s3://bucket/key. Locations without scheme are
+// local paths. Backends of other schemes can be added with Register.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Backend reads and writes files of the URL scheme. Get returns the
+	// content of the file and its size, -1 if it's unknown. Put writes
+	// the content of r into the file.
+	Backend interface {
+		Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error)
+		Put(ctx context.Context, u *url.URL, r io.Reader) error
+	}
+
+	// Storage maps URL schemes to backends. Local paths and file URLs are
+	// handled by Local backend.
+	Storage map[string]Backend
+
+	// Local is the backend of local files.
+	Local struct{}
+)
+
+// Get returns the content of the file at location and its size, -1 if
+// it's unknown.
+func (s Storage) Get(ctx context.Context, location string) (io.ReadCloser, int64, error) {
+	b, u, err := s.backend(location)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b.Get(ctx, u)
+}
+
+// Put writes the content of r into the file at location.
+func (s Storage) Put(ctx context.Context, location string, r io.Reader) error {
+	b, u, err := s.backend(location)
+	if err != nil {
+		return err
+	}
+	return b.Put(ctx, u, r)
+}
+
+// Remote returns true if the location has the scheme of remote backend.
+func (s Storage) Remote(location string) bool {
+	scheme := Scheme(location)
+	if scheme == "" || scheme == "file" {
+		return false
+	}
+	_, ok := s[scheme]
+	return ok
+}
+
+// backend returns the backend of the location and its URL.
+func (s Storage) backend(location string) (Backend, *url.URL, error) {
+	scheme := Scheme(location)
+	if scheme == "" {
+		return Local{}, &url.URL{Path: location}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid location: %w", err)
+	}
+	if scheme == "file" {
+		return Local{}, u, nil
+	}
+	b, ok := s[scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported storage scheme: %s", scheme)
+	}
+	return b, u, nil
+}
+
+// Scheme returns the lower-case URL scheme of the location or empty
+// string if it's a local path. Windows drive letters aren't schemes.
+func Scheme(location string) string {
+	i := strings.Index(location, "://")
+	if i < 2 {
+		return ""
+	}
+	scheme := location[:i]
+	for _, r := range scheme {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '+' || r == '-' || r == '.') {
+			return ""
+		}
+	}
+	return strings.ToLower(scheme)
+}
+
+// Join returns the location of the file with the name inside the
+// location of directory.
+func Join(dir, name string) string {
+	if Scheme(dir) == "" {
+		return filepath.Join(dir, name)
+	}
+	return strings.TrimSuffix(dir, "/") + "/" + url.PathEscape(name)
+}
+
+// Dir returns the location of the directory of the remote file.
+func Dir(location string) string {
+	if Scheme(location) == "" {
+		return filepath.Dir(location)
+	}
+	return location[:strings.LastIndex(location, "/")+1]
+}
+
+// Base returns the name of the remote file without escaping.
+func Base(location string) string {
+	if Scheme(location) == "" {
+		return filepath.Base(location)
+	}
+	if u, err := url.Parse(location); err == nil {
+		return path.Base(u.Path)
+	}
+	return path.Base(location)
+}
+
+// Get opens the local file.
+func (Local) Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	f, err := os.Open(filepath.FromSlash(u.Path))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// Put creates the local file.
+func (Local) Put(ctx context.Context, u *url.URL, r io.Reader) error {
+	f, err := os.Create(filepath.FromSlash(u.Path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
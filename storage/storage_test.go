@@ -0,0 +1,48 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/storage"
+)
+
+func TestLocations(t *testing.T) {
+	assert.Equal(t, "s3", storage.Scheme("S3://bucket/key"))
+	assert.Equal(t, "", storage.Scheme("/tmp/file.wav"))
+	assert.Equal(t, "", storage.Scheme(`C:\file.wav`))
+	assert.Equal(t, "s3://bucket/dir/a%20b.mp3", storage.Join("s3://bucket/dir/", "a b.mp3"))
+	assert.Equal(t, filepath.Join("dir", "a.mp3"), storage.Join("dir", "a.mp3"))
+	assert.Equal(t, "s3://bucket/dir/", storage.Dir("s3://bucket/dir/a.wav"))
+	assert.Equal(t, "a b.wav", storage.Base("s3://bucket/dir/a%20b.wav"))
+
+	s := storage.Storage{"s3": &storage.S3{}}
+	assert.True(t, s.Remote("s3://bucket/key"))
+	assert.False(t, s.Remote("file:///tmp/key"))
+	assert.False(t, s.Remote("gs://bucket/key"))
+	_, _, err := s.Get(context.Background(), "gs://bucket/key")
+	assert.Error(t, err)
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file.txt")
+
+	s := storage.Storage{}
+	assert.NoError(t, s.Put(context.Background(), path, strings.NewReader("content")))
+	r, size, err := s.Get(context.Background(), path)
+	assert.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, int64(7), size)
+	content, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+}
@@ -0,0 +1,369 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/container"
+)
+
+// maxTOCEntries is the max number of chapters listed in ID3 table of
+// contents.
+const maxTOCEntries = 255
+
+// ReadChapters reads chapters of the input. ID3v2 CHAP frames are read
+// from mp3 input, cue points with labels from wav input and CHAPTERxxx
+// comments from flac input. Chapters are sorted by start, missing ends
+// are set to the start of next chapter or the end of audio. Input is
+// seeked to the start before and after reading.
+func ReadChapters(format *fileformat.Format, input io.ReadSeeker) ([]container.Chapter, error) {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var (
+		chapters []container.Chapter
+		duration time.Duration
+		err      error
+	)
+	switch format {
+	case fileformat.MP3():
+		var id3 id3Tag
+		if id3, err = readID3v2(input); err == nil {
+			chapters = id3.chapters()
+		}
+	case fileformat.WAV():
+		chapters, duration, err = readCues(input)
+	case fileformat.FLAC():
+		chapters, duration, err = readVorbisChapters(input)
+	}
+	if _, serr := input.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].Start < chapters[j].Start
+	})
+	fillEnds(chapters, duration)
+	return chapters, nil
+}
+
+// Shift returns chapters with times scaled by the ratio of durations of
+// the output and the input, e.g. 1/tempo if the output is stretched.
+func Shift(chapters []container.Chapter, ratio float64) []container.Chapter {
+	if ratio == 1 {
+		return chapters
+	}
+	result := make([]container.Chapter, len(chapters))
+	for i, c := range chapters {
+		result[i] = container.Chapter{
+			Start: time.Duration(float64(c.Start) * ratio),
+			End:   time.Duration(float64(c.End) * ratio),
+			Title: c.Title,
+		}
+	}
+	return result
+}
+
+// fillEnds sets missing ends of chapters to the start of next chapter.
+// The last chapter ends with audio.
+func fillEnds(chapters []container.Chapter, duration time.Duration) {
+	for i := range chapters {
+		c := &chapters[i]
+		if c.End > c.Start {
+			continue
+		}
+		switch {
+		case i+1 < len(chapters):
+			c.End = chapters[i+1].Start
+		case duration > c.Start:
+			c.End = duration
+		}
+	}
+}
+
+// chapters parses CHAP frames of the tag. Only TIT2 sub-frames are read.
+func (t id3Tag) chapters() []container.Chapter {
+	var chapters []container.Chapter
+	for _, f := range t.other {
+		if f.id != "CHAP" {
+			continue
+		}
+		end := bytes.IndexByte(f.data, 0)
+		if end < 0 || len(f.data) < end+17 {
+			continue
+		}
+		data := f.data[end+1:]
+		c := container.Chapter{
+			Start: time.Duration(binary.BigEndian.Uint32(data)) * time.Millisecond,
+			End:   time.Duration(binary.BigEndian.Uint32(data[4:])) * time.Millisecond,
+		}
+		for sub := data[16:]; len(sub) >= 10; {
+			size := int(binary.BigEndian.Uint32(sub[4:]))
+			if t.version == 4 {
+				size = syncsafe(sub[4:])
+			}
+			if size > len(sub)-10 {
+				break
+			}
+			if string(sub[:4]) == "TIT2" && size > 0 {
+				c.Title = strings.TrimSpace(id3Text(sub[10], sub[11:10+size]))
+			}
+			sub = sub[10+size:]
+		}
+		chapters = append(chapters, c)
+	}
+	return chapters
+}
+
+// chapterFrames returns CHAP frames of chapters and CTOC frame that lists
+// them. CTOC frame is omitted if there are more than 255 chapters.
+func chapterFrames(chapters []container.Chapter) []id3Frame {
+	if len(chapters) == 0 {
+		return nil
+	}
+	frames := make([]id3Frame, 0, len(chapters)+1)
+	toc := []byte("toc\x00")
+	// top-level and ordered
+	toc = append(toc, 0x03, byte(len(chapters)))
+	for i, c := range chapters {
+		id := "chp" + strconv.Itoa(i)
+		toc = append(append(toc, id...), 0)
+		var data bytes.Buffer
+		data.WriteString(id)
+		data.WriteByte(0)
+		binary.Write(&data, binary.BigEndian, []uint32{
+			uint32(c.Start / time.Millisecond),
+			uint32(c.End / time.Millisecond),
+			// byte offsets aren't used
+			0xffffffff,
+			0xffffffff,
+		})
+		if c.Title != "" {
+			title := append([]byte{3}, c.Title...)
+			data.WriteString("TIT2")
+			data.Write(putSyncsafe(len(title)))
+			data.Write([]byte{0, 0})
+			data.Write(title)
+		}
+		frames = append(frames, id3Frame{id: "CHAP", data: data.Bytes()})
+	}
+	if len(chapters) <= maxTOCEntries {
+		frames = append([]id3Frame{{id: "CTOC", data: toc}}, frames...)
+	}
+	return frames
+}
+
+// readCues reads cue points of RIFF file and their labels from LIST adtl
+// chunk. Duration of audio is returned too.
+func readCues(r io.ReadSeeker) ([]container.Chapter, time.Duration, error) {
+	var (
+		sampleRate int64
+		blockAlign int64
+		dataSize   int64
+		points     = map[uint32]uint32{}
+		order      []uint32
+		labels     = map[uint32]string{}
+	)
+	err := riffChunks(r, func(id string, size int64) error {
+		switch id {
+		case "fmt ", "cue ", "LIST":
+			chunk, err := readList(r, size)
+			if err != nil {
+				return err
+			}
+			switch {
+			case id == "fmt " && len(chunk) >= 16:
+				sampleRate = int64(binary.LittleEndian.Uint32(chunk[4:]))
+				blockAlign = int64(binary.LittleEndian.Uint16(chunk[12:]))
+			case id == "cue " && len(chunk) >= 4:
+				for p := chunk[4:]; len(p) >= 24; p = p[24:] {
+					point := binary.LittleEndian.Uint32(p)
+					points[point] = binary.LittleEndian.Uint32(p[20:])
+					order = append(order, point)
+				}
+			case id == "LIST" && bytes.HasPrefix(chunk, []byte("adtl")):
+				for l := chunk[4:]; len(l) >= 12; {
+					n := int(binary.LittleEndian.Uint32(l[4:]))
+					if n < 4 || n > len(l)-8 {
+						break
+					}
+					if string(l[:4]) == "labl" {
+						labels[binary.LittleEndian.Uint32(l[8:])] = strings.TrimRight(string(l[12:8+n]), "\x00")
+					}
+					next := 8 + n + n&1
+					if next > len(l) {
+						break
+					}
+					l = l[next:]
+				}
+			}
+			return nil
+		case "data":
+			dataSize = size
+		}
+		_, err := r.Seek(size+size&1, io.SeekCurrent)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if sampleRate == 0 {
+		return nil, 0, nil
+	}
+	chapters := make([]container.Chapter, 0, len(order))
+	for _, id := range order {
+		title := labels[id]
+		if !utf8.ValidString(title) {
+			title = latin1(title)
+		}
+		chapters = append(chapters, container.Chapter{
+			Start: samplesDuration(int64(points[id]), sampleRate),
+			Title: title,
+		})
+	}
+	var duration time.Duration
+	if blockAlign > 0 {
+		duration = samplesDuration(dataSize/blockAlign, sampleRate)
+	}
+	return chapters, duration, nil
+}
+
+// appendCues appends cue chunk with points at starts of chapters and LIST
+// adtl chunk with their titles to the end of RIFF file. The size of RIFF
+// chunk is updated.
+func appendCues(w io.WriteSeeker, chapters []container.Chapter, sampleRate int) error {
+	if len(chapters) == 0 {
+		return nil
+	}
+	var cue, adtl bytes.Buffer
+	binary.Write(&cue, binary.LittleEndian, uint32(len(chapters)))
+	adtl.WriteString("adtl")
+	for i, c := range chapters {
+		id := uint32(i + 1)
+		offset := uint32(c.Start.Seconds() * float64(sampleRate))
+		cue.Write(le32(id, offset))
+		cue.WriteString("data")
+		cue.Write(le32(0, 0, offset))
+		if c.Title != "" {
+			writeEntry(&adtl, "labl", append(append(le32(id), c.Title...), 0))
+		}
+	}
+	end, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if end&1 != 0 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if err := writeChunk(w, "cue ", cue.Bytes()); err != nil {
+		return err
+	}
+	if adtl.Len() > 4 {
+		if err := writeChunk(w, "LIST", adtl.Bytes()); err != nil {
+			return err
+		}
+	}
+	return setRIFFSize(w)
+}
+
+// readVorbisChapters reads CHAPTERxxx and CHAPTERxxxNAME comments of flac
+// file. Duration of audio is returned too.
+func readVorbisChapters(r io.ReadSeeker) ([]container.Chapter, time.Duration, error) {
+	var (
+		c        vorbisComment
+		duration time.Duration
+	)
+	err := flacBlocks(r, func(blockType byte, size int64) error {
+		block := make([]byte, size)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return err
+		}
+		switch {
+		case blockType == 0 && size >= 18:
+			sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+			frames := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:]))
+			if sampleRate > 0 {
+				duration = samplesDuration(frames, sampleRate)
+			}
+		case blockType == vorbisCommentBlock:
+			c = parseVorbis(Tags{}, block)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	starts, titles := map[string]time.Duration{}, map[string]string{}
+	var order []string
+	for _, comment := range c.other {
+		eq := strings.IndexByte(comment, '=')
+		name, value := strings.ToUpper(comment[:eq]), comment[eq+1:]
+		if !strings.HasPrefix(name, "CHAPTER") {
+			continue
+		}
+		if n := strings.TrimSuffix(name[len("CHAPTER"):], "NAME"); n != name[len("CHAPTER"):] {
+			titles[n] = strings.TrimSpace(value)
+			continue
+		}
+		start, err := parseChapterTime(value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid %s: %v", name, err)
+		}
+		n := name[len("CHAPTER"):]
+		starts[n] = start
+		order = append(order, n)
+	}
+	chapters := make([]container.Chapter, 0, len(order))
+	for _, n := range order {
+		chapters = append(chapters, container.Chapter{Start: starts[n], Title: titles[n]})
+	}
+	return chapters, duration, nil
+}
+
+// parseChapterTime parses HH:MM:SS.mmm time of Vorbis chapter.
+func parseChapterTime(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("expected HH:MM:SS.mmm: %q", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	sec, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec*float64(time.Second)), nil
+}
+
+// samplesDuration returns the duration of samples.
+func samplesDuration(samples, sampleRate int64) time.Duration {
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
+
+// le32 encodes values as little-endian 32-bit integers.
+func le32(values ...uint32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[4*i:], v)
+	}
+	return b
+}
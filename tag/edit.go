@@ -0,0 +1,145 @@
+package tag
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Edit changes tags. Fields are parsed from the file name with
+	// Template first and then values of Set are assigned. Fields with
+	// empty values in Set are removed.
+	Edit struct {
+		Set      Tags
+		Template *Template
+	}
+
+	// Template parses fields from the file name, e.g.
+	// "{track} {artist} - {title}". Text between placeholders must
+	// match literally.
+	Template struct {
+		pattern *regexp.Regexp
+		fields  []Field
+	}
+)
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ParseField returns the field with provided name. Year is the alias of
+// date.
+func ParseField(name string) (Field, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "year" {
+		return Date, nil
+	}
+	for _, f := range fields {
+		if string(f) == name {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown tag field: %q", name)
+}
+
+// ParseEdit parses assignments in field=value format and the file name
+// template. Empty template is ignored.
+func ParseEdit(assignments []string, template string) (Edit, error) {
+	var e Edit
+	for _, a := range assignments {
+		eq := strings.IndexByte(a, '=')
+		if eq < 0 {
+			return Edit{}, fmt.Errorf("invalid tag %q: expected field=value", a)
+		}
+		f, err := ParseField(a[:eq])
+		if err != nil {
+			return Edit{}, err
+		}
+		if e.Set == nil {
+			e.Set = Tags{}
+		}
+		e.Set[f] = strings.TrimSpace(a[eq+1:])
+	}
+	if template == "" {
+		return e, nil
+	}
+	var err error
+	e.Template, err = ParseTemplate(template)
+	return e, err
+}
+
+// Empty returns true if the edit changes nothing.
+func (e Edit) Empty() bool {
+	return len(e.Set) == 0 && e.Template == nil
+}
+
+// Apply returns tags changed by the edit. The name of the file at path is
+// matched by the template, unmatched names don't change tags.
+func (e Edit) Apply(t Tags, path string) Tags {
+	result := make(Tags, len(t)+len(e.Set))
+	for f, v := range t {
+		result[f] = v
+	}
+	if e.Template != nil {
+		for f, v := range e.Template.Match(path) {
+			result[f] = v
+		}
+	}
+	for f, v := range e.Set {
+		if v == "" {
+			delete(result, f)
+			continue
+		}
+		result[f] = v
+	}
+	return result
+}
+
+// ParseTemplate parses the file name template. Placeholders are names of
+// fields in curly braces, every field can be used once.
+func ParseTemplate(template string) (*Template, error) {
+	var (
+		t    Template
+		expr strings.Builder
+		last int
+	)
+	expr.WriteString("^")
+	for _, m := range placeholder.FindAllStringSubmatchIndex(template, -1) {
+		f, err := ParseField(template[m[2]:m[3]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid template %q: %v", template, err)
+		}
+		for _, used := range t.fields {
+			if used == f {
+				return nil, fmt.Errorf("invalid template %q: %s is used twice", template, f)
+			}
+		}
+		t.fields = append(t.fields, f)
+		expr.WriteString(regexp.QuoteMeta(template[last:m[0]]))
+		expr.WriteString("(.+?)")
+		last = m[1]
+	}
+	if len(t.fields) == 0 {
+		return nil, fmt.Errorf("invalid template %q: no fields", template)
+	}
+	expr.WriteString(regexp.QuoteMeta(template[last:]))
+	expr.WriteString("$")
+	t.pattern = regexp.MustCompile(expr.String())
+	return &t, nil
+}
+
+// Match parses fields from the name of the file at path without its
+// extension. Nil is returned if the name doesn't match the template.
+func (t *Template) Match(path string) Tags {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	m := t.pattern.FindStringSubmatch(name)
+	if m == nil {
+		return nil
+	}
+	result := make(Tags, len(t.fields))
+	for i, f := range t.fields {
+		result.set(f, m[i+1])
+	}
+	return result
+}
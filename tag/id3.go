@@ -0,0 +1,351 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Frames maps ID3v2.2 and ID3v2.3/4 frame IDs to fields.
+var id3Frames = map[string]Field{
+	"TT2": Title, "TIT2": Title,
+	"TP1": Artist, "TPE1": Artist,
+	"TAL": Album, "TALB": Album,
+	"TP2": AlbumArtist, "TPE2": AlbumArtist,
+	"TCM": Composer, "TCOM": Composer,
+	"TCO": Genre, "TCON": Genre,
+	"TRK": Track, "TRCK": Track,
+	"TPA": Disc, "TPOS": Disc,
+	"TYE": Date, "TYER": Date, "TDRC": Date,
+	"COM": Comment, "COMM": Comment,
+	"TBP": BPM, "TBPM": BPM,
+	"TKE": Key, "TKEY": Key,
+	"TCR": Copyright, "TCOP": Copyright,
+}
+
+// txxxFields are fields stored in TXXX frames with descriptions equal to
+// upper-case names of fields.
+var txxxFields = []Field{TrackGain, TrackPeak, AlbumGain, AlbumPeak}
+
+// id3Writes maps fields to ID3v2.4 frame IDs.
+var id3Writes = map[Field]string{
+	Title:       "TIT2",
+	Artist:      "TPE1",
+	Album:       "TALB",
+	AlbumArtist: "TPE2",
+	Composer:    "TCOM",
+	Genre:       "TCON",
+	Track:       "TRCK",
+	Disc:        "TPOS",
+	Date:        "TDRC",
+	Comment:     "COMM",
+	BPM:         "TBPM",
+	Key:         "TKEY",
+	Copyright:   "TCOP",
+}
+
+type (
+	// id3Tag is the parsed ID3v2 tag. Size is the number of bytes of the
+	// tag including its header and footer. Frames that aren't mapped to
+	// fields are kept, so they can be written back.
+	id3Tag struct {
+		Tags
+		other   []id3Frame
+		size    int64
+		version byte
+	}
+
+	// id3Frame is the raw ID3v2.4 frame without format flags.
+	id3Frame struct {
+		id   string
+		data []byte
+	}
+)
+
+// readID3v2 reads ID3v2.2, ID3v2.3 and ID3v2.4 tags. Unknown frames of
+// ID3v2.2 tags aren't kept, since their IDs differ.
+func readID3v2(r io.Reader) (id3Tag, error) {
+	t := id3Tag{Tags: Tags{}}
+	var h [10]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return t, nil
+		}
+		return id3Tag{}, err
+	}
+	if !bytes.Equal(h[:3], []byte("ID3")) {
+		return t, nil
+	}
+	version, flags := h[3], h[5]
+	if version < 2 || version > 4 {
+		return t, nil
+	}
+	body := make([]byte, syncsafe(h[6:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return id3Tag{}, fmt.Errorf("invalid id3 tag: %v", err)
+	}
+	t.size, t.version = int64(len(h)+len(body)), version
+	if flags&0x10 != 0 && version == 4 {
+		// footer
+		t.size += 10
+	}
+	// whole tag unsynchronisation is applied before version 4
+	if flags&0x80 != 0 && version < 4 {
+		body = unsync(body)
+	}
+	if flags&0x40 != 0 && version > 2 {
+		if len(body) < 4 {
+			return t, nil
+		}
+		size := int(binary.BigEndian.Uint32(body)) + 4
+		if version == 4 {
+			size = syncsafe(body)
+		}
+		if size > len(body) {
+			return t, nil
+		}
+		body = body[size:]
+	}
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(body) >= headerSize && body[0] != 0 {
+		id := string(body[:idSize])
+		var size int
+		switch version {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:]))
+		case 4:
+			size = syncsafe(body[4:])
+		}
+		if size > len(body)-headerSize {
+			break
+		}
+		data := body[headerSize : headerSize+size]
+		var format byte
+		if version > 2 {
+			format = body[9]
+		}
+		body = body[headerSize+size:]
+		if data = id3FrameData(version, format, data); data == nil {
+			continue
+		}
+		if (id == "TXXX" || id == "TXX") && len(data) > 0 {
+			if desc, value := id3UserText(data); txxxField(Field(strings.ToLower(desc))) != "" {
+				t.set(Field(strings.ToLower(desc)), value)
+				continue
+			}
+		}
+		f, ok := id3Frames[id]
+		if !ok {
+			if version > 2 {
+				t.other = append(t.other, id3Frame{id: id, data: data})
+			}
+			continue
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if f == Comment {
+			t.set(f, id3Comment(data))
+		} else {
+			t.set(f, id3Text(data[0], data[1:]))
+		}
+	}
+	return t, nil
+}
+
+// rewriteID3v2 copies the input into the output with ID3v2 tag replaced
+// by ID3v2.4 tag with provided fields. Unknown frames of the input tag
+// are kept.
+func rewriteID3v2(in io.ReadSeeker, out io.Writer, t Tags) error {
+	old, err := readID3v2(in)
+	if err != nil {
+		return err
+	}
+	if len(t) > 0 || len(old.other) > 0 {
+		if err := writeID3v2(out, t, old.other...); err != nil {
+			return err
+		}
+	}
+	if _, err := in.Seek(old.size, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// id3FrameData returns the frame data with applied format flags. Nil is
+// returned for compressed and encrypted frames.
+func id3FrameData(version, format byte, data []byte) []byte {
+	switch version {
+	case 3:
+		if format&0xc0 != 0 {
+			return nil
+		}
+		if format&0x20 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+	case 4:
+		if format&0x0c != 0 {
+			return nil
+		}
+		if format&0x40 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+		if format&0x02 != 0 {
+			data = unsync(data)
+		}
+		if format&0x01 != 0 {
+			if len(data) < 4 {
+				return nil
+			}
+			data = data[4:]
+		}
+	}
+	return data
+}
+
+// id3Comment decodes comment frame, its language and description are
+// omitted.
+func id3Comment(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	enc, text := data[0], data[4:]
+	// skip description terminated with null character of the encoding
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(text); i += 2 {
+			if text[i] == 0 && text[i+1] == 0 {
+				return id3Text(enc, text[i+2:])
+			}
+		}
+		return ""
+	}
+	if i := bytes.IndexByte(text, 0); i >= 0 {
+		return id3Text(enc, text[i+1:])
+	}
+	return ""
+}
+
+// id3UserText decodes description and value of user-defined text frame.
+func id3UserText(data []byte) (string, string) {
+	enc, text := data[0], data[1:]
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(text); i += 2 {
+			if text[i] == 0 && text[i+1] == 0 {
+				return id3Text(enc, text[:i+2]), id3Text(enc, text[i+2:])
+			}
+		}
+		return id3Text(enc, text), ""
+	}
+	if i := bytes.IndexByte(text, 0); i >= 0 {
+		return id3Text(enc, text[:i]), id3Text(enc, text[i+1:])
+	}
+	return id3Text(enc, text), ""
+}
+
+// txxxField returns upper-case description of TXXX frame of the field.
+// Empty string is returned if the field isn't stored in TXXX frame.
+func txxxField(f Field) string {
+	for _, txxx := range txxxFields {
+		if txxx == f {
+			return strings.ToUpper(string(f))
+		}
+	}
+	return ""
+}
+
+// id3Text decodes the text of the encoding. Only the first value of
+// multiple null-separated values is returned.
+func id3Text(enc byte, data []byte) string {
+	switch enc {
+	case 0:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		return latin1(string(data))
+	case 1, 2:
+		var order binary.ByteOrder = binary.BigEndian
+		if len(data) >= 2 {
+			switch {
+			case data[0] == 0xff && data[1] == 0xfe:
+				order, data = binary.LittleEndian, data[2:]
+			case data[0] == 0xfe && data[1] == 0xff:
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			u := order.Uint16(data[i:])
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return string(utf16.Decode(units))
+	default:
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			data = data[:i]
+		}
+		return string(data)
+	}
+}
+
+// writeID3v2 writes ID3v2.4 tag with UTF-8 text frames followed by other
+// frames.
+func writeID3v2(w io.Writer, t Tags, other ...id3Frame) error {
+	var body bytes.Buffer
+	writeFrame := func(id string, data []byte) {
+		body.WriteString(id)
+		body.Write(putSyncsafe(len(data)))
+		body.Write([]byte{0, 0})
+		body.Write(data)
+	}
+	for _, f := range fields {
+		v, ok := t[f]
+		if !ok {
+			continue
+		}
+		switch desc := txxxField(f); {
+		case f == Comment:
+			// UTF-8, unknown language and empty description
+			writeFrame("COMM", append([]byte{3, 'X', 'X', 'X', 0}, v...))
+		case desc != "":
+			writeFrame("TXXX", append(append(append([]byte{3}, desc...), 0), v...))
+		default:
+			writeFrame(id3Writes[f], append([]byte{3}, v...))
+		}
+	}
+	for _, f := range other {
+		writeFrame(f.id, f.data)
+	}
+	h := append([]byte{'I', 'D', '3', 4, 0, 0}, putSyncsafe(body.Len())...)
+	if _, err := w.Write(h); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// syncsafe decodes 28-bit integer stored in 4 bytes.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// putSyncsafe encodes 28-bit integer into 4 bytes.
+func putSyncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// unsync reverts unsynchronisation, zero bytes inserted after 0xff are
+// removed.
+func unsync(b []byte) []byte {
+	return bytes.Replace(b, []byte{0xff, 0}, []byte{0xff}, -1)
+}
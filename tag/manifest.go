@@ -0,0 +1,108 @@
+package tag
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Manifest formats.
+const (
+	ManifestCSV  = "csv"
+	ManifestJSON = "json"
+)
+
+// Entry is the file of the manifest and its tags.
+type Entry struct {
+	Path string `json:"path"`
+	Tags Tags   `json:"tags"`
+}
+
+// Fields returns common fields in the order they're written.
+func Fields() []Field {
+	return append([]Field(nil), fields...)
+}
+
+// ReadManifest reads entries of CSV or JSON manifest. CSV manifest must
+// have the header with path column. Columns named after fields are read
+// and other ones are ignored, so columns of technical info don't prevent
+// the import. JSON manifest is the array of entries. Empty values are
+// kept, so they remove fields when the entry is applied.
+func ReadManifest(r io.Reader, format string) ([]Entry, error) {
+	switch strings.ToLower(format) {
+	case ManifestCSV:
+		return readCSV(r)
+	case ManifestJSON:
+		return readJSON(r)
+	}
+	return nil, fmt.Errorf("unsupported manifest format: %s", format)
+}
+
+// readCSV reads entries of CSV manifest.
+func readCSV(r io.Reader) ([]Entry, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("manifest has no header")
+		}
+		return nil, err
+	}
+	pathColumn := -1
+	columns := make(map[int]Field)
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		if strings.EqualFold(strings.TrimSpace(name), "path") {
+			pathColumn = i
+			continue
+		}
+		if f, err := ParseField(name); err == nil {
+			columns[i] = f
+		}
+	}
+	if pathColumn < 0 {
+		return nil, fmt.Errorf("manifest has no path column")
+	}
+	var entries []Entry
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{Path: record[pathColumn], Tags: make(Tags, len(columns))}
+		for i, f := range columns {
+			e.Tags[f] = strings.TrimSpace(record[i])
+		}
+		entries = append(entries, e)
+	}
+}
+
+// readJSON reads entries of JSON manifest. Names of fields are parsed, so
+// aliases are accepted.
+func readJSON(r io.Reader) ([]Entry, error) {
+	var manifest []struct {
+		Path string            `json:"path"`
+		Tags map[string]string `json:"tags"`
+	}
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(manifest))
+	for _, m := range manifest {
+		e := Entry{Path: m.Path, Tags: make(Tags, len(m.Tags))}
+		for name, v := range m.Tags {
+			f, err := ParseField(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", m.Path, err)
+			}
+			e.Tags[f] = strings.TrimSpace(v)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
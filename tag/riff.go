@@ -0,0 +1,253 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// infoChunks maps INFO chunk IDs to fields.
+var infoChunks = map[string]Field{
+	"INAM": Title,
+	"IART": Artist,
+	"IPRD": Album,
+	"ICMS": Composer,
+	"IGNR": Genre,
+	"ITRK": Track,
+	"IPRT": Track,
+	"ICRD": Date,
+	"ICMT": Comment,
+	"ICOP": Copyright,
+}
+
+// infoWrites maps fields to INFO chunk IDs. Fields without INFO chunk
+// aren't written.
+var infoWrites = map[Field]string{
+	Title:     "INAM",
+	Artist:    "IART",
+	Album:     "IPRD",
+	Composer:  "ICMS",
+	Genre:     "IGNR",
+	Track:     "ITRK",
+	Date:      "ICRD",
+	Comment:   "ICMT",
+	Copyright: "ICOP",
+}
+
+// infoEntry is the raw sub-chunk of INFO chunk.
+type infoEntry struct {
+	id   string
+	data []byte
+}
+
+// readInfo reads LIST INFO chunk of RIFF file.
+func readInfo(r io.ReadSeeker) (Tags, error) {
+	t := Tags{}
+	err := riffChunks(r, func(id string, size int64) error {
+		if id != "LIST" {
+			_, err := r.Seek(size+size&1, io.SeekCurrent)
+			return err
+		}
+		list, err := readList(r, size)
+		if err != nil {
+			return err
+		}
+		parseInfo(t, list)
+		return nil
+	})
+	return t, err
+}
+
+// rewriteInfo copies RIFF file into the output with LIST INFO chunks
+// replaced by the single chunk with provided fields appended to the end.
+// Unknown INFO sub-chunks of the input are kept.
+func rewriteInfo(in io.ReadSeeker, out io.WriteSeeker, t Tags) error {
+	if _, err := out.Write([]byte("RIFF\x00\x00\x00\x00WAVE")); err != nil {
+		return err
+	}
+	var other []infoEntry
+	err := riffChunks(in, func(id string, size int64) error {
+		if id == "LIST" {
+			list, err := readList(in, size)
+			if err != nil {
+				return err
+			}
+			if bytes.HasPrefix(list, []byte("INFO")) {
+				other = append(other, parseInfo(Tags{}, list)...)
+				return nil
+			}
+			return writeChunk(out, id, list)
+		}
+		var h [8]byte
+		copy(h[:], id)
+		binary.LittleEndian.PutUint32(h[4:], uint32(size))
+		if _, err := out.Write(h[:]); err != nil {
+			return err
+		}
+		// truncated chunk and missing pad byte of the last chunk are
+		// copied as is
+		if _, err := io.CopyN(out, in, size+size&1); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := appendInfo(out, t, other...); err != nil {
+		return err
+	}
+	return setRIFFSize(out)
+}
+
+// riffChunks calls fn with every chunk of RIFF file. Fn must read or skip
+// the chunk including its pad byte.
+func riffChunks(r io.Reader, fn func(id string, size int64) error) error {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(riff[:4], []byte("RIFF")) || !bytes.Equal(riff[8:], []byte("WAVE")) {
+		return fmt.Errorf("invalid wav header")
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			// no more chunks
+			return nil
+		}
+		if err := fn(string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))); err != nil {
+			return err
+		}
+	}
+}
+
+// readList reads LIST chunk of the size and its pad byte. The size is
+// checked against the rest of the file before the chunk is allocated.
+func readList(r io.ReadSeeker, size int64) ([]byte, error) {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if size > end-pos {
+		return nil, fmt.Errorf("invalid list chunk: size %d exceeds the file", size)
+	}
+	list := make([]byte, size+size&1)
+	if _, err := io.ReadFull(r, list); err != nil {
+		return nil, fmt.Errorf("invalid list chunk: %v", err)
+	}
+	return list[:size], nil
+}
+
+// parseInfo sets fields of INFO list and returns unknown sub-chunks. Nil
+// is returned if it's not INFO list.
+func parseInfo(t Tags, list []byte) []infoEntry {
+	if !bytes.HasPrefix(list, []byte("INFO")) {
+		return nil
+	}
+	var other []infoEntry
+	for list = list[4:]; len(list) >= 8; {
+		id, n := string(list[:4]), int(binary.LittleEndian.Uint32(list[4:]))
+		if n > len(list)-8 {
+			break
+		}
+		if f, ok := infoChunks[id]; ok {
+			t.set(f, string(list[8:8+n]))
+		} else {
+			other = append(other, infoEntry{id: id, data: list[8 : 8+n]})
+		}
+		next := 8 + n + n&1
+		if next > len(list) {
+			break
+		}
+		list = list[next:]
+	}
+	return other
+}
+
+// appendInfo appends LIST INFO chunk with fields followed by other
+// sub-chunks to the end of RIFF file and updates the size of RIFF chunk.
+func appendInfo(w io.WriteSeeker, t Tags, other ...infoEntry) error {
+	var list bytes.Buffer
+	list.WriteString("INFO")
+	for _, f := range fields {
+		id, ok := infoWrites[f]
+		if !ok {
+			continue
+		}
+		v, ok := t[f]
+		if !ok {
+			continue
+		}
+		// values are null-terminated
+		writeEntry(&list, id, append([]byte(v), 0))
+	}
+	for _, e := range other {
+		writeEntry(&list, e.id, e.data)
+	}
+	if list.Len() == 4 {
+		return nil
+	}
+	end, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	// pad the previous chunk to even size
+	if end&1 != 0 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if err := writeChunk(w, "LIST", list.Bytes()); err != nil {
+		return err
+	}
+	return setRIFFSize(w)
+}
+
+// writeEntry writes sub-chunk of the list padded to even size.
+func writeEntry(list *bytes.Buffer, id string, data []byte) {
+	list.WriteString(id)
+	binary.Write(list, binary.LittleEndian, uint32(len(data)))
+	list.Write(data)
+	if len(data)&1 != 0 {
+		list.WriteByte(0)
+	}
+}
+
+// writeChunk writes the chunk padded to even size.
+func writeChunk(w io.Writer, id string, data []byte) error {
+	h := make([]byte, 8, 8+len(data)+1)
+	copy(h, id)
+	binary.LittleEndian.PutUint32(h[4:], uint32(len(data)))
+	h = append(h, data...)
+	if len(data)&1 != 0 {
+		h = append(h, 0)
+	}
+	_, err := w.Write(h)
+	return err
+}
+
+// setRIFFSize updates the size of RIFF chunk to the size of the file.
+// The file is seeked to the end.
+func setRIFFSize(w io.WriteSeeker) error {
+	end, err := w.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(end-8)); err != nil {
+		return err
+	}
+	_, err = w.Seek(0, io.SeekEnd)
+	return err
+}
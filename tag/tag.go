@@ -0,0 +1,217 @@
+// Package tag reads and writes metadata tags of audio files. ID3v2 tags
+// of mp3 files, RIFF INFO chunks of wav files and Vorbis comments of flac
+// files are read into common fields, so tags can be preserved when the
+// file is converted to another format. ID3v2.4 tags are written into mp3
+// output and INFO chunks are written into wav output. Chapters are kept
+// as ID3v2 CHAP frames and wav cue points.
+package tag
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+
+	"pipelined.dev/phono/container"
+)
+
+// Common fields of tags.
+const (
+	Title       Field = "title"
+	Artist      Field = "artist"
+	Album       Field = "album"
+	AlbumArtist Field = "album_artist"
+	Composer    Field = "composer"
+	Genre       Field = "genre"
+	Track       Field = "track"
+	Disc        Field = "disc"
+	Date        Field = "date"
+	Comment     Field = "comment"
+	BPM         Field = "bpm"
+	Key         Field = "key"
+	Copyright   Field = "copyright"
+	// ReplayGain gains are in "-6.20 dB" format, peaks are amplitudes
+	// where 1 is full scale.
+	TrackGain Field = "replaygain_track_gain"
+	TrackPeak Field = "replaygain_track_peak"
+	AlbumGain Field = "replaygain_album_gain"
+	AlbumPeak Field = "replaygain_album_peak"
+)
+
+// fields are written in this order.
+var fields = []Field{Title, Artist, Album, AlbumArtist, Composer, Genre, Track, Disc, Date, Comment, BPM, Key, Copyright, TrackGain, TrackPeak, AlbumGain, AlbumPeak}
+
+type (
+	// Field is the name of common tag field.
+	Field string
+
+	// Tags are values of common fields.
+	Tags map[Field]string
+)
+
+// Read reads tags of the input. ID3v2 tags are read from mp3 input, INFO
+// chunk from wav input and Vorbis comments from flac input. Empty tags
+// are returned if the input has no tags. Input is seeked to the start
+// before and after reading.
+func Read(format *fileformat.Format, input io.ReadSeeker) (Tags, error) {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var (
+		t   Tags
+		err error
+	)
+	switch format {
+	case fileformat.MP3():
+		var id3 id3Tag
+		id3, err = readID3v2(input)
+		t = id3.Tags
+	case fileformat.WAV():
+		t, err = readInfo(input)
+	case fileformat.FLAC():
+		t, err = readVorbis(input)
+	}
+	if _, serr := input.Seek(0, io.SeekStart); err == nil {
+		err = serr
+	}
+	return t, err
+}
+
+// Write copies the input into the output with its tags replaced by
+// provided ones. Frames and comments of the input that aren't mapped to
+// fields are kept. If tags are empty, only those are written.
+func Write(format *fileformat.Format, input io.ReadSeeker, output io.WriteSeeker, t Tags) error {
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	switch format {
+	case fileformat.MP3():
+		return rewriteID3v2(input, output, t)
+	case fileformat.WAV():
+		return rewriteInfo(input, output, t)
+	case fileformat.FLAC():
+		return rewriteVorbis(input, output, t)
+	}
+	return fmt.Errorf("unsupported format: %s", format.DefaultExtension())
+}
+
+// Sink wraps the sink allocator of the format to write tags and chapters
+// into w. ID3v2 tag is written into mp3 output before the audio. INFO,
+// cue and adtl chunks are appended to wav output when the sink is
+// flushed, w must be io.WriteSeeker for that. Sink is returned as is if
+// tags and chapters are empty.
+func Sink(fn pipe.SinkAllocatorFunc, format *fileformat.Format, w io.Writer, t Tags, chapters ...container.Chapter) pipe.SinkAllocatorFunc {
+	if len(t) == 0 && len(chapters) == 0 {
+		return fn
+	}
+	switch format {
+	case fileformat.MP3():
+		return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+			if err := writeID3v2(w, t, chapterFrames(chapters)...); err != nil {
+				return pipe.Sink{}, err
+			}
+			return fn(mctx, bufferSize, props)
+		}
+	case fileformat.WAV():
+		ws, ok := w.(io.WriteSeeker)
+		if !ok {
+			return fn
+		}
+		return func(mctx mutable.Context, bufferSize int, props pipe.SignalProperties) (pipe.Sink, error) {
+			sink, err := fn(mctx, bufferSize, props)
+			if err != nil {
+				return sink, err
+			}
+			flushFn := sink.FlushFunc
+			sink.FlushFunc = func(ctx context.Context) error {
+				if flushFn != nil {
+					if err := flushFn(ctx); err != nil {
+						return err
+					}
+				}
+				if err := appendInfo(ws, t); err != nil {
+					return err
+				}
+				return appendCues(ws, chapters, int(props.SampleRate))
+			}
+			return sink, nil
+		}
+	}
+	return fn
+}
+
+// Supported returns true if the field can be written into files of the
+// format.
+func Supported(format *fileformat.Format, f Field) bool {
+	switch format {
+	case fileformat.MP3():
+		_, ok := id3Writes[f]
+		return ok || txxxField(f) != ""
+	case fileformat.WAV():
+		_, ok := infoWrites[f]
+		return ok
+	case fileformat.FLAC():
+		_, ok := vorbisWrites[f]
+		return ok
+	}
+	return false
+}
+
+// ReplayGain returns the gain in dB and the peak of the album if album is
+// true, otherwise of the track. False is returned if the gain isn't set
+// or invalid. Missing peak is zero.
+func (t Tags) ReplayGain(album bool) (gain, peak float64, ok bool) {
+	gainField, peakField := TrackGain, TrackPeak
+	if album {
+		gainField, peakField = AlbumGain, AlbumPeak
+	}
+	v := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(t[gainField]), "dB"))
+	gain, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(gain) || math.IsInf(gain, 0) {
+		return 0, 0, false
+	}
+	if peak, err = strconv.ParseFloat(strings.TrimSpace(t[peakField]), 64); err != nil || peak < 0 {
+		peak = 0
+	}
+	return gain, peak, true
+}
+
+// SetReplayGain sets the gain in dB and the peak of the album if album is
+// true, otherwise of the track.
+func (t Tags) SetReplayGain(album bool, gain, peak float64) {
+	gainField, peakField := TrackGain, TrackPeak
+	if album {
+		gainField, peakField = AlbumGain, AlbumPeak
+	}
+	t[gainField] = fmt.Sprintf("%+.2f dB", gain)
+	t[peakField] = fmt.Sprintf("%.6f", peak)
+}
+
+// set sets the trimmed value of the field if it's not empty and not set
+// yet. Values that aren't valid UTF-8 are decoded as Latin-1.
+func (t Tags) set(f Field, v string) {
+	if !utf8.ValidString(v) {
+		v = latin1(v)
+	}
+	v = strings.TrimSpace(strings.TrimRight(v, "\x00"))
+	if _, ok := t[f]; ok || v == "" {
+		return
+	}
+	t[f] = v
+}
+
+// latin1 decodes Latin-1 string.
+func latin1(v string) string {
+	runes := make([]rune, len(v))
+	for i := 0; i < len(v); i++ {
+		runes[i] = rune(v[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,382 @@
+package tag_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/pipe/mutable"
+
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/tag"
+	"pipelined.dev/phono/userinput"
+)
+
+var tags = tag.Tags{
+	tag.Title:   "Ænima",
+	tag.Artist:  "Tool",
+	tag.Track:   "15/15",
+	tag.Date:    "1996",
+	tag.Comment: "remastered",
+	tag.BPM:     "120",
+}
+
+func TestID3(t *testing.T) {
+	var out bytes.Buffer
+	sink := tag.Sink(func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{}, nil
+	}, fileformat.MP3(), &out, tags)
+	_, err := sink(mutable.Mutable(), 64, pipe.SignalProperties{})
+	assert.NoError(t, err)
+
+	read, err := tag.Read(fileformat.MP3(), bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, tags, read)
+
+	// ID3v2.3 with UTF-16 text and comment
+	var frames bytes.Buffer
+	id3v23Frame(&frames, "TIT2", append([]byte{1}, utf16Text("Ænima")...))
+	id3v23Frame(&frames, "TPE1", append([]byte{0}, "Tool\x00"...))
+	comment := append([]byte{1, 'e', 'n', 'g'}, utf16Text("")...)
+	id3v23Frame(&frames, "COMM", append(comment, utf16Text("remastered")...))
+	id3v23Frame(&frames, "XXXX", []byte{0, 'x'})
+	file := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(frames.Len())}, frames.Bytes()...)
+	read, err = tag.Read(fileformat.MP3(), bytes.NewReader(file))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{
+		tag.Title:   "Ænima",
+		tag.Artist:  "Tool",
+		tag.Comment: "remastered",
+	}, read)
+
+	// no tags
+	read, err = tag.Read(fileformat.MP3(), bytes.NewReader([]byte{0xff, 0xfb, 0x90, 0x00}))
+	assert.NoError(t, err)
+	assert.Empty(t, read)
+}
+
+func TestInfo(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	in, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer in.Close()
+	read, err := tag.Read(fileformat.WAV(), in)
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{tag.Artist: "freewavesamples.com", tag.Date: "2017"}, read)
+
+	wavSink, err := userinput.WAV.Sink(16)
+	assert.NoError(t, err)
+	sink := tag.Sink(wavSink(f), fileformat.WAV(), f, tags)
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, fileformat.WAV().Source(in), sink))
+
+	read, err = tag.Read(fileformat.WAV(), f)
+	assert.NoError(t, err)
+	// INFO has no BPM
+	assert.Equal(t, tag.Tags{
+		tag.Title:   "Ænima",
+		tag.Artist:  "Tool",
+		tag.Track:   "15/15",
+		tag.Date:    "1996",
+		tag.Comment: "remastered",
+	}, read)
+	// output is still valid
+	_, err = encode.Verify(context.Background(), 512, 0, fileformat.WAV(), f)
+	assert.NoError(t, err)
+
+	// list size exceeds the file
+	file := []byte("RIFF\x10\x00\x00\x00WAVELIST\xf0\xff\xff\xffINFO")
+	_, err = tag.Read(fileformat.WAV(), bytes.NewReader(file))
+	assert.Error(t, err)
+	_, err = tag.ReadChapters(fileformat.WAV(), bytes.NewReader(file))
+	assert.Error(t, err)
+}
+
+func TestVorbis(t *testing.T) {
+	sample, err := ioutil.ReadFile("../_testdata/sample.flac")
+	assert.NoError(t, err)
+	read, err := tag.Read(fileformat.FLAC(), bytes.NewReader(sample))
+	assert.NoError(t, err)
+	assert.Empty(t, read)
+
+	file := withComments(sample, "title=Ænima", "ARTIST=Tool", "TRACKNUMBER=15", "TRACKTOTAL=15", "invalid")
+	read, err = tag.Read(fileformat.FLAC(), bytes.NewReader(file))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{
+		tag.Title:  "Ænima",
+		tag.Artist: "Tool",
+		tag.Track:  "15/15",
+	}, read)
+}
+
+func id3v23Frame(w *bytes.Buffer, id string, data []byte) {
+	w.WriteString(id)
+	binary.Write(w, binary.BigEndian, uint32(len(data)))
+	w.Write([]byte{0, 0})
+	w.Write(data)
+}
+
+// utf16Text returns null-terminated UTF-16 text with byte order mark.
+func utf16Text(s string) []byte {
+	b := []byte{0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return append(b, 0, 0)
+}
+
+// withComments inserts Vorbis comment block after stream info block of
+// flac file.
+func withComments(flac []byte, comments ...string) []byte {
+	var block bytes.Buffer
+	vorbisString(&block, "reference libFLAC")
+	binary.Write(&block, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		vorbisString(&block, c)
+	}
+	const streamInfoEnd = 4 + 4 + 34
+	file := append([]byte{}, flac[:streamInfoEnd]...)
+	file[4] &^= 0x80
+	size := block.Len()
+	file = append(file, flac[4]&0x80|4, byte(size>>16), byte(size>>8), byte(size))
+	file = append(file, block.Bytes()...)
+	return append(file, flac[streamInfoEnd:]...)
+}
+
+func vorbisString(w *bytes.Buffer, s string) {
+	binary.Write(w, binary.LittleEndian, uint32(len(s)))
+	w.WriteString(s)
+}
+
+func TestWrite(t *testing.T) {
+	write := func(format *fileformat.Format, input []byte, tags tag.Tags) []byte {
+		f, err := ioutil.TempFile("", "")
+		assert.NoError(t, err)
+		defer os.Remove(f.Name())
+		defer f.Close()
+		assert.NoError(t, tag.Write(format, bytes.NewReader(input), f, tags))
+		result, err := ioutil.ReadFile(f.Name())
+		assert.NoError(t, err)
+		return result
+	}
+	edited := tag.Tags{tag.Title: "Stinkfist", tag.Track: "1/15"}
+
+	// mp3 with unknown frame and audio after the tag
+	var frames bytes.Buffer
+	id3v23Frame(&frames, "TIT2", append([]byte{0}, "Ænima"...))
+	id3v23Frame(&frames, "APIC", []byte("cover"))
+	audio := []byte{0xff, 0xfb, 0x90, 0x00, 1, 2, 3}
+	mp3 := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(frames.Len())}, frames.Bytes()...)
+	result := write(fileformat.MP3(), append(mp3, audio...), edited)
+	read, err := tag.Read(fileformat.MP3(), bytes.NewReader(result))
+	assert.NoError(t, err)
+	assert.Equal(t, edited, read)
+	assert.True(t, bytes.Contains(result, []byte("APIC\x00\x00\x00\x05\x00\x00cover")))
+	assert.True(t, bytes.HasSuffix(result, audio))
+	// mp3 without tag
+	result = write(fileformat.MP3(), audio, nil)
+	assert.Equal(t, audio, result)
+
+	// wav is still valid after replacing INFO
+	sample, err := ioutil.ReadFile("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	result = write(fileformat.WAV(), sample, edited)
+	read, err = tag.Read(fileformat.WAV(), bytes.NewReader(result))
+	assert.NoError(t, err)
+	assert.Equal(t, edited, read)
+	_, err = encode.Verify(context.Background(), 512, 0, fileformat.WAV(), bytes.NewReader(result))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(len(result)-8), binary.LittleEndian.Uint32(result[4:]))
+
+	// flac is still valid after replacing comments twice
+	sample, err = ioutil.ReadFile("../_testdata/sample.flac")
+	assert.NoError(t, err)
+	result = write(fileformat.FLAC(), sample, edited)
+	result = write(fileformat.FLAC(), result, tag.Tags{tag.Artist: "Tool"})
+	read, err = tag.Read(fileformat.FLAC(), bytes.NewReader(result))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{tag.Artist: "Tool"}, read)
+	_, err = encode.Verify(context.Background(), 512, 0, fileformat.FLAC(), bytes.NewReader(result))
+	assert.NoError(t, err)
+}
+
+func TestEdit(t *testing.T) {
+	e, err := tag.ParseEdit([]string{"album=Ænima", "year=1996", "comment="}, "{track} {artist} - {title}")
+	assert.NoError(t, err)
+	assert.False(t, e.Empty())
+	result := e.Apply(tag.Tags{tag.Comment: "old", tag.Genre: "Metal"}, "music/01 Tool - Stinkfist.mp3")
+	assert.Equal(t, tag.Tags{
+		tag.Album:  "Ænima",
+		tag.Date:   "1996",
+		tag.Genre:  "Metal",
+		tag.Track:  "01",
+		tag.Artist: "Tool",
+		tag.Title:  "Stinkfist",
+	}, result)
+	// unmatched name
+	result = e.Apply(nil, "Stinkfist.mp3")
+	assert.Equal(t, tag.Tags{tag.Album: "Ænima", tag.Date: "1996"}, result)
+
+	for _, invalid := range []struct {
+		assignments []string
+		template    string
+	}{
+		{assignments: []string{"album"}},
+		{assignments: []string{"mood=happy"}},
+		{template: "no fields"},
+		{template: "{title} - {title}"},
+		{template: "{unknown}"},
+	} {
+		_, err := tag.ParseEdit(invalid.assignments, invalid.template)
+		assert.Error(t, err, invalid)
+	}
+	e, err = tag.ParseEdit(nil, "")
+	assert.NoError(t, err)
+	assert.True(t, e.Empty())
+}
+
+func TestChapters(t *testing.T) {
+	chapters := []container.Chapter{
+		{Start: 0, End: 10 * time.Second, Title: "Intro"},
+		{Start: 10 * time.Second, End: 15 * time.Second, Title: "Ænima"},
+	}
+
+	var out bytes.Buffer
+	sink := tag.Sink(func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{}, nil
+	}, fileformat.MP3(), &out, nil, chapters...)
+	_, err := sink(mutable.Mutable(), 64, pipe.SignalProperties{})
+	assert.NoError(t, err)
+	read, err := tag.ReadChapters(fileformat.MP3(), bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, chapters, read)
+	tags, err := tag.Read(fileformat.MP3(), bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Empty(t, tags)
+
+	// wav chapters end with audio
+	f, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	in, err := os.Open("../_testdata/sample.wav")
+	assert.NoError(t, err)
+	defer in.Close()
+	wavSink, err := userinput.WAV.Sink(16)
+	assert.NoError(t, err)
+	wavChapters := []container.Chapter{{Start: 0, Title: "One"}, {Start: 3 * time.Second, Title: "Two"}}
+	sink = tag.Sink(wavSink(f), fileformat.WAV(), f, tags, wavChapters...)
+	assert.NoError(t, encode.Run(context.Background(), 512, 0, fileformat.WAV().Source(in), sink))
+	read, err = tag.ReadChapters(fileformat.WAV(), f)
+	assert.NoError(t, err)
+	assert.Len(t, read, 2)
+	assert.Equal(t, "Two", read[1].Title)
+	assert.Equal(t, 3*time.Second, read[0].End)
+	v, err := encode.Verify(context.Background(), 512, 0, fileformat.WAV(), f)
+	assert.NoError(t, err)
+	assert.InDelta(t, v.Duration, read[1].End.Seconds(), 0.001)
+
+	// flac chapter comments
+	sample, err := ioutil.ReadFile("../_testdata/sample.flac")
+	assert.NoError(t, err)
+	file := withComments(sample, "CHAPTER001=00:00:00.000", "CHAPTER001NAME=One", "CHAPTER002=00:00:02.500", "CHAPTER002NAME=Two")
+	read, err = tag.ReadChapters(fileformat.FLAC(), bytes.NewReader(file))
+	assert.NoError(t, err)
+	assert.Equal(t, []container.Chapter{
+		{Start: 0, End: 2500 * time.Millisecond, Title: "One"},
+		{Start: 2500 * time.Millisecond, End: read[1].End, Title: "Two"},
+	}, read)
+	assert.True(t, read[1].End > 2500*time.Millisecond)
+}
+
+func TestReplayGain(t *testing.T) {
+	rg := tag.Tags{}
+	rg.SetReplayGain(false, -6.2, 0.988281)
+	rg.SetReplayGain(true, 1.5, 1)
+	assert.Equal(t, tag.Tags{
+		tag.TrackGain: "-6.20 dB",
+		tag.TrackPeak: "0.988281",
+		tag.AlbumGain: "+1.50 dB",
+		tag.AlbumPeak: "1.000000",
+	}, rg)
+	gain, peak, ok := rg.ReplayGain(false)
+	assert.True(t, ok)
+	assert.Equal(t, -6.2, gain)
+	assert.Equal(t, 0.988281, peak)
+	_, _, ok = tag.Tags{tag.AlbumGain: "loud"}.ReplayGain(true)
+	assert.False(t, ok)
+
+	assert.True(t, tag.Supported(fileformat.MP3(), tag.TrackGain))
+	assert.True(t, tag.Supported(fileformat.FLAC(), tag.AlbumPeak))
+	assert.False(t, tag.Supported(fileformat.WAV(), tag.TrackGain))
+	assert.True(t, tag.Supported(fileformat.WAV(), tag.Title))
+
+	// TXXX frames and comments
+	var out bytes.Buffer
+	sink := tag.Sink(func(mutable.Context, int, pipe.SignalProperties) (pipe.Sink, error) {
+		return pipe.Sink{}, nil
+	}, fileformat.MP3(), &out, rg)
+	_, err := sink(mutable.Mutable(), 64, pipe.SignalProperties{})
+	assert.NoError(t, err)
+	read, err := tag.Read(fileformat.MP3(), bytes.NewReader(out.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, rg, read)
+	assert.True(t, bytes.Contains(out.Bytes(), []byte("REPLAYGAIN_TRACK_GAIN\x00-6.20 dB")))
+
+	// ID3v2.3 with UTF-16 TXXX frames
+	var frames bytes.Buffer
+	id3v23Frame(&frames, "TXXX", append(append([]byte{1}, utf16Text("REPLAYGAIN_TRACK_GAIN")...), utf16Text("-6.20 dB")...))
+	id3v23Frame(&frames, "TXXX", append([]byte{0}, "MOOD\x00calm"...))
+	file := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(frames.Len())}, frames.Bytes()...)
+	read, err = tag.Read(fileformat.MP3(), bytes.NewReader(file))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{tag.TrackGain: "-6.20 dB"}, read)
+
+	sample, err := ioutil.ReadFile("../_testdata/sample.flac")
+	assert.NoError(t, err)
+	read, err = tag.Read(fileformat.FLAC(), bytes.NewReader(withComments(sample, "replaygain_track_gain=-6.20 dB")))
+	assert.NoError(t, err)
+	assert.Equal(t, tag.Tags{tag.TrackGain: "-6.20 dB"}, read)
+}
+
+func TestManifest(t *testing.T) {
+	entries, err := tag.ReadManifest(strings.NewReader(
+		"path,format,title,year,unknown\n"+
+			"a.mp3,mp3,Stinkfist,1996,x\n"+
+			"\"b, c.flac\",flac,,,\n"), "CSV")
+	assert.NoError(t, err)
+	assert.Equal(t, []tag.Entry{
+		{Path: "a.mp3", Tags: tag.Tags{tag.Title: "Stinkfist", tag.Date: "1996"}},
+		{Path: "b, c.flac", Tags: tag.Tags{tag.Title: "", tag.Date: ""}},
+	}, entries)
+
+	entries, err = tag.ReadManifest(strings.NewReader(`[{"path": "a.mp3", "format": "mp3", "tags": {"title": "Stinkfist", "year": ""}}]`), "json")
+	assert.NoError(t, err)
+	assert.Equal(t, []tag.Entry{
+		{Path: "a.mp3", Tags: tag.Tags{tag.Title: "Stinkfist", tag.Date: ""}},
+	}, entries)
+
+	for _, invalid := range []struct{ format, manifest string }{
+		{"csv", ""},
+		{"csv", "title\nStinkfist\n"},
+		{"csv", "path,title\na.mp3\n"},
+		{"json", `[{"path": "a.mp3", "tags": {"mood": "calm"}}]`},
+		{"xml", ""},
+	} {
+		_, err = tag.ReadManifest(strings.NewReader(invalid.manifest), invalid.format)
+		assert.Error(t, err, invalid.manifest)
+	}
+}
@@ -0,0 +1,244 @@
+package tag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// vorbisComments maps Vorbis comment names to fields.
+var vorbisComments = map[string]Field{
+	"TITLE":       Title,
+	"ARTIST":      Artist,
+	"ALBUM":       Album,
+	"ALBUMARTIST": AlbumArtist,
+	"COMPOSER":    Composer,
+	"GENRE":       Genre,
+	"TRACKNUMBER": Track,
+	"DISCNUMBER":  Disc,
+	"DATE":        Date,
+	"COMMENT":     Comment,
+	"DESCRIPTION": Comment,
+	"BPM":         BPM,
+	"INITIALKEY":  Key,
+	"KEY":         Key,
+	"COPYRIGHT":   Copyright,
+
+	"REPLAYGAIN_TRACK_GAIN": TrackGain,
+	"REPLAYGAIN_TRACK_PEAK": TrackPeak,
+	"REPLAYGAIN_ALBUM_GAIN": AlbumGain,
+	"REPLAYGAIN_ALBUM_PEAK": AlbumPeak,
+}
+
+// vorbisWrites maps fields to Vorbis comment names.
+var vorbisWrites = map[Field]string{
+	Title:       "TITLE",
+	Artist:      "ARTIST",
+	Album:       "ALBUM",
+	AlbumArtist: "ALBUMARTIST",
+	Composer:    "COMPOSER",
+	Genre:       "GENRE",
+	Track:       "TRACKNUMBER",
+	Disc:        "DISCNUMBER",
+	Date:        "DATE",
+	Comment:     "COMMENT",
+	BPM:         "BPM",
+	Key:         "INITIALKEY",
+	Copyright:   "COPYRIGHT",
+	TrackGain:   "REPLAYGAIN_TRACK_GAIN",
+	TrackPeak:   "REPLAYGAIN_TRACK_PEAK",
+	AlbumGain:   "REPLAYGAIN_ALBUM_GAIN",
+	AlbumPeak:   "REPLAYGAIN_ALBUM_PEAK",
+}
+
+// vorbisCommentBlock is the type of flac metadata block.
+const vorbisCommentBlock = 4
+
+type (
+	// vorbisComment is the parsed VORBIS_COMMENT block. Comments that
+	// aren't mapped to fields are kept, so they can be written back.
+	vorbisComment struct {
+		Tags
+		vendor string
+		other  []string
+	}
+
+	// flacBlock is the raw flac metadata block.
+	flacBlock struct {
+		blockType byte
+		data      []byte
+	}
+)
+
+// readVorbis reads VORBIS_COMMENT metadata block of flac file.
+func readVorbis(r io.ReadSeeker) (Tags, error) {
+	t := Tags{}
+	err := flacBlocks(r, func(blockType byte, size int64) error {
+		if blockType != vorbisCommentBlock {
+			_, err := r.Seek(size, io.SeekCurrent)
+			return err
+		}
+		block := make([]byte, size)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return err
+		}
+		parseVorbis(t, block)
+		return nil
+	})
+	return t, err
+}
+
+// rewriteVorbis copies flac file into the output with VORBIS_COMMENT
+// blocks replaced by the single block with provided fields written after
+// STREAMINFO block. Vendor and unknown comments of the input are kept.
+func rewriteVorbis(in io.ReadSeeker, out io.Writer, t Tags) error {
+	var (
+		blocks []flacBlock
+		c      vorbisComment
+	)
+	err := flacBlocks(in, func(blockType byte, size int64) error {
+		block := make([]byte, size)
+		if _, err := io.ReadFull(in, block); err != nil {
+			return err
+		}
+		if blockType == vorbisCommentBlock {
+			c = parseVorbis(Tags{}, block)
+			return nil
+		}
+		blocks = append(blocks, flacBlock{blockType: blockType, data: block})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("invalid flac header")
+	}
+	c.Tags = t
+	if len(t) > 0 || len(c.other) > 0 {
+		blocks = append(blocks[:1], append([]flacBlock{{blockType: vorbisCommentBlock, data: c.bytes()}}, blocks[1:]...)...)
+	}
+	if _, err := out.Write([]byte("fLaC")); err != nil {
+		return err
+	}
+	for i, b := range blocks {
+		h := []byte{b.blockType, byte(len(b.data) >> 16), byte(len(b.data) >> 8), byte(len(b.data))}
+		if i == len(blocks)-1 {
+			h[0] |= 0x80
+		}
+		if _, err := out.Write(append(h, b.data...)); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// flacBlocks calls fn with the type and size of every metadata block of
+// flac file. Fn must read or skip the block.
+func flacBlocks(r io.Reader, fn func(blockType byte, size int64) error) error {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(marker[:], []byte("fLaC")) {
+		return fmt.Errorf("invalid flac header")
+	}
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return err
+		}
+		size := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		if err := fn(h[0]&0x7f, size); err != nil {
+			return err
+		}
+		if h[0]&0x80 != 0 {
+			return nil
+		}
+	}
+}
+
+// parseVorbis parses comments of the block. Lengths of the block are
+// little-endian, unlike the rest of flac format.
+func parseVorbis(t Tags, block []byte) vorbisComment {
+	c := vorbisComment{Tags: t}
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(block))
+		if n > len(block)-4 {
+			return "", false
+		}
+		s := string(block[4 : 4+n])
+		block = block[4+n:]
+		return s, true
+	}
+	vendor, ok := next()
+	if !ok || len(block) < 4 {
+		return c
+	}
+	c.vendor = vendor
+	count := int(binary.LittleEndian.Uint32(block))
+	block = block[4:]
+	var total string
+	for i := 0; i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		eq := strings.IndexByte(comment, '=')
+		if eq < 0 {
+			continue
+		}
+		name, value := strings.ToUpper(comment[:eq]), comment[eq+1:]
+		if name == "TRACKTOTAL" || name == "TOTALTRACKS" {
+			total = strings.TrimSpace(value)
+			continue
+		}
+		if f, ok := vorbisComments[name]; ok {
+			t.set(f, value)
+		} else {
+			c.other = append(c.other, comment)
+		}
+	}
+	if track, ok := t[Track]; ok && total != "" && !strings.Contains(track, "/") {
+		t[Track] = track + "/" + total
+	}
+	return c
+}
+
+// bytes encodes VORBIS_COMMENT block. Track is split into TRACKNUMBER
+// and TRACKTOTAL.
+func (c vorbisComment) bytes() []byte {
+	if c.vendor == "" {
+		c.vendor = "phono"
+	}
+	comments := make([]string, 0, len(c.Tags)+len(c.other)+1)
+	for _, f := range fields {
+		v, ok := c.Tags[f]
+		if !ok {
+			continue
+		}
+		if i := strings.IndexByte(v, '/'); f == Track && i >= 0 {
+			comments = append(comments, "TRACKNUMBER="+v[:i], "TRACKTOTAL="+v[i+1:])
+			continue
+		}
+		comments = append(comments, vorbisWrites[f]+"="+v)
+	}
+	comments = append(comments, c.other...)
+	var b bytes.Buffer
+	writeString := func(s string) {
+		binary.Write(&b, binary.LittleEndian, uint32(len(s)))
+		b.WriteString(s)
+	}
+	writeString(c.vendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, comment := range comments {
+		writeString(comment)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,134 @@
+package tempdir
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"pipelined.dev/phono/clock"
+)
+
+var errInsufficientStorage = errors.New("insufficient storage")
+
+// Janitor removes stale files from the temp directory and refuses new
+// requests when free disk space is low. Files are stale if they aren't
+// modified longer than TTL, so TTL must be longer than any conversion.
+// Files reported by Keep are owned by other components and never removed.
+// Clock and Keep can be set before the first use. Zero TTL and min free
+// space disable the corresponding policy.
+type Janitor struct {
+	Clock   clock.Clock
+	Keep    func(path string) bool
+	dir     string
+	ttl     time.Duration
+	minFree int64
+	lowDisk int32
+}
+
+// NewJanitor returns janitor of the temp directory.
+func NewJanitor(dir string, ttl time.Duration, minFree int64) *Janitor {
+	return &Janitor{
+		dir:     dir,
+		ttl:     ttl,
+		minFree: minFree,
+	}
+}
+
+// Run cleans the directory and checks free space with provided interval
+// until context is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if n, err := j.Clean(); err != nil {
+			log.Printf("Failed to clean temp folder: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d stale temp files", n)
+		}
+		if err := j.CheckSpace(); err != nil {
+			log.Printf("Failed to check free disk space: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Clean removes stale files and returns the number of removed ones.
+func (j *Janitor) Clean() (int, error) {
+	if j.ttl <= 0 {
+		return 0, nil
+	}
+	entries, err := ioutil.ReadDir(j.dir)
+	if err != nil {
+		return 0, err
+	}
+	now := clock.Or(j.Clock).Now()
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == pidFile || now.Sub(entry.ModTime()) < j.ttl {
+			continue
+		}
+		path := filepath.Join(j.dir, entry.Name())
+		if j.Keep != nil && j.Keep(path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// CheckSpace updates the state of free disk space.
+func (j *Janitor) CheckSpace() error {
+	if j.minFree <= 0 {
+		return nil
+	}
+	free, err := freeSpace(j.dir)
+	if err != nil {
+		return err
+	}
+	var low int32
+	if free < j.minFree {
+		low = 1
+	}
+	if atomic.SwapInt32(&j.lowDisk, low) != low && low == 1 {
+		log.Printf("Free disk space is low: %d bytes, new conversions are refused", free)
+	}
+	return nil
+}
+
+// Err returns error while free disk space is low.
+func (j *Janitor) Err() error {
+	if atomic.LoadInt32(&j.lowDisk) == 1 {
+		return errInsufficientStorage
+	}
+	return nil
+}
+
+// Handler refuses requests that store files with 507 status while free
+// disk space is low. GET and HEAD requests are always passed to h.
+func (j *Janitor) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && atomic.LoadInt32(&j.lowDisk) == 1 {
+			w.Header().Set("Retry-After", strconv.Itoa(60))
+			http.Error(w, errInsufficientStorage.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
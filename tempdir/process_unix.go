@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package tempdir
+
+import (
+	"syscall"
+)
+
+// running checks if process exists by sending zero signal.
+func running(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,16 @@
+package tempdir
+
+import (
+	"os"
+)
+
+// running checks if process exists. FindProcess opens the process on
+// windows, so it fails if process doesn't exist.
+func running(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,122 @@
+// Package tempdir manages temporary directories of the server. Every run
+// creates its own directory marked with the pid of the process, so the
+// directories left by crashed runs can be found and cleaned up. The
+// directory is prepared under the hidden name and renamed when the pid
+// file is written, so other processes never see it without the pid.
+package tempdir
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// prefix of temp directories names, it's followed by random digits.
+	prefix = "phono"
+	// stagingPrefix is the prefix of directories that are being created.
+	stagingPrefix = "." + prefix
+	// pidFile contains the pid of the process that owns the directory.
+	pidFile = ".pid"
+	// gracePeriod is the age of directories that can be recovered, so
+	// directories of starting processes are not touched.
+	gracePeriod = time.Minute
+)
+
+// Summary contains the result of orphaned directories recovery.
+type Summary struct {
+	// Directories is the number of removed orphaned directories.
+	Directories int
+	// Salvaged is the number of files salvaged from them.
+	Salvaged int
+}
+
+// Create creates new temp directory in root and marks it with pid of the
+// current process. If root is empty, os.TempDir is used.
+func Create(root string) (string, error) {
+	staging, err := ioutil.TempDir(root, stagingPrefix)
+	if err != nil {
+		return "", err
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(filepath.Join(staging, pidFile), pid, 0600); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(staging), strings.TrimPrefix(filepath.Base(staging), "."))
+	if err := os.Rename(staging, dir); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	return dir, nil
+}
+
+// Recover removes temp directories in root which owner process is not
+// running anymore. Before removal, salvage is called with the path of
+// every orphaned directory and returns the number of files it saved.
+// Directories without pid file were not created by this package and are
+// kept. Directories left by interrupted Create are removed as well.
+// Directories younger than a minute are not touched.
+func Recover(root string, salvage func(dir string) int) (Summary, error) {
+	if root == "" {
+		root = os.TempDir()
+	}
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return Summary{}, err
+	}
+	var s Summary
+	for _, entry := range entries {
+		if !entry.IsDir() || time.Since(entry.ModTime()) < gracePeriod {
+			continue
+		}
+		dir := filepath.Join(root, entry.Name())
+		if isTempDir(entry.Name(), stagingPrefix) {
+			if err := os.RemoveAll(dir); err != nil {
+				return s, err
+			}
+			continue
+		}
+		if !isTempDir(entry.Name(), prefix) || !orphaned(dir) {
+			continue
+		}
+		if salvage != nil {
+			s.Salvaged += salvage(dir)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return s, err
+		}
+		s.Directories++
+	}
+	return s, nil
+}
+
+// isTempDir returns true if name is prefix followed by random digits of
+// ioutil.TempDir.
+func isTempDir(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) {
+		return false
+	}
+	for _, r := range name[len(prefix):] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// orphaned returns true if the owner process of directory isn't running.
+func orphaned(dir string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(dir, pidFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return true
+	}
+	return pid != os.Getpid() && !running(pid)
+}
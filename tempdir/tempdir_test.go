@@ -0,0 +1,114 @@
+package tempdir_test
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/tempdir"
+)
+
+func TestRecover(t *testing.T) {
+	root, err := ioutil.TempDir("", "tempdir-test")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	current, err := tempdir.Create(root)
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(current, ".pid"))
+
+	mkdir := func(name, pid string, age time.Duration) string {
+		dir := filepath.Join(root, name)
+		assert.NoError(t, os.Mkdir(dir, 0700))
+		if pid != "" {
+			assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".pid"), []byte(pid), 0600))
+		}
+		modTime := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(dir, modTime, modTime))
+		return dir
+	}
+	// crashed run and interrupted creation
+	deadPid := mkdir("phono123", "999999999", time.Hour)
+	staging := mkdir(".phono456", "", time.Hour)
+	// not phono dirs
+	noPid := mkdir("phono789", "", time.Hour)
+	other := mkdir("phonograph", "999999999", time.Hour)
+	// dir of another starting process
+	young := mkdir("phono1011", "999999999", 0)
+
+	var salvaged []string
+	summary, err := tempdir.Recover(root, func(dir string) int {
+		salvaged = append(salvaged, dir)
+		return 1
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, tempdir.Summary{Directories: 1, Salvaged: 1}, summary)
+	assert.Equal(t, []string{deadPid}, salvaged)
+
+	for _, dir := range []string{current, noPid, other, young} {
+		_, err := os.Stat(dir)
+		assert.NoError(t, err)
+	}
+	for _, dir := range []string{deadPid, staging} {
+		_, err := os.Stat(dir)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	dir, err := tempdir.Create("")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stale := filepath.Join(dir, "stale")
+	kept := filepath.Join(dir, "kept")
+	fresh := filepath.Join(dir, "fresh")
+	for _, p := range []string{stale, kept, fresh} {
+		assert.NoError(t, ioutil.WriteFile(p, []byte("data"), 0600))
+	}
+	c := clock.NewManual(time.Now().Add(2 * time.Hour))
+	old := time.Now().Add(-time.Minute)
+	assert.NoError(t, os.Chtimes(stale, old, old))
+	assert.NoError(t, os.Chtimes(kept, old, old))
+	assert.NoError(t, os.Chtimes(fresh, c.Now(), c.Now()))
+
+	j := tempdir.NewJanitor(dir, time.Hour, 0)
+	j.Clock = c
+	j.Keep = func(path string) bool {
+		return path == kept
+	}
+	n, err := j.Clean()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	for p, exists := range map[string]bool{
+		stale:                      false,
+		kept:                       true,
+		fresh:                      true,
+		filepath.Join(dir, ".pid"): true,
+	} {
+		_, err := os.Stat(p)
+		assert.Equal(t, exists, err == nil, p)
+	}
+
+	// no disk has that much space
+	h := j.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(method string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, "/", nil))
+		return rr.Code
+	}
+	assert.Equal(t, http.StatusOK, request(http.MethodPost))
+	j = tempdir.NewJanitor(dir, 0, math.MaxInt64)
+	assert.NoError(t, j.CheckSpace())
+	h = j.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	assert.Equal(t, http.StatusInsufficientStorage, request(http.MethodPost))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet))
+}
@@ -0,0 +1,154 @@
+package userinput
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/encode"
+)
+
+type (
+	// Capabilities describe inputs and options accepted by the form, so
+	// clients can validate them before the upload. Capabilities are
+	// served as JSON object.
+	Capabilities struct {
+		Inputs       []string           `json:"inputs"`
+		MaxSizes     map[string]int64   `json:"max_sizes"`
+		SourceURL    bool               `json:"source_url"`
+		Links        bool               `json:"links"`
+		MaxTagLength int                `json:"max_tag_length"`
+		Fields       []Field            `json:"fields"`
+		Outputs      []OutputCapability `json:"outputs"`
+	}
+
+	// OutputCapability describes the output format and fields of its
+	// options.
+	OutputCapability struct {
+		Extension   string  `json:"extension"`
+		Description string  `json:"description,omitempty"`
+		Fields      []Field `json:"fields"`
+	}
+
+	// Field describes the value of the form field. Value is one of
+	// Values if they are provided. Numeric values are within Min and Max
+	// if they are provided.
+	Field struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description,omitempty"`
+		Default     string   `json:"default,omitempty"`
+		Values      []string `json:"values,omitempty"`
+		Min         *float64 `json:"min,omitempty"`
+		Max         *float64 `json:"max,omitempty"`
+	}
+)
+
+// Capabilities returns capabilities of the form. Outputs are taken from
+// the codec registry, so sinks of external modules are included.
+func (f EncodeForm) Capabilities() Capabilities {
+	c := Capabilities{
+		Inputs:       append(inputExtensions(inputFormats...), container.Extensions...),
+		MaxSizes:     f.limits.maxSizes(),
+		SourceURL:    f.data.SourceURL,
+		Links:        f.data.Links,
+		MaxTagLength: maxTagLength,
+		Fields: []Field{
+			{Name: PeakNormalizeKey, Description: "dBFS", Max: bound(0)},
+			{Name: LoudnessKey, Description: "LUFS", Min: bound(encode.MinLoudness), Max: bound(0)},
+			{Name: TruePeakKey, Description: "dBTP", Default: strconv.FormatFloat(DefaultTruePeak, 'f', -1, 64), Max: bound(0)},
+			{Name: TempoKey, Default: "1", Min: bound(encode.MinTempo), Max: bound(encode.MaxTempo)},
+			{Name: PitchKey, Description: "semitones", Default: "0", Min: bound(-encode.MaxSemitones), Max: bound(encode.MaxSemitones)},
+			{Name: ResampleQualityKey, Default: string(encode.DefaultResampleQuality), Values: resampleQualities()},
+		},
+		Outputs: []OutputCapability{
+			{
+				Extension: fileformat.WAV().DefaultExtension(),
+				Fields: []Field{
+					{Name: "wav-bit-depth", Description: "bit depth", Values: WAV.bitDepths()},
+				},
+			},
+			{
+				Extension: fileformat.MP3().DefaultExtension(),
+				Fields: []Field{
+					{Name: "mp3-channel-mode", Description: "channel mode", Values: MP3.channelModes()},
+					{Name: "mp3-bit-rate-mode", Description: "bit rate mode", Values: []string{MP3.VBR, MP3.CBR, MP3.ABR}},
+					{Name: "mp3-bit-rate", Description: "bit rate", Min: bound(float64(MP3.MinBitRate)), Max: bound(float64(MP3.MaxBitRate))},
+					{Name: "mp3-vbr-quality", Description: "vbr quality", Min: bound(float64(MP3.MinVBR)), Max: bound(float64(MP3.MaxVBR))},
+					{Name: "mp3-quality", Description: "quality", Min: bound(float64(MP3.MinQuality)), Max: bound(float64(MP3.MaxQuality))},
+				},
+			},
+		},
+	}
+	for _, s := range registeredSinks(codec.Default) {
+		output := OutputCapability{
+			Extension:   s.Extension,
+			Description: s.Description,
+			Fields:      make([]Field, 0, len(s.Options)),
+		}
+		for _, o := range s.Options {
+			output.Fields = append(output.Fields, Field{
+				Name:        s.ID + "-" + o.Name,
+				Description: o.Description,
+				Default:     o.Default,
+				Values:      o.Values,
+			})
+		}
+		c.Outputs = append(c.Outputs, output)
+	}
+	return c
+}
+
+// ServeHTTP sends capabilities as JSON object.
+func (c Capabilities) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// bound returns the pointer to the bound of the field range.
+func bound(v float64) *float64 {
+	return &v
+}
+
+func resampleQualities() []string {
+	result := make([]string, 0, len(encode.ResampleQualities))
+	for _, q := range encode.ResampleQualities {
+		result = append(result, string(q))
+	}
+	return result
+}
+
+// bitDepths returns sorted bit depths as form values.
+func (f wavSink) bitDepths() []string {
+	result := make([]int, 0, len(f.BitDepths))
+	for bd := range f.BitDepths {
+		result = append(result, int(bd))
+	}
+	return sortedValues(result)
+}
+
+// channelModes returns sorted channel modes as form values.
+func (f mp3Sink) channelModes() []string {
+	result := make([]int, 0, len(f.ChannelModes))
+	for mode := range f.ChannelModes {
+		result = append(result, int(mode))
+	}
+	return sortedValues(result)
+}
+
+func sortedValues(values []int) []string {
+	sort.Ints(values)
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		result = append(result, strconv.Itoa(v))
+	}
+	return result
+}
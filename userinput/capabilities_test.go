@@ -0,0 +1,48 @@
+package userinput_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestCapabilities(t *testing.T) {
+	f := userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", nil, nil, true)
+	rr := httptest.NewRecorder()
+	f.Capabilities().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var c userinput.Capabilities
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &c))
+	assert.Contains(t, c.Inputs, ".mp3")
+	assert.Equal(t, int64(10), c.MaxSizes[".wav"])
+	assert.False(t, c.SourceURL)
+	assert.True(t, c.Links)
+	fields := make(map[string]userinput.Field)
+	for _, field := range c.Fields {
+		fields[field.Name] = field
+	}
+	for _, output := range c.Outputs {
+		for _, field := range output.Fields {
+			fields[field.Name] = field
+		}
+	}
+	tempo := fields[userinput.TempoKey]
+	assert.Equal(t, encode.MinTempo, *tempo.Min)
+	assert.Equal(t, encode.MaxTempo, *tempo.Max)
+	assert.Nil(t, fields[userinput.PeakNormalizeKey].Min)
+	assert.Equal(t, []string{"8", "16", "24", "32"}, fields["wav-bit-depth"].Values)
+	assert.Equal(t, float64(userinput.MP3.MaxBitRate), *fields["mp3-bit-rate"].Max)
+
+	rr = httptest.NewRecorder()
+	f.Capabilities().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/capabilities", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
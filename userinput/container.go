@@ -0,0 +1,37 @@
+package userinput
+
+import (
+	"io"
+	"io/ioutil"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/i18n"
+)
+
+var errExtractedTooLarge = i18n.Errorf("error.extracted_too_large")
+
+// extractAudio writes the audio track of container into a new temp file.
+// Container file is closed.
+func extractAudio(file spooledFile, tempDir string) (*tempFile, *fileformat.Format, error) {
+	defer file.Close()
+	f, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	audio := tempFile{File: f}
+	cw := encode.CountingWriter{Writer: f}
+	format, err := container.Extract(file, &cw)
+	if err != nil {
+		audio.Close()
+		return nil, nil, err
+	}
+	audio.size = cw.Written
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		audio.Close()
+		return nil, nil, err
+	}
+	return &audio, format, nil
+}
@@ -0,0 +1,81 @@
+package userinput
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/storage"
+)
+
+// DestinationKey is the id of the output location input in the HTML form.
+const DestinationKey = "destination"
+
+var errDestinationDisabled = i18n.Errorf("error.destination_disabled")
+
+// Destinations are remote locations where results can be uploaded
+// instead of being sent to the client. Only locations that start with
+// one of Prefixes are allowed. Locations are compared with decoded
+// paths, paths with dot segments or line breaks are not allowed.
+type Destinations struct {
+	Storage  storage.Storage
+	Prefixes []string
+}
+
+// destination returns the destination of the location or nil if it's
+// empty.
+func (d *Destinations) destination(location string) (*service.Destination, error) {
+	if location == "" {
+		return nil, nil
+	}
+	if d == nil {
+		return nil, errDestinationDisabled
+	}
+	if !d.Storage.Remote(location) {
+		return nil, fmt.Errorf("destination %q is not supported", location)
+	}
+	if strings.HasSuffix(location, "/") {
+		return nil, fmt.Errorf("destination %q has no file name", location)
+	}
+	clean, err := cleanLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("destination %q is not allowed", location)
+	}
+	for _, prefix := range d.Prefixes {
+		if prefix == "" {
+			continue
+		}
+		if prefix, err := cleanLocation(prefix); err == nil && strings.HasPrefix(clean, prefix) {
+			return &service.Destination{
+				Location: location,
+				Put: func(ctx context.Context, r io.Reader) error {
+					return d.Storage.Put(ctx, location, r)
+				},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("destination %q is not allowed", location)
+}
+
+// cleanLocation returns the location URL with decoded path and without
+// query and fragment, so prefixes can't be bypassed with escapes. Paths
+// with dot segments or line breaks are rejected.
+func cleanLocation(location string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if strings.ContainsAny(u.Path, "\r\n\x00") {
+		return "", fmt.Errorf("location %q has line breaks", location)
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("location %q has dot segments", location)
+		}
+	}
+	return u.Scheme + "://" + u.User.String() + "@" + u.Host + u.Path, nil
+}
@@ -2,122 +2,534 @@ package userinput
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 
 	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+	"pipelined.dev/signal"
 
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/container"
 	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/processor"
+	"pipelined.dev/phono/tag"
 )
 
 var (
-	errInputFormat  = errors.New("unsupported input format")
-	errOutputFormat = errors.New("unsupported output format")
+	errInputFormat  = i18n.Errorf("error.input_format")
+	errOutputFormat = i18n.Errorf("error.output_format")
+	errProgressID   = i18n.Errorf("error.progress_id")
 )
 
-var formTemplate = template.Must(template.New("encode").Parse(encodeHTML))
+// progressID is the format of progress id provided by the user.
+var progressID = regexp.MustCompile(`^[0-9a-zA-Z-]{1,64}$`)
+
+// inputFormats are formats of user-provided files.
+var inputFormats = []*fileformat.Format{
+	fileformat.WAV(),
+	fileformat.MP3(),
+	fileformat.FLAC(),
+}
 
 // FormFileKey is the id of the file userinput in the HTML form.
 const FormFileKey = "form-file"
 
+// FormatKey is the name of output format values in the form. If multiple
+// formats are selected, all outputs are encoded in a single pass and sent
+// in a zip archive.
+const FormatKey = "format"
+
+// ProgressIDKey is the id of the progress id input in the HTML form.
+const ProgressIDKey = "progress-id"
+
+// LinkKey is the id of the result link checkbox in the HTML form.
+const LinkKey = "link"
+
+// CallbackURLKey is the id of the url that receives the result of
+// conversion.
+const CallbackURLKey = "callback-url"
+
+// ProcessorKey is the name of processor spec values in the form. Multiple
+// processors are applied in the order of values.
+const ProcessorKey = "processor"
+
+// ProcessorsKey is the name of JSON processors in the form. The value is
+// the list of processors with their parameters, they are applied after
+// processors of spec values:
+//
+//	[{"name": "gain", "params": {"db": -3}}, {"name": "limiter", "params": {"ceiling": -1}}]
+const ProcessorsKey = "processors"
+
+// EQKey is the name of equalizer spec in the form. EQ is applied before
+// processors, the format is described in processor.ParseEQ.
+const EQKey = "eq"
+
+// PeakNormalizeKey is the name of peak normalization target in dBFS in
+// the form. Peak is not normalized if it's empty.
+const PeakNormalizeKey = "peak-normalize"
+
+// LoudnessKey is the name of loudness normalization target in LUFS in the
+// form. Loudness is not normalized if it's empty.
+const LoudnessKey = "loudness"
+
+// TruePeakKey is the name of true peak ceiling in dBTP of loudness
+// normalization in the form. DefaultTruePeak is used if it's empty.
+const TruePeakKey = "true-peak"
+
+// DefaultTruePeak is the true peak ceiling recommended by EBU R128.
+const DefaultTruePeak = -1.0
+
+// TempoKey is the name of tempo factor in the form. PitchKey is the name
+// of pitch shift in semitones. Tempo and pitch are not changed if they are
+// empty.
+const (
+	TempoKey = "tempo"
+	PitchKey = "pitch"
+)
+
+// ResampleQualityKey is the name of resample quality of pitch shift in
+// the form. encode.DefaultResampleQuality is used if it's empty.
+const ResampleQualityKey = "resample-quality"
+
+// DetectClippingKey is the name of clipping detection checkbox in the
+// form. FailOnClippingKey is the name of checkbox to fail the conversion
+// if the output is clipped, it enables the detection.
+const (
+	DetectClippingKey = "detect-clipping"
+	FailOnClippingKey = "fail-on-clipping"
+)
+
+// StripTagsKey is the name of checkbox in the form to omit tags of the
+// input in the output.
+const StripTagsKey = "strip-tags"
+
+// TitleKey, ArtistKey and AlbumKey are names of optional tag fields in
+// the form. Provided values replace tags of the input.
+const (
+	TitleKey  = "title"
+	ArtistKey = "artist"
+	AlbumKey  = "album"
+)
+
+// maxTagLength is the max length of tag field value in bytes.
+const maxTagLength = 256
+
+var (
+	errNormalizeBoth     = i18n.Errorf("error.normalize_both")
+	errCallbacksDisabled = i18n.Errorf("error.callbacks_disabled")
+	errMultipleOutputs   = i18n.Errorf("error.multiple_outputs")
+)
+
 type (
 	// Limits for user-provided input files.
 	Limits map[*fileformat.Format]int64
 
-	// EncodeForm provides user interaction via http form.
+	// EncodeForm provides user interaction via http form. Experimental
+	// processors are allowed if Experimental is true. Uploaded files up
+	// to MemoryLimit bytes are kept in memory, larger files are spooled
+	// to the temp dir. Zero MemoryLimit means all files are spooled. If
+	// Destinations is not nil, user can provide the location where the
+	// result is uploaded. If Callbacks is not nil, user can provide the
+	// url to receive the result of conversion.
 	EncodeForm struct {
-		buf    bytes.Buffer
-		limits Limits
+		Experimental bool
+		MemoryLimit  int64
+		Destinations *Destinations
+		Callbacks    *service.Callbacks
+		pages        map[string][]byte
+		templates    *Templates
+		codecs       *codec.Registry
+		limits       Limits
+		tempDir      string
+		fetcher      *Fetcher
+		uploads      *Uploads
+		data         templateData
 	}
 
 	// templateData provides a data for encode form template, so user can
 	// define conversion parameters.
 	templateData struct {
-		Accept     string
-		OutFormats []string
-		WAV        interface{}
-		MP3        interface{}
-		MaxSizes   map[string]int64
+		Accept                 string
+		SourceURL              bool
+		Links                  bool
+		OutFormats             []string
+		Sinks                  []formSink
+		WAV                    interface{}
+		MP3                    interface{}
+		MaxSizes               map[string]int64
+		ResampleQualities      []encode.ResampleQuality
+		DefaultResampleQuality encode.ResampleQuality
+	}
+
+	// formSink is the registered sink rendered with generic options. ID
+	// is the extension without dot, inputs of options are named with it
+	// as a prefix, e.g. aac-bitrate.
+	formSink struct {
+		ID string
+		codec.Sink
 	}
 )
 
-// NewEncodeForm creates new form with provided limits.
-func NewEncodeForm(limits Limits) EncodeForm {
-	var buf bytes.Buffer
-	err := formTemplate.Execute(&buf, templateData{
-		MaxSizes: limits.maxSizes(),
-		Accept: strings.Join(
-			inputExtensions(
+// NewEncodeForm creates new form with provided limits. Uploaded files
+// are spooled into temp dir, os.TempDir is used if it's empty. If fetcher
+// is not nil, user can provide source url instead of uploading the file.
+// If uploads is not nil, user can provide the id of completed resumable
+// upload. If links is true, user can request the link to the result
+// instead of the file.
+func NewEncodeForm(limits Limits, tempDir string, fetcher *Fetcher, uploads *Uploads, links bool) EncodeForm {
+	f := EncodeForm{
+		limits:  limits,
+		tempDir: tempDir,
+		fetcher: fetcher,
+		uploads: uploads,
+		codecs:  codec.Default,
+		data: templateData{
+			MaxSizes:  limits.maxSizes(),
+			SourceURL: fetcher != nil,
+			Links:     links,
+			Accept: strings.Join(
+				append(inputExtensions(inputFormats...), container.Extensions...),
+				", "),
+			OutFormats: append(outputExtensions(
 				fileformat.WAV(),
 				fileformat.MP3(),
-				fileformat.FLAC(),
-			),
-			", "),
-		OutFormats: outputExtensions(
-			fileformat.WAV(),
-			fileformat.MP3(),
-		),
-		WAV: WAV,
-		MP3: MP3,
-	})
-	if err != nil {
-		panic(fmt.Sprintf("failed to parse encode template: %v", err))
+			), sinkExtensions(registeredSinks(codec.Default))...),
+			Sinks:                  registeredSinks(codec.Default),
+			WAV:                    WAV,
+			MP3:                    MP3,
+			ResampleQualities:      encode.ResampleQualities,
+			DefaultResampleQuality: encode.DefaultResampleQuality,
+		},
+	}
+	if err := f.SetTemplates(defaultTemplates); err != nil {
+		panic(err)
 	}
-	return EncodeForm{
-		buf:    buf,
-		limits: limits,
+	return f
+}
+
+// SetTemplates renders the form with templates, e.g. overridden by the
+// operator. The form is rendered in all registered languages, so
+// translations must be registered before.
+func (f *EncodeForm) SetTemplates(t *Templates) error {
+	pages := make(map[string][]byte)
+	for _, lang := range i18n.Languages() {
+		var buf bytes.Buffer
+		if err := t.renderEncode(&buf, lang, f.data); err != nil {
+			return fmt.Errorf("failed to render encode template in %s: %w", lang, err)
+		}
+		pages[lang] = buf.Bytes()
 	}
+	f.pages = pages
+	f.templates = t
+	return nil
 }
 
-// Bytes returns serialized form, ready to be served.
-func (f EncodeForm) Bytes() []byte {
-	return f.buf.Bytes()
+// SetCodecs renders the form with sinks of the registry instead of
+// codec.Default and uses them to parse the output, e.g. in tests.
+func (f *EncodeForm) SetCodecs(r *codec.Registry) error {
+	sinks := registeredSinks(r)
+	data := f.data
+	data.OutFormats = append(outputExtensions(fileformat.WAV(), fileformat.MP3()), sinkExtensions(sinks)...)
+	data.Sinks = sinks
+	f.data = data
+	f.codecs = r
+	return f.SetTemplates(f.templates)
+}
+
+// Bytes returns serialized form in the language, ready to be served.
+// The form in default language is returned if the language is not
+// registered.
+func (f EncodeForm) Bytes(lang string) []byte {
+	if b, ok := f.pages[lang]; ok {
+		return b
+	}
+	return f.pages[i18n.Default]
 }
 
 // Parse returns the data provided by the user via submitted form.
-func (f EncodeForm) Parse(r *http.Request) (encode.FormData, error) {
+// Input format is taken from the URL path. If source url is provided
+// instead of the file, input format is detected after download. If
+// path has the extension of media container, its audio track is
+// extracted.
+func (f EncodeForm) Parse(r *http.Request) (_ service.FormData, err error) {
+	form, inputFormat, extract, err := f.parseRequest(r)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	defer func() {
+		if err != nil {
+			form.Close()
+		}
+	}()
+
+	// parse sinks and validate parameters
+	outputs, err := parseOutputs(form.Value, f.codecs)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	id := form.Value.Get(ProgressIDKey)
+	if id != "" && !progressID.MatchString(id) {
+		return service.FormData{}, errProgressID
+	}
+	link, err := parseBoolValue(form.Value, LinkKey, "result link")
+	if err != nil {
+		return service.FormData{}, err
+	}
+	destination, err := f.Destinations.destination(form.Value.Get(DestinationKey))
+	if err != nil {
+		return service.FormData{}, err
+	}
+	callback, err := f.callback(form.Value.Get(CallbackURLKey))
+	if err != nil {
+		return service.FormData{}, err
+	}
+	if len(outputs) > 1 && (link || destination != nil) {
+		return service.FormData{}, errMultipleOutputs
+	}
+	peakNormalize, peakTarget, err := parsePeakNormalize(form.Value)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	loudnessNormalize, loudnessTarget, truePeak, err := parseLoudnessNormalize(form.Value)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	if peakNormalize && loudnessNormalize {
+		return service.FormData{}, errNormalizeBoth
+	}
+	stretch, tempo, pitch, err := parseStretch(form.Value)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	resampleQuality, err := encode.ParseResampleQuality(form.Value.Get(ResampleQualityKey))
+	if err != nil {
+		return service.FormData{}, err
+	}
+	detectClipping, err := parseBoolValue(form.Value, DetectClippingKey, "clipping detection")
+	if err != nil {
+		return service.FormData{}, err
+	}
+	failOnClipping, err := parseBoolValue(form.Value, FailOnClippingKey, "fail on clipping")
+	if err != nil {
+		return service.FormData{}, err
+	}
+	stripTags, err := parseBoolValue(form.Value, StripTagsKey, "strip tags")
+	if err != nil {
+		return service.FormData{}, err
+	}
+	tags, err := parseTags(form.Value)
+	if err != nil {
+		return service.FormData{}, err
+	}
+	processors, warnings, err := f.ParseProcessors(form.Value)
+	if err != nil {
+		return service.FormData{}, err
+	}
+
+	input, err := f.parseInput(r.Context(), form, inputFormat, extract)
+	if err != nil {
+		return service.FormData{}, err
+	}
+
+	return service.FormData{
+		Input:             input,
+		Output:            outputs[0],
+		Also:              outputs[1:],
+		Processors:        processors,
+		Warnings:          warnings,
+		ProgressID:        id,
+		Link:              link,
+		Destination:       destination,
+		Callback:          callback,
+		PeakNormalize:     peakNormalize,
+		PeakTarget:        peakTarget,
+		LoudnessNormalize: loudnessNormalize,
+		LoudnessTarget:    loudnessTarget,
+		TruePeak:          truePeak,
+		Stretch:           stretch,
+		Tempo:             tempo,
+		Pitch:             pitch,
+		ResampleQuality:   resampleQuality,
+		DetectClipping:    detectClipping || failOnClipping,
+		FailOnClipping:    failOnClipping,
+		StripTags:         stripTags,
+		Tags:              tags,
+	}, nil
+}
+
+// ParseProcessors returns processors inserted into the pipe by form
+// values and warnings about experimental ones. EQ is applied first, then
+// processors of spec values and JSON processors. Parameters of JSON
+// processors can be strings, numbers or booleans.
+func (f EncodeForm) ParseProcessors(data url.Values) ([]pipe.ProcessorAllocatorFunc, []string, error) {
+	var warnings []string
+	warning := func(p processor.Processor) {
+		warnings = append(warnings, fmt.Sprintf("processor %s is experimental", p.Name))
+	}
+	var processors []pipe.ProcessorAllocatorFunc
+	if eq := data.Get(EQKey); eq != "" {
+		bands, err := processor.ParseEQ(eq)
+		if err != nil {
+			return nil, nil, err
+		}
+		processors = append(processors, processor.EQ(bands...))
+	}
+	specs, err := processor.Default.Allocators(data[ProcessorKey], f.Experimental, warning)
+	if err != nil {
+		return nil, nil, err
+	}
+	processors = append(processors, specs...)
+	for _, v := range data[ProcessorsKey] {
+		if strings.TrimSpace(v) == "" {
+			continue
+		}
+		fns, err := jsonProcessors(v, f.Experimental, warning)
+		if err != nil {
+			return nil, nil, err
+		}
+		processors = append(processors, fns...)
+	}
+	return processors, warnings, nil
+}
+
+// jsonProcessors returns allocators of JSON processors.
+func jsonProcessors(v string, experimental bool, warning func(processor.Processor)) ([]pipe.ProcessorAllocatorFunc, error) {
+	var list []struct {
+		Name   string                 `json:"name"`
+		Params map[string]interface{} `json:"params"`
+	}
+	dec := json.NewDecoder(strings.NewReader(v))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid processors: %w", err)
+	}
+	result := make([]pipe.ProcessorAllocatorFunc, 0, len(list))
+	for _, p := range list {
+		registered, err := processor.Default.Lookup(p.Name, experimental)
+		if err != nil {
+			return nil, err
+		}
+		if registered.Experimental && warning != nil {
+			warning(registered)
+		}
+		params := make(processor.Params, len(p.Params))
+		for key, value := range p.Params {
+			switch value := value.(type) {
+			case string:
+				params[key] = value
+			case json.Number:
+				params[key] = value.String()
+			case bool:
+				params[key] = strconv.FormatBool(value)
+			default:
+				return nil, fmt.Errorf("processor %s: invalid parameter %s", p.Name, key)
+			}
+		}
+		fn, err := registered.New(params)
+		if err != nil {
+			return nil, fmt.Errorf("processor %s: %w", registered.Name, err)
+		}
+		result = append(result, fn)
+	}
+	return result, nil
+}
+
+// callback returns the callback of the url or nil if it's empty.
+func (f EncodeForm) callback(rawURL string) (*service.Callback, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	if f.Callbacks == nil {
+		return nil, errCallbacksDisabled
+	}
+	return f.Callbacks.Callback(rawURL)
+}
+
+// parseRequest parses multipart form of the request limited by the max
+// size of input format. Input format is taken from the URL path. Extract
+// is true if the path has the extension of media container.
+func (f EncodeForm) parseRequest(r *http.Request) (*multipartForm, *fileformat.Format, bool, error) {
 	inputFormat := fileformat.FormatByPath(r.URL.Path)
-	if inputFormat == nil {
-		return encode.FormData{}, errInputFormat
+	extract := inputFormat == nil && container.MatchPath(r.URL.Path)
+	if inputFormat == nil && !extract && f.fetcher == nil {
+		return nil, nil, false, errInputFormat
 	}
 	// get max size for the format
 	maxSize := f.inputMaxSize(inputFormat)
 	if maxSize > 0 {
-		// check if limit is defined
-		if maxSize > 0 {
-			r.Body = http.MaxBytesReader(nil, r.Body, maxSize)
-		}
+		r.Body = http.MaxBytesReader(nil, r.Body, maxSize)
 	}
-	// check max size
-	if err := r.ParseMultipartForm(maxSize); err != nil {
-		return encode.FormData{}, err
+	form, err := parseMultipart(r, f.tempDir, f.MemoryLimit)
+	if err != nil {
+		return nil, nil, false, err
 	}
+	return form, inputFormat, extract, nil
+}
 
-	file, _, err := r.FormFile(FormFileKey)
-	if err != nil {
-		return encode.FormData{}, err
+// parseInput returns either uploaded or fetched input file. If extract
+// is true, uploaded file is a container and its audio track is used.
+func (f EncodeForm) parseInput(ctx context.Context, form *multipartForm, format *fileformat.Format, extract bool) (service.Input, error) {
+	if uploadID := form.Value.Get(UploadIDKey); uploadID != "" {
+		return f.parseUpload(form, format, extract, uploadID)
+	}
+	sourceURL := form.Value.Get(SourceURLKey)
+	if sourceURL == "" {
+		if format == nil && !extract {
+			return service.Input{}, errInputFormat
+		}
+		if form.File == nil {
+			return service.Input{}, http.ErrMissingFile
+		}
+		if extract {
+			file, format, err := extractAudio(form.File, f.tempDir)
+			form.File = nil
+			if err != nil {
+				return service.Input{}, err
+			}
+			if maxSize := f.inputMaxSize(format); maxSize > 0 && file.Size() > maxSize {
+				file.Close()
+				return service.Input{}, errExtractedTooLarge
+			}
+			return service.Input{
+				Format: format,
+				File:   file,
+				Size:   file.Size(),
+			}, nil
+		}
+		return service.Input{
+			Format: format,
+			File:   form.File,
+			Size:   form.File.Size(),
+		}, nil
 	}
 
-	// parse sink and validate parameters
-	sink, outputFormat, err := parseOutput(r.MultipartForm.Value)
+	if f.fetcher == nil {
+		return service.Input{}, errSourceURLDisabled
+	}
+	// uploaded file is ignored if source url is provided
+	form.Close()
+	file, format, err := f.fetcher.fetch(ctx, sourceURL)
 	if err != nil {
-		return encode.FormData{}, err
+		return service.Input{}, err
 	}
-
-	return encode.FormData{
-		Input: encode.Input{
-			Format: inputFormat,
-			File:   file,
-		},
-		Output: encode.Output{
-			Format: outputFormat,
-			Sink:   sink,
-		},
+	if maxSize := f.inputMaxSize(format); maxSize > 0 && file.Size() > maxSize {
+		file.Close()
+		return service.Input{}, errSourceURLTooLarge
+	}
+	return service.Input{
+		Format: format,
+		File:   file,
+		Size:   file.Size(),
 	}, nil
 }
 
@@ -138,6 +550,28 @@ func outputExtensions(formats ...*fileformat.Format) []string {
 	return result
 }
 
+// registeredSinks returns registered sinks that don't have dedicated
+// options in the form. Their options, e.g. compression level of flac,
+// are rendered as generic controls shown with the selected format.
+func registeredSinks(r *codec.Registry) []formSink {
+	var result []formSink
+	for _, s := range r.Sinks() {
+		if s.Format == fileformat.WAV() || s.Format == fileformat.MP3() {
+			continue
+		}
+		result = append(result, formSink{ID: strings.TrimPrefix(s.Extension, "."), Sink: s})
+	}
+	return result
+}
+
+func sinkExtensions(sinks []formSink) []string {
+	result := make([]string, 0, len(sinks))
+	for _, s := range sinks {
+		result = append(result, s.Extension)
+	}
+	return result
+}
+
 func (l Limits) maxSizes() map[string]int64 {
 	m := make(map[string]int64)
 	for format, limit := range l {
@@ -148,41 +582,150 @@ func (l Limits) maxSizes() map[string]int64 {
 	return m
 }
 
-// inputMaxSize of file from http request.
+// parseUpload returns completed resumable upload.
+func (f EncodeForm) parseUpload(form *multipartForm, format *fileformat.Format, extract bool, uploadID string) (service.Input, error) {
+	if f.uploads == nil {
+		return service.Input{}, errUploadNotFound
+	}
+	if format == nil && !extract {
+		return service.Input{}, errInputFormat
+	}
+	// uploaded file is ignored if upload id is provided
+	form.Close()
+	file, err := f.uploads.take(uploadID)
+	if err != nil {
+		return service.Input{}, err
+	}
+	if extract {
+		if file, format, err = extractAudio(file, f.tempDir); err != nil {
+			return service.Input{}, err
+		}
+	}
+	if maxSize := f.inputMaxSize(format); maxSize > 0 && file.Size() > maxSize {
+		file.Close()
+		return service.Input{}, errUploadTooLarge
+	}
+	return service.Input{
+		Format: format,
+		File:   file,
+		Size:   file.Size(),
+	}, nil
+}
+
+// inputMaxSize of file from http request. Containers and files of
+// unknown format are limited by the largest limit if all input formats
+// are limited.
 func (f EncodeForm) inputMaxSize(format *fileformat.Format) int64 {
-	return f.limits[format]
+	if format != nil {
+		return f.limits[format]
+	}
+	var max int64
+	for _, in := range inputFormats {
+		limit := f.limits[in]
+		if limit == 0 {
+			return 0
+		}
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
 }
 
-// ParseForm provided via form.
-// This function should return extensions, sinkbuilder
-func parseOutput(formData url.Values) (Sink, *fileformat.Format, error) {
-	formatString := strings.ToLower(formData.Get("format"))
+// parseOutputs parses output formats and sink parameters provided via
+// form. Every format can be selected once, outputs are returned in the
+// order of selection.
+func parseOutputs(formData url.Values, codecs *codec.Registry) ([]service.Output, error) {
+	formats := formData[FormatKey]
+	if len(formats) == 0 {
+		return nil, i18n.Errorf("error.output_missing")
+	}
+	outputs := make([]service.Output, 0, len(formats))
+	selected := make(map[string]struct{})
+	for _, f := range formats {
+		output, err := parseOutput(formData, f, codecs)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := selected[output.DefaultExtension()]; ok {
+			return nil, i18n.Errorf("error.output_twice", f)
+		}
+		selected[output.DefaultExtension()] = struct{}{}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// parseOutput parses sink parameters of output format provided via form.
+// Formats other than wav and mp3 are looked up in codecs.
+func parseOutput(formData url.Values, formatString string, codecs *codec.Registry) (service.Output, error) {
+	formatString = strings.ToLower(formatString)
 	format := fileformat.FormatByPath(formatString)
-	var (
-		sink Sink
-		err  error
-	)
 	switch format {
 	case fileformat.WAV():
-		sink, err = parseWAVSink(formData)
+		sink, bitDepth, err := parseWAVSink(formData)
+		if err != nil {
+			return service.Output{}, err
+		}
+		return service.Output{
+			Format:   format,
+			Sink:     sink,
+			BitDepth: bitDepth,
+		}, nil
 	case fileformat.MP3():
-		sink, err = parseMP3Sink(formData)
+		stream, err := parseMP3Sink(formData)
+		if err != nil {
+			return service.Output{}, err
+		}
+		return service.Output{
+			Format:   format,
+			Sink:     stream.Sink(),
+			Stream:   stream,
+			BitDepth: signal.BitDepth16,
+		}, nil
 	default:
-		return nil, nil, fmt.Errorf("Unsupported format: %v", formatString)
+		return parseRegisteredOutput(formData, formatString, codecs)
+	}
+}
+
+// parseRegisteredOutput parses options of the registered sink. Options
+// that are not provided have default values.
+func parseRegisteredOutput(formData url.Values, formatString string, codecs *codec.Registry) (service.Output, error) {
+	s, err := codecs.Sink(formatString)
+	if err != nil {
+		return service.Output{}, i18n.Errorf("error.output_unsupported", formatString)
+	}
+	params := make(codec.Params)
+	prefix := strings.TrimPrefix(s.Extension, ".") + "-"
+	for _, o := range s.Options {
+		if v := formData.Get(prefix + o.Name); v != "" {
+			params[o.Name] = v
+		}
 	}
-	return sink, format, err
+	enc, err := s.NewEncoder(params)
+	if err != nil {
+		return service.Output{}, err
+	}
+	return service.Output{
+		Format:    s.Format,
+		Extension: s.Extension,
+		Sink:      enc.Sink,
+		Stream:    enc.Stream,
+		BitDepth:  enc.BitDepth,
+	}, nil
 }
 
-func parseWAVSink(data url.Values) (Sink, error) {
+func parseWAVSink(data url.Values) (Sink, signal.BitDepth, error) {
 	// try to get bit depth
 	bitDepth, err := parseIntValue(data, "wav-bit-depth", "bit depth")
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return WAV.Sink(bitDepth)
+	sink, err := WAV.Sink(bitDepth)
+	return sink, signal.BitDepth(bitDepth), err
 }
 
-func parseMP3Sink(data url.Values) (Sink, error) {
+func parseMP3Sink(data url.Values) (StreamSink, error) {
 	// try to get channel mode
 	channelMode, err := parseIntValue(data, "mp3-channel-mode", "channel mode")
 	if err != nil {
@@ -222,7 +765,68 @@ func parseMP3Sink(data url.Values) (Sink, error) {
 		}
 	}
 
-	return MP3.Sink(bitRateMode, bitRate, channelMode, useQuality, quality)
+	return MP3.StreamSink(bitRateMode, bitRate, channelMode, useQuality, quality)
+}
+
+// parsePeakNormalize parses peak normalization target. Normalization is
+// disabled if target is not provided.
+func parsePeakNormalize(data url.Values) (bool, float64, error) {
+	str := data.Get(PeakNormalizeKey)
+	if str == "" {
+		return false, 0, nil
+	}
+	target, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("Failed parsing peak target %s: %v", str, err)
+	}
+	if err := encode.CheckPeakTarget(target); err != nil {
+		return false, 0, err
+	}
+	return true, target, nil
+}
+
+// parseLoudnessNormalize parses loudness target and true peak ceiling.
+// Normalization is disabled if target is not provided.
+func parseLoudnessNormalize(data url.Values) (bool, float64, float64, error) {
+	str := data.Get(LoudnessKey)
+	if str == "" {
+		return false, 0, 0, nil
+	}
+	target, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("Failed parsing loudness target %s: %v", str, err)
+	}
+	truePeak := DefaultTruePeak
+	if str := data.Get(TruePeakKey); str != "" {
+		if truePeak, err = strconv.ParseFloat(str, 64); err != nil {
+			return false, 0, 0, fmt.Errorf("Failed parsing true peak %s: %v", str, err)
+		}
+	}
+	if err := encode.CheckLoudnessTarget(target, truePeak); err != nil {
+		return false, 0, 0, err
+	}
+	return true, target, truePeak, nil
+}
+
+// parseStretch parses tempo and pitch shift. Stretch is disabled if they
+// don't change the signal.
+func parseStretch(data url.Values) (bool, float64, float64, error) {
+	tempo, pitch := 1.0, 0.0
+	var err error
+	if str := data.Get(TempoKey); str != "" {
+		if tempo, err = strconv.ParseFloat(str, 64); err != nil {
+			return false, 0, 0, fmt.Errorf("Failed parsing tempo %s: %v", str, err)
+		}
+	}
+	if str := data.Get(PitchKey); str != "" {
+		if pitch, err = encode.ParseSemitones(str); err != nil {
+			return false, 0, 0, err
+		}
+	}
+	if err := encode.CheckStretch(tempo, pitch); err != nil {
+		return false, 0, 0, err
+	}
+	return tempo != 1 || pitch != 0, tempo, pitch, nil
 }
 
 // parseIntValue parses value of key provided in the html form. Returns
@@ -230,7 +834,7 @@ func parseMP3Sink(data url.Values) (Sink, error) {
 func parseIntValue(data url.Values, key, name string) (int, error) {
 	str := data.Get(key)
 	if str == "" {
-		return 0, fmt.Errorf("%s not provided", name)
+		return 0, i18n.Errorf("error.value_missing", name)
 	}
 
 	val, err := strconv.Atoi(str)
@@ -257,224 +861,29 @@ func parseBoolValue(data url.Values, key, name string) (bool, error) {
 	return val, nil
 }
 
-const encodeHTML = `
-<html>
-<head>
-    <style>
-        * {
-            font-family: Verdana;
-        }
-        form {
-            margin: 0;
-        }
-        a {
-            color:inherit;
-        }
-        button {
-            background:none!important;
-            color:inherit;
-            border:none;
-            padding:0!important;
-            font: inherit;
-            border-bottom:1px solid #444;
-            cursor: pointer;
-        }
-        .file {
-            margin-bottom: 20px;
-        }
-        .container {
-            width: 1000px;
-            margin-right: auto;
-            margin-left: auto;
-        }
-        .outputs {
-            margin-bottom: 20px;
-            display: block;
-        }
-        .output-options {
-            display: none;
-        }
-        .mp3-bit-rate-mode-options{
-            display: none;
-        }
-        .mp3-quality {
-            display: inline;
-        }
-        .option {
-            margin-right: 7px;
-        }
-        .footer{
-            position: fixed;
-            padding-top: 15px;
-            padding-bottom: 15px;
-            bottom: 0;
-        }
-        #output-format-block {
-            display: none;
-        }
-        #form-file {
-            display: none;
-        }
-        #form-file-label {
-            cursor: pointer;
-            padding:0!important;
-            border-bottom:1px solid #444;
-        }
-    </style>
-    <script type="text/javascript">
-        const fileId = 'form-file';
-        const accept = '{{ .Accept }}';
-        function getFile() {
-            return document.getElementById(fileId);
-        }
-        function getFileName(file) {
-            var filePath = file.value;
-            return filePath.substr(filePath.lastIndexOf('\\') + 1);
-        }
-        function getFileExtension(fileName) {
-            return '.'.concat(fileName.split('.')[1]);
-        }
-        function humanFileSize(size) {
-            var i = size == 0 ? 0 : Math.floor(Math.log(size) / Math.log(1024));
-            return (size / Math.pow(1024, i)).toFixed(2) * 1 + ' ' + ['B', 'kB', 'MB', 'GB', 'TB'][i];
-        };
-        function displayClass(className, mode) {
-            var elements = document.getElementsByClassName(className);
-            for (var i = 0, ii = elements.length; i < ii; i++) {
-                elements[i].style.display = mode;
-            };
-        }
-        function displayId(id, mode){
-            document.getElementById(id).style.display = mode;
-        }
-        document.addEventListener('DOMContentLoaded', function(event) {
-            document.getElementById('encode').reset();
-            // base form handlers
-            document.getElementById('form-file').addEventListener('change', onInputFileChange);
-            document.getElementById('output-format').addEventListener('change', onOutputFormatChange);
-            document.getElementById('submit-button').addEventListener('click', onSubmitClick);
-            // mp3 handlers
-            document.getElementById('mp3-bit-rate-mode').addEventListener('change', onMp3BitRateModeChange);
-            document.getElementById('mp3-use-quality').addEventListener('click', onMp3UseQUalityChange);
-        });
-        function onInputFileChange(){
-            var fileName = getFileName(getFile());
-            document.getElementById('form-file-label').innerHTML = fileName;
-            var ext = getFileExtension(fileName);
-            if (accept.indexOf(ext) < 0) {
-                alert('Only files with following extensions are allowed: {{.Accept}}')
-                return;
-            }
-            displayClass('form-file-label', 'inline');
-            displayId('output-format-block', 'inline');
-        }
-		function onOutputFormatChange(){
-            displayClass('output-options', 'none');
-            // need to cut the dot
-        	displayId(this.value.slice(1)+'-options', 'inline');
-        	displayClass('submit', 'block');
-        }
-        function onMp3BitRateModeChange(){
-        	displayClass('mp3-bit-rate-mode-options', 'none');
-        	var selectedOptions = 'mp3-'+this.options[this.selectedIndex].id+'-options';
-        	displayClass(selectedOptions, 'inline');
-        }
-        function onMp3UseQUalityChange(){
-            if (this.checked) {
-                document.getElementById('mp3-quality-value').style.visibility = '';
-            } else {
-                document.getElementById('mp3-quality-value').style.visibility = 'hidden';
-            }
-        }
-        function onSubmitClick(){
-            var encode = document.getElementById('encode');
-            var file = getFile();
-            var ext = getFileExtension(getFileName(file));
-            encode.action = ext;
-            var size = file.files[0].size;
-            switch (ext) {
-            {{ range $ext, $maxSize := .MaxSizes }}
-            case '{{$ext}}':
-                if ({{ $maxSize }} > 0 && {{ $maxSize }} < size) {
-                    alert('File is too big. Maximum allowed size: '.concat(humanFileSize({{ $maxSize }})))
-                    return;
-                }
-                break;
-            {{ end }}
-            }
-            encode.submit();
-        }
-    </script>
-</head>
-<body>
-    <div class="container">
-        <h2>phono encode</h1>
-        <form id="encode" enctype="multipart/form-data" method="post">
-        <div class="file">
-            <input id="form-file" type="file" name="form-file" accept="{{.Accept}}"/>
-            <label id="form-file-label" for="form-file">select file</label>
-        </div>
-        <div class="outputs">
-            <div id="output-format-block" class="option">
-                format
-                <select id="output-format" name="format">
-                    <option hidden disabled selected value>select</option>
-                    {{range $value := .OutFormats}}
-                        <option id="{{ $value }}" value="{{ $value }}">{{ $value }}</option>
-                    {{end}}
-                </select>
-            </div>
-            <div id="wav-options" class="output-options">
-                bit depth
-                <select name="wav-bit-depth" class="option">
-                    <option hidden disabled selected value>select</option>
-                    {{range $key, $value := .WAV.BitDepths}}
-                        <option value="{{ printf "%d" $key }}">{{ $key }}</option>
-                    {{end}}
-                </select>
-            </div>
-            <div id="mp3-options" class="output-options">
-                channel mode
-                <select name="mp3-channel-mode" class="option">
-                    <option hidden disabled selected value>select</option>
-                    {{range $key, $value := .MP3.ChannelModes}}
-                        <option value="{{ printf "%d" $key }}">{{ $key }}</option>
-                    {{end}}
-                </select>
-                bit rate mode
-                <select id="mp3-bit-rate-mode" class="option" name="mp3-bit-rate-mode">
-                    <option hidden disabled selected value>select</option>
-                    <option id="{{ .MP3.VBR  }}" value="{{ .MP3.VBR }}">{{ .MP3.VBR }}</option>
-                    <option id="{{ .MP3.CBR  }}" value="{{ .MP3.CBR }}">{{ .MP3.CBR }}</option>
-                    <option id="{{ .MP3.ABR  }}" value="{{ .MP3.ABR }}">{{ .MP3.ABR }}</option>
-                </select>
-                <div class="mp3-bit-rate-mode-options mp3-{{ .MP3.ABR }}-options mp3-{{ .MP3.CBR }}-options">
-                    bit rate [{{ .MP3.MinBitRate }}-{{ .MP3.MaxBitRate }}]
-                    <input type="text" class="option" name="mp3-bit-rate" maxlength="3" size="3">
-                </div>
-                <div class="mp3-bit-rate-mode-options mp3-{{ .MP3.VBR }}-options">
-                    vbr quality [{{ .MP3.MinVBR }}-{{ .MP3.MaxVBR }}]
-                    <input type="text" class="option" name="mp3-vbr-quality" maxlength="1" size="3">
-                </div>
-                <div class="mp3-quality">
-                    <input type="checkbox" id="mp3-use-quality" name="mp3-use-quality" value="true">quality
-                    <div id="mp3-quality-value" class="mp3-quality" style="visibility:hidden">
-                        [{{ .MP3.MinQuality }}-{{ .MP3.MaxQuality }}]
-                        <input type="text" class="option" name="mp3-quality" maxlength="1" size="3">
-                    </div>
-                </div>
-            </div>
-        </div>
-        </form>
-        <div class="submit" style="display:none">
-            <button id="submit-button" type="button">encode</button>
-        </div>
-        <div class="footer">
-            <div class="container">
-            powered by <a href="https://github.com/pipelined/pipe" target="_blank">pipe</a>
-            </div>
-        </div>
-    </div>
-</body>
-</html>
-`
+// parseTags parses optional tag fields provided in the html form. Nil is
+// returned if no fields are provided.
+func parseTags(data url.Values) (tag.Tags, error) {
+	var tags tag.Tags
+	for _, field := range []struct {
+		key   string
+		field tag.Field
+	}{
+		{TitleKey, tag.Title},
+		{ArtistKey, tag.Artist},
+		{AlbumKey, tag.Album},
+	} {
+		v := strings.TrimSpace(data.Get(field.key))
+		if v == "" {
+			continue
+		}
+		if len(v) > maxTagLength {
+			return nil, i18n.Errorf("error.tag_too_long", field.field, maxTagLength)
+		}
+		if tags == nil {
+			tags = tag.Tags{}
+		}
+		tags[field.field] = v
+	}
+	return tags, nil
+}
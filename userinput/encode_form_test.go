@@ -3,17 +3,25 @@ package userinput_test
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/html"
 	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
 
+	"pipelined.dev/phono/codec"
+	"pipelined.dev/phono/i18n"
 	"pipelined.dev/phono/userinput"
+	"pipelined.dev/signal"
 )
 
 func TestFormParsing(t *testing.T) {
@@ -69,7 +77,7 @@ func TestFormParsing(t *testing.T) {
 
 	noLimits := userinput.Limits{}
 	t.Run("ok wav",
-		testOk(userinput.NewEncodeForm(noLimits),
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
 			newWavRequest(
 				map[string]string{
 					"format":        ".wav",
@@ -78,8 +86,168 @@ func TestFormParsing(t *testing.T) {
 			),
 		),
 	)
+	t.Run("ok peak normalize",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                   ".wav",
+					"wav-bit-depth":            "16",
+					userinput.PeakNormalizeKey: "-1.5",
+				},
+			),
+		),
+	)
+	t.Run("peak normalize above full scale",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                   ".wav",
+					"wav-bit-depth":            "16",
+					userinput.PeakNormalizeKey: "1",
+				},
+			),
+		),
+	)
+	t.Run("ok loudness normalize",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":              ".wav",
+					"wav-bit-depth":       "16",
+					userinput.LoudnessKey: "-16",
+					userinput.TruePeakKey: "-1.5",
+				},
+			),
+		),
+	)
+	t.Run("loudness normalize with peak",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                   ".wav",
+					"wav-bit-depth":            "16",
+					userinput.LoudnessKey:      "-16",
+					userinput.PeakNormalizeKey: "-1",
+				},
+			),
+		),
+	)
+	t.Run("ok stretch",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                     ".wav",
+					"wav-bit-depth":              "16",
+					userinput.TempoKey:           "1.25",
+					userinput.PitchKey:           "+2st",
+					userinput.ResampleQualityKey: "sinc-best",
+				},
+			),
+		),
+	)
+	t.Run("invalid resample quality",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                     ".wav",
+					"wav-bit-depth":              "16",
+					userinput.PitchKey:           "+2",
+					userinput.ResampleQualityKey: "cubic",
+				},
+			),
+		),
+	)
+	t.Run("tempo out of range",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":           ".wav",
+					"wav-bit-depth":    "16",
+					userinput.TempoKey: "10",
+				},
+			),
+		),
+	)
+	t.Run("ok clipping",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                    ".wav",
+					"wav-bit-depth":             "16",
+					userinput.FailOnClippingKey: "true",
+				},
+			),
+		),
+	)
+	t.Run("invalid clipping detection",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":                    ".wav",
+					"wav-bit-depth":             "16",
+					userinput.DetectClippingKey: "maybe",
+				},
+			),
+		),
+	)
+	t.Run("invalid strip tags",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":               ".wav",
+					"wav-bit-depth":        "16",
+					userinput.StripTagsKey: "maybe",
+				},
+			),
+		),
+	)
+	t.Run("ok tags",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":            ".wav",
+					"wav-bit-depth":     "16",
+					userinput.TitleKey:  "Stinkfist",
+					userinput.ArtistKey: "Tool",
+				},
+			),
+		),
+	)
+	t.Run("tag too long",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":           ".wav",
+					"wav-bit-depth":    "16",
+					userinput.AlbumKey: strings.Repeat("a", 257),
+				},
+			),
+		),
+	)
+	t.Run("ok eq",
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":        ".wav",
+					"wav-bit-depth": "16",
+					userinput.EQKey: "highpass:80,peak:3000:-4:1.0,lowshelf:120:+2",
+				},
+			),
+		),
+	)
+	t.Run("invalid eq",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newWavRequest(
+				map[string]string{
+					"format":        ".wav",
+					"wav-bit-depth": "16",
+					userinput.EQKey: "peak:3000",
+				},
+			),
+		),
+	)
 	t.Run("ok mp3 vbr",
-		testOk(userinput.NewEncodeForm(noLimits),
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
 			newWavRequest(
 				map[string]string{
 					"format":            ".mp3",
@@ -93,7 +261,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("ok mp3 cbr",
-		testOk(userinput.NewEncodeForm(noLimits),
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -104,7 +272,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("ok mp3 abr",
-		testOk(userinput.NewEncodeForm(noLimits),
+		testOk(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -114,36 +282,44 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("fail size exceeded",
-		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", nil, nil, false),
 			newWavRequest(nil),
 		),
 	)
 	t.Run("fail userinput format",
-		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", nil, nil, false),
 			newRequest("non-existing-format", "", nil),
 		),
 	)
 	t.Run("fail output format",
-		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format": "non-existing-format",
 			}),
 		),
 	)
+	t.Run("fail invalid container",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newRequest("test/.mp4", "../_testdata/not-media", map[string]string{
+				"format":        ".wav",
+				"wav-bit-depth": "16",
+			}),
+		),
+	)
 	t.Run("fail no file",
-		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", nil, nil, false),
 			newRequest(".wav", "", nil),
 		),
 	)
 	t.Run("fail wav missing bit depth",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":        ".wav",
 				"wav-bit-depth": "",
 			})),
 	)
 	t.Run("fail mp3 invalid channel mode",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":           ".mp3",
 				"mp3-channel-mode": "invalid-channel-mode",
@@ -151,7 +327,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("fail mp3 invalid bit rate mode",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -160,7 +336,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("fail mp3 invalid vbr quality",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -170,7 +346,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("fail mp3 invalid bit rate",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -180,7 +356,7 @@ func TestFormParsing(t *testing.T) {
 		),
 	)
 	t.Run("fail mp3 invalid quality flag",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -190,8 +366,37 @@ func TestFormParsing(t *testing.T) {
 			}),
 		),
 	)
+	server := httptest.NewServer(http.FileServer(http.Dir("../_testdata")))
+	defer server.Close()
+	t.Run("ok source url",
+		testOk(userinput.NewEncodeForm(noLimits, "", &userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}}, nil, false),
+			newRequest("/", "", map[string]string{
+				userinput.SourceURLKey: server.URL + "/sample.wav",
+				"format":               ".wav",
+				"wav-bit-depth":        "16",
+			}),
+		),
+	)
+	t.Run("fail source url disabled",
+		testFail(userinput.NewEncodeForm(noLimits, "", nil, nil, false),
+			newRequest("/", "", map[string]string{
+				userinput.SourceURLKey: server.URL + "/sample.wav",
+				"format":               ".wav",
+				"wav-bit-depth":        "16",
+			}),
+		),
+	)
+	t.Run("fail source url size exceeded",
+		testFail(userinput.NewEncodeForm(userinput.Limits{fileformat.WAV(): 10}, "", &userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}}, nil, false),
+			newRequest("/", "", map[string]string{
+				userinput.SourceURLKey: server.URL + "/sample.wav",
+				"format":               ".wav",
+				"wav-bit-depth":        "16",
+			}),
+		),
+	)
 	t.Run("fail mp3 invalid quality value",
-		testFail(userinput.NewEncodeForm(userinput.Limits{}),
+		testFail(userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false),
 			newWavRequest(map[string]string{
 				"format":            ".mp3",
 				"mp3-channel-mode":  "1",
@@ -204,9 +409,234 @@ func TestFormParsing(t *testing.T) {
 	)
 }
 
+func TestFormProcessors(t *testing.T) {
+	newRequest := func(spec string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+		file, _ := os.Open("../_testdata/sample.wav")
+		defer file.Close()
+		io.Copy(part, file)
+		writer.WriteField("format", ".wav")
+		writer.WriteField("wav-bit-depth", "16")
+		writer.WriteField(userinput.ProcessorKey, spec)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/test/.wav", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	_, err := f.Parse(newRequest("experimental.gain:db=-3"))
+	assertNotNil(t, "disabled experimental error", err)
+
+	f.Experimental = true
+	data, err := f.Parse(newRequest("experimental.gain:db=-3"))
+	assertEqual(t, "error", err, nil)
+	defer data.Close()
+	assertEqual(t, "processors", len(data.Processors), 1)
+	assertEqual(t, "warnings", data.Warnings, []string{"processor gain is experimental"})
+
+	_, err = f.Parse(newRequest("unknown"))
+	assertNotNil(t, "unknown processor error", err)
+}
+
+func TestFormJSONProcessors(t *testing.T) {
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	f.Experimental = true
+	processors, warnings, err := f.ParseProcessors(url.Values{
+		userinput.EQKey:         {"peak:3000:-4:1.0"},
+		userinput.ProcessorKey:  {"gain:db=-3"},
+		userinput.ProcessorsKey: {`[{"name": "gain", "params": {"db": -3}}, {"name": "limiter", "params": {"ceiling": "-1"}}]`},
+	})
+	assertEqual(t, "error", err, nil)
+	assertEqual(t, "processors", len(processors), 4)
+	assertEqual(t, "warnings", len(warnings), 3)
+
+	for _, v := range []string{
+		`{"name": "gain"}`,
+		`[{"name": "unknown"}]`,
+		`[{"name": "gain", "params": {"db": [1]}}]`,
+		`[{"name": "gain", "options": {"db": -3}}]`,
+	} {
+		_, _, err = f.ParseProcessors(url.Values{userinput.ProcessorsKey: {v}})
+		assertNotNil(t, v, err)
+	}
+}
+
+func TestFormOutputs(t *testing.T) {
+	newRequest := func(link string, formats ...string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+		file, _ := os.Open("../_testdata/sample.wav")
+		defer file.Close()
+		io.Copy(part, file)
+		for _, format := range formats {
+			writer.WriteField(userinput.FormatKey, format)
+		}
+		writer.WriteField("wav-bit-depth", "16")
+		writer.WriteField("mp3-channel-mode", "1")
+		writer.WriteField("mp3-bit-rate-mode", "VBR")
+		writer.WriteField("mp3-vbr-quality", "2")
+		writer.WriteField(userinput.LinkKey, link)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/test/.wav", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	data, err := f.Parse(newRequest("", ".mp3", ".wav"))
+	assertEqual(t, "error", err, nil)
+	defer data.Close()
+	assertEqual(t, "output", data.Output.Format, fileformat.MP3())
+	assertEqual(t, "also", len(data.Also), 1)
+	assertEqual(t, "also format", data.Also[0].Format, fileformat.WAV())
+
+	_, err = f.Parse(newRequest("", ".wav", ".wav"))
+	assertNotNil(t, "duplicate format error", err)
+	_, err = f.Parse(newRequest("true", ".mp3", ".wav"))
+	assertNotNil(t, "multiple outputs link error", err)
+	_, err = f.Parse(newRequest(""))
+	assertNotNil(t, "missing format error", err)
+}
+
+func TestFormRegisteredSink(t *testing.T) {
+	var params codec.Params
+	codecs := codec.NewRegistry()
+	codecs.RegisterSink(codec.Sink{
+		Name:      "formtest",
+		Extension: ".formtest",
+		Options: []codec.Option{
+			{Name: "level", Description: "level", Default: "1", Values: []string{"1", "2"}},
+		},
+		New: func(p codec.Params) (codec.Encoder, error) {
+			params = p
+			return codec.Encoder{
+				Sink: func(io.WriteSeeker) pipe.SinkAllocatorFunc { return nil },
+			}, nil
+		},
+	})
+	newRequest := func(level string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+		file, _ := os.Open("../_testdata/sample.wav")
+		defer file.Close()
+		io.Copy(part, file)
+		writer.WriteField(userinput.FormatKey, ".formtest")
+		writer.WriteField("formtest-level", level)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/test/.wav", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	assertEqual(t, "set codecs", f.SetCodecs(codecs), nil)
+	assertEqual(t, "options rendered", bytes.Contains(f.Bytes(i18n.Default), []byte(`name="formtest-level"`)), true)
+	data, err := f.Parse(newRequest("2"))
+	assertEqual(t, "error", err, nil)
+	defer data.Close()
+	assertEqual(t, "extension", data.Output.DefaultExtension(), ".formtest")
+	assertEqual(t, "level", params["level"], "2")
+
+	_, err = f.Parse(newRequest("3"))
+	assertNotNil(t, "invalid option error", err)
+}
+
+func TestFormFLACOptions(t *testing.T) {
+	flac, err := codec.LookupSink("flac")
+	assertEqual(t, "flac sink", err, nil)
+	codecs := codec.NewRegistry()
+	codecs.RegisterSink(flac)
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	assertEqual(t, "set codecs", f.SetCodecs(codecs), nil)
+	for _, s := range []string{
+		`<option id=".flac" value=".flac">`,
+		`<div id="flac-options" class="output-options">`,
+		`<select name="flac-compression" class="option">`,
+		`<option value="5" selected>5</option>`,
+		`<option value="8">8</option>`,
+		`<select name="flac-bitdepth" class="option">`,
+		`<option value="16">16</option>`,
+	} {
+		assertEqual(t, s, bytes.Contains(f.Bytes(i18n.Default), []byte(s)), true)
+	}
+
+	newRequest := func(compression, bitDepth string) *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+		file, _ := os.Open("../_testdata/sample.wav")
+		defer file.Close()
+		io.Copy(part, file)
+		writer.WriteField(userinput.FormatKey, ".flac")
+		writer.WriteField("flac-compression", compression)
+		writer.WriteField("flac-bitdepth", bitDepth)
+		writer.Close()
+		req := httptest.NewRequest("POST", "/test/.wav", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+	data, err := f.Parse(newRequest("8", ""))
+	assertEqual(t, "error", err, nil)
+	defer data.Close()
+	assertEqual(t, "format", data.Output.Format, fileformat.FLAC())
+	assertEqual(t, "default bit depth", data.Output.BitDepth, signal.BitDepth24)
+	data, err = f.Parse(newRequest("0", "16"))
+	assertEqual(t, "error", err, nil)
+	defer data.Close()
+	assertEqual(t, "bit depth", data.Output.BitDepth, signal.BitDepth16)
+
+	_, err = f.Parse(newRequest("9", ""))
+	assertNotNil(t, "invalid compression error", err)
+	_, err = f.Parse(newRequest("", "8"))
+	assertNotNil(t, "invalid bit depth error", err)
+}
+
+func TestFormMemoryLimit(t *testing.T) {
+	newRequest := func() *http.Request {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile(userinput.FormFileKey, "sample.wav")
+		file, _ := os.Open("../_testdata/sample.wav")
+		defer file.Close()
+		io.Copy(part, file)
+		writer.WriteField("format", ".wav")
+		writer.WriteField("wav-bit-depth", "16")
+		writer.Close()
+		req := httptest.NewRequest("POST", "/test/.wav", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+	dir, err := ioutil.TempDir("", "form-memory-limit")
+	assertEqual(t, "temp dir error", err, nil)
+	defer os.RemoveAll(dir)
+	spooled := func() int {
+		files, _ := ioutil.ReadDir(dir)
+		return len(files)
+	}
+
+	f := userinput.NewEncodeForm(userinput.Limits{}, dir, nil, nil, false)
+	f.MemoryLimit = 2 << 20
+	data, err := f.Parse(newRequest())
+	assertEqual(t, "error", err, nil)
+	assertEqual(t, "spooled files", spooled(), 0)
+	data.Close()
+
+	f.MemoryLimit = 10
+	data, err = f.Parse(newRequest())
+	assertEqual(t, "error", err, nil)
+	assertEqual(t, "spooled files", spooled(), 1)
+	data.Close()
+	assertEqual(t, "spooled files after close", spooled(), 0)
+}
+
 func TestForm(t *testing.T) {
-	f := userinput.NewEncodeForm(userinput.Limits{})
-	_, err := html.Parse(bytes.NewReader(f.Bytes()))
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	_, err := html.Parse(bytes.NewReader(f.Bytes(i18n.Default)))
 	assertEqual(t, "html error", err, nil)
 }
 
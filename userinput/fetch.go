@@ -0,0 +1,210 @@
+package userinput
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/container"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/publicnet"
+	"pipelined.dev/phono/storage"
+)
+
+// SourceURLKey is the id of the source url input in the HTML form.
+const SourceURLKey = "source-url"
+
+var (
+	errSourceURLDisabled = i18n.Errorf("error.source_url_disabled")
+	errSourceURLTooLarge = i18n.Errorf("error.source_url_too_large")
+)
+
+type (
+	// Fetcher downloads remote input files. Only URLs with allowed
+	// schemes are fetched. URLs with schemes of Storage backends are
+	// fetched with them, other ones with HTTP client. The client
+	// connects only to public addresses if it's not provided. Redirects
+	// are followed only to URLs with allowed schemes. Zero MaxSize and
+	// Timeout mean no limits.
+	Fetcher struct {
+		Client  *http.Client
+		Storage storage.Storage
+		Schemes []string
+		MaxSize int64
+		Timeout time.Duration
+		TempDir string
+	}
+)
+
+// Fetch downloads the content of provided URL into a temporary file and
+// detects its format. The file is removed when closed.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (multipart.File, *fileformat.Format, error) {
+	return f.fetch(ctx, rawURL)
+}
+
+func (f *Fetcher) fetch(ctx context.Context, rawURL string) (spooledFile, *fileformat.Format, error) {
+	u, err := f.parseURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if f.Timeout > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, f.Timeout)
+		defer cancelFn()
+	}
+	content, size, contentType, err := f.get(ctx, u)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch source url: %w", err)
+	}
+	defer content.Close()
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return nil, nil, errSourceURLTooLarge
+	}
+
+	var body io.Reader = content
+	if f.MaxSize > 0 {
+		// read one byte more to detect exceeded limit
+		body = io.LimitReader(content, f.MaxSize+1)
+	}
+	file, err := spool(body, f.TempDir, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.MaxSize > 0 && file.Size() > f.MaxSize {
+		file.Close()
+		return nil, nil, errSourceURLTooLarge
+	}
+
+	format, err := sniffFormat(file, u.Path, contentType)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if format == nil {
+		audio, format, err := extractAudio(file, f.TempDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		return audio, format, nil
+	}
+	return file, format, nil
+}
+
+// get returns the content of the url, its size and content type. Size
+// is -1 and content type is empty if they are unknown.
+func (f *Fetcher) get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, string, error) {
+	if f.Storage.Remote(u.String()) {
+		content, size, err := f.Storage.Get(ctx, u.String())
+		return content, size, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	client := publicnet.Client
+	if f.Client != nil {
+		client = f.Client
+	}
+	// redirects are checked with the same rules as the source url
+	c := *client
+	c.CheckRedirect = publicnet.CheckRedirect(func(u *url.URL) error {
+		_, err := f.parseURL(u.String())
+		return err
+	})
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, "", errors.New(resp.Status)
+	}
+	return resp.Body, resp.ContentLength, resp.Header.Get("Content-Type"), nil
+}
+
+// parseURL checks if provided url is valid and its scheme is allowed.
+func (f *Fetcher) parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source url: %w", err)
+	}
+	for _, scheme := range f.Schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("source url scheme %q is not allowed", u.Scheme)
+}
+
+// sniffFormat detects the format of the file by its header. If header
+// is not recognized, the extension of url path and content type are
+// used. Nil format is returned for media containers. File offset is
+// reset to the beginning.
+func sniffFormat(rs io.ReadSeeker, path, contentType string) (*fileformat.Format, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(rs, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if format := formatByHeader(header[:n]); format != nil {
+		return format, nil
+	}
+	if container.Match(header[:n]) {
+		return nil, nil
+	}
+	if format := fileformat.FormatByPath(path); format != nil {
+		return format, nil
+	}
+	if format := formatByContentType(contentType); format != nil {
+		return format, nil
+	}
+	return nil, errInputFormat
+}
+
+func formatByHeader(header []byte) *fileformat.Format {
+	switch {
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WAVE")):
+		return fileformat.WAV()
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return fileformat.FLAC()
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return fileformat.MP3()
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		// mpeg frame sync
+		return fileformat.MP3()
+	}
+	return nil
+}
+
+func formatByContentType(contentType string) *fileformat.Format {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch mediaType {
+	case "audio/wav", "audio/wave", "audio/x-wav", "audio/vnd.wave":
+		return fileformat.WAV()
+	case "audio/mpeg", "audio/mp3":
+		return fileformat.MP3()
+	case "audio/flac", "audio/x-flac":
+		return fileformat.FLAC()
+	}
+	return nil
+}
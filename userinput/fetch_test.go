@@ -0,0 +1,104 @@
+package userinput_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"pipelined.dev/audio/fileformat"
+
+	"pipelined.dev/phono/storage"
+	"pipelined.dev/phono/userinput"
+)
+
+// testdataBackend serves files of testdata folder for any bucket.
+type testdataBackend struct{}
+
+func (testdataBackend) Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	return storage.Local{}.Get(ctx, &url.URL{Path: "../_testdata" + u.Path})
+}
+
+func (testdataBackend) Put(context.Context, *url.URL, io.Reader) error {
+	return errors.New("read-only")
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("../_testdata")))
+	defer server.Close()
+	// https server redirects to http one
+	tlsServer := httptest.NewTLSServer(http.RedirectHandler(server.URL+"/sample.wav", http.StatusFound))
+	defer tlsServer.Close()
+
+	testOk := func(f *userinput.Fetcher, url string, expected *fileformat.Format) func(*testing.T) {
+		return func(t *testing.T) {
+			file, format, err := f.Fetch(context.Background(), url)
+			assertEqual(t, "error", err, nil)
+			assertEqual(t, "format", format, expected)
+			assertEqual(t, "close error", file.Close(), nil)
+		}
+	}
+	testFail := func(f *userinput.Fetcher, url string) func(*testing.T) {
+		return func(t *testing.T) {
+			_, _, err := f.Fetch(context.Background(), url)
+			assertNotNil(t, "error", err)
+		}
+	}
+
+	t.Run("ok wav",
+		testOk(&userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}},
+			server.URL+"/sample.wav",
+			fileformat.WAV(),
+		),
+	)
+	t.Run("fail scheme",
+		testFail(&userinput.Fetcher{Client: server.Client(), Schemes: []string{"https"}},
+			server.URL+"/sample.wav",
+		),
+	)
+	t.Run("fail size exceeded",
+		testFail(&userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}, MaxSize: 10},
+			server.URL+"/sample.wav",
+		),
+	)
+	t.Run("fail not found",
+		testFail(&userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}},
+			server.URL+"/non-existing.wav",
+		),
+	)
+	t.Run("fail not media",
+		testFail(&userinput.Fetcher{Client: server.Client(), Schemes: []string{"http"}},
+			server.URL+"/not-media",
+		),
+	)
+	t.Run("fail private address",
+		testFail(&userinput.Fetcher{Schemes: []string{"http"}},
+			server.URL+"/sample.wav",
+		),
+	)
+	t.Run("fail redirect scheme",
+		testFail(&userinput.Fetcher{Client: tlsServer.Client(), Schemes: []string{"https"}},
+			tlsServer.URL,
+		),
+	)
+	s3 := storage.Storage{"s3": testdataBackend{}}
+	t.Run("ok storage",
+		testOk(&userinput.Fetcher{Schemes: []string{"s3"}, Storage: s3},
+			"s3://bucket/sample.flac",
+			fileformat.FLAC(),
+		),
+	)
+	t.Run("fail storage scheme",
+		testFail(&userinput.Fetcher{Schemes: []string{"https"}, Storage: s3},
+			"s3://bucket/sample.flac",
+		),
+	)
+	t.Run("fail storage not found",
+		testFail(&userinput.Fetcher{Schemes: []string{"s3"}, Storage: s3},
+			"s3://bucket/non-existing.wav",
+		),
+	)
+}
@@ -1,14 +1,13 @@
 package userinput
 
 import (
-	"fmt"
 	"io"
-	"strings"
 
 	"pipelined.dev/audio/mp3"
-	"pipelined.dev/audio/wav"
 	"pipelined.dev/pipe"
 	"pipelined.dev/signal"
+
+	"pipelined.dev/phono/codec"
 )
 
 type (
@@ -31,6 +30,10 @@ type (
 
 	// Sink is used to inject WriteSeeker into Sink.
 	Sink func(io.WriteSeeker) pipe.SinkAllocatorFunc
+
+	// StreamSink is used to inject Writer into Sink. It's provided by
+	// sinks that don't need to seek, so output can be streamed.
+	StreamSink func(io.Writer) pipe.SinkAllocatorFunc
 )
 
 var (
@@ -51,79 +54,43 @@ var (
 			mp3.Stereo:      {},
 			mp3.Mono:        {},
 		},
-		VBR:        "VBR",
-		ABR:        "ABR",
-		CBR:        "CBR",
-		MinBitRate: 8,
-		MaxBitRate: 320,
-		MinQuality: 0,
-		MaxQuality: 9,
-		MinVBR:     0,
-		MaxVBR:     9,
+		VBR:        codec.MP3VBR,
+		ABR:        codec.MP3ABR,
+		CBR:        codec.MP3CBR,
+		MinBitRate: codec.MP3MinBitRate,
+		MaxBitRate: codec.MP3MaxBitRate,
+		MinQuality: codec.MP3MinQuality,
+		MaxQuality: codec.MP3MaxQuality,
+		MinVBR:     codec.MP3MinVBR,
+		MaxVBR:     codec.MP3MaxVBR,
 	}
 )
 
 // WAVSink validates all parameters required to build wav sink. If valid, build closure is returned.
 // Closure allows to postpone io opertaions and do them only after all sink parameters are validated.
 func (f wavSink) Sink(bitDepth int) (Sink, error) {
-	bd := signal.BitDepth(bitDepth)
-	if _, ok := f.BitDepths[bd]; !ok {
-		return nil, fmt.Errorf("Bit depth %v is not supported", bitDepth)
-	}
-
-	return func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
-		return wav.Sink(ws, bd)
-	}, nil
+	return codec.WAV(bitDepth)
 }
 
 // Sink validates all parameters required to build mp3 sink. If valid, Sink closure is returned.
 // Closure allows to postpone io opertaions and do them only after all sink parameters are validated.
 func (f mp3Sink) Sink(bitRateMode string, bitRate, channelMode int, useQuality bool, quality int) (Sink, error) {
-	cm := mp3.ChannelMode(channelMode)
-	if _, ok := f.ChannelModes[cm]; !ok {
-		return nil, fmt.Errorf("Channel mode %v is not supported", cm)
-	}
-
-	var brm mp3.BitRateMode
-	switch strings.ToUpper(bitRateMode) {
-	case f.VBR:
-		if bitRate < f.MinVBR || bitRate > f.MaxVBR {
-			return nil, fmt.Errorf("VBR quality %v is not supported", bitRate)
-		}
-		brm = mp3.VBR(bitRate)
-	case f.CBR:
-		if err := f.bitRate(bitRate); err != nil {
-			return nil, err
-		}
-		brm = mp3.CBR(bitRate)
-	case f.ABR:
-		if err := f.bitRate(bitRate); err != nil {
-			return nil, err
-		}
-		brm = mp3.ABR(bitRate)
-	default:
-		return nil, fmt.Errorf("Bit rate mode %v is not supported", bitRateMode)
-	}
-
-	if useQuality {
-		if quality < f.MinQuality || quality > f.MaxQuality {
-			return nil, fmt.Errorf("MP3 quality %v is not supported", quality)
-		}
+	stream, err := f.StreamSink(bitRateMode, bitRate, channelMode, useQuality, quality)
+	if err != nil {
+		return nil, err
 	}
+	return stream.Sink(), nil
+}
 
-	return func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
-		eq := mp3.DefaultEncodingQuality
-		if useQuality {
-			eq = mp3.EncodingQuality(quality)
-		}
-		return mp3.Sink(ws, brm, cm, eq)
-	}, nil
+// StreamSink validates all parameters required to build mp3 sink. Since
+// mp3 sink doesn't need to seek, StreamSink closure is returned.
+func (f mp3Sink) StreamSink(bitRateMode string, bitRate, channelMode int, useQuality bool, quality int) (StreamSink, error) {
+	return codec.MP3(bitRateMode, bitRate, channelMode, useQuality, quality)
 }
 
-// BitRate checks if provided bit rate is supported.
-func (f mp3Sink) bitRate(v int) error {
-	if v > f.MaxBitRate || v < f.MinBitRate {
-		return fmt.Errorf("Bit rate %v is not supported. Provide value between %d and %d", v, f.MinBitRate, f.MaxBitRate)
+// Sink converts StreamSink to Sink.
+func (s StreamSink) Sink() Sink {
+	return func(ws io.WriteSeeker) pipe.SinkAllocatorFunc {
+		return s(ws)
 	}
-	return nil
 }
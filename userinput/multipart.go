@@ -0,0 +1,180 @@
+package userinput
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/i18n"
+)
+
+// maxValueSize is the max size of a single non-file form value.
+const maxValueSize = 1 << 16
+
+var (
+	errValueTooLarge = i18n.Errorf("error.value_too_large")
+	errMultipleFiles = i18n.Errorf("error.multiple_files")
+)
+
+type (
+	// multipartForm is a form parsed from the multipart stream. File
+	// part is spooled to the temp file, values are kept in memory.
+	multipartForm struct {
+		Value url.Values
+		File  spooledFile
+	}
+
+	// spooledFile is the user-provided file that is kept either in
+	// memory or in the temp file.
+	spooledFile interface {
+		multipart.File
+		Size() int64
+	}
+
+	// memFile is a spooled file that is kept in memory.
+	memFile struct {
+		*bytes.Reader
+	}
+
+	// tempFile is a file that is removed when closed.
+	tempFile struct {
+		*os.File
+		size int64
+	}
+)
+
+// parseMultipart reads multipart stream of the request part by part. The
+// form file is written directly into the temp file without buffering
+// whole request in memory, unless it fits into memLimit bytes.
+func parseMultipart(r *http.Request, tempDir string, memLimit int64) (*multipartForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := multipartForm{
+		Value: url.Values{},
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return &form, nil
+		}
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		if part.FileName() == "" {
+			value, err := readValue(part)
+			part.Close()
+			if err != nil {
+				form.Close()
+				return nil, err
+			}
+			form.Value.Add(name, value)
+			continue
+		}
+		if name != FormFileKey {
+			// skip unknown files
+			part.Close()
+			continue
+		}
+		if form.File != nil {
+			part.Close()
+			form.Close()
+			return nil, errMultipleFiles
+		}
+		form.File, err = spool(part, tempDir, memLimit)
+		part.Close()
+		if err != nil {
+			form.Close()
+			return nil, err
+		}
+	}
+}
+
+// readValue reads non-file form value.
+func readValue(r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxValueSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxValueSize {
+		return "", errValueTooLarge
+	}
+	return string(b), nil
+}
+
+// spool returns the content of reader in memory if it doesn't exceed
+// memLimit bytes. Otherwise it's written into the temp file. Offset of
+// returned file is reset to the beginning.
+func spool(r io.Reader, tempDir string, memLimit int64) (spooledFile, error) {
+	var buf bytes.Buffer
+	if memLimit > 0 {
+		// read one byte more to detect exceeded limit
+		n, err := io.Copy(&buf, io.LimitReader(r, memLimit+1))
+		if err != nil {
+			return nil, err
+		}
+		if n <= memLimit {
+			return memFile{Reader: bytes.NewReader(buf.Bytes())}, nil
+		}
+	}
+	file, err := ioutil.TempFile(tempDir, "")
+	if err != nil {
+		return nil, err
+	}
+	tf := tempFile{File: file}
+	cw := encode.CountingWriter{Writer: file}
+	if _, err = io.Copy(&cw, io.MultiReader(&buf, r)); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	tf.size = cw.Written
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		tf.Close()
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// Close removes the spooled file if it exists. The file is closed once,
+// so the form can be closed again after the input is taken.
+func (f *multipartForm) Close() error {
+	if f.File == nil {
+		return nil
+	}
+	err := f.File.Close()
+	f.File = nil
+	return err
+}
+
+// Close the file and remove it.
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// Size returns the number of bytes written into the file.
+func (f *tempFile) Size() int64 {
+	return f.size
+}
+
+// Close is no-op, memory is released by garbage collector.
+func (memFile) Close() error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package userinput
+
+import (
+	"net/http"
+	"net/url"
+
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/render"
+)
+
+// WidthKey and HeightKey are the names of image size in pixels in the
+// render forms. DefaultWidth and DefaultHeight are used if they are empty.
+const (
+	WidthKey  = "width"
+	HeightKey = "height"
+)
+
+// ImageFormatKey is the name of image format in the render forms. PNG is
+// used if it's empty.
+const ImageFormatKey = "image-format"
+
+// FFTSizeKey, WindowKey and ColorMapKey are the names of spectrogram
+// options in the form. Defaults of render package are used if they are
+// empty.
+const (
+	FFTSizeKey  = "fft-size"
+	WindowKey   = "window"
+	ColorMapKey = "colormap"
+)
+
+var errSpectrogramFormat = i18n.Errorf("error.spectrogram_format")
+
+// Default image size of the render forms.
+const (
+	DefaultWidth  = 1200
+	DefaultHeight = 200
+)
+
+// WaveformForm parses requests to render the waveform image of the
+// input. Input is provided the same way as with the encode form and the
+// same limits are applied.
+type WaveformForm struct {
+	form EncodeForm
+}
+
+// Waveform returns the waveform form that shares input options with the
+// encode form.
+func (f EncodeForm) Waveform() WaveformForm {
+	return WaveformForm{form: f}
+}
+
+// Parse returns the waveform renderer with the input provided by the
+// user.
+func (f WaveformForm) Parse(r *http.Request) (render.FormData, error) {
+	return f.form.parseRender(r, func(data url.Values, width, height int, image render.Format) (render.Renderer, error) {
+		return render.NewWaveform(width, height, render.DefaultWaveformColor)
+	})
+}
+
+// SpectrogramForm parses requests to render the spectrogram image of
+// the input. Input is provided the same way as with the encode form and
+// the same limits are applied. Only PNG format is supported.
+type SpectrogramForm struct {
+	form EncodeForm
+}
+
+// Spectrogram returns the spectrogram form that shares input options
+// with the encode form.
+func (f EncodeForm) Spectrogram() SpectrogramForm {
+	return SpectrogramForm{form: f}
+}
+
+// Parse returns the spectrogram renderer with the input provided by the
+// user.
+func (f SpectrogramForm) Parse(r *http.Request) (render.FormData, error) {
+	return f.form.parseRender(r, func(data url.Values, width, height int, image render.Format) (render.Renderer, error) {
+		if image != render.PNG {
+			return nil, errSpectrogramFormat
+		}
+		fftSize, err := parseIntDefault(data, FFTSizeKey, "fft size", render.DefaultFFTSize)
+		if err != nil {
+			return nil, err
+		}
+		window, err := render.ParseWindow(data.Get(WindowKey))
+		if err != nil {
+			return nil, err
+		}
+		colors, err := render.ParseColorMap(data.Get(ColorMapKey))
+		if err != nil {
+			return nil, err
+		}
+		return render.NewSpectrogram(width, height, fftSize, window, colors)
+	})
+}
+
+// parseRender parses image size and format of the render form and its
+// input. Renderer is created with provided function.
+func (f EncodeForm) parseRender(r *http.Request, renderer func(data url.Values, width, height int, image render.Format) (render.Renderer, error)) (render.FormData, error) {
+	form, inputFormat, extract, err := f.parseRequest(r)
+	if err != nil {
+		return render.FormData{}, err
+	}
+	width, err := parseIntDefault(form.Value, WidthKey, "width", DefaultWidth)
+	if err != nil {
+		form.Close()
+		return render.FormData{}, err
+	}
+	height, err := parseIntDefault(form.Value, HeightKey, "height", DefaultHeight)
+	if err != nil {
+		form.Close()
+		return render.FormData{}, err
+	}
+	image, err := render.ParseFormat(form.Value.Get(ImageFormatKey))
+	if err != nil {
+		form.Close()
+		return render.FormData{}, err
+	}
+	rr, err := renderer(form.Value, width, height, image)
+	if err != nil {
+		form.Close()
+		return render.FormData{}, err
+	}
+	input, err := f.parseInput(r.Context(), form, inputFormat, extract)
+	if err != nil {
+		form.Close()
+		return render.FormData{}, err
+	}
+	return render.FormData{
+		Input:    input,
+		Renderer: rr,
+		Image:    image,
+	}, nil
+}
+
+// parseIntDefault parses int value of key provided in the html form.
+// Returns def if value is not provided.
+func parseIntDefault(data url.Values, key, name string, def int) (int, error) {
+	if data.Get(key) == "" {
+		return def, nil
+	}
+	return parseIntValue(data, key, name)
+}
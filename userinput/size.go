@@ -0,0 +1,50 @@
+package userinput
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are binary multiples of bytes.
+var sizeUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"T", 1 << 40},
+}
+
+// ParseSize parses size in bytes with optional binary unit suffix K, M,
+// G or T, e.g. 500M or 1.5G. Unit can be followed by B or iB, so 500MB
+// and 500MiB are accepted as well.
+func ParseSize(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	num = strings.TrimSuffix(strings.TrimSuffix(num, "B"), "I")
+	multiplier := float64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(num, unit.suffix) {
+			num, multiplier = strings.TrimSuffix(num, unit.suffix), unit.bytes
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) || v*multiplier > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * multiplier), nil
+}
+
+// FormatSize formats size in bytes with the largest binary unit that
+// keeps the value whole, e.g. 500M.
+func FormatSize(size int64) string {
+	for i := len(sizeUnits) - 1; i >= 0; i-- {
+		if unit := int64(sizeUnits[i].bytes); size != 0 && size%unit == 0 {
+			return strconv.FormatInt(size/unit, 10) + sizeUnits[i].suffix
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
@@ -0,0 +1,39 @@
+package userinput_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/userinput"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"0":      0,
+		"1024":   1024,
+		"500M":   500 << 20,
+		"500mb":  500 << 20,
+		"500MiB": 500 << 20,
+		"2G":     2 << 30,
+		"1.5K":   1536,
+		" 1T ":   1 << 40,
+	} {
+		size, err := userinput.ParseSize(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, expected, size, s)
+	}
+	for _, s := range []string{"", "M", "-1M", "1X", "NaN", "1e30T"} {
+		_, err := userinput.ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "0", userinput.FormatSize(0))
+	assert.Equal(t, "1000", userinput.FormatSize(1000))
+	assert.Equal(t, "500M", userinput.FormatSize(500<<20))
+	assert.Equal(t, "1536", userinput.FormatSize(1536))
+	assert.Equal(t, "1536K", userinput.FormatSize(1536<<10))
+	assert.Equal(t, "2G", userinput.FormatSize(2<<30))
+}
@@ -0,0 +1,128 @@
+package userinput
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"text/template"
+
+	"pipelined.dev/phono/i18n"
+)
+
+// StaticPath is the path of static assets of the templates, e.g. styles
+// and scripts of the encode form.
+const StaticPath = "/static/"
+
+// embedded are templates compiled into the binary.
+//
+//go:embed templates
+var embedded embed.FS
+
+// defaultTemplates are used by forms unless other templates are set.
+var defaultTemplates = mustLoadTemplates("")
+
+type (
+	// Templates are HTML templates of pages with their static assets.
+	// encode.html is the template of the encode form, assets are in the
+	// static folder. Templates are embedded, but files of the directory
+	// override embedded files of the same names, so the look of the
+	// pages can be changed without recompiling. Pages are rendered for
+	// every language: t function translates the message of the key, lang
+	// returns the language and messages returns script messages as JSON
+	// object.
+	Templates struct {
+		files  fs.FS
+		encode *template.Template
+	}
+
+	// overlayFS opens files of the top file system if they exist there
+	// and files of the bottom one otherwise.
+	overlayFS struct {
+		top    fs.FS
+		bottom fs.FS
+	}
+)
+
+// LoadTemplates returns templates that are overridden with files of the
+// directory. Embedded templates are returned if dir is empty.
+func LoadTemplates(dir string) (*Templates, error) {
+	files, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid templates directory: %w", err)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("templates path %s is not a directory", dir)
+		}
+		files = overlayFS{top: os.DirFS(dir), bottom: files}
+	}
+	b, err := fs.ReadFile(files, "encode.html")
+	if err != nil {
+		return nil, err
+	}
+	encode, err := template.New("encode").Funcs(translations(i18n.Default)).Parse(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse encode template: %w", err)
+	}
+	return &Templates{files: files, encode: encode}, nil
+}
+
+// translations returns template functions of the language.
+func translations(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(key string, args ...interface{}) string {
+			return i18n.T(lang, key, args...)
+		},
+		"lang": func() string {
+			return lang
+		},
+		"messages": func() (string, error) {
+			// json escapes html characters, so it's safe inside script
+			b, err := json.Marshal(i18n.Messages(lang, "script."))
+			return string(b), err
+		},
+	}
+}
+
+// renderEncode renders the encode form in the language.
+func (t *Templates) renderEncode(w io.Writer, lang string, data interface{}) error {
+	encode, err := t.encode.Clone()
+	if err != nil {
+		return err
+	}
+	return encode.Funcs(translations(lang)).Execute(w, data)
+}
+
+func mustLoadTemplates(dir string) *Templates {
+	t, err := LoadTemplates(dir)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Static returns the handler of static assets served under StaticPath.
+func (t *Templates) Static() http.Handler {
+	static, err := fs.Sub(t.files, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix(StaticPath, http.FileServer(http.FS(static)))
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.bottom.Open(name)
+	}
+	return f, err
+}
@@ -0,0 +1,524 @@
+const fileId = 'form-file';
+// tagFields are limited to max tag length of capabilities
+const tagFields = ['title', 'artist', 'album'];
+// capabilities of the server, inputs are validated with them before
+// the upload
+var capabilities = fetch('/capabilities').then(function(response) {
+    if (!response.ok) {
+        throw new Error(response.statusText);
+    }
+    return response.json();
+});
+// files are converted one by one in the order they were added
+var queue = [];
+var encoding = false;
+function getFile() {
+    return document.getElementById(fileId);
+}
+function getSourceURL() {
+    return document.getElementById('source-url');
+}
+function getFileExtension(fileName) {
+    var i = fileName.lastIndexOf('.');
+    return i < 0 ? '' : fileName.slice(i).toLowerCase();
+}
+function humanFileSize(size) {
+    var i = size == 0 ? 0 : Math.floor(Math.log(size) / Math.log(1024));
+    return (size / Math.pow(1024, i)).toFixed(2) * 1 + ' ' + ['B', 'kB', 'MB', 'GB', 'TB'][i];
+};
+// message returns translated message of the page with %s replaced by
+// arguments
+function message(key) {
+    var text = messages[key] || key;
+    for (var i = 1; i < arguments.length; i++) {
+        text = text.replace('%s', arguments[i]);
+    }
+    return text;
+}
+function displayClass(className, mode) {
+    var elements = document.getElementsByClassName(className);
+    for (var i = 0, ii = elements.length; i < ii; i++) {
+        elements[i].style.display = mode;
+    };
+}
+function displayId(id, mode){
+    document.getElementById(id).style.display = mode;
+}
+document.addEventListener('DOMContentLoaded', function(event) {
+    document.getElementById('encode').reset();
+    capabilities.then(applyCapabilities);
+    // base form handlers
+    document.getElementById('form-file').addEventListener('change', onInputFileChange);
+    document.getElementById('output-format').addEventListener('change', onOutputFormatChange);
+    document.getElementById('submit-button').addEventListener('click', onSubmitClick);
+    var sourceURL = getSourceURL();
+    if (sourceURL) {
+        sourceURL.addEventListener('change', onSourceURLChange);
+    }
+    // drag and drop handlers
+    var dropZone = document.getElementById('drop-zone');
+    dropZone.addEventListener('dragover', function(e) {
+        e.preventDefault();
+        dropZone.classList.add('drag-over');
+    });
+    dropZone.addEventListener('dragleave', function() {
+        dropZone.classList.remove('drag-over');
+    });
+    dropZone.addEventListener('drop', function(e) {
+        e.preventDefault();
+        dropZone.classList.remove('drag-over');
+        addFiles(e.dataTransfer.files);
+    });
+    // mp3 handlers
+    document.getElementById('mp3-bit-rate-mode').addEventListener('change', onMp3BitRateModeChange);
+    document.getElementById('mp3-use-quality').addEventListener('click', onMp3UseQUalityChange);
+    // preset handlers
+    document.getElementById('preset').addEventListener('change', onPresetChange);
+    document.getElementById('save-preset').addEventListener('click', onSavePresetClick);
+    document.getElementById('delete-preset').addEventListener('click', onDeletePresetClick);
+    loadPresets();
+});
+// applyCapabilities sets accepted inputs and ranges of fields, so the
+// browser validates them
+function applyCapabilities(c) {
+    getFile().accept = c.inputs.join(', ');
+    var fields = c.fields;
+    c.outputs.forEach(function(output) {
+        fields = fields.concat(output.fields);
+    });
+    fields.forEach(function(field) {
+        var inputs = document.getElementsByName(field.name);
+        for (var i = 0; i < inputs.length; i++) {
+            if (inputs[i].tagName != 'INPUT') {
+                continue;
+            }
+            if (field.min != null) {
+                inputs[i].min = field.min;
+            }
+            if (field.max != null) {
+                inputs[i].max = field.max;
+            }
+            if (field.default && !inputs[i].placeholder) {
+                inputs[i].placeholder = field.default;
+            }
+        }
+    });
+    tagFields.forEach(function(name) {
+        var inputs = document.getElementsByName(name);
+        for (var i = 0; i < inputs.length; i++) {
+            inputs[i].maxLength = c.max_tag_length;
+        }
+    });
+}
+// validate reports the first invalid field of the form, hidden
+// fields of not selected options are skipped
+function validate(encode) {
+    var inputs = encode.getElementsByTagName('input');
+    for (var i = 0; i < inputs.length; i++) {
+        if (inputs[i].offsetParent != null && !inputs[i].checkValidity()) {
+            inputs[i].reportValidity();
+            return false;
+        }
+    }
+    return true;
+}
+function onInputFileChange(){
+    addFiles(this.files);
+    // the same files can be selected again
+    this.value = '';
+}
+// addFiles adds files to the queue, files of unsupported formats
+// and files that are too big are rejected
+function addFiles(files) {
+    // files are copied, because the list of input is cleared
+    files = Array.prototype.slice.call(files);
+    capabilities.then(function(c) {
+        for (var i = 0; i < files.length; i++) {
+            queue.push(newQueueItem(files[i], c));
+        }
+        if (queue.some(function(item) { return item.state == 'queued'; })) {
+            displayId('output-format-block', 'inline');
+        }
+    });
+}
+function newQueueItem(file, c) {
+    var item = newItem(file.name, humanFileSize(file.size));
+    item.file = file;
+    addPreview(item.preview, file.type, function() {
+        return URL.createObjectURL(file);
+    });
+    var ext = getFileExtension(file.name);
+    var maxSize = c.max_sizes[ext] || 0;
+    if (c.inputs.indexOf(ext) < 0) {
+        setState(item, 'rejected', message('only_formats', c.inputs.join(', ')));
+    } else if (maxSize > 0 && maxSize < file.size) {
+        setState(item, 'rejected', message('too_big', humanFileSize(maxSize)));
+    } else {
+        setState(item, 'queued', message('queued'));
+    }
+    return item;
+}
+// newURLItem returns the item of the source url, the input is
+// fetched by the server
+function newURLItem(url) {
+    var item = newItem(url, '');
+    item.url = url;
+    // results are named after the last element of the path
+    item.name = url.split(/[?#]/)[0].split('/').pop() || 'result';
+    setState(item, 'queued', message('queued'));
+    return item;
+}
+// newItem appends the row of the item to the queue, status of the
+// item has the progress bar of the upload and conversion
+function newItem(name, size) {
+    var row = document.getElementById('queue-files').insertRow(-1);
+    row.insertCell(-1).textContent = name;
+    row.insertCell(-1).textContent = size;
+    var item = {
+        name: name,
+        preview: row.insertCell(-1)
+    };
+    var status = row.insertCell(-1);
+    item.bar = status.appendChild(document.createElement('progress'));
+    item.bar.max = 100;
+    item.bar.style.display = 'none';
+    item.status = status.appendChild(document.createElement('span'));
+    item.result = row.insertCell(-1);
+    displayId('queue', 'block');
+    return item;
+}
+function setState(item, state, text) {
+    item.state = state;
+    item.status.textContent = text;
+}
+function setProgress(item, value, total) {
+    item.bar.style.display = '';
+    item.bar.value = total > 0 ? Math.min(100, Math.floor(value * 100 / total)) : 0;
+}
+function onSourceURLChange(){
+    if (this.value == '') {
+        return;
+    }
+    displayId('output-format-block', 'inline');
+}
+function onOutputFormatChange(){
+    displayClass('output-options', 'none');
+    var selected = this.selectedOptions;
+    for (var i = 0; i < selected.length; i++) {
+        // need to cut the dot
+        displayId(selected[i].value.slice(1)+'-options', 'inline');
+    }
+    displayClass('submit', selected.length > 0 ? 'block' : 'none');
+}
+function onMp3BitRateModeChange(){
+    displayClass('mp3-bit-rate-mode-options', 'none');
+    var selectedOptions = 'mp3-'+this.options[this.selectedIndex].id+'-options';
+    displayClass(selectedOptions, 'inline');
+}
+function onMp3UseQUalityChange(){
+    if (this.checked) {
+        document.getElementById('mp3-quality-value').style.visibility = '';
+    } else {
+        document.getElementById('mp3-quality-value').style.visibility = 'hidden';
+    }
+}
+// presets of the user by names, the block is shown only if the server
+// serves presets
+var presets = {};
+// presetKeys are form fields of the single request, they are not saved
+// in presets
+const presetKeys = [fileId, 'source-url', 'progress-id'];
+function loadPresets(selected) {
+    return fetch('/presets').then(function(response) {
+        if (!response.ok) {
+            throw new Error(response.statusText);
+        }
+        return response.json();
+    }).then(function(list) {
+        var select = document.getElementById('preset');
+        while (select.options.length > 1) {
+            select.remove(1);
+        }
+        presets = {};
+        list.forEach(function(p) {
+            presets[p.name] = p.values;
+            var option = new Option(p.name, p.name);
+            option.selected = p.name == selected;
+            select.add(option);
+        });
+        displayId('presets', 'block');
+    }).catch(function() {});
+}
+function setPresetStatus(text) {
+    document.getElementById('preset-status').textContent = text;
+}
+function onPresetChange(){
+    setPresetStatus('');
+    document.getElementById('preset-name').value = this.value;
+    if (this.value != '') {
+        applyPreset(document.getElementById('encode'), presets[this.value]);
+    }
+}
+// applyPreset resets the form and sets values of the preset, handlers
+// of dependent fields are triggered, so their options are shown
+function applyPreset(encode, values) {
+    var elements = encode.elements;
+    for (var i = 0; i < elements.length; i++) {
+        var e = elements[i];
+        if (!e.name || presetKeys.includes(e.name)) {
+            continue;
+        }
+        var v = values[e.name] || [];
+        if (e.type == 'checkbox') {
+            e.checked = v.includes(e.value);
+        } else if (e.tagName == 'SELECT') {
+            for (var j = 0; j < e.options.length; j++) {
+                e.options[j].selected = v.includes(e.options[j].value);
+            }
+            if (!e.multiple && v.length == 0) {
+                e.selectedIndex = 0;
+            }
+        } else {
+            e.value = v.length > 0 ? v[0] : '';
+        }
+    }
+    displayId('output-format-block', 'inline');
+    ['output-format', 'mp3-bit-rate-mode'].forEach(function(id) {
+        document.getElementById(id).dispatchEvent(new Event('change'));
+    });
+    onMp3UseQUalityChange.call(document.getElementById('mp3-use-quality'));
+}
+// presetValues returns non-empty values of the form that are saved in
+// the preset
+function presetValues(encode) {
+    var values = {};
+    new FormData(encode).forEach(function(v, k) {
+        if (typeof v != 'string' || v == '' || presetKeys.includes(k)) {
+            return;
+        }
+        values[k] = (values[k] || []).concat(v);
+    });
+    return values;
+}
+function onSavePresetClick(){
+    var name = document.getElementById('preset-name');
+    if (name.value == '' || !name.checkValidity()) {
+        name.reportValidity();
+        return;
+    }
+    var encode = document.getElementById('encode');
+    sendPreset('PUT', name.value, JSON.stringify(presetValues(encode))).then(function(ok) {
+        if (ok) {
+            setPresetStatus(message('preset_saved', name.value));
+            loadPresets(name.value);
+        }
+    });
+}
+function onDeletePresetClick(){
+    var name = document.getElementById('preset').value;
+    if (name == '') {
+        return;
+    }
+    sendPreset('DELETE', name).then(function(ok) {
+        if (ok) {
+            setPresetStatus('');
+            document.getElementById('preset-name').value = '';
+            loadPresets();
+        }
+    });
+}
+// sendPreset changes the preset on the server and resolves to true if
+// it's done, errors are shown in the status of presets
+function sendPreset(method, name, body) {
+    return fetch('/presets/'.concat(encodeURIComponent(name)), {
+        method: method,
+        headers: body ? {'Content-Type': 'application/json'} : {},
+        body: body,
+    }).then(function(response) {
+        if (response.ok) {
+            return true;
+        }
+        return response.text().then(function(text) {
+            setPresetStatus(text);
+            return false;
+        });
+    }, function() {
+        setPresetStatus(message('network_error'));
+        return false;
+    });
+}
+function newProgressID() {
+    return Math.random().toString(16).slice(2).concat(Date.now().toString(16));
+}
+// subscribe to progress events of the conversion with the id, events
+// are passed to onProgress until the conversion is done. Returned
+// stream must be closed by the caller when the request is settled
+function subscribeProgress(id, onProgress){
+    var events = new EventSource('/progress/'.concat(id));
+    events.onmessage = function(message) {
+        var e = JSON.parse(message.data);
+        onProgress(e);
+        if (e.done) {
+            events.close();
+        }
+    };
+    events.onerror = function() {
+        events.close();
+    };
+    return events;
+}
+// opened is resolved when the progress stream is connected, so events
+// of fast conversions are not published before the subscription. The
+// stream is optional, it's resolved on error as well
+function opened(events) {
+    return new Promise(function(resolve) {
+        if (events.readyState == EventSource.OPEN) {
+            resolve();
+            return;
+        }
+        events.addEventListener('open', resolve);
+        events.addEventListener('error', resolve);
+    });
+}
+function onSubmitClick(){
+    var encode = document.getElementById('encode');
+    if (!validate(encode)) {
+        return;
+    }
+    var sourceURL = getSourceURL();
+    if (sourceURL && sourceURL.value != '') {
+        queue.push(newURLItem(sourceURL.value));
+        sourceURL.value = '';
+    }
+    if (!encoding) {
+        encodeNext(encode);
+    }
+}
+// encodeNext sends the next queued file with options of the form
+function encodeNext(encode) {
+    var item = queue.find(function(item) { return item.state == 'queued'; });
+    if (!item) {
+        encoding = false;
+        return;
+    }
+    encoding = true;
+    var data = new FormData(encode);
+    data.delete(fileId);
+    data.delete('source-url');
+    var progressID = newProgressID();
+    var events = subscribeProgress(progressID, function(e) {
+        // the input is read by the server after the upload
+        if (item.state == 'converting' && !e.done) {
+            setProgress(item, e.read, e.size);
+            item.status.textContent = message('converting').concat(' ', message('progress', humanFileSize(e.read), humanFileSize(e.size)));
+        }
+    });
+    data.set('progress-id', progressID);
+    var url = '/';
+    if (item.file) {
+        setState(item, 'uploading', message('uploading'));
+        // file is the last part, so options are parsed before upload
+        data.append(fileId, item.file, item.file.name);
+        url = getFileExtension(item.file.name);
+    } else {
+        // input format is detected by server
+        setState(item, 'converting', message('converting'));
+        data.set('source-url', item.url);
+    }
+    opened(events).then(function() {
+        return post(url, data, item);
+    }).then(function(xhr) {
+        var blob = xhr.response;
+        if (xhr.status < 200 || xhr.status >= 300) {
+            return blob.text().then(function(text) {
+                throw new Error(text || xhr.statusText);
+            });
+        }
+        if ((xhr.getResponseHeader('Content-Type') || '').indexOf('application/json') == 0) {
+            // link to the result or its destination
+            return blob.text().then(function(text) {
+                var result = JSON.parse(text);
+                setResult(item, result.url || result.location, '');
+                if (result.url) {
+                    // stored results are served with their type
+                    addPreview(item.result, '', function() {
+                        return result.url;
+                    });
+                }
+            });
+        }
+        var name = resultName(item.name, xhr.getResponseHeader('Content-Disposition'));
+        var url = URL.createObjectURL(blob);
+        setResult(item, url, name);
+        addPreview(item.result, blob.type, function() {
+            return url;
+        });
+    }).then(function() {
+        setState(item, 'done', message('done'));
+    }, function(err) {
+        setState(item, 'failed', err.message);
+    }).then(function() {
+        // failed requests don't finish the progress stream
+        events.close();
+        item.bar.style.display = 'none';
+        encodeNext(encode);
+    });
+}
+// post sends the form with XHR, because fetch doesn't report the
+// progress of the upload. The request is resolved after the response
+// is received.
+function post(url, data, item) {
+    return new Promise(function(resolve, reject) {
+        var xhr = new XMLHttpRequest();
+        xhr.open('POST', url);
+        xhr.responseType = 'blob';
+        xhr.upload.onprogress = function(e) {
+            if (item.state == 'uploading' && e.lengthComputable) {
+                setProgress(item, e.loaded, e.total);
+                item.status.textContent = message('uploading').concat(' ', message('progress', humanFileSize(e.loaded), humanFileSize(e.total)));
+            }
+        };
+        xhr.upload.onload = function() {
+            // conversion progress is reported by the server
+            setState(item, 'converting', message('converting'));
+            setProgress(item, 0, 0);
+        };
+        xhr.onload = function() {
+            resolve(xhr);
+        };
+        xhr.onerror = function() {
+            reject(new Error(message('network_error')));
+        };
+        xhr.send(data);
+    });
+}
+// resultName returns the name of the input with extension of the
+// result
+function resultName(fileName, disposition) {
+    var match = /filename=([^;]+)/.exec(disposition || '');
+    var ext = match ? getFileExtension(match[1]) : '';
+    var i = fileName.lastIndexOf('.');
+    return (i < 0 ? fileName : fileName.slice(0, i)).concat(ext);
+}
+function setResult(item, url, name) {
+    var link = document.createElement('a');
+    link.href = url;
+    link.textContent = message('download');
+    if (name) {
+        link.download = name;
+    }
+    item.result.appendChild(link);
+}
+// addPreview appends the player of the audio to the element if the
+// browser can play its type, empty type is assumed to be playable.
+// Source url is requested only when the player is added
+function addPreview(element, type, url) {
+    var audio = document.createElement('audio');
+    if (type && audio.canPlayType(type) == '') {
+        return;
+    }
+    audio.controls = true;
+    audio.preload = 'none';
+    audio.src = url();
+    element.appendChild(audio);
+}
@@ -0,0 +1,55 @@
+package userinput_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-templates")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "static"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "static", "encode.css"), []byte("body {}"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "encode.html"), []byte("accept {{ .Accept }}"), 0644))
+
+	templates, err := userinput.LoadTemplates(dir)
+	assert.NoError(t, err)
+	f := userinput.NewEncodeForm(userinput.Limits{}, "", nil, nil, false)
+	assert.Contains(t, string(f.Bytes(i18n.Default)), `src="/static/encode.js"`)
+	ru := string(f.Bytes("ru"))
+	assert.Contains(t, ru, `<html lang="ru">`)
+	assert.Contains(t, ru, `"uploading":"загружается"`)
+	assert.Contains(t, string(f.Bytes("ja")), `<html lang="en">`)
+	assert.NoError(t, f.SetTemplates(templates))
+	assert.True(t, strings.HasPrefix(string(f.Bytes(i18n.Default)), "accept .wav"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		templates.Static().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+	// overridden asset
+	assert.Equal(t, "body {}", get("/static/encode.css").Body.String())
+	// embedded asset
+	rr := get("/static/encode.js")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "function encodeNext")
+	assert.Equal(t, http.StatusNotFound, get("/static/missing.js").Code)
+
+	_, err = userinput.LoadTemplates(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "encode.html"), []byte("{{ .Accept"), 0644))
+	_, err = userinput.LoadTemplates(dir)
+	assert.Error(t, err)
+}
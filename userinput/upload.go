@@ -0,0 +1,301 @@
+package userinput
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/i18n"
+	"pipelined.dev/phono/idgen"
+)
+
+// UploadIDKey is the id of the resumable upload input in the HTML form.
+const UploadIDKey = "upload-id"
+
+// Headers of resumable upload protocol.
+const (
+	UploadLengthHeader = "Upload-Length"
+	UploadOffsetHeader = "Upload-Offset"
+)
+
+var (
+	errUploadNotFound   = i18n.Errorf("error.upload_not_found")
+	errUploadIncomplete = i18n.Errorf("error.upload_incomplete")
+	errUploadTooLarge   = i18n.Errorf("error.upload_too_large")
+	errUploadOffset     = i18n.Errorf("error.upload_offset")
+)
+
+type (
+	// Uploads handles resumable uploads. Files are uploaded in chunks
+	// and assembled in the temp dir. Protocol:
+	//	POST  /uploads/      Upload-Length: size   -> 201 Location: /uploads/id
+	//	HEAD  /uploads/id                          -> 200 Upload-Offset: offset
+	//	PATCH /uploads/id    Upload-Offset: offset -> 204 Upload-Offset: offset
+	// If offset of the PATCH request doesn't match the current upload
+	// offset, 409 is returned and client should request current offset
+	// with HEAD. PATCH requests of the same upload are not served
+	// concurrently, the second one gets 409 as well. Completed upload is
+	// encoded by providing its id in the encode form. Uploads which are
+	// not updated longer than TTL are removed by Run. Clock and IDs can
+	// be set before the first use, system clock and random ids are used
+	// by default.
+	Uploads struct {
+		Clock   clock.Clock
+		IDs     idgen.Generator
+		tempDir string
+		maxSize int64
+		ttl     time.Duration
+
+		mu      sync.Mutex
+		uploads map[string]*upload
+	}
+
+	// upload is the state of resumable upload. Its file is written
+	// without the lock, busy is set while the chunk is copied.
+	upload struct {
+		sync.Mutex
+		file    *os.File
+		length  int64
+		offset  int64
+		updated time.Time
+		busy    bool
+		removed bool
+	}
+)
+
+// NewUploads creates resumable uploads handler. Zero maxSize and ttl mean
+// no limits.
+func NewUploads(tempDir string, maxSize int64, ttl time.Duration) *Uploads {
+	return &Uploads{
+		tempDir: tempDir,
+		maxSize: maxSize,
+		ttl:     ttl,
+		uploads: make(map[string]*upload),
+	}
+}
+
+// ServeHTTP handles resumable upload requests.
+func (u *Uploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		u.create(w, r)
+	case http.MethodHead:
+		u.offset(w, r)
+	case http.MethodPatch:
+		u.patch(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (u *Uploads) create(w http.ResponseWriter, r *http.Request) {
+	length, err := parseHeader(r, UploadLengthHeader)
+	if err != nil {
+		http.Error(w, i18n.Localize(i18n.Request(r), err), http.StatusBadRequest)
+		return
+	}
+	if u.maxSize > 0 && length > u.maxSize {
+		http.Error(w, i18n.Localize(i18n.Request(r), errUploadTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	id, err := idgen.Or(u.IDs).New()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	file, err := ioutil.TempFile(u.tempDir, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.mu.Lock()
+	u.uploads[id] = &upload{
+		file:    file,
+		length:  length,
+		updated: u.now(),
+	}
+	u.mu.Unlock()
+
+	w.Header().Set("Location", path.Join(r.URL.Path, id))
+	w.Header().Set(UploadOffsetHeader, "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (u *Uploads) offset(w http.ResponseWriter, r *http.Request) {
+	up, ok := u.get(path.Base(r.URL.Path))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	up.Lock()
+	defer up.Unlock()
+	if up.removed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set(UploadLengthHeader, strconv.FormatInt(up.length, 10))
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(up.offset, 10))
+}
+
+func (u *Uploads) patch(w http.ResponseWriter, r *http.Request) {
+	up, ok := u.get(path.Base(r.URL.Path))
+	if !ok {
+		http.Error(w, i18n.Localize(i18n.Request(r), errUploadNotFound), http.StatusNotFound)
+		return
+	}
+	offset, err := parseHeader(r, UploadOffsetHeader)
+	if err != nil {
+		http.Error(w, i18n.Localize(i18n.Request(r), err), http.StatusBadRequest)
+		return
+	}
+
+	up.Lock()
+	if up.removed {
+		up.Unlock()
+		http.Error(w, i18n.Localize(i18n.Request(r), errUploadNotFound), http.StatusNotFound)
+		return
+	}
+	if up.busy || offset != up.offset {
+		up.Unlock()
+		http.Error(w, i18n.Localize(i18n.Request(r), errUploadOffset), http.StatusConflict)
+		return
+	}
+	up.busy = true
+	up.updated = u.now()
+	length := up.length
+	up.Unlock()
+
+	// chunk cannot exceed declared length, the lock is not held while
+	// the chunk is copied, so slow clients don't block other requests
+	body := http.MaxBytesReader(w, r.Body, length-offset)
+	n, err := io.Copy(up.file, body)
+
+	up.Lock()
+	up.offset += n
+	up.updated = u.now()
+	up.busy = false
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(up.offset, 10))
+	up.Unlock()
+	if err != nil {
+		// client can resume from the current offset
+		http.Error(w, i18n.Localize(i18n.Request(r), err), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// take returns completed upload and removes it from the list.
+func (u *Uploads) take(id string) (*tempFile, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, ok := u.uploads[id]
+	if !ok {
+		return nil, errUploadNotFound
+	}
+	up.Lock()
+	defer up.Unlock()
+	if up.removed {
+		return nil, errUploadNotFound
+	}
+	if up.busy || up.offset != up.length {
+		return nil, errUploadIncomplete
+	}
+	up.removed = true
+	delete(u.uploads, id)
+
+	file := tempFile{File: up.file, size: up.length}
+	if _, err := up.file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (u *Uploads) get(id string) (*upload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, ok := u.uploads[id]
+	return up, ok
+}
+
+// Owns returns true if path is the file of active upload.
+func (u *Uploads) Owns(path string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, up := range u.uploads {
+		if up.file.Name() == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Run removes expired uploads every interval until the context is
+// done. Uploads are kept forever if TTL is zero.
+func (u *Uploads) Run(ctx context.Context, interval time.Duration) {
+	if u.ttl == 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			u.expire()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expire removes uploads that were not updated longer than TTL. Uploads
+// are locked one by one after the list is copied, so chunks that are
+// being written don't block the list.
+func (u *Uploads) expire() {
+	u.mu.Lock()
+	uploads := make(map[string]*upload, len(u.uploads))
+	for id, up := range u.uploads {
+		uploads[id] = up
+	}
+	u.mu.Unlock()
+
+	now := u.now()
+	for id, up := range uploads {
+		up.Lock()
+		expired := !up.busy && !up.removed && now.Sub(up.updated) > u.ttl
+		if expired {
+			up.removed = true
+		}
+		up.Unlock()
+		if !expired {
+			continue
+		}
+		u.mu.Lock()
+		delete(u.uploads, id)
+		u.mu.Unlock()
+		(&tempFile{File: up.file}).Close()
+	}
+}
+
+// parseHeader parses non-negative integer header value.
+func parseHeader(r *http.Request, key string) (int64, error) {
+	v, err := strconv.ParseInt(r.Header.Get(key), 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid %s header: %q", key, r.Header.Get(key))
+	}
+	return v, nil
+}
+
+func (u *Uploads) now() time.Time {
+	return clock.Or(u.Clock).Now()
+}
@@ -0,0 +1,131 @@
+package userinput_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"pipelined.dev/phono/clock"
+	"pipelined.dev/phono/userinput"
+)
+
+func TestUploads(t *testing.T) {
+	content, err := ioutil.ReadFile("../_testdata/sample.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads := userinput.NewUploads("", 0, 0)
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		uploads.ServeHTTP(rr, r)
+		return rr
+	}
+	patch := func(location string, offset int, chunk []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPatch, location, bytes.NewReader(chunk))
+		r.Header.Set(userinput.UploadOffsetHeader, strconv.Itoa(offset))
+		return serve(r)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+	rr := serve(r)
+	assertEqual(t, "missing length", rr.Code, http.StatusBadRequest)
+
+	r.Header.Set(userinput.UploadLengthHeader, strconv.Itoa(len(content)))
+	rr = serve(r)
+	assertEqual(t, "create", rr.Code, http.StatusCreated)
+	location := rr.Header().Get("Location")
+
+	half := len(content) / 2
+	rr = patch(location, 0, content[:half])
+	assertEqual(t, "first chunk", rr.Code, http.StatusNoContent)
+	rr = patch(location, 0, content[half:])
+	assertEqual(t, "offset mismatch", rr.Code, http.StatusConflict)
+
+	rr = serve(httptest.NewRequest(http.MethodHead, location, nil))
+	assertEqual(t, "head", rr.Code, http.StatusOK)
+	assertEqual(t, "offset", rr.Header().Get(userinput.UploadOffsetHeader), strconv.Itoa(half))
+
+	// encode is not possible until upload is complete
+	form := userinput.NewEncodeForm(userinput.Limits{}, "", nil, uploads, false)
+	_, err = form.Parse(encodeUploadRequest(location))
+	assertNotNil(t, "incomplete error", err)
+
+	rr = patch(location, half, content[half:])
+	assertEqual(t, "last chunk", rr.Code, http.StatusNoContent)
+
+	data, err := form.Parse(encodeUploadRequest(location))
+	assertEqual(t, "error", err, nil)
+	assertEqual(t, "size", data.Input.Size, int64(len(content)))
+	data.Close()
+
+	// upload can be encoded only once
+	_, err = form.Parse(encodeUploadRequest(location))
+	assertNotNil(t, "second encode error", err)
+}
+
+func TestUploadsExpire(t *testing.T) {
+	c := clock.NewManual(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	uploads := userinput.NewUploads("", 0, time.Minute)
+	uploads.Clock = c
+	create := func() string {
+		r := httptest.NewRequest(http.MethodPost, "/uploads/", nil)
+		r.Header.Set(userinput.UploadLengthHeader, "4")
+		rr := httptest.NewRecorder()
+		uploads.ServeHTTP(rr, r)
+		return rr.Header().Get("Location")
+	}
+	head := func(location string) int {
+		rr := httptest.NewRecorder()
+		uploads.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, location, nil))
+		return rr.Code
+	}
+	abandoned, active := create(), create()
+
+	// chunk of active upload is being written
+	body, bodyWriter := io.Pipe()
+	r := httptest.NewRequest(http.MethodPatch, active, body)
+	r.Header.Set(userinput.UploadOffsetHeader, "0")
+	patched := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		uploads.ServeHTTP(rr, r)
+		patched <- rr.Code
+	}()
+	bodyWriter.Write([]byte("ab"))
+	assertEqual(t, "head while writing", head(active), http.StatusOK)
+
+	c.Add(2 * time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go uploads.Run(ctx, time.Millisecond)
+	for i := 0; i < 1000 && head(abandoned) != http.StatusNotFound; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assertEqual(t, "abandoned", head(abandoned), http.StatusNotFound)
+	assertEqual(t, "active", head(active), http.StatusOK)
+
+	bodyWriter.Write([]byte("cd"))
+	bodyWriter.Close()
+	assertEqual(t, "patch", <-patched, http.StatusNoContent)
+	assertEqual(t, "completed", head(active), http.StatusOK)
+}
+
+func encodeUploadRequest(location string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField(userinput.UploadIDKey, location[len("/uploads/"):])
+	_ = writer.WriteField("format", ".wav")
+	_ = writer.WriteField("wav-bit-depth", "16")
+	writer.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/.wav", body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
@@ -0,0 +1,271 @@
+package worker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNATSPort is the port of NATS servers if URL doesn't have one.
+const defaultNATSPort = "4222"
+
+var errNATSClosed = errors.New("nats connection is closed")
+
+// NATS is the broker connection to NATS server. Messages are delivered at
+// most once, so jobs of workers that fail during conversion are lost.
+// Connection isn't restored, workers are expected to be restarted.
+type NATS struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu   sync.Mutex
+	w    *bufio.Writer
+	sid  int
+	subs map[string]func([]byte)
+	// pong receives replies to pings of the client.
+	pong chan struct{}
+
+	done chan struct{}
+	err  error
+}
+
+// DialNATS connects to the NATS server of nats://[user:password@]host[:port]
+// or nats://token@host[:port] URL.
+func DialNATS(ctx context.Context, rawURL string) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker url: %w", err)
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported broker: %s", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultNATSPort)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	n := &NATS{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+		subs: make(map[string]func([]byte)),
+		pong: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if err := n.connect(ctx, u); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	go n.read()
+	// the first pong confirms the connection is accepted
+	if err := n.ping(ctx); err != nil {
+		n.Close()
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	return n, nil
+}
+
+// connect reads server info and sends client options.
+func (n *NATS) connect(ctx context.Context, u *url.URL) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		n.conn.SetDeadline(deadline)
+		defer n.conn.SetDeadline(time.Time{})
+	}
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return fmt.Errorf("unexpected server greeting: %s", strings.TrimSpace(line))
+	}
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "phono",
+		"lang":     "go",
+		"protocol": 1,
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = password
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	b, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	return n.write("CONNECT %s\r\n", b)
+}
+
+// Subscribe delivers messages of the subject to the handler. If queue is
+// not empty, every message is delivered to a single subscriber of the
+// queue group. Handler is called by the reading goroutine, so messages
+// wait in the server while it blocks.
+func (n *NATS) Subscribe(subject, queue string, handle func([]byte)) (func() error, error) {
+	n.mu.Lock()
+	n.sid++
+	sid := strconv.Itoa(n.sid)
+	n.subs[sid] = handle
+	n.mu.Unlock()
+	var err error
+	if queue != "" {
+		err = n.write("SUB %s %s %s\r\n", subject, queue, sid)
+	} else {
+		err = n.write("SUB %s %s\r\n", subject, sid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		n.mu.Lock()
+		delete(n.subs, sid)
+		n.mu.Unlock()
+		return n.write("UNSUB %s\r\n", sid)
+	}, nil
+}
+
+// Publish sends the message to the subject.
+func (n *NATS) Publish(subject string, data []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	fmt.Fprintf(n.w, "PUB %s %d\r\n", subject, len(data))
+	n.w.Write(data)
+	n.w.WriteString("\r\n")
+	return n.w.Flush()
+}
+
+// Flush waits until the server processed all sent messages.
+func (n *NATS) Flush(ctx context.Context) error {
+	return n.ping(ctx)
+}
+
+// Done is closed when the connection is lost or closed.
+func (n *NATS) Done() <-chan struct{} {
+	return n.done
+}
+
+// Err returns the reason why the connection is done.
+func (n *NATS) Err() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.err
+}
+
+// Close closes the connection.
+func (n *NATS) Close() error {
+	n.fail(errNATSClosed)
+	return nil
+}
+
+// ping sends the ping and waits for the pong.
+func (n *NATS) ping(ctx context.Context) error {
+	if err := n.write("PING\r\n"); err != nil {
+		return err
+	}
+	select {
+	case <-n.pong:
+		return nil
+	case <-n.done:
+		return n.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read handles messages of the server until the connection fails.
+func (n *NATS) read() {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			n.fail(err)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch op {
+		case "MSG":
+			if err := n.message(line); err != nil {
+				n.fail(err)
+				return
+			}
+		case "PING":
+			if err := n.write("PONG\r\n"); err != nil {
+				n.fail(err)
+				return
+			}
+		case "PONG":
+			select {
+			case n.pong <- struct{}{}:
+			default:
+			}
+		case "-ERR":
+			n.fail(fmt.Errorf("nats: %s", strings.Trim(strings.TrimPrefix(line, op), " '")))
+			return
+		}
+	}
+}
+
+// message reads the payload of MSG subject sid [reply-to] size and
+// passes it to the handler of subscription.
+func (n *NATS) message(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 4 && len(fields) != 5 {
+		return fmt.Errorf("invalid nats message: %s", line)
+	}
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid nats message: %s", line)
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(n.r, payload); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	handle := n.subs[fields[2]]
+	n.mu.Unlock()
+	if handle != nil {
+		handle(payload[:size])
+	}
+	return nil
+}
+
+func (n *NATS) write(format string, args ...interface{}) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	fmt.Fprintf(n.w, format, args...)
+	return n.w.Flush()
+}
+
+// fail closes the connection with the first error.
+func (n *NATS) fail(err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return
+	}
+	n.err = err
+	n.conn.Close()
+	close(n.done)
+}
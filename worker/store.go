@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"pipelined.dev/phono/encode/service"
+)
+
+// Statuses of job records.
+const (
+	// StatusQueued means the job is published and not received yet.
+	StatusQueued = "queued"
+	// StatusRunning means the job is being processed.
+	StatusRunning = "running"
+	// StatusDone means the job is finished successfully.
+	StatusDone = service.CallbackDone
+	// StatusFailed means the job is failed.
+	StatusFailed = service.CallbackFailed
+)
+
+// ErrNotFound is returned when the job record doesn't exist.
+var ErrNotFound = errors.New("job not found")
+
+type (
+	// Store keeps records of jobs, so their state and results survive
+	// restarts. Save replaces the record with the same job id. List
+	// returns records of all jobs sorted by creation time.
+	Store interface {
+		Save(Record) error
+		Get(id string) (Record, error)
+		List() ([]Record, error)
+		Delete(id string) error
+	}
+
+	// Record is the state of the job. Result is set when the job is
+	// finished. Attempts is the number of times the job was received.
+	Record struct {
+		Job      Job                    `json:"job"`
+		Status   string                 `json:"status"`
+		Result   *service.CallbackEvent `json:"result,omitempty"`
+		Attempts int                    `json:"attempts"`
+		Created  time.Time              `json:"created"`
+		Updated  time.Time              `json:"updated"`
+	}
+
+	// MemoryStore keeps records in memory. It's safe for concurrent use.
+	MemoryStore struct {
+		mu      sync.Mutex
+		records map[string]Record
+	}
+)
+
+// Finished returns true if the job is done or failed.
+func (r Record) Finished() bool {
+	return r.Status == StatusDone || r.Status == StatusFailed
+}
+
+// NewMemoryStore returns empty memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Save stores the record.
+func (s *MemoryStore) Save(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[r.Job.ID] = r
+	return nil
+}
+
+// Get returns the record of the job.
+func (s *MemoryStore) Get(id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[id]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return r, nil
+}
+
+// List returns all records.
+func (s *MemoryStore) List() ([]Record, error) {
+	s.mu.Lock()
+	records := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	s.mu.Unlock()
+	sortRecords(records)
+	return records, nil
+}
+
+// Delete removes the record of the job.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.records, id)
+	return nil
+}
+
+// sortRecords sorts records by creation time and id.
+func sortRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Created.Equal(records[j].Created) {
+			return records[i].Created.Before(records[j].Created)
+		}
+		return records[i].Job.ID < records[j].Job.ID
+	})
+}
+
+// Purge removes records of jobs finished before the time and returns
+// the number of removed records.
+func Purge(s Store, before time.Time) (int, error) {
+	records, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, r := range records {
+		if !r.Finished() || !r.Updated.Before(before) {
+			continue
+		}
+		if err := s.Delete(r.Job.ID); err != nil && !errors.Is(err, ErrNotFound) {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Retry publishes the job of the record again and marks it queued. Only
+// failed jobs can be retried.
+func Retry(s Store, b Broker, subject, id string) error {
+	r, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if r.Status != StatusFailed {
+		return fmt.Errorf("job %s is %s, only failed jobs can be retried", id, r.Status)
+	}
+	data, err := json.Marshal(r.Job)
+	if err != nil {
+		return err
+	}
+	// the record is saved first, so it doesn't overwrite the state of
+	// the worker that already received the job
+	failed := r
+	r.Status = StatusQueued
+	r.Result = nil
+	r.Updated = time.Now().UTC()
+	if err := s.Save(r); err != nil {
+		return err
+	}
+	if err := b.Publish(subject, data); err != nil {
+		if serr := s.Save(failed); serr != nil {
+			return fmt.Errorf("%v, failed to restore the record: %v", err, serr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package worker_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/worker"
+)
+
+// fakeBroker records published messages.
+type fakeBroker struct {
+	worker.Broker
+	published [][]byte
+	err       error
+}
+
+func (b *fakeBroker) Publish(subject string, data []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published = append(b.published, data)
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-jobs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sqlite, err := worker.OpenStore("sqlite:" + filepath.Join(dir, "jobs.db"))
+	assert.NoError(t, err)
+	defer sqlite.Close()
+	stores := map[string]worker.Store{
+		"memory": worker.NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+	// postgres database is tested if it's provided
+	if url := os.Getenv("PHONO_TEST_POSTGRES"); url != "" {
+		postgres, err := worker.OpenStore(url)
+		assert.NoError(t, err)
+		defer postgres.Close()
+		stores["postgres"] = postgres
+	}
+	_, err = worker.OpenStore(filepath.Join(dir, "jobs"))
+	assert.Error(t, err)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			records := []worker.Record{
+				{
+					Job:     worker.Job{ID: "../b", Source: "in.wav", Destination: "out.mp3"},
+					Status:  worker.StatusFailed,
+					Result:  &service.CallbackEvent{ID: "../b", Status: service.CallbackFailed, Error: "failed"},
+					Created: now.Add(time.Second),
+					Updated: now.Add(time.Minute),
+				},
+				{
+					Job:     worker.Job{ID: "a"},
+					Status:  worker.StatusDone,
+					Created: now,
+					Updated: now.Add(time.Hour),
+				},
+				{
+					Job:     worker.Job{ID: "c"},
+					Status:  worker.StatusRunning,
+					Created: now.Add(2 * time.Second),
+					Updated: now,
+				},
+			}
+			for _, r := range records {
+				assert.NoError(t, s.Save(r))
+			}
+			list, err := s.List()
+			assert.NoError(t, err)
+			assert.Equal(t, []worker.Record{records[1], records[0], records[2]}, list)
+
+			r, err := s.Get("../b")
+			assert.NoError(t, err)
+			assert.Equal(t, records[0], r)
+			_, err = s.Get("missing")
+			assert.True(t, errors.Is(err, worker.ErrNotFound))
+
+			broker := &fakeBroker{err: errors.New("closed")}
+			assert.Error(t, worker.Retry(s, broker, "jobs", "../b"))
+			r, _ = s.Get("../b")
+			assert.Equal(t, worker.StatusFailed, r.Status)
+			broker.err = nil
+			assert.NoError(t, worker.Retry(s, broker, "jobs", "../b"))
+			assert.Equal(t, [][]byte{[]byte(`{"id":"../b","source":"in.wav","destination":"out.mp3"}`)}, broker.published)
+			r, _ = s.Get("../b")
+			assert.Equal(t, worker.StatusQueued, r.Status)
+			assert.Nil(t, r.Result)
+			assert.Error(t, worker.Retry(s, broker, "jobs", "a"))
+
+			n, err := worker.Purge(s, now.Add(2*time.Hour))
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			list, err = s.List()
+			assert.NoError(t, err)
+			assert.Len(t, list, 2)
+
+			assert.NoError(t, s.Delete("c"))
+			assert.True(t, errors.Is(s.Delete("c"), worker.ErrNotFound))
+		})
+	}
+}
@@ -0,0 +1,396 @@
+// Package worker runs encode jobs consumed from message brokers, so phono
+// can scale horizontally as stateless workers behind a queue.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"pipelined.dev/audio/fileformat"
+	"pipelined.dev/pipe"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/idgen"
+	"pipelined.dev/phono/storage"
+	"pipelined.dev/phono/tag"
+	"pipelined.dev/phono/userinput"
+)
+
+type (
+	// Broker delivers job messages and publishes result events.
+	// Subscribe returns the function that stops delivery. Messages are
+	// passed to the handler one at a time and the next one is not taken
+	// until it returns, so the handler can block to keep pending messages
+	// with the broker. Done is closed when the connection is lost and Err
+	// returns the reason.
+	Broker interface {
+		Subscribe(subject, queue string, handle func([]byte)) (func() error, error)
+		Publish(subject string, data []byte) error
+		Done() <-chan struct{}
+		Err() error
+		Close() error
+	}
+
+	// Job is the message of encode job. Source and Destination are
+	// storage locations, format of the input is detected by the source
+	// extension. Output format is taken from the destination extension
+	// unless Format is provided. Sink options are named after flags of
+	// encode commands, their defaults are the same. Result event is also
+	// sent to CallbackURL if it's provided.
+	Job struct {
+		ID          string `json:"id"`
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		Format      string `json:"format,omitempty"`
+		BitDepth    int    `json:"bit_depth,omitempty"`
+		ChannelMode *int   `json:"channel_mode,omitempty"`
+		BitRateMode string `json:"bit_rate_mode,omitempty"`
+		BitRate     *int   `json:"bit_rate,omitempty"`
+		Quality     *int   `json:"quality,omitempty"`
+		CallbackURL string `json:"callback_url,omitempty"`
+	}
+
+	// Worker consumes jobs of the Jobs subject and publishes result
+	// events into the Results subject. Workers of the same Queue share
+	// jobs, so every job is processed once. Up to Concurrency jobs are
+	// processed at the same time, one if it's zero. Callbacks of jobs are
+	// sent only if Callbacks is not nil. State of jobs is recorded in the
+	// Store if it's not nil. Jobs without id get one from IDs,
+	// random ids are used if it's nil. Temp files are created in TempDir,
+	// os.TempDir is used if it's empty.
+	Worker struct {
+		Broker      Broker
+		Jobs        string
+		Results     string
+		Queue       string
+		Concurrency int
+		Storage     storage.Storage
+		Buffering   encode.Buffering
+		Timeouts    service.Timeouts
+		Callbacks   *service.Callbacks
+		Store       Store
+		IDs         idgen.Generator
+		TempDir     string
+	}
+)
+
+// Run processes jobs until the context is done or the broker connection
+// is lost. Jobs in progress are finished before it returns.
+func (w *Worker) Run(ctx context.Context) error {
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		slots = make(chan struct{}, concurrency)
+		wg    sync.WaitGroup
+		// jobs received after stop are dropped
+		mu      sync.Mutex
+		stopped bool
+	)
+	unsubscribe, err := w.Broker.Subscribe(w.Jobs, w.Queue, func(data []byte) {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			return
+		}
+		wg.Add(1)
+		mu.Unlock()
+		// the next job is not taken until the slot is free
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			w.handle(data)
+		}()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to jobs: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		err = nil
+		if uerr := unsubscribe(); uerr != nil {
+			log.Printf("Failed to unsubscribe from jobs: %v", uerr)
+		}
+	case <-w.Broker.Done():
+		err = w.Broker.Err()
+	}
+	mu.Lock()
+	stopped = true
+	mu.Unlock()
+	wg.Wait()
+	return err
+}
+
+// handle processes the job message and publishes its result.
+func (w *Worker) handle(data []byte) {
+	var (
+		job Job
+		e   service.CallbackEvent
+	)
+	if err := json.Unmarshal(data, &job); err != nil {
+		e = service.CallbackEvent{
+			Status: service.CallbackFailed,
+			Error:  fmt.Sprintf("invalid job: %v", err),
+			Time:   time.Now().UTC(),
+		}
+	} else {
+		if job.ID == "" {
+			if job.ID, err = idgen.Or(w.IDs).New(); err != nil {
+				log.Printf("Failed to generate job id: %v", err)
+			}
+		}
+		record := w.started(job)
+		e = w.Process(context.Background(), job)
+		w.finished(record, e)
+	}
+	if e.Status == service.CallbackDone {
+		log.Printf("Job %s: %s: %s", e.ID, e.Status, e.Location)
+	} else {
+		log.Printf("Job %s: %s: %s", e.ID, e.Status, e.Error)
+	}
+
+	if w.Results != "" {
+		b, err := json.Marshal(e)
+		if err == nil {
+			err = w.Broker.Publish(w.Results, b)
+		}
+		if err != nil {
+			log.Printf("Failed to publish result of job %s: %v", e.ID, err)
+		}
+	}
+	if job.CallbackURL != "" && w.Callbacks != nil {
+		callback, err := w.Callbacks.Callback(job.CallbackURL)
+		if err != nil {
+			log.Printf("Failed to send callback of job %s: %v", e.ID, err)
+			return
+		}
+		callback.Send(e)
+	}
+}
+
+// started records the received job as running. Attempts of jobs received
+// again are counted.
+func (w *Worker) started(job Job) Record {
+	now := time.Now().UTC()
+	r := Record{Job: job, Created: now}
+	if w.Store == nil {
+		return r
+	}
+	if prev, err := w.Store.Get(job.ID); err == nil {
+		r.Created = prev.Created
+		r.Attempts = prev.Attempts
+	}
+	r.Status = StatusRunning
+	r.Attempts++
+	r.Updated = now
+	if err := w.Store.Save(r); err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+	}
+	return r
+}
+
+// finished records the result of the job.
+func (w *Worker) finished(r Record, e service.CallbackEvent) {
+	if w.Store == nil {
+		return
+	}
+	r.Status = e.Status
+	r.Result = &e
+	r.Updated = e.Time
+	if err := w.Store.Save(r); err != nil {
+		log.Printf("Failed to save job %s: %v", r.Job.ID, err)
+	}
+}
+
+// Process runs the job and returns its result event.
+func (w *Worker) Process(ctx context.Context, job Job) service.CallbackEvent {
+	if w.Timeouts.Conversion > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, w.Timeouts.Conversion)
+		defer cancelFn()
+	}
+	e := service.CallbackEvent{
+		ID:     job.ID,
+		Status: service.CallbackDone,
+	}
+	summary, err := w.process(ctx, job)
+	if err != nil {
+		e.Status = service.CallbackFailed
+		e.Error = err.Error()
+		e.Code = encode.Code(err)
+	} else {
+		e.Location = job.Destination
+		e.Stats = service.NewCallbackStats(summary)
+	}
+	e.Time = time.Now().UTC()
+	return e
+}
+
+// process downloads the source, encodes it and uploads the result. Outputs
+// that don't need to seek are uploaded while they are encoded, others are
+// encoded into the temp file first. Tags of the source are kept.
+func (w *Worker) process(ctx context.Context, job Job) (encode.Summary, error) {
+	if job.Source == "" || job.Destination == "" {
+		return encode.Summary{}, errors.New("job must have source and destination")
+	}
+	format := fileformat.FormatByPath(storage.Base(job.Source))
+	if format == nil {
+		return encode.Summary{}, fmt.Errorf("unsupported input format: %s", job.Source)
+	}
+	outFormat, sink, stream, err := jobSink(job)
+	if err != nil {
+		return encode.Summary{}, err
+	}
+
+	in, err := w.download(ctx, job.Source)
+	if err != nil {
+		return encode.Summary{}, fmt.Errorf("failed to download source: %w", err)
+	}
+	defer removeFile(in)
+	tags, err := tag.Read(format, in)
+	if err != nil {
+		log.Printf("Skipping tags of %s: %v", job.Source, err)
+	}
+	stats := encode.NewStats()
+	bufferSize := w.Buffering.InputBufferSize(format, outFormat, in, stream != nil)
+	run := func(out io.Writer, sink pipe.SinkAllocatorFunc) error {
+		if err := encode.Run(ctx, bufferSize, w.Timeouts.Stall, stats.Source(format.Source(in)), tag.Sink(stats.Sink(sink), outFormat, out, tags)); err != nil {
+			return fmt.Errorf("failed to encode: %w", err)
+		}
+		return nil
+	}
+	destination := service.Destination{
+		Location: job.Destination,
+		Put: func(ctx context.Context, r io.Reader) error {
+			return w.Storage.Put(ctx, job.Destination, r)
+		},
+	}
+
+	var size int64
+	if stream != nil {
+		err = destination.Stream(ctx, func(out io.Writer) error {
+			cw := encode.CountingWriter{Writer: out}
+			err := run(&cw, stream(&cw))
+			size = cw.Written
+			return err
+		})
+	} else {
+		size, err = w.encodeFile(ctx, destination, func(out *os.File) error {
+			return run(out, sink(out))
+		})
+	}
+	if err != nil {
+		return encode.Summary{}, err
+	}
+	inSize, _ := in.Seek(0, io.SeekEnd)
+	return stats.Summary(
+		strings.TrimPrefix(format.DefaultExtension(), "."),
+		strings.TrimPrefix(outFormat.DefaultExtension(), "."),
+		inSize,
+		size,
+	), nil
+}
+
+// encodeFile runs the conversion into the temp file and uploads it to
+// the destination. Size of the result is returned.
+func (w *Worker) encodeFile(ctx context.Context, destination service.Destination, run func(*os.File) error) (int64, error) {
+	out, err := ioutil.TempFile(w.TempDir, "phono-")
+	if err != nil {
+		return 0, err
+	}
+	defer removeFile(out)
+	if err := run(out); err != nil {
+		return 0, err
+	}
+	size, err := out.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = out.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := destination.Put(ctx, out); err != nil {
+		return 0, &service.UploadError{Err: err}
+	}
+	return size, nil
+}
+
+// download copies the source into the temp file.
+func (w *Worker) download(ctx context.Context, location string) (*os.File, error) {
+	r, _, err := w.Storage.Get(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(w.TempDir, "phono-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		removeFile(f)
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		removeFile(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// jobSink returns the output format and its sink configured with job
+// options. Stream sink is returned if the output doesn't need to seek.
+func jobSink(job Job) (*fileformat.Format, userinput.Sink, userinput.StreamSink, error) {
+	format := strings.ToLower(strings.TrimPrefix(job.Format, "."))
+	if format == "" {
+		outFormat := fileformat.FormatByPath(storage.Base(job.Destination))
+		if outFormat == nil {
+			return nil, nil, nil, fmt.Errorf("output format of %s is unknown", job.Destination)
+		}
+		format = strings.TrimPrefix(outFormat.DefaultExtension(), ".")
+	}
+	switch format {
+	case "wav":
+		bitDepth := job.BitDepth
+		if bitDepth == 0 {
+			bitDepth = 24
+		}
+		sink, err := userinput.WAV.Sink(bitDepth)
+		return fileformat.WAV(), sink, nil, err
+	case "mp3":
+		channelMode, bitRateMode, bitRate := 2, "vbr", 4
+		if job.ChannelMode != nil {
+			channelMode = *job.ChannelMode
+		}
+		if job.BitRateMode != "" {
+			bitRateMode = job.BitRateMode
+		}
+		if job.BitRate != nil {
+			bitRate = *job.BitRate
+		}
+		var quality int
+		if job.Quality != nil {
+			quality = *job.Quality
+		}
+		stream, err := userinput.MP3.StreamSink(bitRateMode, bitRate, channelMode, job.Quality != nil, quality)
+		return fileformat.MP3(), nil, stream, err
+	}
+	return nil, nil, nil, fmt.Errorf("unsupported output format: %s", format)
+}
+
+func removeFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,297 @@
+package worker_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"pipelined.dev/phono/encode"
+	"pipelined.dev/phono/encode/service"
+	"pipelined.dev/phono/storage"
+	"pipelined.dev/phono/worker"
+)
+
+// fakeNATS is the NATS server of a single connection.
+type fakeNATS struct {
+	net.Listener
+	sync.Mutex
+	conn net.Conn
+	// subs maps sids to subjects
+	subs map[string]string
+}
+
+func newFakeNATS(t *testing.T) *fakeNATS {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &fakeNATS{Listener: l, subs: make(map[string]string)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		f.serve(conn)
+	}()
+	return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	defer conn.Close()
+	f.Lock()
+	f.conn = conn
+	f.Unlock()
+	r := bufio.NewReader(conn)
+	f.send("INFO {\"server_id\":\"fake\"}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			f.send("PONG\r\n")
+		case "SUB":
+			f.Lock()
+			f.subs[fields[len(fields)-1]] = fields[1]
+			f.Unlock()
+		case "UNSUB":
+			f.Lock()
+			delete(f.subs, fields[1])
+			f.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			f.Lock()
+			for sid, subject := range f.subs {
+				if subject == fields[1] {
+					fmt.Fprintf(conn, "MSG %s %s %d\r\n%s", subject, sid, size, payload)
+				}
+			}
+			f.Unlock()
+		}
+	}
+}
+
+// subscribed returns true if the client subscribed to the subject.
+func (f *fakeNATS) subscribed(subject string) bool {
+	f.Lock()
+	defer f.Unlock()
+	for _, s := range f.subs {
+		if s == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeNATS) send(s string) {
+	f.Lock()
+	defer f.Unlock()
+	io.WriteString(f.conn, s)
+}
+
+func TestWorker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-worker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	server := newFakeNATS(t)
+	defer server.Close()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFn()
+	broker, err := worker.DialNATS(ctx, "nats://token@"+server.Addr().String())
+	assert.NoError(t, err)
+	defer broker.Close()
+
+	results := make(chan service.CallbackEvent, 2)
+	_, err = broker.Subscribe("results", "", func(data []byte) {
+		var e service.CallbackEvent
+		assert.NoError(t, json.Unmarshal(data, &e))
+		results <- e
+	})
+	assert.NoError(t, err)
+
+	w := worker.Worker{
+		Broker:  broker,
+		Jobs:    "jobs",
+		Results: "results",
+		Queue:   "phono",
+		Storage: storage.Storage{},
+		Store:   worker.NewMemoryStore(),
+		TempDir: dir,
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- w.Run(runCtx) }()
+	// subscription of the worker must be processed before jobs
+	for !server.subscribed("jobs") {
+		if err := broker.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(dir, "out.mp3")
+	job, _ := json.Marshal(worker.Job{
+		ID:          "job-1",
+		Source:      "../_testdata/sample.wav",
+		Destination: out,
+	})
+	assert.NoError(t, broker.Publish("jobs", job))
+	assert.NoError(t, broker.Publish("jobs", []byte("{")))
+
+	events := make(map[string]service.CallbackEvent)
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-results:
+			events[e.ID] = e
+		case <-ctx.Done():
+			t.Fatal("result is not published")
+		}
+	}
+	stop()
+	assert.NoError(t, <-done)
+
+	e := events["job-1"]
+	assert.Equal(t, service.CallbackDone, e.Status)
+	assert.Equal(t, out, e.Location)
+	assert.NotNil(t, e.Stats)
+	fi, err := os.Stat(out)
+	assert.NoError(t, err)
+	assert.True(t, fi.Size() > 0)
+
+	r, err := w.Store.Get("job-1")
+	assert.NoError(t, err)
+	assert.Equal(t, worker.StatusDone, r.Status)
+	assert.Equal(t, 1, r.Attempts)
+	assert.Equal(t, e.Location, r.Result.Location)
+
+	invalid := events[""]
+	assert.Equal(t, service.CallbackFailed, invalid.Status)
+	assert.Contains(t, invalid.Error, "invalid job")
+}
+
+func TestProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "phono-worker")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	w := worker.Worker{Storage: storage.Storage{}, TempDir: dir}
+	bitDepth := 16
+
+	tests := []struct {
+		job    worker.Job
+		status string
+		code   encode.ErrorCode
+	}{
+		{
+			job: worker.Job{
+				Source:      "../_testdata/sample.flac",
+				Destination: filepath.Join(dir, "out.bin"),
+				Format:      "wav",
+				BitDepth:    bitDepth,
+			},
+			status: service.CallbackDone,
+		},
+		{
+			job: worker.Job{
+				Source:      "../_testdata/sample.wav",
+				Destination: filepath.Join(dir, "out.ogg"),
+			},
+			status: service.CallbackFailed,
+			code:   encode.CodeUnknown,
+		},
+		{
+			job: worker.Job{
+				Source:      "../_testdata/missing.wav",
+				Destination: filepath.Join(dir, "missing.wav"),
+			},
+			status: service.CallbackFailed,
+			code:   encode.CodeUnknown,
+		},
+	}
+	for _, test := range tests {
+		e := w.Process(context.Background(), test.job)
+		assert.Equal(t, test.status, e.Status, e.Error)
+		assert.Equal(t, test.code, e.Code)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, "RIFF", string(b[:4]))
+}
+
+// syncBroker delivers messages of the test synchronously.
+type syncBroker struct {
+	worker.Broker
+	handle chan func([]byte)
+}
+
+func (b *syncBroker) Subscribe(subject, queue string, handle func([]byte)) (func() error, error) {
+	b.handle <- handle
+	return func() error { return nil }, nil
+}
+
+func (b *syncBroker) Done() <-chan struct{} {
+	return nil
+}
+
+// blockingBackend blocks reads until release is closed.
+type blockingBackend struct {
+	storage.Backend
+	release chan struct{}
+}
+
+func (b blockingBackend) Get(ctx context.Context, u *url.URL) (io.ReadCloser, int64, error) {
+	<-b.release
+	return nil, 0, fmt.Errorf("not found")
+}
+
+func TestWorkerPending(t *testing.T) {
+	broker := &syncBroker{handle: make(chan func([]byte), 1)}
+	backend := blockingBackend{release: make(chan struct{})}
+	w := worker.Worker{
+		Broker:  broker,
+		Jobs:    "jobs",
+		Storage: storage.Storage{"mem": backend},
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+	handle := <-broker.handle
+
+	job, _ := json.Marshal(worker.Job{Source: "mem://bucket/a.wav", Destination: "b.wav"})
+	// the first job takes the only slot
+	handle(job)
+	taken := make(chan struct{})
+	go func() {
+		handle(job)
+		close(taken)
+	}()
+	select {
+	case <-taken:
+		t.Fatal("job is taken without free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(backend.release)
+	<-taken
+	stop()
+	assert.NoError(t, <-done)
+}